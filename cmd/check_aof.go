@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"goredis/internal/persistant"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkAOFFix bool
+)
+
+var checkAOFCmd = &cobra.Command{
+	Use:           "check-aof <file|manifest>",
+	Short:         "Check an AOF file (or all files in a manifest) and optionally truncate it to the last valid command",
+	Args:          cobra.ExactArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return checkAOF(args[0], checkAOFFix)
+	},
+}
+
+func init() {
+	checkAOFCmd.Flags().BoolVar(&checkAOFFix, "fix", false, "truncate the file to the last valid command")
+	rootCmd.AddCommand(checkAOFCmd)
+}
+
+// checkAOF 检查 AOF 并输出报告，fix 为 true 时丢弃第一条损坏记录及之后的内容。
+// path 以 .manifest 结尾时按顺序检查 manifest 中的所有文件
+func checkAOF(path string, fix bool) error {
+	var result *persistant.AOFCheckResult
+	var err error
+	if strings.HasSuffix(path, ".manifest") {
+		result, err = persistant.CheckAOFManifest(path)
+	} else {
+		result, err = persistant.CheckAOFFile(path)
+		if result != nil {
+			result.File = path
+		}
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(result)
+	if result.OK() {
+		return nil
+	}
+
+	if !fix {
+		return errors.New("AOF is not valid, use --fix to truncate it")
+	}
+	if !result.Fixable() {
+		return errors.New("AOF cannot be fixed by truncation")
+	}
+	if err := persistant.TruncateAOFFile(result.File, result.ValidSize); err != nil {
+		return err
+	}
+	fmt.Printf("Successfully truncated AOF to %d bytes, %d bytes discarded\n", result.ValidSize, result.Size-result.ValidSize)
+	return nil
+}
@@ -2,7 +2,15 @@ package cmd
 
 import (
 	"fmt"
+	"goredis/internal/database"
+	"goredis/internal/persistant"
 	"goredis/internal/server"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -12,17 +20,67 @@ var (
 	aofDir string
 	master string
 	dbNum  int
+
+	dbFilename string
+	save       string
+
+	aofUseRDBPreamble bool
+	appendFsync       string
+	aofLoadTruncated  bool
+
+	maxMemory       string
+	maxMemoryPolicy string
+
+	luaTimeLimit         int
+	luaReplicateCommands bool
+
+	minReplicasToWrite int
+	minReplicasMaxLag  int
+
+	clusterEnabled     bool
+	clusterConfigFile  string
+	clusterNodeTimeout int
+	clusterPort        int
 )
 
 var runCmd = &cobra.Command{
 	Use:   "run",
 	Short: "Run the Redis server",
 	RunE: func(cmd *cobra.Command, args []string) error {
+		savePoints, err := persistant.ParseSavePoints(save)
+		if err != nil {
+			return err
+		}
+		maxMemoryBytes, err := parseMemory(maxMemory)
+		if err != nil {
+			return err
+		}
+
 		cfg := server.Config{
-			Addr:       addr,
-			AOFDir:     aofDir,
-			DBNum:      dbNum,
-			MasterAddr: master,
+			Addr:        addr,
+			AOFDir:      aofDir,
+			DBNum:       dbNum,
+			MasterAddr:  master,
+			RDBFilename: dbFilename,
+			SavePoints:  savePoints,
+
+			AOFUseRDBPreamble: aofUseRDBPreamble,
+			AppendFsync:       appendFsync,
+			AOFLoadTruncated:  aofLoadTruncated,
+
+			MaxMemory:       maxMemoryBytes,
+			MaxMemoryPolicy: maxMemoryPolicy,
+
+			LuaTimeLimit:         time.Duration(luaTimeLimit) * time.Millisecond,
+			LuaReplicateCommands: luaReplicateCommands,
+
+			MinReplicasToWrite: minReplicasToWrite,
+			MinReplicasMaxLag:  time.Duration(minReplicasMaxLag) * time.Second,
+
+			ClusterEnabled:     clusterEnabled,
+			ClusterConfigFile:  clusterConfigFile,
+			ClusterNodeTimeout: time.Duration(clusterNodeTimeout) * time.Millisecond,
+			ClusterPort:        clusterPort,
 		}
 
 		srv, err := server.NewServer(cfg)
@@ -30,6 +88,15 @@ var runCmd = &cobra.Command{
 			return err
 		}
 
+		// 收到退出信号时先落盘再退出
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sigCh
+			srv.Shutdown()
+			os.Exit(0)
+		}()
+
 		fmt.Printf("goredis listening on %s\n", addr)
 		return srv.ListenAndServe()
 	},
@@ -40,6 +107,55 @@ func init() {
 	runCmd.Flags().StringVar(&aofDir, "aof-dir", "./data", "AOF persistence directory")
 	runCmd.Flags().StringVar(&master, "master", "", "master addr")
 	runCmd.Flags().IntVar(&dbNum, "db-num", 16, "number of databases")
+	runCmd.Flags().StringVar(&dbFilename, "dbfilename", "dump.rdb", "RDB snapshot file name (in the AOF directory)")
+	runCmd.Flags().StringVar(&save, "save", "3600 1 300 100 60 10000", `RDB save points "<seconds> <changes> ...", empty to disable`)
+
+	runCmd.Flags().BoolVar(&aofUseRDBPreamble, "aof-use-rdb-preamble", true, "start rewritten AOF with an RDB snapshot preamble")
+
+	runCmd.Flags().StringVar(&appendFsync, "appendfsync", persistant.FsyncEverySec, "AOF fsync policy: always, everysec or no")
+
+	runCmd.Flags().BoolVar(&aofLoadTruncated, "aof-load-truncated", true, "truncate an AOF with an incomplete tail on startup instead of refusing to start")
+
+	runCmd.Flags().StringVar(&maxMemory, "maxmemory", "0", "memory limit, e.g. 100mb or 1gb; 0 means no limit")
+	runCmd.Flags().StringVar(&maxMemoryPolicy, "maxmemory-policy", database.PolicyNoEviction,
+		"eviction policy when maxmemory is reached: noeviction, allkeys-lru, allkeys-lfu, allkeys-random, volatile-lru, volatile-lfu, volatile-random or volatile-ttl")
+
+	runCmd.Flags().IntVar(&luaTimeLimit, "lua-time-limit", 5000, "max execution time of a Lua script in milliseconds; a script that has not written is then stopped, one that has keeps running while other clients get BUSY; 0 means no limit")
+	runCmd.Flags().BoolVar(&luaReplicateCommands, "lua-replicate-commands", true, "replicate Lua scripts as the write commands they executed instead of the EVAL itself")
+
+	runCmd.Flags().IntVar(&minReplicasToWrite, "min-replicas-to-write", 0, "reject writes when fewer replicas than this are connected with a lag within min-replicas-max-lag, 0 disables the check")
+	runCmd.Flags().IntVar(&minReplicasMaxLag, "min-replicas-max-lag", 10, "max seconds since a replica's last ACK for it to count towards min-replicas-to-write")
+
+	runCmd.Flags().BoolVar(&clusterEnabled, "cluster-enabled", false, "run as a cluster node, keys are sharded across nodes by hash slot")
+	runCmd.Flags().StringVar(&clusterConfigFile, "cluster-config-file", "nodes.conf", "cluster configuration file (in the AOF directory), written by the node itself")
+	runCmd.Flags().IntVar(&clusterNodeTimeout, "cluster-node-timeout", 15000, "milliseconds a node must be unreachable to be considered failing")
+	runCmd.Flags().IntVar(&clusterPort, "cluster-port", 0, "cluster bus port, 0 means the client port + 10000")
 
 	rootCmd.AddCommand(runCmd)
 }
+
+// parseMemory 解析 Redis 风格的内存大小：k/m/g 为 1000 的倍数，kb/mb/gb 为 1024 的倍数
+func parseMemory(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		unit   int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+
+	lower := strings.ToLower(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower, unit = strings.TrimSuffix(lower, u.suffix), u.unit
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid memory size %q", s)
+	}
+	return n * unit, nil
+}
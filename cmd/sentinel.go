@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"goredis/internal/sentinel"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	sentinelAddr            string
+	sentinelAnnounceIP      string
+	sentinelMonitors        []string
+	sentinelDownAfter       int
+	sentinelFailoverTimeout int
+)
+
+var sentinelCmd = &cobra.Command{
+	Use:   "sentinel",
+	Short: "Run a sentinel that monitors masters and fails over to a replica when they go down",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(sentinelMonitors) == 0 {
+			return fmt.Errorf("at least one --monitor is required")
+		}
+		cfg := sentinel.Config{
+			Addr:       sentinelAddr,
+			AnnounceIP: sentinelAnnounceIP,
+		}
+		for _, spec := range sentinelMonitors {
+			mc, err := parseMonitor(spec)
+			if err != nil {
+				return err
+			}
+			mc.DownAfter = time.Duration(sentinelDownAfter) * time.Millisecond
+			mc.FailoverTimeout = time.Duration(sentinelFailoverTimeout) * time.Millisecond
+			cfg.Masters = append(cfg.Masters, mc)
+		}
+
+		s, err := sentinel.New(cfg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("goredis sentinel listening on %s\n", sentinelAddr)
+		return s.ListenAndServe()
+	},
+}
+
+func init() {
+	sentinelCmd.Flags().StringVar(&sentinelAddr, "addr", ":26379", "sentinel listen address")
+	sentinelCmd.Flags().StringVar(&sentinelAnnounceIP, "announce-ip", "", "IP announced to other sentinels, defaults to the local IP used to reach the monitored instances")
+	sentinelCmd.Flags().StringArrayVar(&sentinelMonitors, "monitor", nil, `master to monitor as "<name> <ip> <port> <quorum>", repeatable`)
+	sentinelCmd.Flags().IntVar(&sentinelDownAfter, "down-after-milliseconds", 30000, "milliseconds without a valid PING reply before an instance is considered down")
+	sentinelCmd.Flags().IntVar(&sentinelFailoverTimeout, "failover-timeout", 180000, "failover timeout in milliseconds; a failed failover is retried after twice this time")
+
+	rootCmd.AddCommand(sentinelCmd)
+}
+
+// parseMonitor 解析 "<name> <ip> <port> <quorum>"，与 Redis 的 sentinel monitor 配置一致
+func parseMonitor(spec string) (sentinel.MasterConfig, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 4 {
+		return sentinel.MasterConfig{}, fmt.Errorf("invalid monitor %q, expected \"<name> <ip> <port> <quorum>\"", spec)
+	}
+	if p, err := strconv.Atoi(fields[2]); err != nil || p <= 0 || p > 65535 {
+		return sentinel.MasterConfig{}, fmt.Errorf("invalid port in monitor %q", spec)
+	}
+	quorum, err := strconv.Atoi(fields[3])
+	if err != nil || quorum <= 0 {
+		return sentinel.MasterConfig{}, fmt.Errorf("invalid quorum in monitor %q", spec)
+	}
+	return sentinel.MasterConfig{
+		Name:   fields[0],
+		Addr:   net.JoinHostPort(fields[1], fields[2]),
+		Quorum: quorum,
+	}, nil
+}
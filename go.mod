@@ -7,6 +7,7 @@ toolchain go1.24.5
 require (
 	github.com/spf13/cobra v1.10.2
 	github.com/stretchr/testify v1.11.1
+	github.com/yuin/gopher-lua v1.1.1
 	golang.org/x/exp v0.0.0-20251219203646-944ab1f22d93
 )
 
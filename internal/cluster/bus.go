@@ -0,0 +1,148 @@
+package cluster
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// busTimeout 总线上一次请求的超时，超时后断开连接，下次请求时重连
+const busTimeout = time.Second
+
+// message 集群总线上的消息，每条编码为一行 JSON，每条请求都以 pong 回复。
+// 节点之间定期交换 ping/pong，带上发送方负责的槽、epoch 以及它知道的其他节点 (gossip)
+type message struct {
+	Type         string `json:"type"` // ping、meet、pong 或 fail
+	Sender       string `json:"sender"`
+	Port         int    `json:"port"`
+	BusPort      int    `json:"bus_port"`
+	CurrentEpoch int64  `json:"current_epoch"`
+	ConfigEpoch  int64  `json:"config_epoch"`
+	// 发送方负责的槽，位图
+	Slots  []byte        `json:"slots"`
+	Gossip []gossipEntry `json:"gossip,omitempty"`
+	// fail 消息中被判定为 fail 的节点
+	Failed string `json:"failed,omitempty"`
+}
+
+// gossipEntry 发送方眼中的另一个节点
+type gossipEntry struct {
+	ID      string `json:"id"`
+	IP      string `json:"ip"`
+	Port    int    `json:"port"`
+	BusPort int    `json:"bus_port"`
+	PFail   bool   `json:"pfail,omitempty"`
+}
+
+func hasSlot(bitmap []byte, slot int) bool {
+	return slot/8 < len(bitmap) && bitmap[slot/8]&(1<<(slot%8)) != 0
+}
+
+func setSlot(bitmap []byte, slot int) {
+	bitmap[slot/8] |= 1 << (slot % 8)
+}
+
+// busLink 到另一个节点总线端口的连接，请求之间互斥，断开后下次请求时重连
+type busLink struct {
+	addr string
+
+	mu   sync.Mutex
+	conn net.Conn
+	enc  *json.Encoder
+	dec  *json.Decoder
+	// 在 Cluster.mu 下读取，不能等待 mu
+	isConnected atomic.Bool
+}
+
+func newBusLink(addr string) *busLink {
+	return &busLink{addr: addr}
+}
+
+func (l *busLink) connected() bool {
+	return l.isConnected.Load()
+}
+
+func (l *busLink) connectLocked() error {
+	if l.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", l.addr, busTimeout)
+	if err != nil {
+		return err
+	}
+	l.conn, l.enc, l.dec = conn, json.NewEncoder(conn), json.NewDecoder(bufio.NewReader(conn))
+	l.isConnected.Store(true)
+	return nil
+}
+
+func (l *busLink) closeLocked() {
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn, l.enc, l.dec = nil, nil, nil
+		l.isConnected.Store(false)
+	}
+}
+
+func (l *busLink) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closeLocked()
+}
+
+// call 发送一条消息并读取回复
+func (l *busLink) call(msg *message) (*message, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.connectLocked(); err != nil {
+		return nil, err
+	}
+	l.conn.SetDeadline(time.Now().Add(busTimeout))
+	if err := l.enc.Encode(msg); err != nil {
+		l.closeLocked()
+		return nil, err
+	}
+	reply := &message{}
+	if err := l.dec.Decode(reply); err != nil {
+		l.closeLocked()
+		return nil, err
+	}
+	return reply, nil
+}
+
+// serveBus 处理其他节点连到总线端口的连接：每收到一条消息处理后回复 pong
+func (c *Cluster) serveBus(conn net.Conn) {
+	defer conn.Close()
+	remoteIP, localIP := connIP(conn.RemoteAddr()), connIP(conn.LocalAddr())
+	enc, dec := json.NewEncoder(conn), json.NewDecoder(bufio.NewReader(conn))
+	for {
+		msg := &message{}
+		if err := dec.Decode(msg); err != nil {
+			return
+		}
+		// 关闭后不再回复，其他节点才能发现自己下线
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+		c.mu.Lock()
+		reply := c.handleMessage(msg, remoteIP, localIP, time.Now())
+		c.mu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(busTimeout))
+		if err := enc.Encode(reply); err != nil {
+			return
+		}
+	}
+}
+
+func connIP(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	host, _, _ := net.SplitHostPort(addr.String())
+	return host
+}
@@ -0,0 +1,489 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 定时任务的周期
+var (
+	tickPeriod = 100 * time.Millisecond
+	pingPeriod = time.Second // 向每个节点发送 PING
+)
+
+// busPortOffset 没有指定总线端口时，总线端口为客户端端口加上这个偏移，与 Redis 一致
+const busPortOffset = 10000
+
+type Config struct {
+	Addr       string // 客户端的监听地址
+	BusPort    int    // 集群总线端口，0 表示客户端端口 + 10000
+	ConfigFile string // 保存集群配置的文件，启动时从中恢复节点 ID、其他节点和槽的分配
+	// 超过 NodeTimeout 没有回复 PING 的节点标记为 pfail
+	NodeTimeout time.Duration
+}
+
+// KeySpace 集群命令需要访问的数据，由 database.MultiDB 实现
+type KeySpace interface {
+	CountKeysInSlot(slot int) int
+	GetKeysInSlot(slot int, count int) []string
+}
+
+// Cluster 当前节点眼中的集群：已知的节点、每个槽由哪个节点负责以及正在迁移的槽。
+// 节点之间通过集群总线交换 PING/PONG 传播这些信息，所有状态由 mu 保护，网络调用在独立的 goroutine 中进行
+type Cluster struct {
+	cfg  Config
+	keys KeySpace
+
+	mu           sync.Mutex
+	myself       *node
+	nodes        map[string]*node // 按 id，包括自己和握手中的节点
+	slots        [SlotCount]*node
+	migrating    map[int]*node // 正在迁出的槽 -> 目标节点
+	importing    map[int]*node // 正在迁入的槽 -> 源节点
+	currentEpoch int64
+	stateOK      bool
+	dirty        bool // 配置有变化，下一次 tick 时保存
+
+	ln        net.Listener
+	done      chan struct{}
+	closeOnce sync.Once
+	timerDone sync.WaitGroup
+}
+
+// New 从配置文件恢复集群状态，文件不存在时以新的节点 ID 创建
+func New(cfg Config, keys KeySpace) (*Cluster, error) {
+	host, portStr, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %w", cfg.Addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q", cfg.Addr)
+	}
+	if cfg.BusPort == 0 {
+		cfg.BusPort = port + busPortOffset
+	}
+	if cfg.NodeTimeout <= 0 {
+		return nil, errors.New("cluster node timeout must be positive")
+	}
+	// 监听所有地址时自己的 IP 在其他节点 MEET 自己时得知
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = ""
+	}
+
+	c := &Cluster{
+		cfg:       cfg,
+		keys:      keys,
+		nodes:     make(map[string]*node),
+		migrating: make(map[int]*node),
+		importing: make(map[int]*node),
+		done:      make(chan struct{}),
+	}
+	if err := c.loadConfig(); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("load cluster config: %w", err)
+		}
+		c.myself = newNode(genNodeID(), host, port, cfg.BusPort)
+		c.myself.myself = true
+		c.nodes[c.myself.id] = c.myself
+		log.Printf("[cluster] no cluster configuration found, my id is %s", c.myself.id)
+	} else {
+		log.Printf("[cluster] node configuration loaded, my id is %s", c.myself.id)
+	}
+	// 端口以当前配置为准
+	c.myself.port, c.myself.busPort = port, cfg.BusPort
+	if host != "" {
+		c.myself.ip = host
+	}
+	c.updateState()
+	if err := c.saveConfig(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Start 开始监听集群总线并定期与其他节点交换信息
+func (c *Cluster) Start() error {
+	ln, err := net.Listen("tcp", ":"+strconv.Itoa(c.cfg.BusPort))
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.ln = ln
+	c.mu.Unlock()
+
+	c.timerDone.Add(1)
+	go c.timer()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				select {
+				case <-c.done:
+					return
+				default:
+				}
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					continue
+				}
+				log.Printf("[cluster] bus accept failed: %v", err)
+				return
+			}
+			go c.serveBus(conn)
+		}
+	}()
+	return nil
+}
+
+// Close 停止定时任务，关闭总线的监听和所有连接
+func (c *Cluster) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.mu.Lock()
+		if c.ln != nil {
+			c.ln.Close()
+		}
+		for _, n := range c.nodes {
+			go n.link.close()
+		}
+		c.mu.Unlock()
+		c.timerDone.Wait()
+	})
+}
+
+func (c *Cluster) timer() {
+	defer c.timerDone.Done()
+	ticker := time.NewTicker(tickPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.tick()
+		}
+	}
+}
+
+// tick 定时任务：PING 其他节点，检测下线的节点并更新集群状态
+func (c *Cluster) tick() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	handshakeTimeout := max(c.cfg.NodeTimeout, time.Second)
+	for _, n := range c.nodes {
+		if n.myself {
+			continue
+		}
+		if n.handshake && now.Sub(n.handshakeStart) > handshakeTimeout {
+			log.Printf("[cluster] handshake with %s timed out", n.busAddr())
+			c.removeNode(n)
+			continue
+		}
+		if !n.pending && now.Sub(n.lastPing) >= pingPeriod {
+			n.pending, n.lastPing = true, now
+			if n.pingSent.IsZero() {
+				n.pingSent = now
+			}
+			msgType := "ping"
+			if n.handshake {
+				msgType = "meet"
+			}
+			go c.ping(n, c.makeMessage(msgType))
+		}
+		if !n.handshake && !n.pfail && !n.pingSent.IsZero() && now.Sub(n.pingSent) > c.cfg.NodeTimeout {
+			n.pfail = true
+			log.Printf("[cluster] *** node %s possibly failing", n.id)
+		}
+	}
+	for _, n := range c.nodes {
+		if n.pfail && !n.fail {
+			c.checkFail(n, now)
+		}
+	}
+	c.updateState()
+	if c.dirty {
+		if err := c.saveConfig(); err != nil {
+			log.Printf("[cluster] %v", err)
+		}
+	}
+}
+
+func (c *Cluster) ping(n *node, msg *message) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	reply, err := n.link.call(msg)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n.pending = false
+	// 等待期间节点可能已被删除
+	if err != nil || c.nodes[n.id] != n || reply.Type != "pong" {
+		return
+	}
+	c.handlePong(n, reply, time.Now())
+}
+
+// handlePong 收到自己发出的 PING/MEET 的回复
+func (c *Cluster) handlePong(n *node, reply *message, now time.Time) {
+	if n.handshake {
+		if _, ok := c.nodes[reply.Sender]; ok || reply.Sender == "" {
+			// 已经通过其他途径认识了这个节点
+			c.removeNode(n)
+			return
+		}
+		delete(c.nodes, n.id)
+		n.id, n.handshake = reply.Sender, false
+		c.nodes[n.id] = n
+		c.dirty = true
+		log.Printf("[cluster] handshake with %s completed, node id %s", n.busAddr(), n.id)
+	} else if reply.Sender != n.id {
+		// 这个地址上已经是另一个节点了
+		return
+	}
+
+	n.pingSent, n.pongRecv = time.Time{}, now
+	if n.fail {
+		log.Printf("[cluster] clear FAIL state for node %s: is reachable again", n.id)
+		c.dirty = true
+	}
+	n.pfail, n.fail = false, false
+	c.processMessage(n, reply, now)
+}
+
+// handleMessage 处理其他节点发来的消息，返回 pong
+func (c *Cluster) handleMessage(msg *message, remoteIP, localIP string, now time.Time) *message {
+	// 自己的 IP 从其他节点 MEET 自己的连接得知
+	if msg.Type == "meet" && c.myself.ip == "" && localIP != "" {
+		c.myself.ip = localIP
+		c.dirty = true
+		log.Printf("[cluster] IP address for this node updated to %s", localIP)
+	}
+
+	sender, known := c.nodes[msg.Sender]
+	switch {
+	case !known && msg.Type == "meet":
+		// 反过来与对方握手，握手成功后双方互相认识
+		c.startHandshake(remoteIP, msg.Port, msg.BusPort, now)
+	case known && !sender.myself:
+		if sender.ip != remoteIP || sender.port != msg.Port || sender.busPort != msg.BusPort {
+			log.Printf("[cluster] address updated for node %s, now %s:%d", sender.id, remoteIP, msg.Port)
+			c.setNodeAddr(sender, remoteIP, msg.Port, msg.BusPort)
+		}
+		c.processMessage(sender, msg, now)
+		if msg.Type == "fail" {
+			c.markFailed(msg.Failed, sender)
+		}
+	}
+	return c.makeMessage("pong")
+}
+
+// processMessage 按已知节点的 PING/PONG 更新 epoch、槽的归属和其他节点的状态
+func (c *Cluster) processMessage(sender *node, msg *message, now time.Time) {
+	if msg.CurrentEpoch > c.currentEpoch {
+		c.currentEpoch = msg.CurrentEpoch
+		c.dirty = true
+	}
+	if msg.ConfigEpoch != sender.configEpoch {
+		sender.configEpoch = msg.ConfigEpoch
+		c.dirty = true
+	}
+	c.updateSlots(sender, msg.Slots)
+	c.handleConfigEpochCollision(sender)
+
+	for _, g := range msg.Gossip {
+		if g.ID == c.myself.id {
+			continue
+		}
+		n, ok := c.nodes[g.ID]
+		if !ok {
+			if g.IP != "" && g.Port > 0 {
+				c.startHandshake(g.IP, g.Port, g.BusPort, now)
+			}
+			continue
+		}
+		if g.PFail {
+			n.failReports[sender.id] = now
+		} else {
+			delete(n.failReports, sender.id)
+		}
+	}
+}
+
+// updateSlots sender 声明负责的槽，当前负责的节点 configEpoch 更小时改为 sender。
+// 正在迁入的槽由 CLUSTER SETSLOT NODE 决定归属
+func (c *Cluster) updateSlots(sender *node, bitmap []byte) {
+	for slot := 0; slot < SlotCount; slot++ {
+		owner := c.slots[slot]
+		if owner == sender || !hasSlot(bitmap, slot) {
+			continue
+		}
+		if _, ok := c.importing[slot]; ok {
+			continue
+		}
+		if owner != nil && owner.configEpoch >= sender.configEpoch {
+			continue
+		}
+		if owner == c.myself {
+			log.Printf("[cluster] slot %d is now served by %s with a newer config epoch", slot, sender.id)
+			delete(c.migrating, slot)
+		}
+		c.slots[slot] = sender
+		c.dirty = true
+	}
+}
+
+// handleConfigEpochCollision 两个 master 的 configEpoch 相同时，ID 较小的一方换一个新的 configEpoch，
+// 保证最终每个 master 的 configEpoch 都不同
+func (c *Cluster) handleConfigEpochCollision(sender *node) {
+	if sender.configEpoch != c.myself.configEpoch || sender.id <= c.myself.id {
+		return
+	}
+	c.currentEpoch++
+	c.myself.configEpoch = c.currentEpoch
+	c.dirty = true
+	log.Printf("[cluster] WARNING: configEpoch collision with node %s, configEpoch set to %d", sender.id, c.currentEpoch)
+}
+
+// startHandshake 开始与 ip:port 上的节点握手，之后的 tick 向它发送 MEET。已经在握手中时什么也不做
+func (c *Cluster) startHandshake(ip string, port, busPort int, now time.Time) {
+	for _, n := range c.nodes {
+		if n.handshake && n.ip == ip && n.port == port && n.busPort == busPort {
+			return
+		}
+	}
+	n := newNode(genNodeID(), ip, port, busPort)
+	n.handshake, n.handshakeStart = true, now
+	c.nodes[n.id] = n
+}
+
+func (c *Cluster) setNodeAddr(n *node, ip string, port, busPort int) {
+	n.ip, n.port, n.busPort = ip, port, busPort
+	old := n.link
+	n.link = newBusLink(n.busAddr())
+	go old.close()
+	c.dirty = true
+}
+
+func (c *Cluster) removeNode(n *node) {
+	delete(c.nodes, n.id)
+	for _, other := range c.nodes {
+		delete(other.failReports, n.id)
+	}
+	// 关闭可能要等待进行中的请求，不能持有 mu
+	go n.link.close()
+}
+
+// checkFail 包括自己在内，多数持有槽的 master 都报告 n 为 pfail 时标记为 fail，并通知所有节点
+func (c *Cluster) checkFail(n *node, now time.Time) {
+	owners := c.slotOwners()
+	quorum := len(owners)/2 + 1
+	votes := 1 // 自己
+	for reporter, t := range n.failReports {
+		if now.Sub(t) > 2*c.cfg.NodeTimeout {
+			delete(n.failReports, reporter)
+			continue
+		}
+		if r, ok := c.nodes[reporter]; ok && owners[r] {
+			votes++
+		}
+	}
+	if votes < quorum {
+		return
+	}
+	n.fail = true
+	c.dirty = true
+	log.Printf("[cluster] marking node %s as failing (quorum reached)", n.id)
+
+	msg := c.makeMessage("fail")
+	msg.Failed = n.id
+	for _, other := range c.nodes {
+		if other.myself || other.handshake || other == n {
+			continue
+		}
+		go other.link.call(msg)
+	}
+}
+
+// markFailed 其他节点通知 id 已经被判定为 fail
+func (c *Cluster) markFailed(id string, sender *node) {
+	n, ok := c.nodes[id]
+	if !ok || n.myself || n.fail {
+		return
+	}
+	n.fail = true
+	c.dirty = true
+	log.Printf("[cluster] FAIL message received from %s about %s", sender.id, id)
+}
+
+// slotOwners 负责至少一个槽的节点
+func (c *Cluster) slotOwners() map[*node]bool {
+	owners := make(map[*node]bool)
+	for _, owner := range c.slots {
+		if owner != nil {
+			owners[owner] = true
+		}
+	}
+	return owners
+}
+
+// updateState 所有槽都有节点负责并且这些节点都没有 fail 时集群可用
+func (c *Cluster) updateState() {
+	ok := true
+	for _, owner := range c.slots {
+		if owner == nil || owner.fail {
+			ok = false
+			break
+		}
+	}
+	if ok == c.stateOK {
+		return
+	}
+	c.stateOK = ok
+	if ok {
+		log.Printf("[cluster] cluster state changed: ok")
+	} else {
+		log.Printf("[cluster] cluster state changed: fail")
+	}
+}
+
+func (c *Cluster) makeMessage(msgType string) *message {
+	msg := &message{
+		Type:         msgType,
+		Sender:       c.myself.id,
+		Port:         c.myself.port,
+		BusPort:      c.myself.busPort,
+		CurrentEpoch: c.currentEpoch,
+		ConfigEpoch:  c.myself.configEpoch,
+		Slots:        make([]byte, SlotCount/8),
+	}
+	for slot, owner := range c.slots {
+		if owner == c.myself {
+			setSlot(msg.Slots, slot)
+		}
+	}
+	for _, n := range c.nodes {
+		if n.myself || n.handshake {
+			continue
+		}
+		msg.Gossip = append(msg.Gossip, gossipEntry{
+			ID:      n.id,
+			IP:      n.ip,
+			Port:    n.port,
+			BusPort: n.busPort,
+			PFail:   n.pfail || n.fail,
+		})
+	}
+	return msg
+}
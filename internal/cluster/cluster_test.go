@@ -0,0 +1,125 @@
+package cluster
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// freePort 返回一个当前可用的端口
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func startTestNode(t *testing.T) *Cluster {
+	t.Helper()
+	port := freePort(t)
+	c, err := New(Config{
+		Addr:        "127.0.0.1:" + strconv.Itoa(port),
+		BusPort:     freePort(t),
+		ConfigFile:  t.TempDir() + "/nodes.conf",
+		NodeTimeout: 300 * time.Millisecond,
+	}, fakeKeySpace{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// knownNodes 已经握手成功的节点数，包括自己
+func (c *Cluster) knownNodes() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	known := 0
+	for _, n := range c.nodes {
+		if !n.handshake {
+			known++
+		}
+	}
+	return known
+}
+
+func (c *Cluster) isStateOK() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stateOK
+}
+
+func (c *Cluster) slotOwnerID(slot int) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if owner := c.slots[slot]; owner != nil {
+		return owner.id
+	}
+	return ""
+}
+
+func TestGossip(t *testing.T) {
+	oldTick, oldPing := tickPeriod, pingPeriod
+	tickPeriod, pingPeriod = 10*time.Millisecond, 50*time.Millisecond
+	t.Cleanup(func() { tickPeriod, pingPeriod = oldTick, oldPing })
+
+	a, b, c := startTestNode(t), startTestNode(t), startTestNode(t)
+	addSlots(t, a, 0, 5460)
+	addSlots(t, b, 5461, 10922)
+	addSlots(t, c, 10923, SlotCount-1)
+
+	// 只需要 A 认识 B 和 C，B 和 C 通过 gossip 互相认识
+	for _, other := range []*Cluster{b, c} {
+		assertOK(t, execCluster(a, "meet", "127.0.0.1", strconv.Itoa(other.myself.port), strconv.Itoa(other.cfg.BusPort)))
+	}
+	for _, node := range []*Cluster{a, b, c} {
+		waitFor(t, "all nodes to meet", func() bool { return node.knownNodes() == 3 })
+		waitFor(t, "cluster state ok", node.isStateOK)
+	}
+	if b.slotOwnerID(0) != a.myself.id || c.slotOwnerID(5461) != b.myself.id || a.slotOwnerID(16383) != c.myself.id {
+		t.Fatal("slot assignment not propagated")
+	}
+
+	t.Run("slot moves with a newer config epoch", func(t *testing.T) {
+		slot := strconv.Itoa(100)
+		assertOK(t, execCluster(b, "setslot", slot, "importing", a.myself.id))
+		assertOK(t, execCluster(a, "setslot", slot, "migrating", b.myself.id))
+		assertOK(t, execCluster(b, "setslot", slot, "node", b.myself.id))
+		assertOK(t, execCluster(a, "setslot", slot, "node", b.myself.id))
+		waitFor(t, "C to learn the new owner", func() bool { return c.slotOwnerID(100) == b.myself.id })
+		if a.slotOwnerID(99) != a.myself.id || c.slotOwnerID(99) != a.myself.id {
+			t.Error("other slots of A should not move")
+		}
+	})
+
+	t.Run("failure detection", func(t *testing.T) {
+		c.Close()
+		for _, node := range []*Cluster{a, b} {
+			waitFor(t, "failed node to be detected", func() bool { return !node.isStateOK() })
+			node.mu.Lock()
+			failed := node.nodes[c.myself.id].fail
+			node.mu.Unlock()
+			if !failed {
+				t.Error("closed node should be marked as failed")
+			}
+		}
+	})
+}
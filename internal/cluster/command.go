@@ -0,0 +1,428 @@
+package cluster
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"goredis/internal/resp"
+)
+
+// cluster 子命令及参数个数（不含子命令本身），负数表示至少
+var clusterCmdArity = map[string]int{
+	"info":            0,  // cluster info
+	"myid":            0,  // cluster myid
+	"meet":            -2, // cluster meet ip port [cluster-bus-port]
+	"nodes":           0,  // cluster nodes
+	"slots":           0,  // cluster slots
+	"shards":          0,  // cluster shards
+	"addslots":        -1, // cluster addslots slot [slot ...]
+	"delslots":        -1, // cluster delslots slot [slot ...]
+	"setslot":         -2, // cluster setslot slot IMPORTING node-id|MIGRATING node-id|NODE node-id|STABLE
+	"keyslot":         1,  // cluster keyslot key
+	"getkeysinslot":   2,  // cluster getkeysinslot slot count
+	"countkeysinslot": 1,  // cluster countkeysinslot slot
+}
+
+// Exec 执行 CLUSTER 命令，cmdLine 包括 CLUSTER 本身
+func (c *Cluster) Exec(cmdLine [][]byte) resp.Reply {
+	if len(cmdLine) < 2 {
+		return resp.MakeArgNumErrReply("cluster")
+	}
+	sub := strings.ToLower(string(cmdLine[1]))
+	args := cmdLine[2:]
+	arity, ok := clusterCmdArity[sub]
+	if !ok {
+		return resp.MakeErrReply("ERR Unknown subcommand or wrong number of arguments for '" + sub + "'. Try CLUSTER HELP.")
+	}
+	if (arity >= 0 && len(args) != arity) || (arity < 0 && len(args) < -arity) {
+		return resp.MakeErrReply("ERR wrong number of arguments for 'cluster|" + sub + "' command")
+	}
+
+	switch sub {
+	case "keyslot":
+		return resp.MakeIntReply(int64(KeySlot(string(args[0]))))
+	case "countkeysinslot":
+		slot, ok := parseSlot(string(args[0]))
+		if !ok {
+			return resp.MakeErrReply("ERR Invalid slot")
+		}
+		return resp.MakeIntReply(int64(c.keys.CountKeysInSlot(slot)))
+	case "getkeysinslot":
+		slot, ok := parseSlot(string(args[0]))
+		if !ok {
+			return resp.MakeErrReply("ERR Invalid slot")
+		}
+		count, err := strconv.Atoi(string(args[1]))
+		if err != nil || count < 0 {
+			return resp.MakeErrReply("ERR Invalid number of keys")
+		}
+		keys := c.keys.GetKeysInSlot(slot, count)
+		result := make([][]byte, len(keys))
+		for i, key := range keys {
+			result[i] = []byte(key)
+		}
+		return resp.MakeMultiBulkReply(result)
+	case "setslot":
+		return c.execSetSlot(args)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch sub {
+	case "info":
+		return resp.MakeBulkReply([]byte(c.info()))
+	case "myid":
+		return resp.MakeBulkReply([]byte(c.myself.id))
+	case "meet":
+		return c.execMeet(args)
+	case "nodes":
+		return resp.MakeBulkReply([]byte(c.nodesDescription(true)))
+	case "slots":
+		return c.execSlots()
+	case "shards":
+		return c.execShards()
+	case "addslots":
+		return c.execAddSlots(args)
+	default: // delslots
+		return c.execDelSlots(args)
+	}
+}
+
+// Route 判断涉及 keys 的命令能否在当前节点执行，可以时返回 nil，否则返回重定向或错误：
+//   - key 不在同一个槽时返回 CROSSSLOT
+//   - 槽由其他节点负责时返回 MOVED；正在迁入并且客户端发送了 ASKING 时在本地执行
+//   - 槽正在迁出并且 key 都已经不在本地时返回 ASK，只有一部分在本地时返回 TRYAGAIN
+//
+// exists 判断 key 在本地是否存在，为 nil 时不检查（MIGRATE 需要在迁移中的槽上执行）
+func (c *Cluster) Route(keys []string, asking bool, exists func(key string) bool) resp.Reply {
+	if len(keys) == 0 {
+		return nil
+	}
+	slot := KeySlot(keys[0])
+	for _, key := range keys[1:] {
+		if KeySlot(key) != slot {
+			return resp.MakeErrReply("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+	}
+
+	c.mu.Lock()
+	stateOK, owner := c.stateOK, c.slots[slot]
+	mine := owner == c.myself
+	var ownerAddr, migratingAddr string
+	if owner != nil {
+		ownerAddr = owner.addr()
+	}
+	if target, ok := c.migrating[slot]; ok {
+		migratingAddr = target.addr()
+	}
+	_, importing := c.importing[slot]
+	c.mu.Unlock()
+
+	if !stateOK {
+		return resp.MakeErrReply("CLUSTERDOWN The cluster is down")
+	}
+	if owner == nil {
+		return resp.MakeErrReply("CLUSTERDOWN Hash slot not served")
+	}
+
+	// 统计本地存在和不存在的 key
+	existing, missing := 0, 0
+	if exists != nil && ((!mine && importing && asking) || (mine && migratingAddr != "")) {
+		for _, key := range keys {
+			if exists(key) {
+				existing++
+			} else {
+				missing++
+			}
+		}
+	}
+
+	if !mine {
+		if importing && asking {
+			if len(keys) > 1 && missing > 0 {
+				return resp.MakeErrReply("TRYAGAIN Multiple keys request during rehashing of slot")
+			}
+			return nil
+		}
+		return resp.MakeErrReply(fmt.Sprintf("MOVED %d %s", slot, ownerAddr))
+	}
+	if missing > 0 {
+		if existing > 0 {
+			return resp.MakeErrReply("TRYAGAIN Multiple keys request during rehashing of slot")
+		}
+		return resp.MakeErrReply(fmt.Sprintf("ASK %d %s", slot, migratingAddr))
+	}
+	return nil
+}
+
+func (c *Cluster) info() string {
+	assigned, pfail, fail := 0, 0, 0
+	for _, owner := range c.slots {
+		if owner == nil {
+			continue
+		}
+		assigned++
+		if owner.fail {
+			fail++
+		} else if owner.pfail {
+			pfail++
+		}
+	}
+	state := "fail"
+	if c.stateOK {
+		state = "ok"
+	}
+
+	var b strings.Builder
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format+"\r\n", args...)
+	}
+	line("cluster_enabled:1")
+	line("cluster_state:%s", state)
+	line("cluster_slots_assigned:%d", assigned)
+	line("cluster_slots_ok:%d", assigned-pfail-fail)
+	line("cluster_slots_pfail:%d", pfail)
+	line("cluster_slots_fail:%d", fail)
+	line("cluster_known_nodes:%d", len(c.nodes))
+	line("cluster_size:%d", len(c.slotOwners()))
+	line("cluster_current_epoch:%d", c.currentEpoch)
+	line("cluster_my_epoch:%d", c.myself.configEpoch)
+	return b.String()
+}
+
+// CLUSTER MEET ip port [cluster-bus-port]，握手在后台进行
+func (c *Cluster) execMeet(args [][]byte) resp.Reply {
+	if len(args) > 3 {
+		return resp.MakeErrReply("ERR wrong number of arguments for 'cluster|meet' command")
+	}
+	ip, portStr := string(args[0]), string(args[1])
+	port, err := strconv.Atoi(portStr)
+	busPort := port + busPortOffset
+	if err == nil && len(args) == 3 {
+		busPort, err = strconv.Atoi(string(args[2]))
+	}
+	if err != nil || net.ParseIP(ip) == nil || port <= 0 || port > 65535 || busPort <= 0 || busPort > 65535 {
+		return resp.MakeErrReply("ERR Invalid node address specified: " + ip + ":" + portStr)
+	}
+	log.Printf("[cluster] meet %s:%d", ip, port)
+	c.startHandshake(ip, port, busPort, time.Now())
+	return resp.MakeOkReply()
+}
+
+// CLUSTER SLOTS 每一段连续的槽及负责的节点：[start, end, [ip, port, id]]
+func (c *Cluster) execSlots() resp.Reply {
+	var replies []resp.Reply
+	for start := 0; start < SlotCount; {
+		owner := c.slots[start]
+		end := start
+		for end+1 < SlotCount && c.slots[end+1] == owner {
+			end++
+		}
+		if owner != nil {
+			replies = append(replies, resp.MakeMultiRawReply([]resp.Reply{
+				resp.MakeIntReply(int64(start)),
+				resp.MakeIntReply(int64(end)),
+				resp.MakeMultiRawReply([]resp.Reply{
+					resp.MakeBulkReply([]byte(owner.ip)),
+					resp.MakeIntReply(int64(owner.port)),
+					resp.MakeBulkReply([]byte(owner.id)),
+				}),
+			}))
+		}
+		start = end + 1
+	}
+	return resp.MakeMultiRawReply(replies)
+}
+
+// CLUSTER SHARDS 每个分片负责的槽和其中的节点，目前每个分片只有一个 master
+func (c *Cluster) execShards() resp.Reply {
+	ids := make([]string, 0, len(c.nodes))
+	for id, n := range c.nodes {
+		if !n.handshake {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	replies := make([]resp.Reply, 0, len(ids))
+	for _, id := range ids {
+		n := c.nodes[id]
+		var slots []resp.Reply
+		for _, r := range toRanges(c.slotsOf(n)) {
+			slots = append(slots, resp.MakeIntReply(int64(r.start)), resp.MakeIntReply(int64(r.end)))
+		}
+		health := "online"
+		if n.fail || n.pfail {
+			health = "failed"
+		}
+		nodeInfo := resp.MakeMultiRawReply([]resp.Reply{
+			resp.MakeBulkReply([]byte("id")), resp.MakeBulkReply([]byte(n.id)),
+			resp.MakeBulkReply([]byte("port")), resp.MakeIntReply(int64(n.port)),
+			resp.MakeBulkReply([]byte("ip")), resp.MakeBulkReply([]byte(n.ip)),
+			resp.MakeBulkReply([]byte("endpoint")), resp.MakeBulkReply([]byte(n.ip)),
+			resp.MakeBulkReply([]byte("role")), resp.MakeBulkReply([]byte("master")),
+			resp.MakeBulkReply([]byte("replication-offset")), resp.MakeIntReply(0),
+			resp.MakeBulkReply([]byte("health")), resp.MakeBulkReply([]byte(health)),
+		})
+		replies = append(replies, resp.MakeMultiRawReply([]resp.Reply{
+			resp.MakeBulkReply([]byte("slots")), resp.MakeMultiRawReply(slots),
+			resp.MakeBulkReply([]byte("nodes")), resp.MakeMultiRawReply([]resp.Reply{nodeInfo}),
+		}))
+	}
+	return resp.MakeMultiRawReply(replies)
+}
+
+// parseSlots 解析 ADDSLOTS/DELSLOTS 的槽，重复时报错
+func parseSlots(args [][]byte) ([]int, resp.Reply) {
+	slots := make([]int, 0, len(args))
+	seen := make(map[int]bool, len(args))
+	for _, arg := range args {
+		slot, ok := parseSlot(string(arg))
+		if !ok {
+			return nil, resp.MakeErrReply("ERR Invalid or out of range slot")
+		}
+		if seen[slot] {
+			return nil, resp.MakeErrReply(fmt.Sprintf("ERR Slot %d specified multiple times", slot))
+		}
+		seen[slot] = true
+		slots = append(slots, slot)
+	}
+	return slots, nil
+}
+
+// CLUSTER ADDSLOTS slot [slot ...] 由自己负责这些槽，任何一个已经有节点负责时都不修改
+func (c *Cluster) execAddSlots(args [][]byte) resp.Reply {
+	slots, errReply := parseSlots(args)
+	if errReply != nil {
+		return errReply
+	}
+	for _, slot := range slots {
+		if c.slots[slot] != nil {
+			return resp.MakeErrReply(fmt.Sprintf("ERR Slot %d is already busy", slot))
+		}
+	}
+	for _, slot := range slots {
+		c.slots[slot] = c.myself
+		delete(c.importing, slot)
+	}
+	return c.configChanged()
+}
+
+// CLUSTER DELSLOTS slot [slot ...] 在自己的配置中取消这些槽的分配
+func (c *Cluster) execDelSlots(args [][]byte) resp.Reply {
+	slots, errReply := parseSlots(args)
+	if errReply != nil {
+		return errReply
+	}
+	for _, slot := range slots {
+		if c.slots[slot] == nil {
+			return resp.MakeErrReply(fmt.Sprintf("ERR Slot %d is already unassigned", slot))
+		}
+	}
+	for _, slot := range slots {
+		c.slots[slot] = nil
+		delete(c.importing, slot)
+		delete(c.migrating, slot)
+	}
+	return c.configChanged()
+}
+
+// CLUSTER SETSLOT slot IMPORTING node-id|MIGRATING node-id|NODE node-id|STABLE
+func (c *Cluster) execSetSlot(args [][]byte) resp.Reply {
+	slot, ok := parseSlot(string(args[0]))
+	if !ok {
+		return resp.MakeErrReply("ERR Invalid or out of range slot")
+	}
+	action := strings.ToLower(string(args[1]))
+	switch {
+	case action == "stable" && len(args) == 2:
+	case (action == "importing" || action == "migrating" || action == "node") && len(args) == 3:
+	default:
+		return resp.MakeErrReply("ERR Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+	}
+	// 不能持有 mu 访问数据库
+	keysInSlot := 0
+	if action == "node" {
+		keysInSlot = c.keys.CountKeysInSlot(slot)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var n *node
+	if action != "stable" {
+		id := string(args[2])
+		if n, ok = c.nodes[id]; !ok || n.handshake {
+			if action == "node" {
+				return resp.MakeErrReply("ERR Unknown node " + id)
+			}
+			return resp.MakeErrReply("ERR I don't know about node " + id)
+		}
+	}
+
+	switch action {
+	case "migrating":
+		if c.slots[slot] != c.myself {
+			return resp.MakeErrReply(fmt.Sprintf("ERR I'm not the owner of hash slot %d", slot))
+		}
+		if n == c.myself {
+			return resp.MakeErrReply("ERR Can't migrate a slot to myself")
+		}
+		c.migrating[slot] = n
+	case "importing":
+		if c.slots[slot] == c.myself {
+			return resp.MakeErrReply(fmt.Sprintf("ERR I'm already the owner of hash slot %d", slot))
+		}
+		if n == c.myself {
+			return resp.MakeErrReply("ERR Can't import a slot from myself")
+		}
+		c.importing[slot] = n
+	case "stable":
+		delete(c.migrating, slot)
+		delete(c.importing, slot)
+	default: // node
+		if c.slots[slot] == c.myself && n != c.myself && keysInSlot > 0 {
+			return resp.MakeErrReply(fmt.Sprintf("ERR Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot))
+		}
+		if n != c.myself {
+			delete(c.migrating, slot)
+		}
+		// 迁入完成：换一个比所有节点都大的 configEpoch，其他节点收到 PONG 后以自己为准
+		if _, ok := c.importing[slot]; ok && n == c.myself {
+			delete(c.importing, slot)
+			c.bumpConfigEpoch()
+		}
+		c.slots[slot] = n
+	}
+	return c.configChanged()
+}
+
+// bumpConfigEpoch 不经过其他节点同意直接换一个新的 configEpoch，
+// 只在自己的 configEpoch 为 0 或不是已知最大的时候才需要
+func (c *Cluster) bumpConfigEpoch() {
+	maxEpoch := c.currentEpoch
+	for _, n := range c.nodes {
+		maxEpoch = max(maxEpoch, n.configEpoch)
+	}
+	if c.myself.configEpoch != 0 && c.myself.configEpoch == maxEpoch {
+		return
+	}
+	c.currentEpoch = maxEpoch + 1
+	c.myself.configEpoch = c.currentEpoch
+	log.Printf("[cluster] new configEpoch set to %d", c.myself.configEpoch)
+}
+
+// configChanged 命令修改了配置：立即保存并更新集群状态
+func (c *Cluster) configChanged() resp.Reply {
+	c.updateState()
+	if err := c.saveConfig(); err != nil {
+		return resp.MakeErrReply("ERR " + err.Error())
+	}
+	return resp.MakeOkReply()
+}
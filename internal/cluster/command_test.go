@@ -0,0 +1,232 @@
+package cluster
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"goredis/internal/resp"
+)
+
+// fakeKeySpace 按槽记录 key 的 KeySpace
+type fakeKeySpace map[string]bool
+
+func (ks fakeKeySpace) CountKeysInSlot(slot int) int {
+	return len(ks.GetKeysInSlot(slot, len(ks)))
+}
+
+func (ks fakeKeySpace) GetKeysInSlot(slot int, count int) []string {
+	var keys []string
+	for key := range ks {
+		if KeySlot(key) == slot {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > count {
+		keys = keys[:count]
+	}
+	return keys
+}
+
+func newTestCluster(t *testing.T, addr string, keys KeySpace) *Cluster {
+	t.Helper()
+	c, err := New(Config{
+		Addr:        addr,
+		ConfigFile:  filepath.Join(t.TempDir(), "nodes.conf"),
+		NodeTimeout: time.Second,
+	}, keys)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return c
+}
+
+// addTestNode 加入一个已经握手成功的节点
+func addTestNode(c *Cluster, id string, port int) *node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := newNode(id, "127.0.0.1", port, port+busPortOffset)
+	c.nodes[id] = n
+	return n
+}
+
+func execCluster(c *Cluster, args ...string) resp.Reply {
+	cmdLine := [][]byte{[]byte("cluster")}
+	for _, arg := range args {
+		cmdLine = append(cmdLine, []byte(arg))
+	}
+	return c.Exec(cmdLine)
+}
+
+func assertOK(t *testing.T, reply resp.Reply) {
+	t.Helper()
+	if string(reply.ToBytes()) != "+OK\r\n" {
+		t.Fatalf("expected OK, got %q", reply.ToBytes())
+	}
+}
+
+func assertErr(t *testing.T, reply resp.Reply, prefix string) {
+	t.Helper()
+	if reply == nil {
+		t.Fatalf("expected error %q, got nil", prefix)
+	}
+	if got := string(reply.ToBytes()); !strings.HasPrefix(got, "-"+prefix) {
+		t.Fatalf("expected error %q, got %q", prefix, got)
+	}
+}
+
+// addSlots 把 [start, end] 的槽分配给自己
+func addSlots(t *testing.T, c *Cluster, start, end int) {
+	t.Helper()
+	args := []string{"addslots"}
+	for slot := start; slot <= end; slot++ {
+		args = append(args, strconv.Itoa(slot))
+	}
+	assertOK(t, execCluster(c, args...))
+}
+
+func TestClusterCommand(t *testing.T) {
+	t.Run("KEYSLOT and key counting", func(t *testing.T) {
+		keys := fakeKeySpace{"{a}1": true, "{a}2": true, "b": true}
+		c := newTestCluster(t, "127.0.0.1:7000", keys)
+		slot := KeySlot("a")
+
+		if got := string(execCluster(c, "keyslot", "foo").ToBytes()); got != ":12182\r\n" {
+			t.Errorf("unexpected KEYSLOT %q", got)
+		}
+		if got := string(execCluster(c, "countkeysinslot", strconv.Itoa(slot)).ToBytes()); got != ":2\r\n" {
+			t.Errorf("unexpected COUNTKEYSINSLOT %q", got)
+		}
+		if got := string(execCluster(c, "getkeysinslot", strconv.Itoa(slot), "1").ToBytes()); got != "*1\r\n$4\r\n{a}1\r\n" {
+			t.Errorf("unexpected GETKEYSINSLOT %q", got)
+		}
+		assertErr(t, execCluster(c, "countkeysinslot", "16384"), "ERR Invalid slot")
+		assertErr(t, execCluster(c, "getkeysinslot", "0", "-1"), "ERR Invalid number of keys")
+		assertErr(t, execCluster(c, "keyslot"), "ERR wrong number of arguments")
+		assertErr(t, execCluster(c, "nosuch"), "ERR Unknown subcommand")
+	})
+
+	t.Run("ADDSLOTS and DELSLOTS", func(t *testing.T) {
+		c := newTestCluster(t, "127.0.0.1:7000", fakeKeySpace{})
+		if !strings.Contains(string(execCluster(c, "info").ToBytes()), "cluster_state:fail") {
+			t.Error("cluster without slots should be down")
+		}
+
+		addSlots(t, c, 0, SlotCount-1)
+		info := string(execCluster(c, "info").ToBytes())
+		if !strings.Contains(info, "cluster_state:ok") || !strings.Contains(info, "cluster_slots_assigned:16384") {
+			t.Errorf("unexpected info %q", info)
+		}
+		if nodes := string(execCluster(c, "nodes").ToBytes()); !strings.Contains(nodes, "myself,master") || !strings.Contains(nodes, " 0-16383\n") {
+			t.Errorf("unexpected nodes %q", nodes)
+		}
+		assertErr(t, execCluster(c, "addslots", "5"), "ERR Slot 5 is already busy")
+
+		assertOK(t, execCluster(c, "delslots", "5"))
+		assertErr(t, execCluster(c, "delslots", "5"), "ERR Slot 5 is already unassigned")
+		assertErr(t, execCluster(c, "addslots", "5", "5"), "ERR Slot 5 specified multiple times")
+		assertErr(t, execCluster(c, "addslots", "16384"), "ERR Invalid or out of range slot")
+		if !strings.Contains(string(execCluster(c, "info").ToBytes()), "cluster_state:fail") {
+			t.Error("cluster with an unassigned slot should be down")
+		}
+	})
+
+	t.Run("MEET", func(t *testing.T) {
+		c := newTestCluster(t, "127.0.0.1:7000", fakeKeySpace{})
+		assertErr(t, execCluster(c, "meet", "localhost", "7001"), "ERR Invalid node address specified")
+		assertErr(t, execCluster(c, "meet", "127.0.0.1", "0"), "ERR Invalid node address specified")
+		assertOK(t, execCluster(c, "meet", "127.0.0.1", "7001"))
+		assertOK(t, execCluster(c, "meet", "127.0.0.1", "7001"))
+
+		nodes := string(execCluster(c, "nodes").ToBytes())
+		if strings.Count(nodes, "handshake") != 1 || !strings.Contains(nodes, "127.0.0.1:7001@17001") {
+			t.Errorf("expected one handshake node, got %q", nodes)
+		}
+	})
+}
+
+func TestRoute(t *testing.T) {
+	keys := fakeKeySpace{}
+	c := newTestCluster(t, "127.0.0.1:7000", keys)
+	other := addTestNode(c, strings.Repeat("b", 40), 7001)
+	addSlots(t, c, 0, 8191)
+	c.mu.Lock()
+	for slot := 8192; slot < SlotCount; slot++ {
+		c.slots[slot] = other
+	}
+	c.updateState()
+	c.mu.Unlock()
+
+	exists := func(key string) bool { return keys[key] }
+	local, remote := "bar", "foo" // 5061, 12182
+	localSlot, remoteSlot := strconv.Itoa(KeySlot(local)), strconv.Itoa(KeySlot(remote))
+
+	t.Run("MOVED and CROSSSLOT", func(t *testing.T) {
+		if reply := c.Route([]string{local}, false, exists); reply != nil {
+			t.Errorf("local key should run locally, got %q", reply.ToBytes())
+		}
+		if reply := c.Route(nil, false, exists); reply != nil {
+			t.Errorf("keyless command should run locally, got %q", reply.ToBytes())
+		}
+		assertErr(t, c.Route([]string{remote}, false, exists), "MOVED 12182 127.0.0.1:7001")
+		assertErr(t, c.Route([]string{remote}, true, exists), "MOVED 12182 127.0.0.1:7001")
+		assertErr(t, c.Route([]string{local, remote}, false, exists), "CROSSSLOT")
+		if reply := c.Route([]string{"{bar}1", "{bar}2"}, false, exists); reply != nil {
+			t.Errorf("keys with the same hash tag should run locally, got %q", reply.ToBytes())
+		}
+	})
+
+	t.Run("ASK while migrating", func(t *testing.T) {
+		assertErr(t, execCluster(c, "setslot", remoteSlot, "migrating", other.id), "ERR I'm not the owner")
+		assertErr(t, execCluster(c, "setslot", localSlot, "migrating", "nosuch"), "ERR I don't know about node")
+		assertOK(t, execCluster(c, "setslot", localSlot, "migrating", other.id))
+		if !strings.Contains(string(execCluster(c, "nodes").ToBytes()), "[5061->-"+other.id+"]") {
+			t.Error("CLUSTER NODES should show the migrating slot")
+		}
+
+		assertErr(t, c.Route([]string{local}, false, exists), "ASK 5061 127.0.0.1:7001")
+		keys["{bar}1"] = true
+		if reply := c.Route([]string{"{bar}1"}, false, exists); reply != nil {
+			t.Errorf("existing key should run locally, got %q", reply.ToBytes())
+		}
+		assertErr(t, c.Route([]string{"{bar}1", "{bar}2"}, false, exists), "TRYAGAIN")
+		// MIGRATE 不检查 key 是否存在
+		if reply := c.Route([]string{local}, false, nil); reply != nil {
+			t.Errorf("route without exists should run locally, got %q", reply.ToBytes())
+		}
+
+		assertErr(t, execCluster(c, "setslot", localSlot, "node", other.id), "ERR Can't assign hashslot 5061")
+		delete(keys, "{bar}1")
+		assertOK(t, execCluster(c, "setslot", localSlot, "node", other.id))
+		assertErr(t, c.Route([]string{local}, false, exists), "MOVED 5061 127.0.0.1:7001")
+	})
+
+	t.Run("ASKING while importing", func(t *testing.T) {
+		assertErr(t, execCluster(c, "setslot", "0", "importing", other.id), "ERR I'm already the owner")
+		assertOK(t, execCluster(c, "setslot", remoteSlot, "importing", other.id))
+
+		assertErr(t, c.Route([]string{remote}, false, exists), "MOVED")
+		if reply := c.Route([]string{remote}, true, exists); reply != nil {
+			t.Errorf("ASKING should run locally, got %q", reply.ToBytes())
+		}
+		assertErr(t, c.Route([]string{"{foo}1", "{foo}2"}, true, exists), "TRYAGAIN")
+
+		epoch := c.myself.configEpoch
+		assertOK(t, execCluster(c, "setslot", remoteSlot, "node", c.myself.id))
+		if reply := c.Route([]string{remote}, false, exists); reply != nil {
+			t.Errorf("imported slot should run locally, got %q", reply.ToBytes())
+		}
+		if c.myself.configEpoch <= epoch {
+			t.Error("finishing an import should bump the config epoch")
+		}
+	})
+
+	t.Run("CLUSTERDOWN", func(t *testing.T) {
+		assertOK(t, execCluster(c, "delslots", "0"))
+		assertErr(t, c.Route([]string{local}, false, exists), "CLUSTERDOWN The cluster is down")
+	})
+}
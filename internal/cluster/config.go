@@ -0,0 +1,212 @@
+package cluster
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nodeLine 节点在 CLUSTER NODES 中的一行：
+// <id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+// 自己的一行末尾还有正在迁移的槽：[slot->-target] 迁出，[slot-<-source] 迁入
+func (c *Cluster) nodeLine(n *node) string {
+	fields := []string{
+		n.id,
+		fmt.Sprintf("%s:%d@%d", n.ip, n.port, n.busPort),
+		n.flags(),
+		"-",
+		strconv.FormatInt(unixMilli(n.pingSent), 10),
+		strconv.FormatInt(unixMilli(n.pongRecv), 10),
+		strconv.FormatInt(n.configEpoch, 10),
+		n.linkState(),
+	}
+	for _, r := range toRanges(c.slotsOf(n)) {
+		fields = append(fields, r.String())
+	}
+	if n.myself {
+		for _, slot := range sortedSlots(c.migrating) {
+			fields = append(fields, fmt.Sprintf("[%d->-%s]", slot, c.migrating[slot].id))
+		}
+		for _, slot := range sortedSlots(c.importing) {
+			fields = append(fields, fmt.Sprintf("[%d-<-%s]", slot, c.importing[slot].id))
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// nodesDescription CLUSTER NODES 的内容，按 ID 排序，握手中的节点只在 withHandshake 时包括
+func (c *Cluster) nodesDescription(withHandshake bool) string {
+	ids := make([]string, 0, len(c.nodes))
+	for id, n := range c.nodes {
+		if n.handshake && !withHandshake {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var b strings.Builder
+	for _, id := range ids {
+		b.WriteString(c.nodeLine(c.nodes[id]))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// slotsOf n 负责的槽，升序
+func (c *Cluster) slotsOf(n *node) []int {
+	var slots []int
+	for slot, owner := range c.slots {
+		if owner == n {
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+func sortedSlots(m map[int]*node) []int {
+	slots := make([]int, 0, len(m))
+	for slot := range m {
+		slots = append(slots, slot)
+	}
+	sort.Ints(slots)
+	return slots
+}
+
+// saveConfig 以 CLUSTER NODES 的格式保存配置，最后一行记录 currentEpoch。
+// 先写临时文件再改名，崩溃时不会留下写了一半的配置
+func (c *Cluster) saveConfig() error {
+	content := c.nodesDescription(false) + fmt.Sprintf("vars currentEpoch %d lastVoteEpoch 0\n", c.currentEpoch)
+
+	tmp := c.cfg.ConfigFile + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("save cluster config: %w", err)
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return fmt.Errorf("save cluster config: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("save cluster config: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("save cluster config: %w", err)
+	}
+	if err := os.Rename(tmp, c.cfg.ConfigFile); err != nil {
+		return fmt.Errorf("save cluster config: %w", err)
+	}
+	c.dirty = false
+	return nil
+}
+
+// loadConfig 从配置文件恢复节点、槽的分配和正在迁移的槽，文件不存在时返回 os.ErrNotExist
+func (c *Cluster) loadConfig() error {
+	f, err := os.Open(c.cfg.ConfigFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// 槽和迁移引用的节点可能在后面的行中，先读出所有节点
+	type slotsLine struct {
+		n      *node
+		fields []string
+	}
+	var lines []slotsLine
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "vars" {
+			for i := 1; i+1 < len(fields); i += 2 {
+				if fields[i] == "currentEpoch" {
+					if c.currentEpoch, err = strconv.ParseInt(fields[i+1], 10, 64); err != nil {
+						return fmt.Errorf("invalid currentEpoch %q", fields[i+1])
+					}
+				}
+			}
+			continue
+		}
+		if len(fields) < 8 {
+			return fmt.Errorf("invalid node line %q", scanner.Text())
+		}
+		ip, port, busPort, err := parseNodeAddr(fields[1])
+		if err != nil {
+			return err
+		}
+		n := newNode(fields[0], ip, port, busPort)
+		for _, flag := range strings.Split(fields[2], ",") {
+			switch flag {
+			case "myself":
+				n.myself = true
+				c.myself = n
+			case "fail":
+				n.fail = true
+			}
+		}
+		if n.configEpoch, err = strconv.ParseInt(fields[6], 10, 64); err != nil {
+			return fmt.Errorf("invalid config epoch in line %q", scanner.Text())
+		}
+		// 重启后重新计算下线状态
+		n.pingSent = time.Now()
+		c.nodes[n.id] = n
+		lines = append(lines, slotsLine{n, fields[8:]})
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if c.myself == nil {
+		return fmt.Errorf("no myself node in %s", c.cfg.ConfigFile)
+	}
+	c.myself.pingSent = time.Time{}
+
+	for _, line := range lines {
+		for _, field := range line.fields {
+			if err := c.loadSlotField(line.n, field); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadSlotField 解析一个槽或槽的区间，以及自己正在迁移的槽
+func (c *Cluster) loadSlotField(n *node, field string) error {
+	if strings.HasPrefix(field, "[") && strings.HasSuffix(field, "]") {
+		inner := field[1 : len(field)-1]
+		table, sep := c.importing, "-<-"
+		if strings.Contains(inner, "->-") {
+			table, sep = c.migrating, "->-"
+		}
+		slotStr, id, _ := strings.Cut(inner, sep)
+		slot, ok := parseSlot(slotStr)
+		other, known := c.nodes[id]
+		if !ok || !known {
+			return fmt.Errorf("invalid migrating slot %q", field)
+		}
+		table[slot] = other
+		return nil
+	}
+
+	startStr, endStr, isRange := strings.Cut(field, "-")
+	if !isRange {
+		endStr = startStr
+	}
+	start, ok1 := parseSlot(startStr)
+	end, ok2 := parseSlot(endStr)
+	if !ok1 || !ok2 || start > end {
+		return fmt.Errorf("invalid slot %q", field)
+	}
+	for slot := start; slot <= end; slot++ {
+		c.slots[slot] = n
+	}
+	return nil
+}
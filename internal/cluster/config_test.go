@@ -0,0 +1,73 @@
+package cluster
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfig(t *testing.T) {
+	t.Run("save and load", func(t *testing.T) {
+		c := newTestCluster(t, "127.0.0.1:7000", fakeKeySpace{})
+		other := addTestNode(c, strings.Repeat("b", 40), 7001)
+		third := addTestNode(c, strings.Repeat("c", 40), 7002)
+		addSlots(t, c, 0, 99)
+		c.mu.Lock()
+		for slot := 100; slot < 200; slot++ {
+			c.slots[slot] = other
+		}
+		other.configEpoch, third.fail = 3, true
+		c.currentEpoch = 5
+		c.mu.Unlock()
+		assertOK(t, execCluster(c, "setslot", "10", "migrating", other.id))
+		assertOK(t, execCluster(c, "setslot", "150", "importing", other.id))
+
+		loaded, err := New(c.cfg, fakeKeySpace{})
+		if err != nil {
+			t.Fatalf("reload failed: %v", err)
+		}
+		if loaded.myself.id != c.myself.id || loaded.currentEpoch != 5 || len(loaded.nodes) != 3 {
+			t.Fatalf("unexpected reloaded cluster: id %s epoch %d nodes %d", loaded.myself.id, loaded.currentEpoch, len(loaded.nodes))
+		}
+		if loaded.slots[0] != loaded.myself || loaded.slots[99] != loaded.myself || loaded.slots[100].id != other.id || loaded.slots[200] != nil {
+			t.Error("slot assignment not restored")
+		}
+		if n := loaded.nodes[other.id]; n.configEpoch != 3 || n.port != 7001 || n.busPort != 17001 {
+			t.Errorf("node not restored: %+v", n)
+		}
+		if !loaded.nodes[third.id].fail {
+			t.Error("fail flag not restored")
+		}
+		if loaded.migrating[10].id != other.id || loaded.importing[150].id != other.id {
+			t.Error("migrating slots not restored")
+		}
+	})
+
+	t.Run("handshake nodes are not saved", func(t *testing.T) {
+		c := newTestCluster(t, "127.0.0.1:7000", fakeKeySpace{})
+		assertOK(t, execCluster(c, "meet", "127.0.0.1", "7001"))
+		c.mu.Lock()
+		c.saveConfig()
+		c.mu.Unlock()
+
+		content, err := os.ReadFile(c.cfg.ConfigFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(content), "handshake") {
+			t.Errorf("unexpected config %q", content)
+		}
+		if !strings.HasSuffix(string(content), "vars currentEpoch 0 lastVoteEpoch 0\n") {
+			t.Errorf("missing vars line in %q", content)
+		}
+	})
+
+	t.Run("corrupted config", func(t *testing.T) {
+		cfg := Config{Addr: "127.0.0.1:7000", ConfigFile: t.TempDir() + "/nodes.conf", NodeTimeout: time.Second}
+		os.WriteFile(cfg.ConfigFile, []byte("garbage\n"), 0644)
+		if _, err := New(cfg, fakeKeySpace{}); err == nil {
+			t.Error("corrupted config should be rejected")
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package cluster
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// node 集群中的一个节点，所有字段由 Cluster.mu 保护。
+// 目前集群中只有 master，槽的归属记录在 Cluster.slots 中
+type node struct {
+	id      string
+	ip      string
+	port    int
+	busPort int
+
+	myself bool
+	// 通过 CLUSTER MEET 或 gossip 得知、还没有握手成功的节点，id 是临时生成的
+	handshake      bool
+	handshakeStart time.Time
+
+	// 声明槽归属时使用的版本，更大的 configEpoch 覆盖更小的
+	configEpoch int64
+
+	// pfail 自己超过 NodeTimeout 没有收到它的 PONG；fail 多数持有槽的 master 都认为它 pfail
+	pfail bool
+	fail  bool
+	// 其他 master 报告它 pfail 的时间，reporter id -> 时间
+	failReports map[string]time.Time
+
+	link     *busLink
+	lastPing time.Time
+	pingSent time.Time // 最早的还没有收到 PONG 的 PING 的发送时间，为零表示没有
+	pongRecv time.Time
+	pending  bool // 正在发送 PING
+}
+
+func newNode(id, ip string, port, busPort int) *node {
+	return &node{
+		id:          id,
+		ip:          ip,
+		port:        port,
+		busPort:     busPort,
+		failReports: make(map[string]time.Time),
+		link:        newBusLink(net.JoinHostPort(ip, strconv.Itoa(busPort))),
+	}
+}
+
+func (n *node) addr() string {
+	return net.JoinHostPort(n.ip, strconv.Itoa(n.port))
+}
+
+func (n *node) busAddr() string {
+	return net.JoinHostPort(n.ip, strconv.Itoa(n.busPort))
+}
+
+func (n *node) flags() string {
+	var flags []string
+	if n.myself {
+		flags = append(flags, "myself")
+	}
+	flags = append(flags, "master")
+	if n.fail {
+		flags = append(flags, "fail")
+	} else if n.pfail {
+		flags = append(flags, "fail?")
+	}
+	if n.handshake {
+		flags = append(flags, "handshake")
+	}
+	if n.ip == "" {
+		flags = append(flags, "noaddr")
+	}
+	return strings.Join(flags, ",")
+}
+
+func (n *node) linkState() string {
+	if n.myself || (n.link != nil && n.link.connected()) {
+		return "connected"
+	}
+	return "disconnected"
+}
+
+// genNodeID 随机生成 40 位十六进制的节点 ID
+func genNodeID() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// parseNodeAddr 解析 ip:port@cport
+func parseNodeAddr(s string) (string, int, int, error) {
+	// 忽略 Redis 7 追加的 ,hostname
+	if i := strings.IndexByte(s, ','); i >= 0 {
+		s = s[:i]
+	}
+	addr, cport, ok := strings.Cut(s, "@")
+	if !ok {
+		return "", 0, 0, fmt.Errorf("invalid node address %q", s)
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid node address %q", s)
+	}
+	port, err1 := strconv.Atoi(portStr)
+	busPort, err2 := strconv.Atoi(cport)
+	if err1 != nil || err2 != nil {
+		return "", 0, 0, fmt.Errorf("invalid node address %q", s)
+	}
+	return host, port, busPort, nil
+}
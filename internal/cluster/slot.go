@@ -0,0 +1,70 @@
+package cluster
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SlotCount 哈希槽的数量，与 Redis Cluster 相同
+const SlotCount = 16384
+
+// KeySlot 返回 key 所在的槽：CRC16(key) mod 16384。
+// key 中包含非空的 {...} 时只对第一对花括号中的内容计算，这样相关的 key 可以放在同一个槽里
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) & (SlotCount - 1))
+}
+
+// crc16 CRC16-CCITT (XMODEM)：多项式 0x1021，初始值 0
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// slotRange 连续的一段槽 [start, end]
+type slotRange struct {
+	start, end int
+}
+
+func (r slotRange) String() string {
+	if r.start == r.end {
+		return strconv.Itoa(r.start)
+	}
+	return strconv.Itoa(r.start) + "-" + strconv.Itoa(r.end)
+}
+
+// toRanges 把升序的槽合并成连续的区间
+func toRanges(slots []int) []slotRange {
+	var ranges []slotRange
+	for _, slot := range slots {
+		if n := len(ranges); n > 0 && ranges[n-1].end == slot-1 {
+			ranges[n-1].end = slot
+			continue
+		}
+		ranges = append(ranges, slotRange{slot, slot})
+	}
+	return ranges
+}
+
+// parseSlot 解析槽编号，超出范围时返回 false
+func parseSlot(s string) (int, bool) {
+	slot, err := strconv.Atoi(s)
+	if err != nil || slot < 0 || slot >= SlotCount {
+		return 0, false
+	}
+	return slot, true
+}
@@ -0,0 +1,61 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeySlot(t *testing.T) {
+	t.Run("crc16", func(t *testing.T) {
+		// XMODEM 的标准校验值
+		if got := crc16("123456789"); got != 0x31c3 {
+			t.Errorf("expected 0x31c3, got %#x", got)
+		}
+	})
+
+	t.Run("known slots", func(t *testing.T) {
+		cases := map[string]int{
+			"":      0,
+			"foo":   12182,
+			"bar":   5061,
+			"hello": 866,
+		}
+		for key, want := range cases {
+			if got := KeySlot(key); got != want {
+				t.Errorf("KeySlot(%q) = %d, want %d", key, got, want)
+			}
+		}
+	})
+
+	t.Run("hash tags", func(t *testing.T) {
+		if KeySlot("{user1000}.following") != KeySlot("{user1000}.followers") {
+			t.Error("keys with the same hash tag should be in the same slot")
+		}
+		same := map[string]string{
+			"foo{bar}{zap}": "bar",        // 只看第一对花括号
+			"foo{{bar}}zap": "{bar",       // 第一个 { 到第一个 } 之间
+			"foo{}{bar}":    "foo{}{bar}", // 空的 {} 不算，对整个 key 计算
+			"foo{bar":       "foo{bar",    // 没有 }
+			"}foo{bar}":     "bar",
+		}
+		for key, hashed := range same {
+			if KeySlot(key) != KeySlot(hashed) {
+				t.Errorf("KeySlot(%q) should equal KeySlot(%q)", key, hashed)
+			}
+		}
+	})
+}
+
+func TestToRanges(t *testing.T) {
+	got := toRanges([]int{0, 1, 2, 5, 7, 8, 16383})
+	want := []slotRange{{0, 2}, {5, 5}, {7, 8}, {16383, 16383}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if got[0].String() != "0-2" || got[1].String() != "5" {
+		t.Errorf("unexpected strings %q %q", got[0].String(), got[1].String())
+	}
+	if toRanges(nil) != nil {
+		t.Error("no slots should give no ranges")
+	}
+}
@@ -0,0 +1,577 @@
+package command
+
+import (
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"goredis/internal/data"
+	"goredis/internal/resp"
+	"goredis/internal/types"
+)
+
+// maxBitOffset 位偏移的上限，与字符串的最大长度一致
+const maxBitOffset = maxStringSize * 8
+
+func makeBitOffsetErr() resp.Reply {
+	return resp.MakeErrReply("ERR bit offset is not an integer or out of range")
+}
+
+// parseBitOffset 解析位偏移，hashAllowed 时支持 BITFIELD 的 #N 写法，表示第 N 个宽度为 width 的字段
+func parseBitOffset(arg []byte, hashAllowed bool, width int64) (int64, resp.Reply) {
+	s := string(arg)
+	multiply := false
+	if hashAllowed && strings.HasPrefix(s, "#") {
+		s, multiply = s[1:], true
+	}
+	offset, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, makeBitOffsetErr()
+	}
+	if multiply {
+		if offset > maxBitOffset/width {
+			return 0, makeBitOffsetErr()
+		}
+		offset *= width
+	}
+	if offset+width > maxBitOffset {
+		return 0, makeBitOffsetErr()
+	}
+	return offset, nil
+}
+
+// SETBIT key offset value
+func execSetBit(db types.Database, args [][]byte) resp.Reply {
+	key := string(args[0])
+	offset, errReply := parseBitOffset(args[1], false, 1)
+	if errReply != nil {
+		return errReply
+	}
+	value := string(args[2])
+	if value != "0" && value != "1" {
+		return resp.MakeErrReply("ERR bit is not an integer or out of range")
+	}
+
+	str, exists, errReply := getString(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if !exists {
+		str = data.NewStringFromBytes(nil)
+		db.PutEntity(key, &types.DataEntity{Data: str})
+	}
+	return resp.MakeIntReply(int64(str.SetBit(offset, value[0]-'0')))
+}
+
+// GETBIT key offset
+func execGetBit(db types.Database, args [][]byte) resp.Reply {
+	offset, errReply := parseBitOffset(args[1], false, 1)
+	if errReply != nil {
+		return errReply
+	}
+	str, exists, errReply := getString(db, string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if !exists {
+		return resp.MakeIntReply(0)
+	}
+	return resp.MakeIntReply(int64(str.GetBit(offset)))
+}
+
+// bitRange BITCOUNT/BITPOS 的范围参数
+type bitRange struct {
+	start, end int64
+	isBit      bool // 范围以位为单位 (BIT)，否则以字节为单位 (BYTE)
+}
+
+// parseBitRange 解析 start end [BYTE|BIT]，end 可以省略时由调用方传入 endOptional
+func parseBitRange(args [][]byte, endOptional bool) (*bitRange, bool, resp.Reply) {
+	r := &bitRange{end: -1}
+	endGiven := false
+	if len(args) == 0 {
+		return nil, false, nil
+	}
+	if len(args) > 3 || (len(args) == 1 && !endOptional) {
+		return nil, false, resp.MakeErrReply("ERR syntax error")
+	}
+
+	var err error
+	if r.start, err = strconv.ParseInt(string(args[0]), 10, 64); err != nil {
+		return nil, false, resp.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if len(args) >= 2 {
+		if r.end, err = strconv.ParseInt(string(args[1]), 10, 64); err != nil {
+			return nil, false, resp.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		endGiven = true
+	}
+	if len(args) == 3 {
+		switch strings.ToLower(string(args[2])) {
+		case "bit":
+			r.isBit = true
+		case "byte":
+		default:
+			return nil, false, resp.MakeErrReply("ERR syntax error")
+		}
+	}
+	return r, endGiven, nil
+}
+
+// normalize 按 Redis 的规则处理负数下标并截断到 [0, total)，范围为空时返回 false
+func (r *bitRange) normalize(total int64) bool {
+	if r.start < 0 && r.end < 0 && r.start > r.end {
+		return false
+	}
+	if r.start < 0 {
+		r.start += total
+	}
+	if r.end < 0 {
+		r.end += total
+	}
+	if r.start < 0 {
+		r.start = 0
+	}
+	if r.end < 0 {
+		r.end = 0
+	}
+	if r.end >= total {
+		r.end = total - 1
+	}
+	return r.start <= r.end
+}
+
+// bitRangeBytes 返回范围覆盖的字节以及首尾字节中不属于范围的位
+func bitRangeBytes(val []byte, r *bitRange) (b []byte, firstMask, lastMask byte) {
+	if !r.isBit {
+		return val[r.start : r.end+1], 0, 0
+	}
+	firstMask = ^byte(0xff >> uint(r.start&7))
+	lastMask = byte(0xff >> uint(r.end&7+1))
+	return val[r.start>>3 : r.end>>3+1], firstMask, lastMask
+}
+
+// BITCOUNT key [start end [BYTE|BIT]]
+func execBitCount(db types.Database, args [][]byte) resp.Reply {
+	r, _, errReply := parseBitRange(args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+	str, exists, errReply := getString(db, string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if !exists {
+		return resp.MakeIntReply(0)
+	}
+
+	val := str.Get()
+	if r == nil {
+		r = &bitRange{start: 0, end: -1}
+	}
+	total := int64(len(val))
+	if r.isBit {
+		total *= 8
+	}
+	if !r.normalize(total) {
+		return resp.MakeIntReply(0)
+	}
+
+	b, firstMask, lastMask := bitRangeBytes(val, r)
+	count := 0
+	for _, c := range b {
+		count += bits.OnesCount8(c)
+	}
+	count -= bits.OnesCount8(b[0]&firstMask) + bits.OnesCount8(b[len(b)-1]&lastMask)
+	return resp.MakeIntReply(int64(count))
+}
+
+// BITPOS key bit [start [end [BYTE|BIT]]]
+func execBitPos(db types.Database, args [][]byte) resp.Reply {
+	bitArg := string(args[1])
+	if bitArg != "0" && bitArg != "1" {
+		return resp.MakeErrReply("ERR The bit argument must be 1 or 0.")
+	}
+	bit := bitArg[0] - '0'
+
+	r, endGiven, errReply := parseBitRange(args[2:], true)
+	if errReply != nil {
+		return errReply
+	}
+	str, exists, errReply := getString(db, string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	// 不存在的 key 当作全 0 的无限长字符串
+	if !exists {
+		if bit == 1 {
+			return resp.MakeIntReply(-1)
+		}
+		return resp.MakeIntReply(0)
+	}
+
+	val := str.Get()
+	if r == nil {
+		r = &bitRange{start: 0, end: -1}
+	}
+	total := int64(len(val))
+	if r.isBit {
+		total *= 8
+	}
+	if !r.normalize(total) {
+		return resp.MakeIntReply(-1)
+	}
+
+	b, firstMask, lastMask := bitRangeBytes(val, r)
+	byteStart := r.start
+	if r.isBit {
+		byteStart = r.start >> 3
+	}
+	// 范围之外的位设置为与目标相反的值，不会被找到
+	for i, c := range b {
+		if i == 0 {
+			c = maskOut(c, firstMask, bit)
+		}
+		if i == len(b)-1 {
+			c = maskOut(c, lastMask, bit)
+		}
+		if bit == 0 {
+			c = ^c
+		}
+		if c != 0 {
+			return resp.MakeIntReply((byteStart+int64(i))*8 + int64(bits.LeadingZeros8(c)))
+		}
+	}
+
+	// 查找 0 且没有指定 end 时，字符串右侧视为补 0
+	if bit == 0 && !endGiven {
+		return resp.MakeIntReply((byteStart + int64(len(b))) * 8)
+	}
+	return resp.MakeIntReply(-1)
+}
+
+func maskOut(c, mask, bit byte) byte {
+	if bit == 1 {
+		return c &^ mask
+	}
+	return c | mask
+}
+
+// BITOP AND|OR|XOR|NOT destkey key [key ...]
+func execBitOp(db types.Database, args [][]byte) resp.Reply {
+	op := strings.ToLower(string(args[0]))
+	switch op {
+	case "and", "or", "xor":
+	case "not":
+		if len(args) != 3 {
+			return resp.MakeErrReply("ERR BITOP NOT must be called with a single source key.")
+		}
+	default:
+		return resp.MakeErrReply("ERR syntax error")
+	}
+	destKey := string(args[1])
+
+	// 不存在的 key 当作空字符串
+	srcs := make([][]byte, 0, len(args)-2)
+	maxLen := 0
+	for _, arg := range args[2:] {
+		str, exists, errReply := getString(db, string(arg))
+		if errReply != nil {
+			return errReply
+		}
+		var val []byte
+		if exists {
+			val = str.Get()
+		}
+		srcs = append(srcs, val)
+		maxLen = max(maxLen, len(val))
+	}
+
+	// 较短的字符串右侧补 0
+	result := make([]byte, maxLen)
+	for i := range result {
+		b := byteAt(srcs[0], i)
+		switch op {
+		case "not":
+			b = ^b
+		case "and":
+			for _, src := range srcs[1:] {
+				b &= byteAt(src, i)
+			}
+		case "or":
+			for _, src := range srcs[1:] {
+				b |= byteAt(src, i)
+			}
+		case "xor":
+			for _, src := range srcs[1:] {
+				b ^= byteAt(src, i)
+			}
+		}
+		result[i] = b
+	}
+
+	if maxLen == 0 {
+		db.Remove(destKey)
+		return resp.MakeIntReply(0)
+	}
+	db.PutEntity(destKey, &types.DataEntity{Data: data.NewStringFromBytes(result)})
+	db.DeleteTTL(destKey)
+	return resp.MakeIntReply(int64(maxLen))
+}
+
+func byteAt(b []byte, i int) byte {
+	if i < len(b) {
+		return b[i]
+	}
+	return 0
+}
+
+// BITFIELD 的溢出处理方式
+const (
+	overflowWrap = iota
+	overflowSat
+	overflowFail
+)
+
+// bitfieldOp BITFIELD 的一个子命令
+type bitfieldOp struct {
+	op       string // get, set, incrby
+	signed   bool
+	width    int64
+	offset   int64
+	value    int64
+	overflow int
+}
+
+func parseBitfieldType(arg []byte) (bool, int64, bool) {
+	s := strings.ToLower(string(arg))
+	if len(s) < 2 || (s[0] != 'i' && s[0] != 'u') {
+		return false, 0, false
+	}
+	signed := s[0] == 'i'
+	width, err := strconv.ParseInt(s[1:], 10, 64)
+	if err != nil || width < 1 || (signed && width > 64) || (!signed && width > 63) {
+		return false, 0, false
+	}
+	return signed, width, true
+}
+
+// parseBitfieldOps 解析 BITFIELD 的子命令，readOnly 时只允许 GET
+func parseBitfieldOps(args [][]byte, readOnly bool) ([]bitfieldOp, resp.Reply) {
+	var ops []bitfieldOp
+	overflow := overflowWrap
+	for i := 0; i < len(args); {
+		name := strings.ToLower(string(args[i]))
+		need := 0
+		switch name {
+		case "get":
+			need = 2
+		case "set", "incrby":
+			need = 3
+		case "overflow":
+			need = 1
+		default:
+			return nil, resp.MakeErrReply("ERR syntax error")
+		}
+		if i+need >= len(args) {
+			return nil, resp.MakeErrReply("ERR syntax error")
+		}
+
+		if name == "overflow" {
+			switch strings.ToLower(string(args[i+1])) {
+			case "wrap":
+				overflow = overflowWrap
+			case "sat":
+				overflow = overflowSat
+			case "fail":
+				overflow = overflowFail
+			default:
+				return nil, resp.MakeErrReply("ERR Invalid OVERFLOW type specified")
+			}
+			i += 2
+			continue
+		}
+
+		if readOnly && name != "get" {
+			return nil, resp.MakeErrReply("ERR BITFIELD_RO only supports the GET subcommand")
+		}
+		signed, width, ok := parseBitfieldType(args[i+1])
+		if !ok {
+			return nil, resp.MakeErrReply("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+		}
+		offset, errReply := parseBitOffset(args[i+2], true, width)
+		if errReply != nil {
+			return nil, errReply
+		}
+		op := bitfieldOp{op: name, signed: signed, width: width, offset: offset, overflow: overflow}
+		if need == 3 {
+			value, err := strconv.ParseInt(string(args[i+3]), 10, 64)
+			if err != nil {
+				return nil, resp.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			op.value = value
+		}
+		ops = append(ops, op)
+		i += need + 1
+	}
+	return ops, nil
+}
+
+// BITFIELD key [GET type offset] [SET type offset value] [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL]
+func execBitField(db types.Database, args [][]byte) resp.Reply {
+	return bitfieldGeneric(db, args, false)
+}
+
+// BITFIELD_RO key [GET type offset ...]
+func execBitFieldRO(db types.Database, args [][]byte) resp.Reply {
+	return bitfieldGeneric(db, args, true)
+}
+
+func bitfieldGeneric(db types.Database, args [][]byte, readOnly bool) resp.Reply {
+	key := string(args[0])
+	ops, errReply := parseBitfieldOps(args[1:], readOnly)
+	if errReply != nil {
+		return errReply
+	}
+
+	str, exists, errReply := getString(db, key)
+	if errReply != nil {
+		return errReply
+	}
+
+	// 有写操作时才创建 key，与 Redis 一样先把字符串扩展到所需的长度
+	var maxBit int64 = -1
+	for _, op := range ops {
+		if op.op != "get" {
+			maxBit = max(maxBit, op.offset+op.width-1)
+		}
+	}
+	if maxBit >= 0 {
+		if !exists {
+			str = data.NewStringFromBytes(nil)
+			db.PutEntity(key, &types.DataEntity{Data: str})
+			exists = true
+		}
+		if int64(len(str.Get()))*8 <= maxBit {
+			str.SetRange(int(maxBit>>3), []byte{0})
+		}
+	}
+
+	replies := make([]resp.Reply, 0, len(ops))
+	for _, op := range ops {
+		var current int64
+		if exists {
+			current = readBitfield(str, op.offset, op.width, op.signed)
+		}
+
+		switch op.op {
+		case "get":
+			replies = append(replies, resp.MakeIntReply(current))
+		case "set":
+			value, ok := bitfieldOverflow(op.value, 0, op)
+			if !ok {
+				replies = append(replies, resp.MakeNullBulkReply())
+				continue
+			}
+			writeBitfield(str, op.offset, op.width, value)
+			replies = append(replies, resp.MakeIntReply(current))
+		case "incrby":
+			value, ok := bitfieldOverflow(current, op.value, op)
+			if !ok {
+				replies = append(replies, resp.MakeNullBulkReply())
+				continue
+			}
+			writeBitfield(str, op.offset, op.width, value)
+			replies = append(replies, resp.MakeIntReply(value))
+		}
+	}
+	return resp.MakeMultiRawReply(replies)
+}
+
+func readBitfield(str *data.SimpleString, offset, width int64, signed bool) int64 {
+	var v uint64
+	for j := int64(0); j < width; j++ {
+		v = v<<1 | uint64(str.GetBit(offset+j))
+	}
+	// 有符号数做符号扩展
+	if signed && width < 64 && v&(1<<uint(width-1)) != 0 {
+		v |= ^uint64(0) << uint(width)
+	}
+	return int64(v)
+}
+
+func writeBitfield(str *data.SimpleString, offset, width, value int64) {
+	v := uint64(value)
+	for j := int64(0); j < width; j++ {
+		str.SetBit(offset+j, byte(v>>uint(width-1-j))&1)
+	}
+}
+
+// bitfieldOverflow 计算 value+incr，按 op 的类型和溢出方式处理。OVERFLOW FAIL 溢出时返回 false
+func bitfieldOverflow(value, incr int64, op bitfieldOp) (int64, bool) {
+	if op.signed {
+		return signedBitfieldOverflow(value, incr, op.width, op.overflow)
+	}
+	return unsignedBitfieldOverflow(uint64(value), incr, op.width, op.overflow)
+}
+
+func signedBitfieldOverflow(value, incr, width int64, overflow int) (int64, bool) {
+	maxVal := int64(math.MaxInt64)
+	if width < 64 {
+		maxVal = 1<<uint(width-1) - 1
+	}
+	minVal := -maxVal - 1
+	maxIncr, minIncr := maxVal-value, minVal-value
+
+	var limit int64
+	switch {
+	case value > maxVal || (width != 64 && incr > maxIncr) || (value >= 0 && incr > 0 && incr > maxIncr):
+		limit = maxVal
+	case value < minVal || (width != 64 && incr < minIncr) || (value < 0 && incr < 0 && incr < minIncr):
+		limit = minVal
+	default:
+		return value + incr, true
+	}
+
+	switch overflow {
+	case overflowSat:
+		return limit, true
+	case overflowFail:
+		return 0, false
+	}
+	// WRAP：截取低 width 位后做符号扩展
+	c := uint64(value) + uint64(incr)
+	if width < 64 {
+		mask := ^uint64(0) << uint(width)
+		if c&(1<<uint(width-1)) != 0 {
+			c |= mask
+		} else {
+			c &^= mask
+		}
+	}
+	return int64(c), true
+}
+
+func unsignedBitfieldOverflow(value uint64, incr, width int64, overflow int) (int64, bool) {
+	maxVal := uint64(1)<<uint(width) - 1
+	maxIncr, minIncr := int64(maxVal-value), -int64(value)
+
+	var limit uint64
+	switch {
+	case value > maxVal || (incr > 0 && incr > maxIncr):
+		limit = maxVal
+	case incr < 0 && incr < minIncr:
+		limit = 0
+	default:
+		return int64(value + uint64(incr)), true
+	}
+
+	switch overflow {
+	case overflowSat:
+		return int64(limit), true
+	case overflowFail:
+		return 0, false
+	}
+	return int64((value + uint64(incr)) & maxVal), true
+}
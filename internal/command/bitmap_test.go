@@ -0,0 +1,199 @@
+package command
+
+import (
+	"testing"
+
+	"goredis/internal/types"
+)
+
+func TestSetBitGetBit(t *testing.T) {
+	db := NewMockDB()
+
+	assertEqualInt(t, execSetBit(db, toArgs("b", "7", "1")), 0)
+	assertEqualInt(t, execSetBit(db, toArgs("b", "7", "0")), 1)
+	assertEqualInt(t, execGetBit(db, toArgs("b", "7")), 0)
+	assertEqualInt(t, execGetBit(db, toArgs("b", "100")), 0)
+	assertEqualInt(t, execGetBit(db, toArgs("missing", "0")), 0)
+
+	t.Run("integer encoding", func(t *testing.T) {
+		// "1" = 0x31，把最低位清零后变成 "0"
+		execSet(db, toArgs("i", "1"))
+		assertEqualInt(t, execGetBit(db, toArgs("i", "7")), 1)
+		assertEqualInt(t, execSetBit(db, toArgs("i", "7", "0")), 1)
+		assertEqualBulk(t, execGet(db, toArgs("i")), []byte("0"))
+		assertEqualInt(t, execIncr(db, toArgs("i")), 1)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if msg := getErrorString(t, execSetBit(db, toArgs("b", "-1", "1"))); msg != "ERR bit offset is not an integer or out of range" {
+			t.Errorf("unexpected error %s", msg)
+		}
+		if msg := getErrorString(t, execSetBit(db, toArgs("b", "4294967296", "1"))); msg != "ERR bit offset is not an integer or out of range" {
+			t.Errorf("unexpected error %s", msg)
+		}
+		if msg := getErrorString(t, execSetBit(db, toArgs("b", "0", "2"))); msg != "ERR bit is not an integer or out of range" {
+			t.Errorf("unexpected error %s", msg)
+		}
+		db.PutEntity("l", &types.DataEntity{Data: "not a string"})
+		if msg := getErrorString(t, execGetBit(db, toArgs("l", "0"))); msg != "ERR wrong type" {
+			t.Errorf("unexpected error %s", msg)
+		}
+	})
+}
+
+func TestBitCountBitPos(t *testing.T) {
+	db := NewMockDB()
+	execSet(db, toArgs("k", "foobar"))
+
+	t.Run("BITCOUNT", func(t *testing.T) {
+		assertEqualInt(t, execBitCount(db, toArgs("k")), 26)
+		assertEqualInt(t, execBitCount(db, toArgs("k", "0", "0")), 4)
+		assertEqualInt(t, execBitCount(db, toArgs("k", "1", "1")), 6)
+		assertEqualInt(t, execBitCount(db, toArgs("k", "1", "1", "BYTE")), 6)
+		assertEqualInt(t, execBitCount(db, toArgs("k", "5", "30", "BIT")), 17)
+		assertEqualInt(t, execBitCount(db, toArgs("k", "-2", "-1")), 7)
+		assertEqualInt(t, execBitCount(db, toArgs("k", "3", "1")), 0)
+		assertEqualInt(t, execBitCount(db, toArgs("missing")), 0)
+
+		if msg := getErrorString(t, execBitCount(db, toArgs("k", "0"))); msg != "ERR syntax error" {
+			t.Errorf("unexpected error %s", msg)
+		}
+		if msg := getErrorString(t, execBitCount(db, toArgs("k", "0", "1", "bits"))); msg != "ERR syntax error" {
+			t.Errorf("unexpected error %s", msg)
+		}
+	})
+
+	t.Run("BITPOS", func(t *testing.T) {
+		execSet(db, toArgs("p", "\xff\xf0\x00"))
+		assertEqualInt(t, execBitPos(db, toArgs("p", "0")), 12)
+		execSet(db, toArgs("p", "\x00\xff\xf0"))
+		assertEqualInt(t, execBitPos(db, toArgs("p", "1", "0")), 8)
+		assertEqualInt(t, execBitPos(db, toArgs("p", "1", "2")), 16)
+		assertEqualInt(t, execBitPos(db, toArgs("p", "1", "2", "-1", "BYTE")), 16)
+		assertEqualInt(t, execBitPos(db, toArgs("p", "1", "7", "15", "BIT")), 8)
+
+		// 没有指定 end 时右侧视为补 0
+		execSet(db, toArgs("ones", "\xff\xff"))
+		assertEqualInt(t, execBitPos(db, toArgs("ones", "0")), 16)
+		assertEqualInt(t, execBitPos(db, toArgs("ones", "0", "0", "-1")), -1)
+		assertEqualInt(t, execBitPos(db, toArgs("ones", "0", "3", "9", "BIT")), -1)
+
+		assertEqualInt(t, execBitPos(db, toArgs("missing", "0")), 0)
+		assertEqualInt(t, execBitPos(db, toArgs("missing", "1")), -1)
+
+		if msg := getErrorString(t, execBitPos(db, toArgs("p", "2"))); msg != "ERR The bit argument must be 1 or 0." {
+			t.Errorf("unexpected error %s", msg)
+		}
+	})
+}
+
+func TestBitOp(t *testing.T) {
+	db := NewMockDB()
+	execSet(db, toArgs("a", "foobar"))
+	execSet(db, toArgs("b", "abcdef"))
+
+	assertEqualInt(t, execBitOp(db, toArgs("and", "dest", "a", "b")), 6)
+	assertEqualBulk(t, execGet(db, toArgs("dest")), []byte("`bc`ab"))
+	assertEqualInt(t, execBitOp(db, toArgs("OR", "dest", "a", "b")), 6)
+	assertEqualBulk(t, execGet(db, toArgs("dest")), []byte("goofev"))
+	assertEqualInt(t, execBitOp(db, toArgs("xor", "dest", "a", "a")), 6)
+	assertEqualBulk(t, execGet(db, toArgs("dest")), []byte("\x00\x00\x00\x00\x00\x00"))
+
+	// 较短的字符串补 0
+	execSet(db, toArgs("short", "\xff"))
+	assertEqualInt(t, execBitOp(db, toArgs("and", "dest", "short", "a")), 6)
+	assertEqualBulk(t, execGet(db, toArgs("dest")), []byte("f\x00\x00\x00\x00\x00"))
+	assertEqualInt(t, execBitOp(db, toArgs("not", "dest", "short")), 1)
+	assertEqualBulk(t, execGet(db, toArgs("dest")), []byte("\x00"))
+
+	// 结果为空时删除目标 key
+	assertEqualInt(t, execBitOp(db, toArgs("or", "dest", "missing")), 0)
+	if _, exists := db.GetEntity("dest"); exists {
+		t.Error("empty result should delete destkey")
+	}
+
+	if msg := getErrorString(t, execBitOp(db, toArgs("not", "dest", "a", "b"))); msg != "ERR BITOP NOT must be called with a single source key." {
+		t.Errorf("unexpected error %s", msg)
+	}
+	if msg := getErrorString(t, execBitOp(db, toArgs("nand", "dest", "a"))); msg != "ERR syntax error" {
+		t.Errorf("unexpected error %s", msg)
+	}
+}
+
+func TestBitField(t *testing.T) {
+	bitfield := func(db *MockDB, args ...string) string {
+		return string(execBitField(db, toArgs(args...)).ToBytes())
+	}
+
+	t.Run("GET SET INCRBY", func(t *testing.T) {
+		db := NewMockDB()
+		if got := bitfield(db, "k", "incrby", "i5", "100", "1", "get", "u4", "0"); got != "*2\r\n:1\r\n:0\r\n" {
+			t.Errorf("unexpected reply %q", got)
+		}
+		if got := bitfield(db, "k", "set", "i8", "#1", "-100", "get", "i8", "8", "get", "u8", "#1"); got != "*3\r\n:0\r\n:-100\r\n:156\r\n" {
+			t.Errorf("unexpected reply %q", got)
+		}
+		if got := bitfield(db, "k2", "set", "i64", "0", "-1", "get", "i64", "0", "get", "u63", "1"); got != "*3\r\n:0\r\n:-1\r\n:9223372036854775807\r\n" {
+			t.Errorf("unexpected reply %q", got)
+		}
+	})
+
+	t.Run("OVERFLOW", func(t *testing.T) {
+		db := NewMockDB()
+		cases := []struct {
+			args []string
+			want string
+		}{
+			{[]string{"incrby", "u2", "100", "1", "overflow", "sat", "incrby", "u2", "102", "1"}, "*2\r\n:1\r\n:1\r\n"},
+			{[]string{"incrby", "u2", "100", "1", "overflow", "sat", "incrby", "u2", "102", "1"}, "*2\r\n:2\r\n:2\r\n"},
+			{[]string{"incrby", "u2", "100", "1", "overflow", "sat", "incrby", "u2", "102", "1"}, "*2\r\n:3\r\n:3\r\n"},
+			{[]string{"incrby", "u2", "100", "1", "overflow", "sat", "incrby", "u2", "102", "1"}, "*2\r\n:0\r\n:3\r\n"},
+			{[]string{"overflow", "fail", "incrby", "u2", "102", "1"}, "*1\r\n$-1\r\n"},
+			{[]string{"overflow", "wrap", "incrby", "i8", "200", "127", "incrby", "i8", "200", "2"}, "*2\r\n:127\r\n:-127\r\n"},
+			{[]string{"overflow", "sat", "incrby", "i8", "300", "-200"}, "*1\r\n:-128\r\n"},
+			{[]string{"overflow", "sat", "set", "u8", "400", "-1", "get", "u8", "400"}, "*2\r\n:0\r\n:255\r\n"},
+			{[]string{"overflow", "wrap", "set", "u8", "400", "257", "get", "u8", "400"}, "*2\r\n:255\r\n:1\r\n"},
+			{[]string{"overflow", "wrap", "incrby", "i64", "500", "9223372036854775807", "incrby", "i64", "500", "1"}, "*2\r\n:9223372036854775807\r\n:-9223372036854775808\r\n"},
+		}
+		for _, tc := range cases {
+			if got := bitfield(db, append([]string{"k"}, tc.args...)...); got != tc.want {
+				t.Errorf("%v: got %q, want %q", tc.args, got, tc.want)
+			}
+		}
+	})
+
+	t.Run("read only", func(t *testing.T) {
+		db := NewMockDB()
+		if got := string(execBitFieldRO(db, toArgs("missing", "get", "u8", "0")).ToBytes()); got != "*1\r\n:0\r\n" {
+			t.Errorf("unexpected reply %q", got)
+		}
+		if _, exists := db.GetEntity("missing"); exists {
+			t.Error("GET should not create the key")
+		}
+		if msg := getErrorString(t, execBitFieldRO(db, toArgs("k", "set", "u8", "0", "1"))); msg != "ERR BITFIELD_RO only supports the GET subcommand" {
+			t.Errorf("unexpected error %s", msg)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		db := NewMockDB()
+		for _, tc := range []struct {
+			args []string
+			err  string
+		}{
+			{[]string{"get", "u64", "0"}, "ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is."},
+			{[]string{"get", "i0", "0"}, "ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is."},
+			{[]string{"get", "u8", "-1"}, "ERR bit offset is not an integer or out of range"},
+			{[]string{"overflow", "foo"}, "ERR Invalid OVERFLOW type specified"},
+			{[]string{"set", "u8", "0"}, "ERR syntax error"},
+			{[]string{"del", "u8", "0"}, "ERR syntax error"},
+		} {
+			if msg := getErrorString(t, execBitField(db, toArgs(append([]string{"k"}, tc.args...)...))); msg != tc.err {
+				t.Errorf("%v: got %q, want %q", tc.args, msg, tc.err)
+			}
+		}
+		if _, exists := db.GetEntity("k"); exists {
+			t.Error("invalid BITFIELD should not create the key")
+		}
+	})
+}
@@ -1,11 +1,14 @@
 package command
 
 import (
+	"math"
 	"strconv"
-	"time"
+	"strings"
 
+	"goredis/internal/data"
 	"goredis/internal/resp"
 	"goredis/internal/types"
+	"goredis/pkg/wildcard"
 )
 
 // ExecFunc 定义每个 Redis 命令的执行函数签名
@@ -16,6 +19,30 @@ type Command struct {
 	Name     string   // 命令名称
 	Executor ExecFunc // 执行函数
 	Arity    int      // 参数数量限制 (例如: SET key val 是 3，如果允许不定参数用负数表示)
+
+	// key 在命令行中的位置，含义与 Redis 命令表一致 (命令名位于 0)
+	// FirstKey 为 0 表示命令不涉及 key，LastKey 为负数表示从末尾倒数
+	FirstKey int
+	LastKey  int
+	KeyStep  int
+
+	// Keys 非空时取代 FirstKey/LastKey/KeyStep，用于 key 位置不固定的命令，例如 XREAD
+	Keys func(cmdLine [][]byte) []string
+
+	// Translate 非空时，执行前用它改写命令行，例如把相对过期时间换算成绝对时间。
+	// 实际执行以及写入 AOF 和复制流的都是改写后的命令，保证重放的结果一致
+	Translate func(cmdLine [][]byte) [][]byte
+}
+
+// PropagateReply 写入 AOF 和复制流的命令取决于执行结果时使用，例如 XADD 自动生成的 ID，
+// 执行前无法用 Translate 改写。CmdLines 取代原命令被传播，为空表示不需要传播
+type PropagateReply struct {
+	resp.Reply
+	CmdLines [][][]byte
+}
+
+func makePropagateReply(reply resp.Reply, cmdLines ...[][]byte) *PropagateReply {
+	return &PropagateReply{Reply: reply, CmdLines: cmdLines}
 }
 
 // 全局命令注册表
@@ -26,7 +53,37 @@ func RegisterCommand(cmd *Command) {
 		Name:     cmd.Name,
 		Executor: cmd.Executor,
 		Arity:    cmd.Arity,
+		FirstKey: cmd.FirstKey,
+		LastKey:  cmd.LastKey,
+		KeyStep:  cmd.KeyStep,
+
+		Keys:      cmd.Keys,
+		Translate: cmd.Translate,
+	}
+}
+
+// GetKeys 按照声明的 key 位置从命令行中取出所有 key
+func (cmd Command) GetKeys(cmdLine [][]byte) []string {
+	if cmd.Keys != nil {
+		return cmd.Keys(cmdLine)
+	}
+	if cmd.FirstKey <= 0 || cmd.KeyStep <= 0 {
+		return nil
 	}
+
+	last := cmd.LastKey
+	if last < 0 {
+		last = len(cmdLine) + last
+	}
+	if last >= len(cmdLine) {
+		last = len(cmdLine) - 1
+	}
+
+	keys := make([]string, 0, (last-cmd.FirstKey)/cmd.KeyStep+1)
+	for i := cmd.FirstKey; i <= last; i += cmd.KeyStep {
+		keys = append(keys, string(cmdLine[i]))
+	}
+	return keys
 }
 
 func execDel(db types.Database, args [][]byte) resp.Reply {
@@ -45,25 +102,143 @@ func execDel(db types.Database, args [][]byte) resp.Reply {
 	return resp.MakeIntReply(int64(deleted))
 }
 
-func execExpire(db types.Database, args [][]byte) resp.Reply {
-	key := string(args[0])
-	seconds, err := strconv.Atoi(string(args[1]))
-	if err != nil {
-		return resp.MakeErrReply("ERR invalid expire time")
+func execFlushDB(db types.Database, args [][]byte) resp.Reply {
+	db.Clear()
+	return resp.MakeOkReply()
+}
+
+// typeName 返回 TYPE 命令显示的类型名
+func typeName(entity *types.DataEntity) string {
+	switch entity.Data.(type) {
+	case data.String:
+		return "string"
+	case data.List:
+		return "list"
+	case data.Hash:
+		return "hash"
+	case data.Set:
+		return "set"
+	case data.ZSetInterface:
+		return "zset"
+	case *data.Stream:
+		return "stream"
+	default:
+		return "none"
+	}
+}
+
+// EXISTS key [key ...]，重复的 key 重复计数
+func execExists(db types.Database, args [][]byte) resp.Reply {
+	count := 0
+	for _, arg := range args {
+		if _, exists := db.GetEntity(string(arg)); exists {
+			count++
+		}
 	}
+	return resp.MakeIntReply(int64(count))
+}
 
-	_, exists := db.GetEntity(key)
+// TYPE key
+func execType(db types.Database, args [][]byte) resp.Reply {
+	entity, exists := db.GetEntity(string(args[0]))
 	if !exists {
-		return resp.MakeIntReply(0)
+		return resp.MakeSimpleStringReply("none")
+	}
+	return resp.MakeSimpleStringReply(typeName(entity))
+}
+
+// KEYS pattern
+func execKeys(db types.Database, args [][]byte) resp.Reply {
+	pattern := string(args[0])
+	keys := make([][]byte, 0)
+	db.ForEach(func(key string, _ types.RedisData) {
+		if !db.IsExpired(key) && wildcard.Match(pattern, key) {
+			keys = append(keys, []byte(key))
+		}
+	})
+	return resp.MakeMultiBulkReply(keys)
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func execScan(db types.Database, args [][]byte) resp.Reply {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return resp.MakeErrReply("ERR invalid cursor")
+	}
+
+	pattern, count, typ := "*", 10, ""
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return resp.MakeErrReply("ERR syntax error")
+		}
+		value := string(args[i+1])
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			pattern = value
+		case "count":
+			count, err = strconv.Atoi(value)
+			if err != nil {
+				return resp.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count < 1 {
+				return resp.MakeErrReply("ERR syntax error")
+			}
+		case "type":
+			typ = strings.ToLower(value)
+		default:
+			return resp.MakeErrReply("ERR syntax error")
+		}
+	}
+
+	// 超出范围的游标视为遍历结束
+	next := 0
+	var keys []string
+	if cursor <= math.MaxInt32 {
+		keys, next = db.Scan(int(cursor), count)
+	}
+
+	matched := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		if db.IsExpired(key) || !wildcard.Match(pattern, key) {
+			continue
+		}
+		if typ != "" {
+			entity, exists := db.GetEntity(key)
+			if !exists || typeName(entity) != typ {
+				continue
+			}
+		}
+		matched = append(matched, []byte(key))
 	}
 
-	if seconds <= 0 {
-		db.Remove(key)
-		return resp.MakeIntReply(1)
+	return resp.MakeMultiRawReply([]resp.Reply{
+		resp.MakeBulkReply([]byte(strconv.Itoa(next))),
+		resp.MakeMultiBulkReply(matched),
+	})
+}
+
+// RANDOMKEY
+func execRandomKey(db types.Database, args [][]byte) resp.Reply {
+	key, ok := db.RandomKey()
+	if !ok {
+		return resp.MakeNullBulkReply()
 	}
+	return resp.MakeBulkReply([]byte(key))
+}
 
-	expireAt := time.Now().Add(time.Duration(seconds) * time.Second)
-	db.SetExpire(key, expireAt)
+// DBSIZE
+func execDBSize(db types.Database, args [][]byte) resp.Reply {
+	return resp.MakeIntReply(int64(db.Len()))
+}
 
-	return resp.MakeIntReply(1)
+// PING [message]
+func execPing(db types.Database, args [][]byte) resp.Reply {
+	switch len(args) {
+	case 0:
+		return resp.MakeSimpleStringReply("PONG")
+	case 1:
+		return resp.MakeBulkReply(args[0])
+	default:
+		return resp.MakeArgNumErrReply("ping")
+	}
 }
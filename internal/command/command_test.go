@@ -3,6 +3,8 @@ package command
 import (
 	"goredis/internal/resp"
 	"goredis/internal/types"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 )
@@ -132,3 +134,149 @@ func TestRegisterCommand(t *testing.T) {
 		t.Error("executor did not return OK")
 	}
 }
+
+func TestCommandGetKeys(t *testing.T) {
+	toCmdLine := func(args ...string) [][]byte {
+		cmdLine := make([][]byte, len(args))
+		for i, arg := range args {
+			cmdLine[i] = []byte(arg)
+		}
+		return cmdLine
+	}
+
+	tests := []struct {
+		name    string
+		cmdLine [][]byte
+		want    []string
+	}{
+		{"set", toCmdLine("set", "k", "v", "ex", "10"), []string{"k"}},
+		{"del", toCmdLine("del", "a", "b", "c"), []string{"a", "b", "c"}},
+		{"mset", toCmdLine("mset", "a", "1", "b", "2"), []string{"a", "b"}},
+		{"flushdb", toCmdLine("flushdb"), nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd, ok := GetCmd(tc.name)
+			if !ok {
+				t.Fatalf("command %s not registered", tc.name)
+			}
+			got := cmd.GetKeys(tc.cmdLine)
+			if len(got) != len(tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("at %d: expected %q, got %q", i, tc.want[i], got[i])
+				}
+			}
+		})
+	}
+
+	if _, ok := GetCmd("nosuchcmd"); ok {
+		t.Error("unknown command should not be found")
+	}
+}
+
+func TestKeyspaceCommands(t *testing.T) {
+	args := func(strs ...string) [][]byte {
+		res := make([][]byte, len(strs))
+		for i, s := range strs {
+			res[i] = []byte(s)
+		}
+		return res
+	}
+	sortedKeys := func(reply resp.Reply) string {
+		var keys []string
+		for _, k := range getMultiBulkValues(t, reply) {
+			keys = append(keys, string(k))
+		}
+		sort.Strings(keys)
+		return strings.Join(keys, ",")
+	}
+
+	db := NewMockDB()
+	execSet(db, args("str", "v"))
+	execRPush(db, args("list", "a"))
+	execHSet(db, args("hash", "f", "v"))
+	execSAdd(db, args("set", "m"))
+	execZAdd(db, args("zset", "1", "m"))
+	execSet(db, args("expired", "v"))
+	db.SetExpire("expired", time.Now().Add(-time.Second))
+
+	t.Run("EXISTS", func(t *testing.T) {
+		assertIntReply(t, execExists(db, args("str", "list", "str", "missing", "expired")), 3)
+	})
+
+	t.Run("TYPE", func(t *testing.T) {
+		for key, typ := range map[string]string{
+			"str": "string", "list": "list", "hash": "hash", "set": "set", "zset": "zset", "missing": "none", "expired": "none",
+		} {
+			if got := execType(db, args(key)).(*resp.SimpleStringReply).Status; got != typ {
+				t.Errorf("TYPE %s = %s, want %s", key, got, typ)
+			}
+		}
+	})
+
+	t.Run("KEYS", func(t *testing.T) {
+		if got := sortedKeys(execKeys(db, args("*"))); got != "hash,list,set,str,zset" {
+			t.Errorf("KEYS * = %s", got)
+		}
+		if got := sortedKeys(execKeys(db, args("[hl]*"))); got != "hash,list" {
+			t.Errorf("KEYS [hl]* = %s", got)
+		}
+		if got := sortedKeys(execKeys(db, args("?set"))); got != "zset" {
+			t.Errorf("KEYS ?set = %s", got)
+		}
+	})
+
+	t.Run("SCAN", func(t *testing.T) {
+		var all []string
+		cursor := "0"
+		for {
+			reply := execScan(db, args(cursor, "COUNT", "2")).(*resp.MultiRawReply)
+			cursor = string(reply.Replies[0].(*resp.BulkReply).Arg)
+			for _, k := range getMultiBulkValues(t, reply.Replies[1]) {
+				all = append(all, string(k))
+			}
+			if cursor == "0" {
+				break
+			}
+		}
+		sort.Strings(all)
+		if strings.Join(all, ",") != "hash,list,set,str,zset" {
+			t.Errorf("full SCAN returned %v", all)
+		}
+
+		reply := execScan(db, args("0", "MATCH", "*s*", "TYPE", "SET", "COUNT", "100")).(*resp.MultiRawReply)
+		if got := sortedKeys(reply.Replies[1]); got != "set" {
+			t.Errorf("SCAN MATCH TYPE = %s", got)
+		}
+
+		assertErrorReply(t, execScan(db, args("abc")), "invalid cursor")
+		assertErrorReply(t, execScan(db, args("0", "COUNT", "0")), "syntax error")
+		assertErrorReply(t, execScan(db, args("0", "MATCH")), "syntax error")
+		assertErrorReply(t, execScan(db, args("0", "FOO", "bar")), "syntax error")
+	})
+
+	t.Run("RANDOMKEY and DBSIZE", func(t *testing.T) {
+		key := execRandomKey(db, nil).(*resp.BulkReply).Arg
+		if key == nil || string(key) == "expired" {
+			t.Errorf("unexpected random key %q", key)
+		}
+		// DBSIZE 包含还没被删除的过期 key
+		assertIntReply(t, execDBSize(db, nil), 6)
+
+		empty := NewMockDB()
+		assertBulkReply(t, execRandomKey(empty, nil), nil)
+		assertIntReply(t, execDBSize(empty, nil), 0)
+	})
+
+	t.Run("PING", func(t *testing.T) {
+		if reply, ok := execPing(db, nil).(*resp.SimpleStringReply); !ok || reply.Status != "PONG" {
+			t.Errorf("expected PONG, got %v", reply)
+		}
+		assertBulkReply(t, execPing(db, args("hello")), []byte("hello"))
+		assertErrorReply(t, execPing(db, args("a", "b")), "wrong number of arguments")
+	})
+}
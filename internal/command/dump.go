@@ -0,0 +1,91 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"goredis/internal/persistant"
+	"goredis/internal/resp"
+	"goredis/internal/types"
+)
+
+// DUMP key，返回序列化的值，格式见 persistant.DumpValue
+func execDump(db types.Database, args [][]byte) resp.Reply {
+	entity, exists := db.GetEntity(string(args[0]))
+	if !exists {
+		return resp.MakeNullBulkReply()
+	}
+	value, _ := entity.Data.(types.RedisData)
+	payload, err := persistant.DumpValue(value)
+	if err != nil {
+		return resp.MakeErrReply("ERR " + err.Error())
+	}
+	return resp.MakeBulkReply(payload)
+}
+
+// RESTORE key ttl serialized-value [REPLACE] [ABSTTL]
+// ttl 为 0 表示不过期；带 ABSTTL 时 ttl 是毫秒级的 unix 时间，已经过去时不创建 key
+func execRestore(db types.Database, args [][]byte) resp.Reply {
+	key := string(args[0])
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return resp.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return resp.MakeErrReply("ERR Invalid TTL value, must be >= 0")
+	}
+	replace, absTTL := false, false
+	for _, arg := range args[3:] {
+		switch strings.ToLower(string(arg)) {
+		case "replace":
+			replace = true
+		case "absttl":
+			absTTL = true
+		default:
+			return resp.MakeErrReply("ERR syntax error")
+		}
+	}
+
+	if _, exists := db.GetEntity(key); exists && !replace {
+		return resp.MakeErrReply("BUSYKEY Target key name already exists.")
+	}
+	entity, err := persistant.RestoreValue(args[2])
+	if err != nil {
+		return resp.MakeErrReply("ERR " + err.Error())
+	}
+
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		if absTTL {
+			expireAt = time.UnixMilli(ttl)
+		}
+	}
+	db.Remove(key)
+	if !expireAt.IsZero() && !expireAt.After(time.Now()) {
+		return resp.MakeOkReply()
+	}
+	db.PutEntity(key, entity)
+	if !expireAt.IsZero() {
+		db.SetExpire(key, expireAt)
+	}
+	return resp.MakeOkReply()
+}
+
+// translateRestore 把相对的 ttl 换算成 ABSTTL，重放时不会延长 key 的生命周期
+func translateRestore(cmdLine [][]byte) [][]byte {
+	ttl, err := strconv.ParseInt(string(cmdLine[2]), 10, 64)
+	if err != nil || ttl <= 0 {
+		return cmdLine
+	}
+	for _, arg := range cmdLine[4:] {
+		if strings.EqualFold(string(arg), "absttl") {
+			return cmdLine
+		}
+	}
+	expireAt := time.Now().Add(time.Duration(ttl) * time.Millisecond).UnixMilli()
+	translated := [][]byte{cmdLine[0], cmdLine[1], []byte(strconv.FormatInt(expireAt, 10)), cmdLine[3]}
+	translated = append(translated, cmdLine[4:]...)
+	return append(translated, []byte("ABSTTL"))
+}
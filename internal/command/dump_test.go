@@ -0,0 +1,83 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"goredis/internal/data"
+	"goredis/internal/types"
+)
+
+func TestDumpRestore(t *testing.T) {
+	db := NewMockDB()
+	list := data.NewQuickList()
+	list.PushBack([]byte("a"))
+	list.PushBack([]byte("b"))
+	db.PutEntity("list", &types.DataEntity{Data: list})
+
+	dumped := getBulkValue(t, execDump(db, toArgs("list")))
+
+	t.Run("DUMP missing key", func(t *testing.T) {
+		assertBulkReply(t, execDump(db, toArgs("missing")), nil)
+	})
+
+	t.Run("RESTORE", func(t *testing.T) {
+		assertOKReply(t, execRestore(db, [][]byte{[]byte("copy"), []byte("0"), dumped}))
+		entity, ok := db.GetEntity("copy")
+		if !ok {
+			t.Fatal("restored key should exist")
+		}
+		if got := entity.Data.(*data.QuickList).Range(0, -1); len(got) != 2 || string(got[1]) != "b" {
+			t.Errorf("unexpected list %q", got)
+		}
+		if _, hasTTL := db.GetExpireTime("copy"); hasTTL {
+			t.Error("ttl 0 should not set an expire time")
+		}
+
+		assertErrorReply(t, execRestore(db, [][]byte{[]byte("copy"), []byte("0"), dumped}), "BUSYKEY")
+		assertOKReply(t, execRestore(db, [][]byte{[]byte("copy"), []byte("10000"), dumped, []byte("replace")}))
+		if pttl := getIntValue(t, execPTTL(db, toArgs("copy"))); pttl <= 9000 || pttl > 10000 {
+			t.Errorf("unexpected PTTL %d", pttl)
+		}
+	})
+
+	t.Run("RESTORE ABSTTL", func(t *testing.T) {
+		at := time.Now().Add(time.Hour).UnixMilli()
+		assertOKReply(t, execRestore(db, [][]byte{[]byte("abs"), []byte(strconv.FormatInt(at, 10)), dumped, []byte("ABSTTL")}))
+		assertEqualInt(t, execPExpireTime(db, toArgs("abs")), at)
+
+		// 已经过期时不创建 key，REPLACE 时删除原来的 key
+		assertOKReply(t, execRestore(db, [][]byte{[]byte("abs"), []byte("1"), dumped, []byte("replace"), []byte("absttl")}))
+		assertEqualInt(t, execTTL(db, toArgs("abs")), -2)
+	})
+
+	t.Run("RESTORE errors", func(t *testing.T) {
+		assertErrorReply(t, execRestore(db, [][]byte{[]byte("bad"), []byte("0"), []byte("garbage")}), "checksum")
+		assertErrorReply(t, execRestore(db, [][]byte{[]byte("bad"), []byte("-1"), dumped}), "Invalid TTL")
+		assertErrorReply(t, execRestore(db, [][]byte{[]byte("bad"), []byte("x"), dumped}), "not an integer")
+		assertErrorReply(t, execRestore(db, [][]byte{[]byte("bad"), []byte("0"), dumped, []byte("idle")}), "syntax")
+		if _, ok := db.GetEntity("bad"); ok {
+			t.Error("failed RESTORE should not create the key")
+		}
+	})
+
+	t.Run("translate to ABSTTL", func(t *testing.T) {
+		before := time.Now().UnixMilli()
+		line := translateRestore([][]byte{[]byte("restore"), []byte("k"), []byte("5000"), dumped, []byte("REPLACE")})
+		if len(line) != 6 || !strings.EqualFold(string(line[5]), "absttl") || string(line[4]) != "REPLACE" {
+			t.Fatalf("unexpected translation %q", line[4:])
+		}
+		if at, _ := strconv.ParseInt(string(line[2]), 10, 64); at < before+5000 || at > time.Now().UnixMilli()+5000 {
+			t.Errorf("unexpected absolute ttl %d", at)
+		}
+
+		for _, ttl := range []string{"0", "x"} {
+			line := [][]byte{[]byte("restore"), []byte("k"), []byte(ttl), dumped}
+			if got := translateRestore(line); len(got) != 4 || string(got[2]) != ttl {
+				t.Errorf("ttl %s should not be translated: %q", ttl, got[2])
+			}
+		}
+	})
+}
@@ -0,0 +1,513 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"goredis/internal/data"
+	"goredis/internal/resp"
+	"goredis/internal/types"
+	"goredis/pkg/geohash"
+)
+
+// 距离单位换算为米
+var geoUnits = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"ft": 0.3048,
+	"mi": 1609.34,
+}
+
+func parseGeoUnit(arg []byte) (float64, resp.Reply) {
+	conversion, ok := geoUnits[strings.ToLower(string(arg))]
+	if !ok {
+		return 0, resp.MakeErrReply("ERR unsupported unit provided. please use M, KM, FT, MI")
+	}
+	return conversion, nil
+}
+
+// parseLongLat 解析并校验经纬度
+func parseLongLat(longArg, latArg []byte) (float64, float64, resp.Reply) {
+	longitude, err1 := strconv.ParseFloat(string(longArg), 64)
+	latitude, err2 := strconv.ParseFloat(string(latArg), 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, resp.MakeErrReply("ERR value is not a valid float")
+	}
+	if !geohash.Valid(longitude, latitude) {
+		return 0, 0, resp.MakeErrReply(fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", longitude, latitude))
+	}
+	return longitude, latitude, nil
+}
+
+// formatCoord 与 Redis 一样输出 17 位小数并去掉末尾的 0
+func formatCoord(v float64) []byte {
+	s := strconv.FormatFloat(v, 'f', 17, 64)
+	s = strings.TrimRight(s, "0")
+	s = strings.TrimSuffix(s, ".")
+	return []byte(s)
+}
+
+func formatDistance(dist, conversion float64) []byte {
+	return []byte(strconv.FormatFloat(dist/conversion, 'f', 4, 64))
+}
+
+func makeCoordReply(longitude, latitude float64) resp.Reply {
+	return resp.MakeMultiBulkReply([][]byte{formatCoord(longitude), formatCoord(latitude)})
+}
+
+// memberLongLat 从成员的分值还原经纬度
+func memberLongLat(zs *data.ZSet, member []byte) (float64, float64, bool) {
+	score, ok := zs.ZScore(member)
+	if !ok {
+		return 0, 0, false
+	}
+	longitude, latitude := geohash.DecodeScore(uint64(score))
+	return longitude, latitude, true
+}
+
+// GEOADD key [NX|XX] [CH] longitude latitude member [longitude latitude member ...]
+func execGeoAdd(db types.Database, args [][]byte) resp.Reply {
+	key := string(args[0])
+	var nx, xx, ch bool
+	i := 1
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		if opt == "nx" {
+			nx = true
+		} else if opt == "xx" {
+			xx = true
+		} else if opt == "ch" {
+			ch = true
+		} else {
+			break
+		}
+	}
+	if (len(args)-i)%3 != 0 || i == len(args) {
+		return resp.MakeErrReply("ERR syntax error")
+	}
+	if nx && xx {
+		return resp.MakeErrReply("ERR XX and NX options at the same time are not compatible")
+	}
+
+	// 先全部校验，避免部分写入
+	scores := make([]float64, 0, (len(args)-i)/3)
+	for j := i; j < len(args); j += 3 {
+		longitude, latitude, errReply := parseLongLat(args[j], args[j+1])
+		if errReply != nil {
+			return errReply
+		}
+		scores = append(scores, float64(geohash.EncodeScore(longitude, latitude)))
+	}
+
+	zs, exists, errReply := getZSet(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if !exists {
+		if xx {
+			return resp.MakeIntReply(0)
+		}
+		zs = data.NewZSet()
+	}
+
+	added, changed := 0, 0
+	for n, j := 0, i; j < len(args); n, j = n+1, j+3 {
+		member := args[j+2]
+		oldScore, had := zs.ZScore(member)
+		if zs.ZAdd(nx, xx, scores[n], member) > 0 {
+			added++
+			changed++
+		} else if had && !nx && oldScore != scores[n] {
+			changed++
+		}
+	}
+	if !exists && zs.ZCard() > 0 {
+		db.PutEntity(key, &types.DataEntity{Data: zs})
+	}
+
+	if ch {
+		return resp.MakeIntReply(int64(changed))
+	}
+	return resp.MakeIntReply(int64(added))
+}
+
+// GEOPOS key [member ...]
+func execGeoPos(db types.Database, args [][]byte) resp.Reply {
+	zs, _, errReply := getZSet(db, string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	replies := make([]resp.Reply, 0, len(args)-1)
+	for _, member := range args[1:] {
+		if zs == nil {
+			replies = append(replies, resp.MakeNullMultiBulkReply())
+			continue
+		}
+		longitude, latitude, ok := memberLongLat(zs, member)
+		if !ok {
+			replies = append(replies, resp.MakeNullMultiBulkReply())
+			continue
+		}
+		replies = append(replies, makeCoordReply(longitude, latitude))
+	}
+	return resp.MakeMultiRawReply(replies)
+}
+
+// GEODIST key member1 member2 [M|KM|FT|MI]
+func execGeoDist(db types.Database, args [][]byte) resp.Reply {
+	conversion := 1.0
+	if len(args) == 4 {
+		var errReply resp.Reply
+		if conversion, errReply = parseGeoUnit(args[3]); errReply != nil {
+			return errReply
+		}
+	} else if len(args) > 4 {
+		return resp.MakeErrReply("ERR syntax error")
+	}
+
+	zs, exists, errReply := getZSet(db, string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if !exists {
+		return resp.MakeNullBulkReply()
+	}
+	long1, lat1, ok1 := memberLongLat(zs, args[1])
+	long2, lat2, ok2 := memberLongLat(zs, args[2])
+	if !ok1 || !ok2 {
+		return resp.MakeNullBulkReply()
+	}
+	return resp.MakeBulkReply(formatDistance(geohash.Distance(long1, lat1, long2, lat2), conversion))
+}
+
+// GEOHASH key [member ...]
+func execGeoHash(db types.Database, args [][]byte) resp.Reply {
+	zs, _, errReply := getZSet(db, string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	hashes := make([][]byte, 0, len(args)-1)
+	for _, member := range args[1:] {
+		if zs == nil {
+			hashes = append(hashes, nil)
+			continue
+		}
+		score, ok := zs.ZScore(member)
+		if !ok {
+			hashes = append(hashes, nil)
+			continue
+		}
+		hashes = append(hashes, []byte(geohash.String(uint64(score))))
+	}
+	return resp.MakeMultiBulkReply(hashes)
+}
+
+// geoSearchOptions GEOSEARCH 和 GEOSEARCHSTORE 的公共参数
+type geoSearchOptions struct {
+	fromMember []byte
+	fromLonLat bool
+	shape      geohash.Shape
+	hasShape   bool
+	conversion float64 // 用户指定单位到米的换算
+
+	sort      int // 0 不排序，1 升序，-1 降序
+	count     int
+	any       bool
+	withCoord bool
+	withDist  bool
+	withHash  bool
+	storeDist bool
+}
+
+// geoPoint 一个搜索结果
+type geoPoint struct {
+	member    []byte
+	score     float64
+	dist      float64 // 米
+	longitude float64
+	latitude  float64
+}
+
+// parseGeoSearchArgs 解析 key 之后的参数，store 为 true 时不允许 WITH* 选项
+func parseGeoSearchArgs(args [][]byte, store bool) (*geoSearchOptions, resp.Reply) {
+	opts := &geoSearchOptions{}
+	syntaxErr := resp.MakeErrReply("ERR syntax error")
+	for i := 0; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch strings.ToLower(string(args[i])) {
+		case "frommember":
+			if remaining < 1 {
+				return nil, syntaxErr
+			}
+			if opts.fromMember != nil || opts.fromLonLat {
+				return nil, resp.MakeErrReply("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+			}
+			opts.fromMember = args[i+1]
+			i++
+		case "fromlonlat":
+			if remaining < 2 {
+				return nil, syntaxErr
+			}
+			if opts.fromMember != nil || opts.fromLonLat {
+				return nil, resp.MakeErrReply("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+			}
+			longitude, latitude, errReply := parseLongLat(args[i+1], args[i+2])
+			if errReply != nil {
+				return nil, errReply
+			}
+			opts.fromLonLat = true
+			opts.shape.Longitude, opts.shape.Latitude = longitude, latitude
+			i += 2
+		case "byradius":
+			if remaining < 2 {
+				return nil, syntaxErr
+			}
+			if opts.hasShape {
+				return nil, resp.MakeErrReply("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+			}
+			radius, err := strconv.ParseFloat(string(args[i+1]), 64)
+			if err != nil {
+				return nil, resp.MakeErrReply("ERR need numeric radius")
+			}
+			if radius < 0 {
+				return nil, resp.MakeErrReply("ERR radius cannot be negative")
+			}
+			conversion, errReply := parseGeoUnit(args[i+2])
+			if errReply != nil {
+				return nil, errReply
+			}
+			opts.hasShape = true
+			opts.conversion = conversion
+			opts.shape.Radius = radius * conversion
+			i += 2
+		case "bybox":
+			if remaining < 3 {
+				return nil, syntaxErr
+			}
+			if opts.hasShape {
+				return nil, resp.MakeErrReply("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+			}
+			width, err1 := strconv.ParseFloat(string(args[i+1]), 64)
+			height, err2 := strconv.ParseFloat(string(args[i+2]), 64)
+			if err1 != nil || err2 != nil {
+				return nil, resp.MakeErrReply("ERR need numeric width or height")
+			}
+			if width < 0 || height < 0 {
+				return nil, resp.MakeErrReply("ERR height or width cannot be negative")
+			}
+			conversion, errReply := parseGeoUnit(args[i+3])
+			if errReply != nil {
+				return nil, errReply
+			}
+			opts.hasShape = true
+			opts.conversion = conversion
+			opts.shape.IsBox = true
+			opts.shape.Width, opts.shape.Height = width*conversion, height*conversion
+			i += 3
+		case "asc":
+			opts.sort = 1
+		case "desc":
+			opts.sort = -1
+		case "count":
+			if remaining < 1 {
+				return nil, syntaxErr
+			}
+			count, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, resp.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count <= 0 {
+				return nil, resp.MakeErrReply("ERR COUNT must be > 0")
+			}
+			opts.count = int(count)
+			i++
+			if remaining >= 2 && strings.EqualFold(string(args[i+1]), "any") {
+				opts.any = true
+				i++
+			}
+		case "withcoord":
+			if store {
+				return nil, syntaxErr
+			}
+			opts.withCoord = true
+		case "withdist":
+			if store {
+				return nil, syntaxErr
+			}
+			opts.withDist = true
+		case "withhash":
+			if store {
+				return nil, syntaxErr
+			}
+			opts.withHash = true
+		case "storedist":
+			if !store {
+				return nil, syntaxErr
+			}
+			opts.storeDist = true
+		default:
+			return nil, syntaxErr
+		}
+	}
+
+	if opts.fromMember == nil && !opts.fromLonLat {
+		return nil, resp.MakeErrReply("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+	}
+	if !opts.hasShape {
+		return nil, resp.MakeErrReply("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+	}
+	if opts.any && opts.count == 0 {
+		return nil, resp.MakeErrReply("ERR the ANY argument requires COUNT argument")
+	}
+	// 指定 COUNT 但未指定顺序时，取最近的 count 个
+	if opts.count > 0 && !opts.any && opts.sort == 0 {
+		opts.sort = 1
+	}
+	return opts, nil
+}
+
+// geoSearch 扫描中心和周围区域对应的分值区间，返回位于搜索范围内的成员
+func geoSearch(zs *data.ZSet, opts *geoSearchOptions) ([]*geoPoint, resp.Reply) {
+	if opts.fromMember != nil {
+		longitude, latitude, ok := memberLongLat(zs, opts.fromMember)
+		if !ok {
+			return nil, resp.MakeErrReply("ERR could not decode requested zset member")
+		}
+		opts.shape.Longitude, opts.shape.Latitude = longitude, latitude
+	}
+
+	var points []*geoPoint
+	visited := make(map[geohash.Bits]struct{})
+	for _, area := range opts.shape.SearchAreas() {
+		if area.IsZero() {
+			continue
+		}
+		// 精度较低时相邻区域可能重合
+		if _, ok := visited[area]; ok {
+			continue
+		}
+		visited[area] = struct{}{}
+
+		min, max := area.ScoreRange()
+		members, scores := zs.ZRangeByScore(float64(min), float64(max))
+		for n, member := range members {
+			// 区间右开
+			if scores[n] >= float64(max) {
+				continue
+			}
+			longitude, latitude := geohash.DecodeScore(uint64(scores[n]))
+			dist, ok := opts.shape.Contains(longitude, latitude)
+			if !ok {
+				continue
+			}
+			points = append(points, &geoPoint{
+				member:    member,
+				score:     scores[n],
+				dist:      dist,
+				longitude: longitude,
+				latitude:  latitude,
+			})
+			if opts.any && len(points) >= opts.count {
+				return points, nil
+			}
+		}
+	}
+
+	if opts.sort != 0 {
+		sort.SliceStable(points, func(i, j int) bool {
+			if opts.sort > 0 {
+				return points[i].dist < points[j].dist
+			}
+			return points[i].dist > points[j].dist
+		})
+	}
+	if opts.count > 0 && len(points) > opts.count {
+		points = points[:opts.count]
+	}
+	return points, nil
+}
+
+// GEOSEARCH key FROMMEMBER member|FROMLONLAT longitude latitude BYRADIUS radius unit|BYBOX width height unit
+// [ASC|DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+func execGeoSearch(db types.Database, args [][]byte) resp.Reply {
+	opts, errReply := parseGeoSearchArgs(args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+	zs, exists, errReply := getZSet(db, string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if !exists {
+		return resp.MakeMultiBulkReply(nil)
+	}
+	points, errReply := geoSearch(zs, opts)
+	if errReply != nil {
+		return errReply
+	}
+
+	if !opts.withCoord && !opts.withDist && !opts.withHash {
+		members := make([][]byte, len(points))
+		for i, p := range points {
+			members[i] = p.member
+		}
+		return resp.MakeMultiBulkReply(members)
+	}
+
+	replies := make([]resp.Reply, len(points))
+	for i, p := range points {
+		item := []resp.Reply{resp.MakeBulkReply(p.member)}
+		if opts.withDist {
+			item = append(item, resp.MakeBulkReply(formatDistance(p.dist, opts.conversion)))
+		}
+		if opts.withHash {
+			item = append(item, resp.MakeIntReply(int64(p.score)))
+		}
+		if opts.withCoord {
+			item = append(item, makeCoordReply(p.longitude, p.latitude))
+		}
+		replies[i] = resp.MakeMultiRawReply(item)
+	}
+	return resp.MakeMultiRawReply(replies)
+}
+
+// GEOSEARCHSTORE destination source FROMMEMBER member|FROMLONLAT longitude latitude
+// BYRADIUS radius unit|BYBOX width height unit [ASC|DESC] [COUNT count [ANY]] [STOREDIST]
+func execGeoSearchStore(db types.Database, args [][]byte) resp.Reply {
+	destKey := string(args[0])
+	opts, errReply := parseGeoSearchArgs(args[2:], true)
+	if errReply != nil {
+		return errReply
+	}
+	zs, exists, errReply := getZSet(db, string(args[1]))
+	if errReply != nil {
+		return errReply
+	}
+	var points []*geoPoint
+	if exists {
+		if points, errReply = geoSearch(zs, opts); errReply != nil {
+			return errReply
+		}
+	}
+
+	// 没有结果时删除目标 key
+	if len(points) == 0 {
+		db.Remove(destKey)
+		return resp.MakeIntReply(0)
+	}
+
+	dest := data.NewZSet()
+	for _, p := range points {
+		score := p.score
+		if opts.storeDist {
+			// 按用户指定的单位保存距离
+			score = p.dist / opts.conversion
+		}
+		dest.ZAdd(false, false, score, p.member)
+	}
+	db.Remove(destKey)
+	db.PutEntity(destKey, &types.DataEntity{Data: dest})
+	return resp.MakeIntReply(int64(len(points)))
+}
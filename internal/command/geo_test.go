@@ -0,0 +1,133 @@
+package command
+
+import (
+	"testing"
+
+	"goredis/internal/types"
+)
+
+func TestGeoCommands(t *testing.T) {
+	db := NewMockDB()
+	assertEqualInt(t, execGeoAdd(db, toArgs("Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania")), 2)
+
+	t.Run("GEOADD", func(t *testing.T) {
+		// 分值与 Redis 一致
+		assertEqualBulk(t, execZScore(db, toArgs("Sicily", "Palermo")), []byte("3479099956230698"))
+
+		assertEqualInt(t, execGeoAdd(db, toArgs("Sicily", "NX", "13", "38", "Palermo")), 0)
+		assertEqualInt(t, execGeoAdd(db, toArgs("Sicily", "XX", "13", "38", "Foo")), 0)
+		assertEqualInt(t, execGeoAdd(db, toArgs("Sicily", "CH", "13.361389", "38.115556", "Palermo", "13", "38", "Foo")), 1)
+		assertEqualInt(t, execGeoAdd(db, toArgs("Sicily", "XX", "CH", "13.1", "38", "Foo")), 1)
+		execZRem(db, toArgs("Sicily", "Foo"))
+		assertEqualInt(t, execGeoAdd(db, toArgs("missing", "XX", "13", "38", "Foo")), 0)
+		if _, exists := db.GetEntity("missing"); exists {
+			t.Error("GEOADD XX should not create the key")
+		}
+
+		for _, tc := range []struct {
+			args []string
+			err  string
+		}{
+			{[]string{"Sicily", "181", "0", "a"}, "ERR invalid longitude,latitude pair 181.000000,0.000000"},
+			{[]string{"Sicily", "0", "86", "a"}, "ERR invalid longitude,latitude pair 0.000000,86.000000"},
+			{[]string{"Sicily", "x", "0", "a"}, "ERR value is not a valid float"},
+			{[]string{"Sicily", "NX", "XX", "0", "0", "a"}, "ERR XX and NX options at the same time are not compatible"},
+			{[]string{"Sicily", "0", "0"}, "ERR syntax error"},
+		} {
+			if msg := getErrorString(t, execGeoAdd(db, toArgs(tc.args...))); msg != tc.err {
+				t.Errorf("%v: got %q, want %q", tc.args, msg, tc.err)
+			}
+		}
+	})
+
+	t.Run("GEOPOS GEODIST GEOHASH", func(t *testing.T) {
+		if got := string(execGeoPos(db, toArgs("Sicily", "Palermo", "NonExisting")).ToBytes()); got != "*2\r\n*2\r\n$20\r\n13.36138933897018433\r\n$20\r\n38.11555639549629859\r\n*-1\r\n" {
+			t.Errorf("unexpected reply %q", got)
+		}
+		assertEqualBulk(t, execGeoDist(db, toArgs("Sicily", "Palermo", "Catania")), []byte("166274.1516"))
+		assertEqualBulk(t, execGeoDist(db, toArgs("Sicily", "Palermo", "Catania", "km")), []byte("166.2742"))
+		assertEqualBulk(t, execGeoDist(db, toArgs("Sicily", "Palermo", "Catania", "MI")), []byte("103.3182"))
+		assertEqualBulk(t, execGeoDist(db, toArgs("Sicily", "Foo", "Bar")), nil)
+		if msg := getErrorString(t, execGeoDist(db, toArgs("Sicily", "Palermo", "Catania", "yd"))); msg != "ERR unsupported unit provided. please use M, KM, FT, MI" {
+			t.Errorf("unexpected error %s", msg)
+		}
+
+		assertEqualMultiBulk(t, execGeoHash(db, toArgs("Sicily", "Palermo", "Catania", "Foo")), [][]byte{[]byte("sqc8b49rny0"), []byte("sqdtr74hyu0"), nil})
+	})
+
+	t.Run("GEOSEARCH", func(t *testing.T) {
+		execGeoAdd(db, toArgs("Sicily", "12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2"))
+
+		assertEqualMultiBulk(t, execGeoSearch(db, toArgs("Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC")),
+			[][]byte{[]byte("Catania"), []byte("Palermo")})
+		assertEqualMultiBulk(t, execGeoSearch(db, toArgs("Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "DESC")),
+			[][]byte{[]byte("edge1"), []byte("edge2"), []byte("Palermo"), []byte("Catania")})
+		assertEqualMultiBulk(t, execGeoSearch(db, toArgs("Sicily", "FROMMEMBER", "Palermo", "BYRADIUS", "50", "km")),
+			[][]byte{[]byte("Palermo")})
+		// COUNT 未指定顺序时返回最近的
+		assertEqualMultiBulk(t, execGeoSearch(db, toArgs("Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "COUNT", "1")),
+			[][]byte{[]byte("Catania")})
+		if got := len(getMultiBulkValues(t, execGeoSearch(db, toArgs("Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "COUNT", "3", "ANY")))); got != 3 {
+			t.Errorf("expected 3 results, got %d", got)
+		}
+
+		got := string(execGeoSearch(db, toArgs("Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC", "WITHDIST", "WITHCOORD")).ToBytes())
+		want := "*2\r\n" +
+			"*3\r\n$7\r\nCatania\r\n$7\r\n56.4413\r\n*2\r\n$20\r\n15.08726745843887329\r\n$20\r\n37.50266842333162032\r\n" +
+			"*3\r\n$7\r\nPalermo\r\n$8\r\n190.4424\r\n*2\r\n$20\r\n13.36138933897018433\r\n$20\r\n38.11555639549629859\r\n"
+		if got != want {
+			t.Errorf("unexpected reply %q", got)
+		}
+
+		assertEqualMultiBulk(t, execGeoSearch(db, toArgs("missing", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km")), [][]byte{})
+	})
+
+	t.Run("GEOSEARCH errors", func(t *testing.T) {
+		for _, tc := range []struct {
+			args []string
+			err  string
+		}{
+			{[]string{"BYRADIUS", "200", "km"}, "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH"},
+			{[]string{"FROMMEMBER", "Palermo", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km"}, "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH"},
+			{[]string{"FROMLONLAT", "15", "37"}, "ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH"},
+			{[]string{"FROMLONLAT", "15", "37", "BYRADIUS", "1", "m", "BYBOX", "1", "1", "m"}, "ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH"},
+			{[]string{"FROMLONLAT", "15", "37", "BYRADIUS", "-1", "m"}, "ERR radius cannot be negative"},
+			{[]string{"FROMLONLAT", "15", "37", "BYRADIUS", "1", "m", "COUNT", "0"}, "ERR COUNT must be > 0"},
+			{[]string{"FROMLONLAT", "15", "37", "BYRADIUS", "1", "m", "ANY"}, "ERR syntax error"},
+			{[]string{"FROMLONLAT", "15", "37", "BYRADIUS", "1", "m", "STOREDIST"}, "ERR syntax error"},
+			{[]string{"FROMMEMBER", "Foo", "BYRADIUS", "1", "m"}, "ERR could not decode requested zset member"},
+		} {
+			if msg := getErrorString(t, execGeoSearch(db, toArgs(append([]string{"Sicily"}, tc.args...)...))); msg != tc.err {
+				t.Errorf("%v: got %q, want %q", tc.args, msg, tc.err)
+			}
+		}
+
+		db.PutEntity("str", &types.DataEntity{Data: "not a zset"})
+		if msg := getErrorString(t, execGeoPos(db, toArgs("str", "a"))); msg != "ERR wrong type" {
+			t.Errorf("unexpected error %s", msg)
+		}
+	})
+
+	t.Run("GEOSEARCHSTORE", func(t *testing.T) {
+		assertEqualInt(t, execGeoSearchStore(db, toArgs("dest", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km")), 2)
+		assertEqualBulk(t, execZScore(db, toArgs("dest", "Palermo")), []byte("3479099956230698"))
+		// 保存的是 geohash 分值，可以继续作为 GEO 数据使用
+		assertEqualBulk(t, execGeoDist(db, toArgs("dest", "Palermo", "Catania", "km")), []byte("166.2742"))
+
+		assertEqualInt(t, execGeoSearchStore(db, toArgs("dist", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "STOREDIST")), 2)
+		score := getBulkValue(t, execZScore(db, toArgs("dist", "Catania")))
+		if string(score[:6]) != "56.441" {
+			t.Errorf("unexpected distance %s", score)
+		}
+
+		if msg := getErrorString(t, execGeoSearchStore(db, toArgs("dest", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "WITHDIST"))); msg != "ERR syntax error" {
+			t.Errorf("unexpected error %s", msg)
+		}
+
+		// 没有结果时删除目标 key
+		assertEqualInt(t, execGeoSearchStore(db, toArgs("dest", "Sicily", "FROMLONLAT", "0", "0", "BYRADIUS", "1", "km")), 0)
+		if _, exists := db.GetEntity("dest"); exists {
+			t.Error("empty result should delete destination")
+		}
+	})
+}
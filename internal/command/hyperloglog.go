@@ -0,0 +1,180 @@
+package command
+
+import (
+	"strings"
+
+	"goredis/internal/data"
+	"goredis/internal/resp"
+	"goredis/internal/types"
+)
+
+func makeHLLErrReply(err error) resp.Reply {
+	switch err {
+	case data.ErrInvalidHLL:
+		return resp.MakeErrReply("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	case data.ErrCorruptedHLL:
+		return resp.MakeErrReply("INVALIDOBJ Corrupted HLL object detected")
+	default:
+		return resp.MakeErrReply("ERR " + err.Error())
+	}
+}
+
+// getHyperLogLog HyperLogLog 以字符串保存，key 不存在时返回 nil
+func getHyperLogLog(db types.Database, key string) (*data.SimpleString, *data.HyperLogLog, resp.Reply) {
+	str, exists, errReply := getString(db, key)
+	if errReply != nil || !exists {
+		return nil, nil, errReply
+	}
+	hll, err := str.HyperLogLog()
+	if err != nil {
+		return nil, nil, makeHLLErrReply(err)
+	}
+	return str, hll, nil
+}
+
+// PFADD key [element ...]
+func execPFAdd(db types.Database, args [][]byte) resp.Reply {
+	key := string(args[0])
+	str, hll, errReply := getHyperLogLog(db, key)
+	if errReply != nil {
+		return errReply
+	}
+
+	created := hll == nil
+	if created {
+		hll = data.NewHyperLogLog()
+		str = data.NewStringFromBytes(nil)
+	}
+	changed, err := hll.Add(args[1:]...)
+	if err != nil {
+		return makeHLLErrReply(err)
+	}
+	// 稀疏编码修改后底层的字节会被替换，需要写回
+	str.SetHyperLogLog(hll)
+	if created {
+		db.PutEntity(key, &types.DataEntity{Data: str})
+	}
+
+	if created || changed {
+		return resp.MakeIntReply(1)
+	}
+	return resp.MakeIntReply(0)
+}
+
+// PFCOUNT key [key ...]
+func execPFCount(db types.Database, args [][]byte) resp.Reply {
+	// 单个 key 时使用并更新缓存的基数
+	if len(args) == 1 {
+		_, hll, errReply := getHyperLogLog(db, string(args[0]))
+		if errReply != nil {
+			return errReply
+		}
+		if hll == nil {
+			return resp.MakeIntReply(0)
+		}
+		card, err := hll.Count()
+		if err != nil {
+			return makeHLLErrReply(err)
+		}
+		return resp.MakeIntReply(int64(card))
+	}
+
+	regs := make([]byte, data.HLLRegisters)
+	for _, arg := range args {
+		_, hll, errReply := getHyperLogLog(db, string(arg))
+		if errReply != nil {
+			return errReply
+		}
+		if hll == nil {
+			continue
+		}
+		if err := hll.MergeInto(regs); err != nil {
+			return makeHLLErrReply(err)
+		}
+	}
+	return resp.MakeIntReply(int64(data.CountRegisters(regs)))
+}
+
+// PFMERGE destkey [sourcekey ...]，destkey 已存在时也参与合并
+func execPFMerge(db types.Database, args [][]byte) resp.Reply {
+	destKey := string(args[0])
+	regs := make([]byte, data.HLLRegisters)
+	for _, arg := range args {
+		_, hll, errReply := getHyperLogLog(db, string(arg))
+		if errReply != nil {
+			return errReply
+		}
+		if hll == nil {
+			continue
+		}
+		if err := hll.MergeInto(regs); err != nil {
+			return makeHLLErrReply(err)
+		}
+	}
+
+	merged := data.HyperLogLogFromRegisters(regs)
+	dest, hll, _ := getHyperLogLog(db, destKey)
+	if hll == nil {
+		dest = data.NewStringFromBytes(nil)
+		dest.SetHyperLogLog(merged)
+		db.PutEntity(destKey, &types.DataEntity{Data: dest})
+	} else {
+		// 保留 destkey 的过期时间
+		dest.SetHyperLogLog(merged)
+	}
+	return resp.MakeOkReply()
+}
+
+// PFDEBUG GETREG|DECODE|ENCODING|TODENSE key
+func execPFDebug(db types.Database, args [][]byte) resp.Reply {
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "getreg", "decode", "encoding", "todense":
+	default:
+		return resp.MakeErrReply("ERR Unknown PFDEBUG subcommand '" + string(args[0]) + "'")
+	}
+
+	str, hll, errReply := getHyperLogLog(db, string(args[1]))
+	if errReply != nil {
+		return errReply
+	}
+	if hll == nil {
+		return resp.MakeErrReply("ERR The specified key does not exist")
+	}
+
+	switch subCmd {
+	case "getreg":
+		// 与 Redis 一样会先转为稠密编码
+		if _, err := hll.ToDense(); err != nil {
+			return makeHLLErrReply(err)
+		}
+		str.SetHyperLogLog(hll)
+		regs, _ := hll.Registers()
+		replies := make([]resp.Reply, len(regs))
+		for i, val := range regs {
+			replies[i] = resp.MakeIntReply(int64(val))
+		}
+		return resp.MakeMultiRawReply(replies)
+	case "decode":
+		decoded, err := hll.DecodeSparse()
+		if err != nil {
+			return makeHLLErrReply(err)
+		}
+		return resp.MakeSimpleStringReply(decoded)
+	case "encoding":
+		if hll.IsSparse() {
+			return resp.MakeSimpleStringReply("sparse")
+		}
+		return resp.MakeSimpleStringReply("dense")
+	default:
+		converted, err := hll.ToDense()
+		if err != nil {
+			return makeHLLErrReply(err)
+		}
+		str.SetHyperLogLog(hll)
+		if converted {
+			return resp.MakeIntReply(1)
+		}
+		return resp.MakeIntReply(0)
+	}
+}
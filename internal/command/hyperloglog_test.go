@@ -0,0 +1,127 @@
+package command
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"goredis/internal/types"
+)
+
+func TestHyperLogLogCommands(t *testing.T) {
+	db := NewMockDB()
+
+	t.Run("PFADD and PFCOUNT", func(t *testing.T) {
+		assertEqualInt(t, execPFAdd(db, toArgs("hll", "a", "b", "c", "d", "e", "f", "g")), 1)
+		assertEqualInt(t, execPFCount(db, toArgs("hll")), 7)
+		assertEqualInt(t, execPFAdd(db, toArgs("hll", "a")), 0)
+
+		// 没有元素时只创建 key
+		assertEqualInt(t, execPFAdd(db, toArgs("empty")), 1)
+		assertEqualInt(t, execPFAdd(db, toArgs("empty")), 0)
+		assertEqualInt(t, execPFCount(db, toArgs("empty")), 0)
+		assertEqualInt(t, execPFCount(db, toArgs("missing")), 0)
+
+		// 以字符串保存
+		if value := getBulkValue(t, execGet(db, toArgs("hll"))); string(value[:4]) != "HYLL" {
+			t.Errorf("HyperLogLog should be stored as a string, got %q", value)
+		}
+		assertEqualInt(t, execStrLen(db, toArgs("empty")), 18)
+	})
+
+	t.Run("PFCOUNT multiple keys and PFMERGE", func(t *testing.T) {
+		execPFAdd(db, toArgs("hll1", "foo", "bar", "zap", "a"))
+		execPFAdd(db, toArgs("hll2", "a", "b", "c", "foo"))
+		assertEqualInt(t, execPFCount(db, toArgs("hll1", "hll2", "missing")), 6)
+
+		assertOKReply(t, execPFMerge(db, toArgs("hll3", "hll1", "hll2")))
+		assertEqualInt(t, execPFCount(db, toArgs("hll3")), 6)
+
+		// destkey 本身也参与合并，并保留过期时间
+		execExpire(db, toArgs("hll3", "100"))
+		execPFAdd(db, toArgs("other", "x"))
+		assertOKReply(t, execPFMerge(db, toArgs("hll3", "other")))
+		assertEqualInt(t, execPFCount(db, toArgs("hll3")), 7)
+		if _, ok := db.GetExpireTime("hll3"); !ok {
+			t.Error("PFMERGE should keep the TTL of destkey")
+		}
+	})
+
+	t.Run("PFDEBUG", func(t *testing.T) {
+		execPFAdd(db, toArgs("dbg", "a"))
+		if reply := execPFDebug(db, toArgs("encoding", "dbg")); string(reply.ToBytes()) != "+sparse\r\n" {
+			t.Errorf("unexpected encoding %q", reply.ToBytes())
+		}
+		if reply := string(execPFDebug(db, toArgs("decode", "dbg")).ToBytes()); reply[:3] != "+Z:" && reply[:4] != "+XZ:" {
+			t.Errorf("unexpected decode %q", reply)
+		}
+		assertEqualInt(t, execPFDebug(db, toArgs("todense", "dbg")), 1)
+		assertEqualInt(t, execPFDebug(db, toArgs("todense", "dbg")), 0)
+		if msg := getErrorString(t, execPFDebug(db, toArgs("decode", "dbg"))); msg != "ERR HLL encoding is not sparse" {
+			t.Errorf("unexpected error %s", msg)
+		}
+		assertEqualInt(t, execPFCount(db, toArgs("dbg")), 1)
+
+		execPFAdd(db, toArgs("reg", "a"))
+		reply := execPFDebug(db, toArgs("getreg", "reg")).ToBytes()
+		if string(reply[:7]) != "*16384\r" {
+			t.Errorf("GETREG should return all registers, got %q", reply[:10])
+		}
+		if string(execPFDebug(db, toArgs("encoding", "reg")).ToBytes()) != "+dense\r\n" {
+			t.Error("GETREG should convert to dense")
+		}
+
+		if msg := getErrorString(t, execPFDebug(db, toArgs("foo", "dbg"))); msg != "ERR Unknown PFDEBUG subcommand 'foo'" {
+			t.Errorf("unexpected error %s", msg)
+		}
+		if msg := getErrorString(t, execPFDebug(db, toArgs("encoding", "missing"))); msg != "ERR The specified key does not exist" {
+			t.Errorf("unexpected error %s", msg)
+		}
+	})
+
+	t.Run("promote to dense", func(t *testing.T) {
+		for i := 0; i < 10000; i += 100 {
+			args := []string{"big"}
+			for j := i; j < i+100; j++ {
+				args = append(args, strconv.Itoa(j))
+			}
+			execPFAdd(db, toArgs(args...))
+		}
+		if string(execPFDebug(db, toArgs("encoding", "big")).ToBytes()) != "+dense\r\n" {
+			t.Error("large HyperLogLog should be dense")
+		}
+		if card := getIntValue(t, execPFCount(db, toArgs("big"))); card < 9700 || card > 10300 {
+			t.Errorf("expected about 10000, got %d", card)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		execSet(db, toArgs("str", "hello"))
+		for _, reply := range []string{
+			getErrorString(t, execPFAdd(db, toArgs("str", "a"))),
+			getErrorString(t, execPFCount(db, toArgs("str"))),
+			getErrorString(t, execPFMerge(db, toArgs("hll", "str"))),
+		} {
+			if reply != "WRONGTYPE Key is not a valid HyperLogLog string value." {
+				t.Errorf("unexpected error %s", reply)
+			}
+		}
+
+		db.PutEntity("list", &types.DataEntity{Data: "not a string"})
+		if msg := getErrorString(t, execPFAdd(db, toArgs("list", "a"))); msg != "ERR wrong type" {
+			t.Errorf("unexpected error %s", msg)
+		}
+
+		// 稀疏编码损坏
+		execSet(db, toArgs("bad", "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x7f\xfe"))
+		if msg := getErrorString(t, execPFCount(db, toArgs("bad"))); msg != "INVALIDOBJ Corrupted HLL object detected" {
+			t.Errorf("unexpected error %s", msg)
+		}
+	})
+
+	t.Run("expired key", func(t *testing.T) {
+		execPFAdd(db, toArgs("ttl", "a"))
+		db.SetExpire("ttl", time.Now().Add(-time.Second))
+		assertEqualInt(t, execPFCount(db, toArgs("ttl")), 0)
+	})
+}
@@ -1,8 +1,13 @@
 package command
 
+import "time"
+
 func GetCmd(name string) (Command, bool) {
 	cmd, ok := cmdTable[name]
-	return *cmd, ok
+	if !ok {
+		return Command{}, false
+	}
+	return *cmd, true
 }
 
 func init() {
@@ -10,79 +15,457 @@ func init() {
 		Name:     "del",
 		Arity:    -2,
 		Executor: execDel,
+		FirstKey: 1,
+		LastKey:  -1,
+		KeyStep:  1,
 	})
 	RegisterCommand(&Command{
-		Name:     "expire",
-		Arity:    3,
-		Executor: execExpire,
+		Name:      "expire",
+		Arity:     -3, // expire key seconds [NX|XX|GT|LT]
+		Executor:  execExpire,
+		FirstKey:  1,
+		LastKey:   1,
+		KeyStep:   1,
+		Translate: translateExpire(time.Second, true),
+	})
+	RegisterCommand(&Command{
+		Name:      "pexpire",
+		Arity:     -3, // pexpire key milliseconds [NX|XX|GT|LT]
+		Executor:  execPExpire,
+		FirstKey:  1,
+		LastKey:   1,
+		KeyStep:   1,
+		Translate: translateExpire(time.Millisecond, true),
+	})
+	RegisterCommand(&Command{
+		Name:      "expireat",
+		Arity:     -3, // expireat key unix-time-seconds [NX|XX|GT|LT]
+		Executor:  execExpireAt,
+		FirstKey:  1,
+		LastKey:   1,
+		KeyStep:   1,
+		Translate: translateExpire(time.Second, false),
+	})
+	RegisterCommand(&Command{
+		Name:     "pexpireat",
+		Arity:    -3, // pexpireat key unix-time-milliseconds [NX|XX|GT|LT]
+		Executor: execPExpireAt,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+	RegisterCommand(&Command{
+		Name:     "ttl",
+		Arity:    2, // ttl key
+		Executor: execTTL,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+	RegisterCommand(&Command{
+		Name:     "pttl",
+		Arity:    2, // pttl key
+		Executor: execPTTL,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+	RegisterCommand(&Command{
+		Name:     "expiretime",
+		Arity:    2, // expiretime key
+		Executor: execExpireTime,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+	RegisterCommand(&Command{
+		Name:     "pexpiretime",
+		Arity:    2, // pexpiretime key
+		Executor: execPExpireTime,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+	RegisterCommand(&Command{
+		Name:     "persist",
+		Arity:    2, // persist key
+		Executor: execPersist,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+	RegisterCommand(&Command{
+		Name:     "flushdb",
+		Arity:    -1, // flushdb [ASYNC|SYNC]
+		Executor: execFlushDB,
+	})
+	RegisterCommand(&Command{
+		Name:     "exists",
+		Arity:    -2, // exists key [key ...]
+		Executor: execExists,
+		FirstKey: 1,
+		LastKey:  -1,
+		KeyStep:  1,
+	})
+	RegisterCommand(&Command{
+		Name:     "type",
+		Arity:    2, // type key
+		Executor: execType,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+	RegisterCommand(&Command{
+		Name:     "keys",
+		Arity:    2, // keys pattern
+		Executor: execKeys,
+	})
+	RegisterCommand(&Command{
+		Name:     "scan",
+		Arity:    -2, // scan cursor [MATCH pattern] [COUNT count] [TYPE type]
+		Executor: execScan,
+	})
+	RegisterCommand(&Command{
+		Name:     "randomkey",
+		Arity:    1, // randomkey
+		Executor: execRandomKey,
+	})
+	RegisterCommand(&Command{
+		Name:     "dbsize",
+		Arity:    1, // dbsize
+		Executor: execDBSize,
+	})
+	RegisterCommand(&Command{
+		Name:     "ping",
+		Arity:    -1, // ping [message]
+		Executor: execPing,
+	})
+	RegisterCommand(&Command{
+		Name:     "dump",
+		Arity:    2, // dump key
+		Executor: execDump,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+	RegisterCommand(&Command{
+		Name:      "restore",
+		Arity:     -4, // restore key ttl serialized-value [REPLACE] [ABSTTL]
+		Executor:  execRestore,
+		FirstKey:  1,
+		LastKey:   1,
+		KeyStep:   1,
+		Translate: translateRestore,
+	})
+	// MIGRATE 发给目标节点的 RESTORE，在迁入中的槽上不需要客户端先发送 ASKING
+	RegisterCommand(&Command{
+		Name:      "restore-asking",
+		Arity:     -4, // restore-asking key ttl serialized-value [REPLACE] [ABSTTL]
+		Executor:  execRestore,
+		FirstKey:  1,
+		LastKey:   1,
+		KeyStep:   1,
+		Translate: translateRestore,
 	})
 
 	// ========================
 	// String Commands
 	// ========================
 	RegisterCommand(&Command{
-		Name:     "set",
-		Arity:    -3, // set key value [options]
-		Executor: execSet,
+		Name:      "set",
+		Arity:     -3, // set key value [options]
+		Executor:  execSet,
+		FirstKey:  1,
+		LastKey:   1,
+		KeyStep:   1,
+		Translate: translateSet,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "get",
 		Arity:    2, // get key
 		Executor: execGet,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "setnx",
 		Arity:    3, // setnx key value
 		Executor: execSetNX,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "strlen",
 		Arity:    2, // strlen key
 		Executor: execStrLen,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "append",
 		Arity:    3, // append key value
 		Executor: execAppend,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "incr",
 		Arity:    2, // incr key
 		Executor: execIncr,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "decr",
 		Arity:    2, // decr key
 		Executor: execDecr,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "incrby",
 		Arity:    3, // incrby key increment
 		Executor: execIncrBy,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "decrby",
 		Arity:    3, // decrby key decrement
 		Executor: execDecrBy,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "mget",
 		Arity:    -2, // decrby key decrement
 		Executor: execMGet,
+		FirstKey: 1,
+		LastKey:  -1,
+		KeyStep:  1,
 	})
 	RegisterCommand(&Command{
 		Name:     "mset",
 		Arity:    -3, // decrby key decrement
 		Executor: execMSet,
+		FirstKey: 1,
+		LastKey:  -1,
+		KeyStep:  2,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "msetnx",
+		Arity:    -3, // msetnx key value [key value ...]
+		Executor: execMSetNX,
+		FirstKey: 1,
+		LastKey:  -1,
+		KeyStep:  2,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "getset",
+		Arity:    3, // getset key value
+		Executor: execGetSet,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "getdel",
+		Arity:    2, // getdel key
+		Executor: execGetDel,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:      "getex",
+		Arity:     -2, // getex key [options]
+		Executor:  execGetEx,
+		FirstKey:  1,
+		LastKey:   1,
+		KeyStep:   1,
+		Translate: translateGetEx,
+	})
+
+	RegisterCommand(&Command{
+		Name:      "setex",
+		Arity:     4, // setex key seconds value
+		Executor:  execSetEx,
+		FirstKey:  1,
+		LastKey:   1,
+		KeyStep:   1,
+		Translate: translateSetEx("ex"),
+	})
+
+	RegisterCommand(&Command{
+		Name:      "psetex",
+		Arity:     4, // psetex key milliseconds value
+		Executor:  execPSetEx,
+		FirstKey:  1,
+		LastKey:   1,
+		KeyStep:   1,
+		Translate: translateSetEx("px"),
+	})
+
+	RegisterCommand(&Command{
+		Name:     "incrbyfloat",
+		Arity:    3, // incrbyfloat key increment
+		Executor: execIncrByFloat,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "getrange",
+		Arity:    4, // getrange key start end
+		Executor: execGetRange,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "setrange",
+		Arity:    4, // setrange key offset value
+		Executor: execSetRange,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "lcs",
+		Arity:    -3, // lcs key1 key2 [options]
+		Executor: execLCS,
+		FirstKey: 1,
+		LastKey:  2,
+		KeyStep:  1,
+	})
+
+	// ========================
+	// Bitmap Commands
+	// ========================
+	RegisterCommand(&Command{
+		Name:     "setbit",
+		Arity:    4, // setbit key offset value
+		Executor: execSetBit,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "getbit",
+		Arity:    3, // getbit key offset
+		Executor: execGetBit,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "bitcount",
+		Arity:    -2, // bitcount key [start end [BYTE|BIT]]
+		Executor: execBitCount,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "bitpos",
+		Arity:    -3, // bitpos key bit [start [end [BYTE|BIT]]]
+		Executor: execBitPos,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "bitop",
+		Arity:    -4, // bitop operation destkey key [key ...]
+		Executor: execBitOp,
+		FirstKey: 2,
+		LastKey:  -1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "bitfield",
+		Arity:    -2, // bitfield key [subcommands]
+		Executor: execBitField,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "bitfield_ro",
+		Arity:    -2, // bitfield_ro key [GET type offset ...]
+		Executor: execBitFieldRO,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	// ========================
+	// HyperLogLog Commands
+	// ========================
+	RegisterCommand(&Command{
+		Name:     "pfadd",
+		Arity:    -2, // pfadd key [element ...]
+		Executor: execPFAdd,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "pfcount",
+		Arity:    -2, // pfcount key [key ...]
+		Executor: execPFCount,
+		FirstKey: 1,
+		LastKey:  -1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "pfmerge",
+		Arity:    -2, // pfmerge destkey [sourcekey ...]
+		Executor: execPFMerge,
+		FirstKey: 1,
+		LastKey:  -1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "pfdebug",
+		Arity:    3, // pfdebug subcommand key
+		Executor: execPFDebug,
+		FirstKey: 2,
+		LastKey:  2,
+		KeyStep:  1,
 	})
 
 	// ========================
@@ -92,60 +475,108 @@ func init() {
 		Name:     "lpush",
 		Arity:    -3, // lpush key element [element ...]
 		Executor: execLPush,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "rpush",
 		Arity:    -3, // rpush key element [element ...]
 		Executor: execRPush,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "lpop",
 		Arity:    2, // lpop key
 		Executor: execLPop,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "rpop",
 		Arity:    2, // rpop key
 		Executor: execRPop,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "lmove",
+		Arity:    5, // lmove source destination LEFT|RIGHT LEFT|RIGHT
+		Executor: execLMove,
+		FirstKey: 1,
+		LastKey:  2,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "rpoplpush",
+		Arity:    3, // rpoplpush source destination
+		Executor: execRPopLPush,
+		FirstKey: 1,
+		LastKey:  2,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "llen",
 		Arity:    2, // llen key
 		Executor: execLLen,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "lindex",
 		Arity:    3, // lindex key index
 		Executor: execLIndex,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "lset",
 		Arity:    4, // lset key index element
 		Executor: execLSet,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "lrange",
 		Arity:    4, // lrange key start stop
 		Executor: execLRange,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "lrem",
 		Arity:    4, // lrem key count element
 		Executor: execLRem,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "ltrim",
 		Arity:    4, // ltrim key start stop
 		Executor: execLTrim,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	// ========================
@@ -156,52 +587,79 @@ func init() {
 		Name:     "sadd",
 		Arity:    -3, // sadd key member [member ...]
 		Executor: execSAdd,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "srem",
 		Arity:    -3, // srem key member [member ...]
 		Executor: execSRem,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "scard",
 		Arity:    2, // scard key
 		Executor: execSCard,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "smembers",
 		Arity:    2, // smembers key
 		Executor: execSMembers,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "sismember",
 		Arity:    3, // sismember key member
 		Executor: execSIsMember,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "spop",
 		Arity:    -2, // spop key [count]
 		Executor: execSPop,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	RegisterCommand(&Command{
 		Name:     "srandmember",
 		Arity:    -2, // srandmember key [count]
 		Executor: execSRandMember,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 	RegisterCommand(&Command{
 		Name:     "sunion",
 		Arity:    -3,
 		Executor: execSUnion,
+		FirstKey: 1,
+		LastKey:  -1,
+		KeyStep:  1,
 	})
 	RegisterCommand(&Command{
 		Name:     "sinter",
 		Arity:    -3,
 		Executor: execSInter,
+		FirstKey: 1,
+		LastKey:  -1,
+		KeyStep:  1,
 	})
 
 	// ========================
@@ -211,6 +669,9 @@ func init() {
 		Name:     "zadd",
 		Arity:    -4,
 		Executor: execZAdd,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	// ZCARD key
@@ -218,6 +679,9 @@ func init() {
 		Name:     "zcard",
 		Arity:    2,
 		Executor: execZCard,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	// ZSCORE key member
@@ -225,6 +689,9 @@ func init() {
 		Name:     "zscore",
 		Arity:    3,
 		Executor: execZScore,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	// ZRANK key member
@@ -232,6 +699,9 @@ func init() {
 		Name:     "zrank",
 		Arity:    3,
 		Executor: execZRank,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	// ZREVRANK key member
@@ -239,6 +709,9 @@ func init() {
 		Name:     "zrevrank",
 		Arity:    3,
 		Executor: execZRevRank,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	// ZRANGE key start stop [WITHSCORES]
@@ -246,6 +719,9 @@ func init() {
 		Name:     "zrange",
 		Arity:    -4,
 		Executor: execZRange,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	// ZREVRANGE key start stop [WITHSCORES]
@@ -253,6 +729,9 @@ func init() {
 		Name:     "zrevrange",
 		Arity:    -4,
 		Executor: execZRevRange,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	// ZCOUNT key min max
@@ -260,6 +739,9 @@ func init() {
 		Name:     "zcount",
 		Arity:    4,
 		Executor: execZCount,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 
 	// ZREM key member [member ...]
@@ -267,6 +749,200 @@ func init() {
 		Name:     "zrem",
 		Arity:    -3,
 		Executor: execZRem,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	// ========================
+	// Geo Commands
+	// ========================
+	RegisterCommand(&Command{
+		Name:     "geoadd",
+		Arity:    -5, // geoadd key [NX|XX] [CH] longitude latitude member [...]
+		Executor: execGeoAdd,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "geopos",
+		Arity:    -2, // geopos key [member ...]
+		Executor: execGeoPos,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "geodist",
+		Arity:    -4, // geodist key member1 member2 [unit]
+		Executor: execGeoDist,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "geohash",
+		Arity:    -2, // geohash key [member ...]
+		Executor: execGeoHash,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "geosearch",
+		Arity:    -7, // geosearch key FROMMEMBER|FROMLONLAT ... BYRADIUS|BYBOX ... [options]
+		Executor: execGeoSearch,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "geosearchstore",
+		Arity:    -8, // geosearchstore destination source FROMMEMBER|FROMLONLAT ... BYRADIUS|BYBOX ... [options]
+		Executor: execGeoSearchStore,
+		FirstKey: 1,
+		LastKey:  2,
+		KeyStep:  1,
+	})
+
+	// ========================
+	// Stream Commands
+	// ========================
+	RegisterCommand(&Command{
+		Name:     "xadd",
+		Arity:    -5, // xadd key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [...]
+		Executor: execXAdd,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "xrange",
+		Arity:    -4, // xrange key start end [COUNT count]
+		Executor: execXRange,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "xrevrange",
+		Arity:    -4, // xrevrange key end start [COUNT count]
+		Executor: execXRevRange,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "xlen",
+		Arity:    2, // xlen key
+		Executor: execXLen,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "xdel",
+		Arity:    -3, // xdel key id [id ...]
+		Executor: execXDel,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "xtrim",
+		Arity:    -4, // xtrim key MAXLEN|MINID [=|~] threshold [LIMIT count]
+		Executor: execXTrim,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "xsetid",
+		Arity:    -3, // xsetid key last-id [ENTRIESADDED entries-added] [MAXDELETEDID max-deleted-id]
+		Executor: execXSetID,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "xread",
+		Arity:    -4, // xread [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+		Executor: execXRead,
+		Keys:     StreamReadKeys,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "xreadgroup",
+		Arity:    -7, // xreadgroup GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+		Executor: execXReadGroup,
+		Keys:     StreamReadKeys,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "xack",
+		Arity:    -4, // xack key group id [id ...]
+		Executor: execXAck,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "xpending",
+		Arity:    -3, // xpending key group [[IDLE min-idle-time] start end count [consumer]]
+		Executor: execXPending,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "xclaim",
+		Arity:    -6, // xclaim key group consumer min-idle-time id [id ...] [options]
+		Executor: execXClaim,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "xautoclaim",
+		Arity:    -6, // xautoclaim key group consumer min-idle-time start [COUNT count] [JUSTID]
+		Executor: execXAutoClaim,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "xgroup",
+		Arity:    -2, // xgroup CREATE|SETID|DESTROY|CREATECONSUMER|DELCONSUMER key group ...
+		Executor: execXGroup,
+		FirstKey: 2,
+		LastKey:  2,
+		KeyStep:  1,
+	})
+
+	RegisterCommand(&Command{
+		Name:     "xinfo",
+		Arity:    -2, // xinfo STREAM|GROUPS|CONSUMERS key ...
+		Executor: execXInfo,
+		FirstKey: 2,
+		LastKey:  2,
+		KeyStep:  1,
 	})
 
 	// ========================
@@ -276,50 +952,80 @@ func init() {
 		Name:     "hset",
 		Arity:    4,
 		Executor: execHSet,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 	RegisterCommand(&Command{
 		Name:     "hget",
 		Arity:    3,
 		Executor: execHGet,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 	RegisterCommand(&Command{
 		Name:     "hdel",
 		Arity:    -3,
 		Executor: execHDel,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 	RegisterCommand(&Command{
 		Name:     "hexists",
 		Arity:    3,
 		Executor: execHExists,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 	RegisterCommand(&Command{
 		Name:     "hlen",
 		Arity:    2,
 		Executor: execHLEN,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 	RegisterCommand(&Command{
 		Name:     "hkeys",
 		Arity:    2,
 		Executor: execHKeys,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 	RegisterCommand(&Command{
 		Name:     "hvals",
 		Arity:    2,
 		Executor: execHVals,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 	RegisterCommand(&Command{
 		Name:     "hgetall",
 		Arity:    2,
 		Executor: execHGetAll,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 	RegisterCommand(&Command{
 		Name:     "hmset",
 		Arity:    -4,
 		Executor: execHMSet,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 	RegisterCommand(&Command{
 		Name:     "hmget",
 		Arity:    -3,
 		Executor: execHMGet,
+		FirstKey: 1,
+		LastKey:  1,
+		KeyStep:  1,
 	})
 }
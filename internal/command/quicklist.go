@@ -2,6 +2,7 @@ package command
 
 import (
 	"strconv"
+	"strings"
 
 	"goredis/internal/data"
 	"goredis/internal/resp"
@@ -75,6 +76,10 @@ func execLPop(db types.Database, args [][]byte) resp.Reply {
 	if val == nil {
 		return resp.MakeNullBulkReply()
 	}
+	// 列表为空时删除 key，与 Redis 一致
+	if ql.Len() == 0 {
+		db.Remove(key)
+	}
 
 	return resp.MakeBulkReply(val)
 }
@@ -96,6 +101,71 @@ func execRPop(db types.Database, args [][]byte) resp.Reply {
 	if val == nil {
 		return resp.MakeNullBulkReply()
 	}
+	// 列表为空时删除 key，与 Redis 一致
+	if ql.Len() == 0 {
+		db.Remove(key)
+	}
+
+	return resp.MakeBulkReply(val)
+}
+
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func execLMove(db types.Database, args [][]byte) resp.Reply {
+	src, dst := string(args[0]), string(args[1])
+	from, to := strings.ToLower(string(args[2])), strings.ToLower(string(args[3]))
+	if (from != "left" && from != "right") || (to != "left" && to != "right") {
+		return resp.MakeErrReply("ERR syntax error")
+	}
+	return lmove(db, src, dst, from, to)
+}
+
+// RPOPLPUSH source destination，等价于 LMOVE source destination RIGHT LEFT
+func execRPopLPush(db types.Database, args [][]byte) resp.Reply {
+	return lmove(db, string(args[0]), string(args[1]), "right", "left")
+}
+
+func lmove(db types.Database, src, dst, from, to string) resp.Reply {
+	entity, exists := db.GetEntity(src)
+	if !exists {
+		return resp.MakeNullBulkReply()
+	}
+	srcList, ok := entity.Data.(*data.QuickList)
+	if !ok {
+		return resp.MakeErrReply("ERR wrong type")
+	}
+
+	// 先检查目标类型，避免弹出后才发现无法写入
+	var dstList *data.QuickList
+	if entity, exists := db.GetEntity(dst); exists {
+		dstList, ok = entity.Data.(*data.QuickList)
+		if !ok {
+			return resp.MakeErrReply("ERR wrong type")
+		}
+	}
+
+	var val []byte
+	if from == "left" {
+		val = srcList.PopFront()
+	} else {
+		val = srcList.PopBack()
+	}
+	if val == nil {
+		return resp.MakeNullBulkReply()
+	}
+	if srcList.Len() == 0 {
+		db.Remove(src)
+	}
+
+	// src 与 dst 相同且只有一个元素时，上面已删除 key，需要重新创建
+	if dstList == nil || (src == dst && srcList.Len() == 0) {
+		dstList = data.NewQuickList()
+		db.PutEntity(dst, &types.DataEntity{Data: dstList})
+	}
+	if to == "left" {
+		dstList.PushFront(val)
+	} else {
+		dstList.PushBack(val)
+	}
 
 	return resp.MakeBulkReply(val)
 }
@@ -87,6 +87,9 @@ func TestExecLPop(t *testing.T) {
 		execLPop(db, [][]byte{[]byte("l")}) // v3
 		reply := execLPop(db, [][]byte{[]byte("l")})
 		assertEqualBulk(t, reply, nil)
+		if _, ok := db.GetEntity("l"); ok {
+			t.Error("empty list should be removed")
+		}
 	})
 
 	t.Run("key not exists", func(t *testing.T) {
@@ -135,6 +138,45 @@ func TestExecRPop(t *testing.T) {
 	})
 }
 
+func TestExecLMove(t *testing.T) {
+	db := NewMockDB()
+	execRPush(db, [][]byte{[]byte("src"), []byte("a"), []byte("b"), []byte("c")})
+
+	t.Run("move between lists", func(t *testing.T) {
+		reply := execLMove(db, [][]byte{[]byte("src"), []byte("dst"), []byte("LEFT"), []byte("RIGHT")})
+		assertEqualBulk(t, reply, []byte("a"))
+		reply = execLMove(db, [][]byte{[]byte("src"), []byte("dst"), []byte("right"), []byte("left")})
+		assertEqualBulk(t, reply, []byte("c"))
+		assertEqualMultiBulk(t, execLRange(db, [][]byte{[]byte("dst"), []byte("0"), []byte("-1")}),
+			[][]byte{[]byte("c"), []byte("a")})
+	})
+
+	t.Run("rotate single element list", func(t *testing.T) {
+		reply := execLMove(db, [][]byte{[]byte("src"), []byte("src"), []byte("left"), []byte("right")})
+		assertEqualBulk(t, reply, []byte("b"))
+		assertEqualInt(t, execLLen(db, [][]byte{[]byte("src")}), 1)
+	})
+
+	t.Run("source removed when empty", func(t *testing.T) {
+		execRPopLPush(db, [][]byte{[]byte("src"), []byte("dst")})
+		if _, ok := db.GetEntity("src"); ok {
+			t.Error("empty source list should be removed")
+		}
+		assertEqualBulk(t, execRPopLPush(db, [][]byte{[]byte("src"), []byte("dst")}), nil)
+		assertEqualInt(t, execLLen(db, [][]byte{[]byte("dst")}), 3)
+	})
+
+	t.Run("syntax error and wrong type", func(t *testing.T) {
+		reply := execLMove(db, [][]byte{[]byte("dst"), []byte("x"), []byte("up"), []byte("left")})
+		assertErrorReply(t, reply, "syntax error")
+
+		db.PutEntity("str", &types.DataEntity{Data: []byte("v")})
+		reply = execLMove(db, [][]byte{[]byte("dst"), []byte("str"), []byte("left"), []byte("left")})
+		assertErrorReply(t, reply, "wrong type")
+		assertEqualInt(t, execLLen(db, [][]byte{[]byte("dst")}), 3)
+	})
+}
+
 func TestExecLLen(t *testing.T) {
 	db := NewMockDB()
 	execRPush(db, [][]byte{[]byte("l"), []byte("a"), []byte("b")})
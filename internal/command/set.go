@@ -156,7 +156,8 @@ func execSPop(db types.Database, args [][]byte) resp.Reply {
 		db.Remove(key)
 	}
 
-	return resp.MakeBulkReply(v)
+	// 弹出的成员是随机的，以 SREM 传播保证 AOF 重放和从库得到相同的结果
+	return makePropagateReply(resp.MakeBulkReply(v), [][]byte{[]byte("srem"), args[0], v})
 }
 
 func execSUnion(db types.Database, args [][]byte) resp.Reply {
@@ -227,7 +227,7 @@ func TestExecSPop(t *testing.T) {
 	execSAdd(db, [][]byte{[]byte("s"), []byte("a"), []byte("b"), []byte("c")})
 
 	t.Run("pop one", func(t *testing.T) {
-		reply := execSPop(db, [][]byte{[]byte("s")})
+		reply, cmdLines := propagated(execSPop(db, [][]byte{[]byte("s")}))
 		val := getBulkValue(t, reply)
 		if val == nil {
 			t.Error("expected a member")
@@ -236,6 +236,10 @@ func TestExecSPop(t *testing.T) {
 		if !valid[string(val)] {
 			t.Errorf("invalid pop value: %q", val)
 		}
+		// 以 SREM 传播弹出的成员
+		if len(cmdLines) != 1 || cmdLines[0] != "srem s "+string(val) {
+			t.Errorf("unexpected propagation: %q", cmdLines)
+		}
 
 		// Verify size decreased
 		assertEqualInt(t, execSCard(db, [][]byte{[]byte("s")}), 2)
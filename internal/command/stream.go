@@ -0,0 +1,1465 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"goredis/internal/data"
+	"goredis/internal/resp"
+	"goredis/internal/types"
+)
+
+// 近似裁剪默认最多删除的条目数，对应 Redis 的 100 * stream-node-max-entries
+const streamTrimDefaultLimit = 100 * 100
+
+// XAUTOCLAIM 每个 COUNT 最多检查的待确认条目数
+const streamAutoClaimAttemptsFactor = 10
+
+func makeInvalidStreamIDReply() resp.Reply {
+	return resp.MakeErrReply("ERR Invalid stream ID specified as stream command argument")
+}
+
+func makeNoGroupReply(key, group string) resp.Reply {
+	return resp.MakeErrReply("NOGROUP No such key '" + key + "' or consumer group '" + group + "'")
+}
+
+func nowMs() int64 {
+	return time.Now().UnixMilli()
+}
+
+// getStream key 不存在时返回 nil
+func getStream(db types.Database, key string) (*data.Stream, bool, resp.Reply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, false, nil
+	}
+	s, ok := entity.Data.(*data.Stream)
+	if !ok {
+		return nil, false, resp.MakeErrReply("ERR wrong type")
+	}
+	return s, true, nil
+}
+
+// parseStreamID 解析 ms-seq 或 ms，只有 ms 时序号取 missingSeq
+func parseStreamID(arg []byte, missingSeq uint64) (data.StreamID, resp.Reply) {
+	id, err := data.ParseStreamID(string(arg), missingSeq)
+	if err != nil {
+		return id, makeInvalidStreamIDReply()
+	}
+	return id, nil
+}
+
+// parseRangeStart 解析区间的起点：- 表示最小 ID，( 开头表示不包含
+func parseRangeStart(arg []byte) (data.StreamID, resp.Reply) {
+	switch string(arg) {
+	case "-":
+		return data.MinStreamID, nil
+	case "+":
+		return data.MaxStreamID, nil
+	}
+	if len(arg) > 0 && arg[0] == '(' {
+		id, errReply := parseStreamID(arg[1:], 0)
+		if errReply != nil {
+			return id, errReply
+		}
+		next, ok := id.Incr()
+		if !ok {
+			return id, resp.MakeErrReply("ERR invalid start ID for the interval")
+		}
+		return next, nil
+	}
+	return parseStreamID(arg, 0)
+}
+
+// parseRangeEnd 解析区间的终点：+ 表示最大 ID，只有 ms 时包含该毫秒内的所有条目
+func parseRangeEnd(arg []byte) (data.StreamID, resp.Reply) {
+	switch string(arg) {
+	case "-":
+		return data.MinStreamID, nil
+	case "+":
+		return data.MaxStreamID, nil
+	}
+	if len(arg) > 0 && arg[0] == '(' {
+		id, errReply := parseStreamID(arg[1:], ^uint64(0))
+		if errReply != nil {
+			return id, errReply
+		}
+		prev, ok := id.Decr()
+		if !ok {
+			return id, resp.MakeErrReply("ERR invalid end ID for the interval")
+		}
+		return prev, nil
+	}
+	return parseStreamID(arg, ^uint64(0))
+}
+
+func makeStreamIDReply(id data.StreamID) resp.Reply {
+	return resp.MakeBulkReply([]byte(id.String()))
+}
+
+// makeStreamEntryReply [id, [field, value, ...]]
+func makeStreamEntryReply(entry *data.StreamEntry) resp.Reply {
+	return resp.MakeMultiRawReply([]resp.Reply{
+		makeStreamIDReply(entry.ID),
+		resp.MakeMultiBulkReply(entry.Fields),
+	})
+}
+
+func makeStreamEntriesReply(entries []*data.StreamEntry) resp.Reply {
+	replies := make([]resp.Reply, len(entries))
+	for i, entry := range entries {
+		replies[i] = makeStreamEntryReply(entry)
+	}
+	return resp.MakeMultiRawReply(replies)
+}
+
+// streamTrimArgs MAXLEN|MINID [=|~] threshold [LIMIT count]
+type streamTrimArgs struct {
+	strategy string // 空表示不裁剪
+	maxLen   int
+	minID    data.StreamID
+	approx   bool
+	limit    int
+	start    int // 在参数中的位置，用于原样传播
+	end      int
+}
+
+// parseStreamTrimArg 解析 args[i] 开始的裁剪参数，返回下一个参数的位置
+func parseStreamTrimArg(args [][]byte, i int, trim *streamTrimArgs) (int, resp.Reply) {
+	strategy := strings.ToLower(string(args[i]))
+	if trim.strategy != "" {
+		return 0, resp.MakeErrReply("ERR syntax error, MAXLEN and MINID options at the same time are not compatible")
+	}
+	trim.strategy = strategy
+	trim.start = i
+	i++
+	if i < len(args) && (string(args[i]) == "=" || string(args[i]) == "~") {
+		trim.approx = string(args[i]) == "~"
+		i++
+	}
+	if i >= len(args) {
+		return 0, resp.MakeErrReply("ERR syntax error")
+	}
+	if strategy == "maxlen" {
+		maxLen, err := strconv.ParseInt(string(args[i]), 10, 64)
+		if err != nil {
+			return 0, resp.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if maxLen < 0 {
+			return 0, resp.MakeErrReply("ERR The MAXLEN argument must be >= 0.")
+		}
+		trim.maxLen = int(maxLen)
+	} else {
+		id, errReply := parseStreamID(args[i], 0)
+		if errReply != nil {
+			return 0, errReply
+		}
+		trim.maxLen = -1
+		trim.minID = id
+	}
+	i++
+
+	trim.limit = -1
+	if i+1 < len(args) && strings.EqualFold(string(args[i]), "limit") {
+		limit, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil {
+			return 0, resp.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if limit < 0 {
+			return 0, resp.MakeErrReply("ERR The LIMIT argument must be >= 0.")
+		}
+		trim.limit = int(limit)
+		i += 2
+	}
+	trim.end = i
+	return i, nil
+}
+
+// validate 参数全部解析后再检查 LIMIT，并确定实际的删除上限
+func (trim *streamTrimArgs) validate() resp.Reply {
+	if trim.limit >= 0 && !trim.approx {
+		return resp.MakeErrReply("ERR syntax error, LIMIT cannot be used without the special ~ option")
+	}
+	if trim.limit < 0 {
+		trim.limit = 0
+		if trim.approx {
+			trim.limit = streamTrimDefaultLimit
+		}
+	}
+	return nil
+}
+
+func (trim *streamTrimArgs) apply(s *data.Stream) int {
+	return s.Trim(trim.maxLen, trim.minID, trim.approx, trim.limit)
+}
+
+// propagateArgs 近似裁剪的结果取决于节点的划分，以 MAXLEN = 裁剪后的长度 传播
+func (trim *streamTrimArgs) propagateArgs(args [][]byte, s *data.Stream) [][]byte {
+	if trim.approx {
+		return [][]byte{[]byte("maxlen"), []byte("="), []byte(strconv.Itoa(s.Len()))}
+	}
+	return args[trim.start:trim.end]
+}
+
+// XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+func execXAdd(db types.Database, args [][]byte) resp.Reply {
+	key := string(args[0])
+	var noMkStream bool
+	trim := &streamTrimArgs{}
+
+	i := 1
+	for i < len(args) {
+		opt := strings.ToLower(string(args[i]))
+		if opt == "nomkstream" {
+			noMkStream = true
+			i++
+		} else if opt == "maxlen" || opt == "minid" {
+			var errReply resp.Reply
+			if i, errReply = parseStreamTrimArg(args, i, trim); errReply != nil {
+				return errReply
+			}
+		} else {
+			break
+		}
+	}
+	if trim.strategy != "" {
+		if errReply := trim.validate(); errReply != nil {
+			return errReply
+		}
+	}
+	if len(args)-i < 3 || (len(args)-i-1)%2 != 0 {
+		return resp.MakeErrReply("ERR wrong number of arguments for 'xadd' command")
+	}
+
+	// 先解析 ID 的格式
+	idArg := string(args[i])
+	var id data.StreamID
+	autoID, autoSeq := idArg == "*", false
+	if !autoID {
+		if ms, ok := strings.CutSuffix(idArg, "-*"); ok {
+			parsed, err := strconv.ParseUint(ms, 10, 64)
+			if err != nil {
+				return makeInvalidStreamIDReply()
+			}
+			id, autoSeq = data.StreamID{Ms: parsed}, true
+		} else {
+			var errReply resp.Reply
+			if id, errReply = parseStreamID(args[i], 0); errReply != nil {
+				return errReply
+			}
+			if id.IsZero() {
+				return resp.MakeErrReply("ERR The ID specified in XADD must be greater than 0-0")
+			}
+		}
+	}
+
+	s, exists, errReply := getStream(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if !exists {
+		if noMkStream {
+			return makePropagateReply(resp.MakeNullBulkReply())
+		}
+		s = data.NewStream()
+	}
+
+	switch {
+	case autoID:
+		next, err := s.NextID(uint64(nowMs()))
+		if err != nil {
+			return resp.MakeErrReply("ERR The stream has exhausted the last possible ID, unable to add more items")
+		}
+		id = next
+	case autoSeq:
+		next, ok := s.NextSeqID(id.Ms)
+		if !ok {
+			return resp.MakeErrReply("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+		}
+		id = next
+	default:
+		if id.Compare(s.LastID()) <= 0 {
+			return resp.MakeErrReply("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+		}
+	}
+
+	fields := args[i+1:]
+	s.Add(id, fields)
+	if !exists {
+		db.PutEntity(key, &types.DataEntity{Data: s})
+	}
+
+	// 以确定的 ID 传播
+	cmdLine := [][]byte{[]byte("xadd"), args[0]}
+	if trim.strategy != "" {
+		trim.apply(s)
+		cmdLine = append(cmdLine, trim.propagateArgs(args, s)...)
+	}
+	cmdLine = append(cmdLine, []byte(id.String()))
+	cmdLine = append(cmdLine, fields...)
+	return makePropagateReply(makeStreamIDReply(id), cmdLine)
+}
+
+// XLEN key
+func execXLen(db types.Database, args [][]byte) resp.Reply {
+	s, exists, errReply := getStream(db, string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if !exists {
+		return resp.MakeIntReply(0)
+	}
+	return resp.MakeIntReply(int64(s.Len()))
+}
+
+func xrangeGeneric(db types.Database, args [][]byte, rev bool) resp.Reply {
+	startArg, endArg := args[1], args[2]
+	if rev {
+		startArg, endArg = endArg, startArg
+	}
+	start, errReply := parseRangeStart(startArg)
+	if errReply != nil {
+		return errReply
+	}
+	end, errReply := parseRangeEnd(endArg)
+	if errReply != nil {
+		return errReply
+	}
+
+	count := -1
+	if len(args) > 3 {
+		if len(args) != 5 || !strings.EqualFold(string(args[3]), "count") {
+			return resp.MakeErrReply("ERR syntax error")
+		}
+		n, err := strconv.ParseInt(string(args[4]), 10, 64)
+		if err != nil {
+			return resp.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		count = int(max(n, 0))
+	}
+
+	s, exists, errReply := getStream(db, string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if !exists || count == 0 {
+		return resp.MakeMultiRawReply(nil)
+	}
+	return makeStreamEntriesReply(s.Range(start, end, count, rev))
+}
+
+// XRANGE key start end [COUNT count]
+func execXRange(db types.Database, args [][]byte) resp.Reply {
+	return xrangeGeneric(db, args, false)
+}
+
+// XREVRANGE key end start [COUNT count]
+func execXRevRange(db types.Database, args [][]byte) resp.Reply {
+	return xrangeGeneric(db, args, true)
+}
+
+// XDEL key id [id ...]
+func execXDel(db types.Database, args [][]byte) resp.Reply {
+	ids := make([]data.StreamID, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		id, errReply := parseStreamID(arg, 0)
+		if errReply != nil {
+			return errReply
+		}
+		ids = append(ids, id)
+	}
+
+	s, exists, errReply := getStream(db, string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if !exists {
+		return resp.MakeIntReply(0)
+	}
+	deleted := 0
+	for _, id := range ids {
+		if s.Delete(id) {
+			deleted++
+		}
+	}
+	return resp.MakeIntReply(int64(deleted))
+}
+
+// XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count]
+func execXTrim(db types.Database, args [][]byte) resp.Reply {
+	opt := strings.ToLower(string(args[1]))
+	if opt != "maxlen" && opt != "minid" {
+		return resp.MakeErrReply("ERR syntax error")
+	}
+	trim := &streamTrimArgs{}
+	next, errReply := parseStreamTrimArg(args, 1, trim)
+	if errReply != nil {
+		return errReply
+	}
+	if next != len(args) {
+		return resp.MakeErrReply("ERR syntax error")
+	}
+	if errReply := trim.validate(); errReply != nil {
+		return errReply
+	}
+
+	s, exists, errReply := getStream(db, string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if !exists {
+		return makePropagateReply(resp.MakeIntReply(0))
+	}
+	trimmed := trim.apply(s)
+	cmdLine := append([][]byte{[]byte("xtrim"), args[0]}, trim.propagateArgs(args, s)...)
+	return makePropagateReply(resp.MakeIntReply(int64(trimmed)), cmdLine)
+}
+
+// XSETID key last-id [ENTRIESADDED entries-added] [MAXDELETEDID max-deleted-id]
+func execXSetID(db types.Database, args [][]byte) resp.Reply {
+	lastID, errReply := parseStreamID(args[1], 0)
+	if errReply != nil {
+		return errReply
+	}
+	entriesAdded := int64(-1)
+	var maxDeletedID data.StreamID
+	hasMaxDeleted := false
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return resp.MakeErrReply("ERR syntax error")
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "entriesadded":
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return resp.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if n < 0 {
+				return resp.MakeErrReply("ERR entries_added must be positive")
+			}
+			entriesAdded = n
+		case "maxdeletedid":
+			if maxDeletedID, errReply = parseStreamID(args[i+1], 0); errReply != nil {
+				return errReply
+			}
+			if lastID.Compare(maxDeletedID) < 0 {
+				return resp.MakeErrReply("ERR The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+			}
+			hasMaxDeleted = true
+		default:
+			return resp.MakeErrReply("ERR syntax error")
+		}
+	}
+
+	s, exists, errReply := getStream(db, string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if !exists {
+		return resp.MakeErrReply("ERR no such key")
+	}
+	if s.Len() > 0 {
+		if last := s.Range(data.MinStreamID, data.MaxStreamID, 1, true); lastID.Compare(last[0].ID) < 0 {
+			return resp.MakeErrReply("ERR The ID specified in XSETID is smaller than the target stream top item")
+		}
+		if entriesAdded >= 0 && int64(s.Len()) > entriesAdded {
+			return resp.MakeErrReply("ERR The entries_added specified in XSETID is smaller than the target stream length")
+		}
+	}
+
+	added := s.EntriesAdded()
+	if entriesAdded >= 0 {
+		added = uint64(entriesAdded)
+	}
+	if !hasMaxDeleted {
+		maxDeletedID = s.MaxDeletedID()
+	}
+	s.SetID(lastID, added, maxDeletedID)
+	return resp.MakeOkReply()
+}
+
+// streamReadArgs XREAD 和 XREADGROUP 的参数
+type streamReadArgs struct {
+	group    string
+	consumer string
+	count    int
+	block    bool
+	noAck    bool
+	keys     [][]byte
+	ids      [][]byte
+}
+
+// parseStreamReadArgs 解析 XREAD/XREADGROUP 的参数，args 不包含命令名
+func parseStreamReadArgs(cmdName string, args [][]byte) (*streamReadArgs, resp.Reply) {
+	isGroup := cmdName == "xreadgroup"
+	read := &streamReadArgs{}
+	hasGroup := false
+	for i := 0; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch strings.ToLower(string(args[i])) {
+		case "count":
+			if remaining < 1 {
+				return nil, resp.MakeErrReply("ERR syntax error")
+			}
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, resp.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			read.count = int(max(n, 0))
+			i++
+		case "block":
+			if remaining < 1 {
+				return nil, resp.MakeErrReply("ERR syntax error")
+			}
+			if _, errReply := parseStreamBlockTimeout(args[i+1]); errReply != nil {
+				return nil, errReply
+			}
+			read.block = true
+			i++
+		case "group":
+			if !isGroup {
+				return nil, resp.MakeErrReply("ERR The GROUP option is only supported by XREADGROUP. You called XREAD instead.")
+			}
+			if remaining < 2 {
+				return nil, resp.MakeErrReply("ERR syntax error")
+			}
+			read.group, read.consumer = string(args[i+1]), string(args[i+2])
+			hasGroup = true
+			i += 2
+		case "noack":
+			if !isGroup {
+				return nil, resp.MakeErrReply("ERR syntax error")
+			}
+			read.noAck = true
+		case "streams":
+			streams := args[i+1:]
+			if len(streams) == 0 || len(streams)%2 != 0 {
+				if isGroup {
+					return nil, resp.MakeErrReply("ERR Unbalanced 'xreadgroup' list of streams: for each stream key an ID or '>' must be specified.")
+				}
+				return nil, resp.MakeErrReply("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+			}
+			read.keys, read.ids = streams[:len(streams)/2], streams[len(streams)/2:]
+			i = len(args)
+		default:
+			return nil, resp.MakeErrReply("ERR syntax error")
+		}
+	}
+	if read.keys == nil {
+		return nil, resp.MakeErrReply("ERR syntax error")
+	}
+	if isGroup && !hasGroup {
+		return nil, resp.MakeErrReply("ERR Missing GROUP option for XREADGROUP")
+	}
+	return read, nil
+}
+
+func parseStreamBlockTimeout(arg []byte) (time.Duration, resp.Reply) {
+	ms, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, resp.MakeErrReply("ERR timeout is not an integer or out of range")
+	}
+	if ms < 0 {
+		return 0, resp.MakeErrReply("ERR timeout is negative")
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// StreamReadKeys 返回 XREAD/XREADGROUP 的 key，即 STREAMS 之后的前一半参数
+func StreamReadKeys(cmdLine [][]byte) []string {
+	for i := 1; i < len(cmdLine); i++ {
+		if !strings.EqualFold(string(cmdLine[i]), "streams") {
+			continue
+		}
+		streams := cmdLine[i+1:]
+		keys := make([]string, 0, len(streams)/2)
+		for _, key := range streams[:len(streams)/2] {
+			keys = append(keys, string(key))
+		}
+		return keys
+	}
+	return nil
+}
+
+// StreamBlockTimeout 返回 XREAD/XREADGROUP 的 BLOCK 参数，0 表示一直阻塞；
+// 没有 BLOCK 或参数有误时返回 false，由执行函数按非阻塞命令处理并报告错误
+func StreamBlockTimeout(cmdLine [][]byte) (time.Duration, bool) {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	read, errReply := parseStreamReadArgs(cmdName, cmdLine[1:])
+	if errReply != nil || !read.block {
+		return 0, false
+	}
+	for i := 1; i < len(cmdLine)-1; i++ {
+		if strings.EqualFold(string(cmdLine[i]), "block") {
+			timeout, _ := parseStreamBlockTimeout(cmdLine[i+1])
+			return timeout, true
+		}
+	}
+	return 0, false
+}
+
+// ResolveStreamLastIDs 阻塞前将 XREAD 中的 $ 换成当前的最后一个 ID，之后只返回阻塞期间新增的条目
+func ResolveStreamLastIDs(db types.Database, cmdLine [][]byte) [][]byte {
+	keys := StreamReadKeys(cmdLine)
+	resolved := append([][]byte(nil), cmdLine...)
+	idStart := len(cmdLine) - len(keys)
+	for i, key := range keys {
+		if string(resolved[idStart+i]) != "$" {
+			continue
+		}
+		lastID := data.MinStreamID
+		if s, exists, _ := getStream(db, key); exists {
+			lastID = s.LastID()
+		}
+		resolved[idStart+i] = []byte(lastID.String())
+	}
+	return resolved
+}
+
+// XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+// 阻塞由 database 处理，这里总是立即返回
+func execXRead(db types.Database, args [][]byte) resp.Reply {
+	read, errReply := parseStreamReadArgs("xread", args)
+	if errReply != nil {
+		return errReply
+	}
+
+	// 先校验所有 ID
+	streams := make([]*data.Stream, len(read.keys))
+	starts := make([]data.StreamID, len(read.keys))
+	for i, key := range read.keys {
+		s, _, errReply := getStream(db, string(key))
+		if errReply != nil {
+			return errReply
+		}
+		streams[i] = s
+		switch string(read.ids[i]) {
+		case "$":
+			if s != nil {
+				starts[i] = s.LastID()
+			}
+			continue
+		case ">":
+			return resp.MakeErrReply("ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.")
+		}
+		id, errReply := parseStreamID(read.ids[i], 0)
+		if errReply != nil {
+			return errReply
+		}
+		starts[i] = id
+	}
+
+	var replies []resp.Reply
+	for i, s := range streams {
+		if s == nil {
+			continue
+		}
+		// 返回大于指定 ID 的条目
+		start, ok := starts[i].Incr()
+		if !ok {
+			continue
+		}
+		entries := s.Range(start, data.MaxStreamID, read.count, false)
+		if len(entries) == 0 {
+			continue
+		}
+		replies = append(replies, resp.MakeMultiRawReply([]resp.Reply{
+			resp.MakeBulkReply(read.keys[i]),
+			makeStreamEntriesReply(entries),
+		}))
+	}
+	if len(replies) == 0 {
+		return resp.MakeNullMultiBulkReply()
+	}
+	return resp.MakeMultiRawReply(replies)
+}
+
+// xgroupSetIDCmdLine 传播消费组的 lastID 和已读条目数
+func xgroupSetIDCmdLine(key string, g *data.StreamGroup) [][]byte {
+	return [][]byte{
+		[]byte("xgroup"), []byte("setid"), []byte(key), []byte(g.Name), []byte(g.LastID.String()),
+		[]byte("entriesread"), []byte(strconv.FormatInt(g.EntriesRead, 10)),
+	}
+}
+
+func xgroupCreateConsumerCmdLine(key, group, consumer string) [][]byte {
+	return [][]byte{[]byte("xgroup"), []byte("createconsumer"), []byte(key), []byte(group), []byte(consumer)}
+}
+
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+// 投递以精确的 XCLAIM 和 XGROUP SETID 传播，重放时待确认列表的投递时间和次数保持不变
+func execXReadGroup(db types.Database, args [][]byte) resp.Reply {
+	read, errReply := parseStreamReadArgs("xreadgroup", args)
+	if errReply != nil {
+		return errReply
+	}
+
+	streams := make([]*data.Stream, len(read.keys))
+	groups := make([]*data.StreamGroup, len(read.keys))
+	starts := make([]data.StreamID, len(read.keys))
+	history := make([]bool, len(read.keys))
+	for i, key := range read.keys {
+		s, exists, errReply := getStream(db, string(key))
+		if errReply != nil {
+			return errReply
+		}
+		var g *data.StreamGroup
+		if exists {
+			g, _ = s.Group(read.group)
+		}
+		if g == nil {
+			return resp.MakeErrReply("NOGROUP No such key '" + string(key) + "' or consumer group '" + read.group + "' in XREADGROUP with GROUP option")
+		}
+		streams[i], groups[i] = s, g
+
+		switch string(read.ids[i]) {
+		case ">":
+			continue
+		case "$":
+			return resp.MakeErrReply("ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+		}
+		id, errReply := parseStreamID(read.ids[i], 0)
+		if errReply != nil {
+			return errReply
+		}
+		starts[i], history[i] = id, true
+	}
+
+	now := nowMs()
+	var replies []resp.Reply
+	var cmdLines [][][]byte
+	for i, s := range streams {
+		key, g := string(read.keys[i]), groups[i]
+		consumer, created := g.CreateConsumer(read.consumer, now)
+		if created {
+			cmdLines = append(cmdLines, xgroupCreateConsumerCmdLine(key, g.Name, consumer.Name))
+		}
+		consumer.SeenTime = now
+
+		var entries []resp.Reply
+		if history[i] {
+			// 读取该消费者待确认列表中大于指定 ID 的条目，已被删除的条目返回 nil
+			start, ok := starts[i].Incr()
+			if ok {
+				for _, nack := range consumer.Pending.Range(start, data.MaxStreamID, read.count) {
+					entry, exists := s.Get(nack.ID)
+					if !exists {
+						entries = append(entries, resp.MakeMultiRawReply([]resp.Reply{makeStreamIDReply(nack.ID), resp.MakeNullMultiBulkReply()}))
+						continue
+					}
+					nack.DeliveryTime = now
+					nack.DeliveryCount++
+					entries = append(entries, makeStreamEntryReply(entry))
+					cmdLines = append(cmdLines, data.XClaimCmdLine(key, g.Name, consumer.Name, nack))
+				}
+			}
+			replies = append(replies, resp.MakeMultiRawReply([]resp.Reply{
+				resp.MakeBulkReply(read.keys[i]),
+				resp.MakeMultiRawReply(entries),
+			}))
+			continue
+		}
+
+		start, ok := g.LastID.Incr()
+		if !ok {
+			continue
+		}
+		newEntries := s.Range(start, data.MaxStreamID, read.count, false)
+		if len(newEntries) == 0 {
+			continue
+		}
+		for _, entry := range newEntries {
+			s.DeliverNew(g, entry.ID)
+			if !read.noAck {
+				nack := g.Deliver(entry.ID, consumer, now, 1)
+				cmdLines = append(cmdLines, data.XClaimCmdLine(key, g.Name, consumer.Name, nack))
+			}
+			entries = append(entries, makeStreamEntryReply(entry))
+		}
+		consumer.ActiveTime = now
+		cmdLines = append(cmdLines, xgroupSetIDCmdLine(key, g))
+		replies = append(replies, resp.MakeMultiRawReply([]resp.Reply{
+			resp.MakeBulkReply(read.keys[i]),
+			resp.MakeMultiRawReply(entries),
+		}))
+	}
+
+	if len(replies) == 0 {
+		return makePropagateReply(resp.MakeNullMultiBulkReply(), cmdLines...)
+	}
+	return makePropagateReply(resp.MakeMultiRawReply(replies), cmdLines...)
+}
+
+// XACK key group id [id ...]
+func execXAck(db types.Database, args [][]byte) resp.Reply {
+	ids := make([]data.StreamID, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		id, errReply := parseStreamID(arg, 0)
+		if errReply != nil {
+			return errReply
+		}
+		ids = append(ids, id)
+	}
+
+	s, exists, errReply := getStream(db, string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if !exists {
+		return resp.MakeIntReply(0)
+	}
+	g, ok := s.Group(string(args[1]))
+	if !ok {
+		return resp.MakeIntReply(0)
+	}
+	acked := 0
+	for _, id := range ids {
+		if g.Ack(id) {
+			acked++
+		}
+	}
+	return resp.MakeIntReply(int64(acked))
+}
+
+// getStreamGroup 返回 key 上的消费组，key 或消费组不存在时返回 NOGROUP 错误
+func getStreamGroup(db types.Database, key, group string) (*data.Stream, *data.StreamGroup, resp.Reply) {
+	s, exists, errReply := getStream(db, key)
+	if errReply != nil {
+		return nil, nil, errReply
+	}
+	if !exists {
+		return nil, nil, makeNoGroupReply(key, group)
+	}
+	g, ok := s.Group(group)
+	if !ok {
+		return nil, nil, makeNoGroupReply(key, group)
+	}
+	return s, g, nil
+}
+
+// XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func execXPending(db types.Database, args [][]byte) resp.Reply {
+	key, group := string(args[0]), string(args[1])
+
+	// 摘要形式
+	if len(args) == 2 {
+		_, g, errReply := getStreamGroup(db, key, group)
+		if errReply != nil {
+			return errReply
+		}
+		if g.Pending.Len() == 0 {
+			return resp.MakeMultiRawReply([]resp.Reply{
+				resp.MakeIntReply(0), resp.MakeNullBulkReply(), resp.MakeNullBulkReply(), resp.MakeNullMultiBulkReply(),
+			})
+		}
+		var consumers []resp.Reply
+		for _, c := range g.Consumers() {
+			if c.Pending.Len() == 0 {
+				continue
+			}
+			consumers = append(consumers, resp.MakeMultiBulkReply([][]byte{
+				[]byte(c.Name), []byte(strconv.Itoa(c.Pending.Len())),
+			}))
+		}
+		return resp.MakeMultiRawReply([]resp.Reply{
+			resp.MakeIntReply(int64(g.Pending.Len())),
+			makeStreamIDReply(g.Pending.First()),
+			makeStreamIDReply(g.Pending.Last()),
+			resp.MakeMultiRawReply(consumers),
+		})
+	}
+
+	// 扩展形式
+	i := 2
+	var minIdle int64
+	if strings.EqualFold(string(args[i]), "idle") {
+		if len(args) < 4 {
+			return resp.MakeErrReply("ERR syntax error")
+		}
+		n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil {
+			return resp.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		minIdle = n
+		i += 2
+	}
+	if len(args)-i != 3 && len(args)-i != 4 {
+		return resp.MakeErrReply("ERR syntax error")
+	}
+	start, errReply := parseRangeStart(args[i])
+	if errReply != nil {
+		return errReply
+	}
+	end, errReply := parseRangeEnd(args[i+1])
+	if errReply != nil {
+		return errReply
+	}
+	count, err := strconv.ParseInt(string(args[i+2]), 10, 64)
+	if err != nil {
+		return resp.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	_, g, errReply := getStreamGroup(db, key, group)
+	if errReply != nil {
+		return errReply
+	}
+	pel := g.Pending
+	if len(args)-i == 4 {
+		c, ok := g.Consumer(string(args[i+3]))
+		if !ok {
+			return resp.MakeMultiRawReply(nil)
+		}
+		pel = c.Pending
+	}
+	if count <= 0 {
+		return resp.MakeMultiRawReply(nil)
+	}
+
+	now := nowMs()
+	var replies []resp.Reply
+	for _, nack := range pel.Range(start, end, 0) {
+		if len(replies) >= int(count) {
+			break
+		}
+		idle := now - nack.DeliveryTime
+		if idle < minIdle {
+			continue
+		}
+		replies = append(replies, resp.MakeMultiRawReply([]resp.Reply{
+			makeStreamIDReply(nack.ID),
+			resp.MakeBulkReply([]byte(nack.Consumer.Name)),
+			resp.MakeIntReply(max(idle, 0)),
+			resp.MakeIntReply(nack.DeliveryCount),
+		}))
+	}
+	return resp.MakeMultiRawReply(replies)
+}
+
+// XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds]
+// [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+func execXClaim(db types.Database, args [][]byte) resp.Reply {
+	key, group, consumerName := string(args[0]), string(args[1]), string(args[2])
+	minIdle, err := strconv.ParseInt(string(args[3]), 10, 64)
+	if err != nil {
+		return resp.MakeErrReply("ERR Invalid min-idle-time argument for XCLAIM")
+	}
+	minIdle = max(minIdle, 0)
+
+	// ID 之后是选项
+	var ids []data.StreamID
+	i := 4
+	for ; i < len(args); i++ {
+		id, err := data.ParseStreamID(string(args[i]), 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	now := nowMs()
+	deliveryTime := int64(-1)
+	retryCount := int64(-1)
+	var force, justID, hasLastID bool
+	var lastID data.StreamID
+	for ; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		opt := strings.ToLower(string(args[i]))
+		switch {
+		case opt == "force":
+			force = true
+		case opt == "justid":
+			justID = true
+		case opt == "idle" && remaining > 0:
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return resp.MakeErrReply("ERR Invalid IDLE option argument for XCLAIM")
+			}
+			deliveryTime = now - n
+			i++
+		case opt == "time" && remaining > 0:
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return resp.MakeErrReply("ERR Invalid TIME option argument for XCLAIM")
+			}
+			deliveryTime = n
+			i++
+		case opt == "retrycount" && remaining > 0:
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return resp.MakeErrReply("ERR Invalid RETRYCOUNT option argument for XCLAIM")
+			}
+			retryCount = n
+			i++
+		case opt == "lastid" && remaining > 0:
+			id, errReply := parseStreamID(args[i+1], 0)
+			if errReply != nil {
+				return errReply
+			}
+			lastID, hasLastID = id, true
+			i++
+		default:
+			return resp.MakeErrReply("ERR Unrecognized XCLAIM option '" + string(args[i]) + "'")
+		}
+	}
+	// 投递时间不能在未来
+	if deliveryTime < 0 || deliveryTime > now {
+		deliveryTime = now
+	}
+
+	s, g, errReply := getStreamGroup(db, key, group)
+	if errReply != nil {
+		return errReply
+	}
+
+	var cmdLines [][][]byte
+	if hasLastID && lastID.Compare(g.LastID) > 0 {
+		g.LastID = lastID
+		cmdLines = append(cmdLines, xgroupSetIDCmdLine(key, g))
+	}
+
+	var consumer *data.StreamConsumer
+	var replies []resp.Reply
+	for _, id := range ids {
+		nack, pending := g.Pending.Get(id)
+		entry, exists := s.Get(id)
+		// 条目已被删除，从待确认列表中移除
+		if !exists {
+			if pending {
+				cmdLines = append(cmdLines, data.XClaimCmdLine(key, group, nack.Consumer.Name, nack))
+				g.Ack(id)
+			}
+			continue
+		}
+		if !pending {
+			if !force {
+				continue
+			}
+		} else if minIdle > 0 && now-nack.DeliveryTime < minIdle {
+			continue
+		}
+
+		if consumer == nil {
+			consumer, _ = g.CreateConsumer(consumerName, now)
+			consumer.SeenTime = now
+		}
+		count := int64(1) // FORCE 新建的条目
+		if pending {
+			count = nack.DeliveryCount
+		}
+		if retryCount >= 0 {
+			count = retryCount
+		} else if !justID {
+			count++
+		}
+		nack = g.Deliver(id, consumer, deliveryTime, count)
+		consumer.ActiveTime = now
+
+		if justID {
+			replies = append(replies, makeStreamIDReply(id))
+		} else {
+			replies = append(replies, makeStreamEntryReply(entry))
+		}
+		cmdLines = append(cmdLines, data.XClaimCmdLine(key, group, consumerName, nack))
+	}
+	return makePropagateReply(resp.MakeMultiRawReply(replies), cmdLines...)
+}
+
+// XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+func execXAutoClaim(db types.Database, args [][]byte) resp.Reply {
+	key, group, consumerName := string(args[0]), string(args[1]), string(args[2])
+	minIdle, err := strconv.ParseInt(string(args[3]), 10, 64)
+	if err != nil {
+		return resp.MakeErrReply("ERR Invalid min-idle-time argument for XAUTOCLAIM")
+	}
+	minIdle = max(minIdle, 0)
+	start, errReply := parseRangeStart(args[4])
+	if errReply != nil {
+		return errReply
+	}
+
+	count := int64(100)
+	var justID bool
+	for i := 5; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "count":
+			if i+1 >= len(args) {
+				return resp.MakeErrReply("ERR syntax error")
+			}
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return resp.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if n < 1 || n > (1<<62)/streamAutoClaimAttemptsFactor {
+				return resp.MakeErrReply("ERR COUNT must be > 0")
+			}
+			count = n
+			i++
+		case "justid":
+			justID = true
+		default:
+			return resp.MakeErrReply("ERR syntax error")
+		}
+	}
+
+	s, g, errReply := getStreamGroup(db, key, group)
+	if errReply != nil {
+		return errReply
+	}
+
+	now := nowMs()
+	var cmdLines [][][]byte
+	consumer, created := g.CreateConsumer(consumerName, now)
+	if created {
+		cmdLines = append(cmdLines, xgroupCreateConsumerCmdLine(key, group, consumerName))
+	}
+	consumer.SeenTime = now
+
+	attempts := count * streamAutoClaimAttemptsFactor
+	candidates := g.Pending.Range(start, data.MaxStreamID, int(attempts)+1)
+	next := data.MinStreamID
+	var claimed []resp.Reply
+	var deleted [][]byte
+	for _, nack := range candidates {
+		if attempts == 0 || count == 0 {
+			next = nack.ID
+			break
+		}
+		attempts--
+
+		entry, exists := s.Get(nack.ID)
+		if !exists {
+			cmdLines = append(cmdLines, data.XClaimCmdLine(key, group, nack.Consumer.Name, nack))
+			g.Ack(nack.ID)
+			deleted = append(deleted, []byte(nack.ID.String()))
+			continue
+		}
+		if minIdle > 0 && now-nack.DeliveryTime < minIdle {
+			continue
+		}
+
+		deliveryCount := nack.DeliveryCount
+		if !justID {
+			deliveryCount++
+		}
+		nack = g.Deliver(nack.ID, consumer, now, deliveryCount)
+		consumer.ActiveTime = now
+		if justID {
+			claimed = append(claimed, makeStreamIDReply(nack.ID))
+		} else {
+			claimed = append(claimed, makeStreamEntryReply(entry))
+		}
+		cmdLines = append(cmdLines, data.XClaimCmdLine(key, group, consumerName, nack))
+		count--
+	}
+
+	return makePropagateReply(resp.MakeMultiRawReply([]resp.Reply{
+		makeStreamIDReply(next),
+		resp.MakeMultiRawReply(claimed),
+		resp.MakeMultiBulkReply(deleted),
+	}), cmdLines...)
+}
+
+// parseEntriesRead ENTRIESREAD 的取值，-1 表示未知
+func parseEntriesRead(arg []byte) (int64, resp.Reply) {
+	n, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, resp.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if n < 0 && n != data.InvalidEntriesRead {
+		return 0, resp.MakeErrReply("ERR value for ENTRIESREAD must be positive or -1")
+	}
+	return n, nil
+}
+
+// XGROUP CREATE key group id|$ [MKSTREAM] [ENTRIESREAD entries-read]
+// XGROUP SETID key group id|$ [ENTRIESREAD entries-read]
+// XGROUP DESTROY key group
+// XGROUP CREATECONSUMER key group consumer
+// XGROUP DELCONSUMER key group consumer
+func execXGroup(db types.Database, args [][]byte) resp.Reply {
+	subCmd := strings.ToLower(string(args[0]))
+	arityErr := resp.MakeErrReply("ERR wrong number of arguments for 'xgroup|" + subCmd + "' command")
+	switch subCmd {
+	case "create":
+		if len(args) < 4 || len(args) > 7 {
+			return arityErr
+		}
+	case "setid":
+		if len(args) != 4 && len(args) != 6 {
+			return arityErr
+		}
+	case "destroy":
+		if len(args) != 3 {
+			return arityErr
+		}
+	case "createconsumer", "delconsumer":
+		if len(args) != 4 {
+			return arityErr
+		}
+	case "help":
+		return resp.MakeMultiBulkReply([][]byte{
+			[]byte("XGROUP <subcommand> [<arg> [value] [opt] ...]. Subcommands are:"),
+			[]byte("CREATE <key> <groupname> <id|$> [option]"),
+			[]byte("CREATECONSUMER <key> <groupname> <consumer>"),
+			[]byte("DELCONSUMER <key> <groupname> <consumer>"),
+			[]byte("DESTROY <key> <groupname>"),
+			[]byte("SETID <key> <groupname> <id|$> [ENTRIESREAD entries_read]"),
+		})
+	default:
+		return resp.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try XGROUP HELP.")
+	}
+
+	key, group := string(args[1]), string(args[2])
+	s, exists, errReply := getStream(db, key)
+	if errReply != nil {
+		return errReply
+	}
+
+	// CREATE 和 SETID 的选项
+	var mkStream bool
+	entriesRead := int64(data.InvalidEntriesRead)
+	if subCmd == "create" || subCmd == "setid" {
+		for i := 4; i < len(args); i++ {
+			switch opt := strings.ToLower(string(args[i])); {
+			case opt == "mkstream" && subCmd == "create":
+				mkStream = true
+			case opt == "entriesread" && i+1 < len(args):
+				if entriesRead, errReply = parseEntriesRead(args[i+1]); errReply != nil {
+					return errReply
+				}
+				i++
+			default:
+				return resp.MakeErrReply("ERR syntax error")
+			}
+		}
+	}
+
+	if !exists {
+		if subCmd != "create" || !mkStream {
+			return resp.MakeErrReply("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+		}
+		s = data.NewStream()
+	}
+
+	var id data.StreamID
+	if subCmd == "create" || subCmd == "setid" {
+		if string(args[3]) == "$" {
+			id = s.LastID()
+		} else if id, errReply = parseStreamID(args[3], 0); errReply != nil {
+			return errReply
+		}
+	}
+
+	if subCmd == "create" {
+		if _, ok := s.CreateGroup(group, id, entriesRead); !ok {
+			return resp.MakeErrReply("BUSYGROUP Consumer Group name already exists")
+		}
+		if !exists {
+			db.PutEntity(key, &types.DataEntity{Data: s})
+		}
+		return resp.MakeOkReply()
+	}
+
+	g, ok := s.Group(group)
+	if !ok {
+		if subCmd == "destroy" {
+			return resp.MakeIntReply(0)
+		}
+		return resp.MakeErrReply("NOGROUP No such consumer group '" + group + "' for key name '" + key + "'")
+	}
+
+	switch subCmd {
+	case "setid":
+		g.LastID = id
+		g.EntriesRead = entriesRead
+		return resp.MakeOkReply()
+	case "destroy":
+		s.DestroyGroup(group)
+		return resp.MakeIntReply(1)
+	case "createconsumer":
+		if _, created := g.CreateConsumer(string(args[3]), nowMs()); !created {
+			return resp.MakeIntReply(0)
+		}
+		return resp.MakeIntReply(1)
+	default:
+		pending, _ := g.DeleteConsumer(string(args[3]))
+		return resp.MakeIntReply(int64(pending))
+	}
+}
+
+// makeStreamLagReply 消费组的延迟，无法确定时为 nil
+func makeStreamLagReply(s *data.Stream, g *data.StreamGroup) resp.Reply {
+	lag, ok := s.Lag(g)
+	if !ok {
+		return resp.MakeNullBulkReply()
+	}
+	return resp.MakeIntReply(lag)
+}
+
+func makeEntriesReadReply(g *data.StreamGroup) resp.Reply {
+	if g.EntriesRead == data.InvalidEntriesRead {
+		return resp.MakeNullBulkReply()
+	}
+	return resp.MakeIntReply(g.EntriesRead)
+}
+
+func makeOptionalEntryReply(entries []*data.StreamEntry) resp.Reply {
+	if len(entries) == 0 {
+		return resp.MakeNullBulkReply()
+	}
+	return makeStreamEntryReply(entries[0])
+}
+
+// XINFO STREAM key [FULL [COUNT count]]
+// XINFO GROUPS key
+// XINFO CONSUMERS key group
+func execXInfo(db types.Database, args [][]byte) resp.Reply {
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "help":
+		return resp.MakeMultiBulkReply([][]byte{
+			[]byte("XINFO <subcommand> [<arg> [value] [opt] ...]. Subcommands are:"),
+			[]byte("CONSUMERS <key> <groupname>"),
+			[]byte("GROUPS <key>"),
+			[]byte("STREAM <key> [FULL [COUNT <count>]]"),
+		})
+	case "stream", "groups", "consumers":
+	default:
+		return resp.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try XINFO HELP.")
+	}
+	if len(args) < 2 || (subCmd == "groups" && len(args) != 2) || (subCmd == "consumers" && len(args) != 3) {
+		return resp.MakeErrReply("ERR wrong number of arguments for 'xinfo|" + subCmd + "' command")
+	}
+
+	key := string(args[1])
+	s, exists, errReply := getStream(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if !exists {
+		return resp.MakeErrReply("ERR no such key")
+	}
+
+	now := nowMs()
+	switch subCmd {
+	case "groups":
+		var replies []resp.Reply
+		for _, g := range s.Groups() {
+			replies = append(replies, resp.MakeMultiRawReply([]resp.Reply{
+				resp.MakeBulkReply([]byte("name")), resp.MakeBulkReply([]byte(g.Name)),
+				resp.MakeBulkReply([]byte("consumers")), resp.MakeIntReply(int64(len(g.Consumers()))),
+				resp.MakeBulkReply([]byte("pending")), resp.MakeIntReply(int64(g.Pending.Len())),
+				resp.MakeBulkReply([]byte("last-delivered-id")), makeStreamIDReply(g.LastID),
+				resp.MakeBulkReply([]byte("entries-read")), makeEntriesReadReply(g),
+				resp.MakeBulkReply([]byte("lag")), makeStreamLagReply(s, g),
+			}))
+		}
+		return resp.MakeMultiRawReply(replies)
+
+	case "consumers":
+		group := string(args[2])
+		g, ok := s.Group(group)
+		if !ok {
+			return resp.MakeErrReply("NOGROUP No such consumer group '" + group + "' for key name '" + key + "'")
+		}
+		var replies []resp.Reply
+		for _, c := range g.Consumers() {
+			inactive := int64(-1)
+			if c.ActiveTime != -1 {
+				inactive = now - c.ActiveTime
+			}
+			replies = append(replies, resp.MakeMultiRawReply([]resp.Reply{
+				resp.MakeBulkReply([]byte("name")), resp.MakeBulkReply([]byte(c.Name)),
+				resp.MakeBulkReply([]byte("pending")), resp.MakeIntReply(int64(c.Pending.Len())),
+				resp.MakeBulkReply([]byte("idle")), resp.MakeIntReply(now - c.SeenTime),
+				resp.MakeBulkReply([]byte("inactive")), resp.MakeIntReply(inactive),
+			}))
+		}
+		return resp.MakeMultiRawReply(replies)
+	}
+
+	// STREAM
+	full := false
+	count := 10
+	if len(args) > 2 {
+		if !strings.EqualFold(string(args[2]), "full") {
+			return resp.MakeErrReply("ERR syntax error")
+		}
+		full = true
+		if len(args) > 3 {
+			if len(args) != 5 || !strings.EqualFold(string(args[3]), "count") {
+				return resp.MakeErrReply("ERR syntax error")
+			}
+			n, err := strconv.ParseInt(string(args[4]), 10, 64)
+			if err != nil {
+				return resp.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			count = int(max(n, 0))
+		}
+	}
+
+	replies := []resp.Reply{
+		resp.MakeBulkReply([]byte("length")), resp.MakeIntReply(int64(s.Len())),
+		resp.MakeBulkReply([]byte("radix-tree-keys")), resp.MakeIntReply(int64(s.NodeCount())),
+		resp.MakeBulkReply([]byte("radix-tree-nodes")), resp.MakeIntReply(int64(s.NodeCount())),
+		resp.MakeBulkReply([]byte("last-generated-id")), makeStreamIDReply(s.LastID()),
+		resp.MakeBulkReply([]byte("max-deleted-entry-id")), makeStreamIDReply(s.MaxDeletedID()),
+		resp.MakeBulkReply([]byte("entries-added")), resp.MakeIntReply(int64(s.EntriesAdded())),
+		resp.MakeBulkReply([]byte("recorded-first-entry-id")), makeStreamIDReply(s.FirstID()),
+	}
+	if !full {
+		replies = append(replies,
+			resp.MakeBulkReply([]byte("groups")), resp.MakeIntReply(int64(len(s.Groups()))),
+			resp.MakeBulkReply([]byte("first-entry")), makeOptionalEntryReply(s.Range(data.MinStreamID, data.MaxStreamID, 1, false)),
+			resp.MakeBulkReply([]byte("last-entry")), makeOptionalEntryReply(s.Range(data.MinStreamID, data.MaxStreamID, 1, true)),
+		)
+		return resp.MakeMultiRawReply(replies)
+	}
+
+	// FULL：条目、消费组、待确认列表和消费者的完整信息，COUNT 为 0 表示不限制
+	replies = append(replies,
+		resp.MakeBulkReply([]byte("entries")), makeStreamEntriesReply(s.Range(data.MinStreamID, data.MaxStreamID, count, false)),
+	)
+	var groups []resp.Reply
+	for _, g := range s.Groups() {
+		var pending []resp.Reply
+		for _, nack := range g.Pending.Range(data.MinStreamID, data.MaxStreamID, count) {
+			pending = append(pending, resp.MakeMultiRawReply([]resp.Reply{
+				makeStreamIDReply(nack.ID),
+				resp.MakeBulkReply([]byte(nack.Consumer.Name)),
+				resp.MakeIntReply(nack.DeliveryTime),
+				resp.MakeIntReply(nack.DeliveryCount),
+			}))
+		}
+		var consumers []resp.Reply
+		for _, c := range g.Consumers() {
+			var consumerPending []resp.Reply
+			for _, nack := range c.Pending.Range(data.MinStreamID, data.MaxStreamID, count) {
+				consumerPending = append(consumerPending, resp.MakeMultiRawReply([]resp.Reply{
+					makeStreamIDReply(nack.ID),
+					resp.MakeIntReply(nack.DeliveryTime),
+					resp.MakeIntReply(nack.DeliveryCount),
+				}))
+			}
+			consumers = append(consumers, resp.MakeMultiRawReply([]resp.Reply{
+				resp.MakeBulkReply([]byte("name")), resp.MakeBulkReply([]byte(c.Name)),
+				resp.MakeBulkReply([]byte("seen-time")), resp.MakeIntReply(c.SeenTime),
+				resp.MakeBulkReply([]byte("active-time")), resp.MakeIntReply(c.ActiveTime),
+				resp.MakeBulkReply([]byte("pel-count")), resp.MakeIntReply(int64(c.Pending.Len())),
+				resp.MakeBulkReply([]byte("pending")), resp.MakeMultiRawReply(consumerPending),
+			}))
+		}
+		groups = append(groups, resp.MakeMultiRawReply([]resp.Reply{
+			resp.MakeBulkReply([]byte("name")), resp.MakeBulkReply([]byte(g.Name)),
+			resp.MakeBulkReply([]byte("last-delivered-id")), makeStreamIDReply(g.LastID),
+			resp.MakeBulkReply([]byte("entries-read")), makeEntriesReadReply(g),
+			resp.MakeBulkReply([]byte("lag")), makeStreamLagReply(s, g),
+			resp.MakeBulkReply([]byte("pel-count")), resp.MakeIntReply(int64(g.Pending.Len())),
+			resp.MakeBulkReply([]byte("pending")), resp.MakeMultiRawReply(pending),
+			resp.MakeBulkReply([]byte("consumers")), resp.MakeMultiRawReply(consumers),
+		}))
+	}
+	replies = append(replies, resp.MakeBulkReply([]byte("groups")), resp.MakeMultiRawReply(groups))
+	return resp.MakeMultiRawReply(replies)
+}
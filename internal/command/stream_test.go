@@ -0,0 +1,238 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"goredis/internal/resp"
+)
+
+// propagated 拆出 PropagateReply 中的回复和传播的命令
+func propagated(reply resp.Reply) (resp.Reply, []string) {
+	p, ok := reply.(*PropagateReply)
+	if !ok {
+		return reply, nil
+	}
+	var cmdLines []string
+	for _, cmdLine := range p.CmdLines {
+		args := make([]string, len(cmdLine))
+		for i, arg := range cmdLine {
+			args[i] = string(arg)
+		}
+		cmdLines = append(cmdLines, strings.Join(args, " "))
+	}
+	return p.Reply, cmdLines
+}
+
+func assertReplyBytes(t *testing.T, reply resp.Reply, want string) {
+	t.Helper()
+	reply, _ = propagated(reply)
+	if got := string(reply.ToBytes()); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func assertStreamError(t *testing.T, reply resp.Reply, want string) {
+	t.Helper()
+	reply, _ = propagated(reply)
+	if msg := getErrorString(t, reply); msg != want {
+		t.Errorf("expected error %q, got %q", want, msg)
+	}
+}
+
+func TestStreamCommands(t *testing.T) {
+	db := NewMockDB()
+
+	t.Run("XADD", func(t *testing.T) {
+		reply, cmdLines := propagated(execXAdd(db, toArgs("s", "1-1", "a", "1")))
+		assertEqualBulk(t, reply, []byte("1-1"))
+		if len(cmdLines) != 1 || cmdLines[0] != "xadd s 1-1 a 1" {
+			t.Errorf("unexpected propagation %q", cmdLines)
+		}
+		assertReplyBytes(t, execXAdd(db, toArgs("s", "1-*", "b", "2")), "$3\r\n1-2\r\n")
+		assertReplyBytes(t, execXAdd(db, toArgs("s", "2", "c", "3")), "$3\r\n2-0\r\n")
+
+		// 自动生成的 ID 以确定的值传播
+		reply, cmdLines = propagated(execXAdd(db, toArgs("s", "*", "d", "4")))
+		id := string(getBulkValue(t, reply))
+		if len(cmdLines) != 1 || cmdLines[0] != "xadd s "+id+" d 4" {
+			t.Errorf("unexpected propagation %q", cmdLines)
+		}
+		assertEqualInt(t, execXLen(db, toArgs("s")), 4)
+
+		assertStreamError(t, execXAdd(db, toArgs("s", "1-5", "a", "1")), "ERR The ID specified in XADD is equal or smaller than the target stream top item")
+		assertStreamError(t, execXAdd(db, toArgs("s", "0-0", "a", "1")), "ERR The ID specified in XADD must be greater than 0-0")
+		assertStreamError(t, execXAdd(db, toArgs("s", "*", "a")), "ERR wrong number of arguments for 'xadd' command")
+		assertStreamError(t, execXAdd(db, toArgs("s", "maxlen", "1", "limit", "5", "*", "a", "1")), "ERR syntax error, LIMIT cannot be used without the special ~ option")
+		assertStreamError(t, execXAdd(db, toArgs("s", "maxlen", "-1", "*", "a", "1")), "ERR The MAXLEN argument must be >= 0.")
+		assertStreamError(t, execXAdd(db, toArgs("s", "x-y", "a", "1")), "ERR Invalid stream ID specified as stream command argument")
+
+		assertReplyBytes(t, execXAdd(db, toArgs("nostream", "nomkstream", "*", "a", "1")), "$-1\r\n")
+		if _, exists := db.GetEntity("nostream"); exists {
+			t.Error("NOMKSTREAM should not create the key")
+		}
+	})
+
+	t.Run("XADD trimming", func(t *testing.T) {
+		for i := 1; i <= 250; i++ {
+			execXAdd(db, toArgs("big", "*", "i", "v"))
+		}
+		// 近似裁剪以实际的长度传播
+		_, cmdLines := propagated(execXAdd(db, toArgs("big", "maxlen", "~", "100", "*", "i", "v")))
+		if len(cmdLines) != 1 || !strings.HasPrefix(cmdLines[0], "xadd big maxlen = 151 ") {
+			t.Errorf("unexpected propagation %q", cmdLines)
+		}
+		assertEqualInt(t, execXLen(db, toArgs("big")), 151)
+		_, cmdLines = propagated(execXTrim(db, toArgs("big", "maxlen", "=", "10")))
+		if len(cmdLines) != 1 || cmdLines[0] != "xtrim big maxlen = 10" {
+			t.Errorf("unexpected propagation %q", cmdLines)
+		}
+		assertEqualInt(t, execXLen(db, toArgs("big")), 10)
+		reply, _ := propagated(execXTrim(db, toArgs("big", "minid", "0")))
+		assertEqualInt(t, reply, 0)
+	})
+
+	t.Run("XRANGE XREVRANGE XDEL", func(t *testing.T) {
+		assertReplyBytes(t, execXRange(db, toArgs("s", "-", "1-2")),
+			"*2\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n")
+		assertReplyBytes(t, execXRange(db, toArgs("s", "(1-1", "1", "COUNT", "5")),
+			"*1\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n")
+		assertReplyBytes(t, execXRevRange(db, toArgs("s", "2", "-", "COUNT", "1")),
+			"*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n")
+		assertReplyBytes(t, execXRange(db, toArgs("s", "-", "+", "COUNT", "0")), "*0\r\n")
+		assertReplyBytes(t, execXRange(db, toArgs("missing", "-", "+")), "*0\r\n")
+		assertStreamError(t, execXRange(db, toArgs("s", "(18446744073709551615-18446744073709551615", "+")), "ERR invalid start ID for the interval")
+
+		assertEqualInt(t, execXDel(db, toArgs("s", "1-2", "1-2", "9-9")), 1)
+		assertEqualInt(t, execXLen(db, toArgs("s")), 3)
+	})
+
+	t.Run("XREAD", func(t *testing.T) {
+		assertReplyBytes(t, execXRead(db, toArgs("COUNT", "1", "STREAMS", "s", "missing", "1-1", "0")),
+			"*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n")
+		assertReplyBytes(t, execXRead(db, toArgs("STREAMS", "s", "$")), "*-1\r\n")
+		assertStreamError(t, execXRead(db, toArgs("STREAMS", "s", ">")), "ERR The > ID can be specified only when calling XREADGROUP using the GROUP <group> <consumer> option.")
+		assertStreamError(t, execXRead(db, toArgs("STREAMS", "s", "t", "0")), "ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+
+		if keys := StreamReadKeys(toArgs("xread", "COUNT", "1", "STREAMS", "a", "b", "0", "0")); len(keys) != 2 || keys[1] != "b" {
+			t.Errorf("unexpected keys %q", keys)
+		}
+		if timeout, ok := StreamBlockTimeout(toArgs("xread", "BLOCK", "1500", "STREAMS", "a", "0")); !ok || timeout.Milliseconds() != 1500 {
+			t.Errorf("unexpected timeout %v, %v", timeout, ok)
+		}
+		if _, ok := StreamBlockTimeout(toArgs("xread", "STREAMS", "a", "0")); ok {
+			t.Error("XREAD without BLOCK should not block")
+		}
+		resolved := ResolveStreamLastIDs(db, toArgs("xread", "BLOCK", "0", "STREAMS", "s", "missing", "$", "$"))
+		if string(resolved[6]) == "$" || string(resolved[7]) != "0-0" {
+			t.Errorf("unexpected resolved ids %q", resolved[6:])
+		}
+	})
+
+	t.Run("XSETID", func(t *testing.T) {
+		assertOKReply(t, execXSetID(db, toArgs("s", "99999999999999-0", "ENTRIESADDED", "10", "MAXDELETEDID", "1-2")))
+		assertStreamError(t, execXSetID(db, toArgs("s", "1-0")), "ERR The ID specified in XSETID is smaller than the target stream top item")
+		assertStreamError(t, execXSetID(db, toArgs("s", "99999999999999-0", "ENTRIESADDED", "1")), "ERR The entries_added specified in XSETID is smaller than the target stream length")
+		assertStreamError(t, execXSetID(db, toArgs("missing", "1-0")), "ERR no such key")
+		assertStreamError(t, execXAdd(db, toArgs("s", "99999999999998-0", "a", "1")), "ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	})
+}
+
+func TestStreamGroupCommands(t *testing.T) {
+	db := NewMockDB()
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		execXAdd(db, toArgs("s", id, "f", id))
+	}
+
+	t.Run("XGROUP", func(t *testing.T) {
+		assertOKReply(t, execXGroup(db, toArgs("CREATE", "s", "g", "0")))
+		assertStreamError(t, execXGroup(db, toArgs("CREATE", "s", "g", "0")), "BUSYGROUP Consumer Group name already exists")
+		assertStreamError(t, execXGroup(db, toArgs("CREATE", "missing", "g", "$")),
+			"ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+		assertOKReply(t, execXGroup(db, toArgs("CREATE", "empty", "g", "$", "MKSTREAM")))
+		assertStreamError(t, execXGroup(db, toArgs("SETID", "s", "nogroup", "0")), "NOGROUP No such consumer group 'nogroup' for key name 's'")
+		assertStreamError(t, execXGroup(db, toArgs("CREATE", "s", "g2", "0", "ENTRIESREAD", "-2")), "ERR value for ENTRIESREAD must be positive or -1")
+		assertEqualInt(t, execXGroup(db, toArgs("CREATECONSUMER", "s", "g", "alice")), 1)
+		assertEqualInt(t, execXGroup(db, toArgs("CREATECONSUMER", "s", "g", "alice")), 0)
+		assertEqualInt(t, execXGroup(db, toArgs("DESTROY", "empty", "g")), 1)
+	})
+
+	t.Run("XREADGROUP", func(t *testing.T) {
+		reply, cmdLines := propagated(execXReadGroup(db, toArgs("GROUP", "g", "alice", "COUNT", "2", "STREAMS", "s", ">")))
+		assertReplyBytes(t, reply,
+			"*1\r\n*2\r\n$1\r\ns\r\n*2\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\nf\r\n$3\r\n1-0\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nf\r\n$3\r\n2-0\r\n")
+		if len(cmdLines) != 3 || !strings.HasPrefix(cmdLines[0], "xclaim s g alice 0 1-0 time ") ||
+			!strings.HasSuffix(cmdLines[0], " retrycount 1 force justid") || cmdLines[2] != "xgroup setid s g 2-0 entriesread 2" {
+			t.Errorf("unexpected propagation %q", cmdLines)
+		}
+
+		// 读取历史，新的消费者需要传播
+		reply, cmdLines = propagated(execXReadGroup(db, toArgs("GROUP", "g", "bob", "STREAMS", "s", "0")))
+		assertReplyBytes(t, reply, "*1\r\n*2\r\n$1\r\ns\r\n*0\r\n")
+		if len(cmdLines) != 1 || cmdLines[0] != "xgroup createconsumer s g bob" {
+			t.Errorf("unexpected propagation %q", cmdLines)
+		}
+
+		execXDel(db, toArgs("s", "2-0"))
+		assertReplyBytes(t, execXReadGroup(db, toArgs("GROUP", "g", "alice", "STREAMS", "s", "0")),
+			"*1\r\n*2\r\n$1\r\ns\r\n*2\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\nf\r\n$3\r\n1-0\r\n*2\r\n$3\r\n2-0\r\n*-1\r\n")
+
+		assertStreamError(t, execXReadGroup(db, toArgs("GROUP", "nogroup", "alice", "STREAMS", "s", ">")),
+			"NOGROUP No such key 's' or consumer group 'nogroup' in XREADGROUP with GROUP option")
+		assertStreamError(t, execXReadGroup(db, toArgs("GROUP", "g", "alice", "STREAMS", "s", "$")),
+			"ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+	})
+
+	t.Run("XPENDING XACK", func(t *testing.T) {
+		assertReplyBytes(t, execXPending(db, toArgs("s", "g")),
+			"*4\r\n:2\r\n$3\r\n1-0\r\n$3\r\n2-0\r\n*1\r\n*2\r\n$5\r\nalice\r\n$1\r\n2\r\n")
+		reply := execXPending(db, toArgs("s", "g", "-", "+", "10", "alice"))
+		// 读取历史增加了 1-0 的投递次数，已删除的 2-0 不变
+		if got := string(reply.ToBytes()); !strings.HasPrefix(got, "*2\r\n*4\r\n$3\r\n1-0\r\n$5\r\nalice\r\n:") ||
+			!strings.Contains(got, ":2\r\n*4\r\n$3\r\n2-0\r\n") || !strings.HasSuffix(got, ":1\r\n") {
+			t.Errorf("unexpected reply %q", got)
+		}
+		assertReplyBytes(t, execXPending(db, toArgs("s", "g", "IDLE", "100000", "-", "+", "10")), "*0\r\n")
+
+		assertEqualInt(t, execXAck(db, toArgs("s", "g", "1-0", "9-0")), 1)
+		assertEqualInt(t, execXAck(db, toArgs("s", "nogroup", "2-0")), 0)
+	})
+
+	t.Run("XCLAIM XAUTOCLAIM", func(t *testing.T) {
+		execXReadGroup(db, toArgs("GROUP", "g", "alice", "STREAMS", "s", ">"))
+
+		// 未达到空闲时间
+		assertReplyBytes(t, execXClaim(db, toArgs("s", "g", "bob", "100000", "3-0")), "*0\r\n")
+		reply, cmdLines := propagated(execXClaim(db, toArgs("s", "g", "bob", "0", "3-0", "JUSTID")))
+		assertReplyBytes(t, reply, "*1\r\n$3\r\n3-0\r\n")
+		if len(cmdLines) != 1 || !strings.Contains(cmdLines[0], "xclaim s g bob 0 3-0 ") || !strings.Contains(cmdLines[0], "retrycount 1 ") {
+			t.Errorf("unexpected propagation %q", cmdLines)
+		}
+		assertStreamError(t, execXClaim(db, toArgs("s", "g", "bob", "x", "3-0")), "ERR Invalid min-idle-time argument for XCLAIM")
+		assertStreamError(t, execXClaim(db, toArgs("s", "g", "bob", "0", "3-0", "FOO")), "ERR Unrecognized XCLAIM option 'FOO'")
+
+		// 2-0 已被删除，从待确认列表中清除
+		reply, _ = propagated(execXAutoClaim(db, toArgs("s", "g", "carol", "0", "0", "COUNT", "10")))
+		assertReplyBytes(t, reply,
+			"*3\r\n$3\r\n0-0\r\n*1\r\n*2\r\n$3\r\n3-0\r\n*2\r\n$1\r\nf\r\n$3\r\n3-0\r\n*1\r\n$3\r\n2-0\r\n")
+		assertReplyBytes(t, execXPending(db, toArgs("s", "g")),
+			"*4\r\n:1\r\n$3\r\n3-0\r\n$3\r\n3-0\r\n*1\r\n*2\r\n$5\r\ncarol\r\n$1\r\n1\r\n")
+	})
+
+	t.Run("XINFO", func(t *testing.T) {
+		reply := execXInfo(db, toArgs("GROUPS", "s"))
+		want := "*1\r\n*12\r\n$4\r\nname\r\n$1\r\ng\r\n$9\r\nconsumers\r\n:3\r\n$7\r\npending\r\n:1\r\n" +
+			"$17\r\nlast-delivered-id\r\n$3\r\n3-0\r\n$12\r\nentries-read\r\n:3\r\n$3\r\nlag\r\n:0\r\n"
+		if got := string(reply.ToBytes()); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+		if got := string(execXInfo(db, toArgs("STREAM", "s")).ToBytes()); !strings.HasPrefix(got, "*20\r\n$6\r\nlength\r\n:2\r\n") {
+			t.Errorf("unexpected reply %q", got)
+		}
+		if got := string(execXInfo(db, toArgs("STREAM", "s", "FULL")).ToBytes()); !strings.Contains(got, "$7\r\nentries\r\n*2\r\n") {
+			t.Errorf("unexpected reply %q", got)
+		}
+		assertEqualInt(t, execXGroup(db, toArgs("DELCONSUMER", "s", "g", "carol")), 1)
+		assertStreamError(t, execXInfo(db, toArgs("CONSUMERS", "s", "nogroup")), "NOGROUP No such consumer group 'nogroup' for key name 's'")
+	})
+}
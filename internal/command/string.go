@@ -1,6 +1,7 @@
 package command
 
 import (
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -51,12 +52,20 @@ func execIncrBy(db types.Database, args [][]byte) resp.Reply {
 
 	val, err := str.IncrBy(delta)
 	if err != nil {
-		return resp.MakeErrReply("ERR value is not an integer")
+		return incrErrReply(err)
 	}
 
 	return resp.MakeIntReply(val)
 }
 
+// incrErrReply 把 IncrBy 的错误转换成错误回复
+func incrErrReply(err error) resp.Reply {
+	if err == data.ErrOverflow {
+		return resp.MakeErrReply("ERR increment or decrement would overflow")
+	}
+	return resp.MakeErrReply("ERR value is not an integer")
+}
+
 func execAppend(db types.Database, args [][]byte) resp.Reply {
 	key := string(args[0])
 	appendVal := args[1]
@@ -115,6 +124,9 @@ func execDecrBy(db types.Database, args [][]byte) resp.Reply {
 	if err != nil {
 		return resp.MakeErrReply("ERR value is not an integer or out of range")
 	}
+	if delta == math.MinInt64 {
+		return resp.MakeErrReply("ERR decrement would overflow")
+	}
 
 	entity, exists := db.GetEntity(key)
 	if !exists {
@@ -130,63 +142,192 @@ func execDecrBy(db types.Database, args [][]byte) resp.Reply {
 
 	val, err := str.IncrBy(-delta)
 	if err != nil {
-		return resp.MakeErrReply("ERR value is not an integer")
+		return incrErrReply(err)
 	}
 
 	return resp.MakeIntReply(val)
 }
 
-func execSet(db types.Database, args [][]byte) resp.Reply {
-	key := string(args[0])
-	value := args[1]
+// setOptions SET 命令的选项
+type setOptions struct {
+	nx, xx, get, keepTTL bool
 
-	var (
-		useNX    bool
-		expireAt time.Time
-		hasTTL   bool
-	)
+	hasTTL   bool
+	expireAt time.Time
+}
 
-	// 1. 解析参数
-	for i := 2; i < len(args); i++ {
+func parseSetOptions(args [][]byte) (setOptions, resp.Reply) {
+	var opts setOptions
+	syntaxErr := resp.MakeErrReply("ERR syntax error")
+	for i := 0; i < len(args); i++ {
 		option := strings.ToLower(string(args[i]))
 		switch option {
-		case "ex":
+		case "ex", "px", "exat", "pxat":
 			if i+1 >= len(args) {
-				return resp.MakeErrReply("ERR wrong number of arguments for 'set' command")
+				return opts, resp.MakeErrReply("ERR wrong number of arguments for 'set' command")
 			}
-			seconds, err := strconv.Atoi(string(args[i+1]))
-			if err != nil || seconds <= 0 {
-				return resp.MakeErrReply("ERR invalid expire time")
+			if opts.hasTTL || opts.keepTTL {
+				return opts, syntaxErr
 			}
-			expireAt = time.Now().Add(time.Duration(seconds) * time.Second)
-			hasTTL = true
+			expireAt, errReply := parseExpireOption(option, args[i+1], "set")
+			if errReply != nil {
+				return opts, errReply
+			}
+			opts.expireAt = expireAt
+			opts.hasTTL = true
 			i++
 		case "nx":
-			useNX = true
+			if opts.xx {
+				return opts, syntaxErr
+			}
+			opts.nx = true
+		case "xx":
+			if opts.nx {
+				return opts, syntaxErr
+			}
+			opts.xx = true
+		case "keepttl":
+			if opts.hasTTL {
+				return opts, syntaxErr
+			}
+			opts.keepTTL = true
+		case "get":
+			opts.get = true
 		default:
-			return resp.MakeErrReply("ERR unknown option")
+			return opts, syntaxErr
 		}
 	}
+	return opts, nil
+}
+
+// parseExpireOption 解析 EX/PX/EXAT/PXAT 的参数，时间必须为正数
+func parseExpireOption(option string, arg []byte, cmdName string) (time.Time, resp.Reply) {
+	opt := setExpireOptions[option]
+	ms, errReply := parseExpireAt(arg, opt.unit, opt.relative, time.Now())
+	if n, err := strconv.ParseInt(string(arg), 10, 64); errReply != nil || err != nil || n <= 0 {
+		return time.Time{}, resp.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+	}
+	return time.UnixMilli(ms), nil
+}
+
+// SET key value [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT unix-time-seconds|PXAT unix-time-milliseconds|KEEPTTL]
+func execSet(db types.Database, args [][]byte) resp.Reply {
+	key := string(args[0])
+	value := args[1]
+
+	// 1. 解析参数
+	opts, errReply := parseSetOptions(args[2:])
+	if errReply != nil {
+		return errReply
+	}
 
-	// 2. NX 语义判断（在写之前）
-	if useNX {
-		if _, exists := db.GetEntity(key); exists {
-			return resp.MakeNullBulkReply()
+	// 2. GET 需要旧值是字符串，否则不做任何修改
+	entity, exists := db.GetEntity(key)
+	var oldReply resp.Reply = resp.MakeNullBulkReply()
+	if opts.get && exists {
+		str, ok := entity.Data.(*data.SimpleString)
+		if !ok {
+			return resp.MakeErrReply("ERR wrong type")
 		}
+		oldReply = resp.MakeBulkReply(str.Get())
 	}
-	str := data.NewStringFromBytes(value)
-	// 3. 写入数据（覆盖写会清理旧 TTL）
-	db.PutEntity(key, &types.DataEntity{Data: str})
-	db.DeleteTTL(key)
 
-	// 4. 设置过期时间
-	if hasTTL {
-		db.SetExpire(key, expireAt)
+	// 3. NX/XX 语义判断（在写之前）
+	if (opts.nx && exists) || (opts.xx && !exists) {
+		return oldReply
+	}
+
+	// 4. 写入数据（覆盖写会清理旧 TTL，除非指定了 KEEPTTL）
+	db.PutEntity(key, &types.DataEntity{Data: data.NewStringFromBytes(value)})
+	if !opts.keepTTL {
+		db.DeleteTTL(key)
+	}
+
+	// 5. 设置过期时间
+	if opts.hasTTL {
+		db.SetExpire(key, opts.expireAt)
+	}
+
+	if opts.get {
+		return oldReply
+	}
+	return resp.MakeOkReply()
+}
+
+// SET 的过期选项，换算成毫秒时间戳时使用
+var setExpireOptions = map[string]struct {
+	unit     time.Duration
+	relative bool
+}{
+	"ex":   {time.Second, true},
+	"px":   {time.Millisecond, true},
+	"exat": {time.Second, false},
+	"pxat": {time.Millisecond, false},
+}
+
+// translateSet 把 SET 的 EX/PX/EXAT 换算成 PXAT
+func translateSet(cmdLine [][]byte) [][]byte {
+	return translateExpireOption(cmdLine, 3)
+}
+
+// translateExpireOption 从 from 开始查找 EX/PX/EXAT 选项并换算成 PXAT。
+// 时间不是正数时保持原样，由执行函数返回 invalid expire time 错误
+func translateExpireOption(cmdLine [][]byte, from int) [][]byte {
+	for i := from; i+1 < len(cmdLine); i++ {
+		option := strings.ToLower(string(cmdLine[i]))
+		if _, ok := setExpireOptions[option]; !ok {
+			continue
+		}
+		if option == "pxat" {
+			return cmdLine
+		}
+		expireAt, errReply := parseExpireOption(option, cmdLine[i+1], "")
+		if errReply != nil {
+			return cmdLine
+		}
+		translated := append([][]byte(nil), cmdLine...)
+		translated[i] = []byte("pxat")
+		translated[i+1] = []byte(strconv.FormatInt(expireAt.UnixMilli(), 10))
+		return translated
 	}
+	return cmdLine
+}
+
+// SETEX key seconds value
+func execSetEx(db types.Database, args [][]byte) resp.Reply {
+	return setExGeneric(db, args, "ex", "setex")
+}
 
+// PSETEX key milliseconds value
+func execPSetEx(db types.Database, args [][]byte) resp.Reply {
+	return setExGeneric(db, args, "px", "psetex")
+}
+
+func setExGeneric(db types.Database, args [][]byte, option string, cmdName string) resp.Reply {
+	key := string(args[0])
+	expireAt, errReply := parseExpireOption(option, args[1], cmdName)
+	if errReply != nil {
+		return errReply
+	}
+	db.PutEntity(key, &types.DataEntity{Data: data.NewStringFromBytes(args[2])})
+	db.SetExpire(key, expireAt)
 	return resp.MakeOkReply()
 }
 
+// translateSetEx 把 SETEX/PSETEX 改写成 SET key value PXAT，参数错误时保持原样
+func translateSetEx(option string) func(cmdLine [][]byte) [][]byte {
+	return func(cmdLine [][]byte) [][]byte {
+		expireAt, errReply := parseExpireOption(option, cmdLine[2], "")
+		if errReply != nil {
+			return cmdLine
+		}
+		return [][]byte{
+			[]byte("set"), cmdLine[1], cmdLine[3],
+			[]byte("pxat"), []byte(strconv.FormatInt(expireAt.UnixMilli(), 10)),
+		}
+	}
+}
+
 func execGet(db types.Database, args [][]byte) resp.Reply {
 	key := string(args[0])
 
@@ -242,3 +383,317 @@ func execMGet(db types.Database, args [][]byte) resp.Reply {
 
 	return resp.MakeMultiBulkReply(result)
 }
+
+// GETSET key value
+func execGetSet(db types.Database, args [][]byte) resp.Reply {
+	key := string(args[0])
+	str, exists, errReply := getString(db, key)
+	if errReply != nil {
+		return errReply
+	}
+
+	var reply resp.Reply = resp.MakeNullBulkReply()
+	if exists {
+		reply = resp.MakeBulkReply(str.Get())
+	}
+	db.PutEntity(key, &types.DataEntity{Data: data.NewStringFromBytes(args[1])})
+	db.DeleteTTL(key)
+	return reply
+}
+
+// GETDEL key
+func execGetDel(db types.Database, args [][]byte) resp.Reply {
+	key := string(args[0])
+	str, exists, errReply := getString(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if !exists {
+		return resp.MakeNullBulkReply()
+	}
+	db.Remove(key)
+	return resp.MakeBulkReply(str.Get())
+}
+
+// GETEX key [EX seconds|PX milliseconds|EXAT unix-time-seconds|PXAT unix-time-milliseconds|PERSIST]
+func execGetEx(db types.Database, args [][]byte) resp.Reply {
+	key := string(args[0])
+
+	var (
+		expireAt time.Time
+		hasTTL   bool
+		persist  bool
+	)
+	for i := 1; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		switch option {
+		case "ex", "px", "exat", "pxat":
+			if i+1 >= len(args) || hasTTL || persist {
+				return resp.MakeErrReply("ERR syntax error")
+			}
+			at, errReply := parseExpireOption(option, args[i+1], "getex")
+			if errReply != nil {
+				return errReply
+			}
+			expireAt, hasTTL = at, true
+			i++
+		case "persist":
+			if hasTTL {
+				return resp.MakeErrReply("ERR syntax error")
+			}
+			persist = true
+		default:
+			return resp.MakeErrReply("ERR syntax error")
+		}
+	}
+
+	str, exists, errReply := getString(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	if !exists {
+		return resp.MakeNullBulkReply()
+	}
+
+	value := str.Get()
+	switch {
+	case hasTTL && !expireAt.After(time.Now()):
+		db.Remove(key)
+	case hasTTL:
+		db.SetExpire(key, expireAt)
+	case persist:
+		db.DeleteTTL(key)
+	}
+	return resp.MakeBulkReply(value)
+}
+
+// translateGetEx 把 GETEX 的 EX/PX/EXAT 换算成 PXAT，时间不是正数时保持原样，由 execGetEx 报错
+func translateGetEx(cmdLine [][]byte) [][]byte {
+	return translateExpireOption(cmdLine, 2)
+}
+
+// MSETNX key value [key value ...]，只要有一个 key 存在就不做任何修改
+func execMSetNX(db types.Database, args [][]byte) resp.Reply {
+	if len(args)%2 != 0 {
+		return resp.MakeErrReply("ERR wrong number of arguments for 'msetnx' command")
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		if _, exists := db.GetEntity(string(args[i])); exists {
+			return resp.MakeIntReply(0)
+		}
+	}
+	for i := 0; i < len(args); i += 2 {
+		key := string(args[i])
+		db.PutEntity(key, &types.DataEntity{Data: data.NewStringFromBytes(args[i+1])})
+		db.DeleteTTL(key)
+	}
+	return resp.MakeIntReply(1)
+}
+
+// INCRBYFLOAT key increment
+func execIncrByFloat(db types.Database, args [][]byte) resp.Reply {
+	key := string(args[0])
+	delta, err := strconv.ParseFloat(string(args[1]), 64)
+	if err != nil {
+		return resp.MakeErrReply("ERR value is not a valid float")
+	}
+
+	str, exists, errReply := getString(db, key)
+	if errReply != nil {
+		return errReply
+	}
+
+	// 不存在则当作 0，出错时不创建 key
+	if !exists {
+		str = data.NewSimpleString(true, 0)
+	}
+	val, err := str.IncrByFloat(delta)
+	if err != nil {
+		return resp.MakeErrReply("ERR " + err.Error())
+	}
+	if !exists {
+		db.PutEntity(key, &types.DataEntity{Data: str})
+	}
+	return resp.MakeBulkReply(val)
+}
+
+// GETRANGE key start end
+func execGetRange(db types.Database, args [][]byte) resp.Reply {
+	start, err1 := strconv.ParseInt(string(args[1]), 10, 64)
+	end, err2 := strconv.ParseInt(string(args[2]), 10, 64)
+	if err1 != nil || err2 != nil {
+		return resp.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	str, exists, errReply := getString(db, string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if !exists {
+		return resp.MakeBulkReply([]byte{})
+	}
+	return resp.MakeBulkReply(str.GetRange(start, end))
+}
+
+// maxStringSize 字符串的最大长度，对应 Redis 的 proto-max-bulk-len
+const maxStringSize = 512 * 1024 * 1024
+
+// SETRANGE key offset value
+func execSetRange(db types.Database, args [][]byte) resp.Reply {
+	key := string(args[0])
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return resp.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if offset < 0 {
+		return resp.MakeErrReply("ERR offset is out of range")
+	}
+	value := args[2]
+
+	str, exists, errReply := getString(db, key)
+	if errReply != nil {
+		return errReply
+	}
+	// 空值不会修改，也不会创建 key
+	if len(value) == 0 {
+		if !exists {
+			return resp.MakeIntReply(0)
+		}
+		return resp.MakeIntReply(int64(len(str.Get())))
+	}
+	if offset+int64(len(value)) > maxStringSize {
+		return resp.MakeErrReply("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+
+	if !exists {
+		str = data.NewStringFromBytes(nil)
+		db.PutEntity(key, &types.DataEntity{Data: str})
+	}
+	return resp.MakeIntReply(int64(str.SetRange(int(offset), value)))
+}
+
+// LCS key1 key2 [LEN] [IDX] [MINMATCHLEN min-match-len] [WITHMATCHLEN]
+func execLCS(db types.Database, args [][]byte) resp.Reply {
+	var (
+		getLen, getIdx, withMatchLen bool
+		minMatchLen                  int64
+	)
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "len":
+			getLen = true
+		case "idx":
+			getIdx = true
+		case "withmatchlen":
+			withMatchLen = true
+		case "minmatchlen":
+			if i+1 >= len(args) {
+				return resp.MakeErrReply("ERR syntax error")
+			}
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return resp.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if n > 0 {
+				minMatchLen = n
+			}
+			i++
+		default:
+			return resp.MakeErrReply("ERR syntax error")
+		}
+	}
+	if getLen && getIdx {
+		return resp.MakeErrReply("ERR If you want both the length and indexes, please just use IDX.")
+	}
+
+	// 不存在的 key 当作空字符串
+	values := make([][]byte, 2)
+	for i := range values {
+		str, exists, errReply := getString(db, string(args[i]))
+		if errReply != nil {
+			return errReply
+		}
+		if exists {
+			values[i] = str.Get()
+		}
+	}
+	a, b := values[0], values[1]
+
+	// dp[i][j] 为 a[:i] 和 b[:j] 的最长公共子序列长度
+	width := len(b) + 1
+	dp := make([]uint32, (len(a)+1)*width)
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				dp[i*width+j] = dp[(i-1)*width+j-1] + 1
+			} else {
+				dp[i*width+j] = max(dp[(i-1)*width+j], dp[i*width+j-1])
+			}
+		}
+	}
+	length := int(dp[len(a)*width+len(b)])
+	if getLen {
+		return resp.MakeIntReply(int64(length))
+	}
+
+	// 从末尾回溯，同时记录连续匹配的区间，与 Redis 一样按从后往前的顺序输出
+	result := make([]byte, length)
+	var matches []resp.Reply
+	idx := length
+	aStart, aEnd, bStart, bEnd := -1, 0, 0, 0
+	emit := func() {
+		matchLen := aEnd - aStart + 1
+		if minMatchLen == 0 || int64(matchLen) >= minMatchLen {
+			match := []resp.Reply{makeRangeReply(aStart, aEnd), makeRangeReply(bStart, bEnd)}
+			if withMatchLen {
+				match = append(match, resp.MakeIntReply(int64(matchLen)))
+			}
+			matches = append(matches, resp.MakeMultiRawReply(match))
+		}
+		aStart = -1
+	}
+	for i, j := len(a), len(b); i > 0 && j > 0; {
+		if a[i-1] == b[j-1] {
+			result[idx-1] = a[i-1]
+			if aStart == -1 {
+				aStart, aEnd, bStart, bEnd = i-1, i-1, j-1, j-1
+			} else {
+				aStart, bStart = i-1, j-1
+			}
+			idx, i, j = idx-1, i-1, j-1
+			if i == 0 || j == 0 {
+				emit()
+			}
+			continue
+		}
+		if dp[(i-1)*width+j] > dp[i*width+j-1] {
+			i--
+		} else {
+			j--
+		}
+		if aStart != -1 {
+			emit()
+		}
+	}
+
+	if !getIdx {
+		return resp.MakeBulkReply(result)
+	}
+	if matches == nil {
+		matches = []resp.Reply{}
+	}
+	return resp.MakeMultiRawReply([]resp.Reply{
+		resp.MakeBulkReply([]byte("matches")),
+		resp.MakeMultiRawReply(matches),
+		resp.MakeBulkReply([]byte("len")),
+		resp.MakeIntReply(int64(length)),
+	})
+}
+
+func makeRangeReply(start, end int) resp.Reply {
+	return resp.MakeMultiRawReply([]resp.Reply{
+		resp.MakeIntReply(int64(start)),
+		resp.MakeIntReply(int64(end)),
+	})
+}
@@ -2,6 +2,7 @@ package command
 
 import (
 	"goredis/internal/types"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -224,6 +225,34 @@ func TestExecSet(t *testing.T) {
 		}
 	})
 
+	t.Run("set with PX, EXAT and PXAT", func(t *testing.T) {
+		now := time.Now()
+		at := now.Add(time.Hour)
+		for _, args := range [][]string{
+			{"px", "3600000"},
+			{"exat", strconv.FormatInt(at.Unix(), 10)},
+			{"pxat", strconv.FormatInt(at.UnixMilli(), 10)},
+		} {
+			reply := execSet(db, toArgs(append([]string{"kt", "v"}, args...)...))
+			if !isOKReply(t, reply) {
+				t.Fatalf("%v: expected OK", args)
+			}
+			expireTime, ok := db.GetExpireTime("kt")
+			if !ok || expireTime.Before(at.Add(-time.Second)) || expireTime.After(at.Add(time.Second)) {
+				t.Errorf("%v: unexpected expire time: %v", args, expireTime)
+			}
+		}
+
+		reply := execSet(db, toArgs("kt", "v", "ex", "10", "px", "100"))
+		if errMsg := getErrorString(t, reply); errMsg != "ERR syntax error" {
+			t.Errorf("expected syntax error, got: %s", errMsg)
+		}
+		reply = execSet(db, toArgs("kt", "v", "px", "0"))
+		if errMsg := getErrorString(t, reply); errMsg != "ERR invalid expire time in 'set' command" {
+			t.Errorf("expected invalid expire time, got: %s", errMsg)
+		}
+	})
+
 	t.Run("set with NX on non-existing", func(t *testing.T) {
 		reply := execSet(db, [][]byte{[]byte("non-existing"), []byte("v"), []byte("NX")})
 		if !isOKReply(t, reply) {
@@ -252,16 +281,16 @@ func TestExecSet(t *testing.T) {
 	t.Run("invalid EX value", func(t *testing.T) {
 		reply := execSet(db, [][]byte{[]byte("k"), []byte("v"), []byte("EX"), []byte("abc")})
 		errMsg := getErrorString(t, reply)
-		if errMsg != "ERR invalid expire time" {
+		if errMsg != "ERR invalid expire time in 'set' command" {
 			t.Errorf("expected invalid expire time, got: %s", errMsg)
 		}
 	})
 
 	t.Run("unknown option", func(t *testing.T) {
-		reply := execSet(db, [][]byte{[]byte("k"), []byte("v"), []byte("XX")})
+		reply := execSet(db, [][]byte{[]byte("k"), []byte("v"), []byte("FOO")})
 		errMsg := getErrorString(t, reply)
-		if errMsg != "ERR unknown option" {
-			t.Errorf("expected unknown option, got: %s", errMsg)
+		if errMsg != "ERR syntax error" {
+			t.Errorf("expected syntax error, got: %s", errMsg)
 		}
 	})
 
@@ -359,3 +388,206 @@ func TestExecMGet(t *testing.T) {
 		assertEqualMultiBulk(t, reply, expected)
 	})
 }
+
+func TestSetOptions(t *testing.T) {
+	db := NewMockDB()
+
+	t.Run("XX", func(t *testing.T) {
+		if !isNullBulk(t, execSet(db, toArgs("xx", "v", "XX"))) {
+			t.Error("XX on missing key should return null")
+		}
+		execSet(db, toArgs("xx", "v"))
+		assertOKReply(t, execSet(db, toArgs("xx", "v2", "xx")))
+		assertEqualBulk(t, execGet(db, toArgs("xx")), []byte("v2"))
+	})
+
+	t.Run("KEEPTTL", func(t *testing.T) {
+		execSet(db, toArgs("kt", "v", "ex", "100"))
+		execSet(db, toArgs("kt", "v2", "keepttl"))
+		if _, ok := db.GetExpireTime("kt"); !ok {
+			t.Error("KEEPTTL should keep the TTL")
+		}
+		execSet(db, toArgs("kt", "v3"))
+		if _, ok := db.GetExpireTime("kt"); ok {
+			t.Error("SET without KEEPTTL should clear the TTL")
+		}
+	})
+
+	t.Run("GET", func(t *testing.T) {
+		if !isNullBulk(t, execSet(db, toArgs("g", "v1", "get"))) {
+			t.Error("GET on missing key should return null")
+		}
+		assertEqualBulk(t, execSet(db, toArgs("g", "v2", "GET")), []byte("v1"))
+		// NX 失败时仍返回旧值，且不修改
+		assertEqualBulk(t, execSet(db, toArgs("g", "v3", "nx", "get")), []byte("v2"))
+		assertEqualBulk(t, execGet(db, toArgs("g")), []byte("v2"))
+
+		db.PutEntity("l", &types.DataEntity{Data: "not a string"})
+		if msg := getErrorString(t, execSet(db, toArgs("l", "v", "get"))); msg != "ERR wrong type" {
+			t.Errorf("expected wrong type, got %s", msg)
+		}
+	})
+
+	t.Run("incompatible options", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"k", "v", "nx", "xx"},
+			{"k", "v", "ex", "10", "keepttl"},
+			{"k", "v", "keepttl", "px", "10"},
+		} {
+			if msg := getErrorString(t, execSet(db, toArgs(args...))); msg != "ERR syntax error" {
+				t.Errorf("%v: expected syntax error, got %s", args, msg)
+			}
+		}
+	})
+}
+
+func TestGetSetDelEx(t *testing.T) {
+	db := NewMockDB()
+
+	t.Run("GETSET", func(t *testing.T) {
+		if !isNullBulk(t, execGetSet(db, toArgs("k", "v1"))) {
+			t.Error("expected null")
+		}
+		execExpire(db, toArgs("k", "100"))
+		assertEqualBulk(t, execGetSet(db, toArgs("k", "v2")), []byte("v1"))
+		if _, ok := db.GetExpireTime("k"); ok {
+			t.Error("GETSET should clear the TTL")
+		}
+	})
+
+	t.Run("GETDEL", func(t *testing.T) {
+		assertEqualBulk(t, execGetDel(db, toArgs("k")), []byte("v2"))
+		if !isNullBulk(t, execGetDel(db, toArgs("k"))) {
+			t.Error("key should be deleted")
+		}
+	})
+
+	t.Run("GETEX", func(t *testing.T) {
+		execSet(db, toArgs("e", "v"))
+		assertEqualBulk(t, execGetEx(db, toArgs("e", "ex", "100")), []byte("v"))
+		assertEqualInt(t, execTTL(db, toArgs("e")), 100)
+		assertEqualBulk(t, execGetEx(db, toArgs("e", "persist")), []byte("v"))
+		assertEqualInt(t, execTTL(db, toArgs("e")), -1)
+
+		// 过去的时间直接删除 key
+		assertEqualBulk(t, execGetEx(db, toArgs("e", "pxat", "1")), []byte("v"))
+		assertEqualInt(t, execTTL(db, toArgs("e")), -2)
+
+		if msg := getErrorString(t, execGetEx(db, toArgs("e", "ex", "0"))); msg != "ERR invalid expire time in 'getex' command" {
+			t.Errorf("unexpected error %s", msg)
+		}
+		if msg := getErrorString(t, execGetEx(db, toArgs("e", "ex", "10", "persist"))); msg != "ERR syntax error" {
+			t.Errorf("unexpected error %s", msg)
+		}
+	})
+
+	t.Run("SETEX and PSETEX", func(t *testing.T) {
+		assertOKReply(t, execSetEx(db, toArgs("s", "100", "v")))
+		assertEqualInt(t, execTTL(db, toArgs("s")), 100)
+		assertOKReply(t, execPSetEx(db, toArgs("s", "5000", "v")))
+		assertEqualInt(t, execTTL(db, toArgs("s")), 5)
+
+		if msg := getErrorString(t, execSetEx(db, toArgs("s", "-1", "v"))); msg != "ERR invalid expire time in 'setex' command" {
+			t.Errorf("unexpected error %s", msg)
+		}
+
+		line := translateSetEx("ex")(toArgs("setex", "s", "100", "v"))
+		if len(line) != 5 || string(line[0]) != "set" || string(line[2]) != "v" || string(line[3]) != "pxat" {
+			t.Errorf("SETEX should be translated to SET PXAT, got %q", line)
+		}
+	})
+}
+
+func TestMSetNX(t *testing.T) {
+	db := NewMockDB()
+	assertEqualInt(t, execMSetNX(db, toArgs("a", "1", "b", "2")), 1)
+	assertEqualInt(t, execMSetNX(db, toArgs("b", "3", "c", "4")), 0)
+	if _, exists := db.GetEntity("c"); exists {
+		t.Error("MSETNX should not set any key when one exists")
+	}
+	if msg := getErrorString(t, execMSetNX(db, toArgs("a", "1", "b"))); msg != "ERR wrong number of arguments for 'msetnx' command" {
+		t.Errorf("unexpected error %s", msg)
+	}
+}
+
+func TestIncrByFloatAndOverflow(t *testing.T) {
+	db := NewMockDB()
+
+	assertEqualBulk(t, execIncrByFloat(db, toArgs("f", "10.5")), []byte("10.5"))
+	assertEqualBulk(t, execIncrByFloat(db, toArgs("f", "0.1")), []byte("10.6"))
+	assertEqualBulk(t, execIncrByFloat(db, toArgs("f", "-5.6")), []byte("5"))
+
+	if msg := getErrorString(t, execIncrByFloat(db, toArgs("f", "abc"))); msg != "ERR value is not a valid float" {
+		t.Errorf("unexpected error %s", msg)
+	}
+	if msg := getErrorString(t, execIncrByFloat(db, toArgs("new", "inf"))); msg != "ERR increment would produce NaN or Infinity" {
+		t.Errorf("unexpected error %s", msg)
+	}
+	if _, exists := db.GetEntity("new"); exists {
+		t.Error("failed INCRBYFLOAT should not create the key")
+	}
+
+	execSet(db, toArgs("i", "9223372036854775807"))
+	if msg := getErrorString(t, execIncr(db, toArgs("i"))); msg != "ERR increment or decrement would overflow" {
+		t.Errorf("unexpected error %s", msg)
+	}
+	if msg := getErrorString(t, execDecrBy(db, toArgs("i", "-9223372036854775808"))); msg != "ERR decrement would overflow" {
+		t.Errorf("unexpected error %s", msg)
+	}
+}
+
+func TestGetRangeSetRange(t *testing.T) {
+	db := NewMockDB()
+
+	execSet(db, toArgs("k", "Hello World"))
+	assertEqualBulk(t, execGetRange(db, toArgs("k", "0", "4")), []byte("Hello"))
+	assertEqualBulk(t, execGetRange(db, toArgs("k", "-5", "-1")), []byte("World"))
+	assertEqualBulk(t, execGetRange(db, toArgs("missing", "0", "-1")), []byte{})
+
+	assertEqualInt(t, execSetRange(db, toArgs("k", "6", "Redis")), 11)
+	assertEqualBulk(t, execGet(db, toArgs("k")), []byte("Hello Redis"))
+
+	assertEqualInt(t, execSetRange(db, toArgs("pad", "2", "x")), 3)
+	assertEqualBulk(t, execGet(db, toArgs("pad")), []byte("\x00\x00x"))
+
+	// 空值不创建 key
+	assertEqualInt(t, execSetRange(db, toArgs("empty", "5", "")), 0)
+	if _, exists := db.GetEntity("empty"); exists {
+		t.Error("SETRANGE with empty value should not create the key")
+	}
+
+	if msg := getErrorString(t, execSetRange(db, toArgs("k", "-1", "x"))); msg != "ERR offset is out of range" {
+		t.Errorf("unexpected error %s", msg)
+	}
+	if msg := getErrorString(t, execSetRange(db, toArgs("k", "536870912", "x"))); msg != "ERR string exceeds maximum allowed size (proto-max-bulk-len)" {
+		t.Errorf("unexpected error %s", msg)
+	}
+}
+
+func TestLCS(t *testing.T) {
+	db := NewMockDB()
+	execMSet(db, toArgs("key1", "ohmytext", "key2", "mynewtext"))
+
+	assertEqualBulk(t, execLCS(db, toArgs("key1", "key2")), []byte("mytext"))
+	assertEqualInt(t, execLCS(db, toArgs("key1", "key2", "len")), 6)
+	assertEqualBulk(t, execLCS(db, toArgs("key1", "missing")), []byte{})
+
+	want := "*4\r\n$7\r\nmatches\r\n*2\r\n" +
+		"*2\r\n*2\r\n:4\r\n:7\r\n*2\r\n:5\r\n:8\r\n" +
+		"*2\r\n*2\r\n:2\r\n:3\r\n*2\r\n:0\r\n:1\r\n" +
+		"$3\r\nlen\r\n:6\r\n"
+	if got := string(execLCS(db, toArgs("key1", "key2", "idx")).ToBytes()); got != want {
+		t.Errorf("unexpected IDX reply:\n%q\nwant\n%q", got, want)
+	}
+
+	want = "*4\r\n$7\r\nmatches\r\n*1\r\n" +
+		"*3\r\n*2\r\n:4\r\n:7\r\n*2\r\n:5\r\n:8\r\n:4\r\n" +
+		"$3\r\nlen\r\n:6\r\n"
+	if got := string(execLCS(db, toArgs("key1", "key2", "idx", "minmatchlen", "4", "withmatchlen")).ToBytes()); got != want {
+		t.Errorf("unexpected IDX reply:\n%q\nwant\n%q", got, want)
+	}
+
+	if msg := getErrorString(t, execLCS(db, toArgs("key1", "key2", "len", "idx"))); msg != "ERR If you want both the length and indexes, please just use IDX." {
+		t.Errorf("unexpected error %s", msg)
+	}
+}
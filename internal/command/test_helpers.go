@@ -5,6 +5,7 @@ import (
 	"goredis/internal/resp"
 	"goredis/internal/types"
 	"goredis/pkg/connection"
+	"sort"
 	"strings"
 	"sync"
 	"testing"
@@ -69,15 +70,69 @@ func (m *MockDB) IsExpired(key string) bool {
 
 // 其他接口方法留空或 panic（因为我们不会调用它们）
 func (m *MockDB) GetDBIndex() int                                           { return 0 }
-func (m *MockDB) ForEach(handler func(string, types.RedisData))             {}
 func (m *MockDB) Exec(c connection.Connection, cmdLine [][]byte) resp.Reply { panic("not implemented") }
 func (m *MockDB) StartExpireTask()                                          {}
-func (m *MockDB) DeleteTTL(key string)                                      {}
+func (m *MockDB) DeleteTTL(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.ttl, key)
+}
+func (m *MockDB) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data = make(map[string]*types.DataEntity)
+	m.ttl = make(map[string]time.Time)
+}
 func (m *MockDB) GetExpireTime(key string) (time.Time, bool) {
 	expire, ok := m.ttl[key]
 	return expire, ok
 }
 
+func (m *MockDB) ForEach(handler func(string, types.RedisData)) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for key, entity := range m.data {
+		handler(key, entity.Data.(types.RedisData))
+	}
+}
+
+func (m *MockDB) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.data)
+}
+
+// Scan 按 key 排序，游标是下一个 key 的下标
+func (m *MockDB) Scan(cursor, count int) ([]string, int) {
+	m.mu.RLock()
+	keys := make([]string, 0, len(m.data))
+	for key := range m.data {
+		keys = append(keys, key)
+	}
+	m.mu.RUnlock()
+	sort.Strings(keys)
+
+	if cursor >= len(keys) {
+		return nil, 0
+	}
+	end := cursor + count
+	if end >= len(keys) {
+		return keys[cursor:], 0
+	}
+	return keys[cursor:end], end
+}
+
+func (m *MockDB) RandomKey() (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for key := range m.data {
+		if expireTime, ok := m.ttl[key]; !ok || !time.Now().After(expireTime) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
 // 断言是 IntReply 且值等于 expected
 func assertIntReply(t *testing.T, reply resp.Reply, expected int64) {
 	t.Helper()
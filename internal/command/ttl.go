@@ -0,0 +1,192 @@
+package command
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"goredis/internal/resp"
+	"goredis/internal/types"
+)
+
+// expireFlags EXPIRE 系列命令的 NX|XX|GT|LT 选项
+type expireFlags struct {
+	nx, xx, gt, lt bool
+}
+
+func parseExpireFlags(args [][]byte) (expireFlags, resp.Reply) {
+	var flags expireFlags
+	for _, arg := range args {
+		switch strings.ToLower(string(arg)) {
+		case "nx":
+			flags.nx = true
+		case "xx":
+			flags.xx = true
+		case "gt":
+			flags.gt = true
+		case "lt":
+			flags.lt = true
+		default:
+			return flags, resp.MakeErrReply("ERR Unsupported option " + string(arg))
+		}
+	}
+	if flags.nx && (flags.xx || flags.gt || flags.lt) {
+		return flags, resp.MakeErrReply("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if flags.gt && flags.lt {
+		return flags, resp.MakeErrReply("ERR GT and LT options at the same time are not compatible")
+	}
+	return flags, nil
+}
+
+// parseExpireAt 将以 unit 为单位的时间换算成毫秒时间戳，relative 为 true 时相对于 now
+func parseExpireAt(arg []byte, unit time.Duration, relative bool, now time.Time) (int64, resp.Reply) {
+	invalid := resp.MakeErrReply("ERR invalid expire time")
+
+	n, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, invalid
+	}
+	factor := int64(unit / time.Millisecond)
+	if n > math.MaxInt64/factor || n < math.MinInt64/factor {
+		return 0, invalid
+	}
+	ms := n * factor
+	if relative {
+		base := now.UnixMilli()
+		if ms > 0 && ms > math.MaxInt64-base {
+			return 0, invalid
+		}
+		ms += base
+	}
+	return ms, nil
+}
+
+// expireGeneric 按毫秒时间戳设置过期时间，时间已过时直接删除 key
+func expireGeneric(db types.Database, args [][]byte, unit time.Duration, relative bool) resp.Reply {
+	key := string(args[0])
+	now := time.Now()
+	ms, errReply := parseExpireAt(args[1], unit, relative, now)
+	if errReply != nil {
+		return errReply
+	}
+	flags, errReply := parseExpireFlags(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+
+	if _, exists := db.GetEntity(key); !exists {
+		return resp.MakeIntReply(0)
+	}
+
+	// 没有过期时间视为永不过期：GT 总是失败，LT 总是成功
+	expireAt := time.UnixMilli(ms)
+	current, hasTTL := db.GetExpireTime(key)
+	switch {
+	case flags.nx && hasTTL,
+		flags.xx && !hasTTL,
+		flags.gt && (!hasTTL || !expireAt.After(current)),
+		flags.lt && hasTTL && !expireAt.Before(current):
+		return resp.MakeIntReply(0)
+	}
+
+	if !expireAt.After(now) {
+		db.Remove(key)
+		return resp.MakeIntReply(1)
+	}
+	db.SetExpire(key, expireAt)
+	return resp.MakeIntReply(1)
+}
+
+// EXPIRE key seconds [NX|XX|GT|LT]
+func execExpire(db types.Database, args [][]byte) resp.Reply {
+	return expireGeneric(db, args, time.Second, true)
+}
+
+// PEXPIRE key milliseconds [NX|XX|GT|LT]
+func execPExpire(db types.Database, args [][]byte) resp.Reply {
+	return expireGeneric(db, args, time.Millisecond, true)
+}
+
+// EXPIREAT key unix-time-seconds [NX|XX|GT|LT]
+func execExpireAt(db types.Database, args [][]byte) resp.Reply {
+	return expireGeneric(db, args, time.Second, false)
+}
+
+// PEXPIREAT key unix-time-milliseconds [NX|XX|GT|LT]
+func execPExpireAt(db types.Database, args [][]byte) resp.Reply {
+	return expireGeneric(db, args, time.Millisecond, false)
+}
+
+// translateExpire 把 EXPIRE/PEXPIRE/EXPIREAT 换算成 PEXPIREAT，
+// 写入 AOF 和复制流的是绝对时间，重放时不会延长 key 的生命周期
+func translateExpire(unit time.Duration, relative bool) func(cmdLine [][]byte) [][]byte {
+	return func(cmdLine [][]byte) [][]byte {
+		ms, errReply := parseExpireAt(cmdLine[2], unit, relative, time.Now())
+		if errReply != nil {
+			// 参数错误留给命令本身报告
+			return cmdLine
+		}
+		translated := [][]byte{[]byte("pexpireat"), cmdLine[1], []byte(strconv.FormatInt(ms, 10))}
+		return append(translated, cmdLine[3:]...)
+	}
+}
+
+// ttlGeneric TTL/PTTL/EXPIRETIME/PEXPIRETIME：key 不存在返回 -2，没有过期时间返回 -1
+func ttlGeneric(db types.Database, key string, value func(expireAt time.Time) int64) resp.Reply {
+	if _, exists := db.GetEntity(key); !exists {
+		return resp.MakeIntReply(-2)
+	}
+	expireAt, hasTTL := db.GetExpireTime(key)
+	if !hasTTL {
+		return resp.MakeIntReply(-1)
+	}
+	return resp.MakeIntReply(value(expireAt))
+}
+
+// TTL key，按四舍五入返回剩余秒数
+func execTTL(db types.Database, args [][]byte) resp.Reply {
+	return ttlGeneric(db, string(args[0]), func(expireAt time.Time) int64 {
+		return (remainingMillis(expireAt) + 500) / 1000
+	})
+}
+
+// PTTL key
+func execPTTL(db types.Database, args [][]byte) resp.Reply {
+	return ttlGeneric(db, string(args[0]), remainingMillis)
+}
+
+// EXPIRETIME key
+func execExpireTime(db types.Database, args [][]byte) resp.Reply {
+	return ttlGeneric(db, string(args[0]), func(expireAt time.Time) int64 {
+		return expireAt.Unix()
+	})
+}
+
+// PEXPIRETIME key
+func execPExpireTime(db types.Database, args [][]byte) resp.Reply {
+	return ttlGeneric(db, string(args[0]), func(expireAt time.Time) int64 {
+		return expireAt.UnixMilli()
+	})
+}
+
+func remainingMillis(expireAt time.Time) int64 {
+	if ms := time.Until(expireAt).Milliseconds(); ms > 0 {
+		return ms
+	}
+	return 0
+}
+
+// PERSIST key
+func execPersist(db types.Database, args [][]byte) resp.Reply {
+	key := string(args[0])
+	if _, exists := db.GetEntity(key); !exists {
+		return resp.MakeIntReply(0)
+	}
+	if _, hasTTL := db.GetExpireTime(key); !hasTTL {
+		return resp.MakeIntReply(0)
+	}
+	db.DeleteTTL(key)
+	return resp.MakeIntReply(1)
+}
@@ -0,0 +1,181 @@
+package command
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"goredis/internal/types"
+)
+
+func TestTTLCommands(t *testing.T) {
+	db := NewMockDB()
+	key := func(k string) [][]byte { return [][]byte{[]byte(k)} }
+
+	t.Run("TTL and PTTL", func(t *testing.T) {
+		assertEqualInt(t, execTTL(db, key("missing")), -2)
+		assertEqualInt(t, execPTTL(db, key("missing")), -2)
+
+		db.PutEntity("k", &types.DataEntity{Data: "v"})
+		assertEqualInt(t, execTTL(db, key("k")), -1)
+		assertEqualInt(t, execPExpireTime(db, key("k")), -1)
+
+		assertEqualInt(t, execPExpire(db, toArgs("k", "10500")), 1)
+		assertEqualInt(t, execTTL(db, key("k")), 10)
+		if pttl := getIntValue(t, execPTTL(db, key("k"))); pttl <= 10000 || pttl > 10500 {
+			t.Errorf("unexpected PTTL %d", pttl)
+		}
+	})
+
+	t.Run("EXPIREAT and EXPIRETIME", func(t *testing.T) {
+		db.PutEntity("at", &types.DataEntity{Data: "v"})
+		at := time.Now().Add(time.Hour).Unix()
+		assertEqualInt(t, execExpireAt(db, toArgs("at", strconv.FormatInt(at, 10))), 1)
+		assertEqualInt(t, execExpireTime(db, key("at")), at)
+		assertEqualInt(t, execPExpireTime(db, key("at")), at*1000)
+
+		ms := time.Now().Add(time.Minute).UnixMilli()
+		assertEqualInt(t, execPExpireAt(db, toArgs("at", strconv.FormatInt(ms, 10))), 1)
+		assertEqualInt(t, execPExpireTime(db, key("at")), ms)
+
+		// 过去的时间直接删除 key
+		assertEqualInt(t, execPExpireAt(db, toArgs("at", "1")), 1)
+		assertEqualInt(t, execTTL(db, key("at")), -2)
+	})
+
+	t.Run("NX XX GT LT", func(t *testing.T) {
+		db.PutEntity("f", &types.DataEntity{Data: "v"})
+		assertEqualInt(t, execExpire(db, toArgs("f", "100", "xx")), 0)
+		assertEqualInt(t, execExpire(db, toArgs("f", "100", "gt")), 0)
+		assertEqualInt(t, execExpire(db, toArgs("f", "100", "NX")), 1)
+		assertEqualInt(t, execExpire(db, toArgs("f", "200", "nx")), 0)
+		assertEqualInt(t, execExpire(db, toArgs("f", "50", "gt")), 0)
+		assertEqualInt(t, execExpire(db, toArgs("f", "200", "xx", "gt")), 1)
+		assertEqualInt(t, execExpire(db, toArgs("f", "300", "lt")), 0)
+		assertEqualInt(t, execExpire(db, toArgs("f", "50", "lt")), 1)
+		assertEqualInt(t, execTTL(db, key("f")), 50)
+
+		// 没有过期时间视为永不过期，LT 总是成功
+		db.PutEntity("g", &types.DataEntity{Data: "v"})
+		assertEqualInt(t, execExpire(db, toArgs("g", "100", "lt")), 1)
+
+		for _, tc := range []struct {
+			flags []string
+			err   string
+		}{
+			{[]string{"nx", "xx"}, "ERR NX and XX, GT or LT options at the same time are not compatible"},
+			{[]string{"gt", "lt"}, "ERR GT and LT options at the same time are not compatible"},
+			{[]string{"foo"}, "ERR Unsupported option foo"},
+		} {
+			args := toArgs(append([]string{"f", "100"}, tc.flags...)...)
+			if msg := getErrorString(t, execExpire(db, args)); msg != tc.err {
+				t.Errorf("flags %v: expected %q, got %q", tc.flags, tc.err, msg)
+			}
+		}
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		db.PutEntity("o", &types.DataEntity{Data: "v"})
+		for _, args := range [][][]byte{
+			toArgs("o", "9223372036854775807"),
+			toArgs("o", "9223372036854775"),
+		} {
+			if msg := getErrorString(t, execExpire(db, args)); msg != "ERR invalid expire time" {
+				t.Errorf("expected invalid expire time, got %q", msg)
+			}
+		}
+	})
+
+	t.Run("PERSIST", func(t *testing.T) {
+		db.PutEntity("p", &types.DataEntity{Data: "v"})
+		assertEqualInt(t, execPersist(db, key("p")), 0)
+		execExpire(db, toArgs("p", "100"))
+		assertEqualInt(t, execPersist(db, key("p")), 1)
+		assertEqualInt(t, execTTL(db, key("p")), -1)
+		assertEqualInt(t, execPersist(db, key("missing")), 0)
+	})
+}
+
+func TestTranslateExpire(t *testing.T) {
+	now := time.Now().UnixMilli()
+	parseAt := func(t *testing.T, line [][]byte, name string, pos int) int64 {
+		t.Helper()
+		if !strings.EqualFold(string(line[0]), name) {
+			t.Fatalf("expected %s, got %s", name, line[0])
+		}
+		ms, err := strconv.ParseInt(string(line[pos]), 10, 64)
+		if err != nil {
+			t.Fatalf("expected millisecond timestamp, got %q", line[pos])
+		}
+		return ms
+	}
+
+	t.Run("relative to absolute", func(t *testing.T) {
+		line := translateExpire(time.Second, true)(toArgs("expire", "k", "10", "nx"))
+		ms := parseAt(t, line, "pexpireat", 2)
+		if ms < now+10000 || ms > now+11000 {
+			t.Errorf("unexpected timestamp %d", ms)
+		}
+		if len(line) != 4 || string(line[3]) != "nx" {
+			t.Errorf("flags should be kept: %q", line)
+		}
+
+		line = translateExpire(time.Second, false)(toArgs("expireat", "k", "100"))
+		if ms := parseAt(t, line, "pexpireat", 2); ms != 100000 {
+			t.Errorf("expected 100000, got %d", ms)
+		}
+	})
+
+	t.Run("invalid arguments are kept", func(t *testing.T) {
+		line := translateExpire(time.Second, true)(toArgs("expire", "k", "abc"))
+		if string(line[0]) != "expire" {
+			t.Errorf("invalid command should not be translated: %q", line)
+		}
+	})
+
+	t.Run("SET", func(t *testing.T) {
+		line := translateSet(toArgs("set", "k", "v", "nx", "EX", "10"))
+		ms := parseAt(t, line, "set", 5)
+		if string(line[4]) != "pxat" || ms < now+10000 || ms > now+11000 {
+			t.Errorf("unexpected translation: %q", line)
+		}
+
+		line = translateSet(toArgs("set", "k", "ex", "px", "100"))
+		if string(line[2]) != "ex" || string(line[3]) != "pxat" {
+			t.Errorf("value should not be treated as option: %q", line)
+		}
+
+		line = translateSet(toArgs("set", "k", "v"))
+		if len(line) != 3 {
+			t.Errorf("SET without TTL should be kept: %q", line)
+		}
+
+		// 非正数的时间不换算，由 execSet 报错
+		for _, ttl := range []string{"0", "-1"} {
+			line = translateSet(toArgs("set", "k", "v", "px", ttl))
+			if string(line[3]) != "px" || string(line[4]) != ttl {
+				t.Errorf("invalid expire time should be kept: %q", line)
+			}
+		}
+	})
+
+	t.Run("GETEX", func(t *testing.T) {
+		line := translateGetEx(toArgs("getex", "k", "ex", "10"))
+		if ms := parseAt(t, line, "getex", 3); string(line[2]) != "pxat" || ms < now+10000 || ms > now+11000 {
+			t.Errorf("unexpected translation: %q", line)
+		}
+		line = translateGetEx(toArgs("getex", "k", "px", "-10"))
+		if string(line[2]) != "px" || string(line[3]) != "-10" {
+			t.Errorf("invalid expire time should be kept: %q", line)
+		}
+	})
+}
+
+func toArgs(args ...string) [][]byte {
+	result := make([][]byte, len(args))
+	for i, arg := range args {
+		result[i] = []byte(arg)
+	}
+	return result
+}
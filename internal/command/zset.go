@@ -232,6 +232,19 @@ func execZRem(db types.Database, args [][]byte) resp.Reply {
 	return resp.MakeIntReply(int64(removed))
 }
 
+// helper: 获取 data.ZSet，key 不存在时返回 nil
+func getZSet(db types.Database, key string) (*data.ZSet, bool, resp.Reply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, false, nil
+	}
+	zs, ok := entity.Data.(*data.ZSet)
+	if !ok {
+		return nil, false, resp.MakeErrReply("ERR wrong type")
+	}
+	return zs, true, nil
+}
+
 // helper: 获取或创建 data.ZSet
 func getOrCreateZSet(db types.Database, key string) *data.ZSet {
 	entity, ok := db.GetEntity(key)
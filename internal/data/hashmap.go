@@ -35,8 +35,15 @@ type Hash interface {
 
 var _ Hash = &RedisHash{}
 
+// 哈希的估算开销：空的分片 dict 和每个字段的 map 条目
+const (
+	hashOverhead      = 32 * 64
+	hashEntryOverhead = 48
+)
+
 type RedisHash struct {
 	data datastruct.Dict
+	size int64 // 所有字段名和值的字节数
 }
 
 func NewRedisHash() *RedisHash {
@@ -46,6 +53,12 @@ func NewRedisHash() *RedisHash {
 }
 
 func (h *RedisHash) HSet(field string, value []byte) int {
+	if old, ok := h.data.Get(field); ok {
+		h.size -= int64(len(old.([]byte)))
+	} else {
+		h.size += int64(len(field))
+	}
+	h.size += int64(len(value))
 	res := h.data.Put(field, append([]byte(nil), value...))
 	return res // 1 = 新增字段，0 = 更新字段
 }
@@ -61,13 +74,20 @@ func (h *RedisHash) HGet(field string) ([]byte, bool) {
 func (h *RedisHash) HDel(fields ...string) int {
 	deleted := 0
 	for _, f := range fields {
-		if h.data.Remove(f) > 0 {
+		old, ok := h.data.Get(f)
+		if ok && h.data.Remove(f) > 0 {
+			h.size -= int64(len(f) + len(old.([]byte)))
 			deleted++
 		}
 	}
 	return deleted
 }
 
+// MemoryUsage 估算占用的内存
+func (h *RedisHash) MemoryUsage() int64 {
+	return hashOverhead + int64(h.data.Len())*hashEntryOverhead + h.size
+}
+
 func (h *RedisHash) HExists(field string) bool {
 	_, ok := h.data.Get(field)
 	return ok
@@ -113,7 +133,7 @@ func (h *RedisHash) Clone() interface{} {
 	nh := NewRedisHash()
 
 	h.data.ForEach(func(k string, v interface{}) bool {
-		nh.data.Put(k, common.CloneBytes(v.([]byte)))
+		nh.HSet(k, common.CloneBytes(v.([]byte)))
 		return true
 	})
 	return nh
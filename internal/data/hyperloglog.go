@@ -0,0 +1,421 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// HyperLogLog 的参数与 Redis 一致，保存格式也与 Redis 相同，可以直接用 GET/SET 在两者之间迁移
+const (
+	hllP          = 14
+	hllQ          = 64 - hllP
+	HLLRegisters  = 1 << hllP
+	hllPMask      = HLLRegisters - 1
+	hllBits       = 6
+	hllRegMax     = 1<<hllBits - 1
+	hllHdrSize    = 16
+	hllDenseSize  = hllHdrSize + (HLLRegisters*hllBits+7)/8
+	hllAlphaInf   = 0.721347520444481703680
+	hllHashSeed   = 0xadc83b19
+	hllMaxEncType = hllSparse
+
+	// 稀疏编码能表示的最大值，以及稀疏编码的最大长度 (hll-sparse-max-bytes)
+	hllSparseValMax   = 32
+	hllSparseMaxBytes = 3000
+)
+
+// 头部的编码类型
+const (
+	hllDense  = 0
+	hllSparse = 1
+)
+
+// 稀疏编码的操作码
+const (
+	hllSparseXZeroBit = 0x40
+	hllSparseValBit   = 0x80
+	hllSparseZeroMax  = 64
+	hllSparseXZeroMax = 16384
+	hllSparseValLen   = 4
+)
+
+var (
+	ErrInvalidHLL   = errors.New("key is not a valid HyperLogLog string value")
+	ErrCorruptedHLL = errors.New("corrupted HLL object detected")
+)
+
+// HyperLogLog 直接操作 Redis 格式的字节：
+// 4 字节 "HYLL"，1 字节编码，3 字节保留，8 字节小端的基数缓存 (最高位为 1 表示缓存失效)，之后是寄存器
+type HyperLogLog struct {
+	buf []byte
+}
+
+// NewHyperLogLog 创建一个空的稀疏编码 HyperLogLog
+func NewHyperLogLog() *HyperLogLog {
+	regs := make([]byte, HLLRegisters)
+	h, _ := hllFromRegisters(regs, true)
+	h.buf[15] = 0 // 空 HLL 的基数缓存有效，为 0
+	return h
+}
+
+// ParseHyperLogLog 校验头部，返回的 HyperLogLog 与 b 共享内存
+func ParseHyperLogLog(b []byte) (*HyperLogLog, error) {
+	if len(b) < hllHdrSize || !bytes.Equal(b[:4], []byte("HYLL")) || b[4] > hllMaxEncType {
+		return nil, ErrInvalidHLL
+	}
+	if b[4] == hllDense && len(b) != hllDenseSize {
+		return nil, ErrInvalidHLL
+	}
+	return &HyperLogLog{buf: b}, nil
+}
+
+// Bytes 返回 Redis 格式的字节，与 HyperLogLog 共享内存
+func (h *HyperLogLog) Bytes() []byte {
+	return h.buf
+}
+
+func (h *HyperLogLog) IsSparse() bool {
+	return h.buf[4] == hllSparse
+}
+
+func (h *HyperLogLog) invalidateCache() {
+	h.buf[15] |= 0x80
+}
+
+// hllHash 返回元素对应的寄存器以及该寄存器的候选值 (第一个 1 出现的位置)
+func hllHash(element []byte) (int, byte) {
+	hash := murmurHash64A(element, hllHashSeed)
+	index := int(hash & hllPMask)
+	hash >>= hllP
+	hash |= 1 << hllQ // 保证循环会结束
+	count := byte(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+// murmurHash64A 与 Redis 使用的哈希函数相同，保证同样的元素落在同样的寄存器中
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const (
+		m = 0xc6a4a7935bd1e995
+		r = 47
+	)
+	h := seed ^ uint64(len(key))*m
+
+	n := len(key) / 8
+	for i := 0; i < n; i++ {
+		k := binary.LittleEndian.Uint64(key[i*8:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+	}
+
+	tail := key[n*8:]
+	if len(tail) > 0 {
+		for i := len(tail) - 1; i >= 0; i-- {
+			h ^= uint64(tail[i]) << (8 * uint(i))
+		}
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// denseGet 寄存器按 6 位紧密排列，低位在前
+func denseGet(regs []byte, index int) byte {
+	bit := index * hllBits
+	b, fb := bit/8, uint(bit&7)
+	v := regs[b] >> fb
+	if b+1 < len(regs) {
+		v |= regs[b+1] << (8 - fb)
+	}
+	return v & hllRegMax
+}
+
+func denseSet(regs []byte, index int, val byte) {
+	bit := index * hllBits
+	b, fb := bit/8, uint(bit&7)
+	regs[b] &^= hllRegMax << fb
+	regs[b] |= val << fb
+	if b+1 < len(regs) {
+		regs[b+1] &^= hllRegMax >> (8 - fb)
+		regs[b+1] |= val >> (8 - fb)
+	}
+}
+
+// Add 添加元素，有寄存器被修改时返回 true。稀疏编码放不下时自动转为稠密编码
+func (h *HyperLogLog) Add(elements ...[]byte) (bool, error) {
+	changed := false
+	if !h.IsSparse() {
+		regs := h.buf[hllHdrSize:]
+		for _, element := range elements {
+			index, count := hllHash(element)
+			if count > denseGet(regs, index) {
+				denseSet(regs, index, count)
+				changed = true
+			}
+		}
+		if changed {
+			h.invalidateCache()
+		}
+		return changed, nil
+	}
+
+	regs, err := h.Registers()
+	if err != nil {
+		return false, err
+	}
+	for _, element := range elements {
+		index, count := hllHash(element)
+		if count > regs[index] {
+			regs[index] = count
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	h.buf = HyperLogLogFromRegisters(regs).buf
+	return true, nil
+}
+
+// Registers 返回解码后的寄存器，每个寄存器一个字节
+func (h *HyperLogLog) Registers() ([]byte, error) {
+	regs := make([]byte, HLLRegisters)
+	if err := h.MergeInto(regs); err != nil {
+		return nil, err
+	}
+	return regs, nil
+}
+
+// MergeInto 把寄存器按最大值合并到 regs 中，regs 的长度为 HLLRegisters
+func (h *HyperLogLog) MergeInto(regs []byte) error {
+	if !h.IsSparse() {
+		dense := h.buf[hllHdrSize:]
+		for i := range regs {
+			regs[i] = max(regs[i], denseGet(dense, i))
+		}
+		return nil
+	}
+
+	p := h.buf[hllHdrSize:]
+	index := 0
+	for i := 0; i < len(p); {
+		op := p[i]
+		switch {
+		case op&hllSparseValBit != 0:
+			val := (op>>2)&0x1f + 1
+			run := int(op&0x3) + 1
+			if index+run > HLLRegisters {
+				return ErrCorruptedHLL
+			}
+			for j := index; j < index+run; j++ {
+				regs[j] = max(regs[j], val)
+			}
+			index += run
+			i++
+		case op&hllSparseXZeroBit != 0:
+			if i+1 >= len(p) {
+				return ErrCorruptedHLL
+			}
+			index += (int(op&0x3f)<<8 | int(p[i+1])) + 1
+			i += 2
+		default:
+			index += int(op&0x3f) + 1
+			i++
+		}
+		if index > HLLRegisters {
+			return ErrCorruptedHLL
+		}
+	}
+	if index != HLLRegisters {
+		return ErrCorruptedHLL
+	}
+	return nil
+}
+
+// Count 返回估算的基数，缓存有效时直接使用，否则计算后写回缓存
+func (h *HyperLogLog) Count() (uint64, error) {
+	if h.buf[15]&0x80 == 0 {
+		return binary.LittleEndian.Uint64(h.buf[8:16]), nil
+	}
+	regs, err := h.Registers()
+	if err != nil {
+		return 0, err
+	}
+	card := CountRegisters(regs)
+	binary.LittleEndian.PutUint64(h.buf[8:16], card)
+	return card, nil
+}
+
+// ToDense 转为稠密编码，已经是稠密编码时返回 false
+func (h *HyperLogLog) ToDense() (bool, error) {
+	if !h.IsSparse() {
+		return false, nil
+	}
+	regs, err := h.Registers()
+	if err != nil {
+		return false, err
+	}
+	dense, _ := hllFromRegisters(regs, false)
+	copy(dense.buf[8:16], h.buf[8:16])
+	h.buf = dense.buf
+	return true, nil
+}
+
+// DecodeSparse 以 PFDEBUG DECODE 的格式输出稀疏编码的操作码
+func (h *HyperLogLog) DecodeSparse() (string, error) {
+	if !h.IsSparse() {
+		return "", errors.New("HLL encoding is not sparse")
+	}
+	p := h.buf[hllHdrSize:]
+	var parts []string
+	for i := 0; i < len(p); i++ {
+		op := p[i]
+		switch {
+		case op&hllSparseValBit != 0:
+			parts = append(parts, "v:"+strconv.Itoa(int((op>>2)&0x1f+1))+","+strconv.Itoa(int(op&0x3+1)))
+		case op&hllSparseXZeroBit != 0:
+			if i+1 >= len(p) {
+				return "", ErrCorruptedHLL
+			}
+			parts = append(parts, "XZ:"+strconv.Itoa(int(op&0x3f)<<8|int(p[i+1])+1))
+			i++
+		default:
+			parts = append(parts, "Z:"+strconv.Itoa(int(op&0x3f)+1))
+		}
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// HyperLogLogFromRegisters 根据寄存器创建 HyperLogLog，优先使用稀疏编码
+func HyperLogLogFromRegisters(regs []byte) *HyperLogLog {
+	if h, ok := hllFromRegisters(regs, true); ok && len(h.buf) <= hllSparseMaxBytes {
+		return h
+	}
+	h, _ := hllFromRegisters(regs, false)
+	return h
+}
+
+// hllFromRegisters 编码寄存器，基数缓存标记为失效。稀疏编码无法表示超过 32 的值，此时返回 false
+func hllFromRegisters(regs []byte, sparse bool) (*HyperLogLog, bool) {
+	if !sparse {
+		buf := make([]byte, hllDenseSize)
+		copy(buf, "HYLL")
+		buf[4] = hllDense
+		for i, val := range regs {
+			denseSet(buf[hllHdrSize:], i, val)
+		}
+		h := &HyperLogLog{buf: buf}
+		h.invalidateCache()
+		return h, true
+	}
+
+	buf := make([]byte, hllHdrSize, hllHdrSize+64)
+	copy(buf, "HYLL")
+	buf[4] = hllSparse
+	for i := 0; i < len(regs); {
+		val := regs[i]
+		j := i
+		for j < len(regs) && regs[j] == val {
+			j++
+		}
+		run := j - i
+		i = j
+
+		if val > hllSparseValMax {
+			return nil, false
+		}
+		for run > 0 {
+			switch {
+			case val != 0:
+				n := min(run, hllSparseValLen)
+				buf = append(buf, hllSparseValBit|(val-1)<<2|byte(n-1))
+				run -= n
+			case run > hllSparseZeroMax:
+				n := min(run, hllSparseXZeroMax)
+				buf = append(buf, hllSparseXZeroBit|byte((n-1)>>8), byte(n-1))
+				run -= n
+			default:
+				buf = append(buf, byte(run-1))
+				run = 0
+			}
+		}
+	}
+	h := &HyperLogLog{buf: buf}
+	h.invalidateCache()
+	return h, true
+}
+
+// CountRegisters 使用 Redis 的估算方法 (Otmar Ertl 的改进算法) 计算基数
+func CountRegisters(regs []byte) uint64 {
+	// 稠密编码的寄存器最大为 63，损坏的数据也不会越界
+	var histo [hllRegMax + 1]int
+	for _, val := range regs {
+		histo[val]++
+	}
+
+	m := float64(HLLRegisters)
+	z := m * hllTau((m-float64(histo[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histo[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
+
+// HyperLogLog 以 Redis 的格式保存在字符串中，返回的 HyperLogLog 与字符串共享内存
+func (s *SimpleString) HyperLogLog() (*HyperLogLog, error) {
+	if s.isInt {
+		return nil, ErrInvalidHLL
+	}
+	return ParseHyperLogLog(s.valRaw)
+}
+
+// SetHyperLogLog 修改后写回字符串，编码转换时底层的字节会被替换
+func (s *SimpleString) SetHyperLogLog(h *HyperLogLog) {
+	s.isInt, s.valInt, s.valRaw = false, 0, h.buf
+}
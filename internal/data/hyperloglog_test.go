@@ -0,0 +1,149 @@
+package data
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		h := NewHyperLogLog()
+		if !h.IsSparse() {
+			t.Error("new HyperLogLog should be sparse")
+		}
+		if card, err := h.Count(); err != nil || card != 0 {
+			t.Errorf("expected 0, got %d, %v", card, err)
+		}
+		if decoded, _ := h.DecodeSparse(); decoded != "XZ:16384" {
+			t.Errorf("unexpected encoding %q", decoded)
+		}
+	})
+
+	t.Run("add and count", func(t *testing.T) {
+		h := NewHyperLogLog()
+		changed, _ := h.Add([]byte("a"), []byte("b"), []byte("c"))
+		if !changed {
+			t.Error("adding new elements should change registers")
+		}
+		if changed, _ := h.Add([]byte("a")); changed {
+			t.Error("adding an existing element should not change registers")
+		}
+		if card, _ := h.Count(); card != 3 {
+			t.Errorf("expected 3, got %d", card)
+		}
+		// 缓存有效时直接返回
+		if h.buf[15]&0x80 != 0 {
+			t.Error("cardinality should be cached")
+		}
+	})
+
+	t.Run("promote to dense", func(t *testing.T) {
+		h := NewHyperLogLog()
+		for i := 0; i < 5000 && h.IsSparse(); i++ {
+			h.Add([]byte(strconv.Itoa(i)))
+		}
+		if h.IsSparse() {
+			t.Fatal("large HyperLogLog should be promoted to dense")
+		}
+		if len(h.Bytes()) != hllDenseSize {
+			t.Errorf("unexpected dense size %d", len(h.Bytes()))
+		}
+	})
+
+	t.Run("accuracy", func(t *testing.T) {
+		h := NewHyperLogLog()
+		for _, n := range []int{100, 1000, 10000, 100000} {
+			for i := 0; i < n; i++ {
+				h.Add([]byte("element:" + strconv.Itoa(i)))
+			}
+			card, _ := h.Count()
+			// 标准误差约为 0.81%
+			if errRate := math.Abs(float64(card)-float64(n)) / float64(n); errRate > 0.03 {
+				t.Errorf("n=%d: estimated %d, error rate %.4f", n, card, errRate)
+			}
+		}
+	})
+
+	t.Run("sparse and dense agree", func(t *testing.T) {
+		h := NewHyperLogLog()
+		for i := 0; i < 200; i++ {
+			h.Add([]byte(strconv.Itoa(i)))
+		}
+		sparse, _ := h.Registers()
+		if converted, _ := h.ToDense(); !converted {
+			t.Fatal("expected conversion")
+		}
+		dense, _ := h.Registers()
+		if string(sparse) != string(dense) {
+			t.Error("registers should not change after conversion")
+		}
+		if converted, _ := h.ToDense(); converted {
+			t.Error("already dense")
+		}
+		if string(HyperLogLogFromRegisters(dense).Bytes()[hllHdrSize:]) != string(HyperLogLogFromRegisters(sparse).Bytes()[hllHdrSize:]) {
+			t.Error("same registers should produce the same encoding")
+		}
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		a, b := NewHyperLogLog(), NewHyperLogLog()
+		for i := 0; i < 1000; i++ {
+			a.Add([]byte(strconv.Itoa(i)))
+			b.Add([]byte(strconv.Itoa(i + 500)))
+		}
+		regs := make([]byte, HLLRegisters)
+		a.MergeInto(regs)
+		b.MergeInto(regs)
+		if card := CountRegisters(regs); card < 1450 || card > 1550 {
+			t.Errorf("expected about 1500, got %d", card)
+		}
+	})
+
+	t.Run("invalid and corrupted", func(t *testing.T) {
+		for _, b := range []string{"", "hello", "HYLL\x02" + string(make([]byte, 11)), "HYLL\x00" + string(make([]byte, 20))} {
+			if _, err := ParseHyperLogLog([]byte(b)); err != ErrInvalidHLL {
+				t.Errorf("%q: expected ErrInvalidHLL, got %v", b, err)
+			}
+		}
+
+		// 稀疏编码的寄存器总数不是 16384
+		h, err := ParseHyperLogLog([]byte("HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80\x7f\xfe"))
+		if err != nil {
+			t.Fatalf("header should be valid: %v", err)
+		}
+		if _, err := h.Count(); err != ErrCorruptedHLL {
+			t.Errorf("expected ErrCorruptedHLL, got %v", err)
+		}
+	})
+
+	t.Run("stored as string", func(t *testing.T) {
+		s := NewStringFromBytes(nil)
+		h := NewHyperLogLog()
+		h.Add([]byte("a"), []byte("b"))
+		s.SetHyperLogLog(h)
+
+		// AOF 重写生成 SET 命令，重放后仍是同一个 HyperLogLog
+		cmdLine := s.ToWriteCmdLine("hll")
+		replayed, err := NewStringFromBytes(cmdLine[2]).HyperLogLog()
+		if err != nil {
+			t.Fatalf("replayed value should be a HyperLogLog: %v", err)
+		}
+		if card, _ := replayed.Count(); card != 2 {
+			t.Errorf("expected 2, got %d", card)
+		}
+
+		// Clone 之后互不影响
+		clone := s.Clone().(*SimpleString)
+		ch, _ := clone.HyperLogLog()
+		ch.ToDense()
+		clone.SetHyperLogLog(ch)
+		if h2, _ := s.HyperLogLog(); !h2.IsSparse() {
+			t.Error("modifying the clone should not affect the original")
+		}
+
+		if _, err := NewStringFromBytes([]byte("123")).HyperLogLog(); err != ErrInvalidHLL {
+			t.Errorf("integer should not be a HyperLogLog, got %v", err)
+		}
+	})
+}
@@ -51,10 +51,17 @@ type QuickListNode struct {
 	lp *datastruct.ListPack
 }
 
+// 列表的估算开销：每个 listpack 节点和每个元素
+const (
+	listNodeOverhead  = 96
+	listEntryOverhead = 24
+)
+
 type QuickList struct {
 	list      *datastruct.List // 你已有的双向链表
 	len       int              // 总元素数
 	lpMaxSize int              // 单个 listpack 最大元素数
+	size      int64            // 所有元素的字节数
 }
 
 func NewQuickList() *QuickList {
@@ -123,6 +130,7 @@ func (ql *QuickList) PushFront(val []byte) {
 		}
 	}
 	ql.len++
+	ql.size += int64(len(val))
 }
 
 func (ql *QuickList) PushBack(val []byte) {
@@ -138,6 +146,7 @@ func (ql *QuickList) PushBack(val []byte) {
 		}
 	}
 	ql.len++
+	ql.size += int64(len(val))
 }
 
 func (ql *QuickList) PopFront() []byte {
@@ -156,6 +165,7 @@ func (ql *QuickList) PopFront() []byte {
 		ql.list.Remove(headNode)
 	}
 	ql.len--
+	ql.size -= int64(len(val))
 	return val
 }
 
@@ -175,6 +185,7 @@ func (ql *QuickList) PopBack() []byte {
 		ql.list.Remove(tailNode)
 	}
 	ql.len--
+	ql.size -= int64(len(val))
 	return val
 }
 
@@ -250,7 +261,12 @@ func (ql *QuickList) Set(index int, val []byte) bool {
 	for node := ql.list.Head(); node != nil; node = node.Next() {
 		qn := node.Value().(*QuickListNode)
 		if n < qn.lp.Len() {
-			return qn.lp.Set(n, val)
+			old, _ := qn.lp.Get(n)
+			if !qn.lp.Set(n, val) {
+				return false
+			}
+			ql.size += int64(len(val) - len(old))
+			return true
 		}
 		n -= qn.lp.Len()
 	}
@@ -274,6 +290,7 @@ func (ql *QuickList) RemoveByValue(count int, val []byte) int {
 		node = next
 	}
 	ql.len -= removed
+	ql.size -= int64(removed * len(val))
 	return removed
 }
 
@@ -287,6 +304,7 @@ func (ql *QuickList) Trim(start, stop int) {
 		// trim 后为空
 		ql.list = datastruct.NewList()
 		ql.len = 0
+		ql.size = 0
 		return
 	}
 
@@ -306,6 +324,11 @@ func (ql *QuickList) Trim(start, stop int) {
 	}
 }
 
+// MemoryUsage 估算占用的内存
+func (ql *QuickList) MemoryUsage() int64 {
+	return int64(ql.list.Len())*listNodeOverhead + int64(ql.len)*listEntryOverhead + ql.size
+}
+
 func (ql *QuickList) Len() int {
 	return ql.len
 }
@@ -412,3 +412,31 @@ func TestQuickListEdgeCases(t *testing.T) {
 		t.Errorf("Expected len to be 0 after all pops, got %d", ql2.len)
 	}
 }
+
+func TestQuickListMemoryUsage(t *testing.T) {
+	ql := NewQuickList()
+	empty := ql.MemoryUsage()
+
+	ql.PushBack([]byte("aaaa"))
+	ql.PushFront([]byte("bb"))
+	ql.PushBack([]byte("aaaa"))
+	ql.Set(0, []byte("cccccc"))
+	if ql.size != 14 {
+		t.Errorf("Expected element bytes 14, got %d", ql.size)
+	}
+
+	ql.RemoveByValue(0, []byte("aaaa"))
+	ql.PopFront()
+	if ql.size != 0 || ql.MemoryUsage() != empty {
+		t.Errorf("Expected empty list usage %d, got %d (size %d)", empty, ql.MemoryUsage(), ql.size)
+	}
+
+	for i := 0; i < 1000; i++ {
+		ql.PushBack([]byte(strconv.Itoa(i)))
+	}
+	before := ql.MemoryUsage()
+	ql.Trim(10, 19)
+	if ql.MemoryUsage() >= before || ql.size != 20 {
+		t.Errorf("Expected usage to shrink after trim, got %d (size %d)", ql.MemoryUsage(), ql.size)
+	}
+}
@@ -30,10 +30,18 @@ const maxIntSetEntries = 512 // 对齐 Redis 默认值
 
 var _ Set = &SetObject{}
 
+// 集合的估算开销：intset 每个元素 8 字节，hashset 每个元素一个 map 条目
+const (
+	setOverhead          = 64
+	intSetEntrySize      = 8
+	hashSetEntryOverhead = 48
+)
+
 type SetObject struct {
 	encoding Encoding
 	is       *datastruct.IntSet
 	hs       *datastruct.HashSet
+	size     int64 // hash 编码时所有成员的字节数
 }
 
 func NewSet() *SetObject {
@@ -55,14 +63,22 @@ func (s *SetObject) Add(member []byte) bool {
 		}
 		// 非整数，必须升级
 		s.upgradeToHash()
-		return s.hs.Add(member)
+		return s.addToHash(member)
 
 	case EncHash:
-		return s.hs.Add(member)
+		return s.addToHash(member)
 	}
 	return false
 }
 
+func (s *SetObject) addToHash(member []byte) bool {
+	if !s.hs.Add(member) {
+		return false
+	}
+	s.size += int64(len(member))
+	return true
+}
+
 func (s *SetObject) Remove(member []byte) bool {
 	switch s.encoding {
 	case EncIntSet:
@@ -71,7 +87,11 @@ func (s *SetObject) Remove(member []byte) bool {
 		}
 		return false
 	case EncHash:
-		return s.hs.Remove(member)
+		if !s.hs.Remove(member) {
+			return false
+		}
+		s.size -= int64(len(member))
+		return true
 	}
 	return false
 }
@@ -119,14 +139,21 @@ func (s *SetObject) upgradeToHash() {
 		return
 	}
 
-	hs := datastruct.NewHashSet()
-	for _, v := range s.is.Values() {
-		hs.Add([]byte(strconv.FormatInt(v, 10)))
-	}
-
+	values := s.is.Values()
 	s.is = nil
-	s.hs = hs
+	s.hs = datastruct.NewHashSet()
 	s.encoding = EncHash
+	for _, v := range values {
+		s.addToHash([]byte(strconv.FormatInt(v, 10)))
+	}
+}
+
+// MemoryUsage 估算占用的内存
+func (s *SetObject) MemoryUsage() int64 {
+	if s.encoding == EncIntSet {
+		return setOverhead + int64(s.Len())*intSetEntrySize
+	}
+	return setOverhead + int64(s.Len())*hashSetEntryOverhead + s.size
 }
 
 func (s *SetObject) Random() ([]byte, bool) {
@@ -148,7 +175,11 @@ func (s *SetObject) Pop() ([]byte, bool) {
 		v, _ := s.is.Pop()
 		return []byte(strconv.FormatInt(v, 10)), true
 	}
-	return s.hs.Pop()
+	member, ok := s.hs.Pop()
+	if ok {
+		s.size -= int64(len(member))
+	}
+	return member, ok
 }
 
 func (s *SetObject) ToWriteCmdLine(key string) [][]byte {
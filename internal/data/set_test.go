@@ -142,6 +142,28 @@ func TestRandomAndPop(t *testing.T) {
 	}
 }
 
+// ---------- 内存估算 ----------
+
+func TestSetMemoryUsage(t *testing.T) {
+	s := NewSet()
+	s.Add([]byte("1"))
+	s.Add([]byte("2"))
+	intUsage := s.MemoryUsage()
+
+	// 升级后按成员的字节数计算
+	s.Add([]byte("abc"))
+	if s.size != 5 || s.MemoryUsage() <= intUsage {
+		t.Errorf("unexpected usage after upgrade: %d (size %d)", s.MemoryUsage(), s.size)
+	}
+
+	s.Remove([]byte("abc"))
+	s.Pop()
+	s.Pop()
+	if s.size != 0 {
+		t.Errorf("size should be 0 after removing all members, got %d", s.size)
+	}
+}
+
 // ---------- 性能基准 ----------
 
 func BenchmarkAddInt(b *testing.B) {
@@ -0,0 +1,767 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"goredis/pkg/datastruct"
+)
+
+// 与 Redis 的 stream-node-max-entries 一致，每个 listpack 节点最多保存的条目数
+const streamNodeMaxEntries = 100
+
+// 流的估算开销
+const (
+	streamOverhead      = 256
+	streamNodeOverhead  = 64
+	streamEntryOverhead = 32
+	streamNACKOverhead  = 64
+)
+
+// InvalidEntriesRead 消费组已读条目数未知，对应 Redis 的 SCG_INVALID_ENTRIES_READ
+const InvalidEntriesRead = -1
+
+var (
+	ErrInvalidStreamID   = errors.New("invalid stream ID")
+	ErrStreamIDExhausted = errors.New("the stream has exhausted the last possible ID, unable to add more items")
+)
+
+// StreamID 流条目 ID：毫秒时间戳-序号
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	MinStreamID = StreamID{0, 0}
+	MaxStreamID = StreamID{math.MaxUint64, math.MaxUint64}
+)
+
+// ParseStreamID 解析 ms-seq 或 ms，只有 ms 时序号取 missingSeq
+func ParseStreamID(s string, missingSeq uint64) (StreamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, ErrInvalidStreamID
+	}
+	if !hasSeq {
+		return StreamID{ms, missingSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return StreamID{}, ErrInvalidStreamID
+	}
+	return StreamID{ms, seq}, nil
+}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+func (id StreamID) Compare(other StreamID) int {
+	switch {
+	case id.Ms < other.Ms:
+		return -1
+	case id.Ms > other.Ms:
+		return 1
+	case id.Seq < other.Seq:
+		return -1
+	case id.Seq > other.Seq:
+		return 1
+	}
+	return 0
+}
+
+func (id StreamID) IsZero() bool {
+	return id.Ms == 0 && id.Seq == 0
+}
+
+// Incr 返回下一个 ID，已经是最大值时返回 false
+func (id StreamID) Incr() (StreamID, bool) {
+	if id.Seq < math.MaxUint64 {
+		return StreamID{id.Ms, id.Seq + 1}, true
+	}
+	if id.Ms < math.MaxUint64 {
+		return StreamID{id.Ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// Decr 返回上一个 ID，已经是 0-0 时返回 false
+func (id StreamID) Decr() (StreamID, bool) {
+	if id.Seq > 0 {
+		return StreamID{id.Ms, id.Seq - 1}, true
+	}
+	if id.Ms > 0 {
+		return StreamID{id.Ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// StreamEntry 流中的一个条目，Fields 依次为 field value
+type StreamEntry struct {
+	ID     StreamID
+	Fields [][]byte
+}
+
+// streamNode 一个 listpack 节点，ids 与 lp 中的条目一一对应，有序
+type streamNode struct {
+	ids []StreamID
+	lp  *datastruct.ListPack
+}
+
+func (n *streamNode) first() StreamID {
+	return n.ids[0]
+}
+
+func (n *streamNode) last() StreamID {
+	return n.ids[len(n.ids)-1]
+}
+
+// search 返回第一个 >= id 的位置
+func (n *streamNode) search(id StreamID) int {
+	return sort.Search(len(n.ids), func(i int) bool {
+		return n.ids[i].Compare(id) >= 0
+	})
+}
+
+func (n *streamNode) entry(i int) *StreamEntry {
+	raw, _ := n.lp.Get(i)
+	return &StreamEntry{ID: n.ids[i], Fields: decodeStreamFields(raw)}
+}
+
+func encodeStreamFields(fields [][]byte) []byte {
+	buf := binary.AppendUvarint(nil, uint64(len(fields)))
+	for _, f := range fields {
+		buf = binary.AppendUvarint(buf, uint64(len(f)))
+		buf = append(buf, f...)
+	}
+	return buf
+}
+
+func decodeStreamFields(raw []byte) [][]byte {
+	n, off := binary.Uvarint(raw)
+	fields := make([][]byte, 0, n)
+	for i := uint64(0); i < n; i++ {
+		l, k := binary.Uvarint(raw[off:])
+		off += k
+		fields = append(fields, raw[off:off+int(l)])
+		off += int(l)
+	}
+	return fields
+}
+
+// StreamNACK 已投递但还未确认的条目
+type StreamNACK struct {
+	ID            StreamID
+	Consumer      *StreamConsumer
+	DeliveryTime  int64 // 毫秒时间戳
+	DeliveryCount int64
+}
+
+// StreamConsumer 消费组中的消费者
+type StreamConsumer struct {
+	Name       string
+	SeenTime   int64 // 最近一次尝试读取或认领的时间
+	ActiveTime int64 // 最近一次成功读取或认领的时间，-1 表示从未成功
+	Pending    *StreamPEL
+}
+
+// StreamGroup 消费组
+type StreamGroup struct {
+	Name        string
+	LastID      StreamID
+	EntriesRead int64
+	Pending     *StreamPEL
+	consumers   map[string]*StreamConsumer
+}
+
+// StreamPEL 按 ID 有序的待确认列表（Pending Entries List）
+type StreamPEL struct {
+	ids  []StreamID
+	nack map[StreamID]*StreamNACK
+}
+
+func newStreamPEL() *StreamPEL {
+	return &StreamPEL{nack: make(map[StreamID]*StreamNACK)}
+}
+
+func (p *StreamPEL) Len() int {
+	return len(p.ids)
+}
+
+func (p *StreamPEL) Get(id StreamID) (*StreamNACK, bool) {
+	nack, ok := p.nack[id]
+	return nack, ok
+}
+
+func (p *StreamPEL) search(id StreamID) int {
+	return sort.Search(len(p.ids), func(i int) bool {
+		return p.ids[i].Compare(id) >= 0
+	})
+}
+
+func (p *StreamPEL) add(nack *StreamNACK) {
+	if _, ok := p.nack[nack.ID]; ok {
+		p.nack[nack.ID] = nack
+		return
+	}
+	i := p.search(nack.ID)
+	p.ids = append(p.ids, StreamID{})
+	copy(p.ids[i+1:], p.ids[i:])
+	p.ids[i] = nack.ID
+	p.nack[nack.ID] = nack
+}
+
+func (p *StreamPEL) remove(id StreamID) bool {
+	if _, ok := p.nack[id]; !ok {
+		return false
+	}
+	delete(p.nack, id)
+	i := p.search(id)
+	p.ids = append(p.ids[:i], p.ids[i+1:]...)
+	return true
+}
+
+// Range 按 ID 升序返回 [start, end] 内最多 count 个条目，count <= 0 表示不限制
+func (p *StreamPEL) Range(start, end StreamID, count int) []*StreamNACK {
+	var result []*StreamNACK
+	for i := p.search(start); i < len(p.ids) && p.ids[i].Compare(end) <= 0; i++ {
+		if count > 0 && len(result) >= count {
+			break
+		}
+		result = append(result, p.nack[p.ids[i]])
+	}
+	return result
+}
+
+// First 和 Last 返回最小和最大的 ID，调用方需保证非空
+func (p *StreamPEL) First() StreamID {
+	return p.ids[0]
+}
+
+func (p *StreamPEL) Last() StreamID {
+	return p.ids[len(p.ids)-1]
+}
+
+// Consumer 返回指定的消费者
+func (g *StreamGroup) Consumer(name string) (*StreamConsumer, bool) {
+	c, ok := g.consumers[name]
+	return c, ok
+}
+
+// CreateConsumer 创建消费者，已存在时返回 false
+func (g *StreamGroup) CreateConsumer(name string, now int64) (*StreamConsumer, bool) {
+	if c, ok := g.consumers[name]; ok {
+		return c, false
+	}
+	c := &StreamConsumer{Name: name, SeenTime: now, ActiveTime: -1, Pending: newStreamPEL()}
+	g.consumers[name] = c
+	return c, true
+}
+
+// DeleteConsumer 删除消费者及其待确认条目，返回删除的待确认条目数
+func (g *StreamGroup) DeleteConsumer(name string) (int, bool) {
+	c, ok := g.consumers[name]
+	if !ok {
+		return 0, false
+	}
+	pending := c.Pending.Len()
+	for _, id := range c.Pending.ids {
+		g.Pending.remove(id)
+	}
+	delete(g.consumers, name)
+	return pending, true
+}
+
+// Consumers 按名称排序返回所有消费者
+func (g *StreamGroup) Consumers() []*StreamConsumer {
+	consumers := make([]*StreamConsumer, 0, len(g.consumers))
+	for _, c := range g.consumers {
+		consumers = append(consumers, c)
+	}
+	sort.Slice(consumers, func(i, j int) bool {
+		return consumers[i].Name < consumers[j].Name
+	})
+	return consumers
+}
+
+// Deliver 将条目加入 consumer 的待确认列表，已被其他消费者持有时转移过来
+func (g *StreamGroup) Deliver(id StreamID, consumer *StreamConsumer, deliveryTime, deliveryCount int64) *StreamNACK {
+	if nack, ok := g.Pending.Get(id); ok {
+		nack.Consumer.Pending.remove(id)
+		nack.Consumer = consumer
+		nack.DeliveryTime = deliveryTime
+		nack.DeliveryCount = deliveryCount
+		consumer.Pending.add(nack)
+		return nack
+	}
+	nack := &StreamNACK{ID: id, Consumer: consumer, DeliveryTime: deliveryTime, DeliveryCount: deliveryCount}
+	g.Pending.add(nack)
+	consumer.Pending.add(nack)
+	return nack
+}
+
+// Ack 确认条目，返回是否在待确认列表中
+func (g *StreamGroup) Ack(id StreamID) bool {
+	nack, ok := g.Pending.Get(id)
+	if !ok {
+		return false
+	}
+	g.Pending.remove(id)
+	nack.Consumer.Pending.remove(id)
+	return true
+}
+
+// Stream 流：条目按 ID 有序保存在一组 listpack 节点中
+type Stream struct {
+	nodes        []*streamNode
+	length       int
+	lastID       StreamID
+	maxDeletedID StreamID
+	entriesAdded uint64
+	groups       map[string]*StreamGroup
+	size         int64
+}
+
+func NewStream() *Stream {
+	return &Stream{groups: make(map[string]*StreamGroup)}
+}
+
+func (s *Stream) Len() int {
+	return s.length
+}
+
+func (s *Stream) LastID() StreamID {
+	return s.lastID
+}
+
+func (s *Stream) MaxDeletedID() StreamID {
+	return s.maxDeletedID
+}
+
+func (s *Stream) EntriesAdded() uint64 {
+	return s.entriesAdded
+}
+
+// NodeCount 返回 listpack 节点数
+func (s *Stream) NodeCount() int {
+	return len(s.nodes)
+}
+
+// FirstID 返回第一个条目的 ID，流为空时返回 0-0
+func (s *Stream) FirstID() StreamID {
+	if s.length == 0 {
+		return MinStreamID
+	}
+	return s.nodes[0].first()
+}
+
+// NextID 返回自动生成的下一个 ID：时间戳不小于 lastID 的时间戳时使用 now，否则在 lastID 上递增序号
+func (s *Stream) NextID(now uint64) (StreamID, error) {
+	if now > s.lastID.Ms {
+		return StreamID{now, 0}, nil
+	}
+	next, ok := s.lastID.Incr()
+	if !ok {
+		return StreamID{}, ErrStreamIDExhausted
+	}
+	return next, nil
+}
+
+// NextSeqID 只指定了时间戳的 ID（ms-*）对应的完整 ID，时间戳小于 lastID 时返回 false
+func (s *Stream) NextSeqID(ms uint64) (StreamID, bool) {
+	if ms > s.lastID.Ms {
+		return StreamID{ms, 0}, true
+	}
+	if ms < s.lastID.Ms || s.lastID.Seq == math.MaxUint64 {
+		return StreamID{}, false
+	}
+	return StreamID{ms, s.lastID.Seq + 1}, true
+}
+
+// Add 追加条目，调用方需保证 id 大于 lastID
+func (s *Stream) Add(id StreamID, fields [][]byte) {
+	var node *streamNode
+	if len(s.nodes) > 0 && len(s.nodes[len(s.nodes)-1].ids) < streamNodeMaxEntries {
+		node = s.nodes[len(s.nodes)-1]
+	} else {
+		node = &streamNode{lp: datastruct.NewListPack(streamNodeMaxEntries)}
+		s.nodes = append(s.nodes, node)
+		s.size += streamNodeOverhead
+	}
+	raw := encodeStreamFields(fields)
+	node.ids = append(node.ids, id)
+	node.lp.PushBack(raw)
+	s.size += streamEntryOverhead + int64(len(raw))
+
+	s.length++
+	s.lastID = id
+	s.entriesAdded++
+}
+
+// SetID 设置 lastID、entriesAdded 和 maxDeletedID，参数由调用方校验
+func (s *Stream) SetID(lastID StreamID, entriesAdded uint64, maxDeletedID StreamID) {
+	s.lastID = lastID
+	s.entriesAdded = entriesAdded
+	s.maxDeletedID = maxDeletedID
+}
+
+// locate 返回第一个 >= id 的条目位置，不存在时 node 为 len(s.nodes)
+func (s *Stream) locate(id StreamID) (int, int) {
+	n := sort.Search(len(s.nodes), func(i int) bool {
+		return s.nodes[i].last().Compare(id) >= 0
+	})
+	if n == len(s.nodes) {
+		return n, 0
+	}
+	return n, s.nodes[n].search(id)
+}
+
+// Get 返回指定 ID 的条目
+func (s *Stream) Get(id StreamID) (*StreamEntry, bool) {
+	n, i := s.locate(id)
+	if n == len(s.nodes) || s.nodes[n].ids[i] != id {
+		return nil, false
+	}
+	return s.nodes[n].entry(i), true
+}
+
+// Range 返回 [start, end] 内最多 count 个条目，count <= 0 表示不限制，rev 为 true 时从 end 开始倒序
+func (s *Stream) Range(start, end StreamID, count int, rev bool) []*StreamEntry {
+	var result []*StreamEntry
+	if start.Compare(end) > 0 {
+		return nil
+	}
+	full := func() bool {
+		return count > 0 && len(result) >= count
+	}
+
+	if !rev {
+		for n, i := s.locate(start); n < len(s.nodes); n, i = n+1, 0 {
+			node := s.nodes[n]
+			for ; i < len(node.ids); i++ {
+				if node.ids[i].Compare(end) > 0 || full() {
+					return result
+				}
+				result = append(result, node.entry(i))
+			}
+		}
+		return result
+	}
+
+	// 倒序：从最后一个 <= end 的条目开始
+	n, i := s.locate(end)
+	if n == len(s.nodes) || s.nodes[n].ids[i].Compare(end) > 0 {
+		if n < len(s.nodes) && i > 0 {
+			i--
+		} else if n--; n >= 0 {
+			i = len(s.nodes[n].ids) - 1
+		}
+	}
+	for ; n >= 0; n-- {
+		node := s.nodes[n]
+		for ; i >= 0; i-- {
+			if node.ids[i].Compare(start) < 0 || full() {
+				return result
+			}
+			result = append(result, node.entry(i))
+		}
+		if n > 0 {
+			i = len(s.nodes[n-1].ids) - 1
+		}
+	}
+	return result
+}
+
+// Delete 删除指定 ID 的条目
+func (s *Stream) Delete(id StreamID) bool {
+	n, i := s.locate(id)
+	if n == len(s.nodes) || s.nodes[n].ids[i] != id {
+		return false
+	}
+	s.removeAt(n, i)
+	if id.Compare(s.maxDeletedID) > 0 {
+		s.maxDeletedID = id
+	}
+	return true
+}
+
+func (s *Stream) removeAt(n, i int) {
+	node := s.nodes[n]
+	raw, _ := node.lp.Get(i)
+	s.size -= streamEntryOverhead + int64(len(raw))
+	node.lp.RemoveAt(i)
+	node.ids = append(node.ids[:i], node.ids[i+1:]...)
+	s.length--
+	if len(node.ids) == 0 {
+		s.nodes = append(s.nodes[:n], s.nodes[n+1:]...)
+		s.size -= streamNodeOverhead
+	}
+}
+
+// removeNode 删除整个节点
+func (s *Stream) removeNode(n int) {
+	node := s.nodes[n]
+	for i := 0; i < node.lp.Len(); i++ {
+		raw, _ := node.lp.Get(i)
+		s.size -= streamEntryOverhead + int64(len(raw))
+	}
+	s.length -= len(node.ids)
+	s.nodes = append(s.nodes[:n], s.nodes[n+1:]...)
+	s.size -= streamNodeOverhead
+}
+
+// Trim 从头部删除条目，直到长度不超过 maxLen（maxLen >= 0）或第一个条目不小于 minID。
+// approx 为 true 时只删除整个节点，limit 限制删除的条目数，0 表示不限制。返回删除的条目数。
+// 与 XDEL 不同，裁剪不会更新 maxDeletedID
+func (s *Stream) Trim(maxLen int, minID StreamID, approx bool, limit int) int {
+	trimmed := 0
+	shouldTrim := func(id StreamID) bool {
+		if maxLen >= 0 {
+			return s.length > maxLen
+		}
+		return id.Compare(minID) < 0
+	}
+
+	for len(s.nodes) > 0 {
+		node := s.nodes[0]
+		// 整个节点都可以删除
+		wholeNode := false
+		if maxLen >= 0 {
+			wholeNode = s.length-len(node.ids) >= maxLen
+		} else {
+			wholeNode = node.last().Compare(minID) < 0
+		}
+		if wholeNode {
+			if limit > 0 && trimmed+len(node.ids) > limit {
+				break
+			}
+			trimmed += len(node.ids)
+			s.removeNode(0)
+			continue
+		}
+		if approx {
+			break
+		}
+		// 精确删除节点内的部分条目
+		for len(node.ids) > 0 && shouldTrim(node.first()) {
+			if limit > 0 && trimmed >= limit {
+				break
+			}
+			s.removeAt(0, 0)
+			trimmed++
+		}
+		break
+	}
+	return trimmed
+}
+
+// Group 返回指定名称的消费组
+func (s *Stream) Group(name string) (*StreamGroup, bool) {
+	g, ok := s.groups[name]
+	return g, ok
+}
+
+// CreateGroup 创建消费组，已存在时返回 false
+func (s *Stream) CreateGroup(name string, lastID StreamID, entriesRead int64) (*StreamGroup, bool) {
+	if _, ok := s.groups[name]; ok {
+		return nil, false
+	}
+	g := &StreamGroup{
+		Name:        name,
+		LastID:      lastID,
+		EntriesRead: entriesRead,
+		Pending:     newStreamPEL(),
+		consumers:   make(map[string]*StreamConsumer),
+	}
+	s.groups[name] = g
+	return g, true
+}
+
+// DestroyGroup 删除消费组
+func (s *Stream) DestroyGroup(name string) bool {
+	if _, ok := s.groups[name]; !ok {
+		return false
+	}
+	delete(s.groups, name)
+	return true
+}
+
+// Groups 按名称排序返回所有消费组
+func (s *Stream) Groups() []*StreamGroup {
+	groups := make([]*StreamGroup, 0, len(s.groups))
+	for _, g := range s.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// RangeHasTombstones 判断 [start, end] 内是否可能有被删除的条目
+func (s *Stream) RangeHasTombstones(start, end StreamID) bool {
+	if s.length == 0 || s.maxDeletedID.IsZero() {
+		return false
+	}
+	return start.Compare(s.maxDeletedID) <= 0 && s.maxDeletedID.Compare(end) <= 0
+}
+
+// EstimateDistanceFromFirstEverEntry 估算 id 是流中第几个被添加的条目，无法确定时返回 InvalidEntriesRead
+func (s *Stream) EstimateDistanceFromFirstEverEntry(id StreamID) int64 {
+	if s.entriesAdded == 0 {
+		return 0
+	}
+	if s.length == 0 && id.Compare(s.lastID) < 1 {
+		return int64(s.entriesAdded)
+	}
+
+	cmpLast := id.Compare(s.lastID)
+	if cmpLast == 0 {
+		return int64(s.entriesAdded)
+	} else if cmpLast > 0 {
+		return InvalidEntriesRead
+	}
+
+	first := s.FirstID()
+	cmpFirst := id.Compare(first)
+	// 第一个条目之前没有被删除的条目，可以直接计算
+	if s.maxDeletedID.IsZero() || s.maxDeletedID.Compare(first) < 0 {
+		if cmpFirst < 0 {
+			return int64(s.entriesAdded) - int64(s.length)
+		} else if cmpFirst == 0 {
+			return int64(s.entriesAdded) - int64(s.length) + 1
+		}
+	}
+	return InvalidEntriesRead
+}
+
+// Lag 返回消费组还未读取的条目数，无法确定时返回 false
+func (s *Stream) Lag(g *StreamGroup) (int64, bool) {
+	if s.entriesAdded == 0 {
+		return 0, true
+	}
+	if g.EntriesRead != InvalidEntriesRead && !s.RangeHasTombstones(g.LastID, MaxStreamID) {
+		return int64(s.entriesAdded) - g.EntriesRead, true
+	}
+	entriesRead := s.EstimateDistanceFromFirstEverEntry(g.LastID)
+	if entriesRead == InvalidEntriesRead {
+		return 0, false
+	}
+	return int64(s.entriesAdded) - entriesRead, true
+}
+
+// DeliverNew 消费组读取到新条目 id 时更新 lastID 和已读条目数
+func (s *Stream) DeliverNew(g *StreamGroup, id StreamID) {
+	if id.Compare(g.LastID) <= 0 {
+		return
+	}
+	if g.EntriesRead != InvalidEntriesRead && !s.RangeHasTombstones(id, MaxStreamID) {
+		g.EntriesRead++
+	} else if s.entriesAdded > 0 {
+		g.EntriesRead = s.EstimateDistanceFromFirstEverEntry(id)
+	}
+	g.LastID = id
+}
+
+func (s *Stream) MemoryUsage() int64 {
+	size := streamOverhead + s.size
+	for _, g := range s.groups {
+		size += int64(len(g.Name)) + int64(g.Pending.Len())*streamNACKOverhead
+	}
+	return size
+}
+
+// ToWriteCmdLine 只用于兼容 RedisData 接口，完整的重建命令见 ToWriteCmdLines
+func (s *Stream) ToWriteCmdLine(key string) [][]byte {
+	return s.ToWriteCmdLines(key)[0]
+}
+
+// ToWriteCmdLines 生成重建流的命令：XADD 所有条目，XSETID 恢复 ID 状态，
+// 再创建消费组、消费者，并用 XCLAIM 恢复待确认列表
+func (s *Stream) ToWriteCmdLines(key string) [][][]byte {
+	var cmdLines [][][]byte
+	k := []byte(key)
+
+	for _, node := range s.nodes {
+		for i := range node.ids {
+			entry := node.entry(i)
+			cmdLine := [][]byte{[]byte("xadd"), k, []byte(entry.ID.String())}
+			cmdLines = append(cmdLines, append(cmdLine, entry.Fields...))
+		}
+	}
+	if s.length == 0 {
+		// 空的流：添加一个条目后立即裁剪掉，只留下流本身，ID 状态由随后的 XSETID 恢复
+		cmdLines = append(cmdLines, [][]byte{[]byte("xadd"), k, []byte("maxlen"), []byte("0"), []byte("0-1"), []byte("x"), []byte("y")})
+	}
+	cmdLines = append(cmdLines, [][]byte{
+		[]byte("xsetid"), k, []byte(s.lastID.String()),
+		[]byte("entriesadded"), []byte(strconv.FormatUint(s.entriesAdded, 10)),
+		[]byte("maxdeletedid"), []byte(s.maxDeletedID.String()),
+	})
+
+	for _, g := range s.Groups() {
+		name := []byte(g.Name)
+		cmdLines = append(cmdLines, [][]byte{
+			[]byte("xgroup"), []byte("create"), k, name, []byte(g.LastID.String()),
+			[]byte("entriesread"), []byte(strconv.FormatInt(g.EntriesRead, 10)),
+		})
+		for _, c := range g.Consumers() {
+			if c.Pending.Len() == 0 {
+				cmdLines = append(cmdLines, [][]byte{[]byte("xgroup"), []byte("createconsumer"), k, name, []byte(c.Name)})
+				continue
+			}
+			for _, nack := range c.Pending.Range(MinStreamID, MaxStreamID, 0) {
+				cmdLines = append(cmdLines, XClaimCmdLine(key, g.Name, c.Name, nack))
+			}
+		}
+	}
+	return cmdLines
+}
+
+// XClaimCmdLine 精确恢复一个待确认条目的 XCLAIM 命令
+func XClaimCmdLine(key, group, consumer string, nack *StreamNACK) [][]byte {
+	return [][]byte{
+		[]byte("xclaim"), []byte(key), []byte(group), []byte(consumer), []byte("0"), []byte(nack.ID.String()),
+		[]byte("time"), []byte(strconv.FormatInt(nack.DeliveryTime, 10)),
+		[]byte("retrycount"), []byte(strconv.FormatInt(nack.DeliveryCount, 10)),
+		[]byte("force"), []byte("justid"),
+	}
+}
+
+func (s *Stream) Clone() interface{} {
+	ns := NewStream()
+	for _, node := range s.nodes {
+		nn := &streamNode{
+			ids: append([]StreamID(nil), node.ids...),
+			lp:  datastruct.NewListPack(streamNodeMaxEntries),
+		}
+		for i := 0; i < node.lp.Len(); i++ {
+			raw, _ := node.lp.Get(i)
+			nn.lp.PushBack(append([]byte(nil), raw...))
+		}
+		ns.nodes = append(ns.nodes, nn)
+	}
+	ns.length = s.length
+	ns.lastID = s.lastID
+	ns.maxDeletedID = s.maxDeletedID
+	ns.entriesAdded = s.entriesAdded
+	ns.size = s.size
+
+	for name, g := range s.groups {
+		ng, _ := ns.CreateGroup(name, g.LastID, g.EntriesRead)
+		for cname, c := range g.consumers {
+			nc := &StreamConsumer{Name: cname, SeenTime: c.SeenTime, ActiveTime: c.ActiveTime, Pending: newStreamPEL()}
+			ng.consumers[cname] = nc
+			for _, nack := range c.Pending.Range(MinStreamID, MaxStreamID, 0) {
+				ng.Deliver(nack.ID, nc, nack.DeliveryTime, nack.DeliveryCount)
+			}
+		}
+	}
+	return ns
+}
@@ -0,0 +1,170 @@
+package data
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+func addStreamEntries(s *Stream, n int) {
+	for i := 1; i <= n; i++ {
+		s.Add(StreamID{Ms: uint64(i)}, [][]byte{[]byte("f"), []byte(strconv.Itoa(i))})
+	}
+}
+
+func TestStreamID(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want StreamID
+		ok   bool
+	}{
+		{"1-2", StreamID{1, 2}, true},
+		{"5", StreamID{5, 7}, true},
+		{"18446744073709551615-18446744073709551615", MaxStreamID, true},
+		{"1-", StreamID{}, false},
+		{"-1", StreamID{}, false},
+		{"a-1", StreamID{}, false},
+	} {
+		id, err := ParseStreamID(tc.in, 7)
+		if (err == nil) != tc.ok || (tc.ok && id != tc.want) {
+			t.Errorf("ParseStreamID(%q) = %v, %v", tc.in, id, err)
+		}
+	}
+
+	if next, ok := (StreamID{1, 18446744073709551615}).Incr(); !ok || next != (StreamID{2, 0}) {
+		t.Errorf("Incr overflow = %v, %v", next, ok)
+	}
+	if _, ok := MaxStreamID.Incr(); ok {
+		t.Error("Incr of max id should fail")
+	}
+	if prev, ok := (StreamID{2, 0}).Decr(); !ok || prev != (StreamID{1, 18446744073709551615}) {
+		t.Errorf("Decr underflow = %v, %v", prev, ok)
+	}
+}
+
+func TestStreamAddRange(t *testing.T) {
+	s := NewStream()
+	addStreamEntries(s, 250)
+	if s.Len() != 250 || s.NodeCount() != 3 {
+		t.Fatalf("len=%d nodes=%d", s.Len(), s.NodeCount())
+	}
+	if s.LastID() != (StreamID{Ms: 250}) || s.EntriesAdded() != 250 {
+		t.Errorf("lastID=%v entriesAdded=%d", s.LastID(), s.EntriesAdded())
+	}
+
+	entries := s.Range(StreamID{Ms: 99}, StreamID{Ms: 102}, 0, false)
+	if len(entries) != 4 || entries[0].ID.Ms != 99 || entries[3].ID.Ms != 102 {
+		t.Fatalf("unexpected range %v", entries)
+	}
+	if !bytes.Equal(entries[1].Fields[1], []byte("100")) {
+		t.Errorf("unexpected fields %q", entries[1].Fields)
+	}
+	rev := s.Range(MinStreamID, MaxStreamID, 3, true)
+	if len(rev) != 3 || rev[0].ID.Ms != 250 || rev[2].ID.Ms != 248 {
+		t.Errorf("unexpected reverse range %v", rev)
+	}
+
+	next, err := s.NextID(100)
+	if err != nil || next != (StreamID{250, 1}) {
+		t.Errorf("NextID with clock behind = %v, %v", next, err)
+	}
+	if _, ok := s.NextSeqID(249); ok {
+		t.Error("NextSeqID smaller than last id should fail")
+	}
+}
+
+func TestStreamDeleteTrim(t *testing.T) {
+	s := NewStream()
+	addStreamEntries(s, 250)
+
+	if !s.Delete(StreamID{Ms: 5}) || s.Delete(StreamID{Ms: 5}) {
+		t.Error("Delete should succeed only once")
+	}
+	if _, ok := s.Get(StreamID{Ms: 5}); ok || s.MaxDeletedID() != (StreamID{Ms: 5}) {
+		t.Error("deleted entry is still visible")
+	}
+
+	// 近似裁剪只删除整个节点
+	if n := s.Trim(120, StreamID{}, true, 0); n != 99 || s.Len() != 150 {
+		t.Errorf("approx trim removed %d, len %d", n, s.Len())
+	}
+	if n := s.Trim(100, StreamID{}, false, 0); n != 50 || s.FirstID() != (StreamID{Ms: 151}) {
+		t.Errorf("exact trim removed %d, first %v", n, s.FirstID())
+	}
+	if n := s.Trim(-1, StreamID{Ms: 200}, false, 0); n != 49 || s.Len() != 51 {
+		t.Errorf("minid trim removed %d, len %d", n, s.Len())
+	}
+	// 裁剪不影响 lastID 和 entriesAdded
+	if s.LastID() != (StreamID{Ms: 250}) || s.EntriesAdded() != 250 {
+		t.Errorf("lastID=%v entriesAdded=%d", s.LastID(), s.EntriesAdded())
+	}
+}
+
+func TestStreamGroup(t *testing.T) {
+	s := NewStream()
+	addStreamEntries(s, 5)
+	g, ok := s.CreateGroup("g", StreamID{}, 0)
+	if !ok {
+		t.Fatal("CreateGroup failed")
+	}
+	if _, ok := s.CreateGroup("g", StreamID{}, 0); ok {
+		t.Error("duplicated group should fail")
+	}
+	if lag, ok := s.Lag(g); !ok || lag != 5 {
+		t.Errorf("lag = %d, %v", lag, ok)
+	}
+
+	alice, _ := g.CreateConsumer("alice", 1)
+	bob, _ := g.CreateConsumer("bob", 1)
+	for i := 1; i <= 3; i++ {
+		s.DeliverNew(g, StreamID{Ms: uint64(i)})
+		g.Deliver(StreamID{Ms: uint64(i)}, alice, 10, 1)
+	}
+	if g.LastID != (StreamID{Ms: 3}) || g.EntriesRead != 3 {
+		t.Errorf("lastID=%v entriesRead=%d", g.LastID, g.EntriesRead)
+	}
+	if lag, _ := s.Lag(g); lag != 2 {
+		t.Errorf("lag = %d", lag)
+	}
+
+	// 转移给另一个消费者
+	g.Deliver(StreamID{Ms: 2}, bob, 20, 2)
+	if alice.Pending.Len() != 2 || bob.Pending.Len() != 1 || g.Pending.Len() != 3 {
+		t.Errorf("pending alice=%d bob=%d group=%d", alice.Pending.Len(), bob.Pending.Len(), g.Pending.Len())
+	}
+	if !g.Ack(StreamID{Ms: 1}) || g.Ack(StreamID{Ms: 1}) {
+		t.Error("Ack should succeed only once")
+	}
+	if n, ok := g.DeleteConsumer("bob"); !ok || n != 1 || g.Pending.Len() != 1 {
+		t.Errorf("DeleteConsumer = %d, %v, group pending %d", n, ok, g.Pending.Len())
+	}
+
+	// 删除了未读条目后无法计算延迟
+	s.Delete(StreamID{Ms: 4})
+	if _, ok := s.Lag(g); ok {
+		t.Error("lag should be unknown with tombstones after last delivered id")
+	}
+}
+
+func TestStreamClone(t *testing.T) {
+	s := NewStream()
+	addStreamEntries(s, 3)
+	g, _ := s.CreateGroup("g", StreamID{Ms: 1}, 1)
+	c, _ := g.CreateConsumer("c", 1)
+	g.Deliver(StreamID{Ms: 1}, c, 10, 1)
+
+	clone := s.Clone().(*Stream)
+	s.Add(StreamID{Ms: 10}, [][]byte{[]byte("f"), []byte("v")})
+	g.Ack(StreamID{Ms: 1})
+
+	if clone.Len() != 3 || clone.LastID() != (StreamID{Ms: 3}) {
+		t.Errorf("clone len=%d lastID=%v", clone.Len(), clone.LastID())
+	}
+	cg, _ := clone.Group("g")
+	if cg.Pending.Len() != 1 {
+		t.Error("clone should keep its own PEL")
+	}
+	if cmdLines := clone.ToWriteCmdLines("k"); len(cmdLines) != 6 {
+		t.Errorf("unexpected rewrite commands %q", cmdLines)
+	}
+}
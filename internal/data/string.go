@@ -4,6 +4,7 @@ import (
 	"errors"
 	"goredis/internal/common"
 	"goredis/internal/types"
+	"math"
 	"strconv"
 )
 
@@ -19,10 +20,31 @@ type String interface {
 	// INCR / INCRBY / DECRBY
 	// 如果不是整数，返回 error（Redis 行为）
 	IncrBy(delta int64) (int64, error)
+
+	// INCRBYFLOAT，返回新值的字符串形式
+	IncrByFloat(delta float64) ([]byte, error)
+
+	// GETRANGE，下标含义与 Redis 一致，支持负数
+	GetRange(start, end int64) []byte
+
+	// SETRANGE，超出长度的部分用 0 填充，返回新的长度
+	SetRange(offset int, val []byte) int
+
+	// GETBIT / SETBIT，位的顺序与 Redis 一致：每个字节从高位开始
+	GetBit(offset int64) byte
+	SetBit(offset int64, bit byte) byte
 }
 
+var (
+	ErrNotFloat = errors.New("value is not a valid float")
+	ErrNaNOrInf = errors.New("increment would produce NaN or Infinity")
+	ErrOverflow = errors.New("increment or decrement would overflow")
+)
+
 var _ String = &SimpleString{}
 
+const stringOverhead = 48 // SimpleString 结构体
+
 type SimpleString struct {
 	// 如果 isInt=true，则 valInt 有效
 	isInt  bool
@@ -40,7 +62,7 @@ func NewSimpleString(isInt bool, val int64) *SimpleString {
 }
 func NewStringFromBytes(b []byte) *SimpleString {
 	// 尝试解析成 int（模拟 Redis 行为）
-	if i, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+	if i, ok := parseStrictInt(b); ok {
 		return &SimpleString{
 			isInt:  true,
 			valInt: i,
@@ -53,6 +75,23 @@ func NewStringFromBytes(b []byte) *SimpleString {
 	}
 }
 
+// parseStrictInt 只有格式化后与原值完全相同时才按整数编码，"007"、"+1" 等保持原样
+func parseStrictInt(b []byte) (int64, bool) {
+	i, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil || strconv.FormatInt(i, 10) != string(b) {
+		return 0, false
+	}
+	return i, true
+}
+
+// MemoryUsage 估算占用的内存，整数编码只占结构体本身
+func (s *SimpleString) MemoryUsage() int64 {
+	if s.isInt {
+		return stringOverhead
+	}
+	return stringOverhead + int64(cap(s.valRaw))
+}
+
 func (s *SimpleString) Get() []byte {
 	if s.isInt {
 		return []byte(strconv.FormatInt(s.valInt, 10))
@@ -61,7 +100,7 @@ func (s *SimpleString) Get() []byte {
 }
 
 func (s *SimpleString) Set(b []byte) {
-	if i, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+	if i, ok := parseStrictInt(b); ok {
 		s.isInt = true
 		s.valInt = i
 		s.valRaw = nil
@@ -74,12 +113,105 @@ func (s *SimpleString) Set(b []byte) {
 
 func (s *SimpleString) IncrBy(delta int64) (int64, error) {
 	if !s.isInt {
-		return 0, errors.New("value is not an integer")
+		// 位操作等原地修改后可能是整数的 raw 编码
+		i, ok := parseStrictInt(s.valRaw)
+		if !ok {
+			return 0, errors.New("value is not an integer")
+		}
+		s.isInt, s.valInt, s.valRaw = true, i, nil
+	}
+	if (delta > 0 && s.valInt > math.MaxInt64-delta) || (delta < 0 && s.valInt < math.MinInt64-delta) {
+		return 0, ErrOverflow
 	}
 	s.valInt += delta
 	return s.valInt, nil
 }
 
+func (s *SimpleString) IncrByFloat(delta float64) ([]byte, error) {
+	cur, err := strconv.ParseFloat(string(s.Get()), 64)
+	if err != nil {
+		return nil, ErrNotFloat
+	}
+	val := cur + delta
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return nil, ErrNaNOrInf
+	}
+	b := []byte(strconv.FormatFloat(val, 'f', -1, 64))
+	s.Set(b)
+	return b, nil
+}
+
+func (s *SimpleString) GetRange(start, end int64) []byte {
+	val := s.Get()
+	n := int64(len(val))
+	if start < 0 && end < 0 && start > end {
+		return []byte{}
+	}
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= n {
+		end = n - 1
+	}
+	if n == 0 || start > end {
+		return []byte{}
+	}
+	return append([]byte(nil), val[start:end+1]...)
+}
+
+func (s *SimpleString) SetRange(offset int, val []byte) int {
+	cur := s.Get()
+	if len(val) == 0 {
+		return len(cur)
+	}
+	size := len(cur)
+	if offset+len(val) > size {
+		size = offset + len(val)
+	}
+	b := make([]byte, size)
+	copy(b, cur)
+	copy(b[offset:], val)
+	s.Set(b)
+	return size
+}
+
+func (s *SimpleString) GetBit(offset int64) byte {
+	val := s.Get()
+	idx := offset >> 3
+	if idx >= int64(len(val)) {
+		return 0
+	}
+	return (val[idx] >> (7 - uint(offset&7))) & 1
+}
+
+// SetBit 原地修改，整数编码会先转为 raw，返回原来的位
+func (s *SimpleString) SetBit(offset int64, bit byte) byte {
+	if s.isInt {
+		s.isInt, s.valRaw, s.valInt = false, s.Get(), 0
+	}
+	idx := int(offset >> 3)
+	if idx >= len(s.valRaw) {
+		s.valRaw = append(s.valRaw, make([]byte, idx+1-len(s.valRaw))...)
+	}
+	shift := 7 - uint(offset&7)
+	old := (s.valRaw[idx] >> shift) & 1
+	if bit == 1 {
+		s.valRaw[idx] |= 1 << shift
+	} else {
+		s.valRaw[idx] &^= 1 << shift
+	}
+	return old
+}
+
 func (s *SimpleString) ToWriteCmdLine(key string) [][]byte {
 	return [][]byte{
 		[]byte("set"),
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"math"
 	"reflect"
 	"testing"
 )
@@ -40,6 +41,15 @@ func TestNewStringFromBytes(t *testing.T) {
 			t.Errorf("empty string should be raw")
 		}
 	})
+
+	t.Run("non-canonical integer (should be raw)", func(t *testing.T) {
+		for _, v := range []string{"007", "+1", "-0"} {
+			s := NewStringFromBytes([]byte(v))
+			if s.isInt || string(s.Get()) != v {
+				t.Errorf("%q should be kept as raw string, got %q", v, s.Get())
+			}
+		}
+	})
 }
 
 func TestGet(t *testing.T) {
@@ -120,6 +130,92 @@ func TestIncrBy(t *testing.T) {
 	})
 }
 
+func TestIncrByOverflow(t *testing.T) {
+	s := NewSimpleString(true, math.MaxInt64)
+	if _, err := s.IncrBy(1); err != ErrOverflow {
+		t.Errorf("expected ErrOverflow, got %v", err)
+	}
+	s = NewSimpleString(true, math.MinInt64)
+	if _, err := s.IncrBy(-1); err != ErrOverflow {
+		t.Errorf("expected ErrOverflow, got %v", err)
+	}
+	if s.valInt != math.MinInt64 {
+		t.Errorf("value should be unchanged after overflow")
+	}
+}
+
+func TestIncrByFloat(t *testing.T) {
+	t.Run("int and float", func(t *testing.T) {
+		s := NewStringFromBytes([]byte("10"))
+		got, err := s.IncrByFloat(0.5)
+		if err != nil || string(got) != "10.5" || string(s.Get()) != "10.5" {
+			t.Errorf("expected 10.5, got %q, %v", got, err)
+		}
+		got, _ = s.IncrByFloat(-0.5)
+		if string(got) != "10" || !s.isInt {
+			t.Errorf("expected integer 10, got %q", got)
+		}
+		got, _ = NewStringFromBytes([]byte("5.0e3")).IncrByFloat(200)
+		if string(got) != "5200" {
+			t.Errorf("expected 5200, got %q", got)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if _, err := NewStringFromBytes([]byte("abc")).IncrByFloat(1); err != ErrNotFloat {
+			t.Errorf("expected ErrNotFloat, got %v", err)
+		}
+		s := NewStringFromBytes([]byte("1"))
+		if _, err := s.IncrByFloat(math.Inf(1)); err != ErrNaNOrInf {
+			t.Errorf("expected ErrNaNOrInf, got %v", err)
+		}
+		if string(s.Get()) != "1" {
+			t.Errorf("value should be unchanged on error")
+		}
+	})
+}
+
+func TestGetRangeSetRange(t *testing.T) {
+	t.Run("GetRange", func(t *testing.T) {
+		s := NewStringFromBytes([]byte("This is a string"))
+		cases := []struct {
+			start, end int64
+			want       string
+		}{
+			{0, 3, "This"},
+			{-3, -1, "ing"},
+			{0, -1, "This is a string"},
+			{10, 100, "string"},
+			{5, 3, ""},
+			{-1, -5, ""},
+			{100, 200, ""},
+		}
+		for _, tc := range cases {
+			if got := s.GetRange(tc.start, tc.end); string(got) != tc.want {
+				t.Errorf("GetRange(%d, %d) = %q, want %q", tc.start, tc.end, got, tc.want)
+			}
+		}
+	})
+
+	t.Run("SetRange", func(t *testing.T) {
+		s := NewStringFromBytes([]byte("Hello World"))
+		if n := s.SetRange(6, []byte("Redis")); n != 11 || string(s.Get()) != "Hello Redis" {
+			t.Errorf("unexpected result %d %q", n, s.Get())
+		}
+
+		s = NewStringFromBytes(nil)
+		if n := s.SetRange(3, []byte("1")); n != 4 || string(s.Get()) != "\x00\x00\x001" {
+			t.Errorf("should pad with zero bytes, got %d %q", n, s.Get())
+		}
+
+		s = NewStringFromBytes([]byte("12"))
+		s.SetRange(1, []byte("5"))
+		if !s.isInt || s.valInt != 15 {
+			t.Errorf("expected integer 15, got %q", s.Get())
+		}
+	})
+}
+
 func TestClone(t *testing.T) {
 	t.Run("clone int", func(t *testing.T) {
 		orig := &SimpleString{isInt: true, valInt: 99}
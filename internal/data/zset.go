@@ -37,6 +37,9 @@ type ZSetInterface interface {
 	// ZCOUNT key min max
 	ZCount(min, max float64) int
 
+	// ZRangeByScore 按分值升序返回 [min, max] 内的成员和分值
+	ZRangeByScore(min, max float64) ([][]byte, []float64)
+
 	// ZINCRBY key increment member
 	ZIncrBy(delta float64, member []byte) float64
 
@@ -53,10 +56,18 @@ const (
 	zsetMaxZiplist = 128 // 小规模使用 listpack
 )
 
+// 有序集合的估算开销：listpack 中每个元素带 8 字节分值，skiplist 中每个元素还有节点和 dict 条目
+const (
+	zsetOverhead              = 128
+	zsetListPackEntrySize     = 16
+	zsetSkipListEntryOverhead = 128
+)
+
 type ZSet struct {
 	dict map[string]float64   // member -> score 映射
 	sl   *datastruct.SkipList // 大规模用 SkipList
 	lp   *datastruct.ListPack // 小规模用 listpack，元素存成 [score|member]
+	size int64                // 所有 member 的字节数
 }
 
 func NewZSet() *ZSet {
@@ -97,6 +108,7 @@ func (zs *ZSet) ZAdd(nx bool, xx bool, score float64, member []byte) int {
 			}
 		} else {
 			added = 1
+			zs.size += int64(len(member))
 		}
 
 		// 插入新的 entry
@@ -128,6 +140,7 @@ func (zs *ZSet) ZAdd(nx bool, xx bool, score float64, member []byte) int {
 		zs.sl.Delete(oldScore, member)
 	} else {
 		added = 1
+		zs.size += int64(len(member))
 	}
 
 	zs.sl.Insert(score, member)
@@ -154,6 +167,7 @@ func (zs *ZSet) ZRem(member []byte) int {
 	}
 
 	delete(zs.dict, memberStr)
+	zs.size -= int64(len(member))
 
 	if zs.sl != nil {
 		zs.sl.Delete(oldScore, member)
@@ -368,6 +382,15 @@ func (zs *ZSet) ZRevRange(start, stop int, withScores bool) [][]byte {
 	return res
 }
 
+// MemoryUsage 估算占用的内存
+func (zs *ZSet) MemoryUsage() int64 {
+	entryOverhead := int64(zsetListPackEntrySize)
+	if zs.sl != nil {
+		entryOverhead = zsetSkipListEntryOverhead
+	}
+	return zsetOverhead + int64(len(zs.dict))*entryOverhead + zs.size
+}
+
 func (zs *ZSet) ZCard() int {
 	return len(zs.dict)
 }
@@ -407,10 +430,54 @@ func (zs *ZSet) ZCount(min, max float64) int {
 	return len(nodes)
 }
 
+func (zs *ZSet) ZRangeByScore(min, max float64) ([][]byte, []float64) {
+	var members [][]byte
+	var scores []float64
+
+	// 大规模 SkipList 本身有序
+	if zs.sl != nil {
+		for _, node := range zs.sl.RangeByScore(min, max, true) {
+			elem := node.Element()
+			members = append(members, elem.Member)
+			scores = append(scores, elem.Score)
+		}
+		return members, scores
+	}
+
+	// 小规模 ListPack 无序，过滤后排序
+	type entry struct {
+		member []byte
+		score  float64
+	}
+	var entries []entry
+	for i := 0; i < zs.lp.Len(); i++ {
+		raw := zs.lp.GetRaw(i)
+		if raw == nil {
+			continue
+		}
+		score, member := decodeZSetEntry(raw)
+		if score >= min && score <= max {
+			entries = append(entries, entry{member, score})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].score != entries[j].score {
+			return entries[i].score < entries[j].score
+		}
+		return bytes.Compare(entries[i].member, entries[j].member) < 0
+	})
+	for _, e := range entries {
+		members = append(members, e.member)
+		scores = append(scores, e.score)
+	}
+	return members, scores
+}
+
 func (zs *ZSet) Clear() {
 	zs.dict = make(map[string]float64)
 	zs.lp = datastruct.NewListPack(zsetMaxZiplist)
 	zs.sl = nil
+	zs.size = 0
 }
 
 func (zs *ZSet) ToWriteCmdLine(key string) [][]byte {
@@ -427,7 +494,8 @@ func (zs *ZSet) ToWriteCmdLine(key string) [][]byte {
 func (zs *ZSet) Clone() interface{} {
 	nz := NewZSet()
 
-	for _, member := range zs.ZRange(0, zs.lp.Len()-1, false) {
+	// 升级为 SkipList 后 lp 为 nil，按 ZCard 取全部成员
+	for _, member := range zs.ZRange(0, zs.ZCard()-1, false) {
 		score := zs.dict[string(member)]
 		nz.ZAdd(false, false, score, common.CloneBytes(member))
 	}
@@ -0,0 +1,405 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"goredis/internal/command"
+	"goredis/internal/resp"
+	"goredis/pkg/connection"
+)
+
+// blockingOp 一次阻塞读取：依次尝试 keys，实际的读取用等价的非阻塞命令完成，
+// 执行时产生的写命令也是写入 AOF 和复制流的内容
+type blockingOp struct {
+	keys    []string
+	timeout time.Duration // 0 表示一直阻塞
+	// serve 可能修改的 key：等待的 key 以及 BLMOVE 的目标，prepare 和 serve 期间持有它们的写锁
+	lockKeys []string
+	// prepare 首次尝试前调用，调用方持有 mdb.mu，例如将 XREAD 的 $ 换成当前的最后一个 ID
+	prepare func(db *DB)
+	// serve 尝试为 key 服务，没有数据时返回 nil 回复；同时返回需要传播的命令
+	serve    func(db *DB, key string) (resp.Reply, [][][]byte)
+	nilReply resp.Reply // 超时的回复
+	// 等待期间 key 被删除或改成其他类型时返回错误；为 false 时继续等待
+	unblockOnError bool
+}
+
+// waiter 一个阻塞中的客户端
+type waiter struct {
+	conn   connection.Connection
+	op     *blockingOp
+	result chan resp.Reply // 被服务后写入弹出结果，容量为 1
+	served bool            // 受 blockingKeys.mu 保护
+}
+
+// blockingKeys 记录每个 key 上按到达顺序排队的阻塞客户端
+type blockingKeys struct {
+	mu      sync.Mutex
+	waiters map[string][]*waiter
+	// 正在阻塞或准备阻塞的客户端数量，为 0 时写命令无需加锁检查
+	pending int32
+}
+
+func newBlockingKeys() *blockingKeys {
+	return &blockingKeys{waiters: make(map[string][]*waiter)}
+}
+
+// add 将客户端加入它等待的每个 key 的队尾，调用方需持有 mu
+func (bk *blockingKeys) add(w *waiter) {
+	for _, key := range w.op.keys {
+		bk.waiters[key] = append(bk.waiters[key], w)
+	}
+}
+
+// remove 将客户端从所有 key 的队列中移除，调用方需持有 mu
+func (bk *blockingKeys) remove(w *waiter) {
+	for _, key := range w.op.keys {
+		queue := bk.waiters[key]
+		for i, other := range queue {
+			if other == w {
+				queue = append(queue[:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(bk.waiters, key)
+		} else {
+			bk.waiters[key] = queue
+		}
+	}
+	atomic.AddInt32(&bk.pending, -1)
+}
+
+// cancel 超时或断开时退出等待；返回 false 表示已经被服务，结果在 w.result 中
+func (bk *blockingKeys) cancel(w *waiter) bool {
+	bk.mu.Lock()
+	defer bk.mu.Unlock()
+
+	if w.served {
+		return false
+	}
+	bk.remove(w)
+	return true
+}
+
+// parseBlockingOp 解析 BLPOP/BRPOP/BLMOVE/BRPOPLPUSH 和带 BLOCK 的 XREAD/XREADGROUP，参数个数已校验
+func parseBlockingOp(cmdLine [][]byte) (*blockingOp, resp.Reply) {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if cmdName == "xread" || cmdName == "xreadgroup" {
+		return parseStreamBlockingOp(cmdLine), nil
+	}
+
+	timeout, errReply := parseTimeout(cmdLine[len(cmdLine)-1])
+	if errReply != nil {
+		return nil, errReply
+	}
+
+	switch cmdName {
+	case "blpop", "brpop":
+		// BLPOP key [key ...] timeout
+		popName := []byte(cmdName[1:])
+		keys := make([]string, 0, len(cmdLine)-2)
+		for _, arg := range cmdLine[1 : len(cmdLine)-1] {
+			keys = append(keys, string(arg))
+		}
+		return &blockingOp{
+			keys:     keys,
+			timeout:  timeout,
+			lockKeys: keys,
+			serve: popServer(func(key string) [][]byte {
+				return [][]byte{popName, []byte(key)}
+			}, func(key string, popped []byte) resp.Reply {
+				return resp.MakeMultiBulkReply([][]byte{[]byte(key), popped})
+			}),
+			nilReply: resp.MakeNullMultiBulkReply(),
+		}, nil
+
+	case "blmove", "brpoplpush":
+		// BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+		// BRPOPLPUSH source destination timeout
+		src, dst := cmdLine[1], cmdLine[2]
+		from, to := []byte("right"), []byte("left")
+		if cmdName == "blmove" {
+			from, to = []byte(strings.ToLower(string(cmdLine[3]))), []byte(strings.ToLower(string(cmdLine[4])))
+			if !isListSide(from) || !isListSide(to) {
+				return nil, resp.MakeErrReply("ERR syntax error")
+			}
+		}
+		return &blockingOp{
+			keys:     []string{string(src)},
+			timeout:  timeout,
+			lockKeys: []string{string(src), string(dst)},
+			serve: popServer(func(key string) [][]byte {
+				if cmdName == "brpoplpush" {
+					return [][]byte{[]byte("rpoplpush"), src, dst}
+				}
+				return [][]byte{[]byte("lmove"), src, dst, from, to}
+			}, func(key string, popped []byte) resp.Reply {
+				return resp.MakeBulkReply(popped)
+			}),
+			nilReply: resp.MakeNullBulkReply(),
+		}, nil
+	}
+
+	return nil, resp.MakeErrReply("ERR unknown command '" + cmdName + "'")
+}
+
+// popServer 列表的阻塞弹出：执行 popCmd，弹出成功时用 reply 构造回复
+func popServer(popCmd func(key string) [][]byte, reply func(key string, popped []byte) resp.Reply) func(*DB, string) (resp.Reply, [][][]byte) {
+	return func(db *DB, key string) (resp.Reply, [][][]byte) {
+		result, cmdLines := db.execCommand(popCmd(key))
+		if resp.IsErrorReply(result) {
+			return result, nil
+		}
+		if bulk, ok := result.(*resp.BulkReply); ok && bulk.Arg != nil {
+			return reply(key, bulk.Arg), cmdLines
+		}
+		return nil, nil
+	}
+}
+
+// parseStreamBlockingOp XREAD/XREADGROUP 阻塞时重复执行同一条命令，直到有新条目。
+// 不论哪个 key 被写入，都返回所有 stream 上的新条目
+func parseStreamBlockingOp(cmdLine [][]byte) *blockingOp {
+	timeout, _ := command.StreamBlockTimeout(cmdLine)
+	keys := command.StreamReadKeys(cmdLine)
+	op := &blockingOp{
+		keys:    keys,
+		timeout: timeout,
+		// XREADGROUP 会修改消费者组；XREAD 只读，但阻塞的读取已经由 blockingKeys.mu 串行化，统一加写锁
+		lockKeys:       keys,
+		nilReply:       resp.MakeNullMultiBulkReply(),
+		unblockOnError: true,
+	}
+	readCmd := cmdLine
+	op.prepare = func(db *DB) {
+		readCmd = command.ResolveStreamLastIDs(db, cmdLine)
+	}
+	op.serve = func(db *DB, key string) (resp.Reply, [][][]byte) {
+		reply, cmdLines := db.execCommand(readCmd)
+		if _, ok := reply.(*resp.NullMultiBulkReply); ok {
+			// 没有新条目时 XREADGROUP 也可能创建了消费者，需要传播
+			return nil, cmdLines
+		}
+		return reply, cmdLines
+	}
+	return op
+}
+
+// isBlockingCmd 列表的阻塞命令，以及带 BLOCK 选项的 XREAD/XREADGROUP
+func isBlockingCmd(cmdLine [][]byte) bool {
+	switch strings.ToLower(string(cmdLine[0])) {
+	case "blpop", "brpop", "blmove", "brpoplpush":
+		return true
+	case "xread", "xreadgroup":
+		_, ok := command.StreamBlockTimeout(cmdLine)
+		return ok
+	}
+	return false
+}
+
+func isListSide(side []byte) bool {
+	return string(side) == "left" || string(side) == "right"
+}
+
+// parseTimeout 超时时间以秒为单位，支持小数
+func parseTimeout(arg []byte) (time.Duration, resp.Reply) {
+	seconds, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil {
+		return 0, resp.MakeErrReply("ERR timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, resp.MakeErrReply("ERR timeout is negative")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// lock 对 op.lockKeys 加写锁，返回解锁函数
+func (op *blockingOp) lock(db *DB) func() {
+	db.locks.RWLocks(op.lockKeys, nil)
+	return func() { db.locks.RWUnlocks(op.lockKeys, nil) }
+}
+
+// tryPop 按 key 的顺序尝试读取，返回回复和需要传播的命令；
+// 所有 key 都没有数据时返回 nil 回复
+func (db *DB) tryPop(op *blockingOp) (resp.Reply, [][][]byte) {
+	var propagated [][][]byte
+	for _, key := range op.keys {
+		reply, cmdLines := op.serve(db, key)
+		propagated = append(propagated, cmdLines...)
+		if reply != nil {
+			return reply, propagated
+		}
+	}
+	return nil, propagated
+}
+
+// execBlocking BLPOP/BRPOP/BLMOVE/BRPOPLPUSH/XREAD/XREADGROUP：有数据时立即返回，
+// 否则在 key 上排队，等待期间不持有 mdb.mu
+func (mdb *MultiDB) execBlocking(c connection.Connection, cmdLine [][]byte) resp.Reply {
+	op, errReply := parseBlockingOp(cmdLine)
+	if errReply != nil {
+		return errReply
+	}
+
+	mdb.mu.RLock()
+	db, errReply := mdb.selectDB(c.GetDBIndex())
+	if errReply != nil {
+		mdb.mu.RUnlock()
+		return errReply
+	}
+
+	bk := db.blocking
+	bk.mu.Lock()
+	// 先计数再检查数据，保证与并发的写入之间不会漏掉唤醒
+	atomic.AddInt32(&bk.pending, 1)
+	unlock := op.lock(db)
+	if op.prepare != nil {
+		op.prepare(db)
+	}
+	reply, cmdLines := db.tryPop(op)
+	// 没有数据时也可能有需要传播的写入，例如 XREADGROUP 创建的消费者
+	mdb.commitWrite(c, db.index, cmdLines)
+	unlock()
+
+	if reply != nil {
+		atomic.AddInt32(&bk.pending, -1)
+		bk.mu.Unlock()
+		mdb.signalWritten(db.index, cmdLines)
+		mdb.mu.RUnlock()
+		return reply
+	}
+
+	w := &waiter{conn: c, op: op, result: make(chan resp.Reply, 1)}
+	bk.add(w)
+	bk.mu.Unlock()
+	mdb.signalWritten(db.index, cmdLines)
+	mdb.mu.RUnlock()
+
+	var timeout <-chan time.Time
+	if op.timeout > 0 {
+		timer := time.NewTimer(op.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case reply := <-w.result:
+		return reply
+	case <-timeout:
+	case <-c.Done():
+	}
+
+	if bk.cancel(w) {
+		return op.nilReply
+	}
+	// 超时的同时被服务，以读取结果为准
+	return <-w.result
+}
+
+// popNow 事务中的阻塞命令不会阻塞，没有数据时直接返回超时的回复，调用方需持有 mdb.mu
+func (mdb *MultiDB) popNow(c connection.Connection, cmdLine [][]byte) (resp.Reply, [][][]byte) {
+	op, errReply := parseBlockingOp(cmdLine)
+	if errReply != nil {
+		return errReply, nil
+	}
+	db, errReply := mdb.selectDB(c.GetDBIndex())
+	if errReply != nil {
+		return errReply, nil
+	}
+	unlock := op.lock(db)
+	defer unlock()
+	if op.prepare != nil {
+		op.prepare(db)
+	}
+
+	reply, cmdLines := db.tryPop(op)
+	if reply == nil {
+		return op.nilReply, cmdLines
+	}
+	return reply, cmdLines
+}
+
+// signalKeysReady 写命令执行后，为在相关 key 上阻塞的客户端弹出数据，调用方需持有 mdb.mu
+func (mdb *MultiDB) signalKeysReady(dbIndex int, cmdLine [][]byte) {
+	var keys []string
+	switch strings.ToLower(string(cmdLine[0])) {
+	case "move":
+		// key 被移动到了目标库
+		index, errReply := mdb.parseDBIndex(cmdLine[2])
+		if errReply != nil {
+			return
+		}
+		dbIndex, keys = index, []string{string(cmdLine[1])}
+	case "swapdb", "flushall":
+		return
+	default:
+		keys = cmdLineKeys(cmdLine)
+	}
+
+	db, errReply := mdb.selectDB(dbIndex)
+	if errReply != nil {
+		return
+	}
+	mdb.serveBlocked(db, keys)
+}
+
+func cmdLineKeys(cmdLine [][]byte) []string {
+	cmd, errReply := lookupCommand(cmdLine)
+	if errReply != nil {
+		return nil
+	}
+	return cmd.GetKeys(cmdLine)
+}
+
+// serveBlocked 按 FIFO 顺序为 key 上阻塞的客户端读取数据，读不到数据的客户端继续等待；
+// 读取以等价的非阻塞命令写入 AOF，这些命令写入的 key（例如 BLMOVE 的目标）会继续唤醒其上的等待者
+func (mdb *MultiDB) serveBlocked(db *DB, keys []string) {
+	bk := db.blocking
+	if atomic.LoadInt32(&bk.pending) == 0 {
+		return
+	}
+
+	bk.mu.Lock()
+	defer bk.mu.Unlock()
+
+	for len(keys) > 0 {
+		key := keys[0]
+		keys = keys[1:]
+
+		// 服务过程中队列会变化，遍历副本
+		queue := append([]*waiter(nil), bk.waiters[key]...)
+		for _, w := range queue {
+			if w.served {
+				continue
+			}
+			// 已断开的客户端不再为其读取数据
+			if w.conn.IsClosed() {
+				bk.remove(w)
+				continue
+			}
+
+			unlock := w.op.lock(db)
+			reply, cmdLines := w.op.serve(db, key)
+			if len(cmdLines) > 0 {
+				mdb.propagate(db.index, cmdLines)
+				mdb.addDirty(int64(len(cmdLines)))
+				for _, line := range cmdLines {
+					keys = append(keys, cmdLineKeys(line)...)
+				}
+			}
+			unlock()
+			if reply == nil || (resp.IsErrorReply(reply) && !w.op.unblockOnError) {
+				continue
+			}
+
+			bk.remove(w)
+			w.served = true
+			w.result <- reply
+		}
+	}
+}
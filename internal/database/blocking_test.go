@@ -0,0 +1,287 @@
+package database
+
+import (
+	"goredis/internal/resp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitBlocked 等待 db 上有 n 个客户端进入阻塞
+func waitBlocked(t *testing.T, db *DB, n int32) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&db.blocking.pending) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d blocked clients, got %d", n, atomic.LoadInt32(&db.blocking.pending))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// execAsync 在新的 goroutine 中执行命令，返回接收回复的 channel
+func execAsync(mdb *MultiDB, conn *MockConnection, args ...string) <-chan resp.Reply {
+	ch := make(chan resp.Reply, 1)
+	go func() {
+		ch <- mdb.Exec(conn, toCmdLine(args...))
+	}()
+	return ch
+}
+
+func receiveReply(t *testing.T, ch <-chan resp.Reply) string {
+	t.Helper()
+	select {
+	case reply := <-ch:
+		return string(reply.ToBytes())
+	case <-time.After(time.Second):
+		t.Fatal("blocked command was not woken up")
+	}
+	return ""
+}
+
+func aofLines(aof *MockAOFHandler) []string {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	lines := make([]string, 0, len(aof.log))
+	for _, cmd := range aof.log {
+		args := make([]string, len(cmd))
+		for i, arg := range cmd {
+			args[i] = string(arg)
+		}
+		lines = append(lines, strings.Join(args, " "))
+	}
+	return lines
+}
+
+func TestBlockingList(t *testing.T) {
+	t.Run("BLPOP pops immediately when data exists", func(t *testing.T) {
+		aof := NewMockAOFHandler()
+		mdb := MakeMultiDB(4, aof)
+		conn := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("rpush", "b", "1", "2"))
+		reply := mdb.Exec(conn, toCmdLine("blpop", "a", "b", "0"))
+		if got := string(reply.ToBytes()); got != "*2\r\n$1\r\nb\r\n$1\r\n1\r\n" {
+			t.Fatalf("unexpected BLPOP reply %q", got)
+		}
+
+		lines := aofLines(aof)
+		if lines[len(lines)-1] != "lpop b" {
+			t.Errorf("BLPOP should be propagated as LPOP, got %v", lines)
+		}
+	})
+
+	t.Run("BRPOP woken by LPUSH", func(t *testing.T) {
+		aof := NewMockAOFHandler()
+		mdb := MakeMultiDB(4, aof)
+		db0, _ := mdb.GetDB(0)
+
+		ch := execAsync(mdb, &MockConnection{}, "brpop", "list", "0")
+		waitBlocked(t, db0, 1)
+
+		mdb.Exec(&MockConnection{}, toCmdLine("lpush", "list", "x"))
+		if got := receiveReply(t, ch); got != "*2\r\n$4\r\nlist\r\n$1\r\nx\r\n" {
+			t.Fatalf("unexpected BRPOP reply %q", got)
+		}
+		if _, ok := db0.GetEntity("list"); ok {
+			t.Error("empty list should be removed after pop")
+		}
+
+		lines := aofLines(aof)
+		if len(lines) != 2 || lines[0] != "lpush list x" || lines[1] != "rpop list" {
+			t.Errorf("expected push then pop in AOF, got %v", lines)
+		}
+	})
+
+	t.Run("waiters are served in FIFO order", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		db0, _ := mdb.GetDB(0)
+
+		first := execAsync(mdb, &MockConnection{}, "blpop", "q", "0")
+		waitBlocked(t, db0, 1)
+		second := execAsync(mdb, &MockConnection{}, "blpop", "other", "q", "0")
+		waitBlocked(t, db0, 2)
+
+		mdb.Exec(&MockConnection{}, toCmdLine("rpush", "q", "a"))
+		if got := receiveReply(t, first); got != "*2\r\n$1\r\nq\r\n$1\r\na\r\n" {
+			t.Fatalf("first client should be served first, got %q", got)
+		}
+		select {
+		case <-second:
+			t.Fatal("second client should still be blocked")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		mdb.Exec(&MockConnection{}, toCmdLine("rpush", "q", "b", "c"))
+		if got := receiveReply(t, second); got != "*2\r\n$1\r\nq\r\n$1\r\nb\r\n" {
+			t.Fatalf("unexpected reply for second client %q", got)
+		}
+		assertIntReply(t, mdb.Exec(&MockConnection{}, toCmdLine("llen", "q")), 1)
+		waitBlocked(t, db0, 0)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		db0, _ := mdb.GetDB(0)
+		conn := &MockConnection{}
+
+		start := time.Now()
+		if got := string(mdb.Exec(conn, toCmdLine("blpop", "k", "0.05")).ToBytes()); got != "*-1\r\n" {
+			t.Errorf("expected null array on timeout, got %q", got)
+		}
+		if time.Since(start) < 50*time.Millisecond {
+			t.Error("BLPOP returned before timeout")
+		}
+		if got := string(mdb.Exec(conn, toCmdLine("blmove", "k", "d", "left", "right", "0.01")).ToBytes()); got != "$-1\r\n" {
+			t.Errorf("expected null bulk on timeout, got %q", got)
+		}
+		waitBlocked(t, db0, 0)
+
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("blpop", "k", "-1"))); msg != "ERR timeout is negative" {
+			t.Errorf("unexpected error: %q", msg)
+		}
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("blpop", "k", "abc"))); msg != "ERR timeout is not a float or out of range" {
+			t.Errorf("unexpected error: %q", msg)
+		}
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("blmove", "k", "d", "up", "left", "0"))); msg != "ERR syntax error" {
+			t.Errorf("unexpected error: %q", msg)
+		}
+	})
+
+	t.Run("client disconnects while blocked", func(t *testing.T) {
+		aof := NewMockAOFHandler()
+		mdb := MakeMultiDB(4, aof)
+		db0, _ := mdb.GetDB(0)
+
+		gone := &MockConnection{done: make(chan struct{})}
+		ch := execAsync(mdb, gone, "blpop", "k", "0")
+		waitBlocked(t, db0, 1)
+
+		close(gone.done)
+		receiveReply(t, ch)
+		waitBlocked(t, db0, 0)
+
+		// 断开的客户端不能再消费数据
+		mdb.Exec(&MockConnection{}, toCmdLine("rpush", "k", "v"))
+		assertIntReply(t, mdb.Exec(&MockConnection{}, toCmdLine("llen", "k")), 1)
+		if lines := aofLines(aof); len(lines) != 1 {
+			t.Errorf("only RPUSH should be logged, got %v", lines)
+		}
+	})
+
+	t.Run("BLMOVE chains to waiter on destination", func(t *testing.T) {
+		aof := NewMockAOFHandler()
+		mdb := MakeMultiDB(4, aof)
+		db0, _ := mdb.GetDB(0)
+
+		mover := execAsync(mdb, &MockConnection{}, "blmove", "src", "dst", "left", "right", "0")
+		waitBlocked(t, db0, 1)
+		popper := execAsync(mdb, &MockConnection{}, "brpoplpush", "dst", "final", "0")
+		waitBlocked(t, db0, 2)
+
+		mdb.Exec(&MockConnection{}, toCmdLine("rpush", "src", "v"))
+		if got := receiveReply(t, mover); got != "$1\r\nv\r\n" {
+			t.Fatalf("unexpected BLMOVE reply %q", got)
+		}
+		if got := receiveReply(t, popper); got != "$1\r\nv\r\n" {
+			t.Fatalf("unexpected BRPOPLPUSH reply %q", got)
+		}
+		assertIntReply(t, mdb.Exec(&MockConnection{}, toCmdLine("llen", "final")), 1)
+
+		want := []string{"rpush src v", "lmove src dst left right", "rpoplpush dst final"}
+		if lines := aofLines(aof); strings.Join(lines, ",") != strings.Join(want, ",") {
+			t.Errorf("expected %v, got %v", want, lines)
+		}
+	})
+
+	t.Run("blocking command inside MULTI does not block", func(t *testing.T) {
+		aof := NewMockAOFHandler()
+		mdb := MakeMultiDB(4, aof)
+		conn := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("rpush", "k", "v"))
+		mdb.Exec(conn, toCmdLine("multi"))
+		mdb.Exec(conn, toCmdLine("blpop", "empty", "0"))
+		mdb.Exec(conn, toCmdLine("brpop", "k", "0"))
+		reply := mdb.Exec(conn, toCmdLine("exec"))
+		if got := string(reply.ToBytes()); got != "*2\r\n*-1\r\n*2\r\n$1\r\nk\r\n$1\r\nv\r\n" {
+			t.Fatalf("unexpected EXEC reply %q", got)
+		}
+
+		want := []string{"rpush k v", "multi", "rpop k", "exec"}
+		if lines := aofLines(aof); strings.Join(lines, ",") != strings.Join(want, ",") {
+			t.Errorf("expected %v, got %v", want, lines)
+		}
+	})
+}
+
+func TestBlockingStream(t *testing.T) {
+	t.Run("XREAD BLOCK $ woken by XADD", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		db0, _ := mdb.GetDB(0)
+		conn := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("xadd", "s", "1-0", "f", "old"))
+		ch := execAsync(mdb, &MockConnection{}, "xread", "BLOCK", "0", "STREAMS", "s", "$")
+		waitBlocked(t, db0, 1)
+
+		// 写入其他 key 不会唤醒
+		mdb.Exec(conn, toCmdLine("xadd", "other", "1-0", "f", "v"))
+		mdb.Exec(conn, toCmdLine("xadd", "s", "2-0", "f", "new"))
+		if got := receiveReply(t, ch); got != "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nf\r\n$3\r\nnew\r\n" {
+			t.Fatalf("unexpected XREAD reply %q", got)
+		}
+		waitBlocked(t, db0, 0)
+
+		// 有数据时不阻塞，超时返回空数组
+		if got := string(mdb.Exec(conn, toCmdLine("xread", "BLOCK", "0", "STREAMS", "s", "1-0")).ToBytes()); !strings.HasPrefix(got, "*1\r\n") {
+			t.Errorf("unexpected XREAD reply %q", got)
+		}
+		if got := string(mdb.Exec(conn, toCmdLine("xread", "BLOCK", "10", "STREAMS", "s", "$")).ToBytes()); got != "*-1\r\n" {
+			t.Errorf("expected null array on timeout, got %q", got)
+		}
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("xread", "BLOCK", "-1", "STREAMS", "s", "$"))); msg != "ERR timeout is negative" {
+			t.Errorf("unexpected error: %q", msg)
+		}
+	})
+
+	t.Run("XREADGROUP BLOCK propagates delivery", func(t *testing.T) {
+		aof := NewMockAOFHandler()
+		mdb := MakeMultiDB(4, aof)
+		db0, _ := mdb.GetDB(0)
+		conn := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("xgroup", "create", "s", "g", "$", "mkstream"))
+		ch := execAsync(mdb, &MockConnection{}, "xreadgroup", "GROUP", "g", "alice", "BLOCK", "0", "STREAMS", "s", ">")
+		waitBlocked(t, db0, 1)
+
+		mdb.Exec(conn, toCmdLine("xadd", "s", "1-0", "f", "v"))
+		if got := receiveReply(t, ch); got != "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n" {
+			t.Fatalf("unexpected XREADGROUP reply %q", got)
+		}
+
+		lines := aofLines(aof)
+		if len(lines) != 7 || lines[1] != "xgroup createconsumer s g alice" || lines[2] != "xadd s 1-0 f v" ||
+			lines[3] != "multi" || !strings.HasPrefix(lines[4], "xclaim s g alice 0 1-0 ") ||
+			lines[5] != "xgroup setid s g 1-0 entriesread 1" || lines[6] != "exec" {
+			t.Errorf("unexpected AOF %q", lines)
+		}
+		assertIntReply(t, mdb.Exec(conn, toCmdLine("xack", "s", "g", "1-0")), 1)
+	})
+
+	t.Run("XREADGROUP unblocked when the key is deleted", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		db0, _ := mdb.GetDB(0)
+
+		mdb.Exec(&MockConnection{}, toCmdLine("xgroup", "create", "s", "g", "$", "mkstream"))
+		ch := execAsync(mdb, &MockConnection{}, "xreadgroup", "GROUP", "g", "alice", "BLOCK", "0", "STREAMS", "s", ">")
+		waitBlocked(t, db0, 1)
+
+		mdb.Exec(&MockConnection{}, toCmdLine("del", "s"))
+		if got := receiveReply(t, ch); !strings.HasPrefix(got, "-NOGROUP") {
+			t.Fatalf("expected NOGROUP error, got %q", got)
+		}
+	})
+}
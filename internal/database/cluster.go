@@ -0,0 +1,347 @@
+package database
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goredis/internal/cluster"
+	"goredis/internal/command"
+	"goredis/internal/persistant"
+	"goredis/internal/resp"
+	"goredis/internal/types"
+	"goredis/pkg/connection"
+	"goredis/pkg/parser"
+)
+
+// slotIndex 集群模式下按槽记录数据库中的 key，用于 CLUSTER GETKEYSINSLOT/COUNTKEYSINSLOT。
+// 由 PutEntity/Remove/Clear 维护，不同 key 的修改可能并发，需要自己的锁
+type slotIndex struct {
+	mu    sync.Mutex
+	slots map[int]map[string]struct{}
+}
+
+func newSlotIndex() *slotIndex {
+	return &slotIndex{slots: make(map[int]map[string]struct{})}
+}
+
+func (idx *slotIndex) add(key string) {
+	slot := cluster.KeySlot(key)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	keys, ok := idx.slots[slot]
+	if !ok {
+		keys = make(map[string]struct{})
+		idx.slots[slot] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+func (idx *slotIndex) remove(key string) {
+	slot := cluster.KeySlot(key)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if keys, ok := idx.slots[slot]; ok {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(idx.slots, slot)
+		}
+	}
+}
+
+func (idx *slotIndex) clear() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.slots = make(map[int]map[string]struct{})
+}
+
+func (idx *slotIndex) count(slot int) int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return len(idx.slots[slot])
+}
+
+// keys 按字典序返回槽中最多 count 个 key
+func (idx *slotIndex) keys(slot, count int) []string {
+	idx.mu.Lock()
+	keys := make([]string, 0, len(idx.slots[slot]))
+	for key := range idx.slots[slot] {
+		keys = append(keys, key)
+	}
+	idx.mu.Unlock()
+
+	sort.Strings(keys)
+	if len(keys) > count {
+		keys = keys[:count]
+	}
+	return keys
+}
+
+// EnableSlotIndex 开启集群模式的按槽索引，已有的 key 也会加入索引，需在开始服务前调用
+func (mdb *MultiDB) EnableSlotIndex() {
+	mdb.mu.Lock()
+	defer mdb.mu.Unlock()
+	for _, db := range mdb.dbSet {
+		idx := newSlotIndex()
+		db.data.ForEach(func(key string, _ interface{}) bool {
+			idx.add(key)
+			return true
+		})
+		db.slots = idx
+	}
+}
+
+// CountKeysInSlot 集群模式只使用 0 号数据库
+func (mdb *MultiDB) CountKeysInSlot(slot int) int {
+	mdb.mu.RLock()
+	defer mdb.mu.RUnlock()
+	if idx := mdb.dbSet[0].slots; idx != nil {
+		return idx.count(slot)
+	}
+	return 0
+}
+
+func (mdb *MultiDB) GetKeysInSlot(slot int, count int) []string {
+	mdb.mu.RLock()
+	defer mdb.mu.RUnlock()
+	if idx := mdb.dbSet[0].slots; idx != nil {
+		return idx.keys(slot, count)
+	}
+	return nil
+}
+
+// KeyExists key 是否存在于 index 号数据库，集群模式下据此判断迁移中的 key 是否还在本地
+func (mdb *MultiDB) KeyExists(index int, key string) bool {
+	mdb.mu.RLock()
+	defer mdb.mu.RUnlock()
+	db, errReply := mdb.selectDB(index)
+	if errReply != nil {
+		return false
+	}
+	keys := []string{key}
+	db.locks.RWLocks(nil, keys)
+	defer db.locks.RWUnlocks(nil, keys)
+	_, exists := db.GetEntity(key)
+	return exists
+}
+
+// CommandKeys 返回命令涉及的 key，集群模式下据此判断命令应该由哪个节点执行。
+// 除了命令表中声明的 key，还包括脚本的 numkeys 个 key、阻塞命令和 MOVE/MIGRATE/WATCH 的 key
+func CommandKeys(cmdLine [][]byte) []string {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	switch cmdName {
+	case "eval", "evalsha", "fcall", "fcall_ro":
+		if len(cmdLine) < 3 {
+			return nil
+		}
+		n, err := strconv.Atoi(string(cmdLine[2]))
+		if err != nil || n < 0 || 3+n > len(cmdLine) {
+			return nil
+		}
+		return bytesToKeys(cmdLine[3 : 3+n])
+	case "watch":
+		return bytesToKeys(cmdLine[1:])
+	case "migrate":
+		return migrateKeys(cmdLine)
+	}
+	if arity, ok := multiDBCmdArity[cmdName]; ok {
+		if !validateArity(arity, cmdLine) {
+			return nil
+		}
+		switch cmdName {
+		case "blpop", "brpop":
+			return bytesToKeys(cmdLine[1 : len(cmdLine)-1])
+		case "blmove", "brpoplpush":
+			return bytesToKeys(cmdLine[1:3])
+		case "move":
+			return bytesToKeys(cmdLine[1:2])
+		}
+		return nil
+	}
+	return cmdLineKeys(cmdLine)
+}
+
+func bytesToKeys(args [][]byte) []string {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+	return keys
+}
+
+// migrateArgs MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [KEYS key [key ...]]
+type migrateArgs struct {
+	addr     string
+	keys     []string
+	dbIndex  int
+	timeout  time.Duration
+	copy     bool // 迁移后保留本地的 key
+	replace  bool // 覆盖目标节点上已有的 key
+	keysMode bool
+}
+
+func parseMigrate(cmdLine [][]byte) (*migrateArgs, resp.Reply) {
+	args := &migrateArgs{addr: net.JoinHostPort(string(cmdLine[1]), string(cmdLine[2]))}
+	dbIndex, err1 := strconv.Atoi(string(cmdLine[4]))
+	timeout, err2 := strconv.ParseInt(string(cmdLine[5]), 10, 64)
+	if err1 != nil || err2 != nil {
+		return nil, resp.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if timeout <= 0 {
+		timeout = 1000
+	}
+	args.dbIndex, args.timeout = dbIndex, time.Duration(timeout)*time.Millisecond
+
+	for i := 6; i < len(cmdLine) && !args.keysMode; i++ {
+		switch strings.ToLower(string(cmdLine[i])) {
+		case "copy":
+			args.copy = true
+		case "replace":
+			args.replace = true
+		case "keys":
+			if len(cmdLine[3]) != 0 {
+				return nil, resp.MakeErrReply("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			args.keysMode = true
+			args.keys = bytesToKeys(cmdLine[i+1:])
+		default:
+			return nil, resp.MakeErrReply("ERR syntax error")
+		}
+	}
+	if !args.keysMode {
+		args.keys = []string{string(cmdLine[3])}
+	}
+	return args, nil
+}
+
+// migrateKeys MIGRATE 要迁移的 key，命令有误时返回 nil
+func migrateKeys(cmdLine [][]byte) []string {
+	if !validateArity(multiDBCmdArity["migrate"], cmdLine) {
+		return nil
+	}
+	args, errReply := parseMigrate(cmdLine)
+	if errReply != nil {
+		return nil
+	}
+	return args.keys
+}
+
+func (mdb *MultiDB) lockMigrate(c connection.Connection, cmdLine [][]byte) func() {
+	db, errReply := mdb.selectDB(c.GetDBIndex())
+	keys := migrateKeys(cmdLine)
+	if errReply != nil || len(keys) == 0 {
+		return func() {}
+	}
+	db.locks.RWLocks(keys, nil)
+	return func() { db.locks.RWUnlocks(keys, nil) }
+}
+
+// MIGRATE 把 key 以 RESTORE-ASKING 发送到目标节点，成功后删除本地的 key（COPY 时保留）并传播 DEL。
+// 执行期间持有这些 key 的写锁，迁移过程中不会被修改；本地都不存在时回复 NOKEY
+func (mdb *MultiDB) execMigrate(c connection.Connection, cmdLine [][]byte) resp.Reply {
+	args, errReply := parseMigrate(cmdLine)
+	if errReply != nil {
+		return errReply
+	}
+	db, errReply := mdb.selectDB(c.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+
+	var keys []string
+	restores := [][][]byte{{[]byte("SELECT"), []byte(strconv.Itoa(args.dbIndex))}}
+	for _, key := range args.keys {
+		entity, exists := db.GetEntity(key)
+		if !exists {
+			continue
+		}
+		value, _ := entity.Data.(types.RedisData)
+		payload, err := persistant.DumpValue(value)
+		if err != nil {
+			return resp.MakeErrReply("ERR " + err.Error())
+		}
+		ttl := int64(0)
+		if expireAt, hasTTL := db.GetExpireTime(key); hasTTL {
+			ttl = max(time.Until(expireAt).Milliseconds(), 1)
+		}
+		restore := [][]byte{[]byte("RESTORE-ASKING"), []byte(key), []byte(strconv.FormatInt(ttl, 10)), payload}
+		if args.replace {
+			restore = append(restore, []byte("REPLACE"))
+		}
+		keys = append(keys, key)
+		restores = append(restores, restore)
+	}
+	if len(keys) == 0 {
+		return resp.MakeSimpleStringReply("NOKEY")
+	}
+
+	errs, errReply := sendMigrate(args.addr, args.timeout, restores)
+	if errReply != nil {
+		return errReply
+	}
+	if errs[0] != "" {
+		return resp.MakeErrReply("ERR Target instance replied with error: " + errs[0])
+	}
+
+	// 目标节点上 RESTORE 成功的 key 从本地删除，失败时回复第一个错误
+	var firstErr string
+	del := [][]byte{[]byte("DEL")}
+	for i, key := range keys {
+		if msg := errs[i+1]; msg != "" {
+			if firstErr == "" {
+				firstErr = msg
+			}
+			continue
+		}
+		if !args.copy {
+			db.Remove(key)
+			db.addVersion(key)
+			del = append(del, []byte(key))
+		}
+	}
+
+	var reply resp.Reply = resp.MakeOkReply()
+	if firstErr != "" {
+		reply = resp.MakeErrReply("ERR Target instance replied with error: " + firstErr)
+	}
+	if len(del) == 1 {
+		return &command.PropagateReply{Reply: reply}
+	}
+	return &command.PropagateReply{Reply: reply, CmdLines: [][][]byte{del}}
+}
+
+// sendMigrate 把命令一次性发给目标节点，返回每条命令的错误（成功时为空字符串）；
+// 连接或读写失败时返回 IOERR
+func sendMigrate(addr string, timeout time.Duration, cmdLines [][][]byte) ([]string, resp.Reply) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, resp.MakeErrReply("IOERR error or timeout connecting to the client")
+	}
+	defer conn.Close()
+
+	var buf []byte
+	for _, line := range cmdLines {
+		buf = append(buf, resp.MakeMultiBulkReply(line).ToBytes()...)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(buf); err != nil {
+		return nil, resp.MakeErrReply("IOERR error or timeout writing to target instance")
+	}
+
+	p := parser.NewParser(conn)
+	errs := make([]string, len(cmdLines))
+	for i := range cmdLines {
+		conn.SetDeadline(time.Now().Add(timeout))
+		reply, err := p.Parse()
+		if err != nil {
+			return nil, resp.MakeErrReply("IOERR error or timeout reading to target instance")
+		}
+		if respErr, ok := reply.(parser.RespError); ok {
+			errs[i] = respErr.Message
+		}
+	}
+	return errs, nil
+}
@@ -0,0 +1,202 @@
+package database
+
+import (
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"goredis/internal/cluster"
+	"goredis/internal/common"
+	"goredis/pkg/parser"
+)
+
+// serveMultiDB 在随机端口上为 mdb 提供服务，作为 MIGRATE 的目标节点
+func serveMultiDB(t *testing.T, mdb *MultiDB) (string, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			raw, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer raw.Close()
+				conn := &MockConnection{}
+				p := parser.NewParser(raw)
+				for {
+					payload, err := p.Parse()
+					if err != nil {
+						return
+					}
+					cmdLine, _ := common.ToCmdLine(payload)
+					raw.Write(mdb.Exec(conn, cmdLine).ToBytes())
+				}
+			}()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	return host, port
+}
+
+func TestSlotIndex(t *testing.T) {
+	mdb := MakeMultiDB(2, NewMockAOFHandler())
+	conn := &MockConnection{}
+	mdb.Exec(conn, toCmdLine("set", "{a}1", "v"))
+	mdb.EnableSlotIndex()
+	slot := cluster.KeySlot("a")
+
+	mdb.Exec(conn, toCmdLine("set", "{a}2", "v"))
+	mdb.Exec(conn, toCmdLine("rpush", "{a}3", "x"))
+	mdb.Exec(conn, toCmdLine("set", "{a}2", "overwrite"))
+	if got := mdb.CountKeysInSlot(slot); got != 3 {
+		t.Errorf("expected 3 keys in slot, got %d", got)
+	}
+	if got := mdb.GetKeysInSlot(slot, 2); !reflect.DeepEqual(got, []string{"{a}1", "{a}2"}) {
+		t.Errorf("unexpected keys %v", got)
+	}
+
+	mdb.Exec(conn, toCmdLine("del", "{a}1"))
+	mdb.Exec(conn, toCmdLine("getdel", "{a}2"))
+	mdb.Exec(conn, toCmdLine("set", "{a}4", "v"))
+	mdb.Exec(conn, toCmdLine("lpop", "{a}3"))
+	if got := mdb.GetKeysInSlot(slot, 10); !reflect.DeepEqual(got, []string{"{a}4"}) {
+		t.Errorf("unexpected keys after deletes %v", got)
+	}
+	if !mdb.KeyExists(0, "{a}4") || mdb.KeyExists(0, "{a}1") || mdb.KeyExists(5, "{a}4") {
+		t.Error("unexpected KeyExists result")
+	}
+
+	mdb.Exec(conn, toCmdLine("flushall"))
+	if got := mdb.CountKeysInSlot(slot); got != 0 {
+		t.Errorf("expected empty slot after FLUSHALL, got %d", got)
+	}
+}
+
+func TestCommandKeys(t *testing.T) {
+	cases := []struct {
+		line string
+		keys []string
+	}{
+		{"get k", []string{"k"}},
+		{"mset a 1 b 2", []string{"a", "b"}},
+		{"eval script 2 a b arg", []string{"a", "b"}},
+		{"evalsha sha 0 arg", []string{}},
+		{"blpop a b 0", []string{"a", "b"}},
+		{"blmove a b left right 0", []string{"a", "b"}},
+		{"move k 1", []string{"k"}},
+		{"watch a b", []string{"a", "b"}},
+		{"migrate host 6379 k 0 1000", []string{"k"}},
+		{"migrate host 6379 \"\" 0 1000 copy keys a b", []string{"a", "b"}},
+		{"select 1", nil},
+		{"ping", nil},
+	}
+	for _, c := range cases {
+		cmdLine := toCmdLine(strings.Fields(c.line)...)
+		if strings.Contains(c.line, `""`) {
+			cmdLine[3] = []byte{}
+		}
+		got := CommandKeys(cmdLine)
+		if len(got) != len(c.keys) || (len(got) > 0 && !reflect.DeepEqual(got, c.keys)) {
+			t.Errorf("%s: expected %v, got %v", c.line, c.keys, got)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	target := MakeMultiDB(2, NewMockAOFHandler())
+	host, port := serveMultiDB(t, target)
+	targetConn := &MockConnection{}
+
+	aof := NewMockAOFHandler()
+	mdb := MakeMultiDB(2, aof)
+	conn := &MockConnection{}
+	migrate := func(args ...string) string {
+		line := append([]string{"migrate", host, port}, args...)
+		cmdLine := toCmdLine(line...)
+		return string(mdb.Exec(conn, cmdLine).ToBytes())
+	}
+
+	t.Run("single key", func(t *testing.T) {
+		mdb.Exec(conn, toCmdLine("rpush", "list", "a", "b"))
+		mdb.Exec(conn, toCmdLine("pexpire", "list", "100000"))
+		if got := migrate("list", "1", "1000"); got != "+OK\r\n" {
+			t.Fatalf("MIGRATE failed: %q", got)
+		}
+		if got := string(mdb.Exec(conn, toCmdLine("exists", "list")).ToBytes()); got != ":0\r\n" {
+			t.Error("migrated key should be removed")
+		}
+		if !aofContains(aof, "DEL list") {
+			t.Errorf("DEL should be propagated, got %v", aofLines(aof))
+		}
+
+		targetConn.SelectDB(1)
+		if got := string(target.Exec(targetConn, toCmdLine("lrange", "list", "0", "-1")).ToBytes()); got != "*2\r\n$1\r\na\r\n$1\r\nb\r\n" {
+			t.Errorf("unexpected list on target %q", got)
+		}
+		pttl, _ := strconv.Atoi(strings.TrimSpace(string(target.Exec(targetConn, toCmdLine("pttl", "list")).ToBytes())[1:]))
+		if pttl <= 90000 || pttl > 100000 {
+			t.Errorf("ttl should be migrated, got %d", pttl)
+		}
+	})
+
+	t.Run("KEYS, COPY and REPLACE", func(t *testing.T) {
+		mdb.Exec(conn, toCmdLine("mset", "a", "1", "b", "2"))
+		target.Exec(targetConn, toCmdLine("set", "a", "old"))
+
+		keys := []string{"", "1", "1000", "copy", "keys", "a", "b", "missing"}
+		if got := migrate(keys...); !strings.HasPrefix(got, "-ERR Target instance replied with error: BUSYKEY") {
+			t.Fatalf("expected BUSYKEY, got %q", got)
+		}
+		// 成功的 b 已经复制过去，COPY 不删除本地的 key
+		if got := string(getBulkValue(target.Exec(targetConn, toCmdLine("get", "b")))); got != "2" {
+			t.Errorf("b should be migrated, got %q", got)
+		}
+
+		if got := migrate("", "1", "1000", "replace", "keys", "a", "b"); got != "+OK\r\n" {
+			t.Fatalf("MIGRATE REPLACE failed: %q", got)
+		}
+		if got := string(getBulkValue(target.Exec(targetConn, toCmdLine("get", "a")))); got != "1" {
+			t.Errorf("a should be replaced, got %q", got)
+		}
+		if got := string(mdb.Exec(conn, toCmdLine("exists", "a", "b")).ToBytes()); got != ":0\r\n" {
+			t.Errorf("migrated keys should be removed, got %q", got)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if got := migrate("missing", "0", "1000"); got != "+NOKEY\r\n" {
+			t.Errorf("expected NOKEY, got %q", got)
+		}
+		if got := migrate("k", "x", "1000"); !strings.HasPrefix(got, "-ERR value is not an integer") {
+			t.Errorf("unexpected reply %q", got)
+		}
+		if got := migrate("k", "0", "1000", "keys", "a"); !strings.HasPrefix(got, "-ERR When using MIGRATE KEYS option") {
+			t.Errorf("unexpected reply %q", got)
+		}
+		if got := migrate("k", "0", "1000", "bogus"); got != "-ERR syntax error\r\n" {
+			t.Errorf("unexpected reply %q", got)
+		}
+
+		mdb.Exec(conn, toCmdLine("set", "k", "v"))
+		if got := migrate("k", "99", "1000"); !strings.HasPrefix(got, "-ERR Target instance replied with error: ERR DB index") {
+			t.Errorf("unexpected reply %q", got)
+		}
+		ln, _ := net.Listen("tcp", "127.0.0.1:0")
+		_, closedPort, _ := net.SplitHostPort(ln.Addr().String())
+		ln.Close()
+		cmdLine := toCmdLine("migrate", "127.0.0.1", closedPort, "k", "0", "100")
+		if got := string(mdb.Exec(conn, cmdLine).ToBytes()); !strings.HasPrefix(got, "-IOERR") {
+			t.Errorf("expected IOERR, got %q", got)
+		}
+		if got := string(getBulkValue(mdb.Exec(conn, toCmdLine("get", "k")))); got != "v" {
+			t.Error("failed MIGRATE should keep the key")
+		}
+	})
+}
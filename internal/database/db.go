@@ -2,6 +2,7 @@ package database
 
 import (
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"goredis/internal/command"
@@ -16,14 +17,37 @@ const (
 	aofRewriteMinSize    = 64 * 1024 * 1024 // 64MB
 	aofRewritePercentage = 25               // 增长 25%
 	aofCheckInterval     = 10 * time.Second
+
+	entryOverhead = 64 // 每个 key 在 dict 中的条目和 DataEntity 本身
 )
 
+// versionSeq 全局递增的版本号，所有 DB 共用，保证 SWAPDB 之后版本号也不会重复
+var versionSeq uint64
+
 // DB 代表每一个单独的数据库 (如 db0, db1...)
 type DB struct {
 	index  int             // 数据库编号
 	data   datastruct.Dict // 核心数据存储 (Key -> types.DataEntity)
 	ttlMap datastruct.Dict // 过期时间存储 (Key -> time.Time) - 对标 Redis 的 expires
 
+	// WATCH 使用的版本号：key 被修改时记录新的版本号
+	// FLUSHDB/SWAPDB 等整库操作只更新 clearVersion，相当于修改了所有 key
+	versions     datastruct.Dict // Key -> uint64
+	clearVersion uint64
+
+	// BLPOP 等阻塞命令在 key 上的等待队列
+	blocking *blockingKeys
+
+	// 估算的内存占用，每个 key 的大小记录在 DataEntity 中，写命令执行后更新
+	used int64
+
+	// key 级别的读写锁：ConcurrentDict 只保护 map 本身，key 对应的数据结构由这里的锁保护，
+	// 每条命令执行期间持有它涉及的所有 key 的锁
+	locks *datastruct.Locks
+
+	// 集群模式下按槽索引的 key，未开启时为 nil，见 cluster.go
+	slots *slotIndex
+
 	aofHandler persistant.AOFHandlerInterface
 }
 
@@ -32,28 +56,16 @@ func MakeDB(index int, aofHandler persistant.AOFHandlerInterface) *DB {
 		index:      index,
 		data:       datastruct.MakeConcurrent(1024),
 		ttlMap:     datastruct.MakeConcurrent(1024),
+		versions:   datastruct.MakeConcurrent(1024),
+		blocking:   newBlockingKeys(),
+		locks:      datastruct.MakeLocks(1024),
 		aofHandler: aofHandler,
 	}
 
-	if aofHandler.HasData() {
-		if err := db.LoadAOF(); err != nil {
-			panic(err)
-		}
-	}
-
 	db.StartExpireTask()
-	db.startAOFRewriteChecker()
 	return db
 }
 
-func (db *DB) LoadAOF() error {
-	return db.aofHandler.Load(func(cmd types.CmdLine) {
-		// FakeConn，避免再次写 AOF
-		conn := connection.NewAOFConnection(db.index)
-		db.Exec(conn, cmd)
-	})
-}
-
 // GetEntity 从 dict 获取 types.DataEntity
 func (db *DB) GetEntity(key string) (*types.DataEntity, bool) {
 	raw, ok := db.data.Get(key)
@@ -62,16 +74,30 @@ func (db *DB) GetEntity(key string) (*types.DataEntity, bool) {
 	}
 	// 检查是否过期
 	if db.IsExpired(key) {
-		db.Remove(key)
+		db.expireKey(key)
 		return nil, false
 	}
 	entity, _ := raw.(*types.DataEntity)
+	entity.Touch()
 	return entity, true
 }
 
 // PutEntity 将 types.DataEntity 存入 dict
 func (db *DB) PutEntity(key string, entity *types.DataEntity) int {
-	return db.data.Put(key, entity)
+	if raw, ok := db.data.Get(key); ok {
+		if old := raw.(*types.DataEntity); old != entity {
+			db.releaseSize(old)
+			entity.InitAccess()
+		}
+	} else {
+		entity.InitAccess()
+	}
+	result := db.data.Put(key, entity)
+	db.accountSize(key, entity)
+	if db.slots != nil && result == 1 {
+		db.slots.add(key)
+	}
+	return result
 }
 
 func (db *DB) DeleteTTL(key string) {
@@ -81,34 +107,178 @@ func (db *DB) DeleteTTL(key string) {
 // Remove 删除 Key
 func (db *DB) Remove(key string) bool {
 	db.ttlMap.Remove(key) // 别忘了删除 TTL
-	return db.data.Remove(key) == 1
+	raw, ok := db.data.Get(key)
+	if !ok || db.data.Remove(key) != 1 {
+		return false
+	}
+	db.releaseSize(raw.(*types.DataEntity))
+	if db.slots != nil {
+		db.slots.remove(key)
+	}
+	return true
+}
+
+// UsedMemory 返回估算的内存占用
+func (db *DB) UsedMemory() int64 {
+	return atomic.LoadInt64(&db.used)
+}
+
+// entitySize 估算一个 key 占用的内存：dict 条目、key 本身和数据
+func entitySize(key string, entity *types.DataEntity) int64 {
+	size := int64(entryOverhead + len(key))
+	if sizer, ok := entity.Data.(types.MemorySizer); ok {
+		size += sizer.MemoryUsage()
+	}
+	return size
+}
+
+// accountSize 重新计算 key 的大小并更新内存统计
+func (db *DB) accountSize(key string, entity *types.DataEntity) {
+	size := entitySize(key, entity)
+	atomic.AddInt64(&db.used, size-entity.SwapSize(size))
+}
+
+// releaseSize 从内存统计中扣除被删除的 key
+func (db *DB) releaseSize(entity *types.DataEntity) {
+	atomic.AddInt64(&db.used, -entity.SwapSize(0))
+}
+
+// updateSize 写命令会原地修改数据，执行后重新计算涉及的 key
+func (db *DB) updateSize(keys ...string) {
+	for _, key := range keys {
+		if raw, ok := db.data.Get(key); ok {
+			db.accountSize(key, raw.(*types.DataEntity))
+		}
+	}
 }
 
 // Exec 在单个 DB 中执行命令
 // 实际逻辑是：根据 command name 查表找到对应的 ExecFunc 并调用
 func (db *DB) Exec(c connection.Connection, cmdLine [][]byte) resp.Reply {
+	cmdLine = translateCmd(cmdLine)
+	unlock := db.lockCmd(cmdLine)
+	defer unlock()
+
+	reply, cmdLines := db.execCommand(cmdLine)
+	// 只传播 execCommand 返回的写命令，读命令不写 AOF
+	if !resp.IsErrorReply(reply) && !isAOFConn(c) {
+		for _, line := range cmdLines {
+			db.aofHandler.AddAOF(db.index, line)
+		}
+	}
+
+	return reply
+}
+
+// 不在 writeCommands 中，但读取时会修改数据结构的命令，例如 PFCOUNT 会更新缓存的基数
+var mutatingReadCommands = map[string]struct{}{
+	"pfcount": {},
+}
+
+// lockCmd 按命令声明的 key 位置加锁，返回解锁函数：
+// 写命令对 key 加写锁，读命令加读锁；没有 key 的写命令（FLUSHDB）锁住整个库
+func (db *DB) lockCmd(cmdLine [][]byte) func() {
+	cmd, errReply := lookupCommand(cmdLine)
+	if errReply != nil {
+		return func() {}
+	}
+
+	keys := cmd.GetKeys(cmdLine)
+	_, mutating := mutatingReadCommands[cmd.Name]
+	if !types.CmdLine(cmdLine).IsWrite() && !mutating {
+		db.locks.RWLocks(nil, keys)
+		return func() { db.locks.RWUnlocks(nil, keys) }
+	}
+	if len(keys) == 0 {
+		db.locks.LockAll()
+		return db.locks.UnlockAll
+	}
+	db.locks.RWLocks(keys, nil)
+	return func() { db.locks.RWUnlocks(keys, nil) }
+}
+
+// execCommand 执行命令并更新被修改 key 的版本号，不写 AOF，调用方需持有 lockCmd 加的锁；
+// 同时返回需要写入 AOF 和复制流的命令，通常就是写命令本身
+func (db *DB) execCommand(cmdLine [][]byte) (resp.Reply, [][][]byte) {
+	cmd, errReply := lookupCommand(cmdLine)
+	if errReply != nil {
+		return errReply, nil
+	}
+
+	reply, cmdLines := propagation(cmdLine, cmd.Executor(db, cmdLine[1:]))
+	if !resp.IsErrorReply(reply) && types.CmdLine(cmdLine).IsWrite() {
+		keys := cmd.GetKeys(cmdLine)
+		db.addVersion(keys...)
+		db.updateSize(keys...)
+	}
+	return reply, cmdLines
+}
+
+// propagation 拆出执行函数指定的传播命令，见 command.PropagateReply；
+// 没有指定时，成功的写命令按原样传播
+func propagation(cmdLine [][]byte, reply resp.Reply) (resp.Reply, [][][]byte) {
+	if p, ok := reply.(*command.PropagateReply); ok {
+		return p.Reply, p.CmdLines
+	}
+	if resp.IsErrorReply(reply) || !types.CmdLine(cmdLine).IsWrite() {
+		return reply, nil
+	}
+	return reply, [][][]byte{cmdLine}
+}
+
+// lookupCommand 查找命令并校验参数个数
+func lookupCommand(cmdLine [][]byte) (command.Command, resp.Reply) {
 	// 1. 获取命令名称 (如 "SET")
 	cmdName := strings.ToLower(string(cmdLine[0]))
 
 	cmd, ok := command.GetCmd(cmdName)
 	if !ok {
-		return resp.MakeErrReply("ERR unknown command '" + cmdName + "'")
+		return cmd, resp.MakeErrReply("ERR unknown command '" + cmdName + "'")
 	}
 
-	// 3. 校验参数个数 (Arity Check)
+	// 2. 校验参数个数 (Arity Check)
 	if !validateArity(cmd.Arity, cmdLine) {
-		return resp.MakeArgNumErrReply(cmdName)
+		return cmd, resp.MakeArgNumErrReply(cmdName)
 	}
+	return cmd, nil
+}
+
+// translateCmd 执行前改写命令，执行和写入 AOF 的都是改写后的命令，见 command.Command.Translate
+func translateCmd(cmdLine [][]byte) [][]byte {
+	cmd, ok := command.GetCmd(strings.ToLower(string(cmdLine[0])))
+	if !ok || cmd.Translate == nil || !validateArity(cmd.Arity, cmdLine) {
+		return cmdLine
+	}
+	return cmd.Translate(cmdLine)
+}
 
-	// 4. 执行具体函数
-	reply := cmd.Executor(db, cmdLine[1:])
-	if !resp.IsErrorReply(reply) {
-		if _, ok := c.(*connection.AOFConnection); !ok {
-			db.aofHandler.AddAOF(cmdLine)
+// GetVersion 返回 key 当前的版本号，WATCH 时记录，EXEC 时比较
+func (db *DB) GetVersion(key string) uint64 {
+	version := atomic.LoadUint64(&db.clearVersion)
+	if raw, ok := db.versions.Get(key); ok {
+		if v := raw.(uint64); v > version {
+			version = v
 		}
 	}
+	return version
+}
 
-	return reply
+func (db *DB) addVersion(keys ...string) {
+	for _, key := range keys {
+		db.versions.Put(key, atomic.AddUint64(&versionSeq, 1))
+	}
+}
+
+// touchAll 标记整个数据库被修改
+func (db *DB) touchAll() {
+	atomic.StoreUint64(&db.clearVersion, atomic.AddUint64(&versionSeq, 1))
+	db.versions.Clear()
+}
+
+// expireKey 删除已过期的 key，过期也算作一次修改
+func (db *DB) expireKey(key string) {
+	db.Remove(key)
+	db.addVersion(key)
 }
 
 func (db *DB) GetDBIndex() int {
@@ -117,7 +287,8 @@ func (db *DB) GetDBIndex() int {
 
 func (db *DB) ForEach(handler func(key string, entity types.RedisData)) {
 	db.data.ForEach(func(key string, data interface{}) bool {
-		handler(key, data.(types.RedisData))
+		entity := data.(*types.DataEntity)
+		handler(key, entity.Data.(types.RedisData))
 		return true
 	})
 }
@@ -165,19 +336,14 @@ func (db *DB) activeExpire() {
 	now := time.Now()
 
 	for _, key := range keys {
+		db.locks.Lock(key)
 		raw, ok := db.ttlMap.Get(key)
-		if !ok {
-			continue
-		}
-
-		expireAt, ok := raw.(time.Time)
-		if !ok {
-			continue
-		}
-
-		if now.After(expireAt) {
-			db.Remove(key)
+		if ok {
+			if expireAt, ok := raw.(time.Time); ok && now.After(expireAt) {
+				db.expireKey(key)
+			}
 		}
+		db.locks.Unlock(key)
 	}
 }
 
@@ -190,56 +356,52 @@ func (db *DB) GetExpireTime(key string) (time.Time, bool) {
 	return val.(time.Time), true
 }
 
-func (db *DB) Clear() {
-	db.data = datastruct.MakeConcurrent(1024)
-	db.ttlMap = datastruct.MakeConcurrent(1024)
+func (db *DB) Len() int {
+	return db.data.Len()
 }
 
-func (db *DB) startAOFRewriteChecker() {
-	go func() {
-		ticker := time.NewTicker(aofCheckInterval)
-		defer ticker.Stop()
-
-		var lastRewriteSize int64
-
-		for range ticker.C {
-			aof := db.aofHandler
-			if aof == nil {
-				continue
-			}
-
-			size, err := aof.LogSize()
-			if err != nil {
-				continue
-			}
-
-			// 小于最小 rewrite 大小，不处理
-			if size < aofRewriteMinSize {
-				continue
-			}
-
-			// 首次记录基线
-			if lastRewriteSize == 0 {
-				lastRewriteSize = size
-				continue
-			}
-
-			// 判断增长比例
-			growth := (size - lastRewriteSize) * 100 / lastRewriteSize
-			if growth < aofRewritePercentage {
-				continue
-			}
+// Scan 过期的 key 也会被返回，由调用方过滤
+func (db *DB) Scan(cursor, count int) ([]string, int) {
+	return db.data.Scan(cursor, count)
+}
 
-			// 触发 rewrite
-			db.aofHandler.Rewrite(db.Clone())
+// RandomKey 随机抽到已过期的 key 时顺便删除，再重新抽取。
+// 只由没有 key 的 RANDOMKEY 调用，执行时不持有任何 key 锁，删除前需要加锁
+func (db *DB) RandomKey() (string, bool) {
+	const maxTries = 100
+	for i := 0; i < maxTries; i++ {
+		keys := db.data.RandomKeys(1)
+		if len(keys) == 0 {
+			return "", false
 		}
-	}()
+		if !db.IsExpired(keys[0]) {
+			return keys[0], true
+		}
+		db.locks.Lock(keys[0])
+		if db.IsExpired(keys[0]) {
+			db.expireKey(keys[0])
+		}
+		db.locks.Unlock(keys[0])
+	}
+	return "", false
+}
+
+// Clear 清空当前数据库 (FLUSHDB)
+func (db *DB) Clear() {
+	db.data.Clear()
+	db.ttlMap.Clear()
+	atomic.StoreInt64(&db.used, 0)
+	if db.slots != nil {
+		db.slots.clear()
+	}
+	db.touchAll()
 }
 
 func (db *DB) Clone() *DB {
 	// 创建新的 Dict
-	newData := datastruct.MakeConcurrent(db.data.Len())
-	newTTL := datastruct.MakeConcurrent(db.ttlMap.Len())
+	// 参数是分片数，与 MakeDB 保持一致（按 key 数量分片在空库时会得到 0 个分片）
+	newData := datastruct.MakeConcurrent(1024)
+	newTTL := datastruct.MakeConcurrent(1024)
 
 	// 拷贝 data
 	db.data.ForEach(func(key string, val interface{}) bool {
@@ -257,12 +419,19 @@ func (db *DB) Clone() *DB {
 	})
 
 	return &DB{
-		index:  db.index,
-		data:   newData,
-		ttlMap: newTTL,
+		index:    db.index,
+		data:     newData,
+		ttlMap:   newTTL,
+		versions: datastruct.MakeConcurrent(1),
 	}
 }
 
+// AOFConnection 是加载 AOF 时使用的伪连接，其命令不需要再次写入 AOF
+func isAOFConn(c connection.Connection) bool {
+	_, ok := c.(*connection.AOFConnection)
+	return ok
+}
+
 // 校验参数数量
 func validateArity(arity int, cmdLine [][]byte) bool {
 	n := len(cmdLine)
@@ -9,36 +9,50 @@ import (
 	"goredis/internal/resp"
 	"goredis/internal/types"
 	"goredis/pkg/connection"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 // MockAOFHandler 实现内存版 AOF
 type MockAOFHandler struct {
-	mu      sync.Mutex
-	log     []types.CmdLine
-	hasData bool
+	mu        sync.Mutex
+	log       []types.CmdLine
+	dbIndexes []int
+	hasData   bool
 }
 
 func NewMockAOFHandler() *MockAOFHandler {
 	return &MockAOFHandler{}
 }
 
-func (m *MockAOFHandler) AddAOF(cmd types.CmdLine) {
+func (m *MockAOFHandler) AddAOF(dbIndex int, cmd types.CmdLine) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.log = append(m.log, cmd)
+	m.dbIndexes = append(m.dbIndexes, dbIndex)
 	m.hasData = true
 }
 
+func (m *MockAOFHandler) AddTransaction(cmds []persistant.TxCmd) {
+	m.AddAOF(cmds[0].DBIndex, types.CmdLine{[]byte("multi")})
+	for _, cmd := range cmds {
+		m.AddAOF(cmd.DBIndex, cmd.CmdLine)
+	}
+	m.AddAOF(cmds[0].DBIndex, types.CmdLine{[]byte("exec")})
+}
+
 func (m *MockAOFHandler) HasData() bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	return m.hasData
 }
 
-func (m *MockAOFHandler) Load(replay func(cmd types.CmdLine)) error {
+func (m *MockAOFHandler) Load(getDB func(index int) (types.Database, bool), replay func(cmd types.CmdLine)) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	for _, cmd := range m.log {
@@ -47,15 +61,15 @@ func (m *MockAOFHandler) Load(replay func(cmd types.CmdLine)) error {
 	return nil
 }
 
-func (m *MockAOFHandler) Rewrite(db types.Database) error      { return nil }
-func (m *MockAOFHandler) LogSize() (int64, error)              { return 0, nil }
-func (m *MockAOFHandler) SetBacklog(b *persistant.ReplBacklog) {}
-func (m *MockAOFHandler) CurrentOffset() int64                 { return 0 }
-func (m *MockAOFHandler) ReadAll() ([]byte, int64, error)      { return nil, 0, nil }
-func (m *MockAOFHandler) AddSlave(w connection.Connection)     {}
-func (m *MockAOFHandler) RemoveSlave(w connection.Connection)  {}
-func (m *MockAOFHandler) SetState(state int32)                 {}
-func (m *MockAOFHandler) Reset(offset int64) error             { return nil }
+func (m *MockAOFHandler) StartRewrite() error                                          { return nil }
+func (m *MockAOFHandler) FinishRewrite(dbs []types.Database, functions [][]byte) error { return nil }
+func (m *MockAOFHandler) LogSize() (int64, error)                                      { return 0, nil }
+func (m *MockAOFHandler) SetBacklog(b *persistant.ReplBacklog)                         {}
+func (m *MockAOFHandler) CurrentOffset() int64                                         { return 0 }
+func (m *MockAOFHandler) ReadAll() ([]byte, int64, error)                              { return nil, 0, nil }
+func (m *MockAOFHandler) AddSlave(w connection.Connection)                             {}
+func (m *MockAOFHandler) RemoveSlave(w connection.Connection)                          {}
+func (m *MockAOFHandler) Reset(offset int64, data []byte) error                        { return nil }
 
 func initTest() {
 	// 注册测试命令
@@ -167,9 +181,9 @@ func TestDB(t *testing.T) {
 			t.Errorf("GET returned wrong value: %q", getBulkValue(reply))
 		}
 
-		// Verify AOF logged
-		if len(aof.log) != 2 {
-			t.Errorf("expected 2 AOF entries, got %d", len(aof.log))
+		// Verify AOF logged, read commands are not propagated
+		if len(aof.log) != 1 || string(aof.log[0][0]) != "set" {
+			t.Errorf("expected only the SET in AOF, got %q", aof.log)
 		}
 	})
 
@@ -256,14 +270,295 @@ func getErrorString(reply resp.Reply) string {
 }
 
 // MockConnection for testing
-type MockConnection struct{}
+type MockConnection struct {
+	dbIndex int
+	done    chan struct{}
+
+	connection.Transaction
+}
 
 func (m *MockConnection) Write([]byte) (int, error) { return 0, nil }
 func (m *MockConnection) Close() error              { return nil }
-func (m *MockConnection) GetDBIndex() int           { return 0 }
+func (m *MockConnection) GetDBIndex() int           { return m.dbIndex }
 func (m *MockConnection) IsClosed() bool            { return false }
+func (m *MockConnection) Done() <-chan struct{}     { return m.done }
 func (m *MockConnection) IsSlave() bool             { return false }
 func (m *MockConnection) RemoteAddr() string        { return "mock" }
-func (m *MockConnection) SelectDB(index int)        {}
+func (m *MockConnection) SelectDB(index int)        { m.dbIndex = index }
 
 func (m *MockConnection) SetSlave() {}
+
+// runConcurrently 启动 workers 个协程，每个执行 fn(worker, i) n 次
+func runConcurrently(workers, n int, fn func(worker, i int)) {
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				fn(w, i)
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+// sortedLines 按行排序，用于比较成员顺序不确定的回复
+func sortedLines(s string) string {
+	lines := strings.Split(s, "\r\n")
+	sort.Strings(lines)
+	return strings.Join(lines, "\r\n")
+}
+
+// 以下测试用 -race 运行时能发现同一个 key 上的数据竞争
+func TestConcurrentExec(t *testing.T) {
+	const workers, n = 8, 100
+
+	t.Run("updates to the same key are atomic", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		runConcurrently(workers, n, func(w, i int) {
+			conn := &MockConnection{}
+			member := fmt.Sprintf("m%d-%d", w, i)
+			mdb.Exec(conn, toCmdLine("incr", "counter"))
+			mdb.Exec(conn, toCmdLine("incrbyfloat", "float", "0.5"))
+			mdb.Exec(conn, toCmdLine("append", "str", "x"))
+			mdb.Exec(conn, toCmdLine("lpush", "list", member))
+			mdb.Exec(conn, toCmdLine("hset", "hash", member, "v"))
+			mdb.Exec(conn, toCmdLine("sadd", "set", member))
+			mdb.Exec(conn, toCmdLine("zadd", "zset", strconv.Itoa(i), member))
+			mdb.Exec(conn, toCmdLine("xadd", "stream", "*", "f", member))
+			mdb.Exec(conn, toCmdLine("pfadd", "hll", member))
+			mdb.Exec(conn, toCmdLine("setbit", "bits", strconv.Itoa(w*n+i), "1"))
+
+			// 并发的读命令
+			mdb.Exec(conn, toCmdLine("lrange", "list", "0", "10"))
+			mdb.Exec(conn, toCmdLine("hgetall", "hash"))
+			mdb.Exec(conn, toCmdLine("smembers", "set"))
+			mdb.Exec(conn, toCmdLine("zrange", "zset", "0", "-1"))
+			mdb.Exec(conn, toCmdLine("xrange", "stream", "-", "+", "COUNT", "5"))
+			mdb.Exec(conn, toCmdLine("pfcount", "hll"))
+			mdb.Exec(conn, toCmdLine("bitcount", "bits"))
+			mdb.Exec(conn, toCmdLine("get", "str"))
+		})
+
+		conn := &MockConnection{}
+		total := int64(workers * n)
+		if got := string(getBulkValue(mdb.Exec(conn, toCmdLine("get", "counter")))); got != strconv.FormatInt(total, 10) {
+			t.Errorf("counter = %s, want %d", got, total)
+		}
+		if got := string(getBulkValue(mdb.Exec(conn, toCmdLine("get", "float")))); got != strconv.Itoa(int(total/2)) {
+			t.Errorf("float = %s, want %d", got, total/2)
+		}
+		assertIntReply(t, mdb.Exec(conn, toCmdLine("strlen", "str")), total)
+		assertIntReply(t, mdb.Exec(conn, toCmdLine("llen", "list")), total)
+		assertIntReply(t, mdb.Exec(conn, toCmdLine("hlen", "hash")), total)
+		assertIntReply(t, mdb.Exec(conn, toCmdLine("scard", "set")), total)
+		assertIntReply(t, mdb.Exec(conn, toCmdLine("zcard", "zset")), total)
+		assertIntReply(t, mdb.Exec(conn, toCmdLine("xlen", "stream")), total)
+		assertIntReply(t, mdb.Exec(conn, toCmdLine("bitcount", "bits")), total)
+	})
+
+	t.Run("multi-key commands", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		conn := &MockConnection{}
+		for i := 0; i < 100; i++ {
+			mdb.Exec(conn, toCmdLine("rpush", "a", strconv.Itoa(i)))
+			mdb.Exec(conn, toCmdLine("rpush", "b", strconv.Itoa(i)))
+		}
+
+		// 元素只在两个列表之间移动，总数不变；MSET 的两个 key 总是同时被读到
+		runConcurrently(workers, n, func(w, i int) {
+			conn := &MockConnection{}
+			switch w % 4 {
+			case 0:
+				mdb.Exec(conn, toCmdLine("rpoplpush", "a", "b"))
+			case 1:
+				mdb.Exec(conn, toCmdLine("lmove", "b", "a", "left", "right"))
+			case 2:
+				v := strconv.Itoa(i)
+				mdb.Exec(conn, toCmdLine("mset", "x", v, "y", v))
+			default:
+				reply := mdb.Exec(conn, toCmdLine("mget", "x", "y")).(*resp.MultiBulkReply)
+				if string(reply.Args[0]) != string(reply.Args[1]) {
+					t.Errorf("MGET saw a partial MSET: %q", reply.Args)
+				}
+			}
+		})
+
+		llenA := mdb.Exec(conn, toCmdLine("llen", "a")).(*resp.IntReply).IntVal
+		llenB := mdb.Exec(conn, toCmdLine("llen", "b")).(*resp.IntReply).IntVal
+		if llenA+llenB != 200 {
+			t.Errorf("elements lost: %d + %d", llenA, llenB)
+		}
+	})
+
+	t.Run("MOVE between databases", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		runConcurrently(workers, n, func(w, i int) {
+			conn := &MockConnection{dbIndex: w % 2}
+			switch i % 3 {
+			case 0:
+				mdb.Exec(conn, toCmdLine("rpush", "k", "v"))
+			case 1:
+				mdb.Exec(conn, toCmdLine("move", "k", strconv.Itoa(1-w%2)))
+			default:
+				mdb.Exec(conn, toCmdLine("lrange", "k", "0", "-1"))
+			}
+		})
+	})
+
+	t.Run("blocking pops and active expiration", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		db0, _ := mdb.GetDB(0)
+
+		var popped int64
+		var wg sync.WaitGroup
+		for w := 0; w < workers/2; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn := &MockConnection{}
+				for i := 0; i < n; i++ {
+					if _, ok := mdb.Exec(conn, toCmdLine("blpop", "q", "1")).(*resp.MultiBulkReply); ok {
+						atomic.AddInt64(&popped, 1)
+					}
+				}
+			}()
+		}
+		runConcurrently(workers/2, n, func(w, i int) {
+			conn := &MockConnection{}
+			mdb.Exec(conn, toCmdLine("rpush", "q", "v"))
+			mdb.Exec(conn, toCmdLine("set", "tmp", "v", "px", "1"))
+			mdb.Exec(conn, toCmdLine("append", "tmp", "v"))
+			if i%20 == 0 {
+				db0.activeExpire()
+			}
+		})
+		wg.Wait()
+
+		if popped != int64(workers/2*n) {
+			t.Errorf("popped %d, want %d", popped, workers/2*n)
+		}
+	})
+
+	t.Run("AOF follows execution order", func(t *testing.T) {
+		aof := NewMockAOFHandler()
+		mdb := MakeMultiDB(1, aof)
+		runConcurrently(workers, n, func(w, i int) {
+			conn := &MockConnection{}
+			v := fmt.Sprintf("%d-%d", w, i)
+			switch (w + i) % 5 {
+			case 0:
+				mdb.Exec(conn, toCmdLine("rpush", "list", v))
+			case 1:
+				mdb.Exec(conn, toCmdLine("lmove", "list", "other", "left", "right"))
+			case 2:
+				mdb.Exec(conn, toCmdLine("set", "str", v))
+			case 3:
+				mdb.Exec(conn, toCmdLine("append", "str", v))
+			default:
+				mdb.Exec(conn, toCmdLine("sadd", "set", v))
+				mdb.Exec(conn, toCmdLine("spop", "set"))
+			}
+		})
+
+		// 按 AOF 重放得到的数据与实际执行的结果一致
+		replayed := MakeDB(0, NewMockAOFHandler())
+		for _, line := range aof.log {
+			replayed.Exec(connection.NewAOFConnection(0), line)
+		}
+		db, _ := mdb.GetDB(0)
+		for _, cmd := range []string{"lrange list 0 -1", "lrange other 0 -1", "get str", "smembers set"} {
+			args := strings.Fields(cmd)
+			want := string(db.Exec(&MockConnection{}, toCmdLine(args...)).ToBytes())
+			got := string(replayed.Exec(&MockConnection{}, toCmdLine(args...)).ToBytes())
+			if args[0] == "smembers" {
+				want, got = sortedLines(want), sortedLines(got)
+			}
+			if got != want {
+				t.Errorf("%s: replayed %q, want %q", cmd, got, want)
+			}
+		}
+	})
+
+	t.Run("FLUSHDB during writes", func(t *testing.T) {
+		mdb := MakeMultiDB(1, NewMockAOFHandler())
+		runConcurrently(workers, n, func(w, i int) {
+			conn := &MockConnection{}
+			if w == 0 && i%20 == 0 {
+				mdb.Exec(conn, toCmdLine("flushdb"))
+				return
+			}
+			mdb.Exec(conn, toCmdLine("rpush", "list", "v"))
+			mdb.Exec(conn, toCmdLine("hset", "hash", strconv.Itoa(i), "v"))
+		})
+	})
+
+	t.Run("DB.Exec without MultiDB", func(t *testing.T) {
+		db := MakeDB(0, NewMockAOFHandler())
+		runConcurrently(workers, n, func(w, i int) {
+			conn := &MockConnection{}
+			db.Exec(conn, toCmdLine("incr", "n"))
+			db.Exec(conn, toCmdLine("rpush", "l", "v"))
+			db.Exec(conn, toCmdLine("lpop", "l"))
+		})
+		if got := string(getBulkValue(db.Exec(&MockConnection{}, toCmdLine("get", "n")))); got != strconv.Itoa(workers*n) {
+			t.Errorf("n = %s, want %d", got, workers*n)
+		}
+		assertIntReply(t, db.Exec(&MockConnection{}, toCmdLine("llen", "l")), 0)
+	})
+}
+
+// 经过 Translate 执行的过期时间选项，非正数的时间要报错而不是写入一个已经过期的 key
+func TestExpireOptionValidation(t *testing.T) {
+	t.Run("SET", func(t *testing.T) {
+		mdb := MakeMultiDB(1, NewMockAOFHandler())
+		conn := &MockConnection{}
+
+		for _, args := range [][]string{{"ex", "0"}, {"px", "0"}, {"ex", "-1"}, {"exat", "0"}, {"pxat", "-5"}} {
+			line := append([]string{"set", "k", "v"}, args...)
+			if msg := getErrorString(mdb.Exec(conn, toCmdLine(line...))); msg != "ERR invalid expire time in 'set' command" {
+				t.Errorf("%v: unexpected reply %q", line, msg)
+			}
+		}
+		if getBulkValue(mdb.Exec(conn, toCmdLine("get", "k"))) != nil {
+			t.Error("SET with an invalid expire time should not create the key")
+		}
+
+		mdb.Exec(conn, toCmdLine("multi"))
+		mdb.Exec(conn, toCmdLine("set", "k", "v", "ex", "0"))
+		reply := mdb.Exec(conn, toCmdLine("exec"))
+		if got := string(reply.ToBytes()); got != "*1\r\n-ERR invalid expire time in 'set' command\r\n" {
+			t.Errorf("unexpected EXEC reply %q", got)
+		}
+
+		if !isOKReply(mdb.Exec(conn, toCmdLine("set", "k", "v", "px", "100000"))) {
+			t.Fatal("SET with a valid expire time failed")
+		}
+		if pttl := mdb.Exec(conn, toCmdLine("pttl", "k")).(*resp.IntReply).IntVal; pttl <= 90000 || pttl > 100000 {
+			t.Errorf("unexpected PTTL %d", pttl)
+		}
+	})
+	t.Run("GETEX", func(t *testing.T) {
+		mdb := MakeMultiDB(1, NewMockAOFHandler())
+		conn := &MockConnection{}
+		mdb.Exec(conn, toCmdLine("set", "k", "v"))
+
+		for _, args := range [][]string{{"ex", "0"}, {"px", "-10"}, {"exat", "-1"}, {"pxat", "0"}} {
+			line := append([]string{"getex", "k"}, args...)
+			if msg := getErrorString(mdb.Exec(conn, toCmdLine(line...))); msg != "ERR invalid expire time in 'getex' command" {
+				t.Errorf("%v: unexpected reply %q", line, msg)
+			}
+		}
+		if string(getBulkValue(mdb.Exec(conn, toCmdLine("get", "k")))) != "v" {
+			t.Error("GETEX with an invalid expire time should keep the key")
+		}
+
+		if string(getBulkValue(mdb.Exec(conn, toCmdLine("getex", "k", "px", "100000")))) != "v" {
+			t.Fatal("GETEX with a valid expire time failed")
+		}
+		if pttl := mdb.Exec(conn, toCmdLine("pttl", "k")).(*resp.IntReply).IntVal; pttl <= 90000 || pttl > 100000 {
+			t.Errorf("unexpected PTTL %d", pttl)
+		}
+	})
+}
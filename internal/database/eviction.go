@@ -0,0 +1,226 @@
+package database
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+
+	"goredis/internal/resp"
+	"goredis/internal/types"
+)
+
+// maxmemory 淘汰策略，与 Redis 的 maxmemory-policy 一致
+const (
+	PolicyNoEviction     = "noeviction"
+	PolicyAllKeysLRU     = "allkeys-lru"
+	PolicyAllKeysLFU     = "allkeys-lfu"
+	PolicyAllKeysRandom  = "allkeys-random"
+	PolicyVolatileLRU    = "volatile-lru"
+	PolicyVolatileLFU    = "volatile-lfu"
+	PolicyVolatileRandom = "volatile-random"
+	PolicyVolatileTTL    = "volatile-ttl"
+)
+
+// evictionSamples 每个库每轮抽样的 key 数，对应 Redis 的 maxmemory-samples
+const evictionSamples = 5
+
+var evictionPolicies = map[string]struct{}{
+	PolicyNoEviction:     {},
+	PolicyAllKeysLRU:     {},
+	PolicyAllKeysLFU:     {},
+	PolicyAllKeysRandom:  {},
+	PolicyVolatileLRU:    {},
+	PolicyVolatileLFU:    {},
+	PolicyVolatileRandom: {},
+	PolicyVolatileTTL:    {},
+}
+
+// 不会增加内存的写命令，内存超限且无法淘汰时仍然允许执行
+var oomAllowedCmds = map[string]struct{}{
+	"del":        {},
+	"getdel":     {},
+	"getex":      {},
+	"expire":     {},
+	"pexpire":    {},
+	"expireat":   {},
+	"pexpireat":  {},
+	"persist":    {},
+	"rename":     {},
+	"migrate":    {},
+	"lpop":       {},
+	"rpop":       {},
+	"ltrim":      {},
+	"lrem":       {},
+	"lmove":      {},
+	"rpoplpush":  {},
+	"hdel":       {},
+	"srem":       {},
+	"zrem":       {},
+	"xdel":       {},
+	"xtrim":      {},
+	"xack":       {},
+	"xreadgroup": {},
+	"xclaim":     {},
+	"xautoclaim": {},
+	"flushdb":    {},
+	"flushall":   {},
+	"swapdb":     {},
+	"move":       {},
+}
+
+func makeOOMReply() resp.Reply {
+	return resp.MakeErrReply("OOM command not allowed when used memory > 'maxmemory'.")
+}
+
+// denyOOM 命令在内存超限时是否需要拒绝
+func denyOOM(cmdLine [][]byte) bool {
+	if !types.CmdLine(cmdLine).IsWrite() {
+		return false
+	}
+	_, allowed := oomAllowedCmds[strings.ToLower(string(cmdLine[0]))]
+	return !allowed
+}
+
+// txDenyOOM 事务中有可能增加内存的命令时，内存超限就拒绝整个事务
+func txDenyOOM(queue [][][]byte) bool {
+	for _, cmdLine := range queue {
+		if denyOOM(cmdLine) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetMaxMemory 设置内存上限和淘汰策略，maxMemory 为 0 表示不限制，需在开始服务前调用
+func (mdb *MultiDB) SetMaxMemory(maxMemory int64, policy string) error {
+	if policy == "" {
+		policy = PolicyNoEviction
+	}
+	if _, ok := evictionPolicies[policy]; !ok {
+		return fmt.Errorf("invalid maxmemory-policy %q", policy)
+	}
+	if maxMemory < 0 {
+		return fmt.Errorf("invalid maxmemory %d", maxMemory)
+	}
+	mdb.maxMemory = maxMemory
+	mdb.evictionPolicy = policy
+	return nil
+}
+
+// SetIgnoreMaxMemory 为 true 时不主动淘汰。slave 的数据由 master 决定，master 淘汰时会同步 DEL
+func (mdb *MultiDB) SetIgnoreMaxMemory(ignore bool) {
+	var v int32
+	if ignore {
+		v = 1
+	}
+	atomic.StoreInt32(&mdb.ignoreMaxMemory, v)
+}
+
+// UsedMemory 返回所有数据库估算的内存占用
+func (mdb *MultiDB) UsedMemory() int64 {
+	mdb.mu.RLock()
+	defer mdb.mu.RUnlock()
+	return mdb.usedMemory()
+}
+
+// EvictedKeys 返回因 maxmemory 被淘汰的 key 数
+func (mdb *MultiDB) EvictedKeys() int64 {
+	return atomic.LoadInt64(&mdb.evictedKeys)
+}
+
+// usedMemory 调用方需持有 mdb.mu
+func (mdb *MultiDB) usedMemory() int64 {
+	var used int64
+	for _, db := range mdb.dbSet {
+		used += db.UsedMemory()
+	}
+	return used
+}
+
+// freeMemoryIfNeeded 内存超过 maxmemory 时按策略抽样淘汰 key，直到回到限制以内。
+// 无法释放足够的内存时返回 false。调用方需持有 mdb.mu
+func (mdb *MultiDB) freeMemoryIfNeeded() bool {
+	if mdb.maxMemory <= 0 || atomic.LoadInt32(&mdb.ignoreMaxMemory) == 1 {
+		return true
+	}
+	for mdb.usedMemory() > mdb.maxMemory {
+		if mdb.evictionPolicy == PolicyNoEviction {
+			return false
+		}
+		db, key, ok := mdb.evictionCandidate()
+		if !ok {
+			return false
+		}
+		mdb.evictKey(db, key)
+	}
+	return true
+}
+
+// evictionCandidate 从每个库中抽样，返回按策略最应该淘汰的 key
+func (mdb *MultiDB) evictionCandidate() (*DB, string, bool) {
+	volatile := mdb.evictionPolicy == PolicyVolatileLRU || mdb.evictionPolicy == PolicyVolatileLFU ||
+		mdb.evictionPolicy == PolicyVolatileRandom || mdb.evictionPolicy == PolicyVolatileTTL
+
+	var (
+		bestDB    *DB
+		bestKey   string
+		bestScore float64
+	)
+	for _, db := range mdb.dbSet {
+		// volatile 策略只从设置了过期时间的 key 中挑选
+		var keys []string
+		if volatile {
+			keys = db.ttlMap.RandomKeys(evictionSamples)
+		} else {
+			keys = db.data.RandomKeys(evictionSamples)
+		}
+
+		for _, key := range keys {
+			// 直接读 dict，抽样不算访问
+			raw, ok := db.data.Get(key)
+			if !ok {
+				continue
+			}
+			score, ok := mdb.evictionScore(db, key, raw.(*types.DataEntity))
+			if ok && (bestDB == nil || score > bestScore) {
+				bestDB, bestKey, bestScore = db, key, score
+			}
+		}
+	}
+	return bestDB, bestKey, bestDB != nil
+}
+
+// evictionScore 分数越大越应该被淘汰
+func (mdb *MultiDB) evictionScore(db *DB, key string, entity *types.DataEntity) (float64, bool) {
+	switch mdb.evictionPolicy {
+	case PolicyAllKeysLRU, PolicyVolatileLRU:
+		return entity.IdleTime().Seconds(), true
+	case PolicyAllKeysLFU, PolicyVolatileLFU:
+		return float64(255 - int(entity.LFUCount())), true
+	case PolicyVolatileTTL:
+		// 越早过期越先淘汰
+		expireAt, ok := db.GetExpireTime(key)
+		if !ok {
+			return 0, false
+		}
+		return -float64(expireAt.UnixMilli()), true
+	default:
+		return rand.Float64(), true
+	}
+}
+
+// evictKey 淘汰一个 key，并以 DEL 写入 AOF 和复制流
+func (mdb *MultiDB) evictKey(db *DB, key string) {
+	// 调用方不持有 key 锁，淘汰和执行中的命令互斥
+	db.locks.Lock(key)
+	defer db.locks.Unlock(key)
+
+	// 并发淘汰时可能选中同一个 key，只有真正删除的一方传播 DEL
+	if !db.Remove(key) {
+		return
+	}
+	db.addVersion(key)
+	atomic.AddInt64(&mdb.evictedKeys, 1)
+	mdb.aofHandler.AddAOF(db.index, [][]byte{[]byte("del"), []byte(key)})
+}
@@ -0,0 +1,219 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func aofContains(aof *MockAOFHandler, line string) bool {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	for _, cmd := range aof.log {
+		args := make([]string, len(cmd))
+		for i, arg := range cmd {
+			args[i] = string(arg)
+		}
+		if strings.Join(args, " ") == line {
+			return true
+		}
+	}
+	return false
+}
+
+func exists(mdb *MultiDB, conn *MockConnection, key string) bool {
+	return getBulkValue(mdb.Exec(conn, toCmdLine("get", key))) != nil
+}
+
+func TestMemoryAccounting(t *testing.T) {
+	mdb := MakeMultiDB(4, NewMockAOFHandler())
+	conn := &MockConnection{}
+
+	mdb.Exec(conn, toCmdLine("set", "k", "value"))
+	afterSet := mdb.UsedMemory()
+	if afterSet <= 0 {
+		t.Fatalf("used memory should grow after SET, got %d", afterSet)
+	}
+
+	mdb.Exec(conn, toCmdLine("rpush", "l", "a", "b", "c"))
+	afterPush := mdb.UsedMemory()
+	mdb.Exec(conn, toCmdLine("rpush", "l", strings.Repeat("x", 1000)))
+	if grown := mdb.UsedMemory() - afterPush; grown < 1000 {
+		t.Errorf("in-place modification should be accounted, grew %d", grown)
+	}
+
+	mdb.Exec(conn, toCmdLine("set", "k", strings.Repeat("v", 100)))
+	mdb.Exec(conn, toCmdLine("del", "l"))
+	if used := mdb.UsedMemory(); used <= afterSet || used >= afterPush {
+		t.Errorf("overwrite and DEL should be accounted, got %d", used)
+	}
+
+	mdb.Exec(conn, toCmdLine("select", "1"))
+	mdb.Exec(conn, toCmdLine("set", "k", "v"))
+	mdb.Exec(conn, toCmdLine("flushall"))
+	if used := mdb.UsedMemory(); used != 0 {
+		t.Errorf("used memory should be 0 after FLUSHALL, got %d", used)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	// setup 写入 keys 后让内存刚好超过 maxmemory，下一条写命令执行前需要先淘汰
+	setup := func(t *testing.T, policy string, keys ...string) (*MultiDB, *MockAOFHandler, *MockConnection) {
+		aof := NewMockAOFHandler()
+		mdb := MakeMultiDB(4, aof)
+		conn := &MockConnection{}
+		for _, key := range keys {
+			mdb.Exec(conn, toCmdLine("set", key, "value"))
+			time.Sleep(5 * time.Millisecond)
+		}
+		if err := mdb.SetMaxMemory(mdb.UsedMemory()-1, policy); err != nil {
+			t.Fatalf("SetMaxMemory failed: %v", err)
+		}
+		return mdb, aof, conn
+	}
+
+	t.Run("invalid policy", func(t *testing.T) {
+		mdb := MakeMultiDB(1, NewMockAOFHandler())
+		if err := mdb.SetMaxMemory(100, "allkeys-foo"); err == nil {
+			t.Error("expected error for unknown policy")
+		}
+	})
+
+	t.Run("noeviction rejects writes", func(t *testing.T) {
+		mdb, _, conn := setup(t, PolicyNoEviction, "a", "b")
+
+		msg := getErrorString(mdb.Exec(conn, toCmdLine("set", "c", "value")))
+		if !strings.HasPrefix(msg, "OOM ") {
+			t.Fatalf("expected OOM error, got %q", msg)
+		}
+		if !exists(mdb, conn, "a") {
+			t.Error("reads should still work")
+		}
+		// 超过上限时仍可以删除
+		assertIntReply(t, mdb.Exec(conn, toCmdLine("del", "a")), 1)
+		if reply := mdb.Exec(conn, toCmdLine("set", "c", "value")); !isOKReply(reply) {
+			t.Errorf("SET should succeed after freeing memory: %s", getErrorString(reply))
+		}
+	})
+
+	t.Run("allkeys-lru evicts least recently used", func(t *testing.T) {
+		mdb, aof, conn := setup(t, PolicyAllKeysLRU, "a", "b", "c")
+		// a 最早写入，但最近被访问过
+		mdb.Exec(conn, toCmdLine("get", "a"))
+
+		if reply := mdb.Exec(conn, toCmdLine("set", "d", "value")); !isOKReply(reply) {
+			t.Fatalf("SET should evict instead of failing: %s", getErrorString(reply))
+		}
+		if exists(mdb, conn, "b") || !exists(mdb, conn, "a") {
+			t.Error("b should be evicted")
+		}
+		if !aofContains(aof, "del b") {
+			t.Error("eviction should be propagated as DEL")
+		}
+		if mdb.EvictedKeys() != 1 {
+			t.Errorf("expected 1 evicted key, got %d", mdb.EvictedKeys())
+		}
+	})
+
+	t.Run("allkeys-lfu evicts least frequently used", func(t *testing.T) {
+		mdb, _, conn := setup(t, PolicyAllKeysLFU, "a", "b")
+		// 前几次访问必定增加计数
+		for i := 0; i < 5; i++ {
+			mdb.Exec(conn, toCmdLine("get", "a"))
+		}
+
+		mdb.Exec(conn, toCmdLine("set", "c", "value"))
+		if exists(mdb, conn, "b") || !exists(mdb, conn, "a") {
+			t.Error("b should be evicted")
+		}
+	})
+
+	t.Run("volatile-ttl evicts the key expiring first", func(t *testing.T) {
+		mdb, _, conn := setup(t, PolicyNoEviction, "persistent", "later", "sooner")
+		mdb.Exec(conn, toCmdLine("expire", "later", "1000"))
+		mdb.Exec(conn, toCmdLine("expire", "sooner", "100"))
+		mdb.SetMaxMemory(mdb.UsedMemory()-1, PolicyVolatileTTL)
+
+		mdb.Exec(conn, toCmdLine("set", "new", "value"))
+		if exists(mdb, conn, "sooner") || !exists(mdb, conn, "later") || !exists(mdb, conn, "persistent") {
+			t.Error("sooner should be evicted")
+		}
+	})
+
+	t.Run("volatile policy without volatile keys", func(t *testing.T) {
+		mdb, _, conn := setup(t, PolicyVolatileLRU, "a")
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("set", "b", "value"))); !strings.HasPrefix(msg, "OOM ") {
+			t.Errorf("expected OOM error, got %q", msg)
+		}
+		if !exists(mdb, conn, "a") {
+			t.Error("keys without TTL should not be evicted")
+		}
+	})
+
+	t.Run("evicts across databases", func(t *testing.T) {
+		mdb, aof, conn := setup(t, PolicyAllKeysRandom, "a")
+		mdb.Exec(conn, toCmdLine("select", "2"))
+
+		mdb.Exec(conn, toCmdLine("set", "b", "value"))
+		mdb.Exec(conn, toCmdLine("select", "0"))
+		if exists(mdb, conn, "a") {
+			t.Error("key in db0 should be evicted")
+		}
+		aof.mu.Lock()
+		defer aof.mu.Unlock()
+		for i, cmd := range aof.log {
+			if string(cmd[0]) == "del" && (string(cmd[1]) != "a" || aof.dbIndexes[i] != 0) {
+				t.Errorf("unexpected eviction of %s in db%d", cmd[1], aof.dbIndexes[i])
+			}
+		}
+	})
+
+	t.Run("slave ignores maxmemory", func(t *testing.T) {
+		mdb, _, conn := setup(t, PolicyAllKeysLRU, "a")
+		mdb.SetIgnoreMaxMemory(true)
+
+		mdb.Exec(conn, toCmdLine("set", "b", "value"))
+		if !exists(mdb, conn, "a") || !exists(mdb, conn, "b") {
+			t.Error("slave should not evict")
+		}
+	})
+
+	t.Run("EXEC is rejected when memory cannot be freed", func(t *testing.T) {
+		mdb, aof, conn := setup(t, PolicyNoEviction, "a")
+		mdb.Exec(conn, toCmdLine("multi"))
+		mdb.Exec(conn, toCmdLine("set", "b", "value"))
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("exec"))); !strings.HasPrefix(msg, "OOM ") {
+			t.Errorf("expected OOM error, got %q", msg)
+		}
+		if exists(mdb, conn, "b") || aofContains(aof, "multi") {
+			t.Error("rejected transaction should not be executed")
+		}
+	})
+
+	t.Run("EVAL is rejected when memory cannot be freed", func(t *testing.T) {
+		mdb, aof, conn := setup(t, PolicyNoEviction, "a")
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("eval", "redis.call('set', 'b', 'value')", "0"))); !strings.HasPrefix(msg, "OOM ") {
+			t.Errorf("expected OOM error, got %q", msg)
+		}
+		if exists(mdb, conn, "b") || aofContains(aof, "set b value") {
+			t.Error("rejected script should not be executed")
+		}
+	})
+
+	t.Run("EVAL evicts before the script, not during it", func(t *testing.T) {
+		mdb, aof, conn := setup(t, PolicyAllKeysLRU, "a")
+		mdb.SetLuaReplicateCommands(false)
+
+		body := "redis.call('set', 'k1', string.rep('y', 400)) redis.call('set', 'k2', 'z')"
+		if reply := mdb.Exec(conn, toCmdLine("eval", body, "0")); getErrorString(reply) != "" {
+			t.Fatalf("EVAL failed: %s", getErrorString(reply))
+		}
+		// 脚本不会淘汰自己写入的 key，原样复制的 EVAL 重新执行后与这里一致
+		if !exists(mdb, conn, "k1") || !exists(mdb, conn, "k2") || exists(mdb, conn, "a") {
+			t.Error("only keys written before the script should be evicted")
+		}
+		if got := aofLines(aof); len(got) != 3 || got[1] != "del a" || got[2] != "eval "+body+" 0" {
+			t.Errorf("unexpected AOF %q", got)
+		}
+	})
+}
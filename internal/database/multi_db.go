@@ -0,0 +1,500 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goredis/internal/persistant"
+	"goredis/internal/resp"
+	"goredis/internal/script"
+	"goredis/internal/types"
+	"goredis/pkg/connection"
+)
+
+const defaultDBNum = 16
+
+// 由 MultiDB 处理的跨库命令、阻塞命令及其参数个数
+var multiDBCmdArity = map[string]int{
+	"select":     2,  // select index
+	"swapdb":     3,  // swapdb index1 index2
+	"flushall":   -1, // flushall [ASYNC|SYNC]
+	"move":       3,  // move key db
+	"save":       1,  // save
+	"bgsave":     -1, // bgsave [SCHEDULE]
+	"lastsave":   1,  // lastsave
+	"blpop":      -3, // blpop key [key ...] timeout
+	"brpop":      -3, // brpop key [key ...] timeout
+	"blmove":     6,  // blmove source destination LEFT|RIGHT LEFT|RIGHT timeout
+	"brpoplpush": 4,  // brpoplpush source destination timeout
+	"migrate":    -6, // migrate host port key|"" destination-db timeout [COPY] [REPLACE] [KEYS key [key ...]]
+}
+
+// MultiDB 管理服务器上的全部逻辑数据库 (db0 ~ dbN-1)，
+// 负责 SELECT/SWAPDB/MOVE/FLUSHALL 这类跨库命令以及 MULTI/EXEC 事务，其余命令路由到连接当前选中的 DB
+type MultiDB struct {
+	// 普通命令持有读锁；SWAPDB/FLUSHALL/EXEC/EVAL 持有写锁，保证执行期间不会穿插其他客户端的命令
+	mu    sync.RWMutex
+	dbSet []*DB
+
+	aofHandler persistant.AOFHandlerInterface
+	rdbHandler *persistant.RDBHandler // 为 nil 时不支持快照
+	// BGSAVE SCHEDULE 推迟的保存
+	bgsaveScheduled int32
+
+	// maxmemory 为 0 表示不限制内存，超过时按 evictionPolicy 淘汰，见 eviction.go
+	maxMemory       int64
+	evictionPolicy  string
+	ignoreMaxMemory int32
+	evictedKeys     int64
+
+	// Lua 脚本缓存和虚拟机，脚本持有写锁执行，见 script.go
+	scripts       *script.Engine
+	scriptEffects bool
+}
+
+func MakeMultiDB(dbNum int, aofHandler persistant.AOFHandlerInterface) *MultiDB {
+	if dbNum <= 0 {
+		dbNum = defaultDBNum
+	}
+
+	mdb := &MultiDB{
+		dbSet:          make([]*DB, dbNum),
+		aofHandler:     aofHandler,
+		evictionPolicy: PolicyNoEviction,
+		scripts:        script.NewEngine(),
+		scriptEffects:  true,
+	}
+	for i := range mdb.dbSet {
+		mdb.dbSet[i] = MakeDB(i, aofHandler)
+	}
+
+	// 所有数据库共用一个 AOF，靠其中的 SELECT 区分
+	if aofHandler.HasData() {
+		if err := mdb.LoadAOF(); err != nil {
+			panic(err)
+		}
+	}
+
+	mdb.startAOFRewriteChecker()
+	return mdb
+}
+
+func (mdb *MultiDB) LoadAOF() error {
+	// FakeConn，避免再次写 AOF；SELECT 会切换它的 dbIndex
+	conn := connection.NewAOFConnection(0)
+	return mdb.aofHandler.Load(mdb.loadTarget, func(cmd types.CmdLine) {
+		mdb.Exec(conn, cmd)
+	})
+}
+
+// loadTarget 返回加载快照时编号对应的数据库
+func (mdb *MultiDB) loadTarget(index int) (types.Database, bool) {
+	db, errReply := mdb.selectDB(index)
+	return db, errReply == nil
+}
+
+// SetRDBHandler 开启快照持久化，并按 save 配置定期检查是否需要自动 BGSAVE
+func (mdb *MultiDB) SetRDBHandler(rdb *persistant.RDBHandler) {
+	mdb.rdbHandler = rdb
+	mdb.startSaveChecker()
+}
+
+// LoadRDB 从快照恢复数据和函数库，调用方需保证此时没有其他客户端
+func (mdb *MultiDB) LoadRDB() error {
+	return mdb.rdbHandler.Load(mdb.loadTarget, func(code []byte) error {
+		if _, errReply := mdb.scripts.LoadLibrary(code, true); errReply != nil {
+			return fmt.Errorf("%s", strings.TrimSpace(string(errReply.ToBytes()[1:])))
+		}
+		return nil
+	})
+}
+
+// Exec 执行一条命令，跨库命令和事务在这里处理，其余交给连接当前选中的 DB
+func (mdb *MultiDB) Exec(c connection.Connection, cmdLine [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+
+	// 执行过写命令的脚本超时后仍在执行，只能等它结束，其他客户端不等待锁而是立即回复 BUSY
+	if !isAOFConn(c) && mdb.scripts.Busy() && !isKillCmd(cmdLine) {
+		return resp.MakeErrReply("BUSY Redis is busy running a script. You can only call SCRIPT KILL or SHUTDOWN NOSAVE.")
+	}
+
+	switch cmdName {
+	case "multi":
+		return execMulti(c, cmdLine)
+	case "exec":
+		return mdb.execExec(c, cmdLine)
+	case "discard":
+		return execDiscard(c, cmdLine)
+	case "watch":
+		return mdb.execWatch(c, cmdLine)
+	case "unwatch":
+		return execUnwatch(c, cmdLine)
+	}
+
+	if c.InMultiState() {
+		return enqueueCmd(c, cmdLine)
+	}
+
+	// EVAL/FCALL 持有写锁执行；SCRIPT 和只读的 FUNCTION 子命令不加锁，SCRIPT KILL 才能在脚本执行期间调用
+	switch cmdName {
+	case "script":
+		return mdb.execScript(cmdLine)
+	case "function":
+		return mdb.execFunction(c, cmdLine)
+	case "eval", "evalsha", "fcall", "fcall_ro":
+		return mdb.execEval(c, cmdLine)
+	}
+
+	// 阻塞命令等待期间不能持有锁
+	if isBlockingCmd(cmdLine) {
+		if arity, ok := multiDBCmdArity[cmdName]; ok && !validateArity(arity, cmdLine) {
+			return resp.MakeArgNumErrReply(cmdName)
+		}
+		return mdb.execBlocking(c, cmdLine)
+	}
+
+	cmdLine = translateCmd(cmdLine)
+
+	// SWAPDB/FLUSHALL 需要独占所有数据库，SAVE/BGSAVE 需要一致的快照
+	if cmdName == "swapdb" || cmdName == "flushall" || cmdName == "save" || cmdName == "bgsave" {
+		mdb.mu.Lock()
+		defer mdb.mu.Unlock()
+	} else {
+		mdb.mu.RLock()
+		defer mdb.mu.RUnlock()
+	}
+
+	// 写命令执行前先腾出内存，加载 AOF 时不淘汰
+	if !isAOFConn(c) && types.CmdLine(cmdLine).IsWrite() && !mdb.freeMemoryIfNeeded() && denyOOM(cmdLine) {
+		return makeOOMReply()
+	}
+
+	// SELECT 等读命令不写 AOF，切库由 AOFHandler 自动补 SELECT。
+	// 持有 key 锁写入 AOF，同一个 key 上的修改按执行顺序传播；唤醒阻塞的客户端时会再次加锁，需要先释放
+	dbIndex := c.GetDBIndex()
+	unlock := mdb.lockCmd(c, cmdLine)
+	reply, cmdLines := mdb.execCmd(c, cmdLine)
+	mdb.commitWrite(c, dbIndex, cmdLines)
+	unlock()
+	mdb.signalWritten(dbIndex, cmdLines)
+	return reply
+}
+
+// afterWrite 写入 AOF，累计修改次数并唤醒在写入的 key 上阻塞的客户端，调用方需持有 mdb.mu
+func (mdb *MultiDB) afterWrite(c connection.Connection, dbIndex int, cmdLines [][][]byte) {
+	mdb.commitWrite(c, dbIndex, cmdLines)
+	mdb.signalWritten(dbIndex, cmdLines)
+}
+
+// commitWrite 写入 AOF 和复制流并累计修改次数
+func (mdb *MultiDB) commitWrite(c connection.Connection, dbIndex int, cmdLines [][][]byte) {
+	if len(cmdLines) == 0 {
+		return
+	}
+	if !isAOFConn(c) {
+		mdb.propagate(dbIndex, cmdLines)
+	}
+	mdb.addDirty(int64(len(cmdLines)))
+}
+
+// signalWritten 唤醒在写入的 key 上阻塞的客户端，调用方不能持有 key 锁
+func (mdb *MultiDB) signalWritten(dbIndex int, cmdLines [][][]byte) {
+	for _, line := range cmdLines {
+		mdb.signalKeysReady(dbIndex, line)
+	}
+}
+
+// lockCmd 为 execCmd 执行的命令加 key 锁，返回解锁函数，调用方需持有 mdb.mu。
+// MOVE 按库的编号依次锁住源库和目标库中的 key，MIGRATE 锁住要迁移的 key；SELECT 等其他跨库命令不涉及 key
+func (mdb *MultiDB) lockCmd(c connection.Connection, cmdLine [][]byte) func() {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	switch cmdName {
+	case "move":
+		return mdb.lockMove(c, cmdLine)
+	case "migrate":
+		return mdb.lockMigrate(c, cmdLine)
+	}
+	if _, ok := multiDBCmdArity[cmdName]; ok {
+		return func() {}
+	}
+	db, errReply := mdb.selectDB(c.GetDBIndex())
+	if errReply != nil {
+		return func() {}
+	}
+	return db.lockCmd(cmdLine)
+}
+
+func (mdb *MultiDB) lockMove(c connection.Connection, cmdLine [][]byte) func() {
+	if !validateArity(multiDBCmdArity["move"], cmdLine) {
+		return func() {}
+	}
+	srcIndex := c.GetDBIndex()
+	dstIndex, errReply := mdb.parseDBIndex(cmdLine[2])
+	if errReply != nil || dstIndex == srcIndex {
+		return func() {}
+	}
+	srcDB, errReply := mdb.selectDB(srcIndex)
+	if errReply != nil {
+		return func() {}
+	}
+
+	key := string(cmdLine[1])
+	first, second := srcDB, mdb.dbSet[dstIndex]
+	if dstIndex < srcIndex {
+		first, second = second, first
+	}
+	first.locks.Lock(key)
+	second.locks.Lock(key)
+	return func() {
+		second.locks.Unlock(key)
+		first.locks.Unlock(key)
+	}
+}
+
+// propagate 写入 AOF 和复制流，一条命令改写成的多条命令作为一个事务写入，保证原子性
+func (mdb *MultiDB) propagate(dbIndex int, cmdLines [][][]byte) {
+	if len(cmdLines) == 1 {
+		mdb.aofHandler.AddAOF(dbIndex, cmdLines[0])
+		return
+	}
+	writes := make([]persistant.TxCmd, 0, len(cmdLines))
+	for _, line := range cmdLines {
+		writes = append(writes, persistant.TxCmd{DBIndex: dbIndex, CmdLine: line})
+	}
+	mdb.aofHandler.AddTransaction(writes)
+}
+
+// GetDB 返回指定编号的数据库
+func (mdb *MultiDB) GetDB(index int) (*DB, bool) {
+	mdb.mu.RLock()
+	defer mdb.mu.RUnlock()
+
+	db, errReply := mdb.selectDB(index)
+	return db, errReply == nil
+}
+
+func (mdb *MultiDB) DBNum() int {
+	return len(mdb.dbSet)
+}
+
+// Clear 清空所有数据库和函数库，不写 AOF（用于全量同步前重置本地状态）
+func (mdb *MultiDB) Clear() {
+	mdb.mu.Lock()
+	defer mdb.mu.Unlock()
+
+	mdb.flushAll()
+	mdb.scripts.FlushFunctions()
+}
+
+// execCmd 执行一条非事务命令，不写 AOF，返回回复和需要传播的命令，调用方需持有 mdb.mu
+func (mdb *MultiDB) execCmd(c connection.Connection, cmdLine [][]byte) (resp.Reply, [][][]byte) {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+
+	if arity, ok := multiDBCmdArity[cmdName]; ok {
+		if !validateArity(arity, cmdLine) {
+			return resp.MakeArgNumErrReply(cmdName), nil
+		}
+		if isBlockingCmd(cmdLine) {
+			return mdb.popNow(c, cmdLine)
+		}
+		return propagation(cmdLine, mdb.execMultiDBCmd(c, cmdLine))
+	}
+
+	db, errReply := mdb.selectDB(c.GetDBIndex())
+	if errReply != nil {
+		return errReply, nil
+	}
+	return db.execCommand(cmdLine)
+}
+
+// execMultiDBCmd 执行由 MultiDB 处理的命令，参数个数已校验
+func (mdb *MultiDB) execMultiDBCmd(c connection.Connection, cmdLine [][]byte) resp.Reply {
+	switch strings.ToLower(string(cmdLine[0])) {
+	case "select":
+		return mdb.execSelect(c, cmdLine)
+	case "swapdb":
+		return mdb.execSwapDB(cmdLine)
+	case "flushall":
+		mdb.flushAll()
+		return resp.MakeOkReply()
+	case "move":
+		return mdb.execMove(c, cmdLine)
+	case "save":
+		return mdb.execSave()
+	case "bgsave":
+		return mdb.execBGSave(cmdLine)
+	case "migrate":
+		return mdb.execMigrate(c, cmdLine)
+	default: // lastsave
+		return mdb.execLastSave()
+	}
+}
+
+// selectDB 调用方需持有 mdb.mu
+func (mdb *MultiDB) selectDB(index int) (*DB, resp.Reply) {
+	if index < 0 || index >= len(mdb.dbSet) {
+		return nil, resp.MakeErrReply("ERR DB index is out of range")
+	}
+	return mdb.dbSet[index], nil
+}
+
+// SELECT index
+func (mdb *MultiDB) execSelect(c connection.Connection, cmdLine [][]byte) resp.Reply {
+	index, errReply := mdb.parseDBIndex(cmdLine[1])
+	if errReply != nil {
+		return errReply
+	}
+
+	c.SelectDB(index)
+	return resp.MakeOkReply()
+}
+
+// SWAPDB index1 index2，调用方需持有写锁
+func (mdb *MultiDB) execSwapDB(cmdLine [][]byte) resp.Reply {
+	idx1, errReply := mdb.parseDBIndex(cmdLine[1])
+	if errReply != nil {
+		return errReply
+	}
+	idx2, errReply := mdb.parseDBIndex(cmdLine[2])
+	if errReply != nil {
+		return errReply
+	}
+
+	db1, db2 := mdb.dbSet[idx1], mdb.dbSet[idx2]
+	mdb.dbSet[idx1], mdb.dbSet[idx2] = db2, db1
+	db1.index, db2.index = idx2, idx1
+
+	// WATCH 是按库编号记录的，交换后两个库里的 key 都视为被修改
+	db1.touchAll()
+	db2.touchAll()
+	return resp.MakeOkReply()
+}
+
+// flushAll 调用方需持有写锁
+func (mdb *MultiDB) flushAll() {
+	for _, db := range mdb.dbSet {
+		db.Clear()
+	}
+}
+
+// MOVE key db
+func (mdb *MultiDB) execMove(c connection.Connection, cmdLine [][]byte) resp.Reply {
+	key := string(cmdLine[1])
+	dstIndex, errReply := mdb.parseDBIndex(cmdLine[2])
+	if errReply != nil {
+		return errReply
+	}
+	if dstIndex == c.GetDBIndex() {
+		return resp.MakeErrReply("ERR source and destination objects are the same")
+	}
+
+	srcDB, errReply := mdb.selectDB(c.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	dstDB := mdb.dbSet[dstIndex]
+
+	entity, exists := srcDB.GetEntity(key)
+	if !exists {
+		return resp.MakeIntReply(0)
+	}
+	if _, exists := dstDB.GetEntity(key); exists {
+		return resp.MakeIntReply(0)
+	}
+
+	expireAt, hasTTL := srcDB.GetExpireTime(key)
+	srcDB.Remove(key)
+	dstDB.PutEntity(key, entity)
+	if hasTTL {
+		dstDB.SetExpire(key, expireAt)
+	}
+	srcDB.addVersion(key)
+	dstDB.addVersion(key)
+
+	return resp.MakeIntReply(1)
+}
+
+func (mdb *MultiDB) parseDBIndex(arg []byte) (int, resp.Reply) {
+	index, err := strconv.Atoi(string(arg))
+	if err != nil {
+		return 0, resp.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if index < 0 || index >= len(mdb.dbSet) {
+		return 0, resp.MakeErrReply("ERR DB index is out of range")
+	}
+	return index, nil
+}
+
+// RewriteAOF 用当前数据重写 AOF。持有写锁切换 incr 文件并克隆数据，
+// 保证快照包含之前的所有写命令，之后的写命令只出现在新的 incr 文件中
+func (mdb *MultiDB) RewriteAOF() error {
+	mdb.mu.Lock()
+	if err := mdb.aofHandler.StartRewrite(); err != nil {
+		mdb.mu.Unlock()
+		return err
+	}
+	dbs, functions := mdb.cloneDBs(), mdb.scripts.LibraryCodes()
+	mdb.mu.Unlock()
+
+	return mdb.aofHandler.FinishRewrite(dbs, functions)
+}
+
+// cloneDBs 调用方需持有 mdb.mu
+func (mdb *MultiDB) cloneDBs() []types.Database {
+	dbs := make([]types.Database, len(mdb.dbSet))
+	for i, db := range mdb.dbSet {
+		dbs[i] = db.Clone()
+	}
+	return dbs
+}
+
+func (mdb *MultiDB) startAOFRewriteChecker() {
+	go func() {
+		ticker := time.NewTicker(aofCheckInterval)
+		defer ticker.Stop()
+
+		var lastRewriteSize int64
+
+		for range ticker.C {
+			aof := mdb.aofHandler
+			if aof == nil {
+				continue
+			}
+
+			size, err := aof.LogSize()
+			if err != nil {
+				continue
+			}
+
+			// 小于最小 rewrite 大小，不处理
+			if size < aofRewriteMinSize {
+				continue
+			}
+
+			// 首次记录基线
+			if lastRewriteSize == 0 {
+				lastRewriteSize = size
+				continue
+			}
+
+			// 判断增长比例
+			growth := (size - lastRewriteSize) * 100 / lastRewriteSize
+			if growth < aofRewritePercentage {
+				continue
+			}
+
+			// 触发 rewrite，之后以重写后的大小为基线
+			if err := mdb.RewriteAOF(); err != nil {
+				log.Printf("[aof] rewrite failed: %v", err)
+				continue
+			}
+			if size, err := aof.LogSize(); err == nil {
+				lastRewriteSize = size
+			}
+		}
+	}()
+}
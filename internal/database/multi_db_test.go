@@ -0,0 +1,233 @@
+package database
+
+import (
+	"goredis/internal/resp"
+	"goredis/internal/types"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func toCmdLine(args ...string) [][]byte {
+	cmdLine := make([][]byte, len(args))
+	for i, arg := range args {
+		cmdLine[i] = []byte(arg)
+	}
+	return cmdLine
+}
+
+func toCmdLines(lines ...string) []types.CmdLine {
+	cmdLines := make([]types.CmdLine, len(lines))
+	for i, line := range lines {
+		cmdLines[i] = toCmdLine(strings.Fields(line)...)
+	}
+	return cmdLines
+}
+
+func TestMultiDB(t *testing.T) {
+	t.Run("SELECT isolates databases", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		conn := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("set", "k", "db0"))
+		if reply := mdb.Exec(conn, toCmdLine("select", "2")); !isOKReply(reply) {
+			t.Fatalf("SELECT failed: %s", getErrorString(reply))
+		}
+		if conn.GetDBIndex() != 2 {
+			t.Fatalf("expected db index 2, got %d", conn.GetDBIndex())
+		}
+		if getBulkValue(mdb.Exec(conn, toCmdLine("get", "k"))) != nil {
+			t.Error("db2 should not see key of db0")
+		}
+
+		mdb.Exec(conn, toCmdLine("set", "k", "db2"))
+		mdb.Exec(conn, toCmdLine("select", "0"))
+		if got := string(getBulkValue(mdb.Exec(conn, toCmdLine("get", "k")))); got != "db0" {
+			t.Errorf("expected db0, got %q", got)
+		}
+	})
+
+	t.Run("SELECT invalid index", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		conn := &MockConnection{}
+
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("select", "4"))); msg != "ERR DB index is out of range" {
+			t.Errorf("unexpected error: %q", msg)
+		}
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("select", "abc"))); msg != "ERR value is not an integer or out of range" {
+			t.Errorf("unexpected error: %q", msg)
+		}
+		if conn.GetDBIndex() != 0 {
+			t.Error("failed SELECT should not change db index")
+		}
+	})
+
+	t.Run("SWAPDB", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		conn := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("set", "k", "v0"))
+		if reply := mdb.Exec(conn, toCmdLine("swapdb", "0", "1")); !isOKReply(reply) {
+			t.Fatalf("SWAPDB failed: %s", getErrorString(reply))
+		}
+
+		if getBulkValue(mdb.Exec(conn, toCmdLine("get", "k"))) != nil {
+			t.Error("db0 should be empty after swap")
+		}
+		mdb.Exec(conn, toCmdLine("select", "1"))
+		if got := string(getBulkValue(mdb.Exec(conn, toCmdLine("get", "k")))); got != "v0" {
+			t.Errorf("expected v0 in db1, got %q", got)
+		}
+
+		db1, _ := mdb.GetDB(1)
+		if db1.GetDBIndex() != 1 {
+			t.Errorf("swapped db should take the new index, got %d", db1.GetDBIndex())
+		}
+	})
+
+	t.Run("MOVE", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		conn := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("set", "k", "v"))
+		db0, _ := mdb.GetDB(0)
+		db0.SetExpire("k", time.Now().Add(time.Hour))
+
+		assertIntReply(t, mdb.Exec(conn, toCmdLine("move", "k", "3")), 1)
+		assertIntReply(t, mdb.Exec(conn, toCmdLine("move", "missing", "3")), 0)
+
+		db3, _ := mdb.GetDB(3)
+		if _, ok := db3.GetEntity("k"); !ok {
+			t.Fatal("key should be moved to db3")
+		}
+		if _, ok := db3.GetExpireTime("k"); !ok {
+			t.Error("TTL should be moved together with the key")
+		}
+		if _, ok := db0.GetEntity("k"); ok {
+			t.Error("key should be removed from db0")
+		}
+
+		// 目标库已存在同名 key 时不移动
+		mdb.Exec(conn, toCmdLine("set", "k", "again"))
+		assertIntReply(t, mdb.Exec(conn, toCmdLine("move", "k", "3")), 0)
+
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("move", "k", "0"))); msg != "ERR source and destination objects are the same" {
+			t.Errorf("unexpected error: %q", msg)
+		}
+	})
+
+	t.Run("FLUSHDB and FLUSHALL", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		conn := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("set", "a", "1"))
+		mdb.Exec(conn, toCmdLine("select", "1"))
+		mdb.Exec(conn, toCmdLine("set", "b", "1"))
+
+		mdb.Exec(conn, toCmdLine("flushdb"))
+		db0, _ := mdb.GetDB(0)
+		db1, _ := mdb.GetDB(1)
+		if _, ok := db1.GetEntity("b"); ok {
+			t.Error("FLUSHDB should clear current db")
+		}
+		if _, ok := db0.GetEntity("a"); !ok {
+			t.Error("FLUSHDB should not touch other db")
+		}
+
+		mdb.Exec(conn, toCmdLine("flushall"))
+		if _, ok := db0.GetEntity("a"); ok {
+			t.Error("FLUSHALL should clear all db")
+		}
+	})
+
+	t.Run("AOF records db index and replays SELECT", func(t *testing.T) {
+		aof := NewMockAOFHandler()
+		mdb := MakeMultiDB(4, aof)
+		conn := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("select", "2"))
+		mdb.Exec(conn, toCmdLine("set", "k", "v"))
+
+		if len(aof.log) != 1 {
+			t.Fatalf("SELECT should not be logged directly, got %d entries", len(aof.log))
+		}
+		if aof.dbIndexes[0] != 2 {
+			t.Errorf("expected AOF entry in db2, got db%d", aof.dbIndexes[0])
+		}
+
+		// 模拟 AOFHandler 写出的 SELECT + 命令
+		replayAOF := NewMockAOFHandler()
+		replayAOF.AddAOF(0, types.CmdLine(toCmdLine("select", "2")))
+		replayAOF.AddAOF(0, types.CmdLine(toCmdLine("set", "k", "v")))
+		restored := MakeMultiDB(4, replayAOF)
+
+		db2, _ := restored.GetDB(2)
+		if _, ok := db2.GetEntity("k"); !ok {
+			t.Error("replayed key should land in db2")
+		}
+		db0, _ := restored.GetDB(0)
+		if _, ok := db0.GetEntity("k"); ok {
+			t.Error("replayed key should not land in db0")
+		}
+	})
+
+	t.Run("AOF records absolute expire time", func(t *testing.T) {
+		aof := NewMockAOFHandler()
+		mdb := MakeMultiDB(1, aof)
+		conn := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("set", "k", "v", "ex", "100"))
+		mdb.Exec(conn, toCmdLine("expire", "k", "200", "gt"))
+		mdb.Exec(conn, toCmdLine("multi"))
+		mdb.Exec(conn, toCmdLine("pexpire", "k", "300000"))
+		mdb.Exec(conn, toCmdLine("exec"))
+
+		now := time.Now().UnixMilli()
+		expected := []struct {
+			name   string
+			pos    int
+			offset int64
+		}{
+			{"set", 4, 100000},
+			{"pexpireat", 2, 200000},
+			{"pexpireat", 2, 300000},
+		}
+		// 跳过事务的 MULTI/EXEC
+		var lines []types.CmdLine
+		for _, line := range aof.log {
+			if name := string(line[0]); name != "multi" && name != "exec" {
+				lines = append(lines, line)
+			}
+		}
+		if len(lines) != len(expected) {
+			t.Fatalf("expected %d AOF entries, got %d", len(expected), len(lines))
+		}
+		for i, exp := range expected {
+			line := lines[i]
+			if string(line[0]) != exp.name {
+				t.Fatalf("entry %d: expected %s, got %s", i, exp.name, line[0])
+			}
+			ms, err := strconv.ParseInt(string(line[exp.pos]), 10, 64)
+			if err != nil || ms > now+exp.offset || ms < now+exp.offset-1000 {
+				t.Errorf("entry %d: expected absolute time, got %q", i, line)
+			}
+		}
+
+		// 重放时不会延长生存时间
+		replayAOF := NewMockAOFHandler()
+		replayAOF.AddAOF(0, types.CmdLine(toCmdLine("set", "old", "v", "pxat", strconv.FormatInt(now-1, 10))))
+		restored := MakeMultiDB(1, replayAOF)
+		if exists(restored, conn, "old") {
+			t.Error("expired key should not be restored")
+		}
+	})
+}
+
+func assertIntReply(t *testing.T, reply resp.Reply, expected int64) {
+	t.Helper()
+	intReply, ok := reply.(*resp.IntReply)
+	if !ok || intReply.IntVal != expected {
+		t.Errorf("expected int %d, got %q", expected, reply.ToBytes())
+	}
+}
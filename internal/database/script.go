@@ -0,0 +1,162 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"goredis/internal/persistant"
+	"goredis/internal/resp"
+	"goredis/internal/types"
+	"goredis/pkg/connection"
+)
+
+// 由 MultiDB 处理的脚本命令及其参数个数
+var scriptCmdArity = map[string]int{
+	"eval":    -3, // eval script numkeys [key ...] [arg ...]
+	"evalsha": -3, // evalsha sha1 numkeys [key ...] [arg ...]
+	"script":  -2, // script LOAD|EXISTS|FLUSH|KILL ...
+
+	"fcall":    -3, // fcall function numkeys [key ...] [arg ...]
+	"fcall_ro": -3, // fcall_ro function numkeys [key ...] [arg ...]
+	"function": -2, // function LOAD|DELETE|FLUSH|LIST|DUMP|RESTORE|KILL ...
+}
+
+// SetLuaTimeLimit 设置脚本执行时间上限，0 表示不限制，需在开始服务前调用。
+// 超时时没有执行过写命令的脚本会被中止，否则继续执行，期间其他客户端收到 BUSY
+func (mdb *MultiDB) SetLuaTimeLimit(limit time.Duration) {
+	mdb.scripts.SetTimeLimit(limit)
+}
+
+// SetLuaReplicateCommands 为 true 时（默认）脚本按执行的写命令复制，否则按 EVAL 原样复制。
+// 原样复制要求脚本是确定性的，slave 和加载 AOF 时重新执行才能得到相同的结果
+func (mdb *MultiDB) SetLuaReplicateCommands(effects bool) {
+	mdb.scriptEffects = effects
+}
+
+// SCRIPT LOAD|EXISTS|FLUSH|KILL，不访问数据库，因此不加锁：SCRIPT KILL 需要在其他脚本执行期间调用
+func (mdb *MultiDB) execScript(cmdLine [][]byte) resp.Reply {
+	if !validateArity(scriptCmdArity["script"], cmdLine) {
+		return resp.MakeArgNumErrReply("script")
+	}
+	return mdb.scripts.ExecScript(cmdLine)
+}
+
+// FUNCTION 的子命令。LOAD/DELETE/FLUSH/RESTORE 修改函数库，持有写锁执行并原样写入 AOF 和复制流；
+// LIST/DUMP/KILL 不加锁，FUNCTION KILL 才能在函数执行期间调用
+func (mdb *MultiDB) execFunction(c connection.Connection, cmdLine [][]byte) resp.Reply {
+	if !validateArity(scriptCmdArity["function"], cmdLine) {
+		return resp.MakeArgNumErrReply("function")
+	}
+	if !types.CmdLine(cmdLine).IsWrite() {
+		return mdb.scripts.ExecFunction(cmdLine)
+	}
+
+	mdb.mu.Lock()
+	defer mdb.mu.Unlock()
+
+	reply, cmdLines := mdb.runFunctionCmd(cmdLine)
+	mdb.afterWrite(c, c.GetDBIndex(), cmdLines)
+	return reply
+}
+
+// runFunctionCmd 执行 FUNCTION 的子命令，返回需要传播的命令，修改函数库的子命令调用方需持有写锁
+func (mdb *MultiDB) runFunctionCmd(cmdLine [][]byte) (resp.Reply, [][][]byte) {
+	reply := mdb.scripts.ExecFunction(cmdLine)
+	if resp.IsErrorReply(reply) || !types.CmdLine(cmdLine).IsWrite() {
+		return reply, nil
+	}
+	return reply, [][][]byte{cmdLine}
+}
+
+// isKillCmd SCRIPT KILL 或 FUNCTION KILL，脚本超时后仍可执行
+func isKillCmd(cmdLine [][]byte) bool {
+	if len(cmdLine) != 2 || !strings.EqualFold(string(cmdLine[1]), "kill") {
+		return false
+	}
+	name := strings.ToLower(string(cmdLine[0]))
+	return name == "script" || name == "function"
+}
+
+// IsWriteCmd 命令是否可能修改数据，slave 据此拒绝客户端的写命令。
+// FCALL 调用声明了 no-writes 的函数时按读命令处理
+func (mdb *MultiDB) IsWriteCmd(cmdLine [][]byte) bool {
+	if len(cmdLine) >= 2 && strings.ToLower(string(cmdLine[0])) == "fcall" {
+		if noWrites, ok := mdb.scripts.FunctionNoWrites(string(cmdLine[1])); ok {
+			return !noWrites
+		}
+	}
+	return types.CmdLine(cmdLine).IsWrite()
+}
+
+// EVAL/EVALSHA/FCALL/FCALL_RO 持有写锁执行，脚本执行期间不会穿插其他客户端的命令
+func (mdb *MultiDB) execEval(c connection.Connection, cmdLine [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if !validateArity(scriptCmdArity[cmdName], cmdLine) {
+		return resp.MakeArgNumErrReply(cmdName)
+	}
+
+	mdb.mu.Lock()
+	defer mdb.mu.Unlock()
+
+	// 与事务一样在脚本开始前腾出内存，执行期间不再淘汰：否则脚本可能淘汰自己写入的 key，
+	// 淘汰产生的 DEL 也会先于脚本传播，重新执行脚本的 slave 和 AOF 与这里不一致
+	if !isAOFConn(c) && mdb.IsWriteCmd(cmdLine) && !mdb.freeMemoryIfNeeded() {
+		return makeOOMReply()
+	}
+
+	reply, writes, effects := mdb.evalScript(c, cmdLine)
+	if len(writes) > 0 && !isAOFConn(c) {
+		if len(writes) == 1 {
+			mdb.aofHandler.AddAOF(writes[0].DBIndex, writes[0].CmdLine)
+		} else {
+			mdb.aofHandler.AddTransaction(writes)
+		}
+	}
+	mdb.addDirty(int64(len(effects)))
+	for _, w := range effects {
+		mdb.signalKeysReady(w.DBIndex, w.CmdLine)
+	}
+	return reply
+}
+
+// evalScript 执行脚本，调用方需持有写锁。
+// 返回需要写入 AOF 和复制流的命令，以及脚本实际执行的写命令（用于唤醒阻塞的客户端）
+func (mdb *MultiDB) evalScript(c connection.Connection, cmdLine [][]byte) (resp.Reply, []persistant.TxCmd, []persistant.TxCmd) {
+	// 脚本有自己的当前数据库，脚本中的 SELECT 不影响调用者
+	conn := connection.NewAOFConnection(c.GetDBIndex())
+
+	var effects []persistant.TxCmd
+	call := func(line [][]byte) resp.Reply {
+		line = translateCmd(line)
+		var reply resp.Reply
+		var cmdLines [][][]byte
+		if isBlockingCmd(line) {
+			// 与事务中一样，脚本中的阻塞命令不会阻塞
+			reply, cmdLines = mdb.popNow(conn, line)
+		} else {
+			unlock := mdb.lockCmd(conn, line)
+			reply, cmdLines = mdb.execCmd(conn, line)
+			unlock()
+		}
+		for _, l := range cmdLines {
+			effects = append(effects, persistant.TxCmd{DBIndex: conn.GetDBIndex(), CmdLine: l})
+		}
+		return reply
+	}
+
+	// 加载 AOF 时脚本必须执行完，否则数据会与写入时不一致
+	run := mdb.scripts.Eval
+	if name := strings.ToLower(string(cmdLine[0])); name == "fcall" || name == "fcall_ro" {
+		run = mdb.scripts.Call
+	}
+	// 只有没有执行过写命令的脚本会被中止，有 effects 时脚本总是完整执行的
+	reply := run(cmdLine, call, !isAOFConn(c))
+	if len(effects) == 0 {
+		return reply, nil, nil
+	}
+	if mdb.scriptEffects {
+		return reply, effects, effects
+	}
+	verbatim := persistant.TxCmd{DBIndex: c.GetDBIndex(), CmdLine: mdb.scripts.EvalCmdLine(cmdLine)}
+	return reply, []persistant.TxCmd{verbatim}, effects
+}
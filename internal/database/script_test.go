@@ -0,0 +1,384 @@
+package database
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"goredis/internal/persistant"
+	"goredis/internal/script"
+)
+
+func TestEval(t *testing.T) {
+	t.Run("redis.call runs against the selected db", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		conn := &MockConnection{dbIndex: 1}
+
+		reply := mdb.Exec(conn, toCmdLine("eval", "redis.call('incrby', KEYS[1], ARGV[1]); return redis.call('get', KEYS[1])", "1", "n", "5"))
+		if got := string(reply.ToBytes()); got != "$1\r\n5\r\n" {
+			t.Fatalf("unexpected EVAL reply %q", got)
+		}
+		db1, _ := mdb.GetDB(1)
+		if _, ok := db1.GetEntity("n"); !ok {
+			t.Error("script should write to the client's db")
+		}
+
+		// 脚本中的 SELECT 不影响调用者
+		mdb.Exec(conn, toCmdLine("eval", "redis.call('select', 2); return redis.call('incr', 'x')", "0"))
+		if conn.GetDBIndex() != 1 {
+			t.Errorf("SELECT in script changed the client's db to %d", conn.GetDBIndex())
+		}
+		db2, _ := mdb.GetDB(2)
+		if _, ok := db2.GetEntity("x"); !ok {
+			t.Error("script should write to the db it selected")
+		}
+	})
+
+	t.Run("EVALSHA and SCRIPT commands", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		conn := &MockConnection{}
+		body := "return ARGV[1]"
+		sha := script.SHA1Hex([]byte(body))
+
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("evalsha", sha, "0", "a"))); !strings.HasPrefix(msg, "NOSCRIPT") {
+			t.Fatalf("expected NOSCRIPT, got %q", msg)
+		}
+		if got := string(getBulkValue(mdb.Exec(conn, toCmdLine("script", "load", body)))); got != sha {
+			t.Fatalf("SCRIPT LOAD returned %q, want %q", got, sha)
+		}
+		if got := string(mdb.Exec(conn, toCmdLine("script", "exists", sha, "ffff")).ToBytes()); got != "*2\r\n:1\r\n:0\r\n" {
+			t.Errorf("unexpected SCRIPT EXISTS reply %q", got)
+		}
+		if got := string(getBulkValue(mdb.Exec(conn, toCmdLine("evalsha", strings.ToUpper(sha), "0", "a")))); got != "a" {
+			t.Errorf("unexpected EVALSHA reply %q", got)
+		}
+		mdb.Exec(conn, toCmdLine("script", "flush"))
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("evalsha", sha, "0"))); !strings.HasPrefix(msg, "NOSCRIPT") {
+			t.Errorf("script should be flushed, got %q", msg)
+		}
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("script", "kill"))); !strings.HasPrefix(msg, "NOTBUSY") {
+			t.Errorf("expected NOTBUSY, got %q", msg)
+		}
+	})
+
+	t.Run("commands not allowed from script", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		conn := &MockConnection{}
+
+		for _, cmd := range []string{"multi", "eval", "save"} {
+			msg := getErrorString(mdb.Exec(conn, toCmdLine("eval", "return redis.call('"+cmd+"')", "0")))
+			if !strings.Contains(msg, "not allowed from script") {
+				t.Errorf("%s in script: unexpected error %q", cmd, msg)
+			}
+		}
+		// 阻塞命令在脚本中不会阻塞
+		reply := mdb.Exec(conn, toCmdLine("eval", "return redis.call('blpop', 'empty', 0)", "0"))
+		if got := string(reply.ToBytes()); got != "$-1\r\n" {
+			t.Errorf("BLPOP in script should return nil immediately, got %q", got)
+		}
+	})
+
+	t.Run("EVAL inside MULTI", func(t *testing.T) {
+		aof := NewMockAOFHandler()
+		mdb := MakeMultiDB(4, aof)
+		conn := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("multi"))
+		mdb.Exec(conn, toCmdLine("eval", "return redis.call('incr', KEYS[1])", "1", "c"))
+		mdb.Exec(conn, toCmdLine("incr", "c"))
+		reply := mdb.Exec(conn, toCmdLine("exec"))
+		if got := string(reply.ToBytes()); got != "*2\r\n:1\r\n:2\r\n" {
+			t.Fatalf("unexpected EXEC reply %q", got)
+		}
+		if got := strings.Join(aofLines(aof), ","); got != "multi,incr c,incr c,exec" {
+			t.Errorf("unexpected AOF %q", got)
+		}
+	})
+}
+
+func TestEvalPropagation(t *testing.T) {
+	const body = "redis.call('rpush', KEYS[1], 'a'); redis.call('incr', KEYS[2]); return redis.call('lpop', KEYS[1])"
+
+	t.Run("effects are propagated as a transaction", func(t *testing.T) {
+		aof := NewMockAOFHandler()
+		mdb := MakeMultiDB(4, aof)
+
+		mdb.Exec(&MockConnection{}, toCmdLine("eval", body, "2", "l", "n"))
+		if got := strings.Join(aofLines(aof), ","); got != "multi,rpush l a,incr n,lpop l,exec" {
+			t.Errorf("unexpected AOF %q", got)
+		}
+	})
+
+	t.Run("scripts without writes are not propagated", func(t *testing.T) {
+		aof := NewMockAOFHandler()
+		mdb := MakeMultiDB(4, aof)
+
+		mdb.Exec(&MockConnection{}, toCmdLine("eval", "return redis.call('get', 'k')", "0"))
+		if lines := aofLines(aof); len(lines) != 0 {
+			t.Errorf("read-only script should not be propagated, got %v", lines)
+		}
+	})
+
+	t.Run("verbatim replication rewrites EVALSHA to EVAL", func(t *testing.T) {
+		aof := NewMockAOFHandler()
+		mdb := MakeMultiDB(4, aof)
+		mdb.SetLuaReplicateCommands(false)
+		conn := &MockConnection{}
+
+		sha := string(getBulkValue(mdb.Exec(conn, toCmdLine("script", "load", body))))
+		mdb.Exec(conn, toCmdLine("evalsha", sha, "2", "l", "n"))
+		if got := strings.Join(aofLines(aof), ","); got != "EVAL "+body+" 2 l n" {
+			t.Fatalf("unexpected AOF %q", got)
+		}
+
+		// 重放 AOF 重新执行脚本
+		replay := MakeMultiDB(4, aof)
+		db0, _ := replay.GetDB(0)
+		if _, ok := db0.GetEntity("n"); !ok {
+			t.Error("replaying the AOF should run the script again")
+		}
+	})
+
+	t.Run("script writes wake up blocked clients", func(t *testing.T) {
+		aof := NewMockAOFHandler()
+		mdb := MakeMultiDB(4, aof)
+		mdb.SetLuaReplicateCommands(false)
+		db0, _ := mdb.GetDB(0)
+
+		ch := execAsync(mdb, &MockConnection{}, "blpop", "q", "0")
+		waitBlocked(t, db0, 1)
+		mdb.Exec(&MockConnection{}, toCmdLine("eval", "return redis.call('rpush', 'q', 'x')", "0"))
+		if got := receiveReply(t, ch); got != "*2\r\n$1\r\nq\r\n$1\r\nx\r\n" {
+			t.Errorf("unexpected BLPOP reply %q", got)
+		}
+	})
+}
+
+func TestEvalAtomicity(t *testing.T) {
+	mdb := MakeMultiDB(4, NewMockAOFHandler())
+	// 读取后写回，不是原子执行的话并发的脚本会丢失更新
+	const body = "local v = tonumber(redis.call('get', KEYS[1]) or '0'); for i = 1, 1000 do end; " +
+		"redis.call('del', KEYS[1]); return redis.call('incrby', KEYS[1], v + 1)"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := &MockConnection{}
+			for j := 0; j < 50; j++ {
+				mdb.Exec(conn, toCmdLine("eval", body, "1", "counter"))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := string(getBulkValue(mdb.Exec(&MockConnection{}, toCmdLine("get", "counter")))); got != "1000" {
+		t.Errorf("counter = %s, want 1000", got)
+	}
+}
+
+func TestEvalTimeLimit(t *testing.T) {
+	t.Run("script exceeding the time limit is stopped", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		mdb.SetLuaTimeLimit(50 * time.Millisecond)
+
+		start := time.Now()
+		msg := getErrorString(mdb.Exec(&MockConnection{}, toCmdLine("eval", "while true do end", "0")))
+		if !strings.Contains(msg, "lua-time-limit") {
+			t.Fatalf("unexpected error %q", msg)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("script ran for %v", elapsed)
+		}
+		// 虚拟机可以继续使用
+		if got := string(mdb.Exec(&MockConnection{}, toCmdLine("eval", "return 1", "0")).ToBytes()); got != ":1\r\n" {
+			t.Errorf("unexpected reply after timeout %q", got)
+		}
+	})
+
+	t.Run("SCRIPT KILL", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		mdb.SetLuaTimeLimit(0)
+
+		ch := execAsync(mdb, &MockConnection{}, "eval", "while true do end", "0")
+		waitScriptRunning(t, mdb)
+		if reply := mdb.Exec(&MockConnection{}, toCmdLine("script", "kill")); !isOKReply(reply) {
+			t.Fatalf("SCRIPT KILL failed: %q", reply.ToBytes())
+		}
+		if got := receiveReply(t, ch); !strings.Contains(got, "SCRIPT KILL") {
+			t.Errorf("unexpected EVAL reply %q", got)
+		}
+	})
+
+	t.Run("script that wrote runs to completion", func(t *testing.T) {
+		aof := NewMockAOFHandler()
+		mdb := MakeMultiDB(4, aof)
+		mdb.SetLuaTimeLimit(5 * time.Millisecond)
+		mdb.SetLuaReplicateCommands(false)
+
+		// 循环要足够久，测试才能在脚本结束前观察到 BUSY
+		body := "redis.call('incr', 'k'); for i = 1, 3000000 do end; return 'done'"
+		ch := execAsync(mdb, &MockConnection{}, "eval", body, "0")
+		deadline := time.Now().Add(time.Second)
+		for !mdb.scripts.Busy() {
+			if time.Now().After(deadline) {
+				t.Fatal("script did not exceed the time limit")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		// 超时后其他客户端立即收到 BUSY，已经写过的脚本不能中止
+		if msg := getErrorString(mdb.Exec(&MockConnection{}, toCmdLine("get", "k"))); !strings.HasPrefix(msg, "BUSY") {
+			t.Errorf("expected BUSY, got %q", msg)
+		}
+		if msg := getErrorString(mdb.Exec(&MockConnection{}, toCmdLine("script", "kill"))); !strings.HasPrefix(msg, "UNKILLABLE") {
+			t.Errorf("expected UNKILLABLE, got %q", msg)
+		}
+
+		select {
+		case reply := <-ch:
+			if got := string(reply.ToBytes()); got != "$4\r\ndone\r\n" {
+				t.Errorf("unexpected EVAL reply %q", got)
+			}
+		case <-time.After(30 * time.Second):
+			t.Fatal("script did not finish")
+		}
+		assertIntReply(t, mdb.Exec(&MockConnection{}, toCmdLine("incr", "k")), 2)
+		// 脚本完整执行，可以原样复制
+		if got := aofLines(aof); len(got) != 2 || got[0] != "eval "+body+" 0" || got[1] != "incr k" {
+			t.Errorf("unexpected AOF %q", got)
+		}
+	})
+}
+
+const counterLibrary = "#!lua name=counter\n" +
+	"redis.register_function('incr2', function(keys) redis.call('incr', keys[1]); return redis.call('incr', keys[1]) end)\n" +
+	"redis.register_function{function_name='peek', callback=function(keys) return redis.call('get', keys[1]) end, flags={'no-writes'}}"
+
+func TestFunction(t *testing.T) {
+	t.Run("FUNCTION LOAD and FCALL are propagated and replayed", func(t *testing.T) {
+		aof := NewMockAOFHandler()
+		mdb := MakeMultiDB(4, aof)
+		conn := &MockConnection{}
+
+		if got := string(getBulkValue(mdb.Exec(conn, toCmdLine("function", "load", counterLibrary)))); got != "counter" {
+			t.Fatalf("unexpected FUNCTION LOAD reply %q", got)
+		}
+		assertIntReply(t, mdb.Exec(conn, toCmdLine("fcall", "incr2", "1", "c")), 2)
+		if got := string(getBulkValue(mdb.Exec(conn, toCmdLine("fcall_ro", "peek", "1", "c")))); got != "2" {
+			t.Errorf("unexpected FCALL_RO reply %q", got)
+		}
+		// 只读子命令和只读函数不写 AOF
+		mdb.Exec(conn, toCmdLine("function", "list"))
+		if got := strings.Join(aofLines(aof), ","); got != "function load "+counterLibrary+",multi,incr c,incr c,exec" {
+			t.Errorf("unexpected AOF %q", got)
+		}
+
+		replay := MakeMultiDB(4, aof)
+		if got := string(getBulkValue(replay.Exec(&MockConnection{}, toCmdLine("fcall", "peek", "1", "c")))); got != "2" {
+			t.Errorf("functions and data should be replayed from the AOF, got %q", got)
+		}
+	})
+
+	t.Run("verbatim replication keeps FCALL", func(t *testing.T) {
+		aof := NewMockAOFHandler()
+		mdb := MakeMultiDB(4, aof)
+		mdb.SetLuaReplicateCommands(false)
+		conn := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("function", "load", counterLibrary))
+		mdb.Exec(conn, toCmdLine("fcall", "incr2", "1", "c"))
+		if lines := aofLines(aof); len(lines) != 2 || lines[1] != "fcall incr2 1 c" {
+			t.Errorf("unexpected AOF %q", lines)
+		}
+	})
+
+	t.Run("write checks", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		conn := &MockConnection{}
+		mdb.Exec(conn, toCmdLine("function", "load", counterLibrary))
+
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("fcall_ro", "incr2", "1", "c"))); !strings.Contains(msg, "*_ro command") {
+			t.Errorf("FCALL_RO should refuse write functions, got %q", msg)
+		}
+		for _, tc := range []struct {
+			line  []string
+			write bool
+		}{
+			{[]string{"fcall", "incr2", "1", "c"}, true},
+			{[]string{"fcall", "peek", "1", "c"}, false},
+			{[]string{"fcall_ro", "peek", "1", "c"}, false},
+			{[]string{"function", "list"}, false},
+			{[]string{"function", "delete", "counter"}, true},
+			{[]string{"get", "c"}, false},
+		} {
+			if got := mdb.IsWriteCmd(toCmdLine(tc.line...)); got != tc.write {
+				t.Errorf("IsWriteCmd(%v) = %v, want %v", tc.line, got, tc.write)
+			}
+		}
+	})
+
+	t.Run("FUNCTION inside MULTI", func(t *testing.T) {
+		aof := NewMockAOFHandler()
+		mdb := MakeMultiDB(4, aof)
+		conn := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("multi"))
+		mdb.Exec(conn, toCmdLine("function", "load", counterLibrary))
+		mdb.Exec(conn, toCmdLine("fcall", "incr2", "1", "c"))
+		mdb.Exec(conn, toCmdLine("function", "list", "libraryname", "nosuch"))
+		reply := mdb.Exec(conn, toCmdLine("exec"))
+		if got := string(reply.ToBytes()); got != "*3\r\n$7\r\ncounter\r\n:2\r\n*0\r\n" {
+			t.Fatalf("unexpected EXEC reply %q", got)
+		}
+		if got := strings.Join(aofLines(aof), ","); got != "multi,function load "+counterLibrary+",incr c,incr c,exec" {
+			t.Errorf("unexpected AOF %q", got)
+		}
+	})
+
+	t.Run("Clear removes functions", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		conn := &MockConnection{}
+		mdb.Exec(conn, toCmdLine("function", "load", counterLibrary))
+		mdb.Clear()
+		if got := string(mdb.Exec(conn, toCmdLine("function", "list")).ToBytes()); got != "*0\r\n" {
+			t.Errorf("functions should be removed by a full resync, got %q", got)
+		}
+	})
+
+	t.Run("functions are saved in the RDB", func(t *testing.T) {
+		dir := t.TempDir()
+		rdb, _ := persistant.NewRDBHandler(dir, "dump.rdb", nil)
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		mdb.SetRDBHandler(rdb)
+		conn := &MockConnection{}
+		mdb.Exec(conn, toCmdLine("function", "load", counterLibrary))
+		mdb.Exec(conn, toCmdLine("set", "c", "5"))
+		if reply := mdb.Exec(conn, toCmdLine("save")); !isOKReply(reply) {
+			t.Fatalf("SAVE failed: %s", getErrorString(reply))
+		}
+
+		rdb2, _ := persistant.NewRDBHandler(dir, "dump.rdb", nil)
+		restored := MakeMultiDB(4, NewMockAOFHandler())
+		restored.SetRDBHandler(rdb2)
+		if err := restored.LoadRDB(); err != nil {
+			t.Fatalf("LoadRDB failed: %v", err)
+		}
+		assertIntReply(t, restored.Exec(conn, toCmdLine("fcall", "incr2", "1", "c")), 7)
+	})
+}
+
+// waitScriptRunning 等待脚本进入循环：执行期间持有写锁，拿不到读锁，再留出执行循环之前语句的时间
+func waitScriptRunning(t *testing.T, mdb *MultiDB) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for mdb.mu.TryRLock() {
+		mdb.mu.RUnlock()
+		if time.Now().After(deadline) {
+			t.Fatal("script did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+}
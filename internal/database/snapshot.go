@@ -0,0 +1,101 @@
+package database
+
+import (
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"goredis/internal/resp"
+	"goredis/internal/types"
+)
+
+const saveCheckInterval = time.Second
+
+// SAVE 在写锁下同步保存，期间不处理其他命令
+func (mdb *MultiDB) execSave() resp.Reply {
+	if mdb.rdbHandler == nil {
+		return resp.MakeErrReply("ERR snapshot persistence is disabled")
+	}
+	if mdb.rdbHandler.IsSaving() {
+		return resp.MakeErrReply("ERR Background save already in progress")
+	}
+
+	dbs := make([]types.Database, len(mdb.dbSet))
+	for i, db := range mdb.dbSet {
+		dbs[i] = db
+	}
+	if err := mdb.rdbHandler.Save(dbs, mdb.scripts.LibraryCodes()); err != nil {
+		return resp.MakeErrReply("ERR " + err.Error())
+	}
+	return resp.MakeOkReply()
+}
+
+// BGSAVE [SCHEDULE]，在写锁下 Clone 出一致的快照后交给后台保存
+func (mdb *MultiDB) execBGSave(cmdLine [][]byte) resp.Reply {
+	if mdb.rdbHandler == nil {
+		return resp.MakeErrReply("ERR snapshot persistence is disabled")
+	}
+	schedule := false
+	if len(cmdLine) > 1 {
+		if len(cmdLine) > 2 || strings.ToLower(string(cmdLine[1])) != "schedule" {
+			return resp.MakeErrReply("ERR syntax error")
+		}
+		schedule = true
+	}
+
+	if mdb.rdbHandler.IsSaving() {
+		if !schedule {
+			return resp.MakeErrReply("ERR Background save already in progress")
+		}
+		// 当前保存结束后由 saveChecker 执行
+		atomic.StoreInt32(&mdb.bgsaveScheduled, 1)
+		return resp.MakeSimpleStringReply("Background saving scheduled")
+	}
+
+	if err := mdb.rdbHandler.BGSave(mdb.cloneDBs(), mdb.scripts.LibraryCodes()); err != nil {
+		return resp.MakeErrReply("ERR " + err.Error())
+	}
+	return resp.MakeSimpleStringReply("Background saving started")
+}
+
+// LASTSAVE
+func (mdb *MultiDB) execLastSave() resp.Reply {
+	if mdb.rdbHandler == nil {
+		return resp.MakeErrReply("ERR snapshot persistence is disabled")
+	}
+	return resp.MakeIntReply(mdb.rdbHandler.LastSave().Unix())
+}
+
+// addDirty 记录写命令数量，用于自动保存
+func (mdb *MultiDB) addDirty(n int64) {
+	if mdb.rdbHandler != nil && n > 0 {
+		mdb.rdbHandler.AddDirty(n)
+	}
+}
+
+// startSaveChecker 满足 save 配置或有被推迟的 BGSAVE 时自动执行 BGSAVE
+func (mdb *MultiDB) startSaveChecker() {
+	go func() {
+		ticker := time.NewTicker(saveCheckInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			rdb := mdb.rdbHandler
+			if rdb.IsSaving() {
+				continue
+			}
+			scheduled := atomic.CompareAndSwapInt32(&mdb.bgsaveScheduled, 1, 0)
+			if !scheduled && !rdb.ShouldSave() {
+				continue
+			}
+
+			mdb.mu.Lock()
+			dbs, functions := mdb.cloneDBs(), mdb.scripts.LibraryCodes()
+			mdb.mu.Unlock()
+			if err := rdb.BGSave(dbs, functions); err != nil {
+				log.Printf("[rdb] auto save failed: %v", err)
+			}
+		}
+	}()
+}
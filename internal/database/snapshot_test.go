@@ -0,0 +1,100 @@
+package database
+
+import (
+	"goredis/internal/persistant"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Run("SAVE then LoadRDB", func(t *testing.T) {
+		dir := t.TempDir()
+		rdb, _ := persistant.NewRDBHandler(dir, "dump.rdb", nil)
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		mdb.SetRDBHandler(rdb)
+		conn := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("set", "k", "v"))
+		mdb.Exec(conn, toCmdLine("rpush", "l", "a", "b"))
+		mdb.Exec(conn, toCmdLine("select", "3"))
+		mdb.Exec(conn, toCmdLine("sadd", "s", "m"))
+		mdb.Exec(conn, toCmdLine("expire", "s", "100"))
+
+		if reply := mdb.Exec(conn, toCmdLine("save")); !isOKReply(reply) {
+			t.Fatalf("SAVE failed: %s", getErrorString(reply))
+		}
+		raw := string(mdb.Exec(conn, toCmdLine("lastsave")).ToBytes())
+		lastSave, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(raw, ":"), "\r\n"), 10, 64)
+		if err != nil || time.Now().Unix()-lastSave > 1 {
+			t.Errorf("unexpected LASTSAVE reply %q", raw)
+		}
+
+		rdb2, _ := persistant.NewRDBHandler(dir, "dump.rdb", nil)
+		restored := MakeMultiDB(4, NewMockAOFHandler())
+		restored.SetRDBHandler(rdb2)
+		if err := restored.LoadRDB(); err != nil {
+			t.Fatalf("LoadRDB failed: %v", err)
+		}
+
+		other := &MockConnection{}
+		if got := string(getBulkValue(restored.Exec(other, toCmdLine("get", "k")))); got != "v" {
+			t.Errorf("expected v, got %q", got)
+		}
+		assertIntReply(t, restored.Exec(other, toCmdLine("llen", "l")), 2)
+		db3, _ := restored.GetDB(3)
+		if _, ok := db3.GetEntity("s"); !ok {
+			t.Error("set should be restored into db3")
+		}
+		if _, ok := db3.GetExpireTime("s"); !ok {
+			t.Error("TTL should be restored")
+		}
+	})
+
+	t.Run("BGSAVE", func(t *testing.T) {
+		dir := t.TempDir()
+		rdb, _ := persistant.NewRDBHandler(dir, "dump.rdb", nil)
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		mdb.SetRDBHandler(rdb)
+		conn := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("set", "k", "before"))
+		reply := mdb.Exec(conn, toCmdLine("bgsave"))
+		if string(reply.ToBytes()) != "+Background saving started\r\n" {
+			t.Fatalf("unexpected BGSAVE reply %q", reply.ToBytes())
+		}
+		// 快照之后的修改不影响正在保存的数据
+		mdb.Exec(conn, toCmdLine("set", "k", "after"))
+
+		deadline := time.Now().Add(time.Second)
+		for rdb.IsSaving() && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		restored := MakeMultiDB(4, NewMockAOFHandler())
+		restored.SetRDBHandler(rdb)
+		if err := restored.LoadRDB(); err != nil {
+			t.Fatalf("LoadRDB failed: %v", err)
+		}
+		if got := string(getBulkValue(restored.Exec(conn, toCmdLine("get", "k")))); got != "before" {
+			t.Errorf("BGSAVE should save the point-in-time snapshot, got %q", got)
+		}
+
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("bgsave", "now"))); msg != "ERR syntax error" {
+			t.Errorf("unexpected error: %q", msg)
+		}
+	})
+
+	t.Run("snapshot disabled", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		conn := &MockConnection{}
+
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("save"))); msg != "ERR snapshot persistence is disabled" {
+			t.Errorf("unexpected error: %q", msg)
+		}
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("lastsave", "x"))); msg == "" {
+			t.Error("LASTSAVE with arguments should fail")
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"strings"
+
+	"goredis/internal/persistant"
+	"goredis/internal/resp"
+	"goredis/pkg/connection"
+)
+
+var queuedReply = resp.MakeSimpleStringReply("QUEUED")
+
+// MULTI
+func execMulti(c connection.Connection, cmdLine [][]byte) resp.Reply {
+	if !validateArity(1, cmdLine) {
+		return resp.MakeArgNumErrReply("multi")
+	}
+	if c.InMultiState() {
+		return resp.MakeErrReply("ERR MULTI calls can not be nested")
+	}
+
+	c.SetMultiState(true)
+	return resp.MakeOkReply()
+}
+
+// DISCARD
+func execDiscard(c connection.Connection, cmdLine [][]byte) resp.Reply {
+	if !validateArity(1, cmdLine) {
+		return resp.MakeArgNumErrReply("discard")
+	}
+	if !c.InMultiState() {
+		return resp.MakeErrReply("ERR DISCARD without MULTI")
+	}
+
+	c.SetMultiState(false)
+	c.Unwatch()
+	return resp.MakeOkReply()
+}
+
+// WATCH key [key ...]
+func (mdb *MultiDB) execWatch(c connection.Connection, cmdLine [][]byte) resp.Reply {
+	if !validateArity(-2, cmdLine) {
+		return resp.MakeArgNumErrReply("watch")
+	}
+	if c.InMultiState() {
+		return resp.MakeErrReply("ERR WATCH inside MULTI is not allowed")
+	}
+
+	mdb.mu.RLock()
+	defer mdb.mu.RUnlock()
+
+	db, errReply := mdb.selectDB(c.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	for _, arg := range cmdLine[1:] {
+		key := string(arg)
+		c.Watch(connection.WatchKey{DBIndex: c.GetDBIndex(), Key: key}, db.GetVersion(key))
+	}
+	return resp.MakeOkReply()
+}
+
+// UNWATCH
+func execUnwatch(c connection.Connection, cmdLine [][]byte) resp.Reply {
+	if !validateArity(1, cmdLine) {
+		return resp.MakeArgNumErrReply("unwatch")
+	}
+
+	c.Unwatch()
+	return resp.MakeOkReply()
+}
+
+// enqueueCmd 事务中的命令先校验再入队，校验失败会使整个事务在 EXEC 时被放弃
+func enqueueCmd(c connection.Connection, cmdLine [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+
+	if arity, ok := multiDBCmdArity[cmdName]; ok {
+		if !validateArity(arity, cmdLine) {
+			c.MarkTxAborted()
+			return resp.MakeArgNumErrReply(cmdName)
+		}
+	} else if arity, ok := scriptCmdArity[cmdName]; ok {
+		if !validateArity(arity, cmdLine) {
+			c.MarkTxAborted()
+			return resp.MakeArgNumErrReply(cmdName)
+		}
+	} else if _, errReply := lookupCommand(cmdLine); errReply != nil {
+		c.MarkTxAborted()
+		return errReply
+	}
+
+	c.EnqueueCmd(cmdLine)
+	return queuedReply
+}
+
+// EXEC 在写锁下依次执行所有入队命令，期间不会穿插其他客户端的命令
+func (mdb *MultiDB) execExec(c connection.Connection, cmdLine [][]byte) resp.Reply {
+	if !validateArity(1, cmdLine) {
+		return resp.MakeArgNumErrReply("exec")
+	}
+	if !c.InMultiState() {
+		return resp.MakeErrReply("ERR EXEC without MULTI")
+	}
+	defer func() {
+		c.SetMultiState(false)
+		c.Unwatch()
+	}()
+
+	if c.IsTxAborted() {
+		return resp.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+
+	mdb.mu.Lock()
+	defer mdb.mu.Unlock()
+
+	// WATCH 的 key 被其他客户端修改过，放弃事务
+	for key, version := range c.GetWatching() {
+		db, errReply := mdb.selectDB(key.DBIndex)
+		if errReply != nil || db.GetVersion(key.Key) != version {
+			return resp.MakeNullMultiBulkReply()
+		}
+	}
+
+	queue := c.GetQueuedCmdLine()
+	if !isAOFConn(c) && txDenyOOM(queue) && !mdb.freeMemoryIfNeeded() {
+		return makeOOMReply()
+	}
+
+	replies := make([]resp.Reply, 0, len(queue))
+	// writes 是写入 AOF 的命令，effects 是实际执行的写命令，只有按原样复制的脚本两者不同
+	writes := make([]persistant.TxCmd, 0, len(queue))
+	var effects []persistant.TxCmd
+	for _, line := range queue {
+		// Redis 事务不回滚，出错的命令只影响自己的返回值
+		var reply resp.Reply
+		var cmdLines [][][]byte
+		switch strings.ToLower(string(line[0])) {
+		case "script":
+			replies = append(replies, mdb.execScript(line))
+			continue
+		case "function":
+			reply, cmdLines = mdb.runFunctionCmd(line)
+			replies = append(replies, reply)
+			for _, cmdLine := range cmdLines {
+				w := persistant.TxCmd{DBIndex: c.GetDBIndex(), CmdLine: cmdLine}
+				writes = append(writes, w)
+				effects = append(effects, w)
+			}
+			continue
+		case "eval", "evalsha", "fcall", "fcall_ro":
+			reply, scriptWrites, scriptEffects := mdb.evalScript(c, line)
+			replies = append(replies, reply)
+			writes = append(writes, scriptWrites...)
+			effects = append(effects, scriptEffects...)
+			continue
+		}
+		if isBlockingCmd(line) {
+			// 事务中的阻塞命令不会阻塞，以实际执行的弹出命令写入 AOF
+			reply, cmdLines = mdb.popNow(c, line)
+		} else {
+			line = translateCmd(line)
+			unlock := mdb.lockCmd(c, line)
+			reply, cmdLines = mdb.execCmd(c, line)
+			unlock()
+		}
+		replies = append(replies, reply)
+
+		for _, cmdLine := range cmdLines {
+			w := persistant.TxCmd{DBIndex: c.GetDBIndex(), CmdLine: cmdLine}
+			writes = append(writes, w)
+			effects = append(effects, w)
+		}
+	}
+
+	// 事务中的写命令作为一个整体写入 AOF 和复制流
+	if len(writes) > 0 && !isAOFConn(c) {
+		mdb.aofHandler.AddTransaction(writes)
+	}
+	mdb.addDirty(int64(len(effects)))
+	for _, w := range effects {
+		mdb.signalKeysReady(w.DBIndex, w.CmdLine)
+	}
+
+	return resp.MakeMultiRawReply(replies)
+}
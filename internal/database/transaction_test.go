@@ -0,0 +1,218 @@
+package database
+
+import (
+	"goredis/internal/resp"
+	"testing"
+)
+
+func TestTransaction(t *testing.T) {
+	t.Run("MULTI EXEC", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		conn := &MockConnection{}
+
+		if reply := mdb.Exec(conn, toCmdLine("multi")); !isOKReply(reply) {
+			t.Fatalf("MULTI failed: %s", getErrorString(reply))
+		}
+		if reply := mdb.Exec(conn, toCmdLine("set", "k", "1")); string(reply.ToBytes()) != "+QUEUED\r\n" {
+			t.Fatalf("expected QUEUED, got %q", reply.ToBytes())
+		}
+		mdb.Exec(conn, toCmdLine("incr", "k"))
+		mdb.Exec(conn, toCmdLine("get", "k"))
+
+		db0, _ := mdb.GetDB(0)
+		if _, ok := db0.GetEntity("k"); ok {
+			t.Fatal("queued command should not be executed before EXEC")
+		}
+
+		reply := mdb.Exec(conn, toCmdLine("exec"))
+		want := "*3\r\n+OK\r\n:2\r\n$1\r\n2\r\n"
+		if string(reply.ToBytes()) != want {
+			t.Errorf("expected %q, got %q", want, reply.ToBytes())
+		}
+		if conn.InMultiState() {
+			t.Error("connection should leave multi state after EXEC")
+		}
+	})
+
+	t.Run("runtime error does not roll back", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		conn := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("set", "str", "abc"))
+		mdb.Exec(conn, toCmdLine("multi"))
+		mdb.Exec(conn, toCmdLine("incr", "str"))
+		mdb.Exec(conn, toCmdLine("set", "other", "1"))
+
+		multi, ok := mdb.Exec(conn, toCmdLine("exec")).(*resp.MultiRawReply)
+		if !ok || len(multi.Replies) != 2 {
+			t.Fatalf("unexpected EXEC reply")
+		}
+		if !resp.IsErrorReply(multi.Replies[0]) {
+			t.Error("INCR on string should fail inside transaction")
+		}
+		if got := string(getBulkValue(mdb.Exec(conn, toCmdLine("get", "other")))); got != "1" {
+			t.Errorf("other commands should still be applied, got %q", got)
+		}
+	})
+
+	t.Run("queue error aborts EXEC", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		conn := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("multi"))
+		mdb.Exec(conn, toCmdLine("set", "k", "v"))
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("nosuchcmd"))); msg == "" {
+			t.Fatal("unknown command should be rejected at queue time")
+		}
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("get"))); msg == "" {
+			t.Fatal("wrong arity should be rejected at queue time")
+		}
+
+		msg := getErrorString(mdb.Exec(conn, toCmdLine("exec")))
+		if msg != "EXECABORT Transaction discarded because of previous errors." {
+			t.Errorf("unexpected error: %q", msg)
+		}
+		if getBulkValue(mdb.Exec(conn, toCmdLine("get", "k"))) != nil {
+			t.Error("aborted transaction should not be applied")
+		}
+	})
+
+	t.Run("DISCARD", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		conn := &MockConnection{}
+
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("discard"))); msg != "ERR DISCARD without MULTI" {
+			t.Errorf("unexpected error: %q", msg)
+		}
+
+		mdb.Exec(conn, toCmdLine("multi"))
+		mdb.Exec(conn, toCmdLine("set", "k", "v"))
+		if reply := mdb.Exec(conn, toCmdLine("discard")); !isOKReply(reply) {
+			t.Fatalf("DISCARD failed: %s", getErrorString(reply))
+		}
+		if getBulkValue(mdb.Exec(conn, toCmdLine("get", "k"))) != nil {
+			t.Error("discarded command should not be applied")
+		}
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("exec"))); msg != "ERR EXEC without MULTI" {
+			t.Errorf("unexpected error: %q", msg)
+		}
+	})
+
+	t.Run("nested MULTI and WATCH inside MULTI", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		conn := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("multi"))
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("multi"))); msg != "ERR MULTI calls can not be nested" {
+			t.Errorf("unexpected error: %q", msg)
+		}
+		if msg := getErrorString(mdb.Exec(conn, toCmdLine("watch", "k"))); msg != "ERR WATCH inside MULTI is not allowed" {
+			t.Errorf("unexpected error: %q", msg)
+		}
+	})
+
+	t.Run("WATCH aborts when key modified by another client", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		conn := &MockConnection{}
+		other := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("set", "stock", "10"))
+		mdb.Exec(conn, toCmdLine("watch", "stock"))
+		mdb.Exec(other, toCmdLine("decr", "stock"))
+
+		mdb.Exec(conn, toCmdLine("multi"))
+		mdb.Exec(conn, toCmdLine("set", "stock", "0"))
+		reply := mdb.Exec(conn, toCmdLine("exec"))
+		if string(reply.ToBytes()) != "*-1\r\n" {
+			t.Fatalf("expected null array, got %q", reply.ToBytes())
+		}
+		if got := string(getBulkValue(mdb.Exec(conn, toCmdLine("get", "stock")))); got != "9" {
+			t.Errorf("aborted transaction should not be applied, got %q", got)
+		}
+		if len(conn.GetWatching()) != 0 {
+			t.Error("EXEC should unwatch all keys")
+		}
+	})
+
+	t.Run("WATCH succeeds when key untouched", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		conn := &MockConnection{}
+		other := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("set", "stock", "10"))
+		mdb.Exec(conn, toCmdLine("watch", "stock", "missing"))
+		mdb.Exec(other, toCmdLine("set", "unrelated", "1"))
+		mdb.Exec(other, toCmdLine("get", "stock"))
+
+		mdb.Exec(conn, toCmdLine("multi"))
+		mdb.Exec(conn, toCmdLine("decr", "stock"))
+		reply := mdb.Exec(conn, toCmdLine("exec"))
+		if string(reply.ToBytes()) != "*1\r\n:9\r\n" {
+			t.Fatalf("unexpected EXEC reply %q", reply.ToBytes())
+		}
+	})
+
+	t.Run("WATCH is bound to selected db and FLUSHALL touches it", func(t *testing.T) {
+		mdb := MakeMultiDB(4, NewMockAOFHandler())
+		conn := &MockConnection{}
+		other := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("watch", "k"))
+		mdb.Exec(other, toCmdLine("select", "1"))
+		mdb.Exec(other, toCmdLine("set", "k", "v"))
+
+		mdb.Exec(conn, toCmdLine("multi"))
+		mdb.Exec(conn, toCmdLine("set", "k", "mine"))
+		if reply := mdb.Exec(conn, toCmdLine("exec")); string(reply.ToBytes()) == "*-1\r\n" {
+			t.Fatal("modifying the same key in another db should not abort EXEC")
+		}
+
+		mdb.Exec(conn, toCmdLine("watch", "k"))
+		mdb.Exec(other, toCmdLine("flushall"))
+		mdb.Exec(conn, toCmdLine("multi"))
+		mdb.Exec(conn, toCmdLine("set", "k", "again"))
+		if reply := mdb.Exec(conn, toCmdLine("exec")); string(reply.ToBytes()) != "*-1\r\n" {
+			t.Fatalf("FLUSHALL should abort EXEC, got %q", reply.ToBytes())
+		}
+	})
+
+	t.Run("transaction is written to AOF as one unit", func(t *testing.T) {
+		aof := NewMockAOFHandler()
+		mdb := MakeMultiDB(4, aof)
+		conn := &MockConnection{}
+
+		mdb.Exec(conn, toCmdLine("multi"))
+		mdb.Exec(conn, toCmdLine("set", "a", "1"))
+		mdb.Exec(conn, toCmdLine("get", "a"))
+		mdb.Exec(conn, toCmdLine("select", "2"))
+		mdb.Exec(conn, toCmdLine("set", "b", "1"))
+		mdb.Exec(conn, toCmdLine("exec"))
+
+		var names []string
+		for _, cmd := range aof.log {
+			names = append(names, string(cmd[0]))
+		}
+		want := []string{"multi", "set", "set", "exec"}
+		if len(names) != len(want) {
+			t.Fatalf("expected %v, got %v", want, names)
+		}
+		if aof.dbIndexes[1] != 0 || aof.dbIndexes[2] != 2 {
+			t.Errorf("transaction commands should keep their db, got %v", aof.dbIndexes)
+		}
+
+		// 回放 MULTI ... EXEC 得到相同的数据
+		replayAOF := NewMockAOFHandler()
+		for _, cmd := range toCmdLines("multi", "set a 1", "select 2", "set b 1", "exec") {
+			replayAOF.AddAOF(0, cmd)
+		}
+		restored := MakeMultiDB(4, replayAOF)
+		db0, _ := restored.GetDB(0)
+		db2, _ := restored.GetDB(2)
+		if _, ok := db0.GetEntity("a"); !ok {
+			t.Error("a should be replayed into db0")
+		}
+		if _, ok := db2.GetEntity("b"); !ok {
+			t.Error("b should be replayed into db2")
+		}
+	})
+}
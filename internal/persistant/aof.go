@@ -2,12 +2,13 @@ package persistant
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
-	"goredis/internal/common"
 	"goredis/internal/resp"
 	"goredis/internal/types"
 	"goredis/pkg/connection"
-	"goredis/pkg/parser"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -26,30 +27,72 @@ const (
 	AOFRewriting = 1
 )
 
+// appendfsync 策略
+const (
+	FsyncAlways   = "always"   // 每次写入都 fsync，落盘后命令才返回
+	FsyncEverySec = "everysec" // 每秒 fsync 一次
+	FsyncNo       = "no"       // 只写入操作系统，由操作系统决定何时落盘
+)
+
+var ErrAOFRewriting = errors.New("aof rewrite already in progress")
+
 type AOFHandlerInterface interface {
-	AddAOF(cmd types.CmdLine)
+	AddAOF(dbIndex int, cmd types.CmdLine)
+	AddTransaction(cmds []TxCmd)
 	HasData() bool
-	Load(replay func(cmd types.CmdLine)) error
-	Rewrite(db types.Database) error
+	Load(getDB func(index int) (types.Database, bool), replay func(cmd types.CmdLine)) error
+	// StartRewrite 切换到新的 incr 文件，调用方需保证此时没有正在执行的写命令，
+	// 并在同一时刻对数据库和函数库做快照交给 FinishRewrite
+	StartRewrite() error
+	FinishRewrite(dbs []types.Database, functions [][]byte) error
 	LogSize() (int64, error)
 }
 
-type AOFHandler struct {
-	path   string
-	file   *os.File      // aof文件
-	writer *bufio.Writer // 缓冲区
-	ch     chan types.CmdLine
+// payload 一条待写入 AOF 的命令及其所属的数据库
+type payload struct {
+	dbIndex int
+	cmdLine types.CmdLine
+	tx      []TxCmd       // 非空时表示一个事务，整体以 MULTI ... EXEC 写入
+	synced  chan struct{} // appendfsync always 时，落盘后关闭
+	rotated chan error    // 非空时表示切换到新的 incr 文件，而不是一条命令
+	offset  chan int64    // 非空时表示查询 offset：之前发送的命令都写入后返回当前 offset
+	stream  []byte        // 非空时表示 slave 收到的复制流，原样写入，dbIndex 是执行之后所在的数据库
+}
+
+// TxCmd 事务中的一条写命令及其执行时所在的数据库
+type TxCmd struct {
+	DBIndex int
+	CmdLine types.CmdLine
+}
 
-	mu          sync.Mutex
-	bufferCount int
-	state       int32
-	rewriteBuf  []types.CmdLine // 存放rewrite期间的新命令
+type AOFHandler struct {
+	dir      string
+	prefix   string       // 文件名前缀，如 db0.aof
+	manifest *aofManifest // 当前生效的文件列表，最后一个 incr 文件正在写入
+	file     *os.File     // 当前 incr 文件
+	writer   *bufio.Writer
+	ch       chan *payload
+
+	mu             sync.Mutex
+	bufferCount    int
+	state          int32
+	rewriteIncrSeq int  // rewrite 开始时新建的 incr 编号，之前的文件会被新的 base 取代
+	currentDB      int  // AOF 流中最后一次 SELECT 的数据库，-1 表示下一条命令前必须 SELECT
+	usePreamble    bool // 重写时以 RDB 快照作为 base（混合持久化）
+	fsync          string
+	synced         []chan struct{} // 已写入、等待下一次 fsync 的命令
 
 	// 主从集群相关字段
-	offset   int64 // 记录当前节点的offset
+	offset   int64 // 记录当前节点的offset，只增不减，rewrite 不影响
 	slavesMu sync.Mutex
-	slaves   map[connection.Connection]struct{}
+	slaves   map[connection.Connection]*slaveWriter
 	backlog  *ReplBacklog
+	// 作为 slave 时，AOF、backlog 和下游 slave 使用 master 发来的原始复制流，offset 与 master 一致
+	replica  bool
+	streamDB int      // 复制流中最后一次 SELECT 的数据库，与 currentDB 不同，AOF 文件之外的数据不会改变它
+	meta     ReplMeta // 复制 ID，连同 offset 写入复制状态文件，见 replMetaSuffix
+	// 上次写入复制状态文件之后 AOF 有新的写入，everysec 定时器据此决定是否更新
+	metaDirty bool
 }
 
 func NewAOFHandler(dir string, dbIndex int) (*AOFHandler, error) {
@@ -57,175 +100,363 @@ func NewAOFHandler(dir string, dbIndex int) (*AOFHandler, error) {
 		return nil, err
 	}
 
-	path := filepath.Join(dir, fmt.Sprintf("db%d.aof", dbIndex))
-	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
-	if err != nil {
-		return nil, err
-	}
-
 	h := &AOFHandler{
-		file:   file,
-		writer: bufio.NewWriter(file),
-		ch:     make(chan types.CmdLine, 4096),
-		path:   path,
+		dir:       dir,
+		prefix:    fmt.Sprintf("db%d.aof", dbIndex),
+		ch:        make(chan *payload, 4096),
+		currentDB: -1,
+		fsync:     FsyncEverySec,
 	}
-	h.slaves = make(map[connection.Connection]struct{})
+	if err := h.openManifest(); err != nil {
+		return nil, err
+	}
+	h.slaves = make(map[connection.Connection]*slaveWriter)
 	h.offset, _ = h.LogSize()
 
 	go h.handle()
 	return h, nil
 }
 
-func (aof *AOFHandler) AddAOF(cmd types.CmdLine) {
-	select {
-	case aof.ch <- cmd:
+func (aof *AOFHandler) manifestPath() string {
+	return filepath.Join(aof.dir, aof.prefix+aofManifestSuffix)
+}
+
+func (aof *AOFHandler) filePath(name string) string {
+	return filepath.Join(aof.dir, name)
+}
+
+// openManifest 读取 manifest 并打开最后一个 incr 文件用于追加；
+// 没有 manifest 时，旧版本的单文件 AOF 作为 base 迁移过来
+func (aof *AOFHandler) openManifest() error {
+	manifest, err := loadManifest(aof.manifestPath())
+	if errors.Is(err, os.ErrNotExist) {
+		manifest, err = aof.migrateLegacy()
+	}
+	if err != nil {
+		return err
+	}
+	for _, f := range manifest.files() {
+		if _, err := os.Stat(aof.filePath(f.name)); err != nil {
+			return fmt.Errorf("aof file listed in manifest: %w", err)
+		}
+	}
+
+	if len(manifest.incrs) == 0 {
+		info := &aofFileInfo{name: incrFileName(aof.prefix, 1), seq: 1, kind: aofTypeIncr}
+		file, err := os.OpenFile(aof.filePath(info.name), os.O_CREATE|os.O_TRUNC|os.O_RDWR|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		file.Close()
+		manifest.incrs = append(manifest.incrs, info)
+		if err := writeManifest(aof.manifestPath(), manifest); err != nil {
+			return err
+		}
+	}
+
+	last := manifest.incrs[len(manifest.incrs)-1]
+	file, err := os.OpenFile(aof.filePath(last.name), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	aof.manifest = manifest
+	aof.file = file
+	aof.writer = bufio.NewWriter(file)
+	return nil
+}
+
+// migrateLegacy 将旧的单文件 AOF 重命名为 base 文件
+func (aof *AOFHandler) migrateLegacy() (*aofManifest, error) {
+	manifest := &aofManifest{}
+	legacy := aof.filePath(aof.prefix)
+	info, err := os.Stat(legacy)
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return manifest, os.Remove(legacy)
+	}
+
+	manifest.base = &aofFileInfo{name: baseFileName(aof.prefix, 1, false), seq: 1, kind: aofTypeBase}
+	if err := os.Rename(legacy, aof.filePath(manifest.base.name)); err != nil {
+		return nil, err
+	}
+	log.Printf("[aof] migrated %s to %s", aof.prefix, manifest.base.name)
+	return manifest, nil
+}
+
+func (aof *AOFHandler) AddAOF(dbIndex int, cmd types.CmdLine) {
+	if !cmd.IsWrite() {
+		return
+	}
+	aof.send(&payload{dbIndex: dbIndex, cmdLine: cmd})
+}
+
+// AddTransaction 将事务作为一个整体写入 AOF，保证 MULTI 与 EXEC 之间不会混入其他客户端的命令
+func (aof *AOFHandler) AddTransaction(cmds []TxCmd) {
+	if len(cmds) == 0 {
+		return
+	}
+	aof.send(&payload{dbIndex: cmds[0].DBIndex, tx: cmds})
+}
+
+// send 将命令交给写入协程。channel 满时阻塞调用方以保证写入顺序；
+// appendfsync always 时等到命令落盘后才返回
+func (aof *AOFHandler) send(p *payload) {
+	aof.mu.Lock()
+	always := aof.fsync == FsyncAlways
+	aof.mu.Unlock()
+
+	if always {
+		p.synced = make(chan struct{})
+	}
+	aof.ch <- p
+	if always {
+		<-p.synced
+	}
+}
+
+// SetFsyncPolicy 设置 appendfsync 策略：always、everysec 或 no
+func (aof *AOFHandler) SetFsyncPolicy(policy string) error {
+	switch policy {
+	case FsyncAlways, FsyncEverySec, FsyncNo:
 	default:
-		go func() {
-			aof.ch <- cmd
-		}()
+		return fmt.Errorf("invalid appendfsync policy %q", policy)
 	}
+	aof.mu.Lock()
+	aof.fsync = policy
+	aof.mu.Unlock()
+	return nil
 }
 
+// SetBacklog 之后写入的命令同时追加到 backlog，全量同步后换成新的 backlog
 func (aof *AOFHandler) SetBacklog(backlog *ReplBacklog) {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
 	aof.backlog = backlog
 }
 
-func (aof *AOFHandler) Rewrite(db types.Database) error {
+// SetUseRDBPreamble 开启后重写生成的 base 为二进制快照，加载更快
+func (aof *AOFHandler) SetUseRDBPreamble(on bool) {
+	aof.mu.Lock()
+	aof.usePreamble = on
+	aof.mu.Unlock()
+}
+
+func (aof *AOFHandler) StartRewrite() error {
 	aof.mu.Lock()
 	if aof.state == AOFRewriting {
 		aof.mu.Unlock()
-		return nil
+		return ErrAOFRewriting
 	}
 	aof.state = AOFRewriting
 	aof.mu.Unlock()
 
-	tmpPath := aof.path + ".tmp"
-	tmpFile, err := os.Create(tmpPath)
-	if err != nil {
+	// 经过 channel 切换文件，保证之前提交的命令都写入旧的 incr 文件
+	done := make(chan error, 1)
+	aof.ch <- &payload{rotated: done}
+	if err := <-done; err != nil {
 		aof.mu.Lock()
 		aof.state = AOFNormal
 		aof.mu.Unlock()
 		return err
 	}
-	writer := bufio.NewWriter(tmpFile)
+	return nil
+}
 
-	// 写快照
-	db.ForEach(func(key string, entity types.RedisData) {
-		var ttl float64
-		if expiredTime, ok := db.GetExpireTime(key); ok {
-			ttl = expiredTime.Sub(time.Now()).Seconds()
-			if ttl <= 0 {
-				return
-			}
-		}
+// rotate 新建 incr 文件并写入 manifest，之后的命令写入新文件
+func (aof *AOFHandler) rotate() error {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
 
-		cmd := entity.ToWriteCmdLine(key)
-		reply := resp.MakeMultiBulkReply(cmd)
-		writer.Write(reply.ToBytes())
-		if ttl > 0 {
-			ttlResp := resp.MakeMultiBulkReply([][]byte{
-				[]byte("expire"),
-				[]byte(key),
-				[]byte(strconv.FormatFloat(ttl, 'f', -1, 64)),
-			})
-			writer.Write(ttlResp.ToBytes())
-		}
-	})
+	aof.flushLocked()
+	seq := aof.manifest.lastIncrSeq() + 1
+	info := &aofFileInfo{name: incrFileName(aof.prefix, seq), seq: seq, kind: aofTypeIncr}
+	path := aof.filePath(info.name)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	manifest := aof.manifest.clone()
+	manifest.incrs = append(manifest.incrs, info)
+	if err := writeManifest(aof.manifestPath(), manifest); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+
+	aof.file.Close()
+	aof.file = file
+	aof.writer = bufio.NewWriter(file)
+	aof.manifest = manifest
+	aof.rewriteIncrSeq = seq
+	// 新文件需要能够单独加载
+	aof.currentDB = -1
+	aof.writeReplMetaLocked()
+	return nil
+}
 
-	// 写 rewrite buffer
+// FinishRewrite 将快照写成新的 base，manifest 中只保留新 base 和 rewrite 开始后的 incr 文件，
+// 旧文件随后删除。失败时 manifest 不变，旧 base 加全部 incr 文件仍然完整
+func (aof *AOFHandler) FinishRewrite(dbs []types.Database, functions [][]byte) error {
 	aof.mu.Lock()
-	for _, cmd := range aof.rewriteBuf {
-		reply := resp.MakeMultiBulkReply(cmd)
-		writer.Write(reply.ToBytes())
+	usePreamble := aof.usePreamble
+	baseSeq := 1
+	if aof.manifest.base != nil {
+		baseSeq = aof.manifest.base.seq + 1
 	}
-	aof.rewriteBuf = nil
 	aof.mu.Unlock()
 
-	if err := writer.Flush(); err != nil {
-		tmpFile.Close()
-		os.Remove(tmpPath)
+	base := &aofFileInfo{name: baseFileName(aof.prefix, baseSeq, usePreamble), seq: baseSeq, kind: aofTypeBase}
+	basePath := aof.filePath(base.name)
+	if err := writeBaseFile(basePath, dbs, functions, usePreamble); err != nil {
 		aof.mu.Lock()
 		aof.state = AOFNormal
 		aof.mu.Unlock()
 		return err
 	}
-	if err := tmpFile.Sync(); err != nil {
-		tmpFile.Close()
-		os.Remove(tmpPath)
-		aof.mu.Lock()
-		aof.state = AOFNormal
-		aof.mu.Unlock()
-		return err
+
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	aof.state = AOFNormal
+
+	old := aof.manifest
+	manifest := &aofManifest{base: base}
+	for _, f := range old.incrs {
+		if f.seq >= aof.rewriteIncrSeq {
+			manifest.incrs = append(manifest.incrs, f)
+		}
 	}
-	if err := tmpFile.Close(); err != nil {
-		os.Remove(tmpPath)
-		aof.mu.Lock()
-		aof.state = AOFNormal
-		aof.mu.Unlock()
+	// rewrite 期间 AOF 被 Reset 过，快照已经过时
+	if len(manifest.incrs) == 0 || manifest.incrs[0].seq != aof.rewriteIncrSeq {
+		os.Remove(basePath)
+		return errors.New("aof was reset during rewrite")
+	}
+	if err := writeManifest(aof.manifestPath(), manifest); err != nil {
+		os.Remove(basePath)
 		return err
 	}
+	aof.manifest = manifest
 
-	aof.mu.Lock()
-	defer aof.mu.Unlock()
+	aof.removeUnlisted(old)
+	// base 替换之后 AOF 的大小变了
+	aof.writeReplMetaLocked()
+	return nil
+}
 
-	// 1. 刷新并关闭当前文件
-	aof.writer.Flush()
-	aof.file.Sync()
-	aof.file.Close()
+// removeUnlisted 删除 old 中已经不在当前 manifest 里的文件
+func (aof *AOFHandler) removeUnlisted(old *aofManifest) {
+	for _, f := range old.files() {
+		if !aof.manifest.contains(f.name) {
+			if err := os.Remove(aof.filePath(f.name)); err != nil {
+				log.Printf("[aof] remove %s failed: %v", f.name, err)
+			}
+		}
+	}
+}
 
-	// 2. 原子重命名（覆盖原文件）
-	if err := os.Rename(tmpPath, aof.path); err != nil {
-		// 回滚：尝试重新打开原文件
-		aof.file, _ = os.OpenFile(aof.path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
-		aof.writer = bufio.NewWriter(aof.file)
-		aof.state = AOFNormal
+// writeBaseFile 写入快照：混合模式下为 RDB，否则为重建数据的命令
+func writeBaseFile(path string, dbs []types.Database, functions [][]byte, usePreamble bool) error {
+	tmpPath := path + ".tmp"
+	tmpFile, err := os.Create(tmpPath)
+	if err != nil {
 		return err
 	}
+	writer := bufio.NewWriter(tmpFile)
 
-	// 3. 重新打开新文件
-	aof.file, err = os.OpenFile(aof.path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+	if usePreamble {
+		err = WriteRDB(writer, dbs, functions)
+	} else {
+		selected := -1
+		writeSnapshotCmds(writer, dbs, functions, &selected)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
 	if err != nil {
-		aof.state = AOFNormal
-		return err
+		os.Remove(tmpPath)
 	}
-	aof.writer = bufio.NewWriter(aof.file)
+	return err
+}
 
-	// 4. 更新 offset（重新计算文件大小）
-	if info, err := aof.file.Stat(); err == nil {
-		aof.offset = info.Size()
-		atomic.StoreInt64(&aof.offset, info.Size())
+// SetReplicaMode 开启后作为 slave：之后由 AppendReplStream 写入 master 的复制流，本地命令只写入 AOF 文件。
+// 关闭时下一条命令前强制 SELECT，使 AOF 文件和复制流所在的数据库重新一致
+func (aof *AOFHandler) SetReplicaMode(on bool) {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	if aof.replica && !on {
+		aof.currentDB = -1
 	}
+	aof.replica = on
+}
 
-	aof.state = AOFNormal
-	return nil
+// AppendReplStream slave 执行 master 发来的一条命令后，把它的原始字节写入 AOF、backlog 并转发给下游 slave，
+// dbIndex 是执行之后所在的数据库
+func (aof *AOFHandler) AppendReplStream(dbIndex int, b []byte) {
+	aof.send(&payload{dbIndex: dbIndex, stream: b})
+}
+
+// StreamDB 返回复制流当前所在的数据库
+func (aof *AOFHandler) StreamDB() int {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	return aof.streamDB
 }
 
 func (aof *AOFHandler) CurrentOffset() int64 {
 	return atomic.LoadInt64(&aof.offset)
 }
 
+// ReplOffset 等待之前提交的命令都写入复制流后返回 offset，WAIT 以它作为 slave 需要确认的位置
+func (aof *AOFHandler) ReplOffset() int64 {
+	done := make(chan int64, 1)
+	aof.ch <- &payload{offset: done}
+	return <-done
+}
+
+// ReadAll 按 manifest 的顺序读出全部数据，返回数据和之后的复制流开始的 offset
 func (aof *AOFHandler) ReadAll() ([]byte, int64, error) {
 	aof.mu.Lock()
 	defer aof.mu.Unlock()
-
-	bytes, err := os.ReadFile(aof.path)
-	if err != nil {
-		return nil, 0, err
-	}
-
-	return bytes, aof.backlog.end - int64(len(bytes)), nil
+	data, err := aof.readAllLocked()
+	return data, aof.CurrentOffset(), err
 }
 
-func (aof *AOFHandler) AddSlave(w connection.Connection) {
-	aof.slavesMu.Lock()
-	defer aof.slavesMu.Unlock()
-	aof.slaves[w] = struct{}{}
-}
+func (aof *AOFHandler) readAllLocked() ([]byte, error) {
+	if err := aof.writer.Flush(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, f := range aof.manifest.files() {
+		content, err := os.ReadFile(aof.filePath(f.name))
+		if err != nil {
+			return nil, err
+		}
+		// slave 只能解析 RESP，快照部分转换成命令后再发送
+		if content, err = preambleToCmds(content); err != nil {
+			return nil, err
+		}
+		buf.Write(content)
+	}
+	// 转发的复制流不一定以 SELECT 开头，数据的最后切换到复制流所在的数据库
+	if aof.replica && aof.currentDB != aof.streamDB {
+		buf.Write(makeSelectCmd(aof.streamDB))
+	}
 
-func (aof *AOFHandler) RemoveSlave(w connection.Connection) {
-	aof.slavesMu.Lock()
-	defer aof.slavesMu.Unlock()
-	delete(aof.slaves, w)
+	return buf.Bytes(), nil
 }
 
 func (aof *AOFHandler) handle() {
@@ -234,156 +465,402 @@ func (aof *AOFHandler) handle() {
 
 	for {
 		select {
-		case cmd := <-aof.ch:
-			if !cmd.IsWrite() {
-				continue
-			}
+		case p := <-aof.ch:
+			pending := aof.process(p)
 
-			if atomic.LoadInt32(&aof.state) == AOFNormal {
-				aof.writeCmd(cmd)
-			} else {
-				// rewrite 期间写入buffer中
-				aof.mu.Lock()
-				aof.rewriteBuf = append(aof.rewriteBuf, cmd)
-				aof.mu.Unlock()
-			}
-			aof.bufferCount++
-
-			if aof.bufferCount >= batchSize {
+			if aof.isAlways() {
+				// 组提交：已经到达的命令一起写入，只 fsync 一次
+				aof.writeQueued(pending)
+				aof.flush()
+			} else if pending >= batchSize {
 				aof.flush()
 			}
 
 		case <-ticker.C:
-			if aof.bufferCount > 0 {
-				aof.flush()
-			}
+			aof.tick()
 		}
 	}
 }
 
-func (aof *AOFHandler) SetState(state int32) {
-	atomic.StoreInt32(&aof.state, state)
+// tick 每秒一次：刷掉缓冲的命令，AOF 有新的写入时更新复制状态文件
+func (aof *AOFHandler) tick() {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	if aof.bufferCount > 0 {
+		aof.flushLocked()
+	}
+	if aof.metaDirty {
+		aof.writeReplMetaLocked()
+	}
+}
+
+// process 写入一条命令或切换 incr 文件，返回尚未 flush 的命令数
+func (aof *AOFHandler) process(p *payload) int {
+	if p.rotated != nil {
+		p.rotated <- aof.rotate()
+		return 0
+	}
+	if p.offset != nil {
+		p.offset <- aof.CurrentOffset()
+		aof.mu.Lock()
+		defer aof.mu.Unlock()
+		return aof.bufferCount
+	}
+	return aof.writeCmd(p)
 }
 
-func (aof *AOFHandler) writeCmd(cmd types.CmdLine) {
-	reply := resp.MakeMultiBulkReply(cmd)
-	b := reply.ToBytes()
+// writeQueued 写入 channel 中已有的命令，最多 batchSize 条
+func (aof *AOFHandler) writeQueued(pending int) {
+	for pending < batchSize {
+		select {
+		case p := <-aof.ch:
+			pending = aof.process(p)
+		default:
+			return
+		}
+	}
+}
 
-	// 原子更新aof的offset和backlog的offset
+func (aof *AOFHandler) isAlways() bool {
 	aof.mu.Lock()
-	n, _ := aof.writer.Write(b)
-	aof.offset += int64(n)
-	if aof.backlog != nil {
-		aof.backlog.Append(b)
+	defer aof.mu.Unlock()
+	return aof.fsync == FsyncAlways
+}
+
+// writeCmd 写入当前 incr 文件，返回尚未 flush 的命令数
+func (aof *AOFHandler) writeCmd(p *payload) int {
+	aof.mu.Lock()
+	if p.stream != nil {
+		aof.writeStreamLocked(p.dbIndex, p.stream)
+	} else {
+		b := encodePayload(p, &aof.currentDB)
+		aof.writer.Write(b)
+		// slave 的复制流只来自 master
+		if !aof.replica {
+			aof.feedLocked(b)
+			aof.streamDB = aof.currentDB
+		}
 	}
+	if p.synced != nil {
+		aof.synced = append(aof.synced, p.synced)
+	}
+	aof.bufferCount++
+	aof.metaDirty = true
+	pending := aof.bufferCount
 	aof.mu.Unlock()
 
-	// 向从节点广播命令
-	for s := range aof.slaves {
-		go func(conn connection.Connection) {
-			if _, err := s.Write(b); err != nil {
-				log.Printf("[aof replication] write cmd failed: %s", err)
-				conn.Close() // 触发slave重连
-				aof.slavesMu.Lock()
-				delete(aof.slaves, s)
-				aof.slavesMu.Unlock()
+	return pending
+}
 
-			}
-		}(s)
+// writeStreamLocked 写入 slave 转发的复制流，文件所在的数据库与复制流不同时（新的 incr 文件、本地写入之后）
+// 先在文件中补一条 SELECT，它不属于复制流
+func (aof *AOFHandler) writeStreamLocked(dbIndex int, b []byte) {
+	if aof.currentDB != aof.streamDB {
+		aof.writer.Write(makeSelectCmd(aof.streamDB))
 	}
+	aof.writer.Write(b)
+	aof.feedLocked(b)
+	aof.currentDB, aof.streamDB = dbIndex, dbIndex
+}
 
+// feedLocked 把复制流计入 offset、写入 backlog 并发给 slave，
+// 在同一个临界区内完成，保证和 FullSync/PartialSync 之间不遗漏、不重复
+func (aof *AOFHandler) feedLocked(b []byte) {
+	atomic.AddInt64(&aof.offset, int64(len(b)))
+	if aof.backlog != nil {
+		aof.backlog.Append(b)
+	}
+	aof.broadcast(b)
 }
 
+// flush 将缓冲写入文件，appendfsync 不为 no 时 fsync，并唤醒等待落盘的命令
 func (h *AOFHandler) flush() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.flushLocked()
+}
+
+func (h *AOFHandler) flushLocked() {
 	if err := h.writer.Flush(); err != nil {
 		log.Printf("aof flush failed: %v", err)
 	}
-	if err := h.file.Sync(); err != nil {
-		log.Printf("aof fsync failed: %v", err)
+	if h.fsync != FsyncNo {
+		if err := h.file.Sync(); err != nil {
+			log.Printf("aof fsync failed: %v", err)
+		}
+	}
+	for _, ch := range h.synced {
+		close(ch)
 	}
+	h.synced = nil
 	h.bufferCount = 0
 }
 
-func (aof *AOFHandler) HasData() bool {
-	info, err := os.Stat(aof.path)
-	if err != nil {
-		return false
-	}
-	return info.Size() > 0
+// Shutdown 关闭前调用：写入之前提交的命令并落盘，更新复制状态文件，重启后可以部分同步
+func (aof *AOFHandler) Shutdown() {
+	aof.ReplOffset()
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	aof.flushLocked()
+	aof.writeReplMetaLocked()
 }
 
-func (aof *AOFHandler) Load(replay func(cmd types.CmdLine)) error {
-	file, err := os.Open(aof.path)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+func (aof *AOFHandler) HasData() bool {
+	size, err := aof.LogSize()
+	return err == nil && size > 0
+}
 
-	parser := parser.NewParser(file)
+// Load 按 manifest 的顺序加载所有文件，快照通过 getDB 直接载入，RESP 命令交给 replay。
+// 文件损坏时回放到第一条损坏的记录为止并返回错误
+func (aof *AOFHandler) Load(getDB func(index int) (types.Database, bool), replay func(cmd types.CmdLine)) error {
+	aof.mu.Lock()
+	files := aof.manifest.files()
+	aof.mu.Unlock()
 
-	for {
-		payload, err := parser.Parse()
+	for _, f := range files {
+		file, err := os.Open(aof.filePath(f.name))
 		if err != nil {
-			// EOF 是正常结束
-			break
+			return err
 		}
-
-		cmdLine, ok := common.ToCmdLine(payload)
-		common.LogBytesArr("aof reload", cmdLine)
-		if !ok {
-			continue
+		result := scanAOF(file, getDB, replay)
+		file.Close()
+		if !result.OK() {
+			result.File = aof.filePath(f.name)
+			return errors.New(result.String())
 		}
+	}
+	return nil
+}
+
+// Check 按 manifest 的顺序检查所有文件
+func (aof *AOFHandler) Check() (*AOFCheckResult, error) {
+	aof.mu.Lock()
+	aof.writer.Flush()
+	files := aof.manifest.files()
+	aof.mu.Unlock()
 
-		replay(cmdLine)
+	return checkAOFFiles(aof.dir, files)
+}
+
+// Truncate 将当前 incr 文件截断到 size 字节，丢弃之后损坏的内容
+func (aof *AOFHandler) Truncate(size int64) error {
+	aof.mu.Lock()
+	if err := aof.writer.Flush(); err != nil {
+		aof.mu.Unlock()
+		return err
+	}
+	if err := aof.file.Truncate(size); err != nil {
+		aof.mu.Unlock()
+		return err
+	}
+	if err := aof.file.Sync(); err != nil {
+		aof.mu.Unlock()
+		return err
+	}
+	// 截断点之前最后一次 SELECT 未知，下一条命令前重新 SELECT
+	aof.currentDB = -1
+	aof.mu.Unlock()
+
+	total, err := aof.LogSize()
+	if err != nil {
+		return err
 	}
+	atomic.StoreInt64(&aof.offset, total)
 	return nil
 }
 
+// LogSize 返回 base 与所有 incr 文件的总大小
 func (aof *AOFHandler) LogSize() (int64, error) {
 	aof.mu.Lock()
 	defer aof.mu.Unlock()
+	return aof.logSizeLocked()
+}
 
-	if aof.file == nil {
+func (aof *AOFHandler) logSizeLocked() (int64, error) {
+	if aof.manifest == nil {
 		return 0, nil
 	}
 
-	info, err := aof.file.Stat()
-	if err != nil {
-		return 0, err
+	var total int64
+	for _, f := range aof.manifest.files() {
+		info, err := os.Stat(aof.filePath(f.name))
+		if err != nil {
+			return 0, err
+		}
+		total += info.Size()
 	}
-	return info.Size(), nil
+	return total, nil
 }
 
-// Reset 清空 AOF 文件并重置内部状态
-func (aof *AOFHandler) Reset(offset int64) error {
+// Reset 全量同步时丢弃所有 AOF 文件，以 master 发来的数据 data 作为新 incr 文件的内容，offset 设为 offset。
+// 复制 ID 随之失效，由调用方重新设置
+func (aof *AOFHandler) Reset(offset int64, data []byte) error {
 	aof.mu.Lock()
 	defer aof.mu.Unlock()
 
-	// 1. 先把缓冲区刷掉，避免旧数据混进去
-	if err := aof.writer.Flush(); err != nil {
-		return fmt.Errorf("flush writer failed: %w", err)
-	}
+	// 1. 先把缓冲区刷掉，唤醒等待落盘的命令；旧的复制状态不再对应 AOF 中的数据
+	aof.flushLocked()
+	aof.removeReplMetaLocked()
 
-	// 2. 截断文件（如果不存在则创建）
-	if err := aof.file.Truncate(0); err != nil {
-		return fmt.Errorf("truncate aof failed: %w", err)
+	// 2. 新建空的 incr 文件，manifest 中只保留它
+	seq := aof.manifest.lastIncrSeq() + 1
+	info := &aofFileInfo{name: incrFileName(aof.prefix, seq), seq: seq, kind: aofTypeIncr}
+	path := aof.filePath(info.name)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("create aof failed: %w", err)
 	}
-
-	// 3. 文件指针回到头部
-	if _, err := aof.file.Seek(0, 0); err != nil {
-		return fmt.Errorf("seek aof failed: %w", err)
+	manifest := &aofManifest{incrs: []*aofFileInfo{info}}
+	if err := writeManifest(aof.manifestPath(), manifest); err != nil {
+		file.Close()
+		os.Remove(path)
+		return fmt.Errorf("write aof manifest failed: %w", err)
 	}
 
-	// 4. 强制落盘
-	if err := aof.file.Sync(); err != nil {
-		return fmt.Errorf("fsync aof failed: %w", err)
-	}
+	// 3. 切换到新文件并删除旧文件
+	aof.file.Close()
+	aof.file = file
+	aof.writer = bufio.NewWriter(file)
+	old := aof.manifest
+	aof.manifest = manifest
+	aof.removeUnlisted(old)
 
-	// 5. 重置内部状态
+	// 4. 重置内部状态，master 在全量数据之后的第一条命令前会 SELECT
 	aof.bufferCount = 0
-	aof.offset = offset
-	aof.rewriteBuf = make([]types.CmdLine, 0)
+	aof.currentDB = -1
+	aof.streamDB = 0
 	atomic.StoreInt64(&aof.offset, offset)
 
+	// 5. 写入全量数据
+	if _, err := aof.writer.Write(data); err != nil {
+		return fmt.Errorf("write aof failed: %w", err)
+	}
+	aof.flushLocked()
 	return nil
 }
+
+// encodePayload 将命令编码为 RESP，数据库与 selected 不一致时在前面补一条 SELECT
+func encodePayload(p *payload, selected *int) []byte {
+	if p.tx != nil {
+		return encodeTransaction(p, selected)
+	}
+
+	b := resp.MakeMultiBulkReply(p.cmdLine).ToBytes()
+	if p.dbIndex == *selected {
+		return b
+	}
+	*selected = p.dbIndex
+	return append(makeSelectCmd(p.dbIndex), b...)
+}
+
+// encodeTransaction 编码为 [SELECT] MULTI cmd... EXEC，事务内切库的 SELECT 会在 EXEC 时一起执行
+func encodeTransaction(p *payload, selected *int) []byte {
+	var b []byte
+	if p.dbIndex != *selected {
+		*selected = p.dbIndex
+		b = append(b, makeSelectCmd(p.dbIndex)...)
+	}
+
+	b = append(b, resp.MakeMultiBulkReply([][]byte{[]byte("multi")}).ToBytes()...)
+	for _, cmd := range p.tx {
+		b = append(b, encodePayload(&payload{dbIndex: cmd.DBIndex, cmdLine: cmd.CmdLine}, selected)...)
+	}
+	b = append(b, resp.MakeMultiBulkReply([][]byte{[]byte("exec")}).ToBytes()...)
+	return b
+}
+
+// writeSnapshotCmds 将函数库和数据库当前的数据以 RESP 命令的形式写入 writer
+func writeSnapshotCmds(writer io.Writer, dbs []types.Database, functions [][]byte, selected *int) {
+	for _, code := range functions {
+		writer.Write(makeFunctionLoadCmd(code))
+	}
+	for _, db := range dbs {
+		db.ForEach(func(key string, entity types.RedisData) {
+			var expireAt time.Time
+			if expiredTime, ok := db.GetExpireTime(key); ok {
+				if !time.Now().Before(expiredTime) {
+					return
+				}
+				expireAt = expiredTime
+			}
+
+			if *selected != db.GetDBIndex() {
+				*selected = db.GetDBIndex()
+				writer.Write(makeSelectCmd(*selected))
+			}
+			writer.Write(makeEntityCmds(key, entity, expireAt))
+		})
+	}
+}
+
+// makeEntityCmds 生成重建 key 的命令，expireAt 非零时追加 PEXPIREAT。
+// 使用绝对时间，重放时不会延长 key 的生存时间
+func makeEntityCmds(key string, entity types.RedisData, expireAt time.Time) []byte {
+	var b []byte
+	if mw, ok := entity.(types.MultiCmdWriter); ok {
+		for _, cmdLine := range mw.ToWriteCmdLines(key) {
+			b = append(b, resp.MakeMultiBulkReply(cmdLine).ToBytes()...)
+		}
+	} else {
+		b = resp.MakeMultiBulkReply(entity.ToWriteCmdLine(key)).ToBytes()
+	}
+	if !expireAt.IsZero() {
+		b = append(b, resp.MakeMultiBulkReply([][]byte{
+			[]byte("pexpireat"),
+			[]byte(key),
+			[]byte(strconv.FormatInt(expireAt.UnixMilli(), 10)),
+		}).ToBytes()...)
+	}
+	return b
+}
+
+// preambleToCmds 将 AOF 开头的快照转换为等价的 RESP 命令，没有快照时原样返回
+func preambleToCmds(content []byte) ([]byte, error) {
+	reader := bufio.NewReader(bytes.NewReader(content))
+	if !hasRDBPreamble(reader) {
+		return content, nil
+	}
+	functions, records, err := readRDBRecords(reader)
+	if err != nil {
+		return nil, fmt.Errorf("aof preamble: %w", err)
+	}
+
+	var buf bytes.Buffer
+	for _, code := range functions {
+		buf.Write(makeFunctionLoadCmd(code))
+	}
+	selected := -1
+	for _, rec := range records {
+		var expireAt time.Time
+		if rec.hasTTL {
+			if !time.Now().Before(rec.expireAt) {
+				continue
+			}
+			expireAt = rec.expireAt
+		}
+		if selected != rec.dbIndex {
+			selected = rec.dbIndex
+			buf.Write(makeSelectCmd(selected))
+		}
+		buf.Write(makeEntityCmds(rec.key, rec.entity.Data.(types.RedisData), expireAt))
+	}
+	// 增量部分以 SELECT 开头，不依赖快照最后选中的数据库
+	buf.ReadFrom(reader)
+	return buf.Bytes(), nil
+}
+
+// functionLoadCmdLine 重建函数库的命令，使用 REPLACE 保证重复加载不会失败
+func functionLoadCmdLine(code []byte) types.CmdLine {
+	return types.CmdLine{[]byte("function"), []byte("load"), []byte("replace"), code}
+}
+
+func makeFunctionLoadCmd(code []byte) []byte {
+	return resp.MakeMultiBulkReply(functionLoadCmdLine(code)).ToBytes()
+}
+
+func makeSelectCmd(dbIndex int) []byte {
+	return resp.MakeMultiBulkReply([][]byte{
+		[]byte("select"),
+		[]byte(strconv.Itoa(dbIndex)),
+	}).ToBytes()
+}
@@ -0,0 +1,219 @@
+package persistant
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"goredis/internal/common"
+	"goredis/internal/types"
+	"goredis/pkg/parser"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	errMultiNotClosed = errors.New("unexpected end of file inside MULTI")
+	errNotCommand     = errors.New("record is not a command")
+)
+
+// AOFCheckResult AOF 文件的检查结果
+type AOFCheckResult struct {
+	File      string // 检查多个文件时，为损坏的文件或最后一个文件
+	Size      int64  // 文件大小
+	Commands  int    // 完整的命令数，不含快照部分
+	Preamble  bool   // 是否以 RDB 快照开头
+	ValidSize int64  // 修复时截断到的位置：最后一条完整命令（或事务）结束处
+
+	// 以下字段只在文件损坏时有意义
+	Err        error // 第一条损坏记录的原因，nil 表示文件完好
+	Offset     int64 // 第一条损坏记录的起始字节
+	Index      int   // 第一条损坏记录是第几条命令，从 1 开始
+	Truncated  bool  // 只是结尾不完整（写入中途宕机），之前的内容都是完好的
+	InPreamble bool  // 损坏发生在快照部分，无法通过截断修复
+	NotLast    bool  // 损坏的不是最后一个文件，截断会丢失之后文件中的数据
+}
+
+func (r *AOFCheckResult) OK() bool {
+	return r.Err == nil
+}
+
+// Fixable 截断到 ValidSize 后文件是否可用
+func (r *AOFCheckResult) Fixable() bool {
+	return !r.OK() && !r.InPreamble && !r.NotLast
+}
+
+func (r *AOFCheckResult) String() string {
+	if r.OK() {
+		if r.Preamble {
+			return fmt.Sprintf("AOF is valid: %d bytes, RDB preamble and %d commands", r.Size, r.Commands)
+		}
+		return fmt.Sprintf("AOF is valid: %d bytes, %d commands", r.Size, r.Commands)
+	}
+	name := "AOF"
+	if r.File != "" {
+		name = "AOF file " + filepath.Base(r.File)
+	}
+	if r.InPreamble {
+		return fmt.Sprintf("%s RDB preamble is corrupted: %v", name, r.Err)
+	}
+	return fmt.Sprintf("%s corrupted at offset %d (command #%d): %v, %d of %d bytes are valid",
+		name, r.Offset, r.Index, r.Err, r.ValidSize, r.Size)
+}
+
+// CheckAOFFile 检查 path 指向的 AOF 文件
+func CheckAOFFile(path string) (*AOFCheckResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return scanAOF(file, nil, nil), nil
+}
+
+// CheckAOFManifest 按 manifest 的顺序检查其中列出的所有文件
+func CheckAOFManifest(path string) (*AOFCheckResult, error) {
+	manifest, err := loadManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	return checkAOFFiles(filepath.Dir(path), manifest.files())
+}
+
+// checkAOFFiles 依次检查 dir 下的文件，返回第一个损坏文件的结果；都完好时汇总大小和命令数
+func checkAOFFiles(dir string, files []*aofFileInfo) (*AOFCheckResult, error) {
+	total := &AOFCheckResult{}
+	for i, f := range files {
+		path := filepath.Join(dir, f.name)
+		result, err := CheckAOFFile(path)
+		if err != nil {
+			return nil, err
+		}
+		result.File = path
+		if !result.OK() {
+			result.NotLast = i != len(files)-1
+			return result, nil
+		}
+		total.File = path
+		total.Size += result.Size
+		total.Commands += result.Commands
+		total.Preamble = total.Preamble || result.Preamble
+	}
+	return total, nil
+}
+
+// TruncateAOFFile 将 AOF 截断到 size 字节，用于丢弃损坏的结尾
+func TruncateAOFFile(path string, size int64) error {
+	return os.Truncate(path, size)
+}
+
+// countingReader 记录从底层读取的字节数
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// scanAOF 逐条解析 AOF，遇到第一条损坏的记录时停止。
+// getDB 非空时将快照载入数据库，replay 非空时回放每条完整的命令
+func scanAOF(in io.Reader, getDB func(index int) (types.Database, bool), replay func(cmd types.CmdLine)) *AOFCheckResult {
+	counter := &countingReader{r: in}
+	reader := bufio.NewReader(counter)
+	// 已经解析掉的字节数；parser 会直接复用 reader，不会再套一层缓冲
+	offset := func() int64 {
+		return counter.n - int64(reader.Buffered())
+	}
+
+	result := &AOFCheckResult{}
+	// 读到文件末尾以得到文件大小
+	finish := func() *AOFCheckResult {
+		io.Copy(io.Discard, reader)
+		result.Size = counter.n
+		return result
+	}
+
+	if hasRDBPreamble(reader) {
+		result.Preamble = true
+		var err error
+		if getDB != nil {
+			// 快照中的函数库转换成 FUNCTION LOAD 回放
+			err = ReadRDB(reader, getDB, func(code []byte) error {
+				if replay != nil {
+					replay(functionLoadCmdLine(code))
+				}
+				return nil
+			})
+		} else {
+			_, _, err = readRDBRecords(reader)
+		}
+		if err != nil {
+			result.Err = fmt.Errorf("rdb preamble: %w", err)
+			result.InPreamble = true
+			return finish()
+		}
+	}
+	result.ValidSize = offset()
+
+	p := parser.NewParser(reader)
+	multiStart, multiIndex := int64(-1), 0
+	for {
+		start := offset()
+		payload, err := p.Parse()
+		if err == io.EOF && offset() == start {
+			// 正常结束，但最后一个事务没有 EXEC
+			if multiStart >= 0 {
+				result.Err = errMultiNotClosed
+				result.Offset = multiStart
+				result.Index = multiIndex
+				result.Truncated = true
+			}
+			return finish()
+		}
+		var cmdLine [][]byte
+		if err == nil {
+			// AOF 中只能是命令数组
+			if _, isArray := payload.([]interface{}); isArray {
+				cmdLine, _ = common.ToCmdLine(payload)
+			}
+			if len(cmdLine) == 0 {
+				err = errNotCommand
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			// 解析一直读到了文件末尾，说明只是最后一条记录没写完
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				result.Truncated = true
+			}
+			result.Err = err
+			result.Offset = start
+			result.Index = result.Commands + 1
+			return finish()
+		}
+
+		result.Commands++
+		switch strings.ToLower(string(cmdLine[0])) {
+		case "multi":
+			multiStart, multiIndex = start, result.Commands
+		case "exec":
+			multiStart = -1
+		}
+		if multiStart < 0 {
+			result.ValidSize = offset()
+		}
+
+		if replay != nil {
+			common.LogBytesArr("aof reload", cmdLine)
+			replay(cmdLine)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package persistant
+
+import (
+	"bytes"
+	"fmt"
+	"goredis/internal/resp"
+	"goredis/internal/types"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func encodeCmds(cmds ...string) []byte {
+	var b []byte
+	for _, cmd := range cmds {
+		var args [][]byte
+		for _, arg := range strings.Fields(cmd) {
+			args = append(args, []byte(arg))
+		}
+		b = append(b, resp.MakeMultiBulkReply(args).ToBytes()...)
+	}
+	return b
+}
+
+func TestCheckAOF(t *testing.T) {
+	valid := encodeCmds("select 0", "set a 1", "multi", "set b 2", "exec")
+	lastStart := int64(len(encodeCmds("select 0", "set a 1", "multi", "set b 2")))
+
+	t.Run("valid file", func(t *testing.T) {
+		result := scanAOF(bytes.NewReader(valid), nil, nil)
+		if !result.OK() || result.Commands != 5 || result.Size != int64(len(valid)) || result.ValidSize != result.Size {
+			t.Errorf("unexpected result: %+v", result)
+		}
+	})
+
+	t.Run("truncated tail", func(t *testing.T) {
+		raw := append(encodeCmds("set a 1"), encodeCmds("set b 2")[:10]...)
+		result := scanAOF(bytes.NewReader(raw), nil, nil)
+		if result.OK() || !result.Truncated || !result.Fixable() {
+			t.Fatalf("expected fixable truncation: %+v", result)
+		}
+		if want := int64(len(encodeCmds("set a 1"))); result.Offset != want || result.ValidSize != want || result.Index != 2 {
+			t.Errorf("expected command #2 at offset %d, got %+v", want, result)
+		}
+		if result.Err != io.ErrUnexpectedEOF {
+			t.Errorf("unexpected error: %v", result.Err)
+		}
+	})
+
+	t.Run("corrupted middle", func(t *testing.T) {
+		raw := append([]byte(nil), valid...)
+		raw[len(encodeCmds("select 0"))] = '?'
+		result := scanAOF(bytes.NewReader(raw), nil, nil)
+		if result.OK() || result.Truncated {
+			t.Fatalf("expected corruption in the middle: %+v", result)
+		}
+		if want := int64(len(encodeCmds("select 0"))); result.Offset != want || result.Index != 2 || result.ValidSize != want {
+			t.Errorf("unexpected position: %+v", result)
+		}
+		if !strings.Contains(result.String(), fmt.Sprintf("offset %d (command #2)", result.Offset)) {
+			t.Errorf("report should contain offset and index: %s", result)
+		}
+	})
+
+	t.Run("transaction without EXEC", func(t *testing.T) {
+		raw := valid[:lastStart]
+		result := scanAOF(bytes.NewReader(raw), nil, nil)
+		multiStart := int64(len(encodeCmds("select 0", "set a 1")))
+		if result.Err != errMultiNotClosed || !result.Truncated || result.Offset != multiStart || result.Index != 3 {
+			t.Errorf("unexpected result: %+v", result)
+		}
+		if result.ValidSize != multiStart {
+			t.Errorf("should truncate before MULTI, got %d", result.ValidSize)
+		}
+
+		// 事务中间的命令损坏时也截断到 MULTI 之前
+		raw = append(append([]byte(nil), valid[:lastStart]...), "*1\r\n$4\r\nex"...)
+		if result := scanAOF(bytes.NewReader(raw), nil, nil); result.ValidSize != multiStart || result.Offset != lastStart {
+			t.Errorf("unexpected result: %+v", result)
+		}
+	})
+
+	t.Run("non-command record", func(t *testing.T) {
+		raw := append(encodeCmds("set a 1"), "+OK\r\n"...)
+		if result := scanAOF(bytes.NewReader(raw), nil, nil); result.Err != errNotCommand || result.Index != 2 {
+			t.Errorf("unexpected result: %+v", result)
+		}
+	})
+
+	t.Run("RDB preamble", func(t *testing.T) {
+		var buf bytes.Buffer
+		WriteRDB(&buf, newSnapshotDBs(), nil)
+		preambleSize := int64(buf.Len())
+		buf.Write(encodeCmds("select 0", "set tail 1"))
+
+		result := scanAOF(bytes.NewReader(buf.Bytes()), nil, nil)
+		if !result.OK() || !result.Preamble || result.Commands != 2 {
+			t.Errorf("unexpected result: %+v", result)
+		}
+
+		truncated := buf.Bytes()[:buf.Len()-3]
+		result = scanAOF(bytes.NewReader(truncated), nil, nil)
+		if !result.Truncated || result.Offset != preambleSize+int64(len(encodeCmds("select 0"))) {
+			t.Errorf("offset should count the preamble: %+v", result)
+		}
+
+		corrupted := append([]byte(nil), buf.Bytes()...)
+		corrupted[len(rdbMagic)+len(rdbVersion)+5] ^= 0xFF
+		result = scanAOF(bytes.NewReader(corrupted), nil, nil)
+		if !result.InPreamble || result.Fixable() {
+			t.Errorf("corrupted preamble should not be fixable: %+v", result)
+		}
+	})
+
+	t.Run("Load stops at corruption and Truncate repairs", func(t *testing.T) {
+		dir := t.TempDir()
+		aof, err := NewAOFHandler(dir, 0)
+		if err != nil {
+			t.Fatalf("NewAOFHandler failed: %v", err)
+		}
+		defer aof.file.Close()
+
+		aof.AddAOF(0, [][]byte{[]byte("set"), []byte("a"), []byte("1")})
+		time.Sleep(100 * time.Millisecond)
+		aof.flush()
+		// 模拟写到一半宕机
+		incrPath := aof.filePath(aof.manifest.incrs[0].name)
+		f, _ := os.OpenFile(incrPath, os.O_APPEND|os.O_WRONLY, 0644)
+		f.Write([]byte("*3\r\n$3\r\nset"))
+		f.Close()
+
+		var replayed []string
+		err = aof.Load(nil, func(cmd types.CmdLine) {
+			replayed = append(replayed, string(cmd[0]))
+		})
+		if err == nil || len(replayed) != 2 {
+			t.Fatalf("Load should replay valid prefix and fail, got %v %v", replayed, err)
+		}
+
+		result, err := aof.Check()
+		if err != nil || !result.Truncated || !result.Fixable() || result.File != incrPath {
+			t.Fatalf("unexpected check result %+v %v", result, err)
+		}
+		if err := aof.Truncate(result.ValidSize); err != nil {
+			t.Fatalf("Truncate failed: %v", err)
+		}
+		aof.AddAOF(0, [][]byte{[]byte("set"), []byte("b"), []byte("2")})
+		time.Sleep(100 * time.Millisecond)
+		aof.flush()
+
+		repaired, err := CheckAOFManifest(filepath.Join(dir, "db0.aof.manifest"))
+		if err != nil || !repaired.OK() || repaired.Commands != 4 {
+			t.Errorf("repaired file should be valid: %+v %v", repaired, err)
+		}
+		if aof.CurrentOffset() != repaired.Size {
+			t.Errorf("offset should follow the truncated file, got %d want %d", aof.CurrentOffset(), repaired.Size)
+		}
+	})
+
+	t.Run("corruption before the last file is not fixable", func(t *testing.T) {
+		dir := t.TempDir()
+		// 旧版本的单文件 AOF 会被迁移为 base
+		os.WriteFile(filepath.Join(dir, "db0.aof"), append(encodeCmds("set a 1"), "*3\r\n$3\r\nset"...), 0644)
+		aof, err := NewAOFHandler(dir, 0)
+		if err != nil {
+			t.Fatalf("NewAOFHandler failed: %v", err)
+		}
+		defer aof.file.Close()
+
+		result, err := aof.Check()
+		if err != nil || result.OK() || !result.NotLast || result.Fixable() {
+			t.Errorf("corrupted base should not be fixable: %+v %v", result, err)
+		}
+		if !strings.Contains(result.String(), "db0.aof.1.base.aof") {
+			t.Errorf("report should name the corrupted file: %s", result)
+		}
+	})
+}
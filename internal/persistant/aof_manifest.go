@@ -0,0 +1,175 @@
+package persistant
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// 多文件 AOF：一个 base 文件（重写时的快照）加若干编号递增的 incr 文件（之后的增量命令），
+// 由 manifest 记录加载顺序，每行一个文件：
+//
+//	file <name> seq <n> type <b|i>
+//
+// rewrite 时只新建 incr 文件并原子替换 manifest，正在写入的文件不会被关闭或覆盖
+const (
+	aofTypeBase = "b"
+	aofTypeIncr = "i"
+
+	aofManifestSuffix = ".manifest"
+)
+
+// aofFileInfo manifest 中的一个文件
+type aofFileInfo struct {
+	name string
+	seq  int
+	kind string
+}
+
+type aofManifest struct {
+	base  *aofFileInfo // 为 nil 表示还没有重写过
+	incrs []*aofFileInfo
+}
+
+// files 按加载顺序返回所有文件
+func (m *aofManifest) files() []*aofFileInfo {
+	var files []*aofFileInfo
+	if m.base != nil {
+		files = append(files, m.base)
+	}
+	return append(files, m.incrs...)
+}
+
+func (m *aofManifest) contains(name string) bool {
+	for _, f := range m.files() {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *aofManifest) lastIncrSeq() int {
+	if len(m.incrs) == 0 {
+		return 0
+	}
+	return m.incrs[len(m.incrs)-1].seq
+}
+
+func (m *aofManifest) clone() *aofManifest {
+	return &aofManifest{
+		base:  m.base,
+		incrs: append([]*aofFileInfo(nil), m.incrs...),
+	}
+}
+
+func (m *aofManifest) encode() []byte {
+	var buf bytes.Buffer
+	for _, f := range m.files() {
+		fmt.Fprintf(&buf, "file %s seq %d type %s\n", f.name, f.seq, f.kind)
+	}
+	return buf.Bytes()
+}
+
+func parseManifest(content []byte) (*aofManifest, error) {
+	m := &aofManifest{}
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 6 || fields[0] != "file" || fields[2] != "seq" || fields[4] != "type" {
+			return nil, fmt.Errorf("aof manifest: invalid line %d: %q", i+1, line)
+		}
+		seq, err := strconv.Atoi(fields[3])
+		if err != nil || seq <= 0 {
+			return nil, fmt.Errorf("aof manifest: invalid seq on line %d: %q", i+1, line)
+		}
+		if strings.ContainsAny(fields[1], `/\`) {
+			return nil, fmt.Errorf("aof manifest: invalid file name on line %d: %q", i+1, line)
+		}
+
+		f := &aofFileInfo{name: fields[1], seq: seq, kind: fields[5]}
+		switch f.kind {
+		case aofTypeBase:
+			if m.base != nil {
+				return nil, errors.New("aof manifest: more than one base file")
+			}
+			m.base = f
+		case aofTypeIncr:
+			if seq <= m.lastIncrSeq() {
+				return nil, fmt.Errorf("aof manifest: incr files out of order on line %d", i+1)
+			}
+			m.incrs = append(m.incrs, f)
+		default:
+			return nil, fmt.Errorf("aof manifest: unknown file type on line %d: %q", i+1, line)
+		}
+	}
+	return m, nil
+}
+
+// loadManifest 读取 manifest，文件不存在时返回 os.ErrNotExist
+func loadManifest(path string) (*aofManifest, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseManifest(content)
+}
+
+// writeManifest 先写临时文件再重命名，保证 manifest 总是完整的
+func writeManifest(path string, m *aofManifest) error {
+	tmpPath := path + ".tmp"
+	if err := writeFileSync(tmpPath, m.encode()); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func writeFileSync(path string, content []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// syncDir 让目录中的新建和重命名落盘
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// baseFileName 快照格式的 base 以 .rdb 结尾，命令格式的以 .aof 结尾
+func baseFileName(prefix string, seq int, rdb bool) string {
+	if rdb {
+		return fmt.Sprintf("%s.%d.base.rdb", prefix, seq)
+	}
+	return fmt.Sprintf("%s.%d.base.aof", prefix, seq)
+}
+
+func incrFileName(prefix string, seq int) string {
+	return fmt.Sprintf("%s.%d.incr.aof", prefix, seq)
+}
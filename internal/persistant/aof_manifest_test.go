@@ -0,0 +1,221 @@
+package persistant
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"goredis/internal/data"
+	"goredis/internal/types"
+)
+
+func TestAOFManifest(t *testing.T) {
+	t.Run("encode and parse", func(t *testing.T) {
+		m := &aofManifest{
+			base: &aofFileInfo{name: "db0.aof.2.base.rdb", seq: 2, kind: aofTypeBase},
+			incrs: []*aofFileInfo{
+				{name: "db0.aof.3.incr.aof", seq: 3, kind: aofTypeIncr},
+				{name: "db0.aof.4.incr.aof", seq: 4, kind: aofTypeIncr},
+			},
+		}
+		want := "file db0.aof.2.base.rdb seq 2 type b\nfile db0.aof.3.incr.aof seq 3 type i\nfile db0.aof.4.incr.aof seq 4 type i\n"
+		if got := string(m.encode()); got != want {
+			t.Fatalf("unexpected manifest %q", got)
+		}
+
+		parsed, err := parseManifest([]byte(want))
+		if err != nil {
+			t.Fatalf("parseManifest failed: %v", err)
+		}
+		if string(parsed.encode()) != want || parsed.lastIncrSeq() != 4 {
+			t.Errorf("round trip mismatch: %q", parsed.encode())
+		}
+	})
+
+	t.Run("invalid manifest", func(t *testing.T) {
+		invalid := []string{
+			"file a seq 1",
+			"file a seq x type i",
+			"file a seq 1 type x",
+			"file a seq 1 type b\nfile b seq 2 type b",
+			"file a seq 2 type i\nfile b seq 1 type i",
+			"file ../a seq 1 type i",
+		}
+		for _, content := range invalid {
+			if _, err := parseManifest([]byte(content)); err == nil {
+				t.Errorf("expected error for %q", content)
+			}
+		}
+	})
+}
+
+func waitFlushed(aof *AOFHandler) {
+	time.Sleep(100 * time.Millisecond)
+	aof.flush()
+}
+
+func setCmd(key, value string) [][]byte {
+	return [][]byte{[]byte("set"), []byte(key), []byte(value)}
+}
+
+// replayedKeys 重新打开目录，返回加载时回放的 SET 命令的 key
+func replayedKeys(t *testing.T, dir string) []string {
+	t.Helper()
+	aof, err := NewAOFHandler(dir, 0)
+	if err != nil {
+		t.Fatalf("NewAOFHandler failed: %v", err)
+	}
+	defer aof.file.Close()
+
+	db := NewMockDB(0)
+	var keys []string
+	err = aof.Load(func(int) (types.Database, bool) { return db, true }, func(cmd types.CmdLine) {
+		if string(cmd[0]) == "set" {
+			keys = append(keys, string(cmd[1]))
+		}
+	})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	for key := range db.data {
+		keys = append(keys, "base:"+key)
+	}
+	return keys
+}
+
+func TestMultiPartAOF(t *testing.T) {
+	t.Run("rewrite switches incr file and keeps offset", func(t *testing.T) {
+		dir := t.TempDir()
+		aof, err := NewAOFHandler(dir, 0)
+		if err != nil {
+			t.Fatalf("NewAOFHandler failed: %v", err)
+		}
+		aof.SetUseRDBPreamble(true)
+
+		aof.AddAOF(0, setCmd("before", "1"))
+		waitFlushed(aof)
+		beforeRewrite := aof.CurrentOffset()
+		oldIncr := aof.filePath(aof.manifest.incrs[0].name)
+
+		if err := aof.StartRewrite(); err != nil {
+			t.Fatalf("StartRewrite failed: %v", err)
+		}
+		if err := aof.StartRewrite(); err != ErrAOFRewriting {
+			t.Errorf("expected ErrAOFRewriting, got %v", err)
+		}
+		// 快照在 StartRewrite 时刻生成，之后的命令只在新的 incr 文件中
+		snapshot := NewMockDB(0)
+		snapshot.PutEntity("before", &types.DataEntity{Data: data.NewStringFromBytes([]byte("1"))})
+		aof.AddAOF(0, setCmd("during", "1"))
+		waitFlushed(aof)
+
+		if err := aof.FinishRewrite([]types.Database{snapshot}, nil); err != nil {
+			t.Fatalf("FinishRewrite failed: %v", err)
+		}
+		aof.AddAOF(0, setCmd("after", "1"))
+		waitFlushed(aof)
+
+		during := int64(len(encodeCmds("select 0", "set during 1")))
+		after := int64(len(encodeCmds("set after 1")))
+		if got := aof.CurrentOffset(); got != beforeRewrite+during+after {
+			t.Errorf("offset should only grow by new commands: before=%d now=%d", beforeRewrite, got)
+		}
+		if _, err := os.Stat(oldIncr); !os.IsNotExist(err) {
+			t.Error("old incr file should be removed")
+		}
+		manifest, _ := os.ReadFile(filepath.Join(dir, "db0.aof.manifest"))
+		if string(manifest) != "file db0.aof.1.base.rdb seq 1 type b\nfile db0.aof.2.incr.aof seq 2 type i\n" {
+			t.Errorf("unexpected manifest %q", manifest)
+		}
+		aof.file.Close()
+
+		keys := replayedKeys(t, dir)
+		if strings.Join(keys, ",") != "during,after,base:before" {
+			t.Errorf("unexpected keys after reload: %v", keys)
+		}
+	})
+
+	t.Run("crash during rewrite keeps all files", func(t *testing.T) {
+		dir := t.TempDir()
+		aof, err := NewAOFHandler(dir, 0)
+		if err != nil {
+			t.Fatalf("NewAOFHandler failed: %v", err)
+		}
+
+		aof.AddAOF(0, setCmd("a", "1"))
+		waitFlushed(aof)
+		aof.StartRewrite()
+		aof.AddAOF(0, setCmd("b", "1"))
+		waitFlushed(aof)
+		// 没有调用 FinishRewrite 就退出
+		aof.file.Close()
+
+		if keys := replayedKeys(t, dir); strings.Join(keys, ",") != "a,b" {
+			t.Errorf("all commands should be replayed in order, got %v", keys)
+		}
+	})
+
+	t.Run("failed rewrite keeps manifest", func(t *testing.T) {
+		dir := t.TempDir()
+		aof, err := NewAOFHandler(dir, 0)
+		if err != nil {
+			t.Fatalf("NewAOFHandler failed: %v", err)
+		}
+		aof.SetUseRDBPreamble(true)
+		aof.AddAOF(0, setCmd("a", "1"))
+		waitFlushed(aof)
+
+		bad := NewMockDB(0)
+		bad.PutEntity("bad", &types.DataEntity{Data: &unknownData{}})
+		if err := rewrite(aof, []types.Database{bad}); err == nil {
+			t.Fatal("unsupported type should fail")
+		}
+		if aof.manifest.base != nil || len(aof.manifest.incrs) != 2 {
+			t.Errorf("manifest should keep all incr files: %q", aof.manifest.encode())
+		}
+		if err := aof.StartRewrite(); err != nil {
+			t.Errorf("rewrite should be possible again: %v", err)
+		}
+		aof.file.Close()
+	})
+
+	t.Run("legacy single file is migrated", func(t *testing.T) {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "db0.aof"), encodeCmds("select 0", "set legacy 1"), 0644)
+
+		if keys := replayedKeys(t, dir); strings.Join(keys, ",") != "legacy" {
+			t.Errorf("legacy AOF should be loaded, got %v", keys)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "db0.aof.1.base.aof")); err != nil {
+			t.Error("legacy AOF should become the base file")
+		}
+		if _, err := os.Stat(filepath.Join(dir, "db0.aof")); !os.IsNotExist(err) {
+			t.Error("legacy AOF should be renamed")
+		}
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		dir := t.TempDir()
+		aof, err := NewAOFHandler(dir, 0)
+		if err != nil {
+			t.Fatalf("NewAOFHandler failed: %v", err)
+		}
+		defer aof.file.Close()
+		aof.AddAOF(0, setCmd("a", "1"))
+		waitFlushed(aof)
+		rewrite(aof, []types.Database{NewMockDB(0)})
+
+		if err := aof.Reset(100, nil); err != nil {
+			t.Fatalf("Reset failed: %v", err)
+		}
+		if aof.HasData() || aof.CurrentOffset() != 100 {
+			t.Errorf("AOF should be empty with offset 100, got offset %d", aof.CurrentOffset())
+		}
+		entries, _ := os.ReadDir(dir)
+		if len(entries) != 2 {
+			t.Errorf("only manifest and one incr file should remain, got %d entries", len(entries))
+		}
+	})
+}
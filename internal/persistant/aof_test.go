@@ -2,11 +2,17 @@ package persistant
 
 import (
 	"bytes"
+	"goredis/internal/common"
 	"goredis/internal/resp"
 	"goredis/internal/types"
 	"goredis/pkg/connection"
+	"goredis/pkg/parser"
+	"io"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -79,10 +85,40 @@ func (m *MockDB) Exec(c connection.Connection, cmdLine [][]byte) resp.Reply { pa
 func (m *MockDB) IsExpired(k string) bool                                   { return false }
 func (m *MockDB) StartExpireTask()                                          {}
 func (m *MockDB) DeleteTTL(k string)                                        {}
+func (m *MockDB) Clear()                                                    {}
+func (m *MockDB) Len() int                                                  { return len(m.data) }
+func (m *MockDB) Scan(cursor, count int) ([]string, int)                    { return nil, 0 }
+func (m *MockDB) RandomKey() (string, bool)                                 { return "", false }
+
+// rewrite 测试中没有并发写入，直接连续调用两个阶段
+func rewrite(aof *AOFHandler, dbs []types.Database) error {
+	if err := aof.StartRewrite(); err != nil {
+		return err
+	}
+	return aof.FinishRewrite(dbs, nil)
+}
+
+// readAOFFiles 按 manifest 的顺序读出所有文件的内容
+func readAOFFiles(t *testing.T, aof *AOFHandler) []byte {
+	t.Helper()
+	aof.flush()
+	aof.mu.Lock()
+	files := aof.manifest.files()
+	aof.mu.Unlock()
+
+	var content []byte
+	for _, f := range files {
+		b, err := os.ReadFile(aof.filePath(f.name))
+		if err != nil {
+			t.Fatalf("Read AOF failed: %v", err)
+		}
+		content = append(content, b...)
+	}
+	return content
+}
 
 func TestAOFHandler(t *testing.T) {
 	tempDir := t.TempDir()
-	aofPath := filepath.Join(tempDir, "db0.aof")
 
 	t.Run("NewAOFHandler creates file", func(t *testing.T) {
 		aof, err := NewAOFHandler(tempDir, 0)
@@ -91,8 +127,11 @@ func TestAOFHandler(t *testing.T) {
 		}
 		defer aof.file.Close()
 
-		if _, err := os.Stat(aofPath); err != nil {
-			t.Errorf("AOF file not created")
+		if _, err := os.Stat(filepath.Join(tempDir, "db0.aof.manifest")); err != nil {
+			t.Errorf("AOF manifest not created")
+		}
+		if _, err := os.Stat(filepath.Join(tempDir, "db0.aof.1.incr.aof")); err != nil {
+			t.Errorf("AOF incr file not created")
 		}
 	})
 
@@ -106,8 +145,8 @@ func TestAOFHandler(t *testing.T) {
 		// Add commands
 		cmd1 := [][]byte{[]byte("set"), []byte("k1"), []byte("v1")}
 		cmd2 := [][]byte{[]byte("hset"), []byte("h1"), []byte("f1"), []byte("v1")}
-		aof.AddAOF(cmd1)
-		aof.AddAOF(cmd2)
+		aof.AddAOF(0, cmd1)
+		aof.AddAOF(0, cmd2)
 
 		// Wait for handle to process
 		time.Sleep(100 * time.Millisecond)
@@ -115,18 +154,82 @@ func TestAOFHandler(t *testing.T) {
 
 		// Load and verify
 		var loaded [][]byte
-		err = aof.Load(func(cmd types.CmdLine) {
+		err = aof.Load(nil, func(cmd types.CmdLine) {
 			loaded = append(loaded, cmd...)
 		})
 		if err != nil {
 			t.Fatalf("Load failed: %v", err)
 		}
 
-		if len(loaded) != 7 { // set k1 v1 hset h1 f1 v1 → 3+4=7
+		if len(loaded) != 9 { // select 0 set k1 v1 hset h1 f1 v1 → 2+3+4=9
 			t.Errorf("unexpected loaded commands: %v", loaded)
 		}
 	})
 
+	t.Run("AddAOF emits SELECT when db changes", func(t *testing.T) {
+		aof, err := NewAOFHandler(tempDir, 5)
+		if err != nil {
+			t.Fatalf("NewAOFHandler failed: %v", err)
+		}
+		defer aof.file.Close()
+
+		aof.AddAOF(0, [][]byte{[]byte("set"), []byte("a"), []byte("1")})
+		aof.AddAOF(0, [][]byte{[]byte("set"), []byte("b"), []byte("1")})
+		aof.AddAOF(3, [][]byte{[]byte("set"), []byte("c"), []byte("1")})
+		time.Sleep(100 * time.Millisecond)
+		aof.flush()
+
+		var names []string
+		aof.Load(nil, func(cmd types.CmdLine) {
+			names = append(names, string(cmd[0])+" "+string(cmd[1]))
+		})
+
+		want := []string{"select 0", "set a", "set b", "select 3", "set c"}
+		if len(names) != len(want) {
+			t.Fatalf("expected %v, got %v", want, names)
+		}
+		for i := range want {
+			if names[i] != want[i] {
+				t.Errorf("at %d: expected %q, got %q", i, want[i], names[i])
+			}
+		}
+	})
+
+	t.Run("AddTransaction writes MULTI EXEC block", func(t *testing.T) {
+		aof, err := NewAOFHandler(tempDir, 6)
+		if err != nil {
+			t.Fatalf("NewAOFHandler failed: %v", err)
+		}
+		defer aof.file.Close()
+
+		aof.AddTransaction([]TxCmd{
+			{DBIndex: 1, CmdLine: [][]byte{[]byte("set"), []byte("a"), []byte("1")}},
+			{DBIndex: 2, CmdLine: [][]byte{[]byte("set"), []byte("b"), []byte("1")}},
+		})
+		aof.AddAOF(2, [][]byte{[]byte("set"), []byte("c"), []byte("1")})
+		time.Sleep(100 * time.Millisecond)
+		aof.flush()
+
+		var names []string
+		aof.Load(nil, func(cmd types.CmdLine) {
+			name := string(cmd[0])
+			if len(cmd) > 1 {
+				name += " " + string(cmd[1])
+			}
+			names = append(names, name)
+		})
+
+		want := []string{"select 1", "multi", "set a", "select 2", "set b", "exec", "set c"}
+		if len(names) != len(want) {
+			t.Fatalf("expected %v, got %v", want, names)
+		}
+		for i := range want {
+			if names[i] != want[i] {
+				t.Errorf("at %d: expected %q, got %q", i, want[i], names[i])
+			}
+		}
+	})
+
 	t.Run("Rewrite", func(t *testing.T) {
 		aof, err := NewAOFHandler(tempDir, 2)
 		if err != nil {
@@ -135,8 +238,8 @@ func TestAOFHandler(t *testing.T) {
 		defer aof.file.Close()
 
 		// Add some commands
-		aof.AddAOF([][]byte{[]byte("set"), []byte("k1"), []byte("v1")})
-		aof.AddAOF([][]byte{[]byte("del"), []byte("k1")})
+		aof.AddAOF(0, [][]byte{[]byte("set"), []byte("k1"), []byte("v1")})
+		aof.AddAOF(0, [][]byte{[]byte("del"), []byte("k1")})
 		time.Sleep(100 * time.Millisecond)
 		aof.flush()
 
@@ -145,16 +248,13 @@ func TestAOFHandler(t *testing.T) {
 		db.PutEntity("k2", &types.DataEntity{Data: &MockString{"final"}})
 
 		// Rewrite
-		err = aof.Rewrite(db)
+		err = rewrite(aof, []types.Database{db})
 		if err != nil {
 			t.Fatalf("Rewrite failed: %v", err)
 		}
 
 		// Verify new AOF only contains final state
-		content, err := os.ReadFile(aof.path)
-		if err != nil {
-			t.Fatalf("Read AOF failed: %v", err)
-		}
+		content := readAOFFiles(t, aof)
 
 		// Should contain "set k2 final"
 		if !bytes.Contains(content, []byte("k2")) || !bytes.Contains(content, []byte("final")) {
@@ -166,6 +266,318 @@ func TestAOFHandler(t *testing.T) {
 		}
 	})
 
+	t.Run("Rewrite with RDB preamble", func(t *testing.T) {
+		aof, err := NewAOFHandler(tempDir, 7)
+		if err != nil {
+			t.Fatalf("NewAOFHandler failed: %v", err)
+		}
+		defer aof.file.Close()
+		aof.SetUseRDBPreamble(true)
+		aof.SetBacklog(NewReplBacklog(1<<20, 0))
+
+		aof.AddAOF(0, [][]byte{[]byte("set"), []byte("old"), []byte("v")})
+		time.Sleep(100 * time.Millisecond)
+		aof.flush()
+
+		if err := rewrite(aof, newSnapshotDBs()); err != nil {
+			t.Fatalf("Rewrite failed: %v", err)
+		}
+		// 重写之后的命令以 RESP 写入新的 incr 文件
+		aof.AddAOF(2, [][]byte{[]byte("set"), []byte("tail"), []byte("v")})
+		time.Sleep(100 * time.Millisecond)
+		aof.flush()
+
+		if !strings.HasSuffix(aof.manifest.base.name, ".base.rdb") {
+			t.Errorf("preamble base should be an RDB file: %s", aof.manifest.base.name)
+		}
+		content := readAOFFiles(t, aof)
+		if !bytes.HasPrefix(content, []byte(rdbMagic)) {
+			t.Fatalf("rewritten AOF should start with RDB preamble: %q", content[:16])
+		}
+
+		restored := []*MockDB{NewMockDB(0), NewMockDB(1), NewMockDB(2)}
+		var tail []string
+		err = aof.Load(loadInto(restored), func(cmd types.CmdLine) {
+			tail = append(tail, string(cmd[0])+" "+string(cmd[1]))
+		})
+		if err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if _, ok := restored[0].GetEntity("old"); ok {
+			t.Error("commands before rewrite should not be replayed")
+		}
+		if _, ok := restored[2].GetEntity("zset"); !ok {
+			t.Error("zset should be loaded from preamble")
+		}
+		if _, ok := restored[2].GetExpireTime("hash"); !ok {
+			t.Error("TTL should be loaded from preamble")
+		}
+		if len(tail) != 2 || tail[0] != "select 2" || tail[1] != "set tail" {
+			t.Errorf("unexpected RESP tail: %v", tail)
+		}
+
+		// 全量同步发给 slave 的数据只包含 RESP 命令
+		full, _, err := aof.ReadAll()
+		if err != nil {
+			t.Fatalf("ReadAll failed: %v", err)
+		}
+		p := parser.NewParser(bytes.NewReader(full))
+		var names []string
+		for {
+			payload, err := p.Parse()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("full sync data should be valid RESP: %v", err)
+			}
+			cmd, _ := common.ToCmdLine(payload)
+			names = append(names, string(cmd[0]))
+		}
+		if len(names) == 0 || names[len(names)-2] != "select" || names[len(names)-1] != "set" {
+			t.Errorf("full sync data should end with the RESP tail: %v", names)
+		}
+		if !contains(names, "zadd") || !contains(names, "pexpireat") {
+			t.Errorf("preamble should be converted to commands: %v", names)
+		}
+
+		// 快照部分损坏时拒绝加载
+		basePath := aof.filePath(aof.manifest.base.name)
+		base, _ := os.ReadFile(basePath)
+		base[len(rdbMagic)+len(rdbVersion)+5] ^= 0xFF
+		os.WriteFile(basePath, base, 0644)
+		err = aof.Load(loadInto([]*MockDB{NewMockDB(0), NewMockDB(1), NewMockDB(2)}), func(cmd types.CmdLine) {})
+		if err == nil {
+			t.Error("corrupted preamble should fail to load")
+		}
+	})
+
+	t.Run("appendfsync always", func(t *testing.T) {
+		aof, err := NewAOFHandler(tempDir, 8)
+		if err != nil {
+			t.Fatalf("NewAOFHandler failed: %v", err)
+		}
+		defer aof.file.Close()
+		if err := aof.SetFsyncPolicy("sometimes"); err == nil {
+			t.Error("invalid policy should fail")
+		}
+		if err := aof.SetFsyncPolicy(FsyncAlways); err != nil {
+			t.Fatalf("SetFsyncPolicy failed: %v", err)
+		}
+
+		// AddAOF 返回时命令已经落盘
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				aof.AddAOF(0, [][]byte{[]byte("set"), []byte("k" + strconv.Itoa(i)), []byte("v")})
+			}(i)
+		}
+		wg.Wait()
+
+		count := 0
+		aof.Load(nil, func(cmd types.CmdLine) {
+			if string(cmd[0]) == "set" {
+				count++
+			}
+		})
+		if count != 50 {
+			t.Errorf("expected 50 durable commands, got %d", count)
+		}
+
+		// 非写命令不会写入，也不会阻塞
+		aof.AddAOF(0, [][]byte{[]byte("get"), []byte("k0")})
+	})
+
+	t.Run("full channel keeps write order", func(t *testing.T) {
+		aof, err := NewAOFHandler(tempDir, 9)
+		if err != nil {
+			t.Fatalf("NewAOFHandler failed: %v", err)
+		}
+		defer aof.file.Close()
+
+		n := cap(aof.ch) * 3
+		for i := 0; i < n; i++ {
+			aof.AddAOF(0, [][]byte{[]byte("set"), []byte("k"), []byte(strconv.Itoa(i))})
+		}
+		time.Sleep(100 * time.Millisecond)
+		aof.flush()
+
+		next := 0
+		aof.Load(nil, func(cmd types.CmdLine) {
+			if string(cmd[0]) != "set" {
+				return
+			}
+			if string(cmd[2]) != strconv.Itoa(next) {
+				t.Fatalf("command %d out of order: %s", next, cmd[2])
+			}
+			next++
+		})
+		if next != n {
+			t.Errorf("expected %d commands, got %d", n, next)
+		}
+	})
+
+	t.Run("replication stream to slaves", func(t *testing.T) {
+		aof, err := NewAOFHandler(tempDir, 10)
+		if err != nil {
+			t.Fatalf("NewAOFHandler failed: %v", err)
+		}
+		defer aof.file.Close()
+		defer aof.flush()
+		aof.SetBacklog(NewReplBacklog(1<<20, 0))
+
+		aof.AddAOF(0, [][]byte{[]byte("set"), []byte("a"), []byte("1")})
+		// ReplOffset 等之前的命令都进入复制流之后返回
+		offset := aof.ReplOffset()
+		if offset == 0 || offset != aof.backlog.GetEndOffset() {
+			t.Fatalf("ReplOffset should be the backlog end, got %d", offset)
+		}
+
+		server, client := net.Pipe()
+		defer client.Close()
+		conn := connection.NewTCPConnection(server)
+		err = aof.FullSync(conn, func(offset int64) []byte {
+			return []byte("+FULLRESYNC id " + strconv.FormatInt(offset, 10) + "\r\n")
+		})
+		if err != nil {
+			t.Fatalf("FullSync failed: %v", err)
+		}
+		aof.AddAOF(1, [][]byte{[]byte("set"), []byte("b"), []byte("2")})
+		// 与 WAIT 一致，先等命令进入复制流再发送 GETACK
+		next := aof.ReplOffset()
+		aof.SendToSlaves(resp.MakeMultiBulkReply([][]byte{[]byte("REPLCONF"), []byte("GETACK"), []byte("*")}).ToBytes())
+
+		// 全量数据以 bulk string 发送，之后是注册之后写入的命令，新 slave 先收到 SELECT
+		names, bulks := readReplStream(t, parser.NewParser(client), 5)
+		want := []string{"FULLRESYNC id", "$", "select 1", "set b", "REPLCONF GETACK"}
+		if strings.Join(names, ",") != strings.Join(want, ",") {
+			t.Fatalf("expected %v, got %v", want, names)
+		}
+		if !bytes.Equal(bulks[0], append(makeSelectCmd(0), resp.MakeMultiBulkReply([][]byte{[]byte("set"), []byte("a"), []byte("1")}).ToBytes()...)) {
+			t.Errorf("unexpected full sync data: %q", bulks[0])
+		}
+		if next-offset != int64(len(makeSelectCmd(1))+len(resp.MakeMultiBulkReply([][]byte{[]byte("set"), []byte("b"), []byte("2")}).ToBytes())) {
+			t.Errorf("only commands after full sync should advance the offset: %d -> %d", offset, next)
+		}
+		if aof.ReplOffset() != next {
+			t.Error("GETACK should not be counted in the offset")
+		}
+
+		if aof.SlaveCount() != 1 {
+			t.Errorf("expected 1 slave, got %d", aof.SlaveCount())
+		}
+		aof.RemoveSlave(conn)
+		if aof.SlaveCount() != 0 {
+			t.Errorf("slave should be removed")
+		}
+	})
+
+	t.Run("PartialSync", func(t *testing.T) {
+		aof, err := NewAOFHandler(tempDir, 11)
+		if err != nil {
+			t.Fatalf("NewAOFHandler failed: %v", err)
+		}
+		defer aof.file.Close()
+		defer aof.flush()
+		aof.SetBacklog(NewReplBacklog(1<<20, 0))
+
+		aof.AddAOF(0, [][]byte{[]byte("set"), []byte("a"), []byte("1")})
+		aof.AddAOF(0, [][]byte{[]byte("set"), []byte("b"), []byte("2")})
+		end := aof.ReplOffset()
+
+		server, client := net.Pipe()
+		defer client.Close()
+		conn := connection.NewTCPConnection(server)
+		if aof.PartialSync(conn, end+1, []byte("+CONTINUE\r\n")) {
+			t.Fatal("offset beyond the backlog should need a full sync")
+		}
+		if aof.SlaveCount() != 0 {
+			t.Fatal("failed partial sync should not register the slave")
+		}
+
+		// 从第一条命令之后继续，只收到第二条
+		first := int64(len(makeSelectCmd(0)) + len(resp.MakeMultiBulkReply([][]byte{[]byte("set"), []byte("a"), []byte("1")}).ToBytes()))
+		if !aof.PartialSync(conn, first, []byte("+CONTINUE\r\n")) {
+			t.Fatal("offset inside the backlog should be served")
+		}
+		p := parser.NewParser(client)
+		if names, _ := readReplStream(t, p, 2); names[0] != "CONTINUE" || names[1] != "set b" {
+			t.Errorf("unexpected partial sync stream: %v", names)
+		}
+		aof.RemoveSlave(conn)
+
+		// 已经同步到最新的 slave 也可以部分同步，之后只收到新的命令
+		server, client = net.Pipe()
+		defer client.Close()
+		conn = connection.NewTCPConnection(server)
+		if !aof.PartialSync(conn, end, []byte("+CONTINUE\r\n")) {
+			t.Fatal("up-to-date slave should be served")
+		}
+		aof.AddAOF(0, [][]byte{[]byte("set"), []byte("c"), []byte("3")})
+		if names, _ := readReplStream(t, parser.NewParser(client), 3); names[0] != "CONTINUE" || names[1] != "select 0" || names[2] != "set c" {
+			t.Errorf("unexpected partial sync stream: %v", names)
+		}
+		aof.RemoveSlave(conn)
+	})
+
+	t.Run("replica mode", func(t *testing.T) {
+		aof, err := NewAOFHandler(tempDir, 12)
+		if err != nil {
+			t.Fatalf("NewAOFHandler failed: %v", err)
+		}
+		defer aof.file.Close()
+		defer aof.flush()
+		aof.SetBacklog(NewReplBacklog(1<<20, 0))
+		aof.SetReplicaMode(true)
+
+		// master 的复制流原样写入，offset 与 master 一致
+		stream := append(makeSelectCmd(2), resp.MakeMultiBulkReply([][]byte{[]byte("set"), []byte("a"), []byte("1")}).ToBytes()...)
+		aof.AppendReplStream(2, stream)
+		if offset := aof.ReplOffset(); offset != int64(len(stream)) {
+			t.Fatalf("offset should follow the master stream, got %d", offset)
+		}
+		// 本地写入只写文件，不属于复制流
+		aof.AddAOF(0, [][]byte{[]byte("set"), []byte("local"), []byte("1")})
+		set := resp.MakeMultiBulkReply([][]byte{[]byte("set"), []byte("b"), []byte("2")}).ToBytes()
+		aof.AppendReplStream(2, set)
+		if offset := aof.ReplOffset(); offset != int64(len(stream)+len(set)) || aof.StreamDB() != 2 {
+			t.Fatalf("local writes should not change the stream, offset %d db %d", offset, aof.StreamDB())
+		}
+
+		// 新文件开头和本地写入之后补 SELECT，回放时复制流的命令仍在原来的数据库
+		aof.flush()
+		var loaded []string
+		aof.Load(nil, func(cmd types.CmdLine) {
+			loaded = append(loaded, string(cmd[0])+" "+string(cmd[1]))
+		})
+		want := []string{"select 0", "select 2", "set a", "select 0", "set local", "select 2", "set b"}
+		if strings.Join(loaded, ",") != strings.Join(want, ",") {
+			t.Errorf("expected %v, got %v", want, loaded)
+		}
+
+		// 下游 slave 全量同步时，数据的最后切换到复制流所在的数据库
+		aof.AddAOF(0, [][]byte{[]byte("set"), []byte("local2"), []byte("1")})
+		aof.ReplOffset()
+		data, offset, err := aof.ReadAll()
+		if err != nil {
+			t.Fatalf("ReadAll failed: %v", err)
+		}
+		if !bytes.HasSuffix(data, makeSelectCmd(2)) || offset != int64(len(stream)+len(set)) {
+			t.Errorf("full sync data should end in the stream db: %q offset %d", data, offset)
+		}
+
+		// 切回 master 后自己的命令先 SELECT，文件和复制流一致
+		aof.SetReplicaMode(false)
+		aof.AddAOF(2, [][]byte{[]byte("set"), []byte("c"), []byte("3")})
+		aof.ReplOffset()
+		tail := aof.backlog.ReadFrom(int64(len(stream) + len(set)))
+		if !bytes.HasPrefix(tail, makeSelectCmd(2)) {
+			t.Errorf("first command after replica mode should select db: %q", tail)
+		}
+	})
+
 	t.Run("HasData", func(t *testing.T) {
 		aof, err := NewAOFHandler(tempDir, 3)
 		if err != nil {
@@ -177,7 +589,7 @@ func TestAOFHandler(t *testing.T) {
 			t.Error("new AOF should be empty")
 		}
 
-		aof.AddAOF([][]byte{[]byte("set"), []byte("a"), []byte("b")})
+		aof.AddAOF(0, [][]byte{[]byte("set"), []byte("a"), []byte("b")})
 		time.Sleep(100 * time.Millisecond)
 		aof.flush()
 
@@ -198,7 +610,7 @@ func TestAOFHandler(t *testing.T) {
 			t.Errorf("initial size should be 0, got %d", size)
 		}
 
-		aof.AddAOF([][]byte{[]byte("set"), []byte("x"), []byte("y")})
+		aof.AddAOF(0, [][]byte{[]byte("set"), []byte("x"), []byte("y")})
 		time.Sleep(100 * time.Millisecond)
 		aof.flush()
 
@@ -221,3 +633,37 @@ func (m *MockString) ToWriteCmdLine(key string) [][]byte {
 func (m *MockString) Clone() interface{} {
 	return &MockString{val: m.val}
 }
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readReplStream 从复制流中读出 n 项，命令记为名字加第一个参数，bulk string 记为 "$" 并返回其内容
+func readReplStream(t *testing.T, p *parser.Parser, n int) ([]string, [][]byte) {
+	t.Helper()
+	var names []string
+	var bulks [][]byte
+	for len(names) < n {
+		payload, err := p.Parse()
+		if err != nil {
+			t.Fatalf("parse replication stream failed: %v", err)
+		}
+		if b, ok := payload.([]byte); ok {
+			names = append(names, "$")
+			bulks = append(bulks, b)
+			continue
+		}
+		cmd, _ := common.ToCmdLine(payload)
+		name := string(cmd[0])
+		if len(cmd) > 1 {
+			name += " " + string(cmd[1])
+		}
+		names = append(names, name)
+	}
+	return names, bulks
+}
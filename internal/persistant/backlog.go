@@ -42,11 +42,12 @@ func (rb *ReplBacklog) Append(data []byte) {
 	}
 }
 
+// CanServe offset 之后的数据是否都在 backlog 中，offset 等于 end 表示 slave 已经同步到最新
 func (rb *ReplBacklog) CanServe(offset int64) bool {
 	rb.mu.Lock()
 	defer rb.mu.Unlock()
 
-	return offset >= rb.start && offset < rb.end
+	return offset >= rb.start && offset <= rb.end
 }
 
 func (rb *ReplBacklog) ReadFrom(offset int64) []byte {
@@ -80,3 +81,15 @@ func (rb *ReplBacklog) GetStartOffset() int64 {
 
 	return rb.start
 }
+
+// GetEndOffset 返回下一个写入字节的全局 offset，backlog 中保存的是 [start, end) 之间的数据
+func (rb *ReplBacklog) GetEndOffset() int64 {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	return rb.end
+}
+
+func (rb *ReplBacklog) Size() int64 {
+	return rb.size
+}
@@ -13,7 +13,7 @@ func TestReplBacklog(t *testing.T) {
 		if rb.end != 100 {
 			t.Errorf("expected end=100, got %d", rb.end)
 		}
-		if rb.CanServe(100) {
+		if !rb.CanServe(100) {
 			t.Error("should serve offset 100 (empty buffer)")
 		}
 		if rb.CanServe(101) {
@@ -21,6 +21,17 @@ func TestReplBacklog(t *testing.T) {
 		}
 	})
 
+	t.Run("window", func(t *testing.T) {
+		rb := NewReplBacklog(5, 10)
+		rb.Append([]byte("1234567"))
+		if start, end := rb.GetStartOffset(), rb.GetEndOffset(); start != 12 || end != 17 {
+			t.Errorf("expected window [12, 17), got [%d, %d)", start, end)
+		}
+		if rb.Size() != 5 {
+			t.Errorf("expected size 5, got %d", rb.Size())
+		}
+	})
+
 	t.Run("append small data", func(t *testing.T) {
 		rb := NewReplBacklog(10, 0)
 		data := []byte("hello")
@@ -46,7 +57,7 @@ func TestReplBacklog(t *testing.T) {
 		}
 
 		// CanServe
-		if !rb.CanServe(0) || !rb.CanServe(4) || rb.CanServe(5) {
+		if !rb.CanServe(0) || !rb.CanServe(4) || !rb.CanServe(5) || rb.CanServe(6) {
 			t.Error("CanServe logic error")
 		}
 	})
@@ -0,0 +1,61 @@
+package persistant
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+
+	"goredis/internal/types"
+)
+
+// ErrDumpPayload DUMP 的数据版本不对或者校验和不匹配
+var ErrDumpPayload = errors.New("DUMP payload version or checksum are wrong")
+
+// DumpValue 序列化单个值，用于 DUMP 和 MIGRATE：
+//
+//	type + value (与 RDB 中的编码相同) + 4 位 RDB 版本号 + 8 字节 CRC64 (小端，覆盖之前的所有字节)
+func DumpValue(entity types.RedisData) ([]byte, error) {
+	valueType, err := rdbValueType(entity)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w := &rdbWriter{w: bufio.NewWriter(&buf), crc: crc64.New(crcTable)}
+	w.writeByte(valueType)
+	writeValue(w, entity)
+	w.write([]byte(rdbVersion))
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], w.crc.Sum64())
+	w.w.Write(sum[:])
+	if err := w.w.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RestoreValue 解析 DumpValue 的结果，版本或校验和不对时返回 ErrDumpPayload
+func RestoreValue(payload []byte) (*types.DataEntity, error) {
+	footer := len(rdbVersion) + 8
+	if len(payload) < 1+footer {
+		return nil, ErrDumpPayload
+	}
+	body, sum := payload[:len(payload)-8], payload[len(payload)-8:]
+	if crc64.Checksum(body, crcTable) != binary.LittleEndian.Uint64(sum) ||
+		string(body[len(body)-len(rdbVersion):]) != rdbVersion {
+		return nil, ErrDumpPayload
+	}
+
+	value := body[:len(body)-len(rdbVersion)]
+	r := &rdbReader{r: bufio.NewReader(bytes.NewReader(value[1:])), crc: crc64.New(crcTable)}
+	entity, err := readEntity(r, value[0])
+	if err != nil {
+		return nil, ErrDumpPayload
+	}
+	// 校验和正确时数据应该正好读完
+	if _, err := r.r.ReadByte(); err == nil {
+		return nil, ErrDumpPayload
+	}
+	return entity, nil
+}
@@ -0,0 +1,61 @@
+package persistant
+
+import (
+	"errors"
+	"goredis/internal/data"
+	"goredis/internal/types"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		hash := data.NewRedisHash()
+		hash.HSet("f1", []byte("v1"))
+		hash.HSet("f2", []byte("v2"))
+		payload, err := DumpValue(hash)
+		if err != nil {
+			t.Fatalf("DumpValue failed: %v", err)
+		}
+		entity, err := RestoreValue(payload)
+		if err != nil {
+			t.Fatalf("RestoreValue failed: %v", err)
+		}
+		restored, ok := entity.Data.(*data.RedisHash)
+		if !ok {
+			t.Fatalf("expected a hash, got %T", entity.Data)
+		}
+		if v, _ := restored.HGet("f2"); string(v) != "v2" || len(restored.HGetAll()) != 2 {
+			t.Errorf("hash mismatch: %v", restored.HGetAll())
+		}
+
+		// 所有类型都能序列化
+		for _, db := range newSnapshotDBs() {
+			db.ForEach(func(key string, value types.RedisData) {
+				payload, err := DumpValue(value)
+				if err != nil {
+					t.Errorf("dump %s failed: %v", key, err)
+					return
+				}
+				if _, err := RestoreValue(payload); err != nil {
+					t.Errorf("restore %s failed: %v", key, err)
+				}
+			})
+		}
+	})
+
+	t.Run("corrupted payload", func(t *testing.T) {
+		payload, err := DumpValue(data.NewStringFromBytes([]byte("hello")))
+		if err != nil {
+			t.Fatalf("DumpValue failed: %v", err)
+		}
+
+		flipped := append([]byte(nil), payload...)
+		flipped[2] ^= 0xff
+		truncated := payload[:len(payload)-1]
+		for _, bad := range [][]byte{nil, []byte("x"), flipped, truncated} {
+			if _, err := RestoreValue(bad); !errors.Is(err, ErrDumpPayload) {
+				t.Errorf("%q should be rejected, got %v", bad, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,792 @@
+package persistant
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"goredis/internal/data"
+	"goredis/internal/types"
+)
+
+// RDB 文件格式：
+//
+//	"GOREDIS" + 4 位版本号
+//	{ FUNCTION code }
+//	{ SELECTDB dbIndex { [EXPIRETIME_MS ms] type key value } }
+//	EOF + 8 字节 CRC64 (小端，覆盖 EOF 之前的所有字节)
+//
+// 长度和整数使用 uvarint 编码，字符串为 长度 + 原始字节
+const (
+	rdbMagic   = "GOREDIS"
+	rdbVersion = "0001"
+
+	rdbOpcodeFunction     = 0xF5 // 函数库的代码，位于所有数据库之前
+	rdbOpcodeExpireTimeMs = 0xFC
+	rdbOpcodeSelectDB     = 0xFE
+	rdbOpcodeEOF          = 0xFF
+
+	rdbTypeString = 0
+	rdbTypeList   = 1
+	rdbTypeSet    = 2
+	rdbTypeZSet   = 3
+	rdbTypeHash   = 4
+	rdbTypeStream = 5
+)
+
+var (
+	crcTable = crc64.MakeTable(crc64.ECMA)
+
+	ErrRDBChecksum = errors.New("rdb checksum mismatch")
+	ErrRDBSaving   = errors.New("background save already in progress")
+)
+
+// SavePoint 自动保存条件：seconds 秒内至少有 changes 次修改
+type SavePoint struct {
+	Seconds int
+	Changes int64
+}
+
+// ParseSavePoints 解析 "900 1 300 10" 形式的配置，空字符串表示关闭自动保存
+func ParseSavePoints(s string) ([]SavePoint, error) {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save config %q", s)
+	}
+
+	points := make([]SavePoint, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err1 := strconv.Atoi(fields[i])
+		changes, err2 := strconv.ParseInt(fields[i+1], 10, 64)
+		if err1 != nil || err2 != nil || seconds <= 0 || changes <= 0 {
+			return nil, fmt.Errorf("invalid save config %q", s)
+		}
+		points = append(points, SavePoint{Seconds: seconds, Changes: changes})
+	}
+	return points, nil
+}
+
+// RDBHandler 负责快照的保存与加载，并记录距上次保存以来的修改次数
+type RDBHandler struct {
+	path       string
+	savePoints []SavePoint
+
+	mu       sync.Mutex // 同一时间只有一个保存在写文件
+	saving   int32      // BGSAVE 进行中
+	dirty    int64      // 上次保存之后的修改次数
+	lastSave int64      // 上次成功保存的时间 (unix 秒)
+}
+
+func NewRDBHandler(dir string, filename string, savePoints []SavePoint) (*RDBHandler, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	h := &RDBHandler{
+		path:       filepath.Join(dir, filename),
+		savePoints: savePoints,
+		lastSave:   time.Now().Unix(),
+	}
+	if info, err := os.Stat(h.path); err == nil {
+		h.lastSave = info.ModTime().Unix()
+	}
+	return h, nil
+}
+
+func (h *RDBHandler) HasData() bool {
+	info, err := os.Stat(h.path)
+	return err == nil && info.Size() > 0
+}
+
+// AddDirty 记录写命令的数量，用于判断是否满足自动保存条件
+func (h *RDBHandler) AddDirty(n int64) {
+	atomic.AddInt64(&h.dirty, n)
+}
+
+func (h *RDBHandler) LastSave() time.Time {
+	return time.Unix(atomic.LoadInt64(&h.lastSave), 0)
+}
+
+func (h *RDBHandler) IsSaving() bool {
+	return atomic.LoadInt32(&h.saving) == 1
+}
+
+// ShouldSave 是否满足任意一个自动保存条件
+func (h *RDBHandler) ShouldSave() bool {
+	dirty := atomic.LoadInt64(&h.dirty)
+	elapsed := time.Since(h.LastSave())
+	for _, p := range h.savePoints {
+		if dirty >= p.Changes && elapsed >= time.Duration(p.Seconds)*time.Second {
+			return true
+		}
+	}
+	return false
+}
+
+// Save 同步保存，调用方需保证 dbs 在保存期间不被修改
+func (h *RDBHandler) Save(dbs []types.Database, functions [][]byte) error {
+	dirty := atomic.LoadInt64(&h.dirty)
+	if err := h.save(dbs, functions); err != nil {
+		return err
+	}
+	atomic.AddInt64(&h.dirty, -dirty)
+	return nil
+}
+
+// BGSave 在后台保存 dbs 和函数库，dbs 应当是调用方 Clone 出来的快照
+func (h *RDBHandler) BGSave(dbs []types.Database, functions [][]byte) error {
+	if !atomic.CompareAndSwapInt32(&h.saving, 0, 1) {
+		return ErrRDBSaving
+	}
+
+	dirty := atomic.LoadInt64(&h.dirty)
+	go func() {
+		defer atomic.StoreInt32(&h.saving, 0)
+		if err := h.save(dbs, functions); err != nil {
+			log.Printf("[rdb] background save failed: %v", err)
+			return
+		}
+		// 保存期间产生的修改留到下一次
+		atomic.AddInt64(&h.dirty, -dirty)
+		log.Printf("[rdb] background saving terminated with success")
+	}()
+	return nil
+}
+
+// save 先写临时文件再原子替换，避免保存失败破坏已有的快照
+func (h *RDBHandler) save(dbs []types.Database, functions [][]byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	tmpPath := fmt.Sprintf("%s.tmp-%d", h.path, time.Now().UnixNano())
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	if err := WriteRDB(file, dbs, functions); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, h.path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	atomic.StoreInt64(&h.lastSave, time.Now().Unix())
+	return nil
+}
+
+// Load 读取快照，getDB 返回编号对应的数据库，函数库交给 loadFunction
+func (h *RDBHandler) Load(getDB func(index int) (types.Database, bool), loadFunction func(code []byte) error) error {
+	file, err := os.Open(h.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return ReadRDB(bufio.NewReader(file), getDB, loadFunction)
+}
+
+// rdbWriter 写入的同时计算 CRC
+type rdbWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash64
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (w *rdbWriter) write(b []byte) {
+	w.w.Write(b)
+	w.crc.Write(b)
+}
+
+func (w *rdbWriter) writeByte(b byte) {
+	w.write([]byte{b})
+}
+
+func (w *rdbWriter) writeUvarint(n uint64) {
+	size := binary.PutUvarint(w.buf[:], n)
+	w.write(w.buf[:size])
+}
+
+func (w *rdbWriter) writeString(s []byte) {
+	w.writeUvarint(uint64(len(s)))
+	w.write(s)
+}
+
+// WriteRDB 将 dbs 和函数库编码为 RDB 格式写入 out，已过期的 key 会被跳过
+func WriteRDB(out io.Writer, dbs []types.Database, functions [][]byte) error {
+	w := &rdbWriter{w: bufio.NewWriter(out), crc: crc64.New(crcTable)}
+	w.write([]byte(rdbMagic + rdbVersion))
+
+	for _, code := range functions {
+		w.writeByte(rdbOpcodeFunction)
+		w.writeString(code)
+	}
+
+	now := time.Now()
+	var encodeErr error
+	for _, db := range dbs {
+		selected := false
+		db.ForEach(func(key string, entity types.RedisData) {
+			if encodeErr != nil {
+				return
+			}
+			expireAt, hasTTL := db.GetExpireTime(key)
+			if hasTTL && !expireAt.After(now) {
+				return
+			}
+
+			if !selected {
+				selected = true
+				w.writeByte(rdbOpcodeSelectDB)
+				w.writeUvarint(uint64(db.GetDBIndex()))
+			}
+			if hasTTL {
+				w.writeByte(rdbOpcodeExpireTimeMs)
+				var ms [8]byte
+				binary.LittleEndian.PutUint64(ms[:], uint64(expireAt.UnixMilli()))
+				w.write(ms[:])
+			}
+			encodeErr = writeEntity(w, key, entity)
+		})
+		if encodeErr != nil {
+			return encodeErr
+		}
+	}
+
+	w.writeByte(rdbOpcodeEOF)
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], w.crc.Sum64())
+	w.w.Write(sum[:])
+	return w.w.Flush()
+}
+
+func writeEntity(w *rdbWriter, key string, entity types.RedisData) error {
+	valueType, err := rdbValueType(entity)
+	if err != nil {
+		return fmt.Errorf("rdb: unsupported type %T of key %s", entity, key)
+	}
+	w.writeByte(valueType)
+	w.writeString([]byte(key))
+	writeValue(w, entity)
+	return nil
+}
+
+// rdbValueType 返回数据在 RDB 中的类型
+func rdbValueType(entity types.RedisData) (byte, error) {
+	switch entity.(type) {
+	case *data.SimpleString:
+		return rdbTypeString, nil
+	case *data.QuickList:
+		return rdbTypeList, nil
+	case *data.SetObject:
+		return rdbTypeSet, nil
+	case *data.ZSet:
+		return rdbTypeZSet, nil
+	case *data.RedisHash:
+		return rdbTypeHash, nil
+	case *data.Stream:
+		return rdbTypeStream, nil
+	}
+	return 0, fmt.Errorf("rdb: unsupported type %T", entity)
+}
+
+// writeValue 写入数据本身，类型已由 rdbValueType 校验
+func writeValue(w *rdbWriter, entity types.RedisData) {
+	switch val := entity.(type) {
+	case *data.SimpleString:
+		w.writeString(val.Get())
+
+	case *data.QuickList:
+		w.writeUvarint(uint64(val.Len()))
+		for _, elem := range val.Range(0, val.Len()-1) {
+			w.writeString(elem)
+		}
+
+	case *data.SetObject:
+		members := val.Members()
+		w.writeUvarint(uint64(len(members)))
+		for _, member := range members {
+			w.writeString(member)
+		}
+
+	case *data.ZSet:
+		w.writeUvarint(uint64(val.ZCard()))
+		for _, member := range val.ZRange(0, val.ZCard()-1, false) {
+			score, _ := val.ZScore(member)
+			w.writeString(member)
+			var bits [8]byte
+			binary.LittleEndian.PutUint64(bits[:], math.Float64bits(score))
+			w.write(bits[:])
+		}
+
+	case *data.RedisHash:
+		fields := val.HGetAll()
+		w.writeUvarint(uint64(len(fields)))
+		for field, value := range fields {
+			w.writeString([]byte(field))
+			w.writeString(value)
+		}
+
+	case *data.Stream:
+		writeStream(w, val)
+	}
+}
+
+func (w *rdbWriter) writeStreamID(id data.StreamID) {
+	w.writeUvarint(id.Ms)
+	w.writeUvarint(id.Seq)
+}
+
+// writeStream 条目、ID 状态，然后是每个消费组的消费者和待确认列表。
+// entries-read 和 active-time 可能为 -1，加 1 后写入
+func writeStream(w *rdbWriter, s *data.Stream) {
+	entries := s.Range(data.MinStreamID, data.MaxStreamID, 0, false)
+	w.writeUvarint(uint64(len(entries)))
+	for _, entry := range entries {
+		w.writeStreamID(entry.ID)
+		w.writeUvarint(uint64(len(entry.Fields)))
+		for _, field := range entry.Fields {
+			w.writeString(field)
+		}
+	}
+	w.writeStreamID(s.LastID())
+	w.writeUvarint(s.EntriesAdded())
+	w.writeStreamID(s.MaxDeletedID())
+
+	groups := s.Groups()
+	w.writeUvarint(uint64(len(groups)))
+	for _, g := range groups {
+		w.writeString([]byte(g.Name))
+		w.writeStreamID(g.LastID)
+		w.writeUvarint(uint64(g.EntriesRead + 1))
+
+		consumers := g.Consumers()
+		w.writeUvarint(uint64(len(consumers)))
+		for _, c := range consumers {
+			w.writeString([]byte(c.Name))
+			w.writeUvarint(uint64(c.SeenTime))
+			w.writeUvarint(uint64(c.ActiveTime + 1))
+		}
+
+		pending := g.Pending.Range(data.MinStreamID, data.MaxStreamID, 0)
+		w.writeUvarint(uint64(len(pending)))
+		for _, nack := range pending {
+			w.writeStreamID(nack.ID)
+			w.writeString([]byte(nack.Consumer.Name))
+			w.writeUvarint(uint64(nack.DeliveryTime))
+			w.writeUvarint(uint64(nack.DeliveryCount))
+		}
+	}
+}
+
+// rdbReader 读取的同时计算 CRC
+type rdbReader struct {
+	r   *bufio.Reader
+	crc hash.Hash64
+}
+
+func (r *rdbReader) readFull(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, err
+	}
+	r.crc.Write(b)
+	return b, nil
+}
+
+func (r *rdbReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	r.crc.Write([]byte{b})
+	return b, nil
+}
+
+func (r *rdbReader) readUvarint() (uint64, error) {
+	return binary.ReadUvarint(r)
+}
+
+func (r *rdbReader) readString() ([]byte, error) {
+	n, err := r.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	// 长度可能来自损坏的文件，边读边分配，避免一次申请过大的内存
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r.r, int64(n)); err != nil {
+		return nil, err
+	}
+	r.crc.Write(buf.Bytes())
+	return buf.Bytes(), nil
+}
+
+// ReadRDB 解析 RDB 数据并写入对应的数据库，函数库交给 loadFunction，CRC 不匹配时返回 ErrRDBChecksum
+func ReadRDB(in *bufio.Reader, getDB func(index int) (types.Database, bool), loadFunction func(code []byte) error) error {
+	functions, records, err := readRDBRecords(in)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range functions {
+		if err := loadFunction(code); err != nil {
+			return fmt.Errorf("rdb: load function: %w", err)
+		}
+	}
+
+	for _, rec := range records {
+		db, ok := getDB(rec.dbIndex)
+		if !ok {
+			return fmt.Errorf("rdb: db index %d out of range", rec.dbIndex)
+		}
+		db.PutEntity(rec.key, rec.entity)
+		if rec.hasTTL {
+			db.SetExpire(rec.key, rec.expireAt)
+		}
+	}
+	return nil
+}
+
+// rdbRecord 快照中的一个键
+type rdbRecord struct {
+	dbIndex  int
+	key      string
+	entity   *types.DataEntity
+	expireAt time.Time
+	hasTTL   bool
+}
+
+// readRDBRecords 先解析到内存，校验通过后再交给调用方，避免加载损坏文件的部分内容；
+// 只读到校验和为止，in 中后续的数据不受影响。返回函数库和键，已过期的键会被跳过
+func readRDBRecords(in *bufio.Reader) ([][]byte, []rdbRecord, error) {
+	r := &rdbReader{r: in, crc: crc64.New(crcTable)}
+
+	header, err := r.readFull(len(rdbMagic) + len(rdbVersion))
+	if err != nil {
+		return nil, nil, fmt.Errorf("rdb: read header: %w", err)
+	}
+	if string(header[:len(rdbMagic)]) != rdbMagic {
+		return nil, nil, errors.New("rdb: wrong signature")
+	}
+	if string(header[len(rdbMagic):]) != rdbVersion {
+		return nil, nil, fmt.Errorf("rdb: unsupported version %s", header[len(rdbMagic):])
+	}
+
+	var functions [][]byte
+	var records []rdbRecord
+	dbIndex := 0
+	var expireAt time.Time
+	hasTTL := false
+	for {
+		opcode, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, fmt.Errorf("rdb: unexpected end of file: %w", err)
+		}
+
+		switch opcode {
+		case rdbOpcodeEOF:
+			expected := r.crc.Sum64()
+			var sum [8]byte
+			if _, err := io.ReadFull(in, sum[:]); err != nil {
+				return nil, nil, fmt.Errorf("rdb: read checksum: %w", err)
+			}
+			if binary.LittleEndian.Uint64(sum[:]) != expected {
+				return nil, nil, ErrRDBChecksum
+			}
+
+			now := time.Now()
+			alive := records[:0]
+			for _, rec := range records {
+				if rec.hasTTL && !rec.expireAt.After(now) {
+					continue
+				}
+				alive = append(alive, rec)
+			}
+			return functions, alive, nil
+
+		case rdbOpcodeFunction:
+			code, err := r.readString()
+			if err != nil {
+				return nil, nil, err
+			}
+			functions = append(functions, code)
+
+		case rdbOpcodeSelectDB:
+			index, err := r.readUvarint()
+			if err != nil {
+				return nil, nil, err
+			}
+			dbIndex = int(index)
+
+		case rdbOpcodeExpireTimeMs:
+			b, err := r.readFull(8)
+			if err != nil {
+				return nil, nil, err
+			}
+			expireAt = time.UnixMilli(int64(binary.LittleEndian.Uint64(b)))
+			hasTTL = true
+
+		default:
+			key, err := r.readString()
+			if err != nil {
+				return nil, nil, err
+			}
+			entity, err := readEntity(r, opcode)
+			if err != nil {
+				return nil, nil, err
+			}
+			records = append(records, rdbRecord{
+				dbIndex:  dbIndex,
+				key:      string(key),
+				entity:   entity,
+				expireAt: expireAt,
+				hasTTL:   hasTTL,
+			})
+			hasTTL = false
+		}
+	}
+}
+
+// hasRDBPreamble 判断 in 是否以快照开头（混合持久化的 AOF）
+func hasRDBPreamble(in *bufio.Reader) bool {
+	head, err := in.Peek(len(rdbMagic))
+	return err == nil && string(head) == rdbMagic
+}
+
+func readEntity(r *rdbReader, valueType byte) (*types.DataEntity, error) {
+	switch valueType {
+	case rdbTypeString:
+		val, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		return &types.DataEntity{Data: data.NewStringFromBytes(val)}, nil
+
+	case rdbTypeList:
+		n, err := r.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		ql := data.NewQuickList()
+		for i := uint64(0); i < n; i++ {
+			elem, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			ql.PushBack(elem)
+		}
+		return &types.DataEntity{Data: ql}, nil
+
+	case rdbTypeSet:
+		n, err := r.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		set := data.NewSet()
+		for i := uint64(0); i < n; i++ {
+			member, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			set.Add(member)
+		}
+		return &types.DataEntity{Data: set}, nil
+
+	case rdbTypeZSet:
+		n, err := r.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		zset := data.NewZSet()
+		for i := uint64(0); i < n; i++ {
+			member, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			bits, err := r.readFull(8)
+			if err != nil {
+				return nil, err
+			}
+			zset.ZAdd(false, false, math.Float64frombits(binary.LittleEndian.Uint64(bits)), member)
+		}
+		return &types.DataEntity{Data: zset}, nil
+
+	case rdbTypeHash:
+		n, err := r.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		h := data.NewRedisHash()
+		for i := uint64(0); i < n; i++ {
+			field, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			value, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			h.HSet(string(field), value)
+		}
+		return &types.DataEntity{Data: h}, nil
+
+	case rdbTypeStream:
+		s, err := readStream(r)
+		if err != nil {
+			return nil, err
+		}
+		return &types.DataEntity{Data: s}, nil
+	}
+
+	return nil, fmt.Errorf("rdb: unknown value type %d", valueType)
+}
+
+func (r *rdbReader) readStreamID() (data.StreamID, error) {
+	ms, err := r.readUvarint()
+	if err != nil {
+		return data.StreamID{}, err
+	}
+	seq, err := r.readUvarint()
+	if err != nil {
+		return data.StreamID{}, err
+	}
+	return data.StreamID{Ms: ms, Seq: seq}, nil
+}
+
+// readUvarints 依次读取多个 uvarint
+func (r *rdbReader) readUvarints(n ...*uint64) error {
+	for _, p := range n {
+		v, err := r.readUvarint()
+		if err != nil {
+			return err
+		}
+		*p = v
+	}
+	return nil
+}
+
+// readStream 格式见 writeStream
+func readStream(r *rdbReader) (*data.Stream, error) {
+	s := data.NewStream()
+	n, err := r.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < n; i++ {
+		id, err := r.readStreamID()
+		if err != nil {
+			return nil, err
+		}
+		fieldNum, err := r.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		fields := make([][]byte, 0, fieldNum)
+		for j := uint64(0); j < fieldNum; j++ {
+			field, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, field)
+		}
+		s.Add(id, fields)
+	}
+
+	lastID, err := r.readStreamID()
+	if err != nil {
+		return nil, err
+	}
+	entriesAdded, err := r.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	maxDeletedID, err := r.readStreamID()
+	if err != nil {
+		return nil, err
+	}
+	s.SetID(lastID, entriesAdded, maxDeletedID)
+
+	groupNum, err := r.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < groupNum; i++ {
+		name, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		groupLastID, err := r.readStreamID()
+		if err != nil {
+			return nil, err
+		}
+		var entriesRead, consumerNum uint64
+		if err := r.readUvarints(&entriesRead, &consumerNum); err != nil {
+			return nil, err
+		}
+		g, _ := s.CreateGroup(string(name), groupLastID, int64(entriesRead)-1)
+
+		for j := uint64(0); j < consumerNum; j++ {
+			consumerName, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			var seenTime, activeTime uint64
+			if err := r.readUvarints(&seenTime, &activeTime); err != nil {
+				return nil, err
+			}
+			c, _ := g.CreateConsumer(string(consumerName), int64(seenTime))
+			c.ActiveTime = int64(activeTime) - 1
+		}
+
+		pendingNum, err := r.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < pendingNum; j++ {
+			id, err := r.readStreamID()
+			if err != nil {
+				return nil, err
+			}
+			consumerName, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			var deliveryTime, deliveryCount uint64
+			if err := r.readUvarints(&deliveryTime, &deliveryCount); err != nil {
+				return nil, err
+			}
+			c, ok := g.Consumer(string(consumerName))
+			if !ok {
+				return nil, fmt.Errorf("rdb: unknown consumer %s in group %s", consumerName, name)
+			}
+			g.Deliver(id, c, int64(deliveryTime), int64(deliveryCount))
+		}
+	}
+	return s, nil
+}
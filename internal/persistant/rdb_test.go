@@ -0,0 +1,302 @@
+package persistant
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"goredis/internal/data"
+	"goredis/internal/types"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newSnapshotDBs 构造包含所有数据类型的两个数据库
+func newSnapshotDBs() []types.Database {
+	db0 := NewMockDB(0)
+	db0.PutEntity("str", &types.DataEntity{Data: data.NewStringFromBytes([]byte("hello"))})
+	db0.PutEntity("int", &types.DataEntity{Data: data.NewStringFromBytes([]byte("42"))})
+
+	list := data.NewQuickList()
+	list.PushBack([]byte("a"))
+	list.PushBack([]byte("b"))
+	db0.PutEntity("list", &types.DataEntity{Data: list})
+
+	set := data.NewSet()
+	set.Add([]byte("x"))
+	set.Add([]byte("y"))
+	db0.PutEntity("set", &types.DataEntity{Data: set})
+
+	stream := data.NewStream()
+	stream.Add(data.StreamID{Ms: 1}, [][]byte{[]byte("f"), []byte("v1")})
+	stream.Add(data.StreamID{Ms: 2}, [][]byte{[]byte("f"), []byte("v2")})
+	stream.Delete(data.StreamID{Ms: 1})
+	group, _ := stream.CreateGroup("g", data.StreamID{Ms: 2}, 2)
+	consumer, _ := group.CreateConsumer("c", 100)
+	group.CreateConsumer("idle", 100)
+	group.Deliver(data.StreamID{Ms: 2}, consumer, 200, 3)
+	db0.PutEntity("stream", &types.DataEntity{Data: stream})
+
+	db2 := NewMockDB(2)
+	zset := data.NewZSet()
+	for i := 0; i < 200; i++ { // 超过 listpack 上限，覆盖 SkipList 编码
+		zset.ZAdd(false, false, float64(i)+0.5, []byte("m"+strconv.Itoa(i)))
+	}
+	db2.PutEntity("zset", &types.DataEntity{Data: zset})
+
+	hash := data.NewRedisHash()
+	hash.HSet("f1", []byte("v1"))
+	hash.HSet("f2", []byte("v2"))
+	db2.PutEntity("hash", &types.DataEntity{Data: hash})
+	db2.SetExpire("hash", time.Now().Add(time.Hour))
+
+	db2.PutEntity("expired", &types.DataEntity{Data: data.NewStringFromBytes([]byte("old"))})
+	db2.SetExpire("expired", time.Now().Add(-time.Second))
+
+	return []types.Database{db0, NewMockDB(1), db2}
+}
+
+func loadInto(dbs []*MockDB) func(index int) (types.Database, bool) {
+	return func(index int) (types.Database, bool) {
+		if index < 0 || index >= len(dbs) {
+			return nil, false
+		}
+		return dbs[index], true
+	}
+}
+
+func TestRDB(t *testing.T) {
+	t.Run("encode and decode all types", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := WriteRDB(&buf, newSnapshotDBs(), nil); err != nil {
+			t.Fatalf("WriteRDB failed: %v", err)
+		}
+
+		restored := []*MockDB{NewMockDB(0), NewMockDB(1), NewMockDB(2)}
+		if err := ReadRDB(bufio.NewReader(&buf), loadInto(restored), nil); err != nil {
+			t.Fatalf("ReadRDB failed: %v", err)
+		}
+
+		str, _ := restored[0].GetEntity("str")
+		if got := string(str.Data.(*data.SimpleString).Get()); got != "hello" {
+			t.Errorf("string mismatch: %q", got)
+		}
+		num, _ := restored[0].GetEntity("int")
+		if n, err := num.Data.(*data.SimpleString).IncrBy(1); err != nil || n != 43 {
+			t.Errorf("integer string should stay an integer, got %d %v", n, err)
+		}
+		list, _ := restored[0].GetEntity("list")
+		if got := list.Data.(*data.QuickList).Range(0, -1); len(got) != 2 || string(got[0]) != "a" || string(got[1]) != "b" {
+			t.Errorf("list mismatch: %q", got)
+		}
+		set, _ := restored[0].GetEntity("set")
+		if !set.Data.(*data.SetObject).Contains([]byte("y")) || set.Data.(*data.SetObject).Len() != 2 {
+			t.Error("set mismatch")
+		}
+
+		stream, _ := restored[0].GetEntity("stream")
+		s := stream.Data.(*data.Stream)
+		if s.Len() != 1 || s.EntriesAdded() != 2 || s.MaxDeletedID() != (data.StreamID{Ms: 1}) {
+			t.Errorf("stream mismatch: len=%d added=%d maxDeleted=%v", s.Len(), s.EntriesAdded(), s.MaxDeletedID())
+		}
+		if g, ok := s.Group("g"); !ok || len(g.Consumers()) != 2 || g.EntriesRead != 2 {
+			t.Error("stream group mismatch")
+		} else if nack, ok := g.Pending.Get(data.StreamID{Ms: 2}); !ok || nack.Consumer.Name != "c" || nack.DeliveryTime != 200 || nack.DeliveryCount != 3 {
+			t.Errorf("stream PEL mismatch: %+v", nack)
+		}
+
+		zset, _ := restored[2].GetEntity("zset")
+		zs := zset.Data.(*data.ZSet)
+		if score, ok := zs.ZScore([]byte("m199")); zs.ZCard() != 200 || !ok || score != 199.5 {
+			t.Errorf("zset mismatch: card=%d score=%v", zs.ZCard(), score)
+		}
+		hash, _ := restored[2].GetEntity("hash")
+		if v, _ := hash.Data.(*data.RedisHash).HGet("f2"); string(v) != "v2" {
+			t.Errorf("hash mismatch: %q", v)
+		}
+		if _, ok := restored[2].GetExpireTime("hash"); !ok {
+			t.Error("TTL should be restored")
+		}
+		if _, ok := restored[2].GetEntity("expired"); ok {
+			t.Error("expired key should not be saved")
+		}
+		if len(restored[1].data) != 0 {
+			t.Error("empty db should stay empty")
+		}
+	})
+
+	t.Run("function libraries", func(t *testing.T) {
+		libs := [][]byte{[]byte("#!lua name=a\nredis.register_function('fa', function() end)"), []byte("#!lua name=b\n")}
+		var buf bytes.Buffer
+		if err := WriteRDB(&buf, newSnapshotDBs(), libs); err != nil {
+			t.Fatalf("WriteRDB failed: %v", err)
+		}
+		raw := buf.Bytes()
+
+		var loaded [][]byte
+		restored := []*MockDB{NewMockDB(0), NewMockDB(1), NewMockDB(2)}
+		err := ReadRDB(bufio.NewReader(bytes.NewReader(raw)), loadInto(restored), func(code []byte) error {
+			loaded = append(loaded, code)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("ReadRDB failed: %v", err)
+		}
+		if len(loaded) != 2 || !bytes.Equal(loaded[0], libs[0]) || !bytes.Equal(loaded[1], libs[1]) {
+			t.Errorf("function libraries mismatch: %q", loaded)
+		}
+		if _, ok := restored[2].GetEntity("zset"); !ok {
+			t.Error("keys should be loaded after functions")
+		}
+
+		// 函数库加载失败时整个文件加载失败
+		err = ReadRDB(bufio.NewReader(bytes.NewReader(raw)), loadInto(restored), func(code []byte) error {
+			return errors.New("bad library")
+		})
+		if err == nil {
+			t.Error("failing function load should fail the whole file")
+		}
+
+		// 转换为命令时函数库排在最前面
+		cmds, err := preambleToCmds(raw)
+		if err != nil {
+			t.Fatalf("preambleToCmds failed: %v", err)
+		}
+		if !bytes.HasPrefix(cmds, []byte("*4\r\n$8\r\nfunction\r\n$4\r\nload\r\n$7\r\nreplace\r\n")) {
+			t.Errorf("function load should come first: %q", cmds[:64])
+		}
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := WriteRDB(&buf, newSnapshotDBs(), nil); err != nil {
+			t.Fatalf("WriteRDB failed: %v", err)
+		}
+		raw := buf.Bytes()
+		raw[len(rdbMagic)+len(rdbVersion)+5] ^= 0xFF
+
+		restored := []*MockDB{NewMockDB(0), NewMockDB(1), NewMockDB(2)}
+		err := ReadRDB(bufio.NewReader(bytes.NewReader(raw)), loadInto(restored), nil)
+		if err == nil {
+			t.Fatal("corrupted file should fail to load")
+		}
+		if len(restored[0].data) != 0 || len(restored[2].data) != 0 {
+			t.Error("corrupted file should not be partially loaded")
+		}
+
+		// 只改校验和
+		raw = append([]byte(nil), buf.Bytes()...)
+		raw[len(raw)-1] ^= 0xFF
+		err = ReadRDB(bufio.NewReader(bytes.NewReader(raw)), loadInto(restored), nil)
+		if !errors.Is(err, ErrRDBChecksum) {
+			t.Errorf("expected checksum error, got %v", err)
+		}
+	})
+
+	t.Run("wrong signature and truncated file", func(t *testing.T) {
+		restored := []*MockDB{NewMockDB(0)}
+		if err := ReadRDB(bufio.NewReader(bytes.NewReader([]byte("REDIS0011"))), loadInto(restored), nil); err == nil {
+			t.Error("wrong signature should fail")
+		}
+
+		var buf bytes.Buffer
+		WriteRDB(&buf, newSnapshotDBs(), nil)
+		truncated := buf.Bytes()[:buf.Len()/2]
+		if err := ReadRDB(bufio.NewReader(bytes.NewReader(truncated)), loadInto(restored), nil); err == nil {
+			t.Error("truncated file should fail")
+		}
+	})
+
+	t.Run("handler Save BGSave and Load", func(t *testing.T) {
+		dir := t.TempDir()
+		h, err := NewRDBHandler(dir, "dump.rdb", nil)
+		if err != nil {
+			t.Fatalf("NewRDBHandler failed: %v", err)
+		}
+		if h.HasData() {
+			t.Fatal("new handler should not have data")
+		}
+
+		h.AddDirty(3)
+		if err := h.Save(newSnapshotDBs(), nil); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		if !h.HasData() || h.dirty != 0 {
+			t.Errorf("Save should write file and reset dirty, dirty=%d", h.dirty)
+		}
+
+		if err := h.BGSave(newSnapshotDBs(), nil); err != nil {
+			t.Fatalf("BGSave failed: %v", err)
+		}
+		deadline := time.Now().Add(time.Second)
+		for h.IsSaving() && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if h.IsSaving() {
+			t.Fatal("BGSave did not finish")
+		}
+
+		restored := []*MockDB{NewMockDB(0), NewMockDB(1), NewMockDB(2)}
+		if err := h.Load(loadInto(restored), nil); err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if _, ok := restored[2].GetEntity("zset"); !ok {
+			t.Error("zset should be loaded")
+		}
+		if matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp-*")); len(matches) != 0 {
+			t.Errorf("temporary files should be removed: %v", matches)
+		}
+	})
+
+	t.Run("save points", func(t *testing.T) {
+		points, err := ParseSavePoints("900 1 300 10")
+		if err != nil || len(points) != 2 || points[1] != (SavePoint{Seconds: 300, Changes: 10}) {
+			t.Fatalf("unexpected save points %v %v", points, err)
+		}
+		if points, err := ParseSavePoints(""); err != nil || len(points) != 0 {
+			t.Errorf("empty config should disable save points")
+		}
+		if _, err := ParseSavePoints("900"); err == nil {
+			t.Error("odd number of fields should fail")
+		}
+		if _, err := ParseSavePoints("900 x"); err == nil {
+			t.Error("non-integer should fail")
+		}
+
+		h, _ := NewRDBHandler(t.TempDir(), "dump.rdb", []SavePoint{{Seconds: 1, Changes: 2}})
+		h.lastSave = time.Now().Add(-2 * time.Second).Unix()
+		h.AddDirty(1)
+		if h.ShouldSave() {
+			t.Error("not enough changes yet")
+		}
+		h.AddDirty(1)
+		if !h.ShouldSave() {
+			t.Error("save point should be reached")
+		}
+	})
+
+	t.Run("failed save keeps old snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		h, _ := NewRDBHandler(dir, "dump.rdb", nil)
+		if err := h.Save(newSnapshotDBs(), nil); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		before, _ := os.ReadFile(filepath.Join(dir, "dump.rdb"))
+
+		bad := NewMockDB(0)
+		bad.PutEntity("bad", &types.DataEntity{Data: &unknownData{}})
+		if err := h.Save([]types.Database{bad}, nil); err == nil {
+			t.Fatal("unsupported type should fail")
+		}
+		after, _ := os.ReadFile(filepath.Join(dir, "dump.rdb"))
+		if !bytes.Equal(before, after) {
+			t.Error("failed save should not overwrite snapshot")
+		}
+	})
+}
+
+type unknownData struct{}
+
+func (u *unknownData) ToWriteCmdLine(key string) [][]byte { return nil }
@@ -0,0 +1,164 @@
+package persistant
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// 复制状态文件，与 AOF 放在同一目录，每行一个字段：
+//
+//	replid <id>
+//	replid2 <id>
+//	second_repl_offset <n>
+//	repl_offset <n>
+//	repl_stream_db <n>
+//	aof_size <n>
+//
+// 复制 ID 变化（包括故障转移）、切换 incr 文件、重写完成和 Shutdown 时更新，
+// 其余时候有新的写入时每秒更新一次，不随每次 flush 写入。
+// 重启时只有 aof_size 与 AOF 的实际大小一致，repl_offset 才与 AOF 中的数据对应，
+// 否则（崩溃时文件没来得及更新、AOF 被截断等）丢弃，换新的复制 ID 全量同步
+const replMetaSuffix = ".repl"
+
+// ReplMeta 持久化的复制状态 (PSYNC2)
+type ReplMeta struct {
+	ReplID string
+	// 上一个复制 ID 及其历史截止的 offset：提升为 master 或 master 换了 ID 之后，
+	// 使用旧 ID 且 offset 不超过 SecondOffset 的 slave 仍然可以部分同步。没有时 SecondOffset 为 -1
+	ReplID2      string
+	SecondOffset int64
+	Offset       int64
+	// 复制流在 Offset 处所在的数据库，slave 重启后从这里继续执行 master 发来的命令
+	StreamDB int
+}
+
+func (m *ReplMeta) encode(aofSize int64) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "replid %s\n", m.ReplID)
+	fmt.Fprintf(&b, "replid2 %s\n", m.ReplID2)
+	fmt.Fprintf(&b, "second_repl_offset %d\n", m.SecondOffset)
+	fmt.Fprintf(&b, "repl_offset %d\n", m.Offset)
+	fmt.Fprintf(&b, "repl_stream_db %d\n", m.StreamDB)
+	fmt.Fprintf(&b, "aof_size %d\n", aofSize)
+	return []byte(b.String())
+}
+
+// parseReplMeta 解析复制状态文件，返回状态和写入时 AOF 的大小
+func parseReplMeta(content []byte) (*ReplMeta, int64, error) {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(string(content), "\n") {
+		kv := strings.Fields(line)
+		if len(kv) == 0 {
+			continue
+		}
+		if len(kv) != 2 {
+			return nil, 0, fmt.Errorf("repl meta: invalid line %q", line)
+		}
+		fields[kv[0]] = kv[1]
+	}
+
+	m := &ReplMeta{ReplID: fields["replid"], ReplID2: fields["replid2"]}
+	if m.ReplID == "" || m.ReplID2 == "" {
+		return nil, 0, errors.New("repl meta: missing replication id")
+	}
+	var err error
+	var aofSize, streamDB int64
+	for key, dst := range map[string]*int64{
+		"second_repl_offset": &m.SecondOffset,
+		"repl_offset":        &m.Offset,
+		"repl_stream_db":     &streamDB,
+		"aof_size":           &aofSize,
+	} {
+		if *dst, err = strconv.ParseInt(fields[key], 10, 64); err != nil {
+			return nil, 0, fmt.Errorf("repl meta: invalid %s %q", key, fields[key])
+		}
+	}
+	m.StreamDB = int(streamDB)
+	return m, aofSize, nil
+}
+
+func (aof *AOFHandler) replMetaPath() string {
+	return filepath.Join(aof.dir, aof.prefix+replMetaSuffix)
+}
+
+// SetReplIDs 更新复制 ID 并立即写入复制状态文件
+func (aof *AOFHandler) SetReplIDs(replID, replID2 string, secondOffset int64) {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	aof.meta.ReplID, aof.meta.ReplID2, aof.meta.SecondOffset = replID, replID2, secondOffset
+	aof.flushLocked()
+	aof.writeReplMetaLocked()
+}
+
+// RestoreReplMeta 读取复制状态文件，与 AOF 中的数据一致时恢复 offset 和复制流所在的数据库，
+// 返回恢复的状态；文件不存在或已经过时返回 false
+func (aof *AOFHandler) RestoreReplMeta() (ReplMeta, bool) {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	content, err := os.ReadFile(aof.replMetaPath())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[aof] read repl meta failed: %v", err)
+		}
+		return ReplMeta{}, false
+	}
+	meta, aofSize, err := parseReplMeta(content)
+	if err != nil {
+		log.Printf("[aof] %v", err)
+		return ReplMeta{}, false
+	}
+	if size, err := aof.logSizeLocked(); err != nil || size != aofSize {
+		log.Printf("[aof] repl meta is stale (aof size %d, recorded %d)", size, aofSize)
+		return ReplMeta{}, false
+	}
+
+	aof.meta = *meta
+	atomic.StoreInt64(&aof.offset, meta.Offset)
+	aof.streamDB = meta.StreamDB
+	return *meta, true
+}
+
+// writeReplMetaLocked 记录当前的复制状态和 AOF 大小，调用方需持有 aof.mu 并已 flush，没有复制 ID 时不写入
+func (aof *AOFHandler) writeReplMetaLocked() {
+	if aof.meta.ReplID == "" {
+		return
+	}
+	path := aof.replMetaPath()
+
+	size, err := aof.logSizeLocked()
+	if err != nil {
+		log.Printf("[aof] write repl meta failed: %v", err)
+		return
+	}
+	aof.metaDirty = false
+	meta := aof.meta
+	meta.Offset = atomic.LoadInt64(&aof.offset)
+	meta.StreamDB = aof.streamDB
+
+	// 不 fsync：崩溃后内容与 AOF 不一致时会因为 aof_size 不符被丢弃
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, meta.encode(size), 0644); err != nil {
+		log.Printf("[aof] write repl meta failed: %v", err)
+		return
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		log.Printf("[aof] write repl meta failed: %v", err)
+	}
+}
+
+// removeReplMetaLocked 清除复制 ID 并删除复制状态文件，AOF 中的数据将被替换时调用
+func (aof *AOFHandler) removeReplMetaLocked() {
+	aof.meta = ReplMeta{}
+	if err := os.Remove(aof.replMetaPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("[aof] remove repl meta failed: %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package persistant
+
+import (
+	"os"
+	"testing"
+)
+
+func TestReplMeta(t *testing.T) {
+	t.Run("restore after restart", func(t *testing.T) {
+		dir := t.TempDir()
+		aof, err := NewAOFHandler(dir, 0)
+		if err != nil {
+			t.Fatalf("NewAOFHandler failed: %v", err)
+		}
+		aof.SetReplIDs("id1", "id0", 10)
+		aof.AddAOF(3, [][]byte{[]byte("set"), []byte("a"), []byte("1")})
+		offset := aof.ReplOffset()
+		aof.Shutdown()
+		aof.file.Close()
+
+		restarted, err := NewAOFHandler(dir, 0)
+		if err != nil {
+			t.Fatalf("NewAOFHandler failed: %v", err)
+		}
+		defer restarted.file.Close()
+		meta, ok := restarted.RestoreReplMeta()
+		if !ok {
+			t.Fatal("repl meta should be restored")
+		}
+		if meta.ReplID != "id1" || meta.ReplID2 != "id0" || meta.SecondOffset != 10 {
+			t.Errorf("unexpected replication ids: %+v", meta)
+		}
+		if meta.Offset != offset || restarted.CurrentOffset() != offset || restarted.StreamDB() != 3 {
+			t.Errorf("expected offset %d db 3, got %+v", offset, meta)
+		}
+	})
+
+	t.Run("stale meta is ignored", func(t *testing.T) {
+		dir := t.TempDir()
+		aof, err := NewAOFHandler(dir, 0)
+		if err != nil {
+			t.Fatalf("NewAOFHandler failed: %v", err)
+		}
+		aof.SetReplIDs("id1", "id0", -1)
+		aof.AddAOF(0, [][]byte{[]byte("set"), []byte("a"), []byte("1")})
+		aof.Shutdown()
+		aof.file.Close()
+
+		// 崩溃前写入了 AOF，但复制状态文件没来得及更新
+		f, _ := os.OpenFile(aof.filePath(aof.manifest.incrs[0].name), os.O_APPEND|os.O_WRONLY, 0644)
+		f.Write(makeSelectCmd(1))
+		f.Close()
+
+		restarted, err := NewAOFHandler(dir, 0)
+		if err != nil {
+			t.Fatalf("NewAOFHandler failed: %v", err)
+		}
+		defer restarted.file.Close()
+		if _, ok := restarted.RestoreReplMeta(); ok {
+			t.Error("meta not matching the aof size should be ignored")
+		}
+	})
+
+	t.Run("written on tick, not on every flush", func(t *testing.T) {
+		dir := t.TempDir()
+		aof, err := NewAOFHandler(dir, 0)
+		if err != nil {
+			t.Fatalf("NewAOFHandler failed: %v", err)
+		}
+		defer aof.file.Close()
+		aof.SetReplIDs("id1", "id0", -1)
+		before, _ := os.ReadFile(aof.replMetaPath())
+
+		aof.AddAOF(0, [][]byte{[]byte("set"), []byte("a"), []byte("1")})
+		offset := aof.ReplOffset()
+		aof.flush()
+		if after, _ := os.ReadFile(aof.replMetaPath()); string(after) != string(before) {
+			t.Error("flush should not rewrite the repl meta")
+		}
+
+		aof.tick()
+		meta, ok := aof.RestoreReplMeta()
+		if !ok || meta.Offset != offset {
+			t.Errorf("tick should write the repl meta, got %+v %v", meta, ok)
+		}
+		os.Remove(aof.replMetaPath())
+		aof.tick()
+		if _, err := os.Stat(aof.replMetaPath()); !os.IsNotExist(err) {
+			t.Error("tick without new writes should not write the repl meta")
+		}
+	})
+
+	t.Run("Reset removes meta", func(t *testing.T) {
+		dir := t.TempDir()
+		aof, err := NewAOFHandler(dir, 0)
+		if err != nil {
+			t.Fatalf("NewAOFHandler failed: %v", err)
+		}
+		defer aof.file.Close()
+		aof.SetReplIDs("id1", "id0", -1)
+		if _, err := os.Stat(aof.replMetaPath()); err != nil {
+			t.Fatalf("repl meta should be written: %v", err)
+		}
+
+		data := makeSelectCmd(0)
+		if err := aof.Reset(100, data); err != nil {
+			t.Fatalf("Reset failed: %v", err)
+		}
+		if _, err := os.Stat(aof.replMetaPath()); !os.IsNotExist(err) {
+			t.Error("repl meta should be removed when the data is replaced")
+		}
+		if size, _ := aof.LogSize(); size != int64(len(data)) || aof.CurrentOffset() != 100 {
+			t.Errorf("full sync data should be written, size %d offset %d", size, aof.CurrentOffset())
+		}
+
+		// 设置新的复制 ID 后，记录的 offset 是全量数据之后的复制流位置
+		aof.SetReplIDs("id2", "id0", -1)
+		if meta, ok := aof.RestoreReplMeta(); !ok || meta.ReplID != "id2" || meta.Offset != 100 {
+			t.Errorf("unexpected meta after reset: %+v %v", meta, ok)
+		}
+	})
+
+	t.Run("parse", func(t *testing.T) {
+		meta := &ReplMeta{ReplID: "a", ReplID2: "b", SecondOffset: -1, Offset: 42, StreamDB: 5}
+		parsed, size, err := parseReplMeta(meta.encode(7))
+		if err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		if *parsed != *meta || size != 7 {
+			t.Errorf("expected %+v size 7, got %+v size %d", meta, parsed, size)
+		}
+
+		for _, content := range []string{
+			"",
+			"replid a\nreplid2 b\n",
+			"replid a\nreplid2 b\nsecond_repl_offset x\nrepl_offset 1\nrepl_stream_db 0\naof_size 1\n",
+			"replid a b\n",
+		} {
+			if _, _, err := parseReplMeta([]byte(content)); err == nil {
+				t.Errorf("%q should be rejected", content)
+			}
+		}
+	})
+}
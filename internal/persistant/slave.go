@@ -0,0 +1,152 @@
+package persistant
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"goredis/pkg/connection"
+)
+
+// slaveQueueSize 每个 slave 最多积压的写入次数，超过时断开该 slave，
+// 避免慢 slave 占用过多内存或拖慢 AOF 写入（类似 Redis 的 client-output-buffer-limit）
+const slaveQueueSize = 1 << 16
+
+// slaveWriter 在独立的协程中按顺序把复制流写给一个 slave
+type slaveWriter struct {
+	conn connection.Connection
+	ch   chan []byte
+	once sync.Once
+}
+
+func newSlaveWriter(conn connection.Connection) *slaveWriter {
+	w := &slaveWriter{
+		conn: conn,
+		ch:   make(chan []byte, slaveQueueSize),
+	}
+	go w.loop()
+	return w
+}
+
+func (w *slaveWriter) loop() {
+	failed := false
+	for b := range w.ch {
+		if failed {
+			continue
+		}
+		if _, err := w.conn.Write(b); err != nil {
+			log.Printf("[aof replication] write cmd failed: %s", err)
+			// 触发 slave 重连，之后的数据直接丢弃，直到连接被移除
+			w.conn.Close()
+			failed = true
+		}
+	}
+}
+
+// send 不阻塞地放入发送队列，队列已满时返回 false
+func (w *slaveWriter) send(b []byte) bool {
+	select {
+	case w.ch <- b:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *slaveWriter) close() {
+	w.once.Do(func() { close(w.ch) })
+}
+
+// addSlaveLocked 注册 slave，initial 中的数据先于之后的命令发送，调用方需持有 aof.mu
+func (aof *AOFHandler) addSlaveLocked(conn connection.Connection, initial ...[]byte) {
+	w := newSlaveWriter(conn)
+	for _, b := range initial {
+		if len(b) > 0 {
+			w.send(b)
+		}
+	}
+
+	aof.slavesMu.Lock()
+	if old, ok := aof.slaves[conn]; ok {
+		old.close()
+	}
+	aof.slaves[conn] = w
+	aof.slavesMu.Unlock()
+
+	// 新 slave 不知道当前所在的数据库，下一条命令前强制 SELECT
+	aof.currentDB = -1
+}
+
+func (aof *AOFHandler) AddSlave(conn connection.Connection) {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	aof.addSlaveLocked(conn)
+}
+
+func (aof *AOFHandler) RemoveSlave(conn connection.Connection) {
+	aof.slavesMu.Lock()
+	defer aof.slavesMu.Unlock()
+	if w, ok := aof.slaves[conn]; ok {
+		w.close()
+		delete(aof.slaves, conn)
+	}
+}
+
+// broadcast 把复制流发给所有 slave，发送队列已满的 slave 被断开
+func (aof *AOFHandler) broadcast(b []byte) {
+	aof.slavesMu.Lock()
+	defer aof.slavesMu.Unlock()
+
+	for conn, w := range aof.slaves {
+		if !w.send(b) {
+			log.Printf("[aof replication] slave %s is too slow, disconnecting", conn.RemoteAddr())
+			conn.Close()
+			w.close()
+			delete(aof.slaves, conn)
+		}
+	}
+}
+
+// FullSync 全量同步：发送 header 和以 bulk string 发送的全部数据，并把 conn 注册为 slave。
+// 读取数据和注册在同一个临界区内完成，之后写入的命令紧接着数据发送，不会遗漏或重复。
+// 数据不属于复制流，header 根据数据之后复制流开始的 offset 生成，例如 +FULLRESYNC <replid> <offset>
+func (aof *AOFHandler) FullSync(conn connection.Connection, header func(offset int64) []byte) error {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	data, err := aof.readAllLocked()
+	if err != nil {
+		return err
+	}
+	bulk := []byte(fmt.Sprintf("$%d\r\n", len(data)))
+	aof.addSlaveLocked(conn, header(aof.CurrentOffset()), bulk, data, []byte("\r\n"))
+	return nil
+}
+
+// PartialSync 部分同步：backlog 包含 offset 之后的全部数据时，发送 header 和这部分数据并注册 slave；
+// 否则返回 false，需要全量同步
+func (aof *AOFHandler) PartialSync(conn connection.Connection, offset int64, header []byte) bool {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+
+	if aof.backlog == nil || !aof.backlog.CanServe(offset) {
+		return false
+	}
+	aof.addSlaveLocked(conn, header, aof.backlog.ReadFrom(offset))
+	return true
+}
+
+// SendToSlaves 把 b 发给所有 slave，排在已经写入的命令之后；
+// 不写入 AOF 和 backlog，也不计入 offset，用于 REPLCONF GETACK 这类不属于数据的命令
+func (aof *AOFHandler) SendToSlaves(b []byte) {
+	aof.mu.Lock()
+	defer aof.mu.Unlock()
+	aof.broadcast(b)
+}
+
+// SlaveCount 返回已注册的 slave 数量
+func (aof *AOFHandler) SlaveCount() int {
+	aof.slavesMu.Lock()
+	defer aof.slavesMu.Unlock()
+	return len(aof.slaves)
+}
@@ -0,0 +1,389 @@
+package pubsub
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"goredis/internal/resp"
+	"goredis/pkg/connection"
+	"goredis/pkg/wildcard"
+)
+
+// subscription 单个连接订阅的频道和模式
+type subscription struct {
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+func (s *subscription) count() int {
+	return len(s.channels) + len(s.patterns)
+}
+
+// writeTimeout 消息超过这个时间还没写给订阅者时断开该订阅者，不读数据的客户端不会一直拖住发布者
+var writeTimeout = 5 * time.Second
+
+// deadlineWriter 支持写超时的连接，见 connection.TCPConnection
+type deadlineWriter interface {
+	WriteTimeout(b []byte, timeout time.Duration) (int, error)
+}
+
+// Hub 维护频道/模式与订阅连接之间的关系
+// 订阅确认在持有写锁时写出，消息在复制订阅者列表、释放锁之后写出：
+// PUBLISH 看到某个订阅者时它的订阅确认已经写完，因此确认一定先于该频道的消息到达客户端
+type Hub struct {
+	mu       sync.RWMutex
+	channels map[string]map[connection.Connection]struct{} // channel -> 订阅者
+	patterns map[string]map[connection.Connection]struct{} // pattern -> 订阅者
+	clients  map[connection.Connection]*subscription
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		channels: make(map[string]map[connection.Connection]struct{}),
+		patterns: make(map[string]map[connection.Connection]struct{}),
+		clients:  make(map[connection.Connection]*subscription),
+	}
+}
+
+// IsPubSubCmd 判断是否是由 Hub 处理的命令
+func IsPubSubCmd(cmdLine [][]byte) bool {
+	if len(cmdLine) == 0 {
+		return false
+	}
+	switch strings.ToLower(string(cmdLine[0])) {
+	case "subscribe", "unsubscribe", "psubscribe", "punsubscribe", "publish", "pubsub":
+		return true
+	}
+	return false
+}
+
+// IsAllowedInSubscribeMode 订阅模式下只允许执行订阅相关的命令以及 PING、QUIT、RESET
+func IsAllowedInSubscribeMode(cmdLine [][]byte) bool {
+	if len(cmdLine) == 0 {
+		return false
+	}
+	switch strings.ToLower(string(cmdLine[0])) {
+	case "subscribe", "unsubscribe", "psubscribe", "punsubscribe", "ping", "quit", "reset":
+		return true
+	}
+	return false
+}
+
+// MakePongReply 订阅模式下 PING [message] 的回复：["pong", message]，没有 message 时为空字符串
+func MakePongReply(args [][]byte) resp.Reply {
+	if len(args) > 1 {
+		return resp.MakeArgNumErrReply("ping")
+	}
+	message := []byte{}
+	if len(args) == 1 {
+		message = args[0]
+	}
+	return resp.MakeMultiBulkReply([][]byte{[]byte("pong"), message})
+}
+
+// Exec 执行发布订阅命令；订阅类命令的回复直接写给连接，返回 nil
+func (h *Hub) Exec(c connection.Connection, cmdLine [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	args := cmdLine[1:]
+
+	switch cmdName {
+	case "subscribe":
+		if len(args) < 1 {
+			return resp.MakeArgNumErrReply(cmdName)
+		}
+		h.Subscribe(c, args)
+	case "psubscribe":
+		if len(args) < 1 {
+			return resp.MakeArgNumErrReply(cmdName)
+		}
+		h.PSubscribe(c, args)
+	case "unsubscribe":
+		h.Unsubscribe(c, args)
+	case "punsubscribe":
+		h.PUnsubscribe(c, args)
+	case "publish":
+		if len(args) != 2 {
+			return resp.MakeArgNumErrReply(cmdName)
+		}
+		return resp.MakeIntReply(int64(h.Publish(string(args[0]), args[1])))
+	case "pubsub":
+		return h.execPubSub(args)
+	default:
+		return resp.MakeErrReply("ERR unknown command '" + cmdName + "'")
+	}
+	return nil
+}
+
+// InSubscribeMode 连接是否订阅了至少一个频道或模式
+func (h *Hub) InSubscribeMode(c connection.Connection) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	sub, ok := h.clients[c]
+	return ok && sub.count() > 0
+}
+
+// Subscribe SUBSCRIBE channel [channel ...]
+func (h *Hub) Subscribe(c connection.Connection, channels [][]byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := h.getOrCreateSubscription(c)
+	for _, ch := range channels {
+		channel := string(ch)
+		sub.channels[channel] = struct{}{}
+		addSubscriber(h.channels, channel, c)
+		push(c, makeSubscribeMsg("subscribe", channel, sub.count()))
+	}
+}
+
+// PSubscribe PSUBSCRIBE pattern [pattern ...]
+func (h *Hub) PSubscribe(c connection.Connection, patterns [][]byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := h.getOrCreateSubscription(c)
+	for _, p := range patterns {
+		pattern := string(p)
+		sub.patterns[pattern] = struct{}{}
+		addSubscriber(h.patterns, pattern, c)
+		push(c, makeSubscribeMsg("psubscribe", pattern, sub.count()))
+	}
+}
+
+// Unsubscribe UNSUBSCRIBE [channel ...]，不带参数时退订所有频道
+func (h *Hub) Unsubscribe(c connection.Connection, channels [][]byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := h.getOrCreateSubscription(c)
+	targets := toStrings(channels)
+	if len(targets) == 0 {
+		targets = sortedKeys(sub.channels)
+	}
+	if len(targets) == 0 {
+		push(c, makeSubscribeMsg("unsubscribe", "", sub.count()))
+	}
+
+	for _, channel := range targets {
+		delete(sub.channels, channel)
+		removeSubscriber(h.channels, channel, c)
+		push(c, makeSubscribeMsg("unsubscribe", channel, sub.count()))
+	}
+	h.releaseIfIdle(c, sub)
+}
+
+// PUnsubscribe PUNSUBSCRIBE [pattern ...]，不带参数时退订所有模式
+func (h *Hub) PUnsubscribe(c connection.Connection, patterns [][]byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := h.getOrCreateSubscription(c)
+	targets := toStrings(patterns)
+	if len(targets) == 0 {
+		targets = sortedKeys(sub.patterns)
+	}
+	if len(targets) == 0 {
+		push(c, makeSubscribeMsg("punsubscribe", "", sub.count()))
+	}
+
+	for _, pattern := range targets {
+		delete(sub.patterns, pattern)
+		removeSubscriber(h.patterns, pattern, c)
+		push(c, makeSubscribeMsg("punsubscribe", pattern, sub.count()))
+	}
+	h.releaseIfIdle(c, sub)
+}
+
+// UnsubscribeAll 连接断开或 RESET 时清理其全部订阅，不再向客户端回复
+func (h *Hub) UnsubscribeAll(c connection.Connection) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub, ok := h.clients[c]
+	if !ok {
+		return
+	}
+	for channel := range sub.channels {
+		removeSubscriber(h.channels, channel, c)
+	}
+	for pattern := range sub.patterns {
+		removeSubscriber(h.patterns, pattern, c)
+	}
+	delete(h.clients, c)
+}
+
+// Publish PUBLISH channel message，返回收到消息的客户端数量
+func (h *Hub) Publish(channel string, message []byte) int {
+	deliveries := h.deliveries(channel, message)
+	for _, d := range deliveries {
+		push(d.c, d.msg)
+	}
+	return len(deliveries)
+}
+
+// delivery 要推送给一个订阅者的消息
+type delivery struct {
+	c   connection.Connection
+	msg []byte
+}
+
+// deliveries 在读锁下找出 channel 的所有订阅者，写出时不持有锁
+func (h *Hub) deliveries(channel string, message []byte) []delivery {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var deliveries []delivery
+	if subscribers, ok := h.channels[channel]; ok {
+		msg := resp.MakeMultiBulkReply([][]byte{
+			[]byte("message"), []byte(channel), message,
+		}).ToBytes()
+		for c := range subscribers {
+			deliveries = append(deliveries, delivery{c, msg})
+		}
+	}
+
+	for pattern, subscribers := range h.patterns {
+		if !wildcard.Match(pattern, channel) {
+			continue
+		}
+		msg := resp.MakeMultiBulkReply([][]byte{
+			[]byte("pmessage"), []byte(pattern), []byte(channel), message,
+		}).ToBytes()
+		for c := range subscribers {
+			deliveries = append(deliveries, delivery{c, msg})
+		}
+	}
+	return deliveries
+}
+
+// push 写给订阅者，超时或出错时断开连接，订阅由连接关闭后的 UnsubscribeAll 清理
+func push(c connection.Connection, msg []byte) {
+	var err error
+	if w, ok := c.(deadlineWriter); ok {
+		_, err = w.WriteTimeout(msg, writeTimeout)
+	} else {
+		_, err = c.Write(msg)
+	}
+	if err != nil && !c.IsClosed() {
+		log.Printf("[pubsub] disconnect subscriber %s: %v", c.RemoteAddr(), err)
+		c.Close()
+	}
+}
+
+// PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+func (h *Hub) execPubSub(args [][]byte) resp.Reply {
+	if len(args) < 1 {
+		return resp.MakeArgNumErrReply("pubsub")
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	sub := strings.ToLower(string(args[0]))
+	switch sub {
+	case "channels":
+		if len(args) > 2 {
+			return resp.MakeArgNumErrReply("pubsub|channels")
+		}
+		result := make([][]byte, 0)
+		for _, channel := range sortedKeys(h.channels) {
+			if len(args) == 2 && !wildcard.Match(string(args[1]), channel) {
+				continue
+			}
+			result = append(result, []byte(channel))
+		}
+		return resp.MakeMultiBulkReply(result)
+
+	case "numsub":
+		replies := make([]resp.Reply, 0, 2*(len(args)-1))
+		for _, ch := range args[1:] {
+			replies = append(replies,
+				resp.MakeBulkReply(ch),
+				resp.MakeIntReply(int64(len(h.channels[string(ch)]))),
+			)
+		}
+		return resp.MakeMultiRawReply(replies)
+
+	case "numpat":
+		if len(args) != 1 {
+			return resp.MakeArgNumErrReply("pubsub|numpat")
+		}
+		return resp.MakeIntReply(int64(len(h.patterns)))
+	}
+
+	return resp.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try PUBSUB HELP.")
+}
+
+// getOrCreateSubscription 调用方需持有写锁
+func (h *Hub) getOrCreateSubscription(c connection.Connection) *subscription {
+	sub, ok := h.clients[c]
+	if !ok {
+		sub = &subscription{
+			channels: make(map[string]struct{}),
+			patterns: make(map[string]struct{}),
+		}
+		h.clients[c] = sub
+	}
+	return sub
+}
+
+// releaseIfIdle 没有任何订阅时退出订阅模式，调用方需持有写锁
+func (h *Hub) releaseIfIdle(c connection.Connection, sub *subscription) {
+	if sub.count() == 0 {
+		delete(h.clients, c)
+	}
+}
+
+func addSubscriber(m map[string]map[connection.Connection]struct{}, name string, c connection.Connection) {
+	subscribers, ok := m[name]
+	if !ok {
+		subscribers = make(map[connection.Connection]struct{})
+		m[name] = subscribers
+	}
+	subscribers[c] = struct{}{}
+}
+
+func removeSubscriber(m map[string]map[connection.Connection]struct{}, name string, c connection.Connection) {
+	subscribers, ok := m[name]
+	if !ok {
+		return
+	}
+	delete(subscribers, c)
+	if len(subscribers) == 0 {
+		delete(m, name)
+	}
+}
+
+// makeSubscribeMsg 订阅确认：[kind, name, 当前订阅数]，name 为空时返回 nil bulk
+func makeSubscribeMsg(kind string, name string, count int) []byte {
+	var nameReply resp.Reply = resp.MakeBulkReply([]byte(name))
+	if name == "" {
+		nameReply = resp.MakeNullBulkReply()
+	}
+	return resp.MakeMultiRawReply([]resp.Reply{
+		resp.MakeBulkReply([]byte(kind)),
+		nameReply,
+		resp.MakeIntReply(int64(count)),
+	}).ToBytes()
+}
+
+func toStrings(args [][]byte) []string {
+	result := make([]string, len(args))
+	for i, arg := range args {
+		result[i] = string(arg)
+	}
+	return result
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,257 @@
+package pubsub
+
+import (
+	"bytes"
+	"goredis/pkg/connection"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockConn 记录写给客户端的所有数据
+type mockConn struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+
+	connection.Transaction
+}
+
+func (m *mockConn) Write(b []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.buf.Write(b)
+}
+func (m *mockConn) Close() error          { return nil }
+func (m *mockConn) IsClosed() bool        { return false }
+func (m *mockConn) Done() <-chan struct{} { return nil }
+func (m *mockConn) GetDBIndex() int       { return 0 }
+func (m *mockConn) SelectDB(int)          {}
+func (m *mockConn) RemoteAddr() string    { return "mock" }
+func (m *mockConn) IsSlave() bool         { return false }
+func (m *mockConn) SetSlave()             {}
+
+// take 取出并清空已写入的数据
+func (m *mockConn) take() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.buf.String()
+	m.buf.Reset()
+	return s
+}
+
+func toArgs(args ...string) [][]byte {
+	result := make([][]byte, len(args))
+	for i, arg := range args {
+		result[i] = []byte(arg)
+	}
+	return result
+}
+
+func TestHub(t *testing.T) {
+	t.Run("SUBSCRIBE and PUBLISH", func(t *testing.T) {
+		hub := NewHub()
+		sub := &mockConn{}
+
+		hub.Subscribe(sub, toArgs("news", "sport"))
+		want := "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n" +
+			"*3\r\n$9\r\nsubscribe\r\n$5\r\nsport\r\n:2\r\n"
+		if got := sub.take(); got != want {
+			t.Fatalf("unexpected subscribe reply %q", got)
+		}
+		if !hub.InSubscribeMode(sub) {
+			t.Error("connection should be in subscribe mode")
+		}
+
+		if n := hub.Publish("news", []byte("hello")); n != 1 {
+			t.Errorf("expected 1 receiver, got %d", n)
+		}
+		if got := sub.take(); got != "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n" {
+			t.Errorf("unexpected message %q", got)
+		}
+
+		if n := hub.Publish("weather", []byte("sunny")); n != 0 {
+			t.Errorf("expected 0 receivers, got %d", n)
+		}
+	})
+
+	t.Run("PSUBSCRIBE with glob pattern", func(t *testing.T) {
+		hub := NewHub()
+		sub := &mockConn{}
+
+		hub.PSubscribe(sub, toArgs("news.*"))
+		sub.take()
+
+		if n := hub.Publish("news.tech", []byte("go")); n != 1 {
+			t.Errorf("expected 1 receiver, got %d", n)
+		}
+		want := "*4\r\n$8\r\npmessage\r\n$6\r\nnews.*\r\n$9\r\nnews.tech\r\n$2\r\ngo\r\n"
+		if got := sub.take(); got != want {
+			t.Errorf("unexpected pmessage %q", got)
+		}
+		if n := hub.Publish("sport.tech", []byte("go")); n != 0 {
+			t.Errorf("expected 0 receivers, got %d", n)
+		}
+	})
+
+	t.Run("UNSUBSCRIBE all leaves subscribe mode", func(t *testing.T) {
+		hub := NewHub()
+		sub := &mockConn{}
+
+		hub.Subscribe(sub, toArgs("a", "b"))
+		hub.PSubscribe(sub, toArgs("c*"))
+		sub.take()
+
+		hub.Unsubscribe(sub, nil)
+		want := "*3\r\n$11\r\nunsubscribe\r\n$1\r\na\r\n:2\r\n" +
+			"*3\r\n$11\r\nunsubscribe\r\n$1\r\nb\r\n:1\r\n"
+		if got := sub.take(); got != want {
+			t.Fatalf("unexpected unsubscribe reply %q", got)
+		}
+		if !hub.InSubscribeMode(sub) {
+			t.Error("pattern subscription should keep subscribe mode")
+		}
+
+		hub.PUnsubscribe(sub, nil)
+		sub.take()
+		if hub.InSubscribeMode(sub) {
+			t.Error("connection should leave subscribe mode")
+		}
+
+		// 没有任何订阅时退订，返回 nil 频道
+		hub.Unsubscribe(sub, nil)
+		if got := sub.take(); got != "*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n" {
+			t.Errorf("unexpected reply %q", got)
+		}
+	})
+
+	t.Run("UnsubscribeAll on disconnect", func(t *testing.T) {
+		hub := NewHub()
+		sub := &mockConn{}
+
+		hub.Subscribe(sub, toArgs("news"))
+		hub.PSubscribe(sub, toArgs("*"))
+		hub.UnsubscribeAll(sub)
+
+		if n := hub.Publish("news", []byte("x")); n != 0 {
+			t.Errorf("disconnected client should not receive messages, got %d", n)
+		}
+		if hub.InSubscribeMode(sub) {
+			t.Error("disconnected client should be removed")
+		}
+	})
+
+	t.Run("slow subscriber is disconnected", func(t *testing.T) {
+		old := writeTimeout
+		writeTimeout = 200 * time.Millisecond
+		defer func() { writeTimeout = old }()
+
+		hub := NewHub()
+		server, client := net.Pipe()
+		defer client.Close()
+		slow := connection.NewTCPConnection(server)
+		// 只读订阅确认，之后不再读
+		confirmed := make(chan struct{})
+		go func() {
+			io.ReadFull(client, make([]byte, len(makeSubscribeMsg("subscribe", "ch", 1))))
+			close(confirmed)
+		}()
+		hub.Subscribe(slow, toArgs("ch"))
+		<-confirmed
+		fast := &mockConn{}
+		hub.Subscribe(fast, toArgs("ch"))
+		fast.take()
+
+		published := make(chan int)
+		go func() { published <- hub.Publish("ch", []byte("hello")) }()
+
+		// 写给 slow 期间不持有锁，其他客户端不受影响
+		time.Sleep(50 * time.Millisecond)
+		start := time.Now()
+		other := &mockConn{}
+		hub.Subscribe(other, toArgs("other"))
+		hub.InSubscribeMode(fast)
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Errorf("hub blocked by a slow subscriber for %v", elapsed)
+		}
+
+		if n := <-published; n != 2 {
+			t.Errorf("expected 2 receivers, got %d", n)
+		}
+		if !slow.IsClosed() {
+			t.Error("slow subscriber should be disconnected")
+		}
+		if got := fast.take(); got != "*3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$5\r\nhello\r\n" {
+			t.Errorf("unexpected message %q", got)
+		}
+	})
+
+	t.Run("PUBSUB", func(t *testing.T) {
+		hub := NewHub()
+		c1, c2 := &mockConn{}, &mockConn{}
+
+		hub.Subscribe(c1, toArgs("news", "sport"))
+		hub.Subscribe(c2, toArgs("news"))
+		hub.PSubscribe(c2, toArgs("n*", "s*"))
+
+		reply := hub.Exec(c1, toArgs("pubsub", "channels"))
+		if got := string(reply.ToBytes()); got != "*2\r\n$4\r\nnews\r\n$5\r\nsport\r\n" {
+			t.Errorf("unexpected CHANNELS reply %q", got)
+		}
+		reply = hub.Exec(c1, toArgs("pubsub", "channels", "s*"))
+		if got := string(reply.ToBytes()); got != "*1\r\n$5\r\nsport\r\n" {
+			t.Errorf("unexpected CHANNELS pattern reply %q", got)
+		}
+		reply = hub.Exec(c1, toArgs("pubsub", "numsub", "news", "none"))
+		if got := string(reply.ToBytes()); got != "*4\r\n$4\r\nnews\r\n:2\r\n$4\r\nnone\r\n:0\r\n" {
+			t.Errorf("unexpected NUMSUB reply %q", got)
+		}
+		reply = hub.Exec(c1, toArgs("pubsub", "numpat"))
+		if got := string(reply.ToBytes()); got != ":2\r\n" {
+			t.Errorf("unexpected NUMPAT reply %q", got)
+		}
+	})
+
+	t.Run("Exec", func(t *testing.T) {
+		hub := NewHub()
+		sub, pub := &mockConn{}, &mockConn{}
+
+		if reply := hub.Exec(sub, toArgs("subscribe", "ch")); reply != nil {
+			t.Errorf("subscribe should reply directly, got %q", reply.ToBytes())
+		}
+		reply := hub.Exec(pub, toArgs("publish", "ch", "msg"))
+		if got := string(reply.ToBytes()); got != ":1\r\n" {
+			t.Errorf("unexpected PUBLISH reply %q", got)
+		}
+		if reply := hub.Exec(pub, toArgs("publish", "ch")); string(reply.ToBytes())[0] != '-' {
+			t.Error("PUBLISH with wrong arity should fail")
+		}
+	})
+
+	t.Run("IsPubSubCmd", func(t *testing.T) {
+		if !IsPubSubCmd(toArgs("PUBLISH", "a", "b")) || IsPubSubCmd(toArgs("get", "a")) {
+			t.Error("IsPubSubCmd mismatch")
+		}
+		if IsAllowedInSubscribeMode(toArgs("publish", "a", "b")) || !IsAllowedInSubscribeMode(toArgs("PSUBSCRIBE", "a")) {
+			t.Error("IsAllowedInSubscribeMode mismatch")
+		}
+		for _, name := range []string{"PING", "quit", "reset"} {
+			if !IsAllowedInSubscribeMode(toArgs(name)) {
+				t.Errorf("%s should be allowed in subscribe mode", name)
+			}
+		}
+	})
+
+	t.Run("MakePongReply", func(t *testing.T) {
+		if got := string(MakePongReply(nil).ToBytes()); got != "*2\r\n$4\r\npong\r\n$0\r\n\r\n" {
+			t.Errorf("unexpected PING reply %q", got)
+		}
+		if got := string(MakePongReply(toArgs("hi")).ToBytes()); got != "*2\r\n$4\r\npong\r\n$2\r\nhi\r\n" {
+			t.Errorf("unexpected PING reply %q", got)
+		}
+		if got := string(MakePongReply(toArgs("a", "b")).ToBytes()); got[0] != '-' {
+			t.Errorf("PING with wrong arity should fail, got %q", got)
+		}
+	})
+}
@@ -121,3 +121,38 @@ func (r *MultiBulkReply) ToBytes() []byte {
 	}
 	return buf
 }
+
+// MultiRawReply 由任意类型的回复组成的数组，例如 EXEC 的返回值
+type MultiRawReply struct {
+	Replies []Reply
+}
+
+func MakeMultiRawReply(replies []Reply) *MultiRawReply {
+	return &MultiRawReply{
+		Replies: replies,
+	}
+}
+
+func (r *MultiRawReply) ToBytes() []byte {
+	var buf []byte
+	buf = append(buf, "*"...)
+	buf = append(buf, []byte(strconv.Itoa(len(r.Replies)))...)
+	buf = append(buf, CRLF...)
+	for _, reply := range r.Replies {
+		buf = append(buf, reply.ToBytes()...)
+	}
+	return buf
+}
+
+type NullMultiBulkReply struct{}
+
+// 预定义 Null 数组回复，例如 WATCH 的 key 被修改后 EXEC 的返回值
+var nullMultiBulkReply = &NullMultiBulkReply{}
+
+func MakeNullMultiBulkReply() *NullMultiBulkReply {
+	return nullMultiBulkReply
+}
+
+func (r *NullMultiBulkReply) ToBytes() []byte {
+	return []byte("*-1\r\n")
+}
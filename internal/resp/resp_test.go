@@ -38,6 +38,16 @@ func TestRESP_All(t *testing.T) {
 			[]byte("world"),
 		}), want: []byte("*3\r\n$5\r\nhello\r\n$-1\r\n$5\r\nworld\r\n")},
 		{name: "MultiBulk_AllNull", reply: MakeMultiBulkReply([][]byte{nil, nil}), want: []byte("*2\r\n$-1\r\n$-1\r\n")},
+		{name: "MultiBulk_Null", reply: MakeNullMultiBulkReply(), want: []byte("*-1\r\n")},
+
+		// MultiRaw
+		{name: "MultiRaw_Empty", reply: MakeMultiRawReply(nil), want: []byte("*0\r\n")},
+		{name: "MultiRaw_Mixed", reply: MakeMultiRawReply([]Reply{
+			MakeOkReply(),
+			MakeIntReply(2),
+			MakeErrReply("ERR foo"),
+			MakeMultiBulkReply([][]byte{[]byte("a")}),
+		}), want: []byte("*4\r\n+OK\r\n:2\r\n-ERR foo\r\n*1\r\n$1\r\na\r\n")},
 	}
 
 	for _, tc := range tests {
@@ -0,0 +1,321 @@
+package script
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"goredis/internal/resp"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// chunkName 脚本在错误信息中显示的名字，与 Redis 一致
+const chunkName = "user_script"
+
+// CallFunc 执行 redis.call 发起的命令，由数据库层提供
+type CallFunc func(cmdLine [][]byte) resp.Reply
+
+// Engine 管理脚本缓存、函数库和 Lua 虚拟机。
+// 所有脚本共用一个虚拟机，函数使用另一个，调用方需保证同一时刻只有一个脚本或函数在执行（持有数据库写锁）
+type Engine struct {
+	// 保护 scripts 和 funcs，SCRIPT 命令和 FUNCTION LIST/DUMP 不持有数据库锁
+	mu      sync.Mutex
+	scripts map[string]*compiled
+	funcs   *registry
+
+	L  *lua.LState
+	fL *lua.LState
+	// 正在加载的函数库，只有这时才能调用 redis.register_function
+	loading *library
+
+	// 超过 timeLimit 时还没有执行写命令的脚本会被中止，执行过写命令的脚本继续执行，
+	// 期间其他客户端收到 BUSY。0 表示不限制
+	timeLimit time.Duration
+
+	// 正在执行的脚本，SCRIPT KILL 不持有数据库锁，通过它中止脚本
+	runMu   sync.Mutex
+	running *run
+}
+
+type compiled struct {
+	body  []byte
+	proto *lua.FunctionProto
+}
+
+// run 一次脚本执行的状态
+type run struct {
+	call     CallFunc
+	cancel   context.CancelFunc
+	readOnly bool   // 声明了 no-writes 的函数不能执行写命令
+	wrote    bool   // 执行过写命令后不能再中止，否则会破坏原子性
+	timedOut bool   // 超过时间上限后仍在执行，见 Busy
+	stop     string // 非空表示脚本被中止，内容是返回给客户端的错误
+}
+
+func NewEngine() *Engine {
+	e := &Engine{scripts: make(map[string]*compiled), funcs: newRegistry()}
+	e.L = e.newLState()
+	e.fL = e.newLState()
+	return e
+}
+
+// SetTimeLimit 设置脚本执行时间上限，需在开始服务前调用
+func (e *Engine) SetTimeLimit(limit time.Duration) {
+	e.timeLimit = limit
+}
+
+// SHA1Hex 返回脚本的 SHA1，作为 EVALSHA 的参数
+func SHA1Hex(body []byte) string {
+	sum := sha1.Sum(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Load 编译并缓存脚本，返回脚本的 SHA1
+func (e *Engine) Load(body []byte) (string, resp.Reply) {
+	sha := SHA1Hex(body)
+	if _, ok := e.lookup(sha); ok {
+		return sha, nil
+	}
+
+	chunk, err := parse.Parse(bytes.NewReader(body), chunkName)
+	if err != nil {
+		return "", compileError(err)
+	}
+	proto, err := lua.Compile(chunk, chunkName)
+	if err != nil {
+		return "", compileError(err)
+	}
+
+	e.mu.Lock()
+	e.scripts[sha] = &compiled{body: body, proto: proto}
+	e.mu.Unlock()
+	return sha, nil
+}
+
+func compileError(err error) resp.Reply {
+	return resp.MakeErrReply("ERR Error compiling script (new function): " + strings.TrimSpace(err.Error()))
+}
+
+func (e *Engine) lookup(sha string) (*compiled, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s, ok := e.scripts[strings.ToLower(sha)]
+	return s, ok
+}
+
+// Body 返回已缓存脚本的内容
+func (e *Engine) Body(sha string) ([]byte, bool) {
+	s, ok := e.lookup(sha)
+	if !ok {
+		return nil, false
+	}
+	return s.body, true
+}
+
+// Flush 清空脚本缓存 (SCRIPT FLUSH)
+func (e *Engine) Flush() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.scripts = make(map[string]*compiled)
+}
+
+// Eval 执行 EVAL/EVALSHA，redis.call 发起的命令交给 call 执行。
+// limited 为 false 时不受时间上限约束，例如加载 AOF 时。
+// 只有没有执行过写命令的脚本会被中止，执行过写命令的脚本总是完整执行
+func (e *Engine) Eval(cmdLine [][]byte, call CallFunc, limited bool) resp.Reply {
+	keys, args, errReply := splitKeys(cmdLine)
+	if errReply != nil {
+		return errReply
+	}
+
+	var sha string
+	if strings.ToLower(string(cmdLine[0])) == "eval" {
+		var errReply resp.Reply
+		if sha, errReply = e.Load(cmdLine[1]); errReply != nil {
+			return errReply
+		}
+	} else {
+		sha = strings.ToLower(string(cmdLine[1]))
+	}
+	s, ok := e.lookup(sha)
+	if !ok {
+		return resp.MakeErrReply("NOSCRIPT No matching script. Please use EVAL.")
+	}
+
+	L := e.L
+	L.G.Global.RawSetString("KEYS", stringsTable(L, keys))
+	L.G.Global.RawSetString("ARGV", stringsTable(L, args))
+	return e.run(L, L.NewFunctionFromProto(s.proto), nil, call, limited, false, func(err error) resp.Reply {
+		return errorReply(sha, chunkName, err)
+	})
+}
+
+// EvalCmdLine 把 EVALSHA 改写成 EVAL，按脚本复制时 AOF 和 slave 上可能没有缓存这个脚本
+func (e *Engine) EvalCmdLine(cmdLine [][]byte) [][]byte {
+	if strings.ToLower(string(cmdLine[0])) != "evalsha" {
+		return cmdLine
+	}
+	body, ok := e.Body(string(cmdLine[1]))
+	if !ok {
+		return cmdLine
+	}
+	line := make([][]byte, len(cmdLine))
+	copy(line, cmdLine)
+	line[0], line[1] = []byte("EVAL"), body
+	return line
+}
+
+// run 在虚拟机 L 中执行 fn，出错时由 onError 生成回复
+func (e *Engine) run(L *lua.LState, fn *lua.LFunction, args []lua.LValue, call CallFunc, limited, readOnly bool,
+	onError func(error) resp.Reply) resp.Reply {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &run{call: call, cancel: cancel, readOnly: readOnly}
+	e.setRunning(r)
+	defer func() {
+		e.setRunning(nil)
+		cancel()
+	}()
+
+	if limit := e.timeLimit; limited && limit > 0 {
+		timer := time.AfterFunc(limit, func() { e.timeout(r, limit) })
+		defer timer.Stop()
+	}
+
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	L.Push(fn)
+	for _, arg := range args {
+		L.Push(arg)
+	}
+	err := L.PCall(len(args), 1, nil)
+
+	if stop := e.stopReason(r); stop != "" {
+		return resp.MakeErrReply(stop)
+	}
+	if err != nil {
+		return onError(err)
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+	return luaToReply(ret)
+}
+
+func (e *Engine) setRunning(r *run) {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+
+	e.running = r
+}
+
+// timeout 脚本超过时间上限：没有执行过写命令时中止，否则让它执行完，期间其他客户端收到 BUSY
+func (e *Engine) timeout(r *run, limit time.Duration) {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+
+	if r.wrote {
+		r.timedOut = true
+		return
+	}
+	e.stopLocked(r, fmt.Sprintf("ERR Script killed after exceeding lua-time-limit of %d ms", limit.Milliseconds()))
+}
+
+// stopLocked 中止脚本，VM 在执行下一条指令时抛出错误，调用方需持有 runMu
+func (e *Engine) stopLocked(r *run, reason string) {
+	if r.stop == "" {
+		r.stop = reason
+	}
+	r.cancel()
+}
+
+// Busy 是否有执行过写命令的脚本超过了时间上限还在执行，此时其他客户端的命令回复 BUSY
+func (e *Engine) Busy() bool {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+
+	return e.running != nil && e.running.timedOut
+}
+
+func (e *Engine) stopReason(r *run) string {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+
+	return r.stop
+}
+
+// markWrote 记录脚本将要执行写命令，脚本已经被中止时返回 false，此时不能再写
+func (e *Engine) markWrote(r *run) bool {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+
+	if r.stop != "" {
+		return false
+	}
+	r.wrote = true
+	return true
+}
+
+// Kill 中止正在执行的脚本 (SCRIPT KILL)，已经执行过写命令的脚本不能中止，否则会破坏原子性
+func (e *Engine) Kill() resp.Reply {
+	e.runMu.Lock()
+	defer e.runMu.Unlock()
+
+	r := e.running
+	if r == nil {
+		return resp.MakeErrReply("NOTBUSY No scripts in execution right now.")
+	}
+	if r.wrote {
+		return resp.MakeErrReply("UNKILLABLE Sorry the script already executed write commands against the dataset. " +
+			"You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.")
+	}
+	e.stopLocked(r, "ERR Script killed by user with SCRIPT KILL...")
+	return resp.MakeOkReply()
+}
+
+// ExecScript 执行 SCRIPT LOAD|EXISTS|FLUSH|KILL，这些命令都不访问数据库
+func (e *Engine) ExecScript(cmdLine [][]byte) resp.Reply {
+	sub := strings.ToLower(string(cmdLine[1]))
+	switch {
+	case sub == "load" && len(cmdLine) == 3:
+		sha, errReply := e.Load(cmdLine[2])
+		if errReply != nil {
+			return errReply
+		}
+		return resp.MakeBulkReply([]byte(sha))
+	case sub == "exists" && len(cmdLine) >= 3:
+		replies := make([]resp.Reply, 0, len(cmdLine)-2)
+		for _, sha := range cmdLine[2:] {
+			_, ok := e.lookup(string(sha))
+			replies = append(replies, resp.MakeIntReply(boolToInt(ok)))
+		}
+		return resp.MakeMultiRawReply(replies)
+	case sub == "flush" && len(cmdLine) <= 3:
+		if len(cmdLine) == 3 {
+			if mode := strings.ToLower(string(cmdLine[2])); mode != "async" && mode != "sync" {
+				return resp.MakeErrReply("ERR SCRIPT FLUSH only support SYNC|ASYNC option")
+			}
+		}
+		e.Flush()
+		return resp.MakeOkReply()
+	case sub == "kill" && len(cmdLine) == 2:
+		return e.Kill()
+	default:
+		return resp.MakeErrReply("ERR unknown subcommand or wrong number of arguments for '" + string(cmdLine[1]) + "'. Try SCRIPT HELP.")
+	}
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
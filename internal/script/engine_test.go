@@ -0,0 +1,135 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"goredis/internal/resp"
+)
+
+func evalArgs(body string, numKeys string, args ...string) [][]byte {
+	cmdLine := [][]byte{[]byte("eval"), []byte(body), []byte(numKeys)}
+	for _, arg := range args {
+		cmdLine = append(cmdLine, []byte(arg))
+	}
+	return cmdLine
+}
+
+// echoCall 把命令行原样返回，INCR 返回整数，ERR 开头的命令返回错误
+func echoCall(cmdLine [][]byte) resp.Reply {
+	switch strings.ToLower(string(cmdLine[0])) {
+	case "incr":
+		return resp.MakeIntReply(1)
+	case "ping":
+		return resp.MakeSimpleStringReply("PONG")
+	case "fail":
+		return resp.MakeErrReply("ERR failed")
+	}
+	return resp.MakeMultiBulkReply(cmdLine)
+}
+
+func eval(e *Engine, body string, numKeys string, args ...string) string {
+	reply := e.Eval(evalArgs(body, numKeys, args...), echoCall, true)
+	return string(reply.ToBytes())
+}
+
+func TestConversion(t *testing.T) {
+	e := NewEngine()
+	for _, tc := range []struct {
+		body string
+		want string
+	}{
+		{"return 'a'", "$1\r\na\r\n"},
+		{"return 3.99", ":3\r\n"},
+		{"return true", ":1\r\n"},
+		{"return false", "$-1\r\n"},
+		{"return nil", "$-1\r\n"},
+		{"return {1, 'x', {2}, nil, 3}", "*3\r\n:1\r\n$1\r\nx\r\n*1\r\n:2\r\n"},
+		{"return redis.status_reply('FINE')", "+FINE\r\n"},
+		{"return redis.error_reply('MY err')", "-MY err\r\n"},
+		{"return {KEYS[1], ARGV[1]}", "*2\r\n$1\r\nk\r\n$1\r\nv\r\n"},
+		// 回复转换为 Lua 值后再转换回来
+		{"return redis.call('incr', 'k')", ":1\r\n"},
+		{"return redis.call('ping')", "+PONG\r\n"},
+		{"return redis.call('echo', 1000000, 1.5)", "*3\r\n$4\r\necho\r\n$7\r\n1000000\r\n$3\r\n1.5\r\n"},
+		{"return redis.pcall('fail')", "-ERR failed\r\n"},
+		{"return redis.sha1hex('')", "$40\r\nda39a3ee5e6b4b0d3255bfef95601890afd80709\r\n"},
+	} {
+		if got := eval(e, tc.body, "1", "k", "v"); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.body, got, tc.want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	e := NewEngine()
+	sha := SHA1Hex([]byte("return redis.call('fail')"))
+	for _, tc := range []struct {
+		body    string
+		numKeys string
+		want    string
+	}{
+		{"return 1", "x", "-ERR value is not an integer or out of range\r\n"},
+		{"return 1", "-1", "-ERR Number of keys can't be negative\r\n"},
+		{"return 1", "2", "-ERR Number of keys can't be greater than number of args\r\n"},
+		{"return redis.call('fail')", "0", "-ERR failed script: " + sha + ", on @user_script:1.\r\n"},
+		{"local ok = pcall(redis.call, 'fail')\nreturn ok", "0", "$-1\r\n"},
+		{"return redis.call()", "0", "-ERR Please specify at least one argument for this redis lib call"},
+		{"return redis.call('get', {})", "0", "-ERR Lua redis lib command arguments must be strings or integers"},
+	} {
+		if got := eval(e, tc.body, tc.numKeys, "k"); !strings.HasPrefix(got, tc.want) {
+			t.Errorf("%s: got %q, want prefix %q", tc.body, got, tc.want)
+		}
+	}
+
+	if got := eval(e, "return (", "0"); !strings.HasPrefix(got, "-ERR Error compiling script") {
+		t.Errorf("unexpected compile error %q", got)
+	}
+	if got := eval(e, "\nlocal x = nil + 1", "0"); !strings.HasPrefix(got, "-ERR user_script:2:") || !strings.HasSuffix(got, "on @user_script:2.\r\n") {
+		t.Errorf("unexpected runtime error %q", got)
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	e := NewEngine()
+	if got := eval(e, "x = 1", "0"); !strings.Contains(got, "Script attempted to create global variable 'x'") {
+		t.Errorf("creating globals should fail, got %q", got)
+	}
+	if got := eval(e, "return y", "0"); !strings.Contains(got, "Script attempted to access nonexistent global variable 'y'") {
+		t.Errorf("accessing undefined globals should fail, got %q", got)
+	}
+	for _, lib := range []string{"os", "io", "dofile", "loadfile"} {
+		if got := eval(e, "return "+lib, "0"); !strings.HasPrefix(got, "-") {
+			t.Errorf("%s should not be available, got %q", lib, got)
+		}
+	}
+	if got := eval(e, "return string.rep(tostring(math.max(1, 2)), table.getn({1, 2}))", "0"); got != "$2\r\n22\r\n" {
+		t.Errorf("standard libs should be available, got %q", got)
+	}
+}
+
+func TestScriptCommand(t *testing.T) {
+	e := NewEngine()
+	load := e.ExecScript([][]byte{[]byte("script"), []byte("load"), []byte("return 1")})
+	sha := SHA1Hex([]byte("return 1"))
+	if got := string(load.ToBytes()); got != "$40\r\n"+sha+"\r\n" {
+		t.Fatalf("unexpected SCRIPT LOAD reply %q", got)
+	}
+
+	evalsha := [][]byte{[]byte("evalsha"), []byte(sha), []byte("0")}
+	if reply := e.Eval(evalsha, echoCall, true); string(reply.ToBytes()) != ":1\r\n" {
+		t.Errorf("unexpected EVALSHA reply %q", reply.ToBytes())
+	}
+	if line := e.EvalCmdLine(evalsha); string(line[0]) != "EVAL" || string(line[1]) != "return 1" {
+		t.Errorf("EVALSHA should be rewritten to EVAL, got %q", line)
+	}
+
+	reply := e.ExecScript([][]byte{[]byte("script"), []byte("flush"), []byte("now")})
+	if !resp.IsErrorReply(reply) {
+		t.Error("SCRIPT FLUSH with invalid mode should fail")
+	}
+	reply = e.ExecScript([][]byte{[]byte("script"), []byte("nosuch")})
+	if !strings.Contains(string(reply.ToBytes()), "Try SCRIPT HELP") {
+		t.Errorf("unexpected reply %q", reply.ToBytes())
+	}
+}
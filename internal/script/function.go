@@ -0,0 +1,582 @@
+package script
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc64"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"goredis/internal/resp"
+	"goredis/pkg/wildcard"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// functionChunkName 函数库代码在错误信息中显示的名字，与 Redis 一致
+const functionChunkName = "user_function"
+
+// loadTimeout 加载函数库时执行库代码的时间上限，库代码只应注册函数
+const loadTimeout = 500 * time.Millisecond
+
+// FUNCTION DUMP 的格式：magic + 版本 + 库数量 + { 代码长度 + 代码 } + CRC64（小端）
+const (
+	dumpMagic   = "GRFUNC"
+	dumpVersion = 1
+)
+
+var dumpCRCTable = crc64.MakeTable(crc64.ECMA)
+
+// 函数支持的标志
+var functionFlags = map[string]struct{}{
+	"no-writes":             {},
+	"allow-oom":             {},
+	"allow-stale":           {},
+	"no-cluster":            {},
+	"allow-cross-slot-keys": {},
+}
+
+type library struct {
+	name      string
+	code      []byte
+	functions map[string]*function
+}
+
+type function struct {
+	name  string
+	lib   *library
+	fn    *lua.LFunction
+	desc  string
+	flags []string
+	// 只读函数可以用 FCALL_RO 调用，也可以在 slave 上执行
+	noWrites bool
+}
+
+// registry 已加载的函数库。修改时先复制一份，全部校验通过后再替换，保证加载多个库时要么全部成功要么全部失败
+type registry struct {
+	libraries map[string]*library
+	functions map[string]*function
+}
+
+func newRegistry() *registry {
+	return &registry{
+		libraries: make(map[string]*library),
+		functions: make(map[string]*function),
+	}
+}
+
+func (reg *registry) clone() *registry {
+	c := newRegistry()
+	for name, lib := range reg.libraries {
+		c.libraries[name] = lib
+	}
+	for name, f := range reg.functions {
+		c.functions[name] = f
+	}
+	return c
+}
+
+func (reg *registry) remove(name string) {
+	lib, ok := reg.libraries[name]
+	if !ok {
+		return
+	}
+	for fname := range lib.functions {
+		delete(reg.functions, fname)
+	}
+	delete(reg.libraries, name)
+}
+
+func (reg *registry) add(lib *library, replace bool) resp.Reply {
+	if _, ok := reg.libraries[lib.name]; ok {
+		if !replace {
+			return resp.MakeErrReply("ERR Library '" + lib.name + "' already exists")
+		}
+		reg.remove(lib.name)
+	}
+	for name := range lib.functions {
+		if _, ok := reg.functions[name]; ok {
+			return resp.MakeErrReply("ERR Function " + name + " already exists")
+		}
+	}
+	reg.libraries[lib.name] = lib
+	for name, f := range lib.functions {
+		reg.functions[name] = f
+	}
+	return nil
+}
+
+// sortedLibraries 按库名排序，保证 FUNCTION LIST/DUMP 和持久化的输出稳定
+func (reg *registry) sortedLibraries() []*library {
+	libs := make([]*library, 0, len(reg.libraries))
+	for _, lib := range reg.libraries {
+		libs = append(libs, lib)
+	}
+	sort.Slice(libs, func(i, j int) bool { return libs[i].name < libs[j].name })
+	return libs
+}
+
+// validName 库名和函数名只能包含字母、数字和下划线
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, ch := range name {
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseMetadata 解析库代码第一行的 "#!lua name=<name>"，返回库名和去掉这一行的代码
+func parseMetadata(code []byte) (string, []byte, resp.Reply) {
+	first, rest, _ := bytes.Cut(code, []byte("\n"))
+	if !bytes.HasPrefix(first, []byte("#!")) {
+		return "", nil, resp.MakeErrReply("ERR Missing library metadata")
+	}
+	parts := strings.Fields(string(first[2:]))
+	if len(parts) == 0 || !strings.EqualFold(parts[0], "lua") {
+		engine := ""
+		if len(parts) > 0 {
+			engine = parts[0]
+		}
+		return "", nil, resp.MakeErrReply("ERR Engine '" + engine + "' not found")
+	}
+	var name string
+	for _, part := range parts[1:] {
+		value, found := strings.CutPrefix(part, "name=")
+		if !found {
+			return "", nil, resp.MakeErrReply("ERR Invalid metadata value given: " + part)
+		}
+		name = value
+	}
+	if name == "" {
+		return "", nil, resp.MakeErrReply("ERR Library name was not given")
+	}
+	if !validName(name) {
+		return "", nil, resp.MakeErrReply("ERR Library names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+	}
+	// 保留第一行的换行，错误信息中的行号与原始代码一致
+	return name, append([]byte("\n"), rest...), nil
+}
+
+// compileLibrary 编译并执行库代码，收集其中注册的函数，不修改已加载的函数库。调用方需持有数据库写锁
+func (e *Engine) compileLibrary(code []byte) (*library, resp.Reply) {
+	name, body, errReply := parseMetadata(code)
+	if errReply != nil {
+		return nil, errReply
+	}
+	chunk, err := parse.Parse(bytes.NewReader(body), functionChunkName)
+	if err != nil {
+		return nil, compileError(err)
+	}
+	proto, err := lua.Compile(chunk, functionChunkName)
+	if err != nil {
+		return nil, compileError(err)
+	}
+
+	lib := &library{name: name, code: code, functions: make(map[string]*function)}
+	ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+	defer cancel()
+
+	L := e.fL
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+	e.loading = lib
+	defer func() { e.loading = nil }()
+
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, 0, nil); err != nil {
+		msg := err.Error()
+		if ctx.Err() != nil {
+			msg = "FUNCTION LOAD timeout"
+		} else if apiErr, ok := err.(*lua.ApiError); ok {
+			msg = apiErr.Object.String()
+			// redis.call 抛出的错误表
+			if tbl, ok := apiErr.Object.(*lua.LTable); ok {
+				msg = tbl.RawGetString("err").String()
+			}
+		}
+		return nil, resp.MakeErrReply("ERR Error registering functions: " + msg)
+	}
+	if len(lib.functions) == 0 {
+		return nil, resp.MakeErrReply("ERR No functions registered")
+	}
+	return lib, nil
+}
+
+// registerFunction 实现 redis.register_function，支持 (name, callback) 和
+// {function_name=..., callback=..., flags={...}, description=...} 两种形式
+func (e *Engine) registerFunction(L *lua.LState) int {
+	lib := e.loading
+	if lib == nil {
+		L.RaiseError("redis.register_function can only be called on FUNCTION LOAD command")
+		return 0
+	}
+
+	f := &function{lib: lib}
+	var nameValue, callback lua.LValue
+	switch L.GetTop() {
+	case 1:
+		tbl, ok := L.Get(1).(*lua.LTable)
+		if !ok {
+			L.RaiseError("calling redis.register_function with a single argument is only applicable to Lua table (representing named arguments).")
+			return 0
+		}
+		var bad string
+		tbl.ForEach(func(k, v lua.LValue) {
+			switch k.String() {
+			case "function_name":
+				nameValue = v
+			case "callback":
+				callback = v
+			case "description":
+				if s, ok := v.(lua.LString); ok {
+					f.desc = string(s)
+				} else if bad == "" {
+					bad = "description argument given to redis.register_function must be a string"
+				}
+			case "flags":
+				flags, ok := v.(*lua.LTable)
+				if !ok {
+					bad = "flags argument to redis.register_function must be a table representing function flags"
+					return
+				}
+				for i := 1; i <= flags.Len(); i++ {
+					flag := flags.RawGetInt(i).String()
+					if _, ok := functionFlags[flag]; !ok {
+						bad = "unknown flag given"
+						return
+					}
+					f.flags = append(f.flags, flag)
+					f.noWrites = f.noWrites || flag == "no-writes"
+				}
+			default:
+				if bad == "" {
+					bad = "unknown argument given to redis.register_function"
+				}
+			}
+		})
+		if bad != "" {
+			L.RaiseError("%s", bad)
+			return 0
+		}
+	case 2:
+		nameValue, callback = L.Get(1), L.Get(2)
+	default:
+		L.RaiseError("wrong number of arguments to redis.register_function")
+		return 0
+	}
+
+	name, ok := nameValue.(lua.LString)
+	if !ok {
+		L.RaiseError("function_name argument given to redis.register_function must be a string")
+		return 0
+	}
+	fn, ok := callback.(*lua.LFunction)
+	if !ok {
+		L.RaiseError("callback argument given to redis.register_function must be a function")
+		return 0
+	}
+	if !validName(string(name)) {
+		L.RaiseError("Function names can only contain letters, numbers, or underscores(_) and must be at least one character long")
+		return 0
+	}
+	if _, ok := lib.functions[string(name)]; ok {
+		L.RaiseError("Function already exists in the library")
+		return 0
+	}
+	f.name, f.fn = string(name), fn
+	lib.functions[f.name] = f
+	return 0
+}
+
+// LoadLibrary 加载函数库 (FUNCTION LOAD)，返回库名。调用方需持有数据库写锁
+func (e *Engine) LoadLibrary(code []byte, replace bool) (string, resp.Reply) {
+	lib, errReply := e.compileLibrary(code)
+	if errReply != nil {
+		return "", errReply
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	reg := e.funcs.clone()
+	if errReply := reg.add(lib, replace); errReply != nil {
+		return "", errReply
+	}
+	e.funcs = reg
+	return lib.name, nil
+}
+
+// LibraryCodes 返回所有函数库的代码，用于写入 RDB 和重写 AOF
+func (e *Engine) LibraryCodes() [][]byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	libs := e.funcs.sortedLibraries()
+	codes := make([][]byte, 0, len(libs))
+	for _, lib := range libs {
+		codes = append(codes, lib.code)
+	}
+	return codes
+}
+
+// FlushFunctions 删除所有函数库 (FUNCTION FLUSH)
+func (e *Engine) FlushFunctions() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.funcs = newRegistry()
+}
+
+func (e *Engine) lookupFunction(name string) (*function, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	f, ok := e.funcs.functions[name]
+	return f, ok
+}
+
+// FunctionNoWrites 返回函数是否声明了 no-writes 标志，函数不存在时 ok 为 false
+func (e *Engine) FunctionNoWrites(name string) (noWrites bool, ok bool) {
+	f, ok := e.lookupFunction(name)
+	if !ok {
+		return false, false
+	}
+	return f.noWrites, true
+}
+
+// Call 执行 FCALL/FCALL_RO，参数和返回值与 Eval 相同。
+// 声明了 no-writes 的函数中不能执行写命令，FCALL_RO 只能调用这样的函数
+func (e *Engine) Call(cmdLine [][]byte, call CallFunc, limited bool) resp.Reply {
+	keys, args, errReply := splitKeys(cmdLine)
+	if errReply != nil {
+		return errReply
+	}
+	f, ok := e.lookupFunction(string(cmdLine[1]))
+	if !ok {
+		return resp.MakeErrReply("ERR Function not found")
+	}
+	if strings.ToLower(string(cmdLine[0])) == "fcall_ro" && !f.noWrites {
+		return resp.MakeErrReply("ERR Can not execute a script with write flag using *_ro command.")
+	}
+
+	L := e.fL
+	fnArgs := []lua.LValue{stringsTable(L, keys), stringsTable(L, args)}
+	return e.run(L, f.fn, fnArgs, call, limited, f.noWrites, func(err error) resp.Reply {
+		return errorReply(f.name, functionChunkName, err)
+	})
+}
+
+// DumpFunctions 序列化所有函数库 (FUNCTION DUMP)
+func (e *Engine) DumpFunctions() []byte {
+	codes := e.LibraryCodes()
+	buf := bytes.NewBufferString(dumpMagic)
+	buf.WriteByte(dumpVersion)
+	buf.Write(binary.AppendUvarint(nil, uint64(len(codes))))
+	for _, code := range codes {
+		buf.Write(binary.AppendUvarint(nil, uint64(len(code))))
+		buf.Write(code)
+	}
+	return binary.LittleEndian.AppendUint64(buf.Bytes(), crc64.Checksum(buf.Bytes(), dumpCRCTable))
+}
+
+// parseDump 校验并解析 FUNCTION DUMP 的输出，返回各个库的代码
+func parseDump(payload []byte) ([][]byte, bool) {
+	if len(payload) < len(dumpMagic)+1+8 {
+		return nil, false
+	}
+	body, sum := payload[:len(payload)-8], payload[len(payload)-8:]
+	if !bytes.HasPrefix(body, []byte(dumpMagic)) || body[len(dumpMagic)] != dumpVersion ||
+		crc64.Checksum(body, dumpCRCTable) != binary.LittleEndian.Uint64(sum) {
+		return nil, false
+	}
+
+	rest := body[len(dumpMagic)+1:]
+	count, n := binary.Uvarint(rest)
+	if n <= 0 {
+		return nil, false
+	}
+	rest = rest[n:]
+	var codes [][]byte
+	for i := uint64(0); i < count; i++ {
+		size, n := binary.Uvarint(rest)
+		if n <= 0 || uint64(len(rest)-n) < size {
+			return nil, false
+		}
+		codes = append(codes, rest[n:n+int(size)])
+		rest = rest[n+int(size):]
+	}
+	return codes, len(rest) == 0
+}
+
+// RestoreFunctions 恢复 FUNCTION DUMP 的输出 (FUNCTION RESTORE)，policy 为 APPEND、REPLACE 或 FLUSH。调用方需持有数据库写锁
+func (e *Engine) RestoreFunctions(payload []byte, policy string) resp.Reply {
+	codes, ok := parseDump(payload)
+	if !ok {
+		return resp.MakeErrReply("ERR payload version or checksum are wrong")
+	}
+	libs := make([]*library, 0, len(codes))
+	for _, code := range codes {
+		lib, errReply := e.compileLibrary(code)
+		if errReply != nil {
+			return errReply
+		}
+		libs = append(libs, lib)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	reg := e.funcs.clone()
+	if policy == "flush" {
+		reg = newRegistry()
+	}
+	for _, lib := range libs {
+		if errReply := reg.add(lib, policy == "replace"); errReply != nil {
+			return errReply
+		}
+	}
+	e.funcs = reg
+	return resp.MakeOkReply()
+}
+
+// listFunctions FUNCTION LIST [LIBRARYNAME pattern] [WITHCODE]
+func (e *Engine) listFunctions(args [][]byte) resp.Reply {
+	pattern, withCode := "", false
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "withcode":
+			withCode = true
+		case "libraryname":
+			if i+1 >= len(args) {
+				return resp.MakeErrReply("ERR library name argument was not given")
+			}
+			i++
+			pattern = string(args[i])
+		default:
+			return resp.MakeErrReply("ERR Unknown argument " + string(args[i]))
+		}
+	}
+
+	e.mu.Lock()
+	libs := e.funcs.sortedLibraries()
+	e.mu.Unlock()
+
+	replies := make([]resp.Reply, 0, len(libs))
+	for _, lib := range libs {
+		if pattern != "" && !wildcard.Match(pattern, lib.name) {
+			continue
+		}
+		names := make([]string, 0, len(lib.functions))
+		for name := range lib.functions {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		functions := make([]resp.Reply, 0, len(names))
+		for _, name := range names {
+			f := lib.functions[name]
+			var desc resp.Reply = resp.MakeNullBulkReply()
+			if f.desc != "" {
+				desc = resp.MakeBulkReply([]byte(f.desc))
+			}
+			flags := make([][]byte, 0, len(f.flags))
+			for _, flag := range f.flags {
+				flags = append(flags, []byte(flag))
+			}
+			functions = append(functions, resp.MakeMultiRawReply([]resp.Reply{
+				resp.MakeBulkReply([]byte("name")), resp.MakeBulkReply([]byte(f.name)),
+				resp.MakeBulkReply([]byte("description")), desc,
+				resp.MakeBulkReply([]byte("flags")), resp.MakeMultiBulkReply(flags),
+			}))
+		}
+
+		entry := []resp.Reply{
+			resp.MakeBulkReply([]byte("library_name")), resp.MakeBulkReply([]byte(lib.name)),
+			resp.MakeBulkReply([]byte("engine")), resp.MakeBulkReply([]byte("LUA")),
+			resp.MakeBulkReply([]byte("functions")), resp.MakeMultiRawReply(functions),
+		}
+		if withCode {
+			entry = append(entry, resp.MakeBulkReply([]byte("library_code")), resp.MakeBulkReply(lib.code))
+		}
+		replies = append(replies, resp.MakeMultiRawReply(entry))
+	}
+	return resp.MakeMultiRawReply(replies)
+}
+
+// ExecFunction 执行 FUNCTION 的子命令。LOAD/DELETE/FLUSH/RESTORE 会修改函数库，调用方需持有数据库写锁
+func (e *Engine) ExecFunction(cmdLine [][]byte) resp.Reply {
+	sub := strings.ToLower(string(cmdLine[1]))
+	switch {
+	case sub == "load" && len(cmdLine) >= 3 && len(cmdLine) <= 4:
+		replace := false
+		if len(cmdLine) == 4 {
+			if !strings.EqualFold(string(cmdLine[2]), "replace") {
+				return resp.MakeErrReply("ERR Unknown option given: " + string(cmdLine[2]))
+			}
+			replace = true
+		}
+		name, errReply := e.LoadLibrary(cmdLine[len(cmdLine)-1], replace)
+		if errReply != nil {
+			return errReply
+		}
+		return resp.MakeBulkReply([]byte(name))
+	case sub == "delete" && len(cmdLine) == 3:
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		name := string(cmdLine[2])
+		if _, ok := e.funcs.libraries[name]; !ok {
+			return resp.MakeErrReply("ERR Library not found")
+		}
+		reg := e.funcs.clone()
+		reg.remove(name)
+		e.funcs = reg
+		return resp.MakeOkReply()
+	case sub == "flush" && len(cmdLine) <= 3:
+		if len(cmdLine) == 3 {
+			if mode := strings.ToLower(string(cmdLine[2])); mode != "async" && mode != "sync" {
+				return resp.MakeErrReply("ERR FUNCTION FLUSH only supports SYNC|ASYNC option")
+			}
+		}
+		e.FlushFunctions()
+		return resp.MakeOkReply()
+	case sub == "list":
+		return e.listFunctions(cmdLine[2:])
+	case sub == "dump" && len(cmdLine) == 2:
+		return resp.MakeBulkReply(e.DumpFunctions())
+	case sub == "restore" && len(cmdLine) >= 3 && len(cmdLine) <= 4:
+		policy := "append"
+		if len(cmdLine) == 4 {
+			policy = strings.ToLower(string(cmdLine[3]))
+			if policy != "append" && policy != "replace" && policy != "flush" {
+				return resp.MakeErrReply("ERR Wrong restore policy given, value should be either FLUSH, APPEND or REPLACE.")
+			}
+		}
+		return e.RestoreFunctions(cmdLine[2], policy)
+	case sub == "kill" && len(cmdLine) == 2:
+		return e.Kill()
+	default:
+		return resp.MakeErrReply("ERR unknown subcommand or wrong number of arguments for '" + string(cmdLine[1]) + "'. Try FUNCTION HELP.")
+	}
+}
+
+// splitKeys 按 numkeys 拆分 EVAL/FCALL 的 key 和参数
+func splitKeys(cmdLine [][]byte) (keys, args [][]byte, errReply resp.Reply) {
+	numKeys, err := strconv.Atoi(string(cmdLine[2]))
+	if err != nil {
+		return nil, nil, resp.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if numKeys < 0 {
+		return nil, nil, resp.MakeErrReply("ERR Number of keys can't be negative")
+	}
+	if numKeys > len(cmdLine)-3 {
+		return nil, nil, resp.MakeErrReply("ERR Number of keys can't be greater than number of args")
+	}
+	return cmdLine[3 : 3+numKeys], cmdLine[3+numKeys:], nil
+}
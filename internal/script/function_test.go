@@ -0,0 +1,183 @@
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"goredis/internal/resp"
+)
+
+const testLibrary = "#!lua name=mylib\n" +
+	"redis.register_function('echo', function(keys, args) return {keys[1], args[1]} end)\n" +
+	"redis.register_function{function_name='ro', callback=function(keys) return redis.call('get', keys[1]) end, " +
+	"flags={'no-writes'}, description='read only'}\n" +
+	"redis.register_function{function_name='bad_ro', callback=function(keys) return redis.call('set', keys[1], 'v') end, flags={'no-writes'}}\n" +
+	"redis.register_function('fail', function()\n\tlocal x = nil + 1\nend)"
+
+func functionCmd(args ...string) [][]byte {
+	cmdLine := [][]byte{[]byte("function")}
+	for _, arg := range args {
+		cmdLine = append(cmdLine, []byte(arg))
+	}
+	return cmdLine
+}
+
+func fcall(e *Engine, args ...string) string {
+	cmdLine := make([][]byte, 0, len(args))
+	for _, arg := range args {
+		cmdLine = append(cmdLine, []byte(arg))
+	}
+	reply := e.Call(cmdLine, echoCall, true)
+	return string(reply.ToBytes())
+}
+
+func TestFunctionLoad(t *testing.T) {
+	t.Run("metadata and registration errors", func(t *testing.T) {
+		e := NewEngine()
+		for _, tc := range []struct {
+			code string
+			want string
+		}{
+			{"return 1", "ERR Missing library metadata"},
+			{"#!js name=x\n", "ERR Engine 'js' not found"},
+			{"#!lua foo=x\n", "ERR Invalid metadata value given: foo=x"},
+			{"#!lua\n", "ERR Library name was not given"},
+			{"#!lua name=a-b\n", "ERR Library names can only contain"},
+			{"#!lua name=x\nreturn 1", "ERR No functions registered"},
+			{"#!lua name=x\nredis.register_function('f')", "ERR Error registering functions:"},
+			{"#!lua name=x\nredis.register_function{function_name='f', callback=function() end, flags={'bogus'}}", "unknown flag given"},
+			{"#!lua name=x\nwhile true do end", "FUNCTION LOAD timeout"},
+			{"#!lua name=x\nredis.call('ping')", "only available while running a script"},
+		} {
+			_, errReply := e.LoadLibrary([]byte(tc.code), false)
+			if errReply == nil || !strings.Contains(string(errReply.ToBytes()), tc.want) {
+				t.Errorf("%q: got %v, want %q", tc.code, errReply, tc.want)
+			}
+		}
+		if codes := e.LibraryCodes(); len(codes) != 0 {
+			t.Errorf("failed loads should not register libraries, got %q", codes)
+		}
+	})
+
+	t.Run("load replace and conflicts", func(t *testing.T) {
+		e := NewEngine()
+		if name, errReply := e.LoadLibrary([]byte(testLibrary), false); errReply != nil || name != "mylib" {
+			t.Fatalf("LoadLibrary failed: %v %v", name, errReply)
+		}
+		if _, errReply := e.LoadLibrary([]byte(testLibrary), false); errReply == nil || !strings.Contains(string(errReply.ToBytes()), "already exists") {
+			t.Errorf("loading twice should fail, got %v", errReply)
+		}
+		if _, errReply := e.LoadLibrary([]byte("#!lua name=other\nredis.register_function('echo', function() end)"), false); errReply == nil {
+			t.Error("function names should be unique across libraries")
+		}
+		// REPLACE 时旧库中的函数被移除
+		if _, errReply := e.LoadLibrary([]byte("#!lua name=mylib\nredis.register_function('only', function() return 1 end)"), true); errReply != nil {
+			t.Fatalf("REPLACE failed: %v", errReply)
+		}
+		if got := fcall(e, "fcall", "echo", "0"); got != "-ERR Function not found\r\n" {
+			t.Errorf("replaced function should be removed, got %q", got)
+		}
+		if got := fcall(e, "fcall", "only", "0"); got != ":1\r\n" {
+			t.Errorf("unexpected reply %q", got)
+		}
+	})
+
+	t.Run("register_function outside FUNCTION LOAD", func(t *testing.T) {
+		e := NewEngine()
+		if got := eval(e, "redis.register_function('f', function() end)", "0"); !strings.Contains(got, "can only be called on FUNCTION LOAD command") {
+			t.Errorf("unexpected reply %q", got)
+		}
+	})
+}
+
+func TestFunctionCall(t *testing.T) {
+	e := NewEngine()
+	if _, errReply := e.LoadLibrary([]byte(testLibrary), false); errReply != nil {
+		t.Fatalf("LoadLibrary failed: %v", errReply)
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"fcall", "echo", "1", "k", "v"}, "*2\r\n$1\r\nk\r\n$1\r\nv\r\n"},
+		{[]string{"fcall", "nosuch", "0"}, "-ERR Function not found\r\n"},
+		{[]string{"fcall", "echo", "2", "k"}, "-ERR Number of keys can't be greater than number of args\r\n"},
+		{[]string{"fcall_ro", "echo", "0"}, "-ERR Can not execute a script with write flag using *_ro command.\r\n"},
+		{[]string{"fcall_ro", "ro", "1", "k"}, "*2\r\n$3\r\nget\r\n$1\r\nk\r\n"},
+		{[]string{"fcall", "bad_ro", "1", "k"}, "-ERR Write commands are not allowed from read-only scripts. script: bad_ro, on @user_function:4.\r\n"},
+		{[]string{"fcall", "fail", "0"}, "-ERR user_function:6: "},
+	} {
+		if got := fcall(e, tc.args...); !strings.HasPrefix(got, tc.want) {
+			t.Errorf("%v: got %q, want %q", tc.args, got, tc.want)
+		}
+	}
+	if noWrites, ok := e.FunctionNoWrites("ro"); !ok || !noWrites {
+		t.Error("ro should be a no-writes function")
+	}
+	if noWrites, ok := e.FunctionNoWrites("echo"); !ok || noWrites {
+		t.Error("echo may write")
+	}
+}
+
+func TestFunctionCommand(t *testing.T) {
+	e := NewEngine()
+	if got := string(e.ExecFunction(functionCmd("load", testLibrary)).ToBytes()); got != "$5\r\nmylib\r\n" {
+		t.Fatalf("unexpected FUNCTION LOAD reply %q", got)
+	}
+	e.ExecFunction(functionCmd("load", "#!lua name=zlib\nredis.register_function('z', function() end)"))
+
+	list := string(e.ExecFunction(functionCmd("list", "libraryname", "my*", "withcode")).ToBytes())
+	if !strings.HasPrefix(list, "*1\r\n*8\r\n$12\r\nlibrary_name\r\n$5\r\nmylib\r\n$6\r\nengine\r\n$3\r\nLUA\r\n$9\r\nfunctions\r\n*4\r\n") ||
+		!strings.Contains(list, "$11\r\ndescription\r\n$9\r\nread only\r\n$5\r\nflags\r\n*1\r\n$9\r\nno-writes\r\n") ||
+		!strings.HasSuffix(list, "$12\r\nlibrary_code\r\n$"+itoa(len(testLibrary))+"\r\n"+testLibrary+"\r\n") {
+		t.Errorf("unexpected FUNCTION LIST reply %q", list)
+	}
+
+	dump := e.ExecFunction(functionCmd("dump")).(*resp.BulkReply).Arg
+	e.ExecFunction(functionCmd("flush"))
+	if got := string(e.ExecFunction(functionCmd("list")).ToBytes()); got != "*0\r\n" {
+		t.Errorf("FUNCTION FLUSH should remove all libraries, got %q", got)
+	}
+
+	if reply := e.ExecFunction(functionCmd("restore", string(dump))); !isOK(reply) {
+		t.Fatalf("FUNCTION RESTORE failed: %q", reply.ToBytes())
+	}
+	if got := fcall(e, "fcall", "z", "0"); got != "$-1\r\n" {
+		t.Errorf("restored function should be callable, got %q", got)
+	}
+	// APPEND 遇到同名库时整体失败，REPLACE 覆盖，FLUSH 先清空
+	if reply := e.ExecFunction(functionCmd("restore", string(dump))); !strings.Contains(string(reply.ToBytes()), "already exists") {
+		t.Errorf("APPEND should fail on existing libraries, got %q", reply.ToBytes())
+	}
+	e.ExecFunction(functionCmd("delete", "zlib"))
+	e.ExecFunction(functionCmd("load", "#!lua name=extra\nredis.register_function('x', function() end)"))
+	if reply := e.ExecFunction(functionCmd("restore", string(dump), "replace")); !isOK(reply) {
+		t.Errorf("REPLACE failed: %q", reply.ToBytes())
+	}
+	if reply := e.ExecFunction(functionCmd("restore", string(dump), "flush")); !isOK(reply) || len(e.LibraryCodes()) != 2 {
+		t.Errorf("FLUSH policy should leave only the dumped libraries, got %q", e.LibraryCodes())
+	}
+
+	corrupted := append([]byte(nil), dump...)
+	corrupted[10] ^= 0xFF
+	for _, args := range [][]string{
+		{"restore", string(corrupted)},
+		{"restore", string(dump), "merge"},
+		{"delete", "nosuch"},
+		{"load", "nope", testLibrary},
+		{"nosuch"},
+	} {
+		if reply := e.ExecFunction(functionCmd(args...)); !resp.IsErrorReply(reply) {
+			t.Errorf("%v should fail, got %q", args[0], reply.ToBytes())
+		}
+	}
+}
+
+func isOK(reply resp.Reply) bool {
+	return string(reply.ToBytes()) == "+OK\r\n"
+}
+
+func itoa(n int) string {
+	return strings.TrimSpace(string(resp.MakeIntReply(int64(n)).ToBytes()[1:]))
+}
@@ -0,0 +1,300 @@
+package script
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"goredis/internal/resp"
+	"goredis/internal/types"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// 脚本中不能执行的命令：事务、脚本本身以及 SAVE 这类耗时的命令
+var noScriptCmds = map[string]struct{}{
+	"multi": {}, "exec": {}, "discard": {}, "watch": {}, "unwatch": {},
+	"eval": {}, "evalsha": {}, "script": {},
+	"fcall": {}, "fcall_ro": {}, "function": {},
+	"save": {}, "bgsave": {},
+}
+
+// newLState 创建沙箱化的虚拟机：只开放 base/table/string/math 库，并禁止创建全局变量
+func (e *Engine) newLState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	// 脚本不能访问文件
+	for _, name := range []string{"dofile", "loadfile"} {
+		L.G.Global.RawSetString(name, lua.LNil)
+	}
+
+	L.G.Global.RawSetString("redis", e.redisLib(L))
+
+	mt := L.NewTable()
+	mt.RawSetString("__newindex", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("Script attempted to create global variable '%s'", L.CheckAny(2).String())
+		return 0
+	}))
+	mt.RawSetString("__index", L.NewFunction(func(L *lua.LState) int {
+		L.RaiseError("Script attempted to access nonexistent global variable '%s'", L.CheckAny(2).String())
+		return 0
+	}))
+	L.SetMetatable(L.G.Global, mt)
+	return L
+}
+
+// redisLib 脚本中的 redis 表
+func (e *Engine) redisLib(L *lua.LState) *lua.LTable {
+	lib := L.NewTable()
+	L.SetFuncs(lib, map[string]lua.LGFunction{
+		"call": func(L *lua.LState) int {
+			return e.redisCall(L, true)
+		},
+		"pcall": func(L *lua.LState) int {
+			return e.redisCall(L, false)
+		},
+		"error_reply": func(L *lua.LState) int {
+			L.Push(replyTable(L, "err", L.CheckString(1)))
+			return 1
+		},
+		"status_reply": func(L *lua.LState) int {
+			L.Push(replyTable(L, "ok", L.CheckString(1)))
+			return 1
+		},
+		"sha1hex": func(L *lua.LState) int {
+			L.Push(lua.LString(SHA1Hex([]byte(L.CheckString(1)))))
+			return 1
+		},
+		"log": func(L *lua.LState) int {
+			level := L.CheckInt(1)
+			parts := make([]string, 0, L.GetTop()-1)
+			for i := 2; i <= L.GetTop(); i++ {
+				parts = append(parts, L.ToString(i))
+			}
+			log.Printf("[script] <%d> %s", level, strings.Join(parts, " "))
+			return 0
+		},
+		"register_function": e.registerFunction,
+		// 复制方式由服务器配置决定，保留这个函数只是为了兼容旧脚本
+		"replicate_commands": func(L *lua.LState) int {
+			L.Push(lua.LTrue)
+			return 1
+		},
+	})
+	for i, name := range []string{"LOG_DEBUG", "LOG_VERBOSE", "LOG_NOTICE", "LOG_WARNING"} {
+		lib.RawSetString(name, lua.LNumber(i))
+	}
+	return lib
+}
+
+// redisCall 实现 redis.call 和 redis.pcall：命令出错时前者抛出错误，后者返回错误表
+func (e *Engine) redisCall(L *lua.LState, raise bool) int {
+	e.runMu.Lock()
+	r := e.running
+	e.runMu.Unlock()
+
+	var reply resp.Reply
+	cmdLine, errReply := callArgs(L)
+	switch {
+	case errReply != nil:
+		reply = errReply
+	case r == nil:
+		reply = resp.MakeErrReply("ERR redis.call is only available while running a script")
+	default:
+		if _, denied := noScriptCmds[strings.ToLower(string(cmdLine[0]))]; denied {
+			reply = resp.MakeErrReply("ERR This Redis command is not allowed from script")
+			break
+		}
+		if types.CmdLine(cmdLine).IsWrite() {
+			if r.readOnly {
+				reply = resp.MakeErrReply("ERR Write commands are not allowed from read-only scripts.")
+				break
+			}
+			if !e.markWrote(r) {
+				// 脚本已经被中止，抛出错误后由 run 返回中止的原因
+				reply = resp.MakeErrReply("ERR script stopped")
+				break
+			}
+		}
+		reply = r.call(cmdLine)
+	}
+
+	if raise && resp.IsErrorReply(reply) {
+		tbl := replyTable(L, "err", errorMessage(reply))
+		// 记录出错的位置，脚本没有捕获时附加在错误信息中
+		tbl.RawSetString("where", lua.LString(L.Where(1)))
+		L.Error(tbl, 0)
+		return 0
+	}
+	L.Push(replyToLua(L, reply))
+	return 1
+}
+
+// callArgs 把 redis.call 的参数转换成命令行，参数只能是字符串或数字
+func callArgs(L *lua.LState) ([][]byte, resp.Reply) {
+	n := L.GetTop()
+	if n == 0 {
+		return nil, resp.MakeErrReply("ERR Please specify at least one argument for this redis lib call")
+	}
+	cmdLine := make([][]byte, 0, n)
+	for i := 1; i <= n; i++ {
+		switch v := L.Get(i).(type) {
+		case lua.LString:
+			cmdLine = append(cmdLine, []byte(v))
+		case lua.LNumber:
+			cmdLine = append(cmdLine, []byte(formatNumber(v)))
+		default:
+			return nil, resp.MakeErrReply("ERR Lua redis lib command arguments must be strings or integers")
+		}
+	}
+	return cmdLine, nil
+}
+
+// formatNumber 整数不带小数点，避免 1e+06 这样的格式
+func formatNumber(n lua.LNumber) string {
+	f := float64(n)
+	if f == math.Trunc(f) && math.Abs(f) < 1e15 {
+		return strconv.FormatInt(int64(f), 10)
+	}
+	return strconv.FormatFloat(f, 'g', 17, 64)
+}
+
+func stringsTable(L *lua.LState, args [][]byte) *lua.LTable {
+	tbl := L.CreateTable(len(args), 0)
+	for _, arg := range args {
+		tbl.Append(lua.LString(arg))
+	}
+	return tbl
+}
+
+func replyTable(L *lua.LState, field, msg string) *lua.LTable {
+	tbl := L.CreateTable(0, 1)
+	tbl.RawSetString(field, lua.LString(msg))
+	return tbl
+}
+
+// errorMessage 取出错误回复的内容，不含开头的 '-' 和结尾的 CRLF
+func errorMessage(reply resp.Reply) string {
+	if r, ok := reply.(*resp.StandardErrReply); ok {
+		return r.Status
+	}
+	return strings.TrimSuffix(string(reply.ToBytes()[1:]), "\r\n")
+}
+
+// replyToLua 按 Redis 的规则把命令的回复转换成 Lua 值：
+// 整数转为 number，bulk 转为 string，nil 转为 false，状态和错误分别转为带 ok、err 字段的表
+func replyToLua(L *lua.LState, reply resp.Reply) lua.LValue {
+	switch r := reply.(type) {
+	case *resp.IntReply:
+		return lua.LNumber(r.IntVal)
+	case *resp.BulkReply:
+		if r.Arg == nil {
+			return lua.LFalse
+		}
+		return lua.LString(r.Arg)
+	case *resp.SimpleStringReply:
+		return replyTable(L, "ok", r.Status)
+	case *resp.StandardErrReply:
+		return replyTable(L, "err", r.Status)
+	case *resp.MultiBulkReply:
+		tbl := L.CreateTable(len(r.Args), 0)
+		for _, arg := range r.Args {
+			if arg == nil {
+				tbl.Append(lua.LFalse)
+			} else {
+				tbl.Append(lua.LString(arg))
+			}
+		}
+		return tbl
+	case *resp.MultiRawReply:
+		tbl := L.CreateTable(len(r.Replies), 0)
+		for _, sub := range r.Replies {
+			tbl.Append(replyToLua(L, sub))
+		}
+		return tbl
+	default:
+		if resp.IsErrorReply(reply) {
+			return replyTable(L, "err", errorMessage(reply))
+		}
+		return lua.LFalse
+	}
+}
+
+// luaToReply 按 Redis 的规则把脚本的返回值转换成回复：
+// number 截断为整数，true 转为 1，false 和 nil 转为 nil，数组遇到第一个 nil 结束
+func luaToReply(lv lua.LValue) resp.Reply {
+	switch v := lv.(type) {
+	case lua.LString:
+		return resp.MakeBulkReply([]byte(v))
+	case lua.LNumber:
+		return resp.MakeIntReply(int64(v))
+	case lua.LBool:
+		if v {
+			return resp.MakeIntReply(1)
+		}
+		return resp.MakeNullBulkReply()
+	case *lua.LTable:
+		if msg, ok := v.RawGetString("err").(lua.LString); ok {
+			return resp.MakeErrReply(string(msg))
+		}
+		if status, ok := v.RawGetString("ok").(lua.LString); ok {
+			return resp.MakeSimpleStringReply(string(status))
+		}
+		replies := make([]resp.Reply, 0, v.Len())
+		for i := 1; ; i++ {
+			item := v.RawGetInt(i)
+			if item == lua.LNil {
+				break
+			}
+			replies = append(replies, luaToReply(item))
+		}
+		return resp.MakeMultiRawReply(replies)
+	default:
+		return resp.MakeNullBulkReply()
+	}
+}
+
+// errorReply 脚本执行出错时的回复，附加脚本的 SHA1（函数则是函数名）和出错的行号
+func errorReply(sha, chunk string, err error) resp.Reply {
+	var msg, where string
+	apiErr, ok := err.(*lua.ApiError)
+	if !ok {
+		return resp.MakeErrReply("ERR " + err.Error() + " script: " + sha)
+	}
+	switch obj := apiErr.Object.(type) {
+	case *lua.LTable:
+		errMsg, isErr := obj.RawGetString("err").(lua.LString)
+		if !isErr {
+			return resp.MakeErrReply("ERR " + obj.String() + " script: " + sha)
+		}
+		msg = string(errMsg)
+		if w, ok := obj.RawGetString("where").(lua.LString); ok {
+			where = strings.TrimSuffix(string(w), ":")
+		}
+	default:
+		// Lua 运行时错误形如 "user_script:1: message"
+		msg = "ERR " + obj.String()
+		if rest, found := strings.CutPrefix(msg, "ERR "+chunk+":"); found {
+			if line, _, found := strings.Cut(rest, ":"); found {
+				where = chunk + ":" + line
+			}
+		}
+	}
+	if where == "" {
+		return resp.MakeErrReply(msg + " script: " + sha)
+	}
+	return resp.MakeErrReply(msg + " script: " + sha + ", on @" + where + ".")
+}
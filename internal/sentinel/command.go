@@ -0,0 +1,270 @@
+package sentinel
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"goredis/internal/common"
+	"goredis/internal/resp"
+	"goredis/pkg/parser"
+)
+
+func (s *Sentinel) handleConn(conn net.Conn) {
+	defer conn.Close()
+	p := parser.NewParser(conn)
+	for {
+		payload, err := p.Parse()
+		if err != nil {
+			return
+		}
+		cmdLine, ok := common.ToCmdLine(payload)
+		if !ok || len(cmdLine) == 0 {
+			continue
+		}
+		if _, err := conn.Write(s.exec(cmdLine).ToBytes()); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Sentinel) exec(cmdLine [][]byte) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	switch cmdName {
+	case "ping":
+		return resp.MakeSimpleStringReply("PONG")
+	case "sentinel":
+		if len(cmdLine) < 2 {
+			return resp.MakeArgNumErrReply(cmdName)
+		}
+		return s.execSentinel(cmdLine[1:])
+	case "info":
+		return s.execInfo()
+	default:
+		return resp.MakeErrReply("ERR unknown command '" + cmdName + "'")
+	}
+}
+
+// sentinel 子命令及参数个数（不含子命令本身）
+var sentinelCmdArity = map[string]int{
+	"masters":                 0, // sentinel masters
+	"master":                  1, // sentinel master name
+	"replicas":                1, // sentinel replicas name
+	"slaves":                  1, // replicas 的旧名字
+	"sentinels":               1, // sentinel sentinels name
+	"get-master-addr-by-name": 1, // sentinel get-master-addr-by-name name
+	"is-master-down-by-addr":  4, // sentinel is-master-down-by-addr ip port current-epoch runid
+	"failover":                1, // sentinel failover name
+	"ckquorum":                1, // sentinel ckquorum name
+	"myid":                    0, // sentinel myid
+}
+
+func (s *Sentinel) execSentinel(args [][]byte) resp.Reply {
+	sub := strings.ToLower(string(args[0]))
+	arity, ok := sentinelCmdArity[sub]
+	if !ok {
+		return resp.MakeErrReply("ERR Unknown sentinel subcommand '" + sub + "'")
+	}
+	if len(args)-1 != arity {
+		return resp.MakeErrReply("ERR wrong number of arguments for 'sentinel|" + sub + "' command")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+
+	switch sub {
+	case "masters":
+		replies := make([]resp.Reply, 0, len(s.masters))
+		for _, name := range sortedKeys(s.masters) {
+			replies = append(replies, resp.MakeMultiBulkReply(masterFields(s.masters[name], now)))
+		}
+		return resp.MakeMultiRawReply(replies)
+	case "myid":
+		return resp.MakeBulkReply([]byte(s.runID))
+	case "is-master-down-by-addr":
+		epoch, err := strconv.ParseInt(string(args[3]), 10, 64)
+		if err != nil {
+			return resp.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		addr := net.JoinHostPort(string(args[1]), string(args[2]))
+		down, leader, leaderEpoch := s.isMasterDown(addr, epoch, string(args[4]), now)
+		downState := int64(0)
+		if down {
+			downState = 1
+		}
+		return resp.MakeMultiRawReply([]resp.Reply{
+			resp.MakeIntReply(downState),
+			resp.MakeBulkReply([]byte(leader)),
+			resp.MakeIntReply(leaderEpoch),
+		})
+	}
+
+	m, ok := s.masters[string(args[1])]
+	if !ok {
+		if sub == "get-master-addr-by-name" {
+			return resp.MakeNullMultiBulkReply()
+		}
+		return resp.MakeErrReply("ERR No such master with that name")
+	}
+	switch sub {
+	case "master":
+		return resp.MakeMultiBulkReply(masterFields(m, now))
+	case "replicas", "slaves":
+		replies := make([]resp.Reply, 0, len(m.replicas))
+		for _, addr := range sortedKeys(m.replicas) {
+			replies = append(replies, resp.MakeMultiBulkReply(replicaFields(m.replicas[addr], now)))
+		}
+		return resp.MakeMultiRawReply(replies)
+	case "sentinels":
+		replies := make([]resp.Reply, 0, len(m.sentinels))
+		for _, runID := range sortedKeys(m.sentinels) {
+			replies = append(replies, resp.MakeMultiBulkReply(peerFields(m.sentinels[runID], now)))
+		}
+		return resp.MakeMultiRawReply(replies)
+	case "get-master-addr-by-name":
+		host, port, _ := net.SplitHostPort(m.inst.addr)
+		return resp.MakeMultiBulkReply([][]byte{[]byte(host), []byte(port)})
+	case "failover":
+		if m.failoverState != failoverNone {
+			return resp.MakeErrReply("INPROG Failover already in progress")
+		}
+		if selectReplica(m, now) == nil {
+			return resp.MakeErrReply("NOGOODSLAVE No suitable replica to promote")
+		}
+		s.startFailover(m, now, true)
+		return resp.MakeOkReply()
+	default: // ckquorum
+		usable := 1
+		for _, p := range m.sentinels {
+			if now.Sub(p.lastHello) < 5*helloPeriod {
+				usable++
+			}
+		}
+		voters := len(m.sentinels) + 1
+		if usable < m.Quorum {
+			return resp.MakeErrReply(fmt.Sprintf("NOQUORUM %d usable Sentinels. Not enough available Sentinels to reach the specified quorum for this master", usable))
+		}
+		if usable < voters/2+1 {
+			return resp.MakeErrReply(fmt.Sprintf("NOQUORUM %d usable Sentinels. Not enough available Sentinels to reach the majority and authorize a failover", usable))
+		}
+		return resp.MakeSimpleStringReply(fmt.Sprintf("OK %d usable Sentinels. Quorum and failover authorization can be reached", usable))
+	}
+}
+
+// execInfo INFO 只有 sentinel 一节，格式与 Redis 一致
+func (s *Sentinel) execInfo() resp.Reply {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b strings.Builder
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format+"\r\n", args...)
+	}
+	line("# Sentinel")
+	line("sentinel_masters:%d", len(s.masters))
+	for i, name := range sortedKeys(s.masters) {
+		m := s.masters[name]
+		status := "ok"
+		if m.odown {
+			status = "odown"
+		} else if m.inst.sdown {
+			status = "sdown"
+		}
+		line("master%d:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d",
+			i, m.Name, status, m.inst.addr, len(m.replicas), len(m.sentinels)+1)
+	}
+	return resp.MakeBulkReply([]byte(b.String()))
+}
+
+func masterFields(m *master, now time.Time) [][]byte {
+	flags := "master"
+	if m.inst.sdown {
+		flags += ",s_down"
+	}
+	if m.odown {
+		flags += ",o_down"
+	}
+	if m.failoverState != failoverNone {
+		flags += ",failover_in_progress"
+	}
+	host, port, _ := net.SplitHostPort(m.inst.addr)
+	return fieldsReply(
+		"name", m.Name,
+		"ip", host,
+		"port", port,
+		"flags", flags,
+		"last-ping-reply", msSince(now, m.inst.lastPong),
+		"role-reported", m.inst.role,
+		"num-slaves", strconv.Itoa(len(m.replicas)),
+		"num-other-sentinels", strconv.Itoa(len(m.sentinels)),
+		"quorum", strconv.Itoa(m.Quorum),
+		"down-after-milliseconds", strconv.FormatInt(m.DownAfter.Milliseconds(), 10),
+		"failover-timeout", strconv.FormatInt(m.FailoverTimeout.Milliseconds(), 10),
+		"config-epoch", strconv.FormatInt(m.configEpoch, 10),
+		"failover-state", failoverStateNames[m.failoverState],
+	)
+}
+
+func replicaFields(r *instance, now time.Time) [][]byte {
+	flags := "slave"
+	if r.sdown {
+		flags += ",s_down"
+	}
+	host, port, _ := net.SplitHostPort(r.addr)
+	masterHost, masterPort, _ := net.SplitHostPort(r.masterAddr)
+	linkStatus := "err"
+	if r.masterLinkUp {
+		linkStatus = "ok"
+	}
+	return fieldsReply(
+		"name", r.addr,
+		"ip", host,
+		"port", port,
+		"flags", flags,
+		"last-ping-reply", msSince(now, r.lastPong),
+		"role-reported", r.role,
+		"master-host", masterHost,
+		"master-port", masterPort,
+		"master-link-status", linkStatus,
+		"slave-repl-offset", strconv.FormatInt(r.offset, 10),
+	)
+}
+
+func peerFields(p *peer, now time.Time) [][]byte {
+	host, port, _ := net.SplitHostPort(p.addr)
+	return fieldsReply(
+		"name", p.runID,
+		"ip", host,
+		"port", port,
+		"runid", p.runID,
+		"flags", "sentinel",
+		"last-hello-message", msSince(now, p.lastHello),
+		"voted-leader", p.leader,
+		"voted-leader-epoch", strconv.FormatInt(p.leaderEpoch, 10),
+	)
+}
+
+func fieldsReply(kvs ...string) [][]byte {
+	args := make([][]byte, len(kvs))
+	for i, kv := range kvs {
+		args[i] = []byte(kv)
+	}
+	return args
+}
+
+func msSince(now, t time.Time) string {
+	return strconv.FormatInt(now.Sub(t).Milliseconds(), 10)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,287 @@
+package sentinel
+
+import (
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// checkSDown 超过 DownAfter 没有正常回复 PING 的实例主观下线
+func (s *Sentinel) checkSDown(m *master, now time.Time) {
+	check := func(kind string, inst *instance) {
+		sdown := now.Sub(inst.lastPong) > m.DownAfter
+		if sdown == inst.sdown {
+			return
+		}
+		inst.sdown = sdown
+		if sdown {
+			log.Printf("[sentinel] +sdown %s %s @ %s", kind, inst.addr, m.Name)
+		} else {
+			log.Printf("[sentinel] -sdown %s %s @ %s", kind, inst.addr, m.Name)
+		}
+	}
+	check("master", m.inst)
+	for _, r := range m.replicas {
+		check("slave", r)
+	}
+}
+
+// askPeers master 主观下线时每隔 askPeriod 询问其他 sentinel 是否也认为它下线；
+// 故障转移进行中时同时请求它们投票给自己
+func (s *Sentinel) askPeers(m *master, now time.Time) {
+	if !m.inst.sdown {
+		for _, p := range m.sentinels {
+			p.masterDown = false
+		}
+		return
+	}
+	host, port, _ := net.SplitHostPort(m.inst.addr)
+	// 请求投票时使用自己发起的故障转移的 epoch，之后 currentEpoch 可能因为其他 sentinel 参选而增大，
+	// 在那个 epoch 中拉票只会分走别人的选票
+	candidate, epoch := "*", s.currentEpoch
+	if m.failoverState != failoverNone {
+		candidate, epoch = s.runID, m.failoverEpoch
+	}
+	for _, p := range m.sentinels {
+		if p.askPending || now.Sub(p.lastAsk) < askPeriod {
+			continue
+		}
+		p.askPending, p.lastAsk = true, now
+		go s.askPeer(p, p.link, host, port, epoch, candidate)
+	}
+}
+
+func (s *Sentinel) askPeer(p *peer, l *link, host, port string, epoch int64, candidate string) {
+	reply, err := l.call("SENTINEL", "is-master-down-by-addr", host, port, strconv.FormatInt(epoch, 10), candidate)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.askPending = false
+	if err != nil {
+		return
+	}
+	// [down_state, leader_runid, leader_epoch]
+	arr, ok := reply.([]interface{})
+	if !ok || len(arr) != 3 {
+		return
+	}
+	down, _ := arr[0].(int64)
+	leader, _ := arr[1].([]byte)
+	leaderEpoch, _ := arr[2].(int64)
+	p.masterDown, p.masterDownReply = down == 1, time.Now()
+	if string(leader) != "*" {
+		p.leader, p.leaderEpoch = string(leader), leaderEpoch
+	}
+}
+
+// checkODown 包括自己在内，认为 master 主观下线的 sentinel 达到 quorum 时客观下线
+func (s *Sentinel) checkODown(m *master, now time.Time) {
+	votes := 0
+	if m.inst.sdown {
+		votes = 1
+		for _, p := range m.sentinels {
+			// 过时的回复不算
+			if p.masterDown && now.Sub(p.masterDownReply) < 5*askPeriod {
+				votes++
+			}
+		}
+	}
+	odown := votes >= m.Quorum
+	if odown == m.odown {
+		return
+	}
+	m.odown = odown
+	if odown {
+		m.odownSince, m.startDelay = now, randDuration(maxDesync)
+		log.Printf("[sentinel] +odown master %s %s #quorum %d/%d", m.Name, m.inst.addr, votes, m.Quorum)
+	} else {
+		log.Printf("[sentinel] -odown master %s %s", m.Name, m.inst.addr)
+	}
+}
+
+// failoverStep 推进故障转移的状态机
+func (s *Sentinel) failoverStep(m *master, now time.Time) {
+	switch m.failoverState {
+	case failoverNone:
+		// 上次故障转移（包括投票给其他 sentinel）之后 2 倍 FailoverTimeout 内不再发起
+		if m.odown && now.Sub(m.odownSince) >= m.startDelay && now.Sub(m.failoverStart) > 2*m.FailoverTimeout {
+			s.startFailover(m, now, false)
+		}
+
+	case failoverWaitStart:
+		if !m.forceFailover {
+			if leader := s.electLeader(m, now); leader != s.runID {
+				timeout := min(electionTimeout, m.FailoverTimeout)
+				if now.Sub(m.failoverStart) > timeout {
+					s.abortFailover(m, "not-elected")
+				}
+				return
+			}
+			log.Printf("[sentinel] +elected-leader master %s %s epoch %d", m.Name, m.inst.addr, m.failoverEpoch)
+		}
+		s.setFailoverState(m, failoverSelectSlave, now)
+		fallthrough
+
+	case failoverSelectSlave:
+		r := selectReplica(m, now)
+		if r == nil {
+			s.abortFailover(m, "no-good-slave")
+			return
+		}
+		log.Printf("[sentinel] +selected-slave slave %s @ %s", r.addr, m.Name)
+		m.promoted = r
+		s.setFailoverState(m, failoverWaitPromotion, now)
+		go s.replicaOf(r, "")
+
+	case failoverWaitPromotion:
+		// 提升成功由 handleInfo 发现
+		if now.Sub(m.failoverStateChange) > m.FailoverTimeout {
+			s.abortFailover(m, "slave-timeout")
+		}
+	}
+}
+
+// startFailover 进入新的 epoch 并开始选举，force 为 true 时不需要其他 sentinel 同意
+func (s *Sentinel) startFailover(m *master, now time.Time, force bool) {
+	s.currentEpoch++
+	m.failoverEpoch = s.currentEpoch
+	m.failoverStart, m.forceFailover = now, force
+	log.Printf("[sentinel] +new-epoch %d", s.currentEpoch)
+	log.Printf("[sentinel] +try-failover master %s %s", m.Name, m.inst.addr)
+	s.setFailoverState(m, failoverWaitStart, now)
+	// 立即请求其他 sentinel 投票
+	for _, p := range m.sentinels {
+		p.lastAsk = time.Time{}
+	}
+}
+
+func (s *Sentinel) setFailoverState(m *master, state failoverState, now time.Time) {
+	m.failoverState, m.failoverStateChange = state, now
+	log.Printf("[sentinel] +failover-state-%s master %s %s", failoverStateNames[state], m.Name, m.inst.addr)
+}
+
+// abortFailover 放弃本次故障转移，已经提升的 slave 之后由 fixReplicaConfig 重新指向 master
+func (s *Sentinel) abortFailover(m *master, reason string) {
+	log.Printf("[sentinel] -failover-abort-%s master %s %s", reason, m.Name, m.inst.addr)
+	m.failoverState, m.forceFailover, m.promoted = failoverNone, false, nil
+}
+
+// vote 在 epoch 中投票给 candidate，每个 epoch 只投一次，返回本 epoch（或更新的 epoch）中投给的 leader
+func (s *Sentinel) vote(m *master, candidate string, epoch int64, now time.Time) (string, int64) {
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+		log.Printf("[sentinel] +new-epoch %d", epoch)
+	}
+	if m.leaderEpoch < epoch && s.currentEpoch <= epoch {
+		m.leader, m.leaderEpoch = candidate, epoch
+		log.Printf("[sentinel] +vote-for-leader %s %d @ %s", candidate, epoch, m.Name)
+		// 投票给其他 sentinel 后一段时间内自己不再发起故障转移
+		if candidate != s.runID {
+			m.failoverStart = now.Add(randDuration(maxDesync))
+		}
+	}
+	return m.leader, m.leaderEpoch
+}
+
+// electLeader 统计本次故障转移 epoch 中的选票，自己投给得票最多的 sentinel（没有时投给自己）。
+// 得票同时达到多数派和 quorum 的 sentinel 成为 leader，没有时返回空字符串
+func (s *Sentinel) electLeader(m *master, now time.Time) string {
+	epoch := m.failoverEpoch
+	counts := make(map[string]int)
+	for _, p := range m.sentinels {
+		if p.leader != "" && p.leaderEpoch == epoch {
+			counts[p.leader]++
+		}
+	}
+	winner, votes := mostVoted(counts)
+
+	candidate := winner
+	if candidate == "" {
+		candidate = s.runID
+	}
+	if myVote, voteEpoch := s.vote(m, candidate, epoch, now); myVote != "" && voteEpoch == epoch {
+		counts[myVote]++
+		winner, votes = mostVoted(counts)
+	}
+
+	voters := len(m.sentinels) + 1
+	if winner == "" || votes < voters/2+1 || votes < m.Quorum {
+		return ""
+	}
+	return winner
+}
+
+// mostVoted 返回得票最多的 run id，票数相同时取较小的
+func mostVoted(counts map[string]int) (string, int) {
+	winner, max := "", 0
+	for runID, n := range counts {
+		if n > max || (n == max && runID < winner) {
+			winner, max = runID, n
+		}
+	}
+	return winner, max
+}
+
+// selectReplica 选择要提升的 slave：排除下线、不再回复或 INFO 过时的 slave，
+// 优先复制 offset 最大的，相同时取地址较小的
+func selectReplica(m *master, now time.Time) *instance {
+	var candidates []*instance
+	for _, r := range m.replicas {
+		if r.sdown || r.role != "slave" {
+			continue
+		}
+		if now.Sub(r.lastPong) > 5*pingPeriod || now.Sub(r.lastInfo) > 5*pingPeriod {
+			continue
+		}
+		candidates = append(candidates, r)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].offset != candidates[j].offset {
+			return candidates[i].offset > candidates[j].offset
+		}
+		return candidates[i].addr < candidates[j].addr
+	})
+	return candidates[0]
+}
+
+// promotionDone 被选中的 slave 已经成为 master：以本次 epoch 更新配置，
+// 其他 slave 改为复制新的 master，新配置通过 hello 传播给其他 sentinel
+func (s *Sentinel) promotionDone(m *master, now time.Time) {
+	promoted := m.promoted
+	log.Printf("[sentinel] +promoted-slave slave %s @ %s", promoted.addr, m.Name)
+	m.configEpoch = m.failoverEpoch
+	s.switchMaster(m, promoted.addr)
+
+	for _, r := range m.replicas {
+		r.reconfSent = now
+		go s.replicaOf(r, promoted.addr)
+	}
+	log.Printf("[sentinel] +failover-end master %s %s", m.Name, m.inst.addr)
+}
+
+// isMasterDown 回复其他 sentinel 的 is-master-down-by-addr：addr 的 master 是否主观下线，
+// candidate 不为 * 时在 epoch 中投票，返回投给的 leader
+func (s *Sentinel) isMasterDown(addr string, epoch int64, candidate string, now time.Time) (bool, string, int64) {
+	var m *master
+	for _, candidateMaster := range s.masters {
+		if candidateMaster.inst.addr == addr {
+			m = candidateMaster
+			break
+		}
+	}
+	if m == nil {
+		return false, "*", 0
+	}
+	leader, leaderEpoch := "*", int64(0)
+	if candidate != "*" {
+		if voted, votedEpoch := s.vote(m, candidate, epoch, now); voted != "" {
+			leader, leaderEpoch = voted, votedEpoch
+		}
+	}
+	return m.inst.sdown, leader, leaderEpoch
+}
@@ -0,0 +1,170 @@
+package sentinel
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestSentinel(t *testing.T, quorum int) (*Sentinel, *master) {
+	t.Helper()
+	s, err := New(Config{Masters: []MasterConfig{{
+		Name:            "mymaster",
+		Addr:            "127.0.0.1:6379",
+		Quorum:          quorum,
+		DownAfter:       time.Second,
+		FailoverTimeout: 10 * time.Second,
+	}}})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return s, s.masters["mymaster"]
+}
+
+func addPeer(m *master, runID string) *peer {
+	p := &peer{runID: runID, addr: runID + ":26379", link: newLink(runID + ":26379")}
+	m.sentinels[runID] = p
+	return p
+}
+
+func addTestReplica(m *master, addr string, offset int64, now time.Time) *instance {
+	r := newInstance(addr)
+	r.role, r.offset, r.lastPong, r.lastInfo = "slave", offset, now, now
+	m.replicas[addr] = r
+	return r
+}
+
+func TestFailover(t *testing.T) {
+	t.Run("invalid config", func(t *testing.T) {
+		for _, mc := range []MasterConfig{
+			{Name: "", Addr: "127.0.0.1:6379", Quorum: 1, DownAfter: time.Second, FailoverTimeout: time.Second},
+			{Name: "m", Addr: "127.0.0.1", Quorum: 1, DownAfter: time.Second, FailoverTimeout: time.Second},
+			{Name: "m", Addr: "127.0.0.1:6379", Quorum: 0, DownAfter: time.Second, FailoverTimeout: time.Second},
+		} {
+			if _, err := New(Config{Masters: []MasterConfig{mc}}); err == nil {
+				t.Errorf("%+v should be rejected", mc)
+			}
+		}
+	})
+
+	t.Run("odown needs quorum", func(t *testing.T) {
+		s, m := newTestSentinel(t, 2)
+		p := addPeer(m, "b")
+		now := time.Now()
+
+		m.inst.sdown = true
+		s.checkODown(m, now)
+		if m.odown {
+			t.Fatal("one sentinel should not reach a quorum of 2")
+		}
+
+		p.masterDown, p.masterDownReply = true, now
+		s.checkODown(m, now)
+		if !m.odown {
+			t.Fatal("master should be objectively down")
+		}
+
+		// 过时的回复不算
+		s.checkODown(m, now.Add(10*askPeriod))
+		if m.odown {
+			t.Error("stale replies should not count")
+		}
+	})
+
+	t.Run("vote once per epoch", func(t *testing.T) {
+		s, m := newTestSentinel(t, 2)
+		now := time.Now()
+
+		if leader, epoch := s.vote(m, "a", 1, now); leader != "a" || epoch != 1 {
+			t.Errorf("expected vote for a in epoch 1, got %s %d", leader, epoch)
+		}
+		if leader, _ := s.vote(m, "b", 1, now); leader != "a" {
+			t.Errorf("vote should not change within an epoch, got %s", leader)
+		}
+		if s.currentEpoch != 1 {
+			t.Errorf("current epoch should follow the request, got %d", s.currentEpoch)
+		}
+		// 投票给其他 sentinel 后暂时不再自己发起故障转移
+		if !m.failoverStart.After(now.Add(-time.Millisecond)) {
+			t.Error("failover start should be postponed after voting for another sentinel")
+		}
+
+		if leader, epoch := s.vote(m, "b", 2, now); leader != "b" || epoch != 2 {
+			t.Errorf("expected vote for b in epoch 2, got %s %d", leader, epoch)
+		}
+		if leader, epoch := s.vote(m, "a", 1, now); leader != "b" || epoch != 2 {
+			t.Errorf("old epoch should not get a vote, got %s %d", leader, epoch)
+		}
+	})
+
+	t.Run("elect leader", func(t *testing.T) {
+		s, m := newTestSentinel(t, 2)
+		b, c := addPeer(m, "b"), addPeer(m, "c")
+		now := time.Now()
+
+		m.failoverEpoch = 1
+		b.leader, b.leaderEpoch = "b", 1
+		c.leader, c.leaderEpoch = "b", 1
+		// 自己投给得票最多的 b
+		if leader := s.electLeader(m, now); leader != "b" {
+			t.Errorf("expected b to be elected, got %q", leader)
+		}
+		if m.leader != "b" {
+			t.Errorf("should vote for the most voted sentinel, voted %q", m.leader)
+		}
+
+		// 选票分散时没有 leader
+		s, m = newTestSentinel(t, 2)
+		b, c = addPeer(m, "b"), addPeer(m, "c")
+		m.failoverEpoch = 1
+		b.leader, b.leaderEpoch = "b", 1
+		c.leader, c.leaderEpoch = "c", 1
+		s.vote(m, s.runID, 1, now)
+		if leader := s.electLeader(m, now); leader != "" {
+			t.Errorf("split votes should elect nobody, got %q", leader)
+		}
+
+		// 其他 epoch 的选票不算
+		s, m = newTestSentinel(t, 2)
+		b, c = addPeer(m, "b"), addPeer(m, "c")
+		m.failoverEpoch = 2
+		b.leader, b.leaderEpoch = "b", 1
+		c.leader, c.leaderEpoch = s.runID, 2
+		if leader := s.electLeader(m, now); leader != s.runID {
+			t.Errorf("expected self to be elected, got %q", leader)
+		}
+	})
+
+	t.Run("majority is required", func(t *testing.T) {
+		// quorum 为 1 时一个 sentinel 就能判断客观下线，但仍然需要多数派才能故障转移
+		s, m := newTestSentinel(t, 1)
+		addPeer(m, "b")
+		addPeer(m, "c")
+		m.failoverEpoch = 1
+		if leader := s.electLeader(m, time.Now()); leader != "" {
+			t.Errorf("one vote out of three should not win, got %q", leader)
+		}
+	})
+
+	t.Run("select replica", func(t *testing.T) {
+		_, m := newTestSentinel(t, 1)
+		now := time.Now()
+		if selectReplica(m, now) != nil {
+			t.Fatal("no replica to select")
+		}
+
+		addTestReplica(m, "127.0.0.1:6380", 100, now)
+		addTestReplica(m, "127.0.0.1:6382", 200, now)
+		best := addTestReplica(m, "127.0.0.1:6381", 200, now)
+		down := addTestReplica(m, "127.0.0.1:6383", 300, now)
+		down.sdown = true
+		stale := addTestReplica(m, "127.0.0.1:6384", 300, now)
+		stale.lastInfo = now.Add(-time.Minute)
+		promoted := addTestReplica(m, "127.0.0.1:6385", 300, now)
+		promoted.role = "master"
+
+		// offset 最大的里面取地址较小的
+		if r := selectReplica(m, now); r != best {
+			t.Errorf("expected %s, got %v", best.addr, r)
+		}
+	})
+}
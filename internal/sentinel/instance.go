@@ -0,0 +1,259 @@
+package sentinel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goredis/internal/resp"
+	"goredis/pkg/parser"
+)
+
+// callTimeout 单个命令的超时，超时后断开连接，下次调用时重连
+const callTimeout = time.Second
+
+// link 到一个实例（master、slave 或其他 sentinel）的命令连接，调用之间互斥，断开后下次调用时重连
+type link struct {
+	addr string
+
+	mu      sync.Mutex
+	conn    net.Conn
+	p       *parser.Parser
+	localIP string // 连接使用的本地 IP，hello 消息中公布
+}
+
+func newLink(addr string) *link {
+	return &link{addr: addr}
+}
+
+func (l *link) connectLocked() error {
+	if l.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", l.addr, callTimeout)
+	if err != nil {
+		return err
+	}
+	l.conn, l.p = conn, parser.NewParser(conn)
+	if tcpAddr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		l.localIP = tcpAddr.IP.String()
+	}
+	return nil
+}
+
+func (l *link) closeLocked() {
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn, l.p = nil, nil
+	}
+}
+
+func (l *link) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closeLocked()
+}
+
+// call 发送一条命令并读取回复，错误回复以 parser.RespError 返回，连接保持可用
+func (l *link) call(args ...string) (interface{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.connectLocked(); err != nil {
+		return nil, err
+	}
+	cmdLine := make([][]byte, len(args))
+	for i, arg := range args {
+		cmdLine[i] = []byte(arg)
+	}
+	l.conn.SetDeadline(time.Now().Add(callTimeout))
+	if _, err := l.conn.Write(resp.MakeMultiBulkReply(cmdLine).ToBytes()); err != nil {
+		l.closeLocked()
+		return nil, err
+	}
+	reply, err := l.p.Parse()
+	if err != nil {
+		l.closeLocked()
+		return nil, err
+	}
+	if respErr, ok := reply.(parser.RespError); ok {
+		return nil, respErr
+	}
+	return reply, nil
+}
+
+// getLocalIP 返回连接使用的本地 IP，尚未连接时先建立连接
+func (l *link) getLocalIP() (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.connectLocked(); err != nil {
+		return "", err
+	}
+	return l.localIP, nil
+}
+
+// instance 被监控的 master 或 slave。字段由 Sentinel.mu 保护，网络调用在独立的 goroutine 中进行
+type instance struct {
+	addr    string
+	link    *link
+	stopSub chan struct{} // 关闭后停止订阅 hello 频道
+
+	lastPong      time.Time // 最后一次收到 PING 的正常回复，创建时视为可用
+	lastPingSent  time.Time
+	lastInfo      time.Time // 最后一次收到 INFO 的回复
+	lastInfoSent  time.Time
+	lastHelloSent time.Time
+	pingPending   bool
+	infoPending   bool
+	helloPending  bool
+	sdown         bool
+
+	// INFO replication 报告的信息
+	role         string
+	roleReported time.Time // role 最近一次变化的时间
+	masterAddr   string    // slave 复制的 master
+	masterLinkUp bool
+	offset       int64
+	reconfSent   time.Time // 最近一次发送 REPLICAOF 的时间
+}
+
+func newInstance(addr string) *instance {
+	now := time.Now()
+	return &instance{
+		addr:         addr,
+		link:         newLink(addr),
+		stopSub:      make(chan struct{}),
+		lastPong:     now,
+		roleReported: now,
+	}
+}
+
+func (inst *instance) close() {
+	close(inst.stopSub)
+	inst.link.close()
+}
+
+// peer 监控同一个 master 的其他 sentinel，通过 hello 消息发现
+type peer struct {
+	runID string
+	addr  string
+	link  *link
+
+	lastHello  time.Time
+	lastAsk    time.Time
+	askPending bool
+	// 最近一次 is-master-down-by-addr 的回复：是否认为 master 下线，以及它投票的 leader
+	masterDown      bool
+	masterDownReply time.Time
+	leader          string
+	leaderEpoch     int64
+}
+
+// replInfo INFO replication 中 sentinel 关心的字段
+type replInfo struct {
+	role         string
+	masterAddr   string
+	masterLinkUp bool
+	offset       int64
+	slaves       []string // master 报告的 slave 地址
+}
+
+// parseInfo 解析 INFO replication 的输出，忽略不认识的字段
+func parseInfo(info string) replInfo {
+	var r replInfo
+	var masterHost, masterPort string
+	for _, line := range strings.Split(info, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		switch {
+		case key == "role":
+			r.role = value
+		case key == "master_host":
+			masterHost = value
+		case key == "master_port":
+			masterPort = value
+		case key == "master_link_status":
+			r.masterLinkUp = value == "up"
+		case key == "slave_repl_offset":
+			r.offset, _ = strconv.ParseInt(value, 10, 64)
+		case strings.HasPrefix(key, "slave") && isDigits(key[len("slave"):]):
+			// slave0:ip=127.0.0.1,port=6380,state=online,offset=42,lag=0
+			var ip, port string
+			for _, field := range strings.Split(value, ",") {
+				k, v, _ := strings.Cut(field, "=")
+				switch k {
+				case "ip":
+					ip = v
+				case "port":
+					port = v
+				}
+			}
+			if ip != "" && port != "" {
+				r.slaves = append(r.slaves, net.JoinHostPort(ip, port))
+			}
+		}
+	}
+	if masterHost != "" {
+		r.masterAddr = net.JoinHostPort(masterHost, masterPort)
+	}
+	return r
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// helloChannel sentinel 通过被监控实例的这个频道互相发现并传播 master 的地址
+const helloChannel = "__sentinel__:hello"
+
+// hello 消息，格式与 Redis 一致：
+// sentinel_ip,sentinel_port,sentinel_runid,current_epoch,master_name,master_ip,master_port,master_config_epoch
+type hello struct {
+	addr        string
+	runID       string
+	epoch       int64
+	masterName  string
+	masterAddr  string
+	configEpoch int64
+}
+
+func (h *hello) encode() string {
+	ip, port, _ := net.SplitHostPort(h.addr)
+	masterIP, masterPort, _ := net.SplitHostPort(h.masterAddr)
+	return fmt.Sprintf("%s,%s,%s,%d,%s,%s,%s,%d",
+		ip, port, h.runID, h.epoch, h.masterName, masterIP, masterPort, h.configEpoch)
+}
+
+func parseHello(msg string) (*hello, error) {
+	fields := strings.Split(msg, ",")
+	if len(fields) != 8 {
+		return nil, errors.New("invalid hello message")
+	}
+	epoch, err1 := strconv.ParseInt(fields[3], 10, 64)
+	configEpoch, err2 := strconv.ParseInt(fields[7], 10, 64)
+	if err1 != nil || err2 != nil || fields[2] == "" {
+		return nil, errors.New("invalid hello message")
+	}
+	return &hello{
+		addr:        net.JoinHostPort(fields[0], fields[1]),
+		runID:       fields[2],
+		epoch:       epoch,
+		masterName:  fields[4],
+		masterAddr:  net.JoinHostPort(fields[5], fields[6]),
+		configEpoch: configEpoch,
+	}, nil
+}
@@ -0,0 +1,73 @@
+package sentinel
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInstance(t *testing.T) {
+	t.Run("parse master info", func(t *testing.T) {
+		info := "# Replication\r\nrole:master\r\nconnected_slaves:2\r\n" +
+			"slave0:ip=127.0.0.1,port=6380,state=online,offset=42,lag=0\r\n" +
+			"slave1:ip=127.0.0.1,port=6381,state=online,offset=40,lag=1\r\n" +
+			"master_replid:abc\r\nmaster_repl_offset:42\r\n"
+		r := parseInfo(info)
+		if r.role != "master" || r.masterAddr != "" {
+			t.Errorf("unexpected role %q master %q", r.role, r.masterAddr)
+		}
+		expected := []string{"127.0.0.1:6380", "127.0.0.1:6381"}
+		if !reflect.DeepEqual(r.slaves, expected) {
+			t.Errorf("expected slaves %v, got %v", expected, r.slaves)
+		}
+	})
+
+	t.Run("parse slave info", func(t *testing.T) {
+		info := "# Replication\r\nrole:slave\r\nmaster_host:127.0.0.1\r\nmaster_port:6379\r\n" +
+			"master_link_status:up\r\nslave_repl_offset:1234\r\nslave_read_only:1\r\nconnected_slaves:0\r\n"
+		r := parseInfo(info)
+		if r.role != "slave" || r.masterAddr != "127.0.0.1:6379" || !r.masterLinkUp || r.offset != 1234 {
+			t.Errorf("unexpected info %+v", r)
+		}
+		if len(r.slaves) != 0 {
+			t.Errorf("slave_repl_offset should not be taken as a slave: %v", r.slaves)
+		}
+
+		r = parseInfo("role:slave\r\nmaster_host:127.0.0.1\r\nmaster_port:6379\r\nmaster_link_status:down\r\n")
+		if r.masterLinkUp {
+			t.Error("link should be down")
+		}
+	})
+
+	t.Run("hello", func(t *testing.T) {
+		h := &hello{
+			addr:        "127.0.0.1:26379",
+			runID:       "abc",
+			epoch:       3,
+			masterName:  "mymaster",
+			masterAddr:  "127.0.0.1:6380",
+			configEpoch: 2,
+		}
+		msg := h.encode()
+		if msg != "127.0.0.1,26379,abc,3,mymaster,127.0.0.1,6380,2" {
+			t.Errorf("unexpected hello %q", msg)
+		}
+		parsed, err := parseHello(msg)
+		if err != nil {
+			t.Fatalf("parse hello failed: %v", err)
+		}
+		if *parsed != *h {
+			t.Errorf("expected %+v, got %+v", h, parsed)
+		}
+
+		for _, msg := range []string{
+			"",
+			"127.0.0.1,26379,abc,3,mymaster,127.0.0.1,6380",
+			"127.0.0.1,26379,abc,x,mymaster,127.0.0.1,6380,2",
+			"127.0.0.1,26379,,3,mymaster,127.0.0.1,6380,2",
+		} {
+			if _, err := parseHello(msg); err == nil {
+				t.Errorf("%q should be rejected", msg)
+			}
+		}
+	})
+}
@@ -0,0 +1,509 @@
+package sentinel
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"goredis/internal/resp"
+	"goredis/pkg/parser"
+)
+
+// 定时任务的周期，与 Redis 一致
+var (
+	tickPeriod  = 100 * time.Millisecond
+	pingPeriod  = time.Second      // PING master、slave；master 下线或故障转移期间也是 INFO 的周期
+	infoPeriod  = 10 * time.Second // INFO replication
+	helloPeriod = 2 * time.Second  // 在 hello 频道发布自己和 master 的地址
+	askPeriod   = time.Second      // master 主观下线时询问其他 sentinel
+	// 客观下线后随机等待不超过 maxDesync 再发起故障转移，避免多个 sentinel 同时参选瓜分选票
+	maxDesync       = time.Second
+	electionTimeout = 10 * time.Second
+)
+
+// MasterConfig 一个被监控的 master
+type MasterConfig struct {
+	Name   string
+	Addr   string
+	Quorum int // 认为 master 客观下线需要的 sentinel 数量（包括自己）
+	// 超过 DownAfter 没有正常回复 PING 视为主观下线
+	DownAfter time.Duration
+	// 故障转移的超时；失败后等待 2 倍的时间才会再次尝试
+	FailoverTimeout time.Duration
+}
+
+type Config struct {
+	Addr string
+	// hello 消息中公布的 IP，为空时使用与被监控实例连接的本地 IP
+	AnnounceIP string
+	Masters    []MasterConfig
+}
+
+type failoverState int
+
+const (
+	failoverNone          failoverState = iota
+	failoverWaitStart                   // 等待选出 leader
+	failoverSelectSlave                 // 选择要提升的 slave
+	failoverWaitPromotion               // 已发送 REPLICAOF NO ONE，等待它报告 role:master
+)
+
+var failoverStateNames = map[failoverState]string{
+	failoverNone:          "none",
+	failoverWaitStart:     "wait_start",
+	failoverSelectSlave:   "select_slave",
+	failoverWaitPromotion: "wait_promotion",
+}
+
+// master 一个被监控的 master 及其 slave 和其他 sentinel，字段由 Sentinel.mu 保护
+type master struct {
+	MasterConfig
+	inst        *instance
+	replicas    map[string]*instance // 按地址
+	sentinels   map[string]*peer     // 按 run id
+	configEpoch int64                // 当前地址是在哪个 epoch 的故障转移中确定的
+
+	odown      bool
+	odownSince time.Time
+	startDelay time.Duration
+
+	// 本 sentinel 在 leaderEpoch 中投票给的 leader
+	leader      string
+	leaderEpoch int64
+
+	failoverState       failoverState
+	failoverEpoch       int64
+	failoverStart       time.Time
+	failoverStateChange time.Time
+	forceFailover       bool // SENTINEL FAILOVER 发起，不需要选举
+	promoted            *instance
+}
+
+// Sentinel 监控 master 和它的 slave，与其他 sentinel 协商后自动故障转移
+type Sentinel struct {
+	cfg   Config
+	runID string
+
+	mu           sync.Mutex
+	currentEpoch int64
+	masters      map[string]*master
+	port         string // 实际监听的端口，hello 消息中公布
+
+	ln        net.Listener
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func New(cfg Config) (*Sentinel, error) {
+	s := &Sentinel{
+		cfg:     cfg,
+		runID:   genRunID(),
+		masters: make(map[string]*master),
+		done:    make(chan struct{}),
+	}
+	for _, mc := range cfg.Masters {
+		if mc.Name == "" || mc.Quorum <= 0 || mc.DownAfter <= 0 || mc.FailoverTimeout <= 0 {
+			return nil, fmt.Errorf("invalid config for master %q", mc.Name)
+		}
+		if _, _, err := net.SplitHostPort(mc.Addr); err != nil {
+			return nil, fmt.Errorf("invalid address for master %q: %v", mc.Name, err)
+		}
+		if _, ok := s.masters[mc.Name]; ok {
+			return nil, fmt.Errorf("duplicate master name %q", mc.Name)
+		}
+		s.masters[mc.Name] = &master{
+			MasterConfig: mc,
+			inst:         newInstance(mc.Addr),
+			replicas:     make(map[string]*instance),
+			sentinels:    make(map[string]*peer),
+		}
+	}
+	return s, nil
+}
+
+func genRunID() string {
+	buf := make([]byte, 20)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func (s *Sentinel) ListenAndServe() error {
+	ln, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve 在 ln 上接受客户端和其他 sentinel 的连接并开始监控，Close 之后返回 nil
+func (s *Sentinel) Serve(ln net.Listener) error {
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	if err != nil {
+		ln.Close()
+		return err
+	}
+	s.mu.Lock()
+	s.ln, s.port = ln, port
+	for _, m := range s.masters {
+		go s.subscribe(m, m.inst)
+	}
+	s.mu.Unlock()
+	log.Printf("[sentinel] running with id %s", s.runID)
+	go s.timer()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// Close 停止监控并关闭监听和所有连接
+func (s *Sentinel) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.ln != nil {
+			s.ln.Close()
+		}
+		for _, m := range s.masters {
+			m.inst.close()
+			for _, r := range m.replicas {
+				r.close()
+			}
+			for _, p := range m.sentinels {
+				p.link.close()
+			}
+		}
+	})
+}
+
+func (s *Sentinel) timer() {
+	ticker := time.NewTicker(tickPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.tick()
+		}
+	}
+}
+
+// tick 定时任务：发起 PING、INFO 和 hello，更新下线状态并推进故障转移
+func (s *Sentinel) tick() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, m := range s.masters {
+		s.monitor(m, m.inst, now)
+		for _, r := range m.replicas {
+			s.monitor(m, r, now)
+		}
+		s.checkSDown(m, now)
+		s.askPeers(m, now)
+		s.checkODown(m, now)
+		s.failoverStep(m, now)
+	}
+}
+
+// monitor 到期时在后台向实例发送 PING、INFO replication 和 hello，同一种请求同时只有一个
+func (s *Sentinel) monitor(m *master, inst *instance, now time.Time) {
+	if !inst.pingPending && now.Sub(inst.lastPingSent) >= pingPeriod {
+		inst.pingPending, inst.lastPingSent = true, now
+		go s.ping(inst)
+	}
+
+	period := infoPeriod
+	if m.inst.sdown || m.failoverState != failoverNone {
+		period = pingPeriod
+	}
+	if !inst.infoPending && now.Sub(inst.lastInfoSent) >= period {
+		inst.infoPending, inst.lastInfoSent = true, now
+		go s.refreshInfo(m, inst)
+	}
+
+	if !inst.helloPending && now.Sub(inst.lastHelloSent) >= helloPeriod {
+		inst.helloPending, inst.lastHelloSent = true, now
+		go s.sendHello(inst, s.makeHello(m))
+	}
+}
+
+func (s *Sentinel) ping(inst *instance) {
+	_, err := inst.link.call("PING")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst.pingPending = false
+	if err == nil {
+		inst.lastPong = time.Now()
+	}
+}
+
+func (s *Sentinel) refreshInfo(m *master, inst *instance) {
+	reply, err := inst.link.call("INFO", "replication")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inst.infoPending = false
+	if err != nil {
+		return
+	}
+	if b, ok := reply.([]byte); ok {
+		s.handleInfo(m, inst, parseInfo(string(b)), time.Now())
+	}
+}
+
+// handleInfo 记录实例报告的复制状态：从 master 发现 slave，确认 slave 提升成功，纠正角色或 master 不对的 slave
+func (s *Sentinel) handleInfo(m *master, inst *instance, info replInfo, now time.Time) {
+	inst.lastInfo = now
+	if info.role != inst.role {
+		inst.role, inst.roleReported = info.role, now
+	}
+	inst.masterAddr, inst.masterLinkUp, inst.offset = info.masterAddr, info.masterLinkUp, info.offset
+
+	if inst == m.inst {
+		if info.role == "master" {
+			for _, addr := range info.slaves {
+				if _, ok := m.replicas[addr]; !ok && addr != m.inst.addr {
+					s.addReplica(m, addr)
+				}
+			}
+		}
+		return
+	}
+	if _, ok := m.replicas[inst.addr]; !ok {
+		return
+	}
+
+	if m.failoverState == failoverWaitPromotion && inst == m.promoted {
+		if info.role == "master" {
+			s.promotionDone(m, now)
+		}
+		return
+	}
+	if m.failoverState == failoverNone {
+		s.fixReplicaConfig(m, inst, now)
+	}
+}
+
+func (s *Sentinel) addReplica(m *master, addr string) *instance {
+	r := newInstance(addr)
+	m.replicas[addr] = r
+	go s.subscribe(m, r)
+	log.Printf("[sentinel] +slave slave %s @ %s", addr, m.Name)
+	return r
+}
+
+// fixReplicaConfig 把自称 master 或者复制其他 master 的 slave 重新指向当前的 master，
+// 例如故障转移后重新上线的旧 master。等待一段时间再处理，期间可能从 hello 得知更新的配置
+func (s *Sentinel) fixReplicaConfig(m *master, inst *instance, now time.Time) {
+	wait := 4 * helloPeriod
+	if m.inst.sdown || now.Sub(inst.roleReported) < wait || now.Sub(inst.reconfSent) < wait {
+		return
+	}
+	switch {
+	case inst.role == "master":
+		log.Printf("[sentinel] +convert-to-slave slave %s @ %s", inst.addr, m.Name)
+	case inst.role == "slave" && inst.masterAddr != m.inst.addr:
+		log.Printf("[sentinel] +fix-slave-config slave %s @ %s", inst.addr, m.Name)
+	default:
+		return
+	}
+	inst.reconfSent = now
+	go s.replicaOf(inst, m.inst.addr)
+}
+
+// replicaOf 让实例复制 masterAddr，为空时提升为 master
+func (s *Sentinel) replicaOf(inst *instance, masterAddr string) {
+	host, port := "NO", "ONE"
+	if masterAddr != "" {
+		host, port, _ = net.SplitHostPort(masterAddr)
+	}
+	if _, err := inst.link.call("REPLICAOF", host, port); err != nil {
+		log.Printf("[sentinel] REPLICAOF %s %s to %s failed: %v", host, port, inst.addr, err)
+	}
+}
+
+func (s *Sentinel) makeHello(m *master) *hello {
+	return &hello{
+		addr:        net.JoinHostPort(s.cfg.AnnounceIP, s.port),
+		runID:       s.runID,
+		epoch:       s.currentEpoch,
+		masterName:  m.Name,
+		masterAddr:  m.inst.addr,
+		configEpoch: m.configEpoch,
+	}
+}
+
+// sendHello 在实例的 hello 频道上发布 h，没有指定公布的 IP 时使用与实例连接的本地 IP
+func (s *Sentinel) sendHello(inst *instance, h *hello) {
+	defer func() {
+		s.mu.Lock()
+		inst.helloPending = false
+		s.mu.Unlock()
+	}()
+
+	if s.cfg.AnnounceIP == "" {
+		ip, err := inst.link.getLocalIP()
+		if err != nil {
+			return
+		}
+		_, port, _ := net.SplitHostPort(h.addr)
+		h.addr = net.JoinHostPort(ip, port)
+	}
+	inst.link.call("PUBLISH", helloChannel, h.encode())
+}
+
+// subscribe 订阅实例的 hello 频道直到实例被移除或 sentinel 关闭，断开后每秒重连
+func (s *Sentinel) subscribe(m *master, inst *instance) {
+	for {
+		conn, err := net.DialTimeout("tcp", inst.addr, callTimeout)
+		if err == nil {
+			s.readHellos(m, conn, inst.stopSub)
+		}
+		select {
+		case <-inst.stopSub:
+			return
+		case <-s.done:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (s *Sentinel) readHellos(m *master, conn net.Conn, stop <-chan struct{}) {
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		select {
+		case <-stop:
+		case <-s.done:
+		case <-closed:
+		}
+		conn.Close()
+	}()
+
+	sub := resp.MakeMultiBulkReply([][]byte{[]byte("SUBSCRIBE"), []byte(helloChannel)})
+	if _, err := conn.Write(sub.ToBytes()); err != nil {
+		return
+	}
+	p := parser.NewParser(conn)
+	for {
+		payload, err := p.Parse()
+		if err != nil {
+			return
+		}
+		// ["message", channel, hello]
+		msg, ok := payload.([]interface{})
+		if !ok || len(msg) != 3 {
+			continue
+		}
+		if kind, _ := msg[0].([]byte); string(kind) != "message" {
+			continue
+		}
+		body, _ := msg[2].([]byte)
+		h, err := parseHello(string(body))
+		if err != nil {
+			continue
+		}
+		s.mu.Lock()
+		s.handleHello(h, time.Now())
+		s.mu.Unlock()
+	}
+}
+
+// handleHello 记录发现的 sentinel 和它的 epoch；它公布的 master 配置更新时切换到新的 master
+func (s *Sentinel) handleHello(h *hello, now time.Time) {
+	if h.runID == s.runID {
+		return
+	}
+	m, ok := s.masters[h.masterName]
+	if !ok {
+		return
+	}
+
+	p, ok := m.sentinels[h.runID]
+	if !ok {
+		// 同一地址的 sentinel 重启后换了 run id，删除旧的
+		for runID, other := range m.sentinels {
+			if other.addr == h.addr {
+				other.link.close()
+				delete(m.sentinels, runID)
+			}
+		}
+		p = &peer{runID: h.runID, addr: h.addr, link: newLink(h.addr)}
+		m.sentinels[h.runID] = p
+		log.Printf("[sentinel] +sentinel sentinel %s %s @ %s", h.runID, h.addr, m.Name)
+	} else if p.addr != h.addr {
+		p.link.close()
+		p.addr, p.link = h.addr, newLink(h.addr)
+	}
+	p.lastHello = now
+
+	if h.epoch > s.currentEpoch {
+		s.currentEpoch = h.epoch
+		log.Printf("[sentinel] +new-epoch %d", h.epoch)
+	}
+	if h.configEpoch > m.configEpoch {
+		m.configEpoch = h.configEpoch
+		if h.masterAddr != m.inst.addr {
+			log.Printf("[sentinel] +config-update-from sentinel %s %s @ %s", h.runID, h.addr, m.Name)
+			s.switchMaster(m, h.masterAddr)
+		}
+	}
+}
+
+// switchMaster 把 addr 作为新的 master，原来的 master 成为 slave，之后由 fixReplicaConfig 重新指向新的 master
+func (s *Sentinel) switchMaster(m *master, addr string) {
+	old := m.inst
+	log.Printf("[sentinel] +switch-master %s %s %s", m.Name, old.addr, addr)
+
+	inst, ok := m.replicas[addr]
+	if ok {
+		delete(m.replicas, addr)
+	} else {
+		inst = newInstance(addr)
+		go s.subscribe(m, inst)
+	}
+	m.inst = inst
+	m.replicas[old.addr] = old
+
+	m.odown, m.odownSince = false, time.Time{}
+	m.failoverState, m.forceFailover, m.promoted = failoverNone, false, nil
+	for _, p := range m.sentinels {
+		p.masterDown = false
+	}
+	// 尽快公布新的配置
+	m.inst.lastHelloSent = time.Time{}
+	for _, r := range m.replicas {
+		r.lastHelloSent = time.Time{}
+	}
+}
+
+func randDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(mathrand.Int63n(int64(max)))
+}
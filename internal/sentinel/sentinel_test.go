@@ -0,0 +1,382 @@
+package sentinel
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"goredis/internal/common"
+	"goredis/internal/resp"
+	"goredis/pkg/parser"
+)
+
+var fastTimersOnce sync.Once
+
+// useFastTimers 缩短定时任务的周期。只设置一次，之前的测试遗留的 goroutine 可能还在读取
+func useFastTimers() {
+	fastTimersOnce.Do(func() {
+		tickPeriod = 10 * time.Millisecond
+		pingPeriod = 50 * time.Millisecond
+		infoPeriod = 100 * time.Millisecond
+		helloPeriod = 50 * time.Millisecond
+		askPeriod = 50 * time.Millisecond
+		maxDesync = 50 * time.Millisecond
+		electionTimeout = time.Second
+	})
+}
+
+// fakeCluster 一组模拟的 goredis 节点，只实现 sentinel 用到的 PING、INFO、REPLICAOF 和发布订阅
+type fakeCluster struct {
+	mu    sync.Mutex
+	nodes map[string]*fakeNode
+}
+
+type fakeNode struct {
+	cluster *fakeCluster
+	addr    string
+	ln      net.Listener
+	conns   map[net.Conn]bool // 值为 true 表示已订阅 hello 频道
+	alive   bool
+	role    string
+	master  string
+	offset  int64
+}
+
+func newFakeCluster() *fakeCluster {
+	return &fakeCluster{nodes: make(map[string]*fakeNode)}
+}
+
+// start 启动一个节点，addr 为空时使用随机端口，master 为空时作为 master
+func (fc *fakeCluster) start(t *testing.T, addr, master string, offset int64) *fakeNode {
+	t.Helper()
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	n := &fakeNode{
+		cluster: fc,
+		addr:    ln.Addr().String(),
+		ln:      ln,
+		conns:   make(map[net.Conn]bool),
+		alive:   true,
+		role:    "master",
+		master:  master,
+		offset:  offset,
+	}
+	if master != "" {
+		n.role = "slave"
+	}
+	fc.mu.Lock()
+	fc.nodes[n.addr] = n
+	fc.mu.Unlock()
+	t.Cleanup(n.kill)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			fc.mu.Lock()
+			n.conns[conn] = false
+			fc.mu.Unlock()
+			go n.serve(conn)
+		}
+	}()
+	return n
+}
+
+func (n *fakeNode) kill() {
+	n.cluster.mu.Lock()
+	defer n.cluster.mu.Unlock()
+	n.alive = false
+	n.ln.Close()
+	for conn := range n.conns {
+		conn.Close()
+	}
+}
+
+func (n *fakeNode) state() (string, string) {
+	n.cluster.mu.Lock()
+	defer n.cluster.mu.Unlock()
+	return n.role, n.master
+}
+
+func (n *fakeNode) serve(conn net.Conn) {
+	p := parser.NewParser(conn)
+	for {
+		payload, err := p.Parse()
+		if err != nil {
+			return
+		}
+		cmdLine, ok := common.ToCmdLine(payload)
+		if !ok || len(cmdLine) == 0 {
+			return
+		}
+		n.cluster.mu.Lock()
+		reply := n.exec(conn, cmdLine)
+		conn.Write(reply.ToBytes())
+		n.cluster.mu.Unlock()
+	}
+}
+
+// exec 调用方持有 cluster.mu
+func (n *fakeNode) exec(conn net.Conn, cmdLine [][]byte) resp.Reply {
+	switch strings.ToLower(string(cmdLine[0])) {
+	case "ping":
+		return resp.MakeSimpleStringReply("PONG")
+	case "info":
+		return resp.MakeBulkReply([]byte(n.info()))
+	case "replicaof":
+		if strings.EqualFold(string(cmdLine[1]), "no") {
+			n.role, n.master = "master", ""
+		} else {
+			n.role, n.master = "slave", net.JoinHostPort(string(cmdLine[1]), string(cmdLine[2]))
+		}
+		return resp.MakeOkReply()
+	case "subscribe":
+		n.conns[conn] = true
+		return resp.MakeMultiRawReply([]resp.Reply{
+			resp.MakeBulkReply([]byte("subscribe")), resp.MakeBulkReply(cmdLine[1]), resp.MakeIntReply(1),
+		})
+	case "publish":
+		msg := resp.MakeMultiBulkReply([][]byte{[]byte("message"), cmdLine[1], cmdLine[2]}).ToBytes()
+		count := 0
+		for sub, subscribed := range n.conns {
+			if subscribed {
+				sub.Write(msg)
+				count++
+			}
+		}
+		return resp.MakeIntReply(int64(count))
+	default:
+		return resp.MakeErrReply("ERR unknown command")
+	}
+}
+
+func (n *fakeNode) info() string {
+	var b strings.Builder
+	b.WriteString("# Replication\r\nrole:" + n.role + "\r\n")
+	if n.role == "slave" {
+		host, port, _ := net.SplitHostPort(n.master)
+		linkStatus := "down"
+		if m, ok := n.cluster.nodes[n.master]; ok && m.alive {
+			linkStatus = "up"
+		}
+		fmt.Fprintf(&b, "master_host:%s\r\nmaster_port:%s\r\nmaster_link_status:%s\r\nslave_repl_offset:%d\r\n",
+			host, port, linkStatus, n.offset)
+		return b.String()
+	}
+	i := 0
+	for _, other := range n.cluster.nodes {
+		if other.alive && other.role == "slave" && other.master == n.addr {
+			host, port, _ := net.SplitHostPort(other.addr)
+			fmt.Fprintf(&b, "slave%d:ip=%s,port=%s,state=online,offset=%d,lag=0\r\n", i, host, port, other.offset)
+			i++
+		}
+	}
+	return b.String()
+}
+
+func startSentinel(t *testing.T, masterAddr string, quorum int) *Sentinel {
+	t.Helper()
+	s, err := New(Config{
+		AnnounceIP: "127.0.0.1",
+		Masters: []MasterConfig{{
+			Name:            "mymaster",
+			Addr:            masterAddr,
+			Quorum:          quorum,
+			DownAfter:       300 * time.Millisecond,
+			FailoverTimeout: time.Second,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func waitFor(t *testing.T, timeout time.Duration, msg string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", msg)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// masterAddrByName 通过 SENTINEL get-master-addr-by-name 查询 sentinel 认为的 master 地址
+func masterAddrByName(t *testing.T, s *Sentinel) string {
+	t.Helper()
+	s.mu.Lock()
+	addr := net.JoinHostPort("127.0.0.1", s.port)
+	s.mu.Unlock()
+
+	l := newLink(addr)
+	defer l.close()
+	reply, err := l.call("SENTINEL", "get-master-addr-by-name", "mymaster")
+	if err != nil {
+		t.Fatalf("get-master-addr-by-name failed: %v", err)
+	}
+	arr, ok := reply.([]interface{})
+	if !ok || len(arr) != 2 {
+		t.Fatalf("unexpected reply %v", reply)
+	}
+	host, _ := arr[0].([]byte)
+	port, _ := arr[1].([]byte)
+	return net.JoinHostPort(string(host), string(port))
+}
+
+func TestSentinel(t *testing.T) {
+	useFastTimers()
+
+	t.Run("failover to the replica with the highest offset", func(t *testing.T) {
+		fc := newFakeCluster()
+		m := fc.start(t, "", "", 0)
+		r1 := fc.start(t, "", m.addr, 100)
+		r2 := fc.start(t, "", m.addr, 200)
+
+		sentinels := []*Sentinel{startSentinel(t, m.addr, 2), startSentinel(t, m.addr, 2), startSentinel(t, m.addr, 2)}
+		waitFor(t, 5*time.Second, "discovery", func() bool {
+			for _, s := range sentinels {
+				s.mu.Lock()
+				sm := s.masters["mymaster"]
+				ok := len(sm.replicas) == 2 && len(sm.sentinels) == 2
+				s.mu.Unlock()
+				if !ok {
+					return false
+				}
+			}
+			return true
+		})
+		for _, s := range sentinels {
+			if addr := masterAddrByName(t, s); addr != m.addr {
+				t.Fatalf("expected master %s, got %s", m.addr, addr)
+			}
+		}
+
+		m.kill()
+		waitFor(t, 20*time.Second, "failover", func() bool {
+			for _, s := range sentinels {
+				if masterAddrByName(t, s) != r2.addr {
+					return false
+				}
+			}
+			return true
+		})
+		if role, _ := r2.state(); role != "master" {
+			t.Errorf("%s should be promoted, role %s", r2.addr, role)
+		}
+		waitFor(t, 5*time.Second, "replica reconfiguration", func() bool {
+			_, master := r1.state()
+			return master == r2.addr
+		})
+
+		// 旧 master 重新上线后被改为新 master 的 slave
+		old := fc.start(t, m.addr, "", 0)
+		waitFor(t, 10*time.Second, "old master conversion", func() bool {
+			role, master := old.state()
+			return role == "slave" && master == r2.addr
+		})
+
+		// 选票分散时会在新的 epoch 中重试，所有 sentinel 最终采用同一个 epoch 的配置
+		var epochs []int64
+		for _, s := range sentinels {
+			s.mu.Lock()
+			epochs = append(epochs, s.masters["mymaster"].configEpoch)
+			s.mu.Unlock()
+		}
+		if epochs[0] < 1 || epochs[1] != epochs[0] || epochs[2] != epochs[0] {
+			t.Errorf("sentinels should agree on the config epoch, got %v", epochs)
+		}
+	})
+
+	t.Run("no failover without quorum", func(t *testing.T) {
+		fc := newFakeCluster()
+		m := fc.start(t, "", "", 0)
+		r := fc.start(t, "", m.addr, 100)
+
+		s := startSentinel(t, m.addr, 2)
+		waitFor(t, 5*time.Second, "replica discovery", func() bool {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return len(s.masters["mymaster"].replicas) == 1
+		})
+
+		m.kill()
+		waitFor(t, 5*time.Second, "sdown", func() bool {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return s.masters["mymaster"].inst.sdown
+		})
+		time.Sleep(500 * time.Millisecond)
+
+		s.mu.Lock()
+		odown := s.masters["mymaster"].odown
+		s.mu.Unlock()
+		if odown {
+			t.Error("a single sentinel should not reach a quorum of 2")
+		}
+		if role, _ := r.state(); role != "slave" {
+			t.Errorf("replica should not be promoted, role %s", role)
+		}
+		if addr := masterAddrByName(t, s); addr != m.addr {
+			t.Errorf("master address should not change, got %s", addr)
+		}
+	})
+
+	t.Run("commands", func(t *testing.T) {
+		s, _ := newTestSentinel(t, 1)
+		cases := []struct {
+			args     []string
+			expected string
+		}{
+			{[]string{"PING"}, "+PONG\r\n"},
+			{[]string{"SENTINEL", "get-master-addr-by-name", "nope"}, "*-1\r\n"},
+			{[]string{"SENTINEL", "get-master-addr-by-name", "mymaster"}, "*2\r\n$9\r\n127.0.0.1\r\n$4\r\n6379\r\n"},
+			{[]string{"SENTINEL", "master", "nope"}, "-ERR No such master with that name\r\n"},
+			{[]string{"SENTINEL", "bogus"}, "-ERR Unknown sentinel subcommand 'bogus'\r\n"},
+			{[]string{"SENTINEL", "master"}, "-ERR wrong number of arguments for 'sentinel|master' command\r\n"},
+			{[]string{"SENTINEL", "failover", "mymaster"}, "-NOGOODSLAVE No suitable replica to promote\r\n"},
+			{[]string{"SENTINEL", "ckquorum", "mymaster"}, "+OK 1 usable Sentinels. Quorum and failover authorization can be reached\r\n"},
+			{[]string{"SENTINEL", "is-master-down-by-addr", "127.0.0.1", "6379", "0", "*"}, "*3\r\n:0\r\n$1\r\n*\r\n:0\r\n"},
+			{[]string{"SENTINEL", "is-master-down-by-addr", "127.0.0.1", "6379", "1", "abc"}, "*3\r\n:0\r\n$3\r\nabc\r\n:1\r\n"},
+			{[]string{"SENTINEL", "is-master-down-by-addr", "127.0.0.1", "6379", "x", "abc"}, "-ERR value is not an integer or out of range\r\n"},
+			{[]string{"GET", "a"}, "-ERR unknown command 'get'\r\n"},
+		}
+		for _, c := range cases {
+			cmdLine := make([][]byte, len(c.args))
+			for i, arg := range c.args {
+				cmdLine[i] = []byte(arg)
+			}
+			if got := string(s.exec(cmdLine).ToBytes()); got != c.expected {
+				t.Errorf("%v: expected %q, got %q", c.args, c.expected, got)
+			}
+		}
+
+		reply := string(s.exec([][]byte{[]byte("SENTINEL"), []byte("master"), []byte("mymaster")}).ToBytes())
+		if !strings.Contains(reply, "$6\r\nquorum\r\n$1\r\n1\r\n") || !strings.Contains(reply, "$5\r\nflags\r\n$6\r\nmaster\r\n") {
+			t.Errorf("unexpected master fields %q", reply)
+		}
+		if got := s.exec([][]byte{[]byte("INFO")}).ToBytes(); !strings.Contains(string(got), "master0:name=mymaster,status=ok,address=127.0.0.1:6379,slaves=0,sentinels=1") {
+			t.Errorf("unexpected info %q", got)
+		}
+		if s.currentEpoch != 1 || s.masters["mymaster"].leader != "abc" {
+			t.Errorf("vote should be recorded, epoch %d leader %q", s.currentEpoch, s.masters["mymaster"].leader)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"path/filepath"
+	"strings"
+
+	"goredis/internal/cluster"
+	"goredis/internal/database"
+	"goredis/internal/resp"
+	"goredis/pkg/connection"
+)
+
+// initCluster 恢复集群配置并开启按槽的 key 索引，需在数据加载完成之后调用
+func (s *Server) initCluster() error {
+	configFile := s.cfg.ClusterConfigFile
+	if configFile == "" {
+		configFile = "nodes.conf"
+	}
+	if !filepath.IsAbs(configFile) {
+		configFile = filepath.Join(s.cfg.AOFDir, configFile)
+	}
+	c, err := cluster.New(cluster.Config{
+		Addr:        s.cfg.Addr,
+		BusPort:     s.cfg.ClusterPort,
+		ConfigFile:  configFile,
+		NodeTimeout: s.cfg.ClusterNodeTimeout,
+	}, s.db)
+	if err != nil {
+		return err
+	}
+	s.db.EnableSlotIndex()
+	s.cluster = c
+	return nil
+}
+
+func (s *Server) execCluster(cmdLine [][]byte) resp.Reply {
+	if s.cluster == nil {
+		return resp.MakeErrReply("ERR This instance has cluster support disabled")
+	}
+	return s.cluster.Exec(cmdLine)
+}
+
+// ASKING 允许下一条命令访问正在迁入本节点的槽
+func (s *Server) execAsking() resp.Reply {
+	if s.cluster == nil {
+		return resp.MakeErrReply("ERR This instance has cluster support disabled")
+	}
+	return resp.MakeOkReply()
+}
+
+func isAsking(cmdLine [][]byte) bool {
+	return strings.EqualFold(string(cmdLine[0]), "asking")
+}
+
+// routeCmd 集群模式下检查命令的 key 是否由本节点负责，返回 nil 时在本地执行，否则返回 MOVED/ASK 等错误。
+// 事务中被拒绝的命令使 EXEC 失败，与 Redis 一致
+func (s *Server) routeCmd(client connection.Connection, cmdLine [][]byte, asking bool) resp.Reply {
+	errReply := s.checkClusterCmd(client, cmdLine, asking)
+	if errReply != nil && client.InMultiState() {
+		client.MarkTxAborted()
+	}
+	return errReply
+}
+
+func (s *Server) checkClusterCmd(client connection.Connection, cmdLine [][]byte, asking bool) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	// 集群模式只使用 0 号数据库
+	if cmdName == "select" && len(cmdLine) == 2 && string(cmdLine[1]) != "0" {
+		return resp.MakeErrReply("ERR SELECT is not allowed in cluster mode")
+	}
+
+	// MIGRATE 发送的 RESTORE-ASKING 相当于带了 ASKING
+	if cmdName == "restore-asking" {
+		asking = true
+	}
+	// MIGRATE 在正在迁出的槽上执行，不需要检查 key 是否还在本地
+	var exists func(key string) bool
+	if cmdName != "migrate" {
+		dbIndex := client.GetDBIndex()
+		exists = func(key string) bool {
+			return s.db.KeyExists(dbIndex, key)
+		}
+	}
+	return s.cluster.Route(database.CommandKeys(cmdLine), asking, exists)
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"goredis/internal/resp"
+)
+
+// INFO [section ...]，目前有 replication 和 cluster 两节；未知的 section 返回空内容，与 Redis 一致
+func (s *Server) execInfo(cmdLine [][]byte) resp.Reply {
+	sections := []string{"default"}
+	if len(cmdLine) > 1 {
+		sections = sections[:0]
+		for _, arg := range cmdLine[1:] {
+			sections = append(sections, strings.ToLower(string(arg)))
+		}
+	}
+
+	replication, cluster := false, false
+	for _, section := range sections {
+		switch section {
+		case "default", "all", "everything":
+			replication, cluster = true, true
+		case "replication":
+			replication = true
+		case "cluster":
+			cluster = true
+		}
+	}
+	var infos []string
+	if replication {
+		infos = append(infos, s.replicationInfo())
+	}
+	if cluster {
+		infos = append(infos, s.clusterInfo())
+	}
+	// 各节之间以空行分隔
+	return resp.MakeBulkReply([]byte(strings.Join(infos, "\r\n")))
+}
+
+// clusterInfo INFO 的 cluster 一节，详细信息见 CLUSTER INFO
+func (s *Server) clusterInfo() string {
+	if s.cluster == nil {
+		return "# Cluster\r\ncluster_enabled:0\r\n"
+	}
+	return "# Cluster\r\ncluster_enabled:1\r\n"
+}
+
+// replicationInfo INFO 的 replication 一节，字段名与 Redis 一致
+func (s *Server) replicationInfo() string {
+	var b strings.Builder
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format+"\r\n", args...)
+	}
+	now := time.Now()
+
+	line("# Replication")
+	if state := s.slaveState(); state != nil {
+		host, port := splitAddr(state.masterAddr)
+		link, lastIO, downSince := state.linkInfo()
+		line("role:slave")
+		line("master_host:%s", host)
+		line("master_port:%d", port)
+		if link == linkConnected {
+			line("master_link_status:up")
+			line("master_last_io_seconds_ago:%d", int(now.Sub(lastIO).Seconds()))
+		} else {
+			line("master_link_status:down")
+			line("master_last_io_seconds_ago:-1")
+			line("master_link_down_since_seconds:%d", int(now.Sub(downSince).Seconds()))
+		}
+		line("slave_repl_offset:%d", state.GetOffset())
+		line("slave_read_only:1")
+	} else {
+		line("role:master")
+	}
+
+	slaves := s.repl.Slaves()
+	line("connected_slaves:%d", len(slaves))
+	for i, slave := range slaves {
+		ip, port := slave.addr()
+		// lag 是距离 slave 上次 REPLCONF ACK 的秒数
+		line("slave%d:ip=%s,port=%d,state=online,offset=%d,lag=%d",
+			i, ip, port, slave.ackOffset, int(now.Sub(slave.lastAck).Seconds()))
+	}
+
+	if s.cfg.MinReplicasToWrite > 0 {
+		line("min_slaves_good_slaves:%d", s.repl.goodSlaves(s.cfg.MinReplicasMaxLag))
+	}
+
+	replID, replID2, secondOffset := s.replIDs()
+	line("master_replid:%s", replID)
+	line("master_replid2:%s", replID2)
+	line("master_repl_offset:%d", s.aofHandler.CurrentOffset())
+	// 与 Redis 一致，记录的是第一个不属于 replid2 的字节，从 1 开始计数
+	if secondOffset >= 0 {
+		line("second_repl_offset:%d", secondOffset+1)
+	} else {
+		line("second_repl_offset:-1")
+	}
+	if backlog := s.repl.Backlog(); backlog != nil {
+		start, end := backlog.GetStartOffset(), backlog.GetEndOffset()
+		line("repl_backlog_active:1")
+		line("repl_backlog_size:%d", backlog.Size())
+		// 与 Redis 一致，第一个字节的 offset 从 1 开始计数
+		line("repl_backlog_first_byte_offset:%d", start+1)
+		line("repl_backlog_histlen:%d", end-start)
+	} else {
+		line("repl_backlog_active:0")
+	}
+	return b.String()
+}
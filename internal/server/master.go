@@ -3,6 +3,7 @@ package server
 import (
 	"fmt"
 	"goredis/internal/common"
+	"goredis/internal/resp"
 	"goredis/pkg/connection"
 	"log"
 	"strconv"
@@ -21,32 +22,28 @@ func (s *Server) handlePSync(conn connection.Connection, cmdLine [][]byte) {
 	}
 
 	common.LogBytesArr("recived slave", cmdLine)
-	// 尝试 partial resync
-	if slaveReplID == s.repliID &&
-		s.repl.backlog != nil &&
-		s.repl.backlog.CanServe(slaveOffset) {
-
-		conn.Write([]byte("+CONTINUE\r\n"))
-		data := s.repl.backlog.ReadFrom(slaveOffset)
-		conn.Write(data)
-		s.repl.AddSlave(conn)
-		s.aofHandler.AddSlave(conn)
+	// 断开时据此清理 slave
+	conn.SetSlave()
+	replID, replID2, secondOffset := s.replIDs()
+
+	s.repl.AddSlave(conn)
+
+	// 尝试 partial resync：slave 与自己的历史相同，或者是上一个复制 ID 在切换之前的历史 (PSYNC2)，
+	// 回复中带上当前的复制 ID，slave 据此切换
+	sameHistory := slaveReplID == replID || (slaveReplID == replID2 && slaveOffset <= secondOffset)
+	if sameHistory && s.aofHandler.PartialSync(conn, slaveOffset, []byte("+CONTINUE "+replID+"\r\n")) {
 		return
 	}
 
 	// FULLRESYNC
-	data, startOffset, err := s.aofHandler.ReadAll()
+	err := s.aofHandler.FullSync(conn, func(offset int64) []byte {
+		return []byte(fmt.Sprintf("+FULLRESYNC %s %d\r\n", replID, offset))
+	})
 	if err != nil {
 		log.Printf("[psync] fail to exec full resync:%s", err)
-		return
+		s.repl.RemoveSlave(conn)
+		conn.Close()
 	}
-	conn.Write([]byte(fmt.Sprintf(
-		"+FULLRESYNC %s %d\r\n", s.repliID, startOffset,
-	)))
-	conn.Write(data)
-
-	s.repl.AddSlave(conn)
-	s.aofHandler.AddSlave(conn)
 }
 
 func isPSync(cmdLine [][]byte) bool {
@@ -67,6 +64,7 @@ func (s *Server) handleReplConf(
 	cmdLine [][]byte,
 ) {
 	if len(cmdLine) < 3 {
+		conn.Write(resp.MakeArgNumErrReply("replconf").ToBytes())
 		return
 	}
 
@@ -74,11 +72,24 @@ func (s *Server) handleReplConf(
 
 	switch sub {
 	case "ACK":
+		// ACK 不回复
 		offset, err := strconv.ParseInt(string(cmdLine[2]), 10, 64)
 		if err != nil {
 			return
 		}
 		s.repl.HandleAck(conn, offset)
+	case "LISTENING-PORT":
+		port, err := strconv.Atoi(string(cmdLine[2]))
+		if err != nil || port < 0 || port > 65535 {
+			conn.Write(resp.MakeErrReply("ERR value is not an integer or out of range").ToBytes())
+			return
+		}
+		s.repl.SetListeningPort(conn, port)
+		conn.Write(resp.MakeOkReply().ToBytes())
+	case "CAPA":
+		conn.Write(resp.MakeOkReply().ToBytes())
+	default:
+		conn.Write(resp.MakeErrReply("ERR Unrecognized REPLCONF option: " + string(cmdLine[1])).ToBytes())
 	}
 }
 
@@ -96,4 +107,125 @@ func (r *Replication) HandleAck(
 
 	slave.ackOffset = offset
 	slave.lastAck = time.Now()
+
+	// 唤醒等待 ACK 的 WAIT
+	close(r.ackNotify)
+	r.ackNotify = make(chan struct{})
+}
+
+// acked 返回已确认到 offset 的 slave 数量，以及下一次收到 ACK 时关闭的 channel
+func (r *Replication) acked(offset int64) (int, <-chan struct{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	for _, slave := range r.slaves {
+		if slave.ackOffset >= offset {
+			n++
+		}
+	}
+	return n, r.ackNotify
+}
+
+// goodSlaves 返回距离上次 ACK 不超过 maxLag 的 slave 数量
+func (r *Replication) goodSlaves(maxLag time.Duration) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := 0
+	now := time.Now()
+	for _, slave := range r.slaves {
+		if now.Sub(slave.lastAck) <= maxLag {
+			n++
+		}
+	}
+	return n
+}
+
+// getAckCmd 让 slave 立即回复 REPLCONF ACK
+var getAckCmd = resp.MakeMultiBulkReply([][]byte{[]byte("REPLCONF"), []byte("GETACK"), []byte("*")}).ToBytes()
+
+// WAIT numreplicas timeout 阻塞到至少 numreplicas 个 slave 确认了调用时的 offset，或者超时（毫秒，0 表示一直等待）。
+// 返回已确认的 slave 数量
+func (s *Server) execWait(c connection.Connection, cmdLine [][]byte) resp.Reply {
+	if s.slaveState() != nil {
+		return resp.MakeErrReply("ERR WAIT cannot be used with replica instances. " +
+			"Please also note that since Redis 4.0 if a replica is configured to be writable " +
+			"(which is not the default) writes to replicas are just local and are not propagated.")
+	}
+	numReplicas, err := strconv.Atoi(string(cmdLine[1]))
+	if err != nil {
+		return resp.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	timeoutMs, err := strconv.ParseInt(string(cmdLine[2]), 10, 64)
+	if err != nil {
+		return resp.MakeErrReply("ERR timeout is not an integer or out of range")
+	}
+	if timeoutMs < 0 {
+		return resp.MakeErrReply("ERR timeout is negative")
+	}
+
+	// 等到之前的写命令都进入复制流，以此时的 offset 为目标
+	target := s.aofHandler.ReplOffset()
+	n, notify := s.repl.acked(target)
+	if n >= numReplicas {
+		return resp.MakeIntReply(int64(n))
+	}
+
+	// slave 每秒上报一次 ACK，GETACK 让它们立即上报
+	s.aofHandler.SendToSlaves(getAckCmd)
+
+	var timeout <-chan time.Time
+	if timeoutMs > 0 {
+		timer := time.NewTimer(time.Duration(timeoutMs) * time.Millisecond)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		select {
+		case <-notify:
+		case <-timeout:
+			n, _ = s.repl.acked(target)
+			return resp.MakeIntReply(int64(n))
+		case <-c.Done():
+			return resp.MakeIntReply(int64(n))
+		}
+		if n, notify = s.repl.acked(target); n >= numReplicas {
+			return resp.MakeIntReply(int64(n))
+		}
+	}
+}
+
+// checkMinReplicas 开启 min-replicas-to-write 时，健康的 slave 不足则拒绝写命令。
+// 事务中被拒绝的写命令使 EXEC 失败；EXEC 时检查事务中是否有写命令
+func (s *Server) checkMinReplicas(c connection.Connection, cmdLine [][]byte) resp.Reply {
+	if s.cfg.MinReplicasToWrite <= 0 || !s.isWriteRequest(c, cmdLine) {
+		return nil
+	}
+	if s.repl.goodSlaves(s.cfg.MinReplicasMaxLag) >= s.cfg.MinReplicasToWrite {
+		return nil
+	}
+
+	if c.InMultiState() {
+		if strings.EqualFold(string(cmdLine[0]), "exec") {
+			c.SetMultiState(false)
+			c.Unwatch()
+		} else {
+			c.MarkTxAborted()
+		}
+	}
+	return resp.MakeErrReply("NOREPLICAS Not enough good replicas to write.")
+}
+
+// isWriteRequest 是否是写命令，EXEC 看事务中是否有写命令
+func (s *Server) isWriteRequest(c connection.Connection, cmdLine [][]byte) bool {
+	if c.InMultiState() && strings.EqualFold(string(cmdLine[0]), "exec") {
+		for _, queued := range c.GetQueuedCmdLine() {
+			if s.db.IsWriteCmd(queued) {
+				return true
+			}
+		}
+		return false
+	}
+	return s.db.IsWriteCmd(cmdLine)
 }
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWait(t *testing.T) {
+	_, masterAddr := startTestServer(t, nil)
+	master := dial(t, masterAddr)
+
+	t.Run("no replicas", func(t *testing.T) {
+		assertReply(t, master.do("wait", "0", "0"), "0")
+		start := time.Now()
+		assertReply(t, master.do("wait", "1", "100"), "0")
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Errorf("WAIT returned before the timeout: %v", elapsed)
+		}
+	})
+
+	_, replica := startReplica(t, masterAddr)
+
+	t.Run("acked replicas", func(t *testing.T) {
+		assertReply(t, master.do("set", "k", "v"), "OK")
+		// GETACK 让 slave 立即确认，不用等每秒一次的 ACK
+		start := time.Now()
+		assertReply(t, master.do("wait", "1", "0"), "1")
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("WAIT should return once the replica acked, took %v", elapsed)
+		}
+		assertReply(t, replica.do("get", "k"), "v")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		assertReply(t, master.do("set", "k", "v2"), "OK")
+		start := time.Now()
+		assertReply(t, master.do("wait", "2", "200"), "1")
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+			t.Errorf("WAIT returned before the timeout: %v", elapsed)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		assertReply(t, master.do("wait", "1", "-1"), "(error) ERR timeout is negative")
+		if reply := replica.do("wait", "1", "0"); !strings.HasPrefix(reply, "(error) ERR WAIT cannot be used with replica instances.") {
+			t.Errorf("unexpected reply %q", reply)
+		}
+	})
+}
+
+func TestMinReplicas(t *testing.T) {
+	_, masterAddr := startTestServer(t, func(cfg *Config) {
+		cfg.MinReplicasToWrite = 2
+		cfg.MinReplicasMaxLag = 10 * time.Second
+	})
+	master := dial(t, masterAddr)
+
+	t.Run("no replicas", func(t *testing.T) {
+		assertReply(t, master.do("set", "k", "v"), "(error) NOREPLICAS Not enough good replicas to write.")
+		assertReply(t, master.do("get", "k"), "(nil)")
+		assertReply(t, master.infoField("replication", "min_slaves_good_slaves"), "0")
+	})
+
+	_, replica1 := startReplica(t, masterAddr)
+
+	t.Run("too few replicas", func(t *testing.T) {
+		waitFor(t, "replica ack", func() bool {
+			return master.infoField("replication", "min_slaves_good_slaves") == "1"
+		})
+		assertReply(t, master.do("set", "k", "v"), "(error) NOREPLICAS Not enough good replicas to write.")
+
+		// 事务中被拒绝的写命令使 EXEC 失败
+		assertReply(t, master.do("multi"), "OK")
+		assertReply(t, master.do("get", "k"), "QUEUED")
+		assertReply(t, master.do("set", "k", "v"), "(error) NOREPLICAS Not enough good replicas to write.")
+		assertReply(t, master.do("exec"), "(error) EXECABORT Transaction discarded because of previous errors.")
+
+		// 只读的事务不受影响
+		assertReply(t, master.do("multi"), "OK")
+		assertReply(t, master.do("get", "k"), "QUEUED")
+		assertReply(t, master.do("exec"), "[(nil)]")
+	})
+
+	startReplica(t, masterAddr)
+
+	t.Run("enough replicas", func(t *testing.T) {
+		waitFor(t, "replica ack", func() bool {
+			return master.infoField("replication", "min_slaves_good_slaves") == "2"
+		})
+		assertReply(t, master.do("set", "k", "v"), "OK")
+		waitFor(t, "write replicated", func() bool { return replica1.do("get", "k") == "v" })
+	})
+}
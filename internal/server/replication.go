@@ -5,6 +5,10 @@ import (
 	"encoding/hex"
 	"goredis/internal/persistant"
 	"goredis/pkg/connection"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -12,44 +16,114 @@ import (
 const DefaultBacklogSize = 1 << 20 // 1MB，和 Redis 一致
 
 type SlaveInfo struct {
-	conn      connection.Connection
-	ackOffset int64
-	lastAck   time.Time
+	conn          connection.Connection
+	listeningPort int // REPLCONF listening-port 上报的端口，0 表示未知
+	ackOffset     int64
+	lastAck       time.Time
+}
+
+// addr 返回 slave 的 IP 和监听端口，未上报端口时使用连接的端口
+func (info *SlaveInfo) addr() (string, int) {
+	host, port, err := net.SplitHostPort(info.conn.RemoteAddr())
+	if err != nil {
+		return info.conn.RemoteAddr(), info.listeningPort
+	}
+	if info.listeningPort != 0 {
+		return host, info.listeningPort
+	}
+	p, _ := strconv.Atoi(port)
+	return host, p
 }
 
 type Replication struct {
 	slaves  map[connection.Connection]*SlaveInfo
 	backlog *persistant.ReplBacklog
-	mu      sync.Mutex
+	// PSYNC 之前通过 REPLCONF listening-port 上报的端口
+	ports map[connection.Connection]int
+	// 收到 ACK 时关闭并换成新的 channel，WAIT 借此等待
+	ackNotify chan struct{}
+	mu        sync.Mutex
 }
 
 func NewReplication() *Replication {
 	return &Replication{
-		slaves: make(map[connection.Connection]*SlaveInfo),
+		slaves:    make(map[connection.Connection]*SlaveInfo),
+		ports:     make(map[connection.Connection]int),
+		ackNotify: make(chan struct{}),
 	}
 }
 
 func (r *Replication) InitBacklog(startOffset int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.backlog = persistant.NewReplBacklog(DefaultBacklogSize, startOffset)
 }
 
+func (r *Replication) Backlog() *persistant.ReplBacklog {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.backlog
+}
+
+// SetListeningPort 记录连接上报的监听端口，PSYNC 成功后成为 slave 的地址
+func (r *Replication) SetListeningPort(conn connection.Connection, port int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ports[conn] = port
+}
+
 func (r *Replication) AddSlave(conn connection.Connection) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	r.slaves[conn] = &SlaveInfo{
-		conn:      conn,
-		ackOffset: 0,
-		lastAck:   time.Now(),
+		conn:          conn,
+		listeningPort: r.ports[conn],
+		ackOffset:     0,
+		lastAck:       time.Now(),
 	}
+	delete(r.ports, conn)
 }
 
 func (r *Replication) RemoveSlave(c connection.Connection) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	delete(r.slaves, c)
+	delete(r.ports, c)
+}
+
+// DisconnectSlaves 断开所有 slave，它们重连后重新同步
+func (r *Replication) DisconnectSlaves() {
+	r.mu.Lock()
+	conns := make([]connection.Connection, 0, len(r.slaves))
+	for conn := range r.slaves {
+		conns = append(conns, conn)
+	}
+	r.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
 }
 
+// Slaves 返回当前 slave 的快照，按地址排序
+func (r *Replication) Slaves() []SlaveInfo {
+	r.mu.Lock()
+	infos := make([]SlaveInfo, 0, len(r.slaves))
+	for _, info := range r.slaves {
+		infos = append(infos, *info)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].conn.RemoteAddr() < infos[j].conn.RemoteAddr()
+	})
+	return infos
+}
+
+// noReplID 没有上一个复制 ID 时的占位，与 Redis 一致
+var noReplID = strings.Repeat("0", 40)
+
 func GenReplID() string {
 	buf := make([]byte, 20) // 20 bytes = 40 hex chars
 	_, _ = rand.Read(buf)
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	"goredis/internal/resp"
+	"goredis/pkg/connection"
+)
+
+// 由 Server 处理的命令：涉及复制状态，不经过 MultiDB
+var serverCmdArity = map[string]int{
+	"replicaof": 3,  // replicaof host port | replicaof no one
+	"slaveof":   3,  // replicaof 的旧名字
+	"role":      1,  // role
+	"info":      -1, // info [section ...]
+	"wait":      3,  // wait numreplicas timeout
+	"cluster":   -2, // cluster subcommand [arg ...]
+	"asking":    1,  // asking
+}
+
+// execServerCmd 执行 Server 处理的命令，不是这类命令时返回 false
+func (s *Server) execServerCmd(c connection.Connection, cmdLine [][]byte) (resp.Reply, bool) {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	arity, ok := serverCmdArity[cmdName]
+	if !ok {
+		return nil, false
+	}
+	if c.InMultiState() {
+		c.MarkTxAborted()
+		return resp.MakeErrReply("ERR Command not allowed inside a transaction"), true
+	}
+	if (arity > 0 && len(cmdLine) != arity) || (arity < 0 && len(cmdLine) < -arity) {
+		return resp.MakeArgNumErrReply(cmdName), true
+	}
+
+	switch cmdName {
+	case "replicaof", "slaveof":
+		return s.execReplicaOf(cmdLine), true
+	case "role":
+		return s.execRole(), true
+	case "wait":
+		return s.execWait(c, cmdLine), true
+	case "cluster":
+		return s.execCluster(cmdLine), true
+	case "asking":
+		return s.execAsking(), true
+	default:
+		return s.execInfo(cmdLine), true
+	}
+}
+
+// REPLICAOF host port 开始（或切换到）从指定的 master 复制；REPLICAOF NO ONE 停止复制，提升为 master
+func (s *Server) execReplicaOf(cmdLine [][]byte) resp.Reply {
+	host, port := string(cmdLine[1]), string(cmdLine[2])
+	if strings.EqualFold(host, "no") && strings.EqualFold(port, "one") {
+		s.promote()
+		return resp.MakeOkReply()
+	}
+
+	if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+		return resp.MakeErrReply("ERR Invalid master port")
+	}
+	addr := net.JoinHostPort(host, port)
+
+	s.roleMu.Lock()
+	defer s.roleMu.Unlock()
+
+	s.mu.Lock()
+	old := s.slave
+	if old != nil && old.masterAddr == addr {
+		s.mu.Unlock()
+		return resp.MakeSimpleStringReply("OK Already connected to specified master")
+	}
+	// 先切换角色拒绝新的写命令
+	state := newSlaveState(addr)
+	s.slave = state
+	s.mu.Unlock()
+
+	if old != nil {
+		old.stop()
+	}
+	// 之前的写命令和复制流都写入之后，从当前的复制 ID 和 offset 发起 PSYNC，
+	// 新 master 与自己的历史相同时（例如它是刚提升的同级 slave）可以部分同步
+	offset := s.aofHandler.ReplOffset()
+	s.aofHandler.SetReplicaMode(true)
+	state.setReplPosition(s.replID(), offset, s.aofHandler.StreamDB())
+
+	// slave 不主动淘汰，以 master 同步过来的 DEL 为准
+	s.db.SetIgnoreMaxMemory(true)
+	log.Printf("[replication] replicating from %s", addr)
+	go s.startReplicationAsSlave(state)
+	return resp.MakeOkReply()
+}
+
+// promote 停止复制并换一个新的复制 ID。原来的复制 ID 成为 replid2，
+// 原 master 的其他 slave 切换过来时可以从 backlog 部分同步
+func (s *Server) promote() {
+	s.roleMu.Lock()
+	defer s.roleMu.Unlock()
+
+	state := s.slaveState()
+	if state == nil {
+		return
+	}
+
+	// stop 返回后复制循环不会再修改数据，等收到的复制流都写入后以此为界切换复制 ID
+	state.stop()
+	offset := s.aofHandler.ReplOffset()
+	s.aofHandler.SetReplicaMode(false)
+	s.shiftReplID(offset)
+
+	s.mu.Lock()
+	s.slave = nil
+	s.mu.Unlock()
+
+	// 下游的 slave 重连后得知新的复制 ID
+	s.repl.DisconnectSlaves()
+	s.db.SetIgnoreMaxMemory(false)
+	log.Printf("[replication] promoted to master, stopped replicating from %s", state.masterAddr)
+}
+
+// ROLE 返回当前角色：
+// master 返回 offset 和每个 slave 的地址与确认的 offset；slave 返回 master 地址、连接状态和 offset
+func (s *Server) execRole() resp.Reply {
+	if state := s.slaveState(); state != nil {
+		host, port := splitAddr(state.masterAddr)
+		link, _, _ := state.linkInfo()
+		offset := int64(-1)
+		if link == linkConnected {
+			offset = state.GetOffset()
+		}
+		return resp.MakeMultiRawReply([]resp.Reply{
+			resp.MakeBulkReply([]byte("slave")),
+			resp.MakeBulkReply([]byte(host)),
+			resp.MakeIntReply(int64(port)),
+			resp.MakeBulkReply([]byte(link)),
+			resp.MakeIntReply(offset),
+		})
+	}
+
+	slaves := s.repl.Slaves()
+	replies := make([]resp.Reply, 0, len(slaves))
+	for _, slave := range slaves {
+		ip, port := slave.addr()
+		replies = append(replies, resp.MakeMultiBulkReply([][]byte{
+			[]byte(ip),
+			[]byte(strconv.Itoa(port)),
+			[]byte(strconv.FormatInt(slave.ackOffset, 10)),
+		}))
+	}
+	return resp.MakeMultiRawReply([]resp.Reply{
+		resp.MakeBulkReply([]byte("master")),
+		resp.MakeIntReply(s.aofHandler.CurrentOffset()),
+		resp.MakeMultiRawReply(replies),
+	})
+}
+
+// splitAddr 拆出 host 和端口，格式不对时端口为 0
+func splitAddr(addr string) (string, int) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, 0
+	}
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReplication(t *testing.T) {
+	_, masterAddr := startTestServer(t, nil)
+	master := dial(t, masterAddr)
+	masterHost, masterPort, _ := net.SplitHostPort(masterAddr)
+
+	// 连接之前写入的数据通过全量同步到达 slave
+	assertReply(t, master.do("set", "before", "1"), "OK")
+	assertReply(t, master.do("select", "2"), "OK")
+	assertReply(t, master.do("rpush", "list", "a", "b"), "2")
+	assertReply(t, master.do("select", "0"), "OK")
+
+	assertReply(t, master.do("role"), "[master "+master.infoField("replication", "master_repl_offset")+" []]")
+	assertReply(t, master.infoField("replication", "role"), "master")
+
+	_, replicaAddr := startTestServer(t, nil)
+	replica := dial(t, replicaAddr)
+	_, replicaPort, _ := net.SplitHostPort(replicaAddr)
+
+	t.Run("REPLICAOF", func(t *testing.T) {
+		assertReply(t, replica.do("replicaof", masterHost, "abc"), "(error) ERR Invalid master port")
+		assertReply(t, replica.do("role"), "[master 0 []]")
+
+		assertReply(t, replica.do("replicaof", masterHost, masterPort), "OK")
+		waitFor(t, "replica link up", func() bool {
+			return replica.infoField("replication", "master_link_status") == "up"
+		})
+		assertReply(t, replica.do("slaveof", masterHost, masterPort), "OK Already connected to specified master")
+	})
+
+	t.Run("full sync", func(t *testing.T) {
+		assertReply(t, replica.do("get", "before"), "1")
+		assertReply(t, replica.do("select", "2"), "OK")
+		assertReply(t, replica.do("lrange", "list", "0", "-1"), "[a b]")
+		assertReply(t, replica.do("select", "0"), "OK")
+	})
+
+	t.Run("replicated writes", func(t *testing.T) {
+		assertReply(t, master.do("set", "after", "2"), "OK")
+		assertReply(t, master.do("del", "before"), "1")
+		waitFor(t, "writes replicated", func() bool {
+			return replica.do("get", "after") == "2" && replica.do("exists", "before") == "0"
+		})
+	})
+
+	t.Run("ROLE", func(t *testing.T) {
+		offset := master.infoField("replication", "master_repl_offset")
+		waitFor(t, "replica offset", func() bool {
+			return replica.infoField("replication", "slave_repl_offset") == offset
+		})
+		assertReply(t, replica.do("role"), "[slave "+masterHost+" "+masterPort+" connected "+offset+"]")
+
+		// slave 每秒 ACK 一次
+		waitFor(t, "replica ack", func() bool {
+			return master.do("role") == "[master "+offset+" [[127.0.0.1 "+replicaPort+" "+offset+"]]]"
+		})
+	})
+
+	t.Run("INFO replication", func(t *testing.T) {
+		assertReply(t, replica.infoField("replication", "role"), "slave")
+		assertReply(t, replica.infoField("replication", "master_host"), masterHost)
+		assertReply(t, replica.infoField("replication", "master_port"), masterPort)
+		assertReply(t, replica.infoField("replication", "slave_read_only"), "1")
+		// slave 与 master 使用同一个复制 ID
+		assertReply(t, replica.infoField("replication", "master_replid"), master.infoField("replication", "master_replid"))
+
+		assertReply(t, master.infoField("replication", "connected_slaves"), "1")
+		if slave := master.infoField("replication", "slave0"); !strings.HasPrefix(slave, "ip=127.0.0.1,port="+replicaPort+",state=online,") {
+			t.Errorf("unexpected slave0 %q", slave)
+		}
+	})
+
+	t.Run("REPLICAOF NO ONE", func(t *testing.T) {
+		oldReplID := master.infoField("replication", "master_replid")
+		offset := replica.infoField("replication", "master_repl_offset")
+
+		assertReply(t, replica.do("replicaof", "no", "one"), "OK")
+		assertReply(t, replica.do("role"), "[master "+offset+" []]")
+		assertReply(t, replica.infoField("replication", "role"), "master")
+		// 原来的复制 ID 成为 replid2，原 master 的其他 slave 切换过来时可以部分同步
+		assertReply(t, replica.infoField("replication", "master_replid2"), oldReplID)
+		if replID := replica.infoField("replication", "master_replid"); replID == oldReplID {
+			t.Error("promoted replica should use a new replication id")
+		}
+
+		// 提升后可以写入，不再接收原 master 的写入
+		assertReply(t, replica.do("set", "promoted", "1"), "OK")
+		waitFor(t, "old master drops the slave", func() bool {
+			return master.infoField("replication", "connected_slaves") == "0"
+		})
+		assertReply(t, master.do("set", "after", "3"), "OK")
+		assertReply(t, replica.do("get", "after"), "2")
+	})
+}
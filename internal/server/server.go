@@ -1,33 +1,70 @@
 package server
 
 import (
+	"errors"
+	"fmt"
+	"goredis/internal/cluster"
 	"goredis/internal/common"
 	"goredis/internal/database"
 	"goredis/internal/persistant"
+	"goredis/internal/pubsub"
 	"goredis/internal/resp"
-	"goredis/internal/types"
 	"goredis/pkg/connection"
 	"goredis/pkg/parser"
 	"log"
 	"net"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Config struct {
-	Addr       string
-	AOFDir     string
-	DBNum      int    // 当前只支持使用0号数据库
-	MasterAddr string // 非空表示 slave
+	Addr        string
+	AOFDir      string // AOF 和 RDB 文件所在目录
+	DBNum       int    // 逻辑数据库数量
+	MasterAddr  string // 非空表示启动时作为该 master 的 slave，运行时可用 REPLICAOF 修改
+	RDBFilename string // 快照文件名，默认 dump.rdb
+	SavePoints  []persistant.SavePoint
+	// 重写 AOF 时以 RDB 快照开头，加载更快
+	AOFUseRDBPreamble bool
+	AppendFsync       string // always、everysec(默认) 或 no
+	// AOF 结尾不完整时截断到最后一条完整命令后继续启动，否则拒绝启动；其他损坏总是拒绝启动
+	AOFLoadTruncated bool
+	// 内存上限（字节），0 表示不限制；超过时按 MaxMemoryPolicy 淘汰，默认 noeviction
+	MaxMemory       int64
+	MaxMemoryPolicy string
+	// 脚本执行时间上限，超时的脚本会被中止，0 表示不限制
+	LuaTimeLimit time.Duration
+	// 脚本按执行的写命令复制 (默认)，为 false 时按 EVAL 原样复制
+	LuaReplicateCommands bool
+	// 距离上次 ACK 不超过 MinReplicasMaxLag 的 slave 少于 MinReplicasToWrite 个时拒绝写命令，0 表示不检查
+	MinReplicasToWrite int
+	MinReplicasMaxLag  time.Duration
+	// 集群模式：按槽把 key 分布到多个节点，节点之间通过集群总线交换配置，见 internal/cluster
+	ClusterEnabled     bool
+	ClusterConfigFile  string        // 集群配置文件，相对路径时位于 AOFDir 下，默认 nodes.conf
+	ClusterNodeTimeout time.Duration // 超过这个时间没有回复的节点被认为下线
+	ClusterPort        int           // 集群总线端口，0 表示客户端端口 + 10000
 }
 
 type Server struct {
 	cfg  Config
 	repl *Replication
-	db   *database.DB
+	db   *database.MultiDB
+	hub  *pubsub.Hub
+	// 未开启集群模式时为 nil
+	cluster *cluster.Cluster
 
-	repliID    string
 	aofHandler *persistant.AOFHandler
 
-	slave *SlaveState
+	// REPLICAOF 在运行时切换角色，roleMu 保证同一时刻只有一个切换在进行；
+	// 复制 ID 和 slave 由 mu 保护，复制 ID 的含义见 persistant.ReplMeta
+	roleMu           sync.Mutex
+	mu               sync.Mutex
+	repliID          string
+	replID2          string
+	secondReplOffset int64
+	slave            *SlaveState // 非 nil 表示当前是 slave
 }
 
 func NewServer(cfg Config) (*Server, error) {
@@ -35,46 +72,135 @@ func NewServer(cfg Config) (*Server, error) {
 	if err != nil {
 		panic(err)
 	}
+	if err := checkAOF(aofHandler, cfg.AOFLoadTruncated); err != nil {
+		return nil, err
+	}
+	// AOF 中的数据与上次的复制状态一致时沿用复制 ID 和 offset，重启后可以部分同步；
+	// AOF 为空时可能从快照恢复，数据与 offset 不再对应，总是换新的复制 ID
+	replID, replID2, secondOffset := GenReplID(), noReplID, int64(-1)
+	if aofHandler.HasData() {
+		if meta, ok := aofHandler.RestoreReplMeta(); ok {
+			replID, replID2, secondOffset = meta.ReplID, meta.ReplID2, meta.SecondOffset
+			log.Printf("[replication] restored replication id %s offset %d", replID, meta.Offset)
+		}
+	}
+	aofHandler.SetReplIDs(replID, replID2, secondOffset)
+
 	// redis的主从架构是多层的，每个节点都可能是主节点，因此都需要构造Replication
 	repl := NewReplication()
 	repl.InitBacklog(aofHandler.CurrentOffset())
 
 	aofHandler.SetBacklog(repl.backlog)
+	aofHandler.SetUseRDBPreamble(cfg.AOFUseRDBPreamble)
+	if cfg.AppendFsync == "" {
+		cfg.AppendFsync = persistant.FsyncEverySec
+	}
+	if err := aofHandler.SetFsyncPolicy(cfg.AppendFsync); err != nil {
+		return nil, err
+	}
+
+	if cfg.RDBFilename == "" {
+		cfg.RDBFilename = "dump.rdb"
+	}
+	rdbHandler, err := persistant.NewRDBHandler(cfg.AOFDir, cfg.RDBFilename, cfg.SavePoints)
+	if err != nil {
+		return nil, err
+	}
+	db := database.MakeMultiDB(cfg.DBNum, aofHandler)
+	db.SetRDBHandler(rdbHandler)
+	if err := db.SetMaxMemory(cfg.MaxMemory, cfg.MaxMemoryPolicy); err != nil {
+		return nil, err
+	}
+	db.SetLuaTimeLimit(cfg.LuaTimeLimit)
+	db.SetLuaReplicateCommands(cfg.LuaReplicateCommands)
+	// slave 不主动淘汰，以 master 同步过来的 DEL 为准
+	db.SetIgnoreMaxMemory(cfg.MasterAddr != "")
+	// AOF 中有数据时以 AOF 为准，否则从快照恢复，并重写 AOF 使其包含快照中的数据
+	if !aofHandler.HasData() && rdbHandler.HasData() {
+		if err := db.LoadRDB(); err != nil {
+			return nil, fmt.Errorf("load rdb: %w", err)
+		}
+		if err := db.RewriteAOF(); err != nil {
+			return nil, fmt.Errorf("rewrite aof after loading rdb: %w", err)
+		}
+	}
+
 	s := &Server{
-		cfg:        cfg,
-		db:         database.MakeDB(0, aofHandler),
-		repl:       NewReplication(),
-		repliID:    GenReplID(),
-		aofHandler: aofHandler,
+		cfg:              cfg,
+		db:               db,
+		repl:             repl,
+		hub:              pubsub.NewHub(),
+		repliID:          replID,
+		replID2:          replID2,
+		secondReplOffset: secondOffset,
+		aofHandler:       aofHandler,
+	}
+	if cfg.ClusterEnabled {
+		if err := s.initCluster(); err != nil {
+			return nil, err
+		}
 	}
 
-	// 启动时都执行全量加载
+	// 以恢复的复制 ID 和 offset 向 master 发起 PSYNC
 	if cfg.MasterAddr != "" {
 		log.Printf("[slave] master has been set %s", cfg.MasterAddr)
-		s.slave = &SlaveState{
-			masterAddr:   cfg.MasterAddr,
-			masterReplID: "?",
-			offset:       0,
-		}
+		aofHandler.SetReplicaMode(true)
+		s.slave = newSlaveState(cfg.MasterAddr)
+		s.slave.setReplPosition(replID, aofHandler.CurrentOffset(), aofHandler.StreamDB())
 	}
 
 	return s, nil
 }
 
-func (s *Server) ListenAndServe() error {
-	if s.slave != nil {
-		go s.startReplicationAsSlave()
+// checkAOF 加载前检查 AOF，结尾不完整且允许截断时修复，其余损坏拒绝启动
+func checkAOF(aofHandler *persistant.AOFHandler, loadTruncated bool) error {
+	result, err := aofHandler.Check()
+	if err != nil {
+		return fmt.Errorf("check aof: %w", err)
+	}
+	if result.OK() {
+		return nil
+	}
+	if !loadTruncated || !result.Truncated || !result.Fixable() {
+		return fmt.Errorf("%s, use 'goredis check-aof --fix' to repair it", result)
 	}
 
+	log.Printf("[aof] %s, truncating to %d bytes", result, result.ValidSize)
+	return aofHandler.Truncate(result.ValidSize)
+}
+
+func (s *Server) ListenAndServe() error {
 	ln, err := net.Listen("tcp", s.cfg.Addr)
 	if err != nil {
 		return err
 	}
+	return s.Serve(ln)
+}
+
+// Shutdown 进程退出前调用，把 AOF 落盘并更新复制状态，重启后可以和 master/slave 部分同步
+func (s *Server) Shutdown() {
+	s.aofHandler.Shutdown()
+}
+
+// Serve 在 ln 上接受客户端连接，ln 关闭后返回；cfg.Addr 应该是 ln 的地址，slave 据此上报端口
+func (s *Server) Serve(ln net.Listener) error {
+	if state := s.slaveState(); state != nil {
+		go s.startReplicationAsSlave(state)
+	}
+	if s.cluster != nil {
+		if err := s.cluster.Start(); err != nil {
+			return fmt.Errorf("start cluster bus: %w", err)
+		}
+	}
+
 	defer ln.Close()
 
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
 			continue
 		}
 		log.Printf("[server] accept connect success")
@@ -85,20 +211,19 @@ func (s *Server) ListenAndServe() error {
 func (s *Server) handleConn(raw net.Conn) {
 	client := connection.NewTCPConnection(raw)
 	defer func() {
-		// 断开时清理 slave
+		// 断开时清理 slave 和订阅
 		if client.IsSlave() {
 			s.repl.RemoveSlave(client)
+			s.aofHandler.RemoveSlave(client)
 		}
+		s.hub.UnsubscribeAll(client)
 		client.Close()
 	}()
-	parser := parser.NewParser(raw)
-
-	for {
-		payload, err := parser.Parse()
-		if err != nil {
-			return
-		}
+	payloads := readPayloads(raw, client.(*connection.TCPConnection))
+	// 上一条命令是 ASKING，只对紧接着的一条命令有效
+	asking := false
 
+	for payload := range payloads {
 		cmdLine, ok := common.ToCmdLine(payload)
 		if !ok {
 			log.Printf("[server] invalid payload type: %T", payload)
@@ -108,21 +233,116 @@ func (s *Server) handleConn(raw net.Conn) {
 			common.LogBytesArr("server", cmdLine)
 			continue
 		}
-		if cmd := types.CmdLine(cmdLine); cmd.IsWrite() && s.slave != nil {
+		if len(cmdLine) == 0 {
+			continue
+		}
+		// QUIT、RESET 作用于连接本身，事务中和订阅模式下也立即执行
+		switch strings.ToLower(string(cmdLine[0])) {
+		case "quit":
+			client.Write(resp.MakeOkReply().ToBytes())
+			return
+		case "reset":
+			if len(cmdLine) != 1 {
+				client.Write(resp.MakeArgNumErrReply("reset").ToBytes())
+				continue
+			}
+			s.resetConn(client)
+			asking = false
+			client.Write(resp.MakeSimpleStringReply("RESET").ToBytes())
+			continue
+		}
+		// 订阅模式下只能执行订阅相关命令和 PING，消息由 Hub 直接推送给连接
+		if s.hub.InSubscribeMode(client) {
+			if !pubsub.IsAllowedInSubscribeMode(cmdLine) {
+				errReply := resp.MakeErrReply("ERR Can't execute '" + strings.ToLower(string(cmdLine[0])) +
+					"': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context")
+				client.Write(errReply.ToBytes())
+				continue
+			}
+			if strings.EqualFold(string(cmdLine[0]), "ping") {
+				client.Write(pubsub.MakePongReply(cmdLine[1:]).ToBytes())
+				continue
+			}
+		}
+		if pubsub.IsPubSubCmd(cmdLine) {
+			// 发布订阅不经过 MULTI 的入队，事务中直接拒绝
+			if client.InMultiState() {
+				client.MarkTxAborted()
+				client.Write(resp.MakeErrReply("ERR Command not allowed inside a transaction").ToBytes())
+				continue
+			}
+			if reply := s.hub.Exec(client, cmdLine); reply != nil {
+				client.Write(reply.ToBytes())
+			}
+			continue
+		}
+		askingCmd := asking
+		asking = false
+		if reply, ok := s.execServerCmd(client, cmdLine); ok {
+			asking = isAsking(cmdLine) && !resp.IsErrorReply(reply)
+			client.Write(reply.ToBytes())
+			continue
+		}
+		if s.cluster != nil {
+			if errReply := s.routeCmd(client, cmdLine, askingCmd); errReply != nil {
+				client.Write(errReply.ToBytes())
+				continue
+			}
+		}
+		if s.db.IsWriteCmd(cmdLine) && s.slaveState() != nil {
 			log.Println("[slave] can't exec write cmd")
+			// 与入队时的其他错误一样，事务中被拒绝的写命令使 EXEC 失败
+			if client.InMultiState() {
+				client.MarkTxAborted()
+			}
 			errReply := resp.MakeErrReply("slave can't execute write cmd")
 			client.Write(errReply.ToBytes())
 			continue
 		}
+		if errReply := s.checkMinReplicas(client, cmdLine); errReply != nil {
+			client.Write(errReply.ToBytes())
+			continue
+		}
 
 		reply := s.db.Exec(client, cmdLine)
-		_, err = client.Write(reply.ToBytes())
+		_, err := client.Write(reply.ToBytes())
 		if err != nil {
 			return
 		}
 	}
 }
 
+// resetConn RESET：退出订阅模式和事务，取消 WATCH，切回 0 号数据库
+func (s *Server) resetConn(client connection.Connection) {
+	s.hub.UnsubscribeAll(client)
+	client.SetMultiState(false)
+	client.Unwatch()
+	client.SelectDB(0)
+}
+
+// readPayloads 在独立的 goroutine 中解析请求，这样执行 BLPOP 等阻塞命令期间也能及时感知客户端断开；
+// 读到 EOF 或出错时关闭 channel，已读到的命令仍会执行完
+func readPayloads(raw net.Conn, client *connection.TCPConnection) <-chan interface{} {
+	payloads := make(chan interface{})
+	go func() {
+		defer close(payloads)
+		parser := parser.NewParser(raw)
+		for {
+			payload, err := parser.Parse()
+			if err != nil {
+				client.NotifyPeerClosed()
+				return
+			}
+			select {
+			case payloads <- payload:
+			case <-client.Done():
+				return
+			}
+		}
+	}()
+	return payloads
+}
+
 func (s *Server) handleSlaveCmd(client connection.Connection, cmdLine [][]byte) bool {
 	// 处理slave连接的数据同步问题
 	if isPSync(cmdLine) {
@@ -136,3 +356,35 @@ func (s *Server) handleSlaveCmd(client connection.Connection, cmdLine [][]byte)
 
 	return false
 }
+
+func (s *Server) slaveState() *SlaveState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.slave
+}
+
+func (s *Server) replID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.repliID
+}
+
+// replIDs 返回当前和上一个复制 ID，以及上一个复制 ID 的历史截止的 offset
+func (s *Server) replIDs() (string, string, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.repliID, s.replID2, s.secondReplOffset
+}
+
+// setReplIDs 修改复制 ID 并持久化
+func (s *Server) setReplIDs(replID, replID2 string, secondOffset int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.repliID, s.replID2, s.secondReplOffset = replID, replID2, secondOffset
+	s.aofHandler.SetReplIDs(replID, replID2, secondOffset)
+}
+
+// shiftReplID 换一个新的复制 ID，当前 ID 的历史到 offset 为止，成为上一个复制 ID
+func (s *Server) shiftReplID(offset int64) {
+	s.setReplIDs(GenReplID(), s.replID(), offset)
+}
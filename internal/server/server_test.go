@@ -0,0 +1,224 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"goredis/pkg/parser"
+)
+
+// startTestServer 在随机端口上启动服务器，modify 可以修改默认配置，返回服务器和它的地址
+func startTestServer(t *testing.T, modify func(cfg *Config)) (*Server, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// AOF 的后台协程没有关闭的方法，测试结束时可能还在写文件，清理失败时忽略
+	dir, err := os.MkdirTemp("", "goredis-server-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	cfg := Config{Addr: ln.Addr().String(), AOFDir: dir, DBNum: 16}
+	if modify != nil {
+		modify(&cfg)
+	}
+	s, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(func() { ln.Close() })
+	return s, cfg.Addr
+}
+
+// testClient 同步收发命令的客户端，回复格式化为字符串，见 formatReply
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	p    *parser.Parser
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, p: parser.NewParser(conn)}
+}
+
+func (c *testClient) send(args ...string) {
+	c.t.Helper()
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		c.t.Fatalf("send %v: %v", args, err)
+	}
+}
+
+func (c *testClient) read() string {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	payload, err := c.p.Parse()
+	if err != nil {
+		c.t.Fatalf("read reply: %v", err)
+	}
+	return formatReply(payload)
+}
+
+func (c *testClient) do(args ...string) string {
+	c.t.Helper()
+	c.send(args...)
+	return c.read()
+}
+
+// formatReply 简单字符串和 bulk 原样返回，错误以 "(error) " 开头，nil 为 "(nil)"，数组为 "[a b]"
+func formatReply(payload interface{}) string {
+	switch v := payload.(type) {
+	case nil:
+		return "(nil)"
+	case string:
+		return v
+	case parser.RespError:
+		return "(error) " + v.Message
+	case int64:
+		return fmt.Sprint(v)
+	case []byte:
+		if v == nil {
+			return "(nil)"
+		}
+		return string(v)
+	case []interface{}:
+		if v == nil {
+			return "(nil)"
+		}
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = formatReply(item)
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	}
+	return fmt.Sprintf("%v", payload)
+}
+
+func assertReply(t *testing.T, got, want string) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+// waitFor 轮询 cond 直到成立，超时后测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// infoField 返回 INFO section 中 name 字段的值，没有时返回空字符串
+func (c *testClient) infoField(section, name string) string {
+	c.t.Helper()
+	for _, line := range strings.Split(c.do("info", section), "\r\n") {
+		if value, ok := strings.CutPrefix(line, name+":"); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// startReplica 启动一个复制 masterAddr 的服务器，等到完成全量同步后返回它的客户端
+func startReplica(t *testing.T, masterAddr string) (*Server, *testClient) {
+	t.Helper()
+	s, addr := startTestServer(t, func(cfg *Config) { cfg.MasterAddr = masterAddr })
+	c := dial(t, addr)
+	waitFor(t, "replica link up", func() bool { return c.infoField("replication", "master_link_status") == "up" })
+	return s, c
+}
+
+func TestPubSub(t *testing.T) {
+	_, addr := startTestServer(t, nil)
+
+	t.Run("not allowed in MULTI", func(t *testing.T) {
+		sub, c := dial(t, addr), dial(t, addr)
+		assertReply(t, sub.do("subscribe", "ch"), "[subscribe ch 1]")
+
+		assertReply(t, c.do("multi"), "OK")
+		assertReply(t, c.do("publish", "ch", "x"), "(error) ERR Command not allowed inside a transaction")
+		assertReply(t, c.do("pubsub", "numsub", "ch"), "(error) ERR Command not allowed inside a transaction")
+		assertReply(t, c.do("set", "k", "v"), "QUEUED")
+		assertReply(t, c.do("exec"), "(error) EXECABORT Transaction discarded because of previous errors.")
+		assertReply(t, c.do("get", "k"), "(nil)")
+
+		// 事务中的 PUBLISH 没有发出
+		assertReply(t, c.do("publish", "ch", "y"), "1")
+		assertReply(t, sub.read(), "[message ch y]")
+	})
+
+	t.Run("PING QUIT RESET in subscribe mode", func(t *testing.T) {
+		sub, c := dial(t, addr), dial(t, addr)
+		assertReply(t, sub.do("subscribe", "ping-ch"), "[subscribe ping-ch 1]")
+		assertReply(t, sub.do("ping"), "[pong ]")
+		assertReply(t, sub.do("ping", "hi"), "[pong hi]")
+		assertReply(t, sub.do("get", "k"),
+			"(error) ERR Can't execute 'get': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context")
+
+		// RESET 退出订阅模式并切回 0 号数据库，不再收到消息
+		assertReply(t, sub.do("reset"), "RESET")
+		assertReply(t, sub.do("ping"), "PONG")
+		assertReply(t, c.do("publish", "ping-ch", "x"), "0")
+
+		assertReply(t, sub.do("subscribe", "ping-ch"), "[subscribe ping-ch 1]")
+		assertReply(t, sub.do("quit"), "OK")
+		if _, err := sub.p.Parse(); err == nil {
+			t.Error("connection should be closed after QUIT")
+		}
+		deadline := time.Now().Add(time.Second)
+		for c.do("publish", "ping-ch", "y") != "0" && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assertReply(t, c.do("pubsub", "numsub", "ping-ch"), "[ping-ch 0]")
+	})
+
+	t.Run("RESET leaves MULTI and selected db", func(t *testing.T) {
+		c := dial(t, addr)
+		assertReply(t, c.do("select", "1"), "OK")
+		assertReply(t, c.do("set", "db1", "v"), "OK")
+		assertReply(t, c.do("multi"), "OK")
+		assertReply(t, c.do("set", "queued", "v"), "QUEUED")
+		assertReply(t, c.do("reset"), "RESET")
+		assertReply(t, c.do("exec"), "(error) ERR EXEC without MULTI")
+		assertReply(t, c.do("get", "queued"), "(nil)")
+		assertReply(t, c.do("get", "db1"), "(nil)")
+	})
+}
+
+func TestSlaveRejectsWrites(t *testing.T) {
+	_, masterAddr := startTestServer(t, nil)
+	_, replica := startReplica(t, masterAddr)
+
+	assertReply(t, replica.do("set", "k", "v"), "(error) slave can't execute write cmd")
+
+	t.Run("inside MULTI aborts the transaction", func(t *testing.T) {
+		assertReply(t, replica.do("multi"), "OK")
+		assertReply(t, replica.do("get", "k"), "QUEUED")
+		assertReply(t, replica.do("set", "k", "v"), "(error) slave can't execute write cmd")
+		assertReply(t, replica.do("exec"), "(error) EXECABORT Transaction discarded because of previous errors.")
+		assertReply(t, replica.do("get", "k"), "(nil)")
+	})
+}
@@ -1,26 +1,68 @@
 package server
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"goredis/internal/common"
+	"goredis/internal/resp"
 	"goredis/pkg/connection"
 	"goredis/pkg/parser"
+	"io"
 	"log"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// 与 master 连接的状态，ROLE 中显示的值与 Redis 一致
+const (
+	linkConnect    = "connect"    // 尚未开始连接
+	linkConnecting = "connecting" // 正在建立连接和握手
+	linkConnected  = "connected"  // 正在接收复制流
+)
+
+const (
+	replDialTimeout   = 5 * time.Second
+	replRetryInterval = 2 * time.Second
+	replAckInterval   = 1 * time.Second
+)
+
+// SlaveState 一次 REPLICAOF 对应的复制状态，REPLICAOF 切换 master 或 NO ONE 时停止，不会再次使用
 type SlaveState struct {
 	masterAddr   string
 	masterReplID string
 	offset       int64
+	// 执行 master 发来的命令，不写 AOF（由 AppendReplStream 写入原始复制流）；
+	// 断线重连之间保留，部分同步时从复制流原来所在的数据库继续
+	execConn *connection.AOFConnection
+
+	mu        sync.Mutex
+	conn      net.Conn
+	closed    bool
+	link      string
+	lastIO    time.Time // 最近一次收到 master 数据的时间
+	downSince time.Time // 连接断开的时间
+}
 
-	conn   net.Conn
-	closed bool
+func newSlaveState(masterAddr string) *SlaveState {
+	return &SlaveState{
+		masterAddr:   masterAddr,
+		masterReplID: "?",
+		execConn:     connection.NewAOFConnection(0),
+		link:         linkConnect,
+		downSince:    time.Now(),
+	}
+}
+
+// setReplPosition 设置 PSYNC 使用的复制 ID 和 offset，以及复制流在 offset 处所在的数据库，开始复制之前调用
+func (state *SlaveState) setReplPosition(replID string, offset int64, streamDB int) {
+	state.masterReplID = replID
+	state.SetOffset(offset)
+	state.execConn.SelectDB(streamDB)
 }
 
 func (state *SlaveState) SetOffset(offset int64) {
@@ -31,31 +73,102 @@ func (state *SlaveState) GetOffset() int64 {
 	return atomic.LoadInt64(&state.offset)
 }
 
-func (s *Server) startReplicationAsSlave() {
-	for {
-		if err := s.slaveOnce(); err != nil {
+// stop 断开与 master 的连接，正在执行的命令执行完后复制循环退出
+func (state *SlaveState) stop() {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	state.closed = true
+	if state.conn != nil {
+		state.conn.Close()
+	}
+}
+
+func (state *SlaveState) isClosed() bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.closed
+}
+
+// setConn 记录新建立的连接；已经停止时返回 false
+func (state *SlaveState) setConn(conn net.Conn) bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if state.closed {
+		return false
+	}
+	state.conn = conn
+	return true
+}
+
+func (state *SlaveState) setLink(link string) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if link == linkConnected {
+		state.lastIO = time.Now()
+	} else if state.link == linkConnected {
+		state.downSince = time.Now()
+	}
+	state.link = link
+}
+
+// apply 在复制没有停止时执行 f，与 stop 互斥，停止后不会再修改本地数据
+func (state *SlaveState) apply(f func()) bool {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if state.closed {
+		return false
+	}
+	state.lastIO = time.Now()
+	f()
+	return true
+}
+
+// linkInfo 返回连接状态、最近一次收到数据和连接断开的时间
+func (state *SlaveState) linkInfo() (string, time.Time, time.Time) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.link, state.lastIO, state.downSince
+}
+
+func (s *Server) startReplicationAsSlave(state *SlaveState) {
+	for !state.isClosed() {
+		if err := s.slaveOnce(state); err != nil && !state.isClosed() {
 			log.Printf("[slave] replication error: %v", err)
 		}
-		time.Sleep(2 * time.Second)
+		state.setLink(linkConnecting)
+		time.Sleep(replRetryInterval)
 	}
+	log.Printf("[slave] replication with %s stopped", state.masterAddr)
 }
 
-func (s *Server) slaveOnce() error {
-	conn, err := net.Dial("tcp", s.slave.masterAddr)
+func (s *Server) slaveOnce(state *SlaveState) error {
+	state.setLink(linkConnecting)
+	conn, err := net.DialTimeout("tcp", state.masterAddr, replDialTimeout)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
-	s.slave.conn = conn
+	if !state.setConn(conn) {
+		return nil
+	}
 
 	parser := parser.NewParser(conn)
 
-	// 1. PSYNC
-	if _, err := s.sendPSync(conn); err != nil {
+	// 1. 告知 master 自己的监听端口，ROLE 和 INFO 中据此显示 slave 的地址
+	if err := s.sendListeningPort(conn, parser); err != nil {
+		return err
+	}
+
+	// 2. PSYNC
+	if _, err := s.sendPSync(conn, state); err != nil {
 		return err
 	}
 
-	// 2. 解析 master 首包
+	// 3. 解析 master 首包
 	payload, err := parser.Parse()
 	if err != nil {
 		log.Printf("[slave] parse payload failed for: %s", err)
@@ -71,88 +184,204 @@ func (s *Server) slaveOnce() error {
 
 	if strings.HasPrefix(string(cmdLine[0]), "FULLRESYNC") {
 		// 从全量复制开始执行并持续监听后续增量写命令
-		return s.handleFullResync(cmdLine, parser)
+		return s.handleFullResync(state, cmdLine, parser)
 
 	} else if strings.HasPrefix(string(cmdLine[0]), "CONTINUE") {
-		// 直接进入增量 replay
-		s.replicationLoop(parser)
+		// master 的复制 ID 变了（例如同级 slave 提升为 master），切换到新的 ID 后进入增量 replay
+		if len(cmdLine) > 1 && len(cmdLine[1]) > 0 && string(cmdLine[1]) != state.masterReplID {
+			if !state.apply(func() { s.switchMasterReplID(state, string(cmdLine[1])) }) {
+				return nil
+			}
+		}
+		return s.replicationLoop(state, parser)
 	}
 
 	return fmt.Errorf("unexpected master reply")
 }
 
 func (s *Server) handleFullResync(
+	state *SlaveState,
 	cmdLine [][]byte,
 	parser *parser.Parser,
 ) error {
-	s.slave.masterReplID = string(cmdLine[1])
-	s.slave.offset, _ = strconv.ParseInt(string(cmdLine[2]), 10, 64)
-	log.Printf("[slave] masterID:%s start offset :%d", s.slave.masterReplID, s.slave.offset)
+	if len(cmdLine) < 3 {
+		return fmt.Errorf("invalid FULLRESYNC reply")
+	}
+	masterReplID := string(cmdLine[1])
+	offset, err := strconv.ParseInt(string(cmdLine[2]), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid FULLRESYNC offset: %q", cmdLine[2])
+	}
+	log.Printf("[slave] masterID:%s start offset :%d", masterReplID, offset)
 
-	// 清空本地状态
-	s.db.Clear()
-	s.aofHandler.Reset(s.slave.offset)
-	s.repl.InitBacklog(s.slave.offset)
+	// 全量数据以 bulk string 发送，不属于复制流
+	payload, err := parser.Parse()
+	if err != nil {
+		return err
+	}
+	data, ok := payload.([]byte)
+	if !ok {
+		return fmt.Errorf("unexpected full sync payload %T", payload)
+	}
 
-	// 进入统一复制流（全量数据 + 增量命令）
-	return s.replicationLoop(parser)
+	// 用全量数据替换本地状态
+	var loadErr error
+	applied := state.apply(func() {
+		s.db.Clear()
+		state.execConn = connection.NewAOFConnection(0)
+		if loadErr = s.loadFullSyncData(state.execConn, data); loadErr != nil {
+			return
+		}
+		if loadErr = s.aofHandler.Reset(offset, data); loadErr != nil {
+			return
+		}
+		s.repl.InitBacklog(offset)
+		s.aofHandler.SetBacklog(s.repl.Backlog())
+		// 之后的历史与 master 相同，使用它的复制 ID
+		s.setReplIDs(masterReplID, noReplID, -1)
+		state.masterReplID = masterReplID
+		state.SetOffset(offset)
+		// 本地数据被替换，下游的 slave 需要重新全量同步
+		s.repl.DisconnectSlaves()
+	})
+	if !applied {
+		return nil
+	}
+	if loadErr != nil {
+		return fmt.Errorf("load full sync data: %w", loadErr)
+	}
+
+	// 进入增量复制流
+	return s.replicationLoop(state, parser)
 }
 
-func (s *Server) replicationLoop(parser *parser.Parser) error {
-	tcpConn := connection.NewTCPConnection(s.slave.conn)
+// loadFullSyncData 执行全量数据中的命令
+func (s *Server) loadFullSyncData(conn connection.Connection, data []byte) error {
+	p := parser.NewParser(bytes.NewReader(data))
+	for {
+		payload, err := p.Parse()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		cmdLine, ok := common.ToCmdLine(payload)
+		if !ok || len(cmdLine) == 0 {
+			return fmt.Errorf("invalid command in full sync data")
+		}
+		s.db.Exec(conn, cmdLine)
+	}
+}
 
-	ackTicker := time.NewTicker(3 * time.Second)
-	defer ackTicker.Stop()
+// switchMasterReplID master 换了复制 ID：之前的历史到当前 offset 为止，使用新 ID 继续。
+// 下游的 slave 重连后同样切换
+func (s *Server) switchMasterReplID(state *SlaveState, replID string) {
+	log.Printf("[slave] master replication id changed to %s", replID)
+	s.setReplIDs(replID, state.masterReplID, state.GetOffset())
+	state.masterReplID = replID
+	s.repl.DisconnectSlaves()
+}
 
-	for {
-		select {
-		case <-ackTicker.C:
-			s.sendAck()
-
-		default:
-			//这是阻塞操作
-			payload, err := parser.Parse()
-			if err != nil {
-				log.Printf("[slave] read byte from master failed for %s, try to reconnect....", err)
-				return err
-			}
+func (s *Server) replicationLoop(state *SlaveState, parser *parser.Parser) error {
+	state.setLink(linkConnected)
 
-			cmdLine, ok := common.ToCmdLine(payload)
-			if !ok {
-				continue
+	// master 空闲时也定期上报 offset，master 据此计算 lag
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ackTicker := time.NewTicker(replAckInterval)
+		defer ackTicker.Stop()
+		for {
+			select {
+			case <-ackTicker.C:
+				s.sendAck(state)
+			case <-done:
+				return
 			}
-			common.LogBytesArr("slave recived", cmdLine)
-			// 执行命令（写 DB + AOF）
-			s.db.Exec(tcpConn, cmdLine)
+		}
+	}()
 
-			// 更新offset(使用aof的offset保证数据不丢失)
-			s.slave.SetOffset(s.aofHandler.CurrentOffset())
+	for {
+		//这是阻塞操作
+		payload, err := parser.Parse()
+		if err != nil {
+			log.Printf("[slave] read byte from master failed for %s, try to reconnect....", err)
+			return err
 		}
+
+		cmdLine, ok := common.ToCmdLine(payload)
+		if !ok {
+			continue
+		}
+		common.LogBytesArr("slave recived", cmdLine)
+		// REPLCONF GETACK 不属于数据，立即上报 offset，不执行也不计入 offset
+		if isGetAck(cmdLine) {
+			s.sendAck(state)
+			continue
+		}
+		// 执行命令，原始的复制流写入 AOF 和 backlog 并转发给下游 slave
+		applied := state.apply(func() {
+			s.db.Exec(state.execConn, cmdLine)
+			// offset 是已处理的复制流字节数，master 按同样的编码发送命令，重新编码即可得到原始字节
+			b := resp.MakeMultiBulkReply(cmdLine).ToBytes()
+			s.aofHandler.AppendReplStream(state.execConn.GetDBIndex(), b)
+			state.SetOffset(state.GetOffset() + int64(len(b)))
+		})
+		if !applied {
+			return nil
+		}
+	}
+}
+
+// sendListeningPort 发送 REPLCONF listening-port，不支持的 master 返回错误时忽略
+func (s *Server) sendListeningPort(conn net.Conn, parser *parser.Parser) error {
+	_, port, err := net.SplitHostPort(s.cfg.Addr)
+	if err != nil {
+		return nil
 	}
+	cmd := fmt.Sprintf(
+		"*3\r\n$8\r\nREPLCONF\r\n$14\r\nlistening-port\r\n$%d\r\n%s\r\n",
+		len(port), port,
+	)
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		return err
+	}
+	_, err = parser.Parse()
+	return err
 }
 
-func (s *Server) sendPSync(conn net.Conn) (int, error) {
+func (s *Server) sendPSync(conn net.Conn, state *SlaveState) (int, error) {
+	offset := strconv.FormatInt(state.GetOffset(), 10)
 	cmd := fmt.Sprintf(
-		"*3\r\n$5\r\nPSYNC\r\n$%d\r\n%s\r\n$%d\r\n%d\r\n",
-		len(s.slave.masterReplID), s.slave.masterReplID,
-		len(strconv.FormatInt(s.slave.offset, 10)),
-		s.slave.offset,
+		"*3\r\n$5\r\nPSYNC\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n",
+		len(state.masterReplID), state.masterReplID,
+		len(offset), offset,
 	)
 
 	return conn.Write([]byte(cmd))
 }
 
-func (s *Server) sendAck() {
-	if s.slave.conn == nil {
+func isGetAck(cmdLine [][]byte) bool {
+	return len(cmdLine) >= 2 &&
+		strings.EqualFold(string(cmdLine[0]), "replconf") &&
+		strings.EqualFold(string(cmdLine[1]), "getack")
+}
+
+func (s *Server) sendAck(state *SlaveState) {
+	state.mu.Lock()
+	conn := state.conn
+	state.mu.Unlock()
+	if conn == nil {
 		return
 	}
 
-	offsetStr := strconv.FormatInt(s.slave.offset, 10)
+	offsetStr := strconv.FormatInt(state.GetOffset(), 10)
 	cmd := fmt.Sprintf(
 		"*3\r\n$8\r\nREPLCONF\r\n$3\r\nACK\r\n$%d\r\n%s\r\n",
 		len(offsetStr),
 		offsetStr,
 	)
 
-	s.slave.conn.Write([]byte(cmd))
+	conn.Write([]byte(cmd))
 }
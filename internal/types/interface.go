@@ -38,12 +38,34 @@ type Database interface {
 
 	// GetExpireTime 获取键的过期时间
 	GetExpireTime(key string) (time.Time, bool)
+
+	// Clear 清空数据库中的所有键
+	Clear()
+
+	// Len 返回键的数量，可能包含已过期但还没有被删除的键
+	Len() int
+
+	// Scan 从游标 cursor 开始返回一批键（不少于 count 个），以及下一次的游标，遍历结束时为 0
+	Scan(cursor, count int) ([]string, int)
+
+	// RandomKey 随机返回一个未过期的键
+	RandomKey() (string, bool)
 }
 
 type RedisData interface {
 	ToWriteCmdLine(key string) [][]byte
 }
 
+// MultiCmdWriter 一条命令无法重建的数据，例如带消费组的 Stream，AOF 重写时使用 ToWriteCmdLines
+type MultiCmdWriter interface {
+	ToWriteCmdLines(key string) [][][]byte
+}
+
+// MemorySizer 估算数据占用的内存，用于 maxmemory 统计，每次写命令后都会调用，需要是 O(1) 的
+type MemorySizer interface {
+	MemoryUsage() int64
+}
+
 type Cloneable interface {
 	Clone() interface{}
 }
@@ -1,7 +1,10 @@
 package types
 
 import (
+	"math/rand"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 // CmdLine 是命令行的别名，例如: set key val -> [][]byte
@@ -9,42 +12,104 @@ type CmdLine [][]byte
 
 var writeCommands = map[string]struct{}{
 	// string
-	"set":    {},
-	"setnx":  {},
-	"incr":   {},
-	"incrby": {},
-	"decr":   {},
-	"decrby": {},
+	"set":         {},
+	"setnx":       {},
+	"setex":       {},
+	"psetex":      {},
+	"mset":        {},
+	"msetnx":      {},
+	"getset":      {},
+	"getdel":      {},
+	"getex":       {},
+	"append":      {},
+	"setrange":    {},
+	"incr":        {},
+	"incrby":      {},
+	"incrbyfloat": {},
+	"decr":        {},
+	"decrby":      {},
+
+	// bitmap
+	"setbit":   {},
+	"bitop":    {},
+	"bitfield": {},
+
+	// hyperloglog
+	"pfadd":   {},
+	"pfmerge": {},
+	"pfdebug": {},
 
 	// hash
-	"hset": {},
-	"hdel": {},
+	"hset":  {},
+	"hmset": {},
+	"hdel":  {},
 
 	// list
-	"lpush": {},
-	"rpush": {},
-	"lpop":  {},
-	"rpop":  {},
-	"lset":  {},
-	"ltrim": {},
-	"lrem":  {},
+	"lpush":      {},
+	"rpush":      {},
+	"lpop":       {},
+	"rpop":       {},
+	"lset":       {},
+	"ltrim":      {},
+	"lrem":       {},
+	"lmove":      {},
+	"rpoplpush":  {},
+	"blpop":      {},
+	"brpop":      {},
+	"blmove":     {},
+	"brpoplpush": {},
 
 	// set
 	"sadd": {},
 	"srem": {},
+	"spop": {},
 
 	// zset
 	"zadd": {},
 	"zrem": {},
 
+	// geo
+	"geoadd":         {},
+	"geosearchstore": {},
+
+	// stream
+	"xadd":       {},
+	"xdel":       {},
+	"xtrim":      {},
+	"xsetid":     {},
+	"xgroup":     {},
+	"xreadgroup": {},
+	"xack":       {},
+	"xclaim":     {},
+	"xautoclaim": {},
+
 	// key
-	"del":    {},
-	"expire": {},
-	"rename": {},
+	"del":            {},
+	"expire":         {},
+	"pexpire":        {},
+	"expireat":       {},
+	"pexpireat":      {},
+	"persist":        {},
+	"rename":         {},
+	"restore":        {},
+	"restore-asking": {},
 
 	// db
-	"select":  {},
-	"flushdb": {},
+	"flushdb":  {},
+	"flushall": {},
+	"swapdb":   {},
+	"move":     {},
+	"migrate":  {},
+
+	// script，脚本和函数可能执行写命令，按写命令处理
+	"eval":    {},
+	"evalsha": {},
+	"fcall":   {},
+}
+
+// 按子命令区分读写的命令
+var writeSubcommands = map[string]map[string]struct{}{
+	"function": {"load": {}, "delete": {}, "flush": {}, "restore": {}},
 }
 
 func (c CmdLine) IsWrite() bool {
@@ -52,6 +117,13 @@ func (c CmdLine) IsWrite() bool {
 		return false
 	}
 	cmd := strings.ToLower(string(c[0]))
+	if subs, ok := writeSubcommands[cmd]; ok {
+		if len(c) < 2 {
+			return false
+		}
+		_, ok = subs[strings.ToLower(string(c[1]))]
+		return ok
+	}
 	_, ok := writeCommands[cmd]
 	return ok
 }
@@ -59,6 +131,76 @@ func (c CmdLine) IsWrite() bool {
 // DataEntity 代表数据库中的数据实体
 type DataEntity struct {
 	Data interface{} // 实际数据: string, *list.List, *set.Set, etc.
+
+	// 以下字段供 maxmemory 淘汰使用，读命令也会更新，只能通过原子操作访问
+	lru  int64  // 最近一次访问的时间，毫秒
+	size int64  // 已计入数据库内存统计的大小
+	lfu  uint32 // 高 16 位是最近一次衰减的时间（分钟），低 8 位是对数访问计数
+}
+
+// LFU 参数与 Redis 默认配置一致
+const (
+	LFUInitVal   = 5  // 新 key 的初始计数，避免刚写入就被淘汰
+	lfuLogFactor = 10 // 计数越大越难增长
+	lfuDecayTime = 1  // 每隔多少分钟计数减一
+)
+
+func lruClock() int64 {
+	return time.Now().UnixMilli()
+}
+
+func lfuClock() uint32 {
+	return uint32(time.Now().Unix()/60) & 0xFFFF
+}
+
+// InitAccess 写入数据库时初始化访问信息
+func (entity *DataEntity) InitAccess() {
+	atomic.StoreInt64(&entity.lru, lruClock())
+	atomic.StoreUint32(&entity.lfu, lfuClock()<<8|LFUInitVal)
+}
+
+// Touch 记录一次访问：更新访问时间，并按对数概率增加 LFU 计数
+func (entity *DataEntity) Touch() {
+	atomic.StoreInt64(&entity.lru, lruClock())
+
+	counter := entity.LFUCount()
+	if counter < 255 {
+		base := float64(counter) - LFUInitVal
+		if base < 0 {
+			base = 0
+		}
+		if rand.Float64() < 1/(base*lfuLogFactor+1) {
+			counter++
+		}
+	}
+	atomic.StoreUint32(&entity.lfu, lfuClock()<<8|uint32(counter))
+}
+
+// IdleTime 距离最近一次访问的时间
+func (entity *DataEntity) IdleTime() time.Duration {
+	last, now := atomic.LoadInt64(&entity.lru), lruClock()
+	if now <= last {
+		return 0
+	}
+	return time.Duration(now-last) * time.Millisecond
+}
+
+// LFUCount 返回按时间衰减后的访问计数
+func (entity *DataEntity) LFUCount() uint8 {
+	v := atomic.LoadUint32(&entity.lfu)
+	counter := v & 0xFF
+	// 分钟时钟只有 16 位，回绕后按差值计算
+	elapsed := (lfuClock() - v>>8) & 0xFFFF
+	periods := elapsed / lfuDecayTime
+	if periods >= counter {
+		return 0
+	}
+	return uint8(counter - periods)
+}
+
+// SwapSize 记录新的内存大小并返回之前的值
+func (entity *DataEntity) SwapSize(size int64) int64 {
+	return atomic.SwapInt64(&entity.size, size)
 }
 
 func (entity *DataEntity) Clone() interface{} {
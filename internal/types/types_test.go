@@ -2,6 +2,7 @@ package types
 
 import (
 	"testing"
+	"time"
 )
 
 func TestTypes_All(t *testing.T) {
@@ -18,6 +19,12 @@ func TestTypes_All(t *testing.T) {
 			{"get_read", [][]byte{[]byte("get")}, false},
 			{"unknown", [][]byte{[]byte("foo")}, false},
 			{"multi_args", [][]byte{[]byte("SET"), []byte("k"), []byte("v")}, true},
+			{"function_load", [][]byte{[]byte("FUNCTION"), []byte("LOAD"), []byte("code")}, true},
+			{"function_list", [][]byte{[]byte("function"), []byte("list")}, false},
+			{"function_only", [][]byte{[]byte("function")}, false},
+			{"fcall_ro", [][]byte{[]byte("fcall_ro"), []byte("f"), []byte("0")}, false},
+			{"hmset", [][]byte{[]byte("hmset"), []byte("h"), []byte("f"), []byte("v")}, true},
+			{"spop", [][]byte{[]byte("spop"), []byte("s")}, true},
 		}
 		for _, tc := range tests {
 			tc := tc
@@ -55,6 +62,39 @@ func TestTypes_All(t *testing.T) {
 			_ = entity.Clone()
 		})
 	})
+
+	t.Run("DataEntity_Access", func(t *testing.T) {
+		entity := &DataEntity{Data: "v"}
+		if entity.LFUCount() != 0 {
+			t.Errorf("uninitialized counter should be 0, got %d", entity.LFUCount())
+		}
+
+		entity.InitAccess()
+		if entity.LFUCount() != LFUInitVal {
+			t.Errorf("counter = %d, want %d", entity.LFUCount(), LFUInitVal)
+		}
+		// 计数不超过初始值时每次访问必定增加
+		entity.Touch()
+		if entity.LFUCount() != LFUInitVal+1 {
+			t.Errorf("counter = %d, want %d", entity.LFUCount(), LFUInitVal+1)
+		}
+		// 计数越大越难增长
+		for i := 0; i < 1000; i++ {
+			entity.Touch()
+		}
+		if c := entity.LFUCount(); c <= LFUInitVal+1 || c > 100 {
+			t.Errorf("counter should grow logarithmically, got %d", c)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		if entity.IdleTime() < 20*time.Millisecond {
+			t.Errorf("idle time = %v, want >= 20ms", entity.IdleTime())
+		}
+		entity.Touch()
+		if entity.IdleTime() >= 20*time.Millisecond {
+			t.Errorf("idle time should be reset by Touch, got %v", entity.IdleTime())
+		}
+	})
 }
 
 // ---------------- 辅助 ----------------
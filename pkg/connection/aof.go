@@ -2,6 +2,9 @@ package connection
 
 type AOFConnection struct {
 	dbIndex int
+
+	// 回放 AOF 中的 MULTI ... EXEC
+	Transaction
 }
 
 func NewAOFConnection(db int) *AOFConnection {
@@ -32,6 +35,11 @@ func (c *AOFConnection) IsClosed() bool {
 	return false
 }
 
+// Done 伪连接永远不会断开
+func (c *AOFConnection) Done() <-chan struct{} {
+	return nil
+}
+
 func (c *AOFConnection) RemoteAddr() string {
 	return "local:aof"
 }
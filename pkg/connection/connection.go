@@ -10,6 +10,9 @@ type Connection interface {
 	// 连接是否已关闭
 	IsClosed() bool
 
+	// 连接关闭时 channel 被关闭，阻塞命令借此感知客户端断开
+	Done() <-chan struct{}
+
 	// 当前选中的 DB
 	GetDBIndex() int
 	SelectDB(int)
@@ -19,4 +22,15 @@ type Connection interface {
 
 	IsSlave() bool
 	SetSlave()
+
+	// 事务 (MULTI/EXEC/WATCH)
+	InMultiState() bool
+	SetMultiState(bool)
+	EnqueueCmd([][]byte)
+	GetQueuedCmdLine() [][][]byte
+	MarkTxAborted()
+	IsTxAborted() bool
+	Watch(key WatchKey, version uint64)
+	GetWatching() map[WatchKey]uint64
+	Unwatch()
 }
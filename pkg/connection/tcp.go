@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net"
 	"sync"
+	"time"
 )
 
 type ConnRole int
@@ -19,6 +20,12 @@ type TCPConnection struct {
 	role    ConnRole
 	mu      sync.Mutex
 	closed  bool
+
+	// 连接关闭或对端断开时关闭
+	done     chan struct{}
+	doneOnce sync.Once
+
+	Transaction
 }
 
 func NewTCPConnection(conn net.Conn) Connection {
@@ -26,6 +33,7 @@ func NewTCPConnection(conn net.Conn) Connection {
 		conn:    conn,
 		dbIndex: 0, // Redis 默认 DB 0
 		role:    RoleNormal,
+		done:    make(chan struct{}),
 	}
 }
 
@@ -51,6 +59,20 @@ func (c *TCPConnection) Write(b []byte) (int, error) {
 	return c.conn.Write(b)
 }
 
+// WriteTimeout 与 Write 相同，但超过 timeout 还没写完时返回错误；
+// 此时可能只写出了一部分数据，调用方应关闭连接
+func (c *TCPConnection) WriteTimeout(b []byte, timeout time.Duration) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return 0, errors.New("connection closed")
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	defer c.conn.SetWriteDeadline(time.Time{})
+	return c.conn.Write(b)
+}
+
 func (c *TCPConnection) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -59,15 +81,27 @@ func (c *TCPConnection) Close() error {
 		return nil
 	}
 	c.closed = true
+	c.NotifyPeerClosed()
 	return c.conn.Close()
 }
 
+// NotifyPeerClosed 读到 EOF 时调用，唤醒阻塞中的命令；连接仍可写出剩余的回复
+func (c *TCPConnection) NotifyPeerClosed() {
+	c.doneOnce.Do(func() {
+		close(c.done)
+	})
+}
+
 func (c *TCPConnection) IsClosed() bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	return c.closed
 }
 
+func (c *TCPConnection) Done() <-chan struct{} {
+	return c.done
+}
+
 func (c *TCPConnection) GetDBIndex() int {
 	c.mu.Lock()
 	defer c.mu.Unlock()
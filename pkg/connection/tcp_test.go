@@ -106,6 +106,11 @@ func TestTCPConnection_All(t *testing.T) {
 		if !c.IsClosed() {
 			t.Error("IsClosed should be true")
 		}
+		select {
+		case <-c.Done():
+		default:
+			t.Error("Done channel should be closed")
+		}
 		// 再关一次不应出错
 		if err := c.Close(); err != nil {
 			t.Errorf("second Close should be nil, got %v", err)
@@ -133,6 +138,35 @@ func TestTCPConnection_All(t *testing.T) {
 		wg.Wait()     // 现在 goroutine 都能在 50 ms 内返回，不会挂死
 	})
 
+	t.Run("Transaction", func(t *testing.T) {
+		srv, _ := newPipeConns()
+		defer srv.Close()
+		c := NewTCPConnection(srv)
+
+		c.SetMultiState(true)
+		c.EnqueueCmd([][]byte{[]byte("set"), []byte("k"), []byte("v")})
+		c.MarkTxAborted()
+		if !c.InMultiState() || len(c.GetQueuedCmdLine()) != 1 || !c.IsTxAborted() {
+			t.Fatal("transaction state not recorded")
+		}
+
+		c.SetMultiState(false)
+		if c.InMultiState() || len(c.GetQueuedCmdLine()) != 0 || c.IsTxAborted() {
+			t.Error("leaving multi state should reset queue and abort flag")
+		}
+
+		key := WatchKey{DBIndex: 1, Key: "k"}
+		c.Watch(key, 3)
+		c.Watch(key, 5)
+		if c.GetWatching()[key] != 3 {
+			t.Errorf("repeated WATCH should keep first version, got %d", c.GetWatching()[key])
+		}
+		c.Unwatch()
+		if len(c.GetWatching()) != 0 {
+			t.Error("Unwatch should clear watched keys")
+		}
+	})
+
 	t.Run("RemoteAddr", func(t *testing.T) {
 		srv, _ := newPipeConns()
 		defer srv.Close()
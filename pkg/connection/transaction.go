@@ -0,0 +1,63 @@
+package connection
+
+// WatchKey 标识一个被 WATCH 的 key，WATCH 与执行时选中的数据库绑定
+type WatchKey struct {
+	DBIndex int
+	Key     string
+}
+
+// Transaction 记录连接上 MULTI/EXEC 的事务状态
+// 只会被连接自己的 goroutine 访问，因此不需要加锁
+type Transaction struct {
+	multi    bool
+	aborted  bool // 入队时出现错误，EXEC 时直接放弃整个事务
+	queue    [][][]byte
+	watching map[WatchKey]uint64 // key -> WATCH 时的版本号
+}
+
+func (tx *Transaction) InMultiState() bool {
+	return tx.multi
+}
+
+// SetMultiState 进入或退出事务，退出时清空已入队的命令
+func (tx *Transaction) SetMultiState(state bool) {
+	if !state {
+		tx.queue = nil
+		tx.aborted = false
+	}
+	tx.multi = state
+}
+
+func (tx *Transaction) EnqueueCmd(cmdLine [][]byte) {
+	tx.queue = append(tx.queue, cmdLine)
+}
+
+func (tx *Transaction) GetQueuedCmdLine() [][][]byte {
+	return tx.queue
+}
+
+func (tx *Transaction) MarkTxAborted() {
+	tx.aborted = true
+}
+
+func (tx *Transaction) IsTxAborted() bool {
+	return tx.aborted
+}
+
+func (tx *Transaction) Watch(key WatchKey, version uint64) {
+	if tx.watching == nil {
+		tx.watching = make(map[WatchKey]uint64)
+	}
+	// 重复 WATCH 保留第一次的版本
+	if _, ok := tx.watching[key]; !ok {
+		tx.watching[key] = version
+	}
+}
+
+func (tx *Transaction) GetWatching() map[WatchKey]uint64 {
+	return tx.watching
+}
+
+func (tx *Transaction) Unwatch() {
+	tx.watching = nil
+}
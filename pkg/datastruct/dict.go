@@ -23,11 +23,12 @@ type Dict interface {
 	Put(key string, val interface{}) (result int)         // 对应 Redis SET
 	PutIfAbsent(key string, val interface{}) (result int) // 对应 Redis SETNX
 	PutIfExists(key string, val interface{}) (result int)
-	Remove(key string) (result int) // 对应 Redis DEL
-	Keys() []string                 // 对应 Redis KEYS *
-	ForEach(consumer Consumer)      // 遍历所有数据
-	RandomKeys(limit int) []string  // 对应 Redis RANDOMKEY，用于驱逐策略
-	Clear()                         // 清空
+	Remove(key string) (result int)                   // 对应 Redis DEL
+	Keys() []string                                   // 对应 Redis KEYS *
+	ForEach(consumer Consumer)                        // 遍历所有数据
+	RandomKeys(limit int) []string                    // 对应 Redis RANDOMKEY，用于驱逐策略
+	Scan(cursor, count int) (keys []string, next int) // 对应 Redis SCAN
+	Clear()                                           // 清空
 }
 
 // shard 单个分片结构
@@ -165,37 +166,52 @@ func (dict *ConcurrentDict) RandomKeys(limit int) []string {
 		return dict.Keys()
 	}
 	used := make(map[string]bool)
-	result := make([]string, limit)
+	result := make([]string, 0, limit)
 	// 随机选分片，再从分片里随机选 key
 	// 注意：Go 的 map 遍历本身就是随机的，但我们需要跨分片随机
-
-	for i := 0; i < limit; i++ {
-		flag := false
-		for {
-			shard := dict.table[rand.Intn(dict.shardCount)]
-			if len(shard.m) == 0 {
-				continue
-			}
-
-			shard.mutex.RLock()
-			// Go map 的随机性：range 一个就可以拿到随机元素
-			for key := range shard.m {
-				if !used[key] {
-					result[i] = key
-					used[key] = true
-					flag = true
-					break // 拿到一个就跑
-				}
-			}
-			shard.mutex.RUnlock()
-			if flag {
-				break
+	// 抽样期间其他协程可能在删除 key，尝试次数有上限，此时返回的 key 会少于 limit
+	maxTries := limit * dict.shardCount * 4
+	for tries := 0; len(result) < limit && tries < maxTries; tries++ {
+		shard := dict.table[rand.Intn(dict.shardCount)]
+
+		shard.mutex.RLock()
+		// Go map 的随机性：range 一个就可以拿到随机元素
+		for key := range shard.m {
+			if !used[key] {
+				result = append(result, key)
+				used[key] = true
+				break // 拿到一个就跑
 			}
 		}
+		shard.mutex.RUnlock()
 	}
 	return result
 }
 
+// Scan 从第 cursor 个分片开始按分片整体返回 key，直到不少于 count 个或遍历完所有分片，
+// 返回下一次调用的游标，为 0 表示遍历结束。
+// 分片数固定，key 所在的分片不会变化，因此整个遍历期间一直存在的 key 一定会被返回且只返回一次
+func (dict *ConcurrentDict) Scan(cursor, count int) ([]string, int) {
+	if cursor < 0 || cursor >= dict.shardCount {
+		return nil, 0
+	}
+
+	var keys []string
+	for cursor < dict.shardCount && len(keys) < count {
+		shard := dict.table[cursor]
+		shard.mutex.RLock()
+		for key := range shard.m {
+			keys = append(keys, key)
+		}
+		shard.mutex.RUnlock()
+		cursor++
+	}
+	if cursor >= dict.shardCount {
+		cursor = 0
+	}
+	return keys, cursor
+}
+
 func (dict *ConcurrentDict) Len() int {
 	return int(atomic.LoadInt32(&dict.count))
 }
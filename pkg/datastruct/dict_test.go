@@ -137,6 +137,44 @@ func TestConcurrentDict(t *testing.T) {
 		}
 	})
 
+	t.Run("Scan", func(t *testing.T) {
+		dict := MakeConcurrent(16)
+		for i := 0; i < 100; i++ {
+			dict.Put(fmt.Sprintf("stable%d", i), i)
+		}
+
+		seen := make(map[string]int)
+		cursor, calls := 0, 0
+		for {
+			// 遍历过程中不断增删其他 key
+			dict.Put(fmt.Sprintf("tmp%d", calls), calls)
+			dict.Remove(fmt.Sprintf("tmp%d", calls-1))
+
+			var keys []string
+			keys, cursor = dict.Scan(cursor, 10)
+			for _, key := range keys {
+				seen[key]++
+			}
+			calls++
+			if cursor == 0 {
+				break
+			}
+		}
+
+		if calls < 2 || calls > 16 {
+			t.Errorf("Expected scan to take several calls, got %d", calls)
+		}
+		for i := 0; i < 100; i++ {
+			if n := seen[fmt.Sprintf("stable%d", i)]; n != 1 {
+				t.Errorf("stable%d returned %d times, want 1", i, n)
+			}
+		}
+
+		if keys, next := dict.Scan(16, 10); keys != nil || next != 0 {
+			t.Errorf("Out of range cursor should end the scan, got %v %d", keys, next)
+		}
+	})
+
 	t.Run("Clear", func(t *testing.T) {
 		dict := MakeConcurrent(16)
 		dict.Put("k1", "v1")
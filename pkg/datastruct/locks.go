@@ -0,0 +1,99 @@
+package datastruct
+
+import (
+	"sort"
+	"sync"
+)
+
+// Locks 将 key 哈希到固定数量的读写锁上，保护 key 对应的数据结构。
+// 同时锁多个 key 时按锁的下标升序加锁，所有调用方的加锁顺序一致，因此不会死锁
+type Locks struct {
+	table []*sync.RWMutex
+}
+
+func MakeLocks(size int) *Locks {
+	table := make([]*sync.RWMutex, size)
+	for i := range table {
+		table[i] = &sync.RWMutex{}
+	}
+	return &Locks{table: table}
+}
+
+func (locks *Locks) index(key string) uint32 {
+	return computeHash(key) % uint32(len(locks.table))
+}
+
+func (locks *Locks) Lock(key string) {
+	locks.table[locks.index(key)].Lock()
+}
+
+func (locks *Locks) Unlock(key string) {
+	locks.table[locks.index(key)].Unlock()
+}
+
+func (locks *Locks) RLock(key string) {
+	locks.table[locks.index(key)].RLock()
+}
+
+func (locks *Locks) RUnlock(key string) {
+	locks.table[locks.index(key)].RUnlock()
+}
+
+// LockAll 对所有锁加写锁，用于清空整个库这类无法列出 key 的操作
+func (locks *Locks) LockAll() {
+	for _, mu := range locks.table {
+		mu.Lock()
+	}
+}
+
+func (locks *Locks) UnlockAll() {
+	for i := len(locks.table) - 1; i >= 0; i-- {
+		locks.table[i].Unlock()
+	}
+}
+
+// lockIndices 返回 key 对应的锁下标（去重、升序），以及其中需要加写锁的下标
+func (locks *Locks) lockIndices(writeKeys, readKeys []string) ([]uint32, map[uint32]bool) {
+	write := make(map[uint32]bool, len(writeKeys)+len(readKeys))
+	for _, key := range writeKeys {
+		write[locks.index(key)] = true
+	}
+	for _, key := range readKeys {
+		idx := locks.index(key)
+		if _, ok := write[idx]; !ok {
+			write[idx] = false
+		}
+	}
+
+	indices := make([]uint32, 0, len(write))
+	for idx := range write {
+		indices = append(indices, idx)
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	return indices, write
+}
+
+// RWLocks 写 key 加写锁，读 key 加读锁；两者落在同一把锁上时加写锁
+func (locks *Locks) RWLocks(writeKeys, readKeys []string) {
+	indices, write := locks.lockIndices(writeKeys, readKeys)
+	for _, idx := range indices {
+		if write[idx] {
+			locks.table[idx].Lock()
+		} else {
+			locks.table[idx].RLock()
+		}
+	}
+}
+
+// RWUnlocks 释放 RWLocks 加的锁，参数需与加锁时相同
+func (locks *Locks) RWUnlocks(writeKeys, readKeys []string) {
+	indices, write := locks.lockIndices(writeKeys, readKeys)
+	for i := len(indices) - 1; i >= 0; i-- {
+		idx := indices[i]
+		if write[idx] {
+			locks.table[idx].Unlock()
+		} else {
+			locks.table[idx].RUnlock()
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package datastruct
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLocks(t *testing.T) {
+	t.Run("indices are sorted and deduplicated", func(t *testing.T) {
+		locks := MakeLocks(8)
+		keys := make([]string, 0, 32)
+		for i := 0; i < 32; i++ {
+			keys = append(keys, "k"+strconv.Itoa(i))
+		}
+		indices, write := locks.lockIndices(keys[:4], keys)
+		if len(indices) > 8 {
+			t.Fatalf("indices should be deduplicated, got %v", indices)
+		}
+		for i := 1; i < len(indices); i++ {
+			if indices[i-1] >= indices[i] {
+				t.Fatalf("indices should be ascending, got %v", indices)
+			}
+		}
+		// 读写 key 落在同一把锁上时加写锁
+		for _, key := range keys[:4] {
+			if !write[locks.index(key)] {
+				t.Errorf("%s should be write locked", key)
+			}
+		}
+	})
+
+	t.Run("read locks are shared", func(t *testing.T) {
+		locks := MakeLocks(8)
+		locks.RWLocks(nil, []string{"a", "b"})
+		done := make(chan struct{})
+		go func() {
+			locks.RLock("a")
+			locks.RUnlock("a")
+			close(done)
+		}()
+		<-done
+		locks.RWUnlocks(nil, []string{"a", "b"})
+	})
+
+	t.Run("LockAll excludes key locks", func(t *testing.T) {
+		locks := MakeLocks(8)
+		locks.LockAll()
+		acquired := make(chan struct{})
+		go func() {
+			locks.RLock("a")
+			locks.RUnlock("a")
+			close(acquired)
+		}()
+		select {
+		case <-acquired:
+			t.Fatal("key lock acquired while all locks are held")
+		case <-time.After(20 * time.Millisecond):
+		}
+		locks.UnlockAll()
+		<-acquired
+	})
+
+	t.Run("overlapping key sets do not deadlock", func(t *testing.T) {
+		locks := MakeLocks(16)
+		keys := []string{"a", "b", "c", "d", "e"}
+		// map 本身只读，每个计数器只在持有对应 key 的锁时修改
+		counters := make(map[string]*int, len(keys))
+		for _, key := range keys {
+			counters[key] = new(int)
+		}
+
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 500; i++ {
+					// 每个协程以不同的顺序给出 key
+					write := []string{keys[(g+i)%5], keys[(g+2*i+1)%5]}
+					read := []string{keys[(g+3)%5]}
+					locks.RWLocks(write, read)
+					for _, key := range write {
+						*counters[key]++
+					}
+					locks.RWUnlocks(write, read)
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		total := 0
+		for _, n := range counters {
+			total += *n
+		}
+		if total != 8*500*2 {
+			t.Errorf("lost updates: total = %d", total)
+		}
+	})
+}
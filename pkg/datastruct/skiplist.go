@@ -61,6 +61,11 @@ func randomLevel() int {
 	return level
 }
 
+// Element 返回节点保存的元素
+func (n *SkipListNode) Element() *SkipListElement {
+	return n.element
+}
+
 func (s1 *SkipList) Head() *SkipListNode {
 	return s1.header
 }
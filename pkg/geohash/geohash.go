@@ -0,0 +1,334 @@
+package geohash
+
+import (
+	"math"
+)
+
+// 与 Redis 保持一致：纬度范围受 Web Mercator 投影限制，精度为 26 步，即 52 位
+const (
+	StepMax = 26
+
+	LongMin = -180.0
+	LongMax = 180.0
+	LatMin  = -85.05112878
+	LatMax  = 85.05112878
+
+	// EarthRadius 地球半径（米），与 Redis 使用的值一致
+	EarthRadius = 6372797.560856
+	mercatorMax = 20037726.37
+)
+
+const base32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// Bits 一个 geohash 区域，bits 的有效位数为 step*2
+type Bits struct {
+	Bits uint64
+	Step uint8
+}
+
+// IsZero 被排除的邻居区域
+func (b Bits) IsZero() bool {
+	return b.Bits == 0 && b.Step == 0
+}
+
+// ScoreRange 返回该区域在 52 位分值下对应的 [min, max) 区间
+func (b Bits) ScoreRange() (uint64, uint64) {
+	shift := uint(StepMax*2 - int(b.Step)*2)
+	return b.Bits << shift, (b.Bits + 1) << shift
+}
+
+// Range 经度或纬度的范围
+type Range struct {
+	Min, Max float64
+}
+
+// Area 一个 geohash 区域对应的经纬度范围
+type Area struct {
+	Hash      Bits
+	Longitude Range
+	Latitude  Range
+}
+
+// Neighbors 周围八个区域
+type Neighbors struct {
+	North, East, West, South                   Bits
+	NorthEast, SouthEast, NorthWest, SouthWest Bits
+}
+
+// Valid 检查经纬度是否可以编码
+func Valid(longitude, latitude float64) bool {
+	return longitude >= LongMin && longitude <= LongMax &&
+		latitude >= LatMin && latitude <= LatMax
+}
+
+// Encode 按给定精度编码经纬度，纬度占偶数位，经度占奇数位
+func Encode(longitude, latitude float64, step uint8) Bits {
+	return encodeRange(longitude, latitude, Range{LongMin, LongMax}, Range{LatMin, LatMax}, step)
+}
+
+// EncodeScore 按最高精度编码，用作 ZSet 的分值
+func EncodeScore(longitude, latitude float64) uint64 {
+	return Encode(longitude, latitude, StepMax).Bits
+}
+
+func encodeRange(longitude, latitude float64, longRange, latRange Range, step uint8) Bits {
+	latOffset := (latitude - latRange.Min) / (latRange.Max - latRange.Min)
+	longOffset := (longitude - longRange.Min) / (longRange.Max - longRange.Min)
+	latOffset *= float64(uint64(1) << step)
+	longOffset *= float64(uint64(1) << step)
+	return Bits{Bits: interleave(uint32(latOffset), uint32(longOffset)), Step: step}
+}
+
+// Decode 返回区域的经纬度范围
+func Decode(hash Bits) Area {
+	lat, long := deinterleave(hash.Bits)
+	scale := float64(uint64(1) << hash.Step)
+	latScale := LatMax - LatMin
+	longScale := LongMax - LongMin
+	return Area{
+		Hash: hash,
+		Latitude: Range{
+			Min: LatMin + float64(lat)/scale*latScale,
+			Max: LatMin + float64(lat+1)/scale*latScale,
+		},
+		Longitude: Range{
+			Min: LongMin + float64(long)/scale*longScale,
+			Max: LongMin + float64(long+1)/scale*longScale,
+		},
+	}
+}
+
+// DecodeScore 把 52 位分值还原为区域中心的经纬度
+func DecodeScore(score uint64) (float64, float64) {
+	area := Decode(Bits{Bits: score, Step: StepMax})
+	longitude := math.Max(LongMin, math.Min(LongMax, (area.Longitude.Min+area.Longitude.Max)/2))
+	latitude := math.Max(LatMin, math.Min(LatMax, (area.Latitude.Min+area.Latitude.Max)/2))
+	return longitude, latitude
+}
+
+// String 返回标准的 11 位 geohash 字符串。
+// 标准 geohash 的纬度范围是 [-90, 90]，需要先还原坐标再重新编码
+func String(score uint64) string {
+	longitude, latitude := DecodeScore(score)
+	hash := encodeRange(longitude, latitude, Range{-180, 180}, Range{-90, 90}, StepMax)
+	buf := make([]byte, 11)
+	for i := range buf {
+		idx := 0
+		// 52 位只够 10 个字符，最后一个字符补 0
+		if i < 10 {
+			idx = int(hash.Bits>>(52-uint((i+1)*5))) & 0x1f
+		}
+		buf[i] = base32[idx]
+	}
+	return string(buf)
+}
+
+// GetNeighbors 计算周围八个区域
+func GetNeighbors(hash Bits) Neighbors {
+	move := func(dx, dy int) Bits {
+		return moveY(moveX(hash, dx), dy)
+	}
+	return Neighbors{
+		East:      move(1, 0),
+		West:      move(-1, 0),
+		North:     move(0, 1),
+		South:     move(0, -1),
+		NorthEast: move(1, 1),
+		SouthEast: move(1, -1),
+		NorthWest: move(-1, 1),
+		SouthWest: move(-1, -1),
+	}
+}
+
+// moveX 沿经度方向移动一格（奇数位）
+func moveX(hash Bits, d int) Bits {
+	if d == 0 {
+		return hash
+	}
+	x := hash.Bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.Bits & 0x5555555555555555
+	zz := uint64(0x5555555555555555) >> (64 - uint(hash.Step)*2)
+	if d > 0 {
+		x = x + (zz + 1)
+	} else {
+		x = x | zz
+		x = x - (zz + 1)
+	}
+	x &= uint64(0xaaaaaaaaaaaaaaaa) >> (64 - uint(hash.Step)*2)
+	return Bits{Bits: x | y, Step: hash.Step}
+}
+
+// moveY 沿纬度方向移动一格（偶数位）
+func moveY(hash Bits, d int) Bits {
+	if d == 0 {
+		return hash
+	}
+	x := hash.Bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.Bits & 0x5555555555555555
+	zz := uint64(0xaaaaaaaaaaaaaaaa) >> (64 - uint(hash.Step)*2)
+	if d > 0 {
+		y = y + (zz + 1)
+	} else {
+		y = y | zz
+		y = y - (zz + 1)
+	}
+	y &= uint64(0x5555555555555555) >> (64 - uint(hash.Step)*2)
+	return Bits{Bits: x | y, Step: hash.Step}
+}
+
+func interleave(lat, long uint32) uint64 {
+	var bits uint64
+	for i := 0; i < 32; i++ {
+		bits |= uint64(lat>>i&1) << (2 * i)
+		bits |= uint64(long>>i&1) << (2*i + 1)
+	}
+	return bits
+}
+
+func deinterleave(bits uint64) (uint32, uint32) {
+	var lat, long uint32
+	for i := 0; i < 32; i++ {
+		lat |= uint32(bits>>(2*i)&1) << i
+		long |= uint32(bits>>(2*i+1)&1) << i
+	}
+	return lat, long
+}
+
+func degToRad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func radToDeg(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// Distance 使用 haversine 公式计算两点间的距离（米）
+func Distance(long1, lat1, long2, lat2 float64) float64 {
+	lat1r, lat2r := degToRad(lat1), degToRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin((degToRad(long2) - degToRad(long1)) / 2)
+	return 2 * EarthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+// latDistance 只考虑纬度差的距离
+func latDistance(lat1, lat2 float64) float64 {
+	return EarthRadius * math.Abs(degToRad(lat2)-degToRad(lat1))
+}
+
+// Shape 搜索区域：半径为 Radius 的圆，或宽 Width 高 Height 的矩形，单位都是米
+type Shape struct {
+	Longitude, Latitude float64
+	IsBox               bool
+	Radius              float64
+	Width, Height       float64
+}
+
+// Contains 判断点是否在区域内，并返回到中心的距离（米）
+func (s *Shape) Contains(longitude, latitude float64) (float64, bool) {
+	if s.IsBox {
+		if latDistance(latitude, s.Latitude) > s.Height/2 {
+			return 0, false
+		}
+		if Distance(longitude, latitude, s.Longitude, latitude) > s.Width/2 {
+			return 0, false
+		}
+		return Distance(s.Longitude, s.Latitude, longitude, latitude), true
+	}
+	dist := Distance(s.Longitude, s.Latitude, longitude, latitude)
+	return dist, dist <= s.Radius
+}
+
+// boundingBox 包含搜索区域的经纬度矩形：minLong, minLat, maxLong, maxLat
+func (s *Shape) boundingBox() (float64, float64, float64, float64) {
+	height, width := s.Radius, s.Radius
+	if s.IsBox {
+		height, width = s.Height/2, s.Width/2
+	}
+	latDelta := radToDeg(height / EarthRadius)
+	longDeltaTop := radToDeg(width / EarthRadius / math.Cos(degToRad(s.Latitude+latDelta)))
+	longDeltaBottom := radToDeg(width / EarthRadius / math.Cos(degToRad(s.Latitude-latDelta)))
+	// 取离赤道较远一侧的经度跨度，它更宽
+	longDelta := longDeltaTop
+	if s.Latitude < 0 {
+		longDelta = longDeltaBottom
+	}
+	return s.Longitude - longDelta, s.Latitude - latDelta, s.Longitude + longDelta, s.Latitude + latDelta
+}
+
+// estimateSteps 根据半径估算合适的精度，使 3x3 个区域能覆盖搜索范围
+func estimateSteps(radius, latitude float64) uint8 {
+	if radius == 0 {
+		return StepMax
+	}
+	step := 1
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+	step -= 2
+	// 高纬度地区区域变窄，需要降低精度
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+	if step < 1 {
+		step = 1
+	}
+	if step > StepMax {
+		step = StepMax
+	}
+	return uint8(step)
+}
+
+// SearchAreas 返回需要扫描的 9 个区域（中心和八个邻居），不需要的邻居置为零值
+func (s *Shape) SearchAreas() []Bits {
+	radius := s.Radius
+	if s.IsBox {
+		radius = math.Sqrt((s.Width/2)*(s.Width/2) + (s.Height/2)*(s.Height/2))
+	}
+	minLong, minLat, maxLong, maxLat := s.boundingBox()
+
+	steps := estimateSteps(radius, s.Latitude)
+	hash := Encode(s.Longitude, s.Latitude, steps)
+	neighbors := GetNeighbors(hash)
+	area := Decode(hash)
+
+	// 估算的精度可能不足以覆盖整个范围，此时降低一级精度
+	if steps > 1 {
+		north := Decode(neighbors.North)
+		south := Decode(neighbors.South)
+		east := Decode(neighbors.East)
+		west := Decode(neighbors.West)
+		if north.Latitude.Max < maxLat || south.Latitude.Min > minLat ||
+			east.Longitude.Max < maxLong || west.Longitude.Min > minLong {
+			steps--
+			hash = Encode(s.Longitude, s.Latitude, steps)
+			neighbors = GetNeighbors(hash)
+			area = Decode(hash)
+		}
+	}
+
+	// 排除不可能包含结果的邻居
+	if steps >= 2 {
+		if area.Latitude.Min < minLat {
+			neighbors.South, neighbors.SouthWest, neighbors.SouthEast = Bits{}, Bits{}, Bits{}
+		}
+		if area.Latitude.Max > maxLat {
+			neighbors.North, neighbors.NorthEast, neighbors.NorthWest = Bits{}, Bits{}, Bits{}
+		}
+		if area.Longitude.Min < minLong {
+			neighbors.West, neighbors.SouthWest, neighbors.NorthWest = Bits{}, Bits{}, Bits{}
+		}
+		if area.Longitude.Max > maxLong {
+			neighbors.East, neighbors.SouthEast, neighbors.NorthEast = Bits{}, Bits{}, Bits{}
+		}
+	}
+
+	return []Bits{
+		hash,
+		neighbors.North, neighbors.South, neighbors.East, neighbors.West,
+		neighbors.NorthEast, neighbors.NorthWest, neighbors.SouthEast, neighbors.SouthWest,
+	}
+}
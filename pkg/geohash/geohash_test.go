@@ -0,0 +1,95 @@
+package geohash
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	// 与 Redis 中 GEOADD Sicily 13.361389 38.115556 Palermo 的分值一致
+	score := EncodeScore(13.361389, 38.115556)
+	if score != 3479099956230698 {
+		t.Errorf("unexpected score %d", score)
+	}
+	longitude, latitude := DecodeScore(score)
+	if math.Abs(longitude-13.361389) > 1e-5 || math.Abs(latitude-38.115556) > 1e-5 {
+		t.Errorf("unexpected position %f,%f", longitude, latitude)
+	}
+
+	if s := String(score); s != "sqc8b49rny0" {
+		t.Errorf("unexpected geohash %s", s)
+	}
+	if s := String(EncodeScore(15.087269, 37.502669)); s != "sqdtr74hyu0" {
+		t.Errorf("unexpected geohash %s", s)
+	}
+
+	if Valid(181, 0) || Valid(0, 86) || !Valid(-180, LatMin) {
+		t.Error("unexpected validation result")
+	}
+}
+
+func TestDistance(t *testing.T) {
+	dist := Distance(13.361389, 38.115556, 15.087269, 37.502669)
+	if math.Abs(dist-166274.15) > 1 {
+		t.Errorf("unexpected distance %f", dist)
+	}
+	if Distance(1, 2, 1, 2) != 0 {
+		t.Error("distance to itself should be 0")
+	}
+}
+
+func TestNeighbors(t *testing.T) {
+	hash := Encode(13.361389, 38.115556, 10)
+	center := Decode(hash)
+	n := GetNeighbors(hash)
+
+	east := Decode(n.East)
+	if east.Longitude.Min != center.Longitude.Max || east.Latitude != center.Latitude {
+		t.Errorf("east neighbor is not adjacent: %+v %+v", center, east)
+	}
+	north := Decode(n.North)
+	if north.Latitude.Min != center.Latitude.Max || north.Longitude != center.Longitude {
+		t.Errorf("north neighbor is not adjacent: %+v %+v", center, north)
+	}
+	southWest := Decode(n.SouthWest)
+	if southWest.Latitude.Max != center.Latitude.Min || southWest.Longitude.Max != center.Longitude.Min {
+		t.Errorf("south west neighbor is not adjacent: %+v %+v", center, southWest)
+	}
+}
+
+func TestSearchAreas(t *testing.T) {
+	shape := &Shape{Longitude: 15, Latitude: 37, Radius: 200000}
+	areas := shape.SearchAreas()
+	if len(areas) != 9 {
+		t.Fatalf("expected 9 areas, got %d", len(areas))
+	}
+
+	// 搜索范围内的点必须落在某个区域的分值区间内
+	for _, p := range [][2]float64{{13.361389, 38.115556}, {15.087269, 37.502669}, {16.5, 36}} {
+		if _, ok := shape.Contains(p[0], p[1]); !ok {
+			continue
+		}
+		score := EncodeScore(p[0], p[1])
+		covered := false
+		for _, area := range areas {
+			if area.IsZero() {
+				continue
+			}
+			min, max := area.ScoreRange()
+			if score >= min && score < max {
+				covered = true
+			}
+		}
+		if !covered {
+			t.Errorf("%v is not covered", p)
+		}
+	}
+
+	box := &Shape{Longitude: 15, Latitude: 37, IsBox: true, Width: 400000, Height: 400000}
+	if _, ok := box.Contains(17.241510, 38.788135); !ok {
+		t.Error("point should be inside the box")
+	}
+	if _, ok := box.Contains(17.241510, 39); ok {
+		t.Error("point should be outside the box")
+	}
+}
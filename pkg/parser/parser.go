@@ -7,6 +7,12 @@ import (
 	"strconv"
 )
 
+// 长度上限与 Redis 的 proto-max-bulk-len 和 multibulk 限制一致，防止损坏的数据导致巨量分配
+const (
+	maxBulkLen  = 512 * 1024 * 1024
+	maxArrayLen = 1024 * 1024
+)
+
 type Parser struct {
 	r *bufio.Reader
 }
@@ -78,7 +84,7 @@ func (p *Parser) parseBulkString() ([]byte, error) {
 	}
 
 	length, err := strconv.Atoi(line)
-	if err != nil {
+	if err != nil || length < -1 || length > maxBulkLen {
 		return nil, errors.New("protocol error: invalid bulk length")
 	}
 
@@ -108,7 +114,7 @@ func (p *Parser) parseArray() ([]interface{}, error) {
 	}
 
 	n, err := strconv.Atoi(line)
-	if err != nil {
+	if err != nil || n < -1 || n > maxArrayLen {
 		return nil, errors.New("protocol error: invalid array length")
 	}
 
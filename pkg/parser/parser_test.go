@@ -36,6 +36,9 @@ func TestParser_RESP_AllInOne(t *testing.T) {
 		{name: "UnknownType", input: "?what\r\n", err: errors.New("protocol error: unknown RESP type")},
 		{name: "InvalidBulkLen", input: "$abc\r\n", err: errors.New("protocol error: invalid bulk length")},
 		{name: "InvalidArrayLen", input: "*xyz\r\n", err: errors.New("protocol error: invalid array length")},
+		{name: "NegativeBulkLen", input: "$-5\r\n", err: errors.New("protocol error: invalid bulk length")},
+		{name: "HugeBulkLen", input: "$99999999999\r\n", err: errors.New("protocol error: invalid bulk length")},
+		{name: "HugeArrayLen", input: "*99999999999\r\n", err: errors.New("protocol error: invalid array length")},
 		{name: "MissingCRLF", input: "+OK\n", err: errors.New("protocol error: invalid line ending"), want: []byte(nil)},
 	}
 
@@ -0,0 +1,134 @@
+package wildcard
+
+// Match 判断 str 是否匹配 Redis 风格的 glob pattern，语义与 Redis 的 stringmatchlen 一致：
+//   - '*' 匹配任意长度（包括空）的字符序列
+//   - '?' 匹配任意单个字符
+//   - [abc] 匹配括号内任意一个字符，支持 [^abc] 取反和 [a-z] 范围
+//   - \x 转义，匹配字符 x 本身
+func Match(pattern, str string) bool {
+	return match([]byte(pattern), []byte(str), false)
+}
+
+// MatchNoCase 忽略大小写匹配
+func MatchNoCase(pattern, str string) bool {
+	return match([]byte(pattern), []byte(str), true)
+}
+
+func match(pattern, str []byte, nocase bool) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			// 合并连续的 *
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if match(pattern[1:], str[i:], nocase) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			var matched bool
+			pattern, matched = matchClass(pattern[1:], str[0], nocase)
+			if !matched {
+				return false
+			}
+			str = str[1:]
+			// matchClass 返回时 pattern 指向 ']'，由下面统一前进
+			if len(pattern) == 0 {
+				return len(str) == 0
+			}
+
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+
+		default:
+			if len(str) == 0 || !equalByte(pattern[0], str[0], nocase) {
+				return false
+			}
+			str = str[1:]
+		}
+
+		pattern = pattern[1:]
+		if len(str) == 0 {
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			break
+		}
+	}
+	return len(pattern) == 0 && len(str) == 0
+}
+
+// matchClass 处理 [...]，pattern 从 '[' 之后开始，返回指向 ']' 的剩余 pattern
+func matchClass(pattern []byte, c byte, nocase bool) ([]byte, bool) {
+	not := len(pattern) > 0 && pattern[0] == '^'
+	if not {
+		pattern = pattern[1:]
+	}
+
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) >= 2:
+			pattern = pattern[1:]
+			if equalByte(pattern[0], c, nocase) {
+				matched = true
+			}
+		case len(pattern) >= 3 && pattern[1] == '-':
+			start, end := pattern[0], pattern[2]
+			if start > end {
+				start, end = end, start
+			}
+			lc := c
+			if nocase {
+				start, end, lc = toLower(start), toLower(end), toLower(c)
+			}
+			if lc >= start && lc <= end {
+				matched = true
+			}
+			pattern = pattern[2:]
+		default:
+			if equalByte(pattern[0], c, nocase) {
+				matched = true
+			}
+		}
+		pattern = pattern[1:]
+	}
+
+	if not {
+		matched = !matched
+	}
+	return pattern, matched
+}
+
+func equalByte(a, b byte, nocase bool) bool {
+	if nocase {
+		return toLower(a) == toLower(b)
+	}
+	return a == b
+}
+
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
@@ -0,0 +1,57 @@
+package wildcard
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str     string
+		want    bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "hllo", true},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hellox", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h[b-a]llo", "hallo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"[\\]]", "]", true},
+		{"news.*", "news.tech", true},
+		{"news.*", "news", false},
+		{"*.tech", "news.tech", true},
+		{"a**b", "axxb", true},
+		{"user:*:name", "user:42:name", true},
+		{"user:*:name", "user:42:age", false},
+		{"", "", true},
+		{"", "a", false},
+		{"abc", "abc", true},
+		{"abc", "ABC", false},
+		{"[abc", "a", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.pattern+"_"+tc.str, func(t *testing.T) {
+			if got := Match(tc.pattern, tc.str); got != tc.want {
+				t.Errorf("Match(%q, %q) = %v, want %v", tc.pattern, tc.str, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMatchNoCase(t *testing.T) {
+	if !MatchNoCase("ABC*", "abcdef") {
+		t.Error("expected case-insensitive match")
+	}
+	if !MatchNoCase("[A-C]x", "bX") {
+		t.Error("expected case-insensitive range match")
+	}
+}